@@ -0,0 +1,379 @@
+// Command chatctl is the offline maintenance tool for a chat server's data.
+//
+// Subcommands
+// -----------
+//
+//	migrate -from <backend> -to <backend>
+//	    copy users and messages between store backends, then verify counts
+//	    and checksums.  A backend is written as kind:location, e.g.
+//	    json:./data.  Only the json backend is built in; sqlite and postgres
+//	    are reserved names for when those stores exist.
+//
+//	reactivate [-data <dir>] <username>
+//	    let an account deactivated for inactivity log in again.
+//
+//	unlock [-data <dir>] <username>
+//	    let an account locked after failed logins log in again.
+//
+//	set-role [-data <dir>] <username> <role>
+//	    make an account a member, moderator or admin.
+//
+//	set-email [-data <dir>] <username> <address>
+//	    set (or, with "", clear) where inactivity warnings and unlock codes
+//	    are mailed.
+//
+//	set-ntfy [-data <dir>] <username> <topic>
+//	    set (or, with "", clear) the ntfy topic unlock codes are sent to.
+//
+//	export [-data <dir>] [-o <file>] <username>
+//	    write everything stored about an account (profile, messages, direct
+//	    messages, preferences) as JSON, for a data access request.
+//
+//	delete-user [-data <dir>] [-purge] <username>
+//	    delete an account and its scheduled messages; with -purge, also
+//	    every message it posted and every direct message it was part of.
+//
+//	partitions [-data <dir>]
+//	    list the days of the message archive, with their message counts.
+//
+//	prune [-data <dir>] [-archive <dir>] -before <day>
+//	    take the days before <day> (2024-06-01) out of the message archive,
+//	    deleting their files or, with -archive, moving them there.
+//
+// Run chatctl only while the server is stopped: the JSON store keeps its
+// state in memory and would overwrite the copy on its next save.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"chat/internal/store"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "migrate":
+		migrate(os.Args[2:])
+	case "reactivate":
+		reactivate(os.Args[2:])
+	case "unlock":
+		unlock(os.Args[2:])
+	case "set-role":
+		setRole(os.Args[2:])
+	case "set-email":
+		setEmail(os.Args[2:])
+	case "set-ntfy":
+		setNtfy(os.Args[2:])
+	case "export":
+		export(os.Args[2:])
+	case "delete-user":
+		deleteUser(os.Args[2:])
+	case "partitions":
+		partitions(os.Args[2:])
+	case "prune":
+		prune(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "chatctl: unknown command %q\n", os.Args[1])
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chatctl migrate -from json:<dir> -to json:<dir>")
+	fmt.Fprintln(os.Stderr, "       chatctl reactivate [-data <dir>] <username>")
+	fmt.Fprintln(os.Stderr, "       chatctl unlock [-data <dir>] <username>")
+	fmt.Fprintln(os.Stderr, "       chatctl set-role [-data <dir>] <username> member|moderator|admin")
+	fmt.Fprintln(os.Stderr, "       chatctl set-email [-data <dir>] <username> <address>")
+	fmt.Fprintln(os.Stderr, "       chatctl set-ntfy [-data <dir>] <username> <topic>")
+	fmt.Fprintln(os.Stderr, "       chatctl export [-data <dir>] [-o <file>] <username>")
+	fmt.Fprintln(os.Stderr, "       chatctl delete-user [-data <dir>] [-purge] <username>")
+	fmt.Fprintln(os.Stderr, "       chatctl partitions [-data <dir>]")
+	fmt.Fprintln(os.Stderr, "       chatctl prune [-data <dir>] [-archive <dir>] -before <day>")
+	os.Exit(2)
+}
+
+func reactivate(args []string) {
+	fs := flag.NewFlagSet("reactivate", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	if err := st.ReactivateUser(fs.Arg(0)); err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	st.Audit(store.AuditEntry{Actor: "chatctl", Action: "reactivate", Target: fs.Arg(0)})
+	log.Printf("reactivated %s", fs.Arg(0))
+}
+
+func setRole(args []string) {
+	fs := flag.NewFlagSet("set-role", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	if err := st.SetRole(fs.Arg(0), fs.Arg(1)); err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	st.Audit(store.AuditEntry{Actor: "chatctl", Action: "set_role", Target: fs.Arg(0), Detail: fs.Arg(1)})
+	log.Printf("%s is now a %s", fs.Arg(0), fs.Arg(1))
+}
+
+func setEmail(args []string) {
+	fs := flag.NewFlagSet("set-email", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	if err := st.SetEmail(fs.Arg(0), fs.Arg(1)); err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	log.Printf("set the email of %s to %q", fs.Arg(0), fs.Arg(1))
+}
+
+func unlock(args []string) {
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	u := st.GetUser(fs.Arg(0))
+	if u == nil {
+		log.Fatalf("chatctl: user %q not found", fs.Arg(0))
+	}
+	if err := st.UnlockUser(u.ID); err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	st.Audit(store.AuditEntry{Actor: "chatctl", Action: "unlock", Target: u.Username})
+	log.Printf("unlocked %s", u.Username)
+}
+
+func setNtfy(args []string) {
+	fs := flag.NewFlagSet("set-ntfy", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	if err := st.SetNtfy(fs.Arg(0), fs.Arg(1)); err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	log.Printf("set the ntfy topic of %s to %q", fs.Arg(0), fs.Arg(1))
+}
+
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	out := fs.String("o", "", "file to write (default: standard output)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	x, err := st.ExportUser(fs.Arg(0), func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\rchatctl: %d/%d messages", done, total)
+	})
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	fmt.Fprintln(os.Stderr)
+	b, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	b = append(b, '\n')
+	if *out == "" {
+		os.Stdout.Write(b)
+	} else if err := os.WriteFile(*out, b, 0o600); err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	st.Audit(store.AuditEntry{Actor: "chatctl", Action: "data_export", Target: x.Profile.Username})
+	log.Printf("exported %s: %d messages, %d direct messages", x.Profile.Username, len(x.Messages), len(x.DirectMessages))
+}
+
+func deleteUser(args []string) {
+	fs := flag.NewFlagSet("delete-user", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	purge := fs.Bool("purge", false, "also delete the account's messages")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	purged := 0
+	err = st.Update(func(tx *store.Tx) error {
+		u := tx.GetUser(fs.Arg(0))
+		if u == nil {
+			return fmt.Errorf("user %q not found", fs.Arg(0))
+		}
+		if *purge {
+			purged = tx.PurgeMessages(u.ID)
+		}
+		return tx.DeleteUser(u.ID)
+	})
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	detail := ""
+	if *purge {
+		detail = fmt.Sprintf("purged %d message(s)", purged)
+	}
+	st.Audit(store.AuditEntry{Actor: "chatctl", Action: "delete_user", Target: fs.Arg(0), Detail: detail})
+	log.Printf("deleted %s (%d message(s) purged)", fs.Arg(0), purged)
+}
+
+func partitions(args []string) {
+	fs := flag.NewFlagSet("partitions", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	days, total := st.Partitions(), 0
+	for _, p := range days {
+		fmt.Printf("%s  %7d message(s)  %s – %s\n", p.Day, p.Messages, p.First.UTC().Format("15:04:05"), p.Last.UTC().Format("15:04:05"))
+		total += p.Messages
+	}
+	log.Printf("%d day(s), %d message(s)", len(days), total)
+}
+
+func prune(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	data := fs.String("data", "./data", "server data directory")
+	archive := fs.String("archive", "", "move the pruned day files to this directory instead of deleting them")
+	before := fs.String("before", "", "prune the days before this one, e.g. 2024-06-01")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *before == "" {
+		usage()
+	}
+	st, err := store.New(*data)
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	gone, err := st.PruneDays(*before, *archive)
+	n := 0
+	for _, p := range gone {
+		n += p.Messages
+	}
+	if len(gone) > 0 {
+		detail := fmt.Sprintf("%d day(s) before %s, %d message(s)", len(gone), *before, n)
+		if *archive != "" {
+			detail += ", moved to " + *archive
+		}
+		st.Audit(store.AuditEntry{Actor: "chatctl", Action: "prune_messages", Detail: detail})
+	}
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+	log.Printf("pruned %d day(s), %d message(s)", len(gone), n)
+}
+
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "source backend, e.g. json:./data")
+	to := fs.String("to", "", "destination backend, e.g. json:./data-new")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	src, err := openBackend(*from)
+	if err != nil {
+		log.Fatalf("chatctl: source: %v", err)
+	}
+	dst, err := openBackend(*to)
+	if err != nil {
+		log.Fatalf("chatctl: destination: %v", err)
+	}
+
+	start := time.Now()
+	users, msgs := src.Users(), src.Messages()
+	log.Printf("read %d user(s) and %d message(s) from %s", len(users), len(msgs), *from)
+
+	err = dst.Import(users, msgs, func(kind string, done, total int) {
+		log.Printf("  %-8s %d/%d", kind, done, total)
+	})
+	if err != nil {
+		log.Fatalf("chatctl: %v", err)
+	}
+
+	// Verify by reopening the destination from disk rather than trusting
+	// the in-memory copy.
+	check, err := openBackend(*to)
+	if err != nil {
+		log.Fatalf("chatctl: verify: %v", err)
+	}
+	gotUsers, gotMsgs := check.Users(), check.Messages()
+	if len(gotUsers) != len(users) || len(gotMsgs) != len(msgs) {
+		log.Fatalf("chatctl: verify: count mismatch: wrote %d/%d user(s)/message(s), read back %d/%d",
+			len(users), len(msgs), len(gotUsers), len(gotMsgs))
+	}
+	want, got := store.Checksum(users, msgs), store.Checksum(gotUsers, gotMsgs)
+	if want != got {
+		log.Fatalf("chatctl: verify: checksum mismatch: source %s, destination %s", want, got)
+	}
+	log.Printf("verified %d user(s), %d message(s), sha256 %s (%v)",
+		len(gotUsers), len(gotMsgs), got[:16], time.Since(start).Round(time.Millisecond))
+}
+
+// openBackend opens a store from a kind:location spec.  A bare path means
+// json.
+func openBackend(spec string) (*store.Store, error) {
+	kind, loc, ok := strings.Cut(spec, ":")
+	if !ok {
+		kind, loc = "json", spec
+	}
+	switch kind {
+	case "json":
+		return store.New(loc)
+	case "sqlite", "postgres":
+		return nil, fmt.Errorf("%s backend is not available in this build (only json)", kind)
+	}
+	return nil, fmt.Errorf("unknown backend %q (want json)", kind)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Bulk moderation
+// ---------------------------------------------------------------------------
+//
+// /bulk asks the server (FeatureBulk) what an operation would affect; the
+// server answers with a count and a confirmation token, and /bulk confirm
+// sends the same request again with the token.  Only the latest preview
+// can be confirmed.
+
+func cmdBulk(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["bulk"].usage)
+		return m, nil
+	}
+	var p protocol.BulkPayload
+	var err error
+	switch args[0] {
+	case "confirm":
+		if m.bulkPending == nil || m.bulkPending.Confirm == "" {
+			m.warn("nothing to confirm — preview an operation with /bulk first")
+			return m, nil
+		}
+		sendPkt(m.conn, protocol.TypeBulk, *m.bulkPending)
+		m.bulkPending = nil
+		m.waitBulk = true
+		return m, nil
+	case "delete":
+		if len(args) < 2 {
+			m.warn("usage: /bulk delete <user> [since [until]]")
+			return m, nil
+		}
+		p = protocol.BulkPayload{Op: protocol.BulkDeleteMessages, User: strings.TrimPrefix(args[1], "@")}
+		p.Since, p.Until, err = parseSpan(time.Now(), args[2:])
+	case "purge":
+		p = protocol.BulkPayload{Op: protocol.BulkPurgeChannel, Channel: m.channel}
+		if len(args) > 1 {
+			p.Channel = args[1]
+		}
+	case "unban":
+		p = protocol.BulkPayload{Op: protocol.BulkUnban}
+		p.Since, p.Until, err = parseSpan(time.Now(), args[1:])
+	default:
+		m.warn("usage: " + commands["bulk"].usage)
+		return m, nil
+	}
+	if err != nil {
+		m.warn(err.Error())
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeBulk, p)
+	m.bulkPending = &p
+	m.waitBulk = true
+	return m, nil
+}
+
+// parseSpan parses the optional since and until arguments of /bulk.  Each
+// is a duration meaning that long ago (24h), a date (2006-01-02) or a
+// local time (2006-01-02T15:04).
+func parseSpan(now time.Time, args []string) (since, until time.Time, err error) {
+	if len(args) > 2 {
+		return since, until, fmt.Errorf("too many arguments: give at most since and until")
+	}
+	parse := func(s string) (time.Time, error) {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			return now.Add(-d), nil
+		}
+		for _, layout := range []string{"2006-01-02", "2006-01-02T15:04"} {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("can't parse time %q — use 24h, 2006-01-02 or 2006-01-02T15:04", s)
+	}
+	if len(args) > 0 {
+		if since, err = parse(args[0]); err != nil {
+			return
+		}
+	}
+	if len(args) > 1 {
+		until, err = parse(args[1])
+	}
+	return
+}
+
+// bulkAnswered handles the response to a /bulk request: a preview to
+// confirm, or the outcome.
+func (m *model) bulkAnswered(r protocol.ResponsePayload) {
+	var pv protocol.BulkPreview
+	if json.Unmarshal(r.Data, &pv) != nil || pv.Token == "" || m.bulkPending == nil {
+		m.bulkPending = nil
+		m.appendChat(successStyle.Render("✓ " + r.Message))
+		return
+	}
+	m.bulkPending.Confirm = pv.Token
+	m.appendChat(successStyle.Render(r.Message + " — /bulk confirm to go ahead"))
+}
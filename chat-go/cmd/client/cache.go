@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message cache
+// ---------------------------------------------------------------------------
+//
+// The client keeps the newest cacheSize messages of each conversation in a
+// file per server and user in the config directory.  At login, and when a
+// conversation is first opened, its cached messages are shown at once and
+// only what was posted after the last of them is fetched: an open-ended
+// sequence gap (see seq.go) that live messages close.  Servers without
+// FeatureSeq get the usual history request.
+//
+// The file is written every cacheSaveEvery new messages, when the
+// connection ends and when the client exits.  It holds what the user could
+// already read, so it is private to the user like the notifications file.
+
+const (
+	cacheSize      = 200 // messages kept per conversation
+	cacheConvs     = 50  // conversations kept, most recently active first
+	cacheSaveEvery = 100 // new messages between writes
+)
+
+// msgCache is the cache of one server and user.  The model holds a pointer
+// so that every copy of it adds to the same cache.
+type msgCache struct {
+	path  string
+	convs map[string][]protocol.BroadcastPayload // by channel, in Seq order
+	added int                                    // messages since the last save
+}
+
+// cachePath is where the cache for user on the server at addr lives, or ""
+// when there is no config directory.
+func cachePath(addr, user string) string {
+	p := noticesPath(addr, user)
+	if p == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(filepath.Dir(p)), "cache", filepath.Base(p))
+}
+
+// loadCache reads the cache at path.  A missing file is an empty cache;
+// so is an unreadable one, which is reported.
+func loadCache(path string) (*msgCache, error) {
+	c := &msgCache{path: path, convs: make(map[string][]protocol.BroadcastPayload)}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &c.convs)
+	}
+	if err != nil {
+		c.convs = make(map[string][]protocol.BroadcastPayload)
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// add records b.  Messages without a sequence number cannot be resumed
+// from and are not kept.
+func (c *msgCache) add(b protocol.BroadcastPayload) {
+	if c == nil || b.Seq == 0 {
+		return
+	}
+	list := c.convs[b.Channel]
+	i, found := slices.BinarySearchFunc(list, b.Seq, func(m protocol.BroadcastPayload, seq uint64) int {
+		return cmp.Compare(m.Seq, seq)
+	})
+	if found {
+		list[i] = b
+		return
+	}
+	if i == 0 && len(list) >= cacheSize {
+		return // older than everything kept
+	}
+	list = slices.Insert(list, i, b)
+	if len(list) > cacheSize {
+		list = slices.Delete(list, 0, len(list)-cacheSize)
+	}
+	c.convs[b.Channel] = list
+	c.added++
+}
+
+// remove forgets the message of channel with the given ID.
+func (c *msgCache) remove(channel, id string) {
+	if c == nil {
+		return
+	}
+	c.convs[channel] = slices.DeleteFunc(c.convs[channel], func(m protocol.BroadcastPayload) bool { return m.ID == id })
+}
+
+// messages returns the cached messages of channel, oldest first.
+func (c *msgCache) messages(channel string) []protocol.BroadcastPayload {
+	if c == nil {
+		return nil
+	}
+	return c.convs[channel]
+}
+
+// save writes the cache, keeping the cacheConvs most recently active
+// conversations.
+func (c *msgCache) save() error {
+	if c == nil || c.path == "" {
+		return nil
+	}
+	c.added = 0
+	if len(c.convs) > cacheConvs {
+		chans := make([]string, 0, len(c.convs))
+		for ch := range c.convs {
+			chans = append(chans, ch)
+		}
+		latest := func(ch string) protocol.BroadcastPayload { l := c.convs[ch]; return l[len(l)-1] }
+		slices.SortFunc(chans, func(a, b string) int { return latest(b).Timestamp.Compare(latest(a).Timestamp) })
+		for _, ch := range chans[cacheConvs:] {
+			delete(c.convs, ch)
+		}
+	}
+	data, err := json.Marshal(c.convs)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.path), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(c.path, data, 0o600)
+	}
+	return err
+}
+
+// cacheMessage adds b to the cache, saving it now and then.
+func (m *model) cacheMessage(b protocol.BroadcastPayload) {
+	m.cache.add(b)
+	if m.cache != nil && m.cache.added >= cacheSaveEvery {
+		m.saveCache()
+	}
+}
+
+// saveCache writes the cache.  A failure is reported but is otherwise
+// harmless.
+func (m *model) saveCache() {
+	if err := m.cache.save(); err != nil {
+		m.fail("saving the message cache: " + err.Error())
+	}
+}
+
+// restoreCached shows the cached messages of the conversation on screen
+// and asks the server only for newer ones.  It reports false, leaving the
+// history to be fetched as usual, when there is nothing to resume from.
+func (m *model) restoreCached() bool {
+	msgs := m.cache.messages(m.channel)
+	if len(msgs) == 0 || !m.supports(protocol.FeatureSeq) || !m.supports(protocol.FeatureBatch) {
+		return false
+	}
+	lines := make([]chatLine, len(msgs))
+	for i, b := range msgs {
+		m.remember(b)
+		lines[i] = m.messageLine(b)
+	}
+	m.scroll.insert(0, lines)
+	m.oldestID, m.hasOlder = msgs[0].ID, msgs[0].Seq > 1
+
+	last := msgs[len(msgs)-1].Seq
+	m.seqs[m.channel] = max(m.seqs[m.channel], last)
+	g := seqGap{channel: m.channel, after: last, resume: true}
+	m.gaps = append(m.gaps, g)
+	m.requestGap(g)
+	m.refreshChat()
+	m.viewport.GotoBottom()
+	return true
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Public channels
+// ---------------------------------------------------------------------------
+//
+// On servers with FeatureChannels, Ctrl+L (or /channels) opens a browser of
+// the public channels, most active first, where Enter joins or opens one.
+// Ctrl+L inside the browser shows or hides the conversation list instead.
+// Joined channels are conversations like DMs: they appear in the list and
+// Tab cycles through them.
+//
+// With FeatureChannelMode, a channel's creator or a moderator can make it an
+// announcement channel with /readonly.  Everyone else sees it with a 🔒
+// and can only run commands there, not type messages.
+//
+// With FeatureArchive, admins /archive a channel and /restore it.  An
+// archived channel is marked 🗄 and is read-only for everyone; only admins
+// still find it in the browser.
+
+// channelListSkip is the number of browser rows above the list: header,
+// blank line, key hints and divider.
+const channelListSkip = 4
+
+// openChannelBrowser shows the browser and asks for a fresh list.
+func (m model) openChannelBrowser() (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeChannelList, map[string]string{})
+	m.waitChannelList = true
+	m.channelList = nil
+	m.channelSel = 0
+	m.state = stateChannels
+	m.chatInput.Blur()
+	return m, nil
+}
+
+func (m model) handleChannelsKey(msg tea.KeyMsg) (model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m.requestQuit()
+
+	case tea.KeyEsc:
+		m.state = stateChat
+		m.chatInput.Focus()
+		return m, nil
+
+	case tea.KeyCtrlL:
+		m.state = stateChat
+		m.chatInput.Focus()
+		m.showConvs = !m.showConvs
+		m.resize(m.width, m.height)
+		return m, nil
+
+	case tea.KeyUp:
+		if m.channelSel > 0 {
+			m.channelSel--
+		}
+		return m, nil
+
+	case tea.KeyDown:
+		if m.channelSel < len(m.channelList)-1 {
+			m.channelSel++
+		}
+		return m, nil
+
+	case tea.KeyEnter:
+		if m.channelSel >= len(m.channelList) {
+			return m, nil
+		}
+		info := m.channelList[m.channelSel]
+		m.state = stateChat
+		m.chatInput.Focus()
+		if info.Joined {
+			return m.openConversation(info.Channel)
+		}
+		return m.joinChannel(info.Channel)
+	}
+	return m, nil
+}
+
+func (m model) joinChannel(ch string) (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeJoin, protocol.ChannelPayload{Channel: ch})
+	m.waitJoin = true
+	return m, nil
+}
+
+// joined opens a channel the server has just let us into.
+func (m model) joined(info protocol.ChannelInfo) model {
+	m.setConversations([]protocol.ConversationInfo{{Channel: info.Channel, LastAt: info.LastAt, ReadOnly: info.ReadOnly, Archived: info.Archived}})
+	m, _ = m.openConversation(info.Channel)
+	if info.Topic != "" {
+		m.appendChat(hintStyle.Render("topic: " + info.Topic))
+	}
+	return m
+}
+
+// channelMode records a channel's new mode, sent by the server when it
+// changes.
+func (m model) channelMode(info protocol.ChannelInfo) model {
+	if cv, ok := m.convs[info.Channel]; ok {
+		cv.readOnly, cv.archived = info.ReadOnly, info.Archived
+	}
+	return m
+}
+
+// readOnly reports whether the conversation on screen refuses our messages.
+func (m model) readOnly() bool {
+	cv, ok := m.convs[m.channel]
+	return ok && cv.readOnly
+}
+
+// archived reports whether the conversation on screen is an archived
+// channel.
+func (m model) archived() bool {
+	cv, ok := m.convs[m.channel]
+	return ok && cv.archived
+}
+
+// left forgets a channel after /leave, returning to the main channel when
+// it was on screen.
+func (m model) left(ch string) model {
+	if m.channel == ch {
+		m, _ = m.openConversation(protocol.MainChannel)
+	}
+	delete(m.convs, ch)
+	delete(m.seqs, ch)
+	return m
+}
+
+func cmdChannels(m model, _ []string) (model, tea.Cmd) {
+	return m.openChannelBrowser()
+}
+
+func cmdJoin(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 1 {
+		m.warn("usage: " + commands["join"].usage)
+		return m, nil
+	}
+	ch := protocol.PublicChannel(args[0])
+	if ch == "" {
+		m.warn(fmt.Sprintf("%q is not a channel name (up to %d of a-z, 0-9, - and _)", args[0], protocol.MaxChannelName))
+		return m, nil
+	}
+	if _, ok := m.convs[ch]; ok {
+		return m.openConversation(ch)
+	}
+	return m.joinChannel(ch)
+}
+
+func cmdLeave(m model, args []string) (model, tea.Cmd) {
+	ch := m.channel
+	if len(args) > 0 {
+		ch = protocol.PublicChannel(args[0])
+	}
+	if !protocol.IsPublic(ch) {
+		m.warn("usage: " + commands["leave"].usage + " (not the main channel or a DM)")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeLeave, protocol.ChannelPayload{Channel: ch})
+	m.waitLeave = ch
+	return m, nil
+}
+
+func cmdTopic(m model, args []string) (model, tea.Cmd) {
+	if !protocol.IsPublic(m.channel) {
+		m.warn("/topic works in a public channel; /join one first")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeTopic, protocol.ChannelPayload{Channel: m.channel, Topic: strings.Join(args, " ")})
+	return m, nil
+}
+
+func cmdReadOnly(m model, args []string) (model, tea.Cmd) {
+	if !protocol.IsPublic(m.channel) {
+		m.warn("/readonly works in a public channel; /join one first")
+		return m, nil
+	}
+	on := true
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "on":
+		case "off":
+			on = false
+		default:
+			m.warn("usage: " + commands["readonly"].usage)
+			return m, nil
+		}
+	}
+	sendPkt(m.conn, protocol.TypeChannelMode, protocol.ChannelPayload{Channel: m.channel, Announce: on})
+	return m, nil
+}
+
+func cmdArchive(m model, args []string) (model, tea.Cmd) { return m.archive(args, true) }
+func cmdRestore(m model, args []string) (model, tea.Cmd) { return m.archive(args, false) }
+
+// archive asks to archive or restore the channel named in args, or the one
+// on screen.
+func (m model) archive(args []string, on bool) (model, tea.Cmd) {
+	name := "archive"
+	if !on {
+		name = "restore"
+	}
+	ch := m.channel
+	if len(args) > 0 {
+		ch = protocol.PublicChannel(args[0])
+	}
+	if len(args) > 1 || !protocol.IsPublic(ch) {
+		m.warn("usage: " + commands[name].usage + " (not the main channel or a DM)")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeArchive, protocol.ChannelPayload{Channel: ch, Archived: on})
+	return m, nil
+}
+
+func (m model) viewChannels() string {
+	if m.width == 0 {
+		return "\n  Loading…"
+	}
+
+	hdr := searchHeaderStyle.
+		Width(m.width).
+		Render(fmt.Sprintf(" Channels  ·  %d public  ·  Esc: return to chat  Ctrl+C: quit", len(m.channelList)))
+	keys := hintStyle.Render("  ↑/↓: select   Enter: join or open   Ctrl+L: conversation list   /join #name: create")
+	div := divStyle.Render(strings.Repeat("─", m.width))
+
+	switch {
+	case m.waitChannelList:
+		return strings.Join([]string{hdr, "", keys, div, hintStyle.Render("  Loading…")}, "\n")
+	case len(m.channelList) == 0:
+		return strings.Join([]string{hdr, "", keys, div, hintStyle.Render("  (no public channels yet)")}, "\n")
+	}
+
+	rows := max(m.height-channelListSkip, 1)
+	first := 0
+	if m.channelSel >= rows {
+		first = m.channelSel - rows + 1
+	}
+	width := 0
+	for _, info := range m.channelList {
+		width = max(width, len(channelLabel(info.Channel)))
+	}
+	lines := []string{hdr, "", keys, div}
+	for k := first; k < len(m.channelList) && k < first+rows; k++ {
+		info := m.channelList[k]
+		mark := "  "
+		if info.Joined {
+			mark = successStyle.Render("✓ ")
+		}
+		active := "no messages yet"
+		if !info.LastAt.IsZero() {
+			active = "active " + info.LastAt.Local().Format("2006-01-02 15:04")
+		}
+		lock := "  "
+		switch {
+		case info.Archived:
+			lock = "🗄"
+		case info.ReadOnly:
+			lock = "🔒"
+		}
+		line := fmt.Sprintf("%s%-*s %s %4d member(s)  %s", mark, width, channelLabel(info.Channel), lock, info.Members, tsStyle.Render(active))
+		if info.Topic != "" {
+			line += "  " + hintStyle.Render(info.Topic)
+		}
+		if k == m.channelSel {
+			line = myNameStyle.Render("▸ ") + line
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
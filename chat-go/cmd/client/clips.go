@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Voice clips
+// ---------------------------------------------------------------------------
+//
+// /clip uploads an audio file and posts it as a KindClip message with its
+// duration and codec (FeatureClips).  Those are read from the file for WAV
+// and Ogg (Opus or Vorbis), and asked of ffprobe for anything else.  /play
+// downloads a clip to a temporary file and runs the player on it: -player
+// or the profile's "player", a command in which {} stands for the file (or
+// which gets it as its last argument), or else the first of
+// defaultPlayers that is installed.  The player's output is discarded, so
+// it cannot disturb the screen.
+
+// defaultPlayers are tried in order when no player is configured.
+var defaultPlayers = []string{
+	"mpv --no-video --really-quiet",
+	"ffplay -nodisp -autoexit -loglevel quiet",
+	"afplay",
+	"paplay",
+}
+
+// playDoneMsg reports that the player exited.
+type playDoneMsg struct{ err error }
+
+func cmdClip(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["clip"].usage)
+		return m, nil
+	}
+	path, caption := args[0], strings.Join(args[1:], " ")
+	st, err := os.Stat(path)
+	if err != nil {
+		m.fail(err.Error())
+		return m, nil
+	}
+	if max := m.hello.Limits.MaxUploadSize; max > 0 && st.Size() > max {
+		m.warn(fmt.Sprintf("%s is %s; the server limit is %s",
+			filepath.Base(path), humanSize(st.Size()), humanSize(max)))
+		return m, nil
+	}
+	uploadURL, channel := m.hello.FilesURL, m.channel
+	maxClip := time.Duration(m.hello.Limits.MaxClipSeconds) * time.Second
+	m.appendChat(hintStyle.Render("uploading " + filepath.Base(path) + "…"))
+	return m.withFileToken(func(token string) tea.Cmd {
+		upload := uploadFile(uploadURL, token, path, caption, channel)
+		return func() tea.Msg {
+			clip, err := probeClip(path)
+			if err != nil {
+				return uploadDoneMsg{err: err}
+			}
+			if d := clipDuration(clip); maxClip > 0 && d > maxClip {
+				return uploadDoneMsg{err: fmt.Errorf("the clip is %s; the server limit is %s", formatClip(d), formatClip(maxClip))}
+			}
+			done := upload().(uploadDoneMsg)
+			done.clip = clip
+			return done
+		}
+	})
+}
+
+func cmdPlay(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 1 {
+		m.warn("usage: " + commands["play"].usage)
+		return m, nil
+	}
+	id, name := "", ""
+	if len(args) == 1 {
+		id, name = args[0], args[0]
+	} else {
+		for i := len(m.recent) - 1; i >= 0; i-- {
+			if b := m.recent[i]; b.Kind == protocol.KindClip && b.Attachment != nil && b.Channel == m.channel {
+				id, name = b.Attachment.ID, b.Attachment.Name
+				break
+			}
+		}
+	}
+	if id == "" {
+		m.warn("no voice clip here to play")
+		return m, nil
+	}
+	player, err := m.playerCommand()
+	if err != nil {
+		m.warn(err.Error())
+		return m, nil
+	}
+	fileURL := m.hello.FilesURL + "/" + url.PathEscape(id)
+	m.appendChat(hintStyle.Render("▶ playing " + name + "…"))
+	return m.withFileToken(func(token string) tea.Cmd {
+		return playClip(fileURL, token, name, player)
+	})
+}
+
+// playerCommand is the configured player, or the first installed default.
+func (m model) playerCommand() ([]string, error) {
+	if m.player != "" {
+		return strings.Fields(m.player), nil
+	}
+	for _, p := range defaultPlayers {
+		argv := strings.Fields(p)
+		if _, err := exec.LookPath(argv[0]); err == nil {
+			return argv, nil
+		}
+	}
+	return nil, errors.New("no audio player found; set one with -player or the profile's \"player\"")
+}
+
+// playClip downloads the clip at fileURL to a temporary directory, plays it
+// with player and removes it again.
+func playClip(fileURL, token, name string, player []string) tea.Cmd {
+	return func() tea.Msg {
+		dir, err := os.MkdirTemp("", "gochat-clip-")
+		if err != nil {
+			return playDoneMsg{err: err}
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "clip"+filepath.Ext(name))
+		if d := downloadFile(fileURL, token, path)().(downloadDoneMsg); d.err != nil {
+			return playDoneMsg{err: d.err}
+		}
+
+		argv, found := make([]string, 0, len(player)+1), false
+		for _, a := range player {
+			if strings.Contains(a, "{}") {
+				a, found = strings.ReplaceAll(a, "{}", path), true
+			}
+			argv = append(argv, a)
+		}
+		if !found {
+			argv = append(argv, path)
+		}
+		cmd := exec.Command(argv[0], argv[1:]...)
+		return playDoneMsg{err: cmd.Run()}
+	}
+}
+
+// renderClip is the kindRenderer of protocol.KindClip.
+func renderClip(b protocol.BroadcastPayload) (string, bool) {
+	var clip protocol.ClipMeta
+	if err := json.Unmarshal(b.Meta, &clip); err != nil || b.Attachment == nil {
+		return "", false
+	}
+	body := fmt.Sprintf("🔊 voice clip %s (%s)", formatClip(clipDuration(&clip)), clip.Codec)
+	if b.Content != "" {
+		body += " " + b.Content
+	}
+	return body + " " + hintStyle.Render("/play "+b.Attachment.ID), true
+}
+
+func clipDuration(c *protocol.ClipMeta) time.Duration {
+	return time.Duration(c.DurationMS) * time.Millisecond
+}
+
+// formatClip writes d as m:ss.
+func formatClip(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// probeClip reads the duration and codec of the audio file at path.
+func probeClip(path string) (*protocol.ClipMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(f, head); err == nil {
+		switch {
+		case string(head[:4]) == "RIFF" && string(head[8:]) == "WAVE":
+			return probeWAV(f)
+		case string(head[:4]) == "OggS":
+			return probeOgg(f)
+		}
+	}
+	return probeFFprobe(path)
+}
+
+// probeWAV reads the fmt and data chunks of a RIFF WAVE file, positioned
+// after its 12-byte header.
+func probeWAV(f *os.File) (*protocol.ClipMeta, error) {
+	var format uint16
+	var byteRate uint32
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			return nil, errors.New("a WAV file without audio data")
+		}
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch string(hdr[:4]) {
+		case "fmt ":
+			var fmtChunk [16]byte
+			if size < 16 {
+				return nil, errors.New("a WAV file with a broken format chunk")
+			}
+			if _, err := io.ReadFull(f, fmtChunk[:]); err != nil {
+				return nil, err
+			}
+			format = binary.LittleEndian.Uint16(fmtChunk[0:])
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:])
+			size -= 16
+		case "data":
+			if byteRate == 0 {
+				return nil, errors.New("a WAV file with a broken format chunk")
+			}
+			codec := "pcm"
+			if format != 1 {
+				codec = "wav-" + strconv.Itoa(int(format))
+			}
+			return &protocol.ClipMeta{DurationMS: size * 1000 / int64(byteRate), Codec: codec}, nil
+		}
+		if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil { // chunks are padded to even sizes
+			return nil, err
+		}
+	}
+}
+
+// probeOgg reads the codec from the first packet of an Ogg file and the
+// duration from the granule position of its last page.
+func probeOgg(f *os.File) (*protocol.ClipMeta, error) {
+	first := make([]byte, 512)
+	n, _ := f.ReadAt(first, 0)
+	first = first[:n]
+	if len(first) < 27 || len(first) < 27+int(first[26]) {
+		return nil, errors.New("a broken Ogg file")
+	}
+	pkt := first[27+int(first[26]):]
+
+	var codec string
+	var rate, skip int64
+	switch {
+	case bytes.HasPrefix(pkt, []byte("OpusHead")) && len(pkt) >= 12:
+		codec, rate = "opus", 48000 // Opus granules always count 48 kHz samples
+		skip = int64(binary.LittleEndian.Uint16(pkt[10:]))
+	case bytes.HasPrefix(pkt, []byte("\x01vorbis")) && len(pkt) >= 16:
+		codec, rate = "vorbis", int64(binary.LittleEndian.Uint32(pkt[12:]))
+	default:
+		return nil, errors.New("an Ogg file that is neither Opus nor Vorbis")
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tail := make([]byte, min(st.Size(), 64<<10))
+	if _, err := f.ReadAt(tail, st.Size()-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) || rate == 0 {
+		return nil, errors.New("a broken Ogg file")
+	}
+	granule := int64(binary.LittleEndian.Uint64(tail[i+6:]))
+	return &protocol.ClipMeta{DurationMS: max(granule-skip, 0) * 1000 / rate, Codec: codec}, nil
+}
+
+// probeFFprobe asks ffprobe about the first audio stream of path.
+func probeFFprobe(path string) (*protocol.ClipMeta, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "format=duration:stream=codec_name", "-of", "json", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot tell how long %s is: only WAV and Ogg are read without ffprobe", filepath.Base(path))
+	}
+	var probe struct {
+		Streams []struct {
+			Codec string `json:"codec_name"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil || len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("%s has no audio", filepath.Base(path))
+	}
+	secs, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot tell how long %s is", filepath.Base(path))
+	}
+	return &protocol.ClipMeta{DurationMS: int64(secs * 1000), Codec: probe.Streams[0].Codec}, nil
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Slash commands
+// ---------------------------------------------------------------------------
+
+// command is a client-side slash command typed into the chat input.
+type command struct {
+	usage   string
+	help    string
+	feature string // server feature the command needs; "" for client-only
+	offline bool   // usable while disconnected
+	run     func(m model, args []string) (model, tea.Cmd)
+}
+
+// commands is keyed by the command name without the leading slash.  It is
+// filled in init so command bodies may refer back to the table (/help).
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help": {
+			usage:   "/help",
+			help:    "list available commands",
+			offline: true,
+			run:     cmdHelp,
+		},
+		"time": {
+			usage: "/time",
+			help:  "show server time and local clock skew",
+			run:   cmdTime,
+		},
+		"reply": {
+			usage: "/reply [@user] <message>",
+			help:  "reply to the latest message (from @user), quoting it",
+			run:   cmdReply,
+		},
+		"delete": {
+			usage:   "/delete [id]",
+			help:    "delete your latest message here, or (admins) the message with that ID",
+			feature: protocol.FeatureDelete,
+			run:     cmdDelete,
+		},
+		"seen": {
+			usage:   "/seen [id]",
+			help:    "who has read your latest message here, or the message with that ID",
+			feature: protocol.FeatureReads,
+			run:     cmdSeen,
+		},
+		"poll": {
+			usage:   "/poll <question> | <option> | <option>…",
+			help:    "start a poll",
+			feature: protocol.FeaturePolls,
+			run:     cmdPoll,
+		},
+		"vote": {
+			usage:   "/vote <poll-id> <n>",
+			help:    "vote for option n (again to change your vote)",
+			feature: protocol.FeaturePolls,
+			run:     cmdVote,
+		},
+		"closepoll": {
+			usage:   "/closepoll <poll-id>",
+			help:    "close a poll you started",
+			feature: protocol.FeaturePolls,
+			run:     cmdClosePoll,
+		},
+		"schedule": {
+			usage:   "/schedule <when> <message>",
+			help:    "send later; when is 10m, 2h30m, 17:45 or 2006-01-02T15:04",
+			feature: protocol.FeatureSchedule,
+			run:     cmdSchedule,
+		},
+		"scheduled": {
+			usage:   "/scheduled",
+			help:    "list your scheduled messages",
+			feature: protocol.FeatureSchedule,
+			run:     cmdScheduled,
+		},
+		"unschedule": {
+			usage:   "/unschedule <id>",
+			help:    "cancel a scheduled message",
+			feature: protocol.FeatureSchedule,
+			run:     cmdUnschedule,
+		},
+		"location": {
+			usage:   "/location <lat> <lon> [label]",
+			help:    "share a location",
+			feature: protocol.FeatureKinds,
+			run:     cmdLocation,
+		},
+		"upload": {
+			usage:   "/upload <path> [caption]",
+			help:    "share a file",
+			feature: protocol.FeatureAttachments,
+			run:     cmdUpload,
+		},
+		"export": {
+			usage:   "/export [user] | save [dest]",
+			help:    "get a copy of your data (admins: anyone's), then save it",
+			feature: protocol.FeatureExport,
+			run:     cmdExport,
+		},
+		"savesearch": {
+			usage: "/savesearch [file]",
+			help:  "save the last search's results as CSV (or JSON, for a .json file)",
+			run:   cmdSaveSearch,
+		},
+		"download": {
+			usage:   "/download <file-id> [dest]",
+			help:    "save an attached file",
+			feature: protocol.FeatureAttachments,
+			run:     cmdDownload,
+		},
+		"clip": {
+			usage:   "/clip <file> [caption]",
+			help:    "send an audio file as a voice clip",
+			feature: protocol.FeatureClips,
+			run:     cmdClip,
+		},
+		"play": {
+			usage:   "/play [file-id]",
+			help:    "play a voice clip, by default the latest one here (see -player)",
+			feature: protocol.FeatureClips,
+			run:     cmdPlay,
+		},
+		"maintenance": {
+			usage:   "/maintenance on [reason] | off | at <when> [duration] [reason]",
+			help:    "admins: make the server read-only",
+			feature: protocol.FeatureMaintenance,
+			run:     cmdMaintenance,
+		},
+		"announce": {
+			usage:   "/announce <message>",
+			help:    "admins: send a notice to everyone as the server",
+			feature: protocol.FeatureAnnounce,
+			run:     cmdAnnounce,
+		},
+		"bulk": {
+			usage:   "/bulk delete <user> [since [until]] | purge [#channel] | unban [since [until]] | confirm",
+			help:    "admins: delete, purge or reactivate in bulk, after a preview",
+			feature: protocol.FeatureBulk,
+			run:     cmdBulk,
+		},
+		"relay": {
+			usage:   "/relay [grant|revoke <user> <prefix>]",
+			help:    "admins: list bots' relay grants, or let a bot post as <prefix>… names",
+			feature: protocol.FeatureRelay,
+			run:     cmdRelay,
+		},
+		"kick": {
+			usage:   "/kick <user> [reason]",
+			help:    "moderators: disconnect a user, who may log in again",
+			feature: protocol.FeatureModeration,
+			run:     cmdKick,
+		},
+		"silence": {
+			usage:   "/silence <user> [duration] [reason]",
+			help:    "moderators: stop a user posting, e.g. for 30m, or until /unsilence",
+			feature: protocol.FeatureModeration,
+			run:     cmdSilence,
+		},
+		"unsilence": {
+			usage:   "/unsilence <user>",
+			help:    "moderators: let a silenced user post again",
+			feature: protocol.FeatureModeration,
+			run:     cmdUnsilence,
+		},
+		"ban": {
+			usage:   "/ban <user> [reason]",
+			help:    "admins: disconnect a user and stop them logging in",
+			feature: protocol.FeatureModeration,
+			run:     cmdBan,
+		},
+		"unban": {
+			usage:   "/unban <user>",
+			help:    "admins: let a banned user log in again",
+			feature: protocol.FeatureModeration,
+			run:     cmdUnban,
+		},
+		"revisions": {
+			usage:   "/revisions [id] [@user] [#channel]",
+			help:    "moderators: what removed messages said",
+			feature: protocol.FeatureRevisions,
+			run:     cmdRevisions,
+		},
+		"held": {
+			usage:   "/held",
+			help:    "moderators: messages held for review",
+			feature: protocol.FeatureHold,
+			run:     cmdHeld,
+		},
+		"release": {
+			usage:   "/release <id> [drop]",
+			help:    "moderators: post a held message, or drop it",
+			feature: protocol.FeatureHold,
+			run:     cmdRelease,
+		},
+		"usage": {
+			usage:   "/usage",
+			help:    "admins: traffic per connection",
+			feature: protocol.FeatureUsage,
+			run:     cmdUsage,
+		},
+		"stats": {
+			usage:   "/stats",
+			help:    "admins: activity statistics",
+			feature: protocol.FeatureStats,
+			run:     cmdStats,
+		},
+		"sessions": {
+			usage:   "/sessions [all]",
+			help:    "list your active sessions (admins: all sessions)",
+			feature: protocol.FeatureSessions,
+			run:     cmdSessions,
+		},
+		"dm": {
+			usage:   "/dm <user> [message]",
+			help:    "open a direct conversation (Tab cycles, Ctrl+L lists them)",
+			feature: protocol.FeatureDM,
+			run:     cmdDM,
+		},
+		"who": {
+			usage:   "/who",
+			help:    "list who is online",
+			feature: protocol.FeatureRoster,
+			run:     cmdWho,
+		},
+		"members": {
+			usage:   "/members [prefix | more]",
+			help:    "list registered users (Tab completes names after /dm or @)",
+			feature: protocol.FeatureUserSearch,
+			run:     cmdMembers,
+		},
+		"channels": {
+			usage:   "/channels",
+			help:    "browse public channels (Ctrl+L)",
+			feature: protocol.FeatureChannels,
+			run:     cmdChannels,
+		},
+		"join": {
+			usage:   "/join <#channel>",
+			help:    "join a public channel, creating it if it is new",
+			feature: protocol.FeatureChannels,
+			run:     cmdJoin,
+		},
+		"leave": {
+			usage:   "/leave [#channel]",
+			help:    "leave a public channel (default: this one)",
+			feature: protocol.FeatureChannels,
+			run:     cmdLeave,
+		},
+		"topic": {
+			usage:   "/topic [text]",
+			help:    "set (or clear) this channel's topic",
+			feature: protocol.FeatureChannels,
+			run:     cmdTopic,
+		},
+		"readonly": {
+			usage:   "/readonly [on | off]",
+			help:    "make this channel an announcement channel, where only its creator and moderators post",
+			feature: protocol.FeatureChannelMode,
+			run:     cmdReadOnly,
+		},
+		"archive": {
+			usage:   "/archive [#channel]",
+			help:    "admin: archive a channel (this one by default): it is hidden and read-only, its history kept",
+			feature: protocol.FeatureArchive,
+			run:     cmdArchive,
+		},
+		"restore": {
+			usage:   "/restore [#channel]",
+			help:    "admin: restore an archived channel",
+			feature: protocol.FeatureArchive,
+			run:     cmdRestore,
+		},
+		"joins": {
+			usage: "/joins [on | off]",
+			help:  "show or hide join and leave notices; hidden ones are summed up every minute or so",
+			run:   cmdJoins,
+		},
+		"bell": {
+			usage: "/bell [on | off]",
+			help:  "ring the terminal bell when someone mentions you",
+			run:   cmdBell,
+		},
+		"mute": {
+			usage:   "/mute [#channel | @user]",
+			help:    "no notifications or unread counts from a conversation (default: this one)",
+			feature: protocol.FeatureMute,
+			run:     cmdMute,
+		},
+		"unmute": {
+			usage:   "/unmute [#channel | @user]",
+			help:    "undo /mute",
+			feature: protocol.FeatureMute,
+			run:     cmdUnmute,
+		},
+		"locale": {
+			usage:   "/locale [language | off]",
+			help:    "have messages in other languages translated, e.g. /locale de",
+			feature: protocol.FeatureTranslate,
+			run:     cmdLocale,
+		},
+		"spell": {
+			usage: "/spell [language | off]",
+			help:  "underline misspelled words as you type, e.g. /spell en_US",
+			run:   cmdSpell,
+		},
+		"main": {
+			usage: "/main",
+			help:  "return to the main channel",
+			run:   cmdMain,
+		},
+		"connect": {
+			usage:   "/connect [profile | host:port]",
+			help:    "reconnect, switch profile or server, or list profiles",
+			offline: true,
+			run:     cmdConnect,
+		},
+		"disconnect": {
+			usage: "/disconnect",
+			help:  "close the connection but keep the client open",
+			run:   cmdDisconnect,
+		},
+		"logout": {
+			usage:   "/logout <conn-id>",
+			help:    "sign out one of your sessions",
+			feature: protocol.FeatureSessions,
+			run:     cmdLogout,
+		},
+	}
+}
+
+// runCommand parses a "/name args…" line and dispatches it.
+func (m model) runCommand(line string) (model, tea.Cmd) {
+	fields := strings.Fields(strings.TrimPrefix(line, "/"))
+	if len(fields) == 0 {
+		return m, nil
+	}
+	cmd, ok := commands[strings.ToLower(fields[0])]
+	if !ok {
+		m.warn(fmt.Sprintf("unknown command /%s — try /help", fields[0]))
+		return m, nil
+	}
+	if m.conn == nil && !cmd.offline {
+		m.warn("not connected — /connect to reconnect")
+		return m, nil
+	}
+	if cmd.feature != "" && !m.supports(cmd.feature) {
+		m.warn(fmt.Sprintf("/%s is not supported by this server", fields[0]))
+		return m, nil
+	}
+	return cmd.run(m, fields[1:])
+}
+
+func cmdHelp(m model, _ []string) (model, tea.Cmd) {
+	names := make([]string, 0, len(commands))
+	for name, c := range commands {
+		if c.feature == "" || m.supports(c.feature) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	m.appendChat(sysStyle.Render("Commands:"))
+	for _, name := range names {
+		c := commands[name]
+		m.appendChat(hintStyle.Render(fmt.Sprintf("  %-24s %s", c.usage, c.help)))
+	}
+	return m, nil
+}
+
+func cmdTime(m model, _ []string) (model, tea.Cmd) {
+	if !m.skewKnown {
+		m.appendChat(hintStyle.Render("clock not synced yet; try again in a moment"))
+		return m, nil
+	}
+	m.appendChat(sysStyle.Render(fmt.Sprintf("server time %s · local clock %+v off · rtt %v",
+		m.serverNow().Format("2006-01-02 15:04:05 MST"),
+		(-m.skew).Round(time.Millisecond), m.rtt.Round(time.Millisecond))))
+	return m, nil
+}
+
+func cmdReply(m model, args []string) (model, tea.Cmd) {
+	var from string
+	if len(args) > 0 && strings.HasPrefix(args[0], "@") {
+		from, args = strings.TrimPrefix(args[0], "@"), args[1:]
+	}
+	if len(args) == 0 {
+		m.warn("usage: " + commands["reply"].usage)
+		return m, nil
+	}
+
+	var parent *protocol.BroadcastPayload
+	for i := len(m.recent) - 1; i >= 0; i-- {
+		b := &m.recent[i]
+		if b.ID == "" || b.Channel != m.channel {
+			continue
+		}
+		if from != "" && strings.EqualFold(b.Username, from) || from == "" && b.Username != m.me {
+			parent = b
+			break
+		}
+	}
+	if parent == nil {
+		m.warn("no message to reply to")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeChat, protocol.ChatPayload{
+		Content: strings.Join(args, " "),
+		ReplyTo: parent.ID,
+		Channel: m.channel,
+	})
+	return m, nil
+}
+
+func cmdSchedule(m model, args []string) (model, tea.Cmd) {
+	if len(args) < 2 {
+		m.warn("usage: " + commands["schedule"].usage)
+		return m, nil
+	}
+	at, err := parseWhen(m.serverNow(), args[0])
+	if err != nil {
+		m.warn(err.Error())
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeChat, protocol.ChatPayload{
+		Content: strings.Join(args[1:], " "),
+		SendAt:  &at,
+		Channel: m.channel,
+	})
+	return m, nil
+}
+
+func cmdScheduled(m model, _ []string) (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeScheduled, map[string]string{})
+	m.waitScheduled = true
+	return m, nil
+}
+
+func cmdUnschedule(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 1 {
+		m.warn("usage: " + commands["unschedule"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeCancelScheduled, protocol.CancelScheduledPayload{ID: args[0]})
+	return m, nil
+}
+
+// parseWhen turns a /schedule time argument into an absolute time.  now is
+// the server's clock so relative times land where the user expects even when
+// the local clock is off.
+func parseWhen(now time.Time, s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("delay must be positive")
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		local := now.In(time.Local)
+		at := time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(local) {
+			at = at.AddDate(0, 0, 1) // already past today: mean tomorrow
+		}
+		return at, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("can't parse time %q — use 10m, 17:45 or 2006-01-02T15:04", s)
+}
+
+// renderScheduled formats a scheduled-message listing for the chat viewport.
+func (m *model) renderScheduled(pending []protocol.ScheduledMessage) {
+	for _, sm := range pending {
+		m.appendChat(hintStyle.Render(fmt.Sprintf("  %s  %s  %s",
+			sm.ID, sm.SendAt.Local().Format("2006-01-02 15:04"), sm.Content)))
+	}
+}
+
+func cmdSessions(m model, args []string) (model, tea.Cmd) {
+	all := len(args) > 0 && args[0] == "all"
+	sendPkt(m.conn, protocol.TypeSessions, protocol.SessionsPayload{All: all})
+	m.waitSessions = true
+	return m, nil
+}
+
+func cmdLogout(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 1 {
+		m.warn("usage: " + commands["logout"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeKillSession, protocol.KillSessionPayload{ConnID: args[0]})
+	return m, nil
+}
+
+func cmdMaintenance(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 1 && args[0] == "at" {
+		return cmdMaintenanceAt(m, args[1:])
+	}
+	if len(args) == 0 || args[0] != "on" && args[0] != "off" {
+		m.warn("usage: " + commands["maintenance"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeMaintenance, protocol.MaintenancePayload{
+		Enabled: args[0] == "on",
+		Reason:  strings.Join(args[1:], " "),
+	})
+	return m, nil
+}
+
+// cmdMaintenanceAt schedules a maintenance window: /maintenance at 22:00
+// 30m upgrading the database.  Without a duration the window lasts until
+// /maintenance off.
+func cmdMaintenanceAt(m model, args []string) (model, tea.Cmd) {
+	if !m.supports(protocol.FeatureMaintWindow) {
+		m.warn("this server cannot schedule maintenance")
+		return m, nil
+	}
+	now := time.Now()
+	start, err := parseWhen(now, args[0])
+	if err != nil {
+		m.warn(err.Error())
+		return m, nil
+	}
+	p := protocol.MaintenancePayload{Enabled: true, Start: start}
+	args = args[1:]
+	if len(args) > 0 {
+		if d, err := time.ParseDuration(args[0]); err == nil && d > 0 {
+			p.End = start.Add(d)
+			args = args[1:]
+		}
+	}
+	p.Reason = strings.Join(args, " ")
+	sendPkt(m.conn, protocol.TypeMaintenance, p)
+	return m, nil
+}
+
+func cmdAnnounce(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["announce"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeAnnounce, protocol.AnnouncePayload{Message: strings.Join(args, " ")})
+	return m, nil
+}
+
+func cmdUsage(m model, _ []string) (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeUsage, map[string]string{})
+	m.waitUsage = true
+	return m, nil
+}
+
+// renderUsage formats a traffic report for the chat viewport.
+func (m *model) renderUsage(r protocol.UsageReport) {
+	row := func(name, addr string, u protocol.UsageInfo) string {
+		line := fmt.Sprintf("  %-10s %-16s %-22s in %9s / %6d pkts   out %9s / %6d pkts",
+			name, u.Username, addr, humanSize(int64(u.BytesIn)), u.PacketsIn, humanSize(int64(u.BytesOut)), u.PacketsOut)
+		if u.SendQueued > 0 {
+			line += fmt.Sprintf("   %d queued", u.SendQueued)
+		}
+		return line
+	}
+	for _, u := range r.Connections {
+		m.appendChat(hintStyle.Render(row(u.ConnID, u.RemoteAddr, u)))
+	}
+	m.appendChat(hintStyle.Render(row("total", "", r.Total)))
+}
+
+func cmdStats(m model, _ []string) (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeStats, map[string]string{})
+	m.waitStats = true
+	return m, nil
+}
+
+// statsBarWidth is the length of the busiest day's bar in /stats.
+const statsBarWidth = 30
+
+// renderStats formats an activity report for the chat viewport.
+func (m *model) renderStats(r protocol.StatsReport) {
+	line := fmt.Sprintf("  online now %d · peak %d", r.Online, r.PeakOnline)
+	if !r.PeakAt.IsZero() {
+		line += " (" + r.PeakAt.Local().Format("2006-01-02 15:04") + ")"
+	}
+	m.appendChat(hintStyle.Render(line + " · storage " + humanSize(r.StorageBytes)))
+
+	busiest := 0
+	for _, d := range r.Daily {
+		busiest = max(busiest, d.Messages)
+	}
+	m.appendChat(hintStyle.Render("  messages per day:"))
+	for _, d := range r.Daily {
+		label := d.Day
+		if t, err := time.Parse(time.DateOnly, d.Day); err == nil {
+			label = t.Format("Mon 01-02")
+		}
+		bar := ""
+		if busiest > 0 {
+			bar = strings.Repeat("█", (d.Messages*statsBarWidth+busiest-1)/busiest)
+		}
+		m.appendChat(hintStyle.Render(fmt.Sprintf("    %s %-*s %d", label, statsBarWidth, bar, d.Messages)))
+	}
+
+	if len(r.TopUsers) == 0 {
+		return
+	}
+	top := make([]string, len(r.TopUsers))
+	for i, u := range r.TopUsers {
+		top[i] = fmt.Sprintf("%s %d", u.Username, u.Messages)
+	}
+	m.appendChat(hintStyle.Render(fmt.Sprintf("  most active (%d days): %s", len(r.Daily), strings.Join(top, ", "))))
+}
+
+// renderSessions formats a sessions listing for the chat viewport.
+func (m *model) renderSessions(sessions []protocol.SessionInfo) {
+	for _, s := range sessions {
+		line := fmt.Sprintf("  %-10s %-16s %-22s since %s",
+			s.ConnID, s.Username, s.RemoteAddr, s.ConnectedSince.Local().Format("2006-01-02 15:04"))
+		if s.Current {
+			line += "  (this session)"
+		}
+		m.appendChat(hintStyle.Render(line))
+	}
+}
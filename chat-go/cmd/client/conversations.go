@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Conversations
+// ---------------------------------------------------------------------------
+//
+// The chat view shows one conversation at a time: the main channel, a DM or
+// a public channel.
+// The model's scrollback and older-history cursor always belong to
+// the conversation on screen; the others are parked in convs and swapped in
+// by swapView.  Messages for a parked conversation bump its unread count and,
+// once its history has been loaded, are appended to its lines.
+
+// convPanelWidth is the width of the Ctrl+L conversation list.
+const convPanelWidth = 24
+
+// convView is the parked state of one conversation.
+type convView struct {
+	peer     string    // the other member of a DM; "" for other channels
+	lastAt   time.Time // latest message, for ordering the list
+	unread   int
+	loaded   bool // history has been requested; new messages are rendered
+	readOnly bool // an announcement channel we may not post in
+	archived bool // an archived channel; readOnly too
+
+	scroll       scrollback
+	oldestID     string
+	hasOlder     bool
+	loadingOlder bool
+	yOffset      int
+}
+
+// conv returns the parked state for ch, creating it on first use.
+func (m *model) conv(ch string) *convView {
+	cv, ok := m.convs[ch]
+	if !ok {
+		cv = &convView{}
+		m.convs[ch] = cv
+	}
+	return cv
+}
+
+// swapView parks the conversation on screen and shows ch instead.
+func (m *model) swapView(ch string) {
+	if ch == m.channel {
+		return
+	}
+	cur := m.conv(m.channel)
+	cur.scroll = m.scroll
+	cur.oldestID, cur.hasOlder, cur.loadingOlder = m.oldestID, m.hasOlder, m.loadingOlder
+	cur.yOffset = m.viewport.YOffset
+
+	next := m.conv(ch)
+	m.channel = ch
+	m.scroll = next.scroll
+	m.oldestID, m.hasOlder, m.loadingOlder = next.oldestID, next.hasOlder, next.loadingOlder
+	m.refreshChat()
+	m.viewport.SetYOffset(next.yOffset)
+}
+
+// openConversation shows ch, requesting its history the first time.
+func (m model) openConversation(ch string) (model, tea.Cmd) {
+	m.swapView(ch)
+	m.markRead()
+	cv := m.conv(ch)
+	cv.unread = 0
+	if !cv.loaded {
+		cv.loaded = true
+		if m.restoreCached() {
+			return m, nil
+		}
+		sendPkt(m.conn, protocol.TypeHistory, protocol.HistoryPayload{
+			Limit:   olderPageSize,
+			Batch:   true,
+			Channel: ch,
+		})
+	}
+	return m, nil
+}
+
+// deliverElsewhere files b under its parked conversation.
+func (m *model) deliverElsewhere(b protocol.BroadcastPayload) {
+	cv := m.conv(b.Channel)
+	if cv.peer == "" && protocol.IsDirect(b.Channel) {
+		cv.peer = peerOf(b, m.me)
+	}
+	cv.lastAt = b.Timestamp
+	if b.Username != m.me && !m.muted[b.Channel] {
+		cv.unread++
+	}
+	if cv.loaded {
+		cv.scroll.add(m.messageLine(b))
+	}
+}
+
+// peerOf is the other party of a DM as seen by me.
+func peerOf(b protocol.BroadcastPayload, me string) string {
+	if b.Username == me {
+		return b.To
+	}
+	return b.Username
+}
+
+// convOrder lists conversations as the panel shows them: the main channel,
+// then DMs and public channels with the most recent activity first.
+func (m model) convOrder() []string {
+	order := []string{protocol.MainChannel}
+	var dms []string
+	for ch := range m.convs {
+		if ch != protocol.MainChannel {
+			dms = append(dms, ch)
+		}
+	}
+	sort.Slice(dms, func(i, j int) bool {
+		a, b := m.convs[dms[i]], m.convs[dms[j]]
+		if !a.lastAt.Equal(b.lastAt) {
+			return a.lastAt.After(b.lastAt)
+		}
+		return m.convLabel(dms[i]) < m.convLabel(dms[j])
+	})
+	return append(order, dms...)
+}
+
+// cycleConversation moves delta places through convOrder.
+func (m model) cycleConversation(delta int) (model, tea.Cmd) {
+	order := m.convOrder()
+	if len(order) < 2 {
+		return m, nil
+	}
+	i := 0
+	for j, ch := range order {
+		if ch == m.channel {
+			i = j
+		}
+	}
+	return m.openConversation(order[(i+delta+len(order))%len(order)])
+}
+
+// convLabel names a conversation in the UI: "#main" or "@peer".
+func (m model) convLabel(ch string) string {
+	if !protocol.IsDirect(ch) {
+		return channelLabel(ch)
+	}
+	if cv, ok := m.convs[ch]; ok && cv.peer != "" {
+		return "@" + cv.peer
+	}
+	return "@?"
+}
+
+// searchLabel names the conversation of a search result, which may be a DM
+// this session has not seen yet.
+func (m model) searchLabel(r protocol.StoredMessage) string {
+	if cv, ok := m.convs[r.Channel]; (!ok || cv.peer == "") && protocol.IsDirect(r.Channel) {
+		return "@" + peerOf(protocol.BroadcastPayload{Username: r.Username, To: r.To}, m.me)
+	}
+	return m.convLabel(r.Channel)
+}
+
+// unreadTotal counts unread messages in every parked conversation.
+func (m model) unreadTotal() int {
+	n := 0
+	for ch, cv := range m.convs {
+		if ch != m.channel {
+			n += cv.unread
+		}
+	}
+	return n
+}
+
+// renderConvPanel draws the conversation list, height rows tall.
+func (m model) renderConvPanel(height int) string {
+	rows := []string{hintStyle.Render("Conversations")}
+	for _, ch := range m.convOrder() {
+		label := m.convLabel(ch)
+		if n := m.convs[ch].unread; n > 0 && ch != m.channel {
+			label += fmt.Sprintf(" (%d)", n)
+		}
+		if m.muted[ch] {
+			label += " 🔕"
+		}
+		switch {
+		case m.convs[ch].archived:
+			label += " 🗄"
+		case m.convs[ch].readOnly:
+			label += " 🔒"
+		}
+		if ch == m.channel {
+			rows = append(rows, myNameStyle.Render("▸ "+label))
+		} else {
+			rows = append(rows, "  "+label)
+		}
+	}
+	rows = append(rows, "", hintStyle.Render("Tab/Shift+Tab: switch"), hintStyle.Render("/dm <user>: new"))
+	return lipgloss.NewStyle().
+		Width(convPanelWidth-1).
+		Height(height).
+		Border(lipgloss.NormalBorder(), false, true, false, false).
+		BorderForeground(gray).
+		Render(strings.Join(rows, "\n"))
+}
+
+// setConversations records the DM list the server sent after login.
+func (m *model) setConversations(list []protocol.ConversationInfo) {
+	for _, info := range list {
+		cv := m.conv(info.Channel)
+		cv.peer = info.Peer
+		cv.readOnly, cv.archived = info.ReadOnly, info.Archived
+		if info.LastAt.After(cv.lastAt) {
+			cv.lastAt = info.LastAt
+		}
+	}
+}
+
+func cmdDM(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["dm"].usage)
+		return m, nil
+	}
+	peer := strings.TrimPrefix(args[0], "@")
+	text := strings.Join(args[1:], " ")
+	for ch, cv := range m.convs {
+		if strings.EqualFold(cv.peer, peer) {
+			m, cmd := m.openConversation(ch)
+			if text != "" {
+				sendPkt(m.conn, protocol.TypeChat, protocol.ChatPayload{Content: text, Channel: ch})
+			}
+			return m, cmd
+		}
+	}
+	sendPkt(m.conn, protocol.TypeOpenDM, protocol.OpenDMPayload{Username: peer})
+	m.waitOpenDM = true
+	m.pendingDM = text
+	return m, nil
+}
+
+func cmdMain(m model, _ []string) (model, tea.Cmd) {
+	return m.openConversation(protocol.MainChannel)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message deletion
+// ---------------------------------------------------------------------------
+//
+// /delete removes the user's latest message in the current conversation,
+// or any message by ID for admins (FeatureDelete).  When the server says a
+// message is gone (TypeDeleted) its line becomes a tombstone, and it is
+// dropped from the message cache and from what /reply and /delete look at.
+
+func cmdDelete(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 1 {
+		m.warn("usage: " + commands["delete"].usage)
+		return m, nil
+	}
+	id := ""
+	if len(args) == 1 {
+		id = args[0]
+	} else {
+		for i := len(m.recent) - 1; i >= 0; i-- {
+			if b := m.recent[i]; b.ID != "" && b.Channel == m.channel && strings.EqualFold(b.Username, m.me) {
+				id = b.ID
+				break
+			}
+		}
+	}
+	if id == "" {
+		m.warn("no message of yours to delete here")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeDelete, protocol.DeletePayload{ID: id})
+	return m, nil
+}
+
+// showDeleted turns the line of the deleted message into a tombstone.
+func (m *model) showDeleted(d protocol.DeletedPayload) {
+	m.recent = slices.DeleteFunc(m.recent, func(b protocol.BroadcastPayload) bool { return b.ID == d.ID })
+	m.cache.remove(d.Channel, d.ID)
+
+	scroll := &m.scroll
+	if d.Channel != m.channel {
+		scroll = &m.conv(d.Channel).scroll
+	}
+	if l, ok := scroll.message(d.ID); ok {
+		l.msg, l.notes = nil, nil
+		l.text = hintStyle.Render("🗑 message deleted by " + d.By)
+		if d.Channel == m.channel {
+			m.refreshChat()
+		}
+	}
+}
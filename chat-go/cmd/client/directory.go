@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// User directory
+// ---------------------------------------------------------------------------
+//
+// /members pages through the server's list of registered users, and Tab
+// completes a username after /dm or an @ from the same list, so people who
+// are offline can be found too.
+
+const (
+	membersPageSize  = 20
+	completePageSize = 10
+)
+
+// memberQuery is the /members listing being paged through.
+type memberQuery struct {
+	prefix string
+	after  string // last username shown
+	more   bool
+}
+
+func cmdMembers(m model, args []string) (model, tea.Cmd) {
+	q := memberQuery{}
+	switch {
+	case len(args) == 1 && args[0] == "more":
+		if !m.members.more {
+			m.appendChat(hintStyle.Render("no more users to show"))
+			return m, nil
+		}
+		q = m.members
+	case len(args) > 0:
+		q.prefix = strings.TrimPrefix(args[0], "@")
+	}
+	m.members = q
+	sendPkt(m.conn, protocol.TypeUserSearch, protocol.UserSearchPayload{Prefix: q.prefix, After: q.after, Limit: membersPageSize})
+	m.waitMembers = true
+	return m, nil
+}
+
+func (m *model) renderMembers(res protocol.UserSearchResult) {
+	if len(res.Users) == 0 {
+		m.appendChat(hintStyle.Render("  no users found"))
+	}
+	for _, u := range res.Users {
+		mark := "○"
+		if u.Online {
+			mark = "●"
+		}
+		m.appendChat(hintStyle.Render(fmt.Sprintf("  %s %s", mark, u.Username)))
+	}
+	if len(res.Users) > 0 {
+		m.members.after = res.Users[len(res.Users)-1].Username
+	}
+	m.members.more = res.More
+	if res.More {
+		m.appendChat(hintStyle.Render("  … /members more for the next page"))
+	}
+}
+
+// completeUser starts completing the username being typed at the end of
+// the input — the argument of /dm, or a word starting with @ — and reports
+// whether there was one.
+func (m model) completeUser() (model, bool) {
+	if !m.supports(protocol.FeatureUserSearch) || m.conn == nil {
+		return m, false
+	}
+	value := m.chatInput.Value()
+	if value == "" || strings.HasSuffix(value, " ") {
+		return m, false
+	}
+	fields := strings.Fields(value)
+	word := fields[len(fields)-1]
+	isDM := len(fields) == 2 && strings.EqualFold(fields[0], "/dm")
+	if !isDM && !strings.HasPrefix(word, "@") {
+		return m, false
+	}
+	sendPkt(m.conn, protocol.TypeUserSearch, protocol.UserSearchPayload{
+		Prefix: strings.TrimPrefix(word, "@"),
+		Limit:  completePageSize,
+	})
+	m.waitComplete = value
+	return m, true
+}
+
+// finishCompletion applies a completion answer to the input, unless the
+// user has typed on since asking.
+func (m *model) finishCompletion(input string, res protocol.UserSearchResult) {
+	if m.chatInput.Value() != input {
+		return
+	}
+	var names []string
+	for _, u := range res.Users {
+		if !strings.EqualFold(u.Username, m.me) {
+			names = append(names, u.Username)
+		}
+	}
+	cut := strings.LastIndex(input, " ") + 1
+	if strings.HasPrefix(input[cut:], "@") {
+		cut++
+	}
+	switch {
+	case len(names) == 0:
+		m.appendChat(hintStyle.Render("no user starts with " + input[cut:]))
+		return
+	case len(names) == 1 && !res.More:
+		m.chatInput.SetValue(input[:cut] + names[0] + " ")
+	default:
+		m.chatInput.SetValue(input[:cut] + commonPrefix(names))
+		list := strings.Join(names, "  ")
+		if res.More {
+			list += "  …"
+		}
+		m.appendChat(hintStyle.Render(list))
+	}
+	m.chatInput.CursorEnd()
+}
+
+// commonPrefix is the longest prefix, ignoring case, that names share,
+// spelled as in the first name.
+func commonPrefix(names []string) string {
+	p := []rune(names[0])
+	for _, name := range names[1:] {
+		r := []rune(name)
+		n := 0
+		for n < len(p) && n < len(r) && strings.EqualFold(string(p[n]), string(r[n])) {
+			n++
+		}
+		p = p[:n]
+	}
+	return string(p)
+}
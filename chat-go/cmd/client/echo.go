@@ -0,0 +1,98 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Sending without echo
+// ---------------------------------------------------------------------------
+//
+// On a server with FeatureNoEcho the client logs in with AuthPayload.NoEcho:
+// a message the user sends is shown at once, marked as on its way, instead
+// of when its broadcast comes back, and the server answers with a TypeSent
+// in the broadcast's place.  The message's entry is found by the Ref it was
+// sent with and becomes the message the server numbered, in place.  When
+// the server refuses the message, or the connection drops first, the entry
+// stays and says it was not sent.  A profile with "echo": true keeps the
+// old way, waiting for each broadcast.
+
+// noEcho reports whether the messages the user sends are shown right away.
+func (m model) noEcho() bool {
+	return !m.echo && m.hello != nil && m.hello.HasFeature(protocol.FeatureNoEcho)
+}
+
+// newRef names a message about to be sent.
+func (m *model) newRef() string {
+	m.refs++
+	return strconv.Itoa(m.refs)
+}
+
+// showSending shows p, just sent, as on its way.
+func (m *model) showSending(p protocol.ChatPayload) {
+	b := protocol.BroadcastPayload{Channel: p.Channel, Username: m.me, Content: p.Content, Timestamp: m.serverNow()}
+	m.appendEntry(chatLine{
+		ref:   p.Ref,
+		text:  m.renderMessage(b),
+		notes: []string{sysStyle.Render("  … sending")},
+	})
+}
+
+// confirmSent turns the entry of the message s acknowledges into the
+// message, wherever its conversation is.
+func (m *model) confirmSent(s protocol.SentPayload) {
+	if s.Ref == "" {
+		return
+	}
+	sb := &m.conv(s.Message.Channel).scroll
+	if s.Message.Channel == m.channel {
+		sb = &m.scroll
+	}
+	sb.settle(s.Ref, m.messageLine(s.Message))
+}
+
+// notSent marks the entry of p, which did not go through, as not sent.
+func (m *model) notSent(p *protocol.ChatPayload) {
+	if p == nil || p.Ref == "" {
+		return
+	}
+	sb := &m.conv(p.Channel).scroll
+	if p.Channel == m.channel {
+		sb = &m.scroll
+	}
+	if i := sb.sending(p.Ref); i >= 0 {
+		sb.lines[i].ref, sb.lines[i].notes = "", []string{errorStyle.Render("  ✗ not sent")}
+		if sb == &m.scroll {
+			m.refreshChat()
+		}
+	}
+}
+
+// sending returns the index of the entry of the own message sent as ref
+// and not yet acknowledged, or -1.
+func (s *scrollback) sending(ref string) int {
+	for i := len(s.lines) - 1; i >= 0; i-- {
+		if s.lines[i].ref == ref {
+			return i
+		}
+	}
+	return -1
+}
+
+// settle replaces the entry of the own message sent as ref with l, the
+// message as the server took it.  l is dropped when it is already shown.
+func (s *scrollback) settle(ref string, l chatLine) {
+	i := s.sending(ref)
+	if i < 0 {
+		return
+	}
+	if _, shown := s.find(l); shown {
+		s.lines = slices.Delete(s.lines, i, i+1)
+	} else {
+		s.lines[i] = l
+	}
+	s.reindex()
+}
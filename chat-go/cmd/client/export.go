@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Personal data export
+// ---------------------------------------------------------------------------
+//
+// /export asks the server (FeatureExport) for a copy of the user's data.
+// The server prepares it in the background and reports progress with
+// TypeExportStatus; once it is ready, /export save downloads the bundle
+// over the file service.
+
+func cmdExport(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 0 && args[0] == "save" {
+		if m.lastExport == nil {
+			m.warn("no export is ready — ask for one with /export")
+			return m, nil
+		}
+		if len(args) > 2 {
+			m.warn("usage: /export save [dest]")
+			return m, nil
+		}
+		fileURL, dest := m.lastExport.URL, ""
+		if len(args) == 2 {
+			dest = args[1]
+		}
+		return m.withFileToken(func(token string) tea.Cmd {
+			return downloadFile(fileURL, token, dest)
+		})
+	}
+	if len(args) > 1 {
+		m.warn("usage: " + commands["export"].usage)
+		return m, nil
+	}
+	var p protocol.ExportPayload
+	if len(args) == 1 {
+		p.User = args[0]
+	}
+	sendPkt(m.conn, protocol.TypeExport, p)
+	return m, nil
+}
+
+// showExportStatus reports a TypeExportStatus packet in the scrollback.
+func (m *model) showExportStatus(raw json.RawMessage) {
+	var st protocol.ExportStatus
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return
+	}
+	switch st.State {
+	case protocol.ExportRunning:
+		if st.Total > 0 {
+			m.appendChat(hintStyle.Render(fmt.Sprintf("  export of %s: %d%% of the archive gone through", st.User, st.Done*100/st.Total)))
+		}
+	case protocol.ExportReady:
+		m.lastExport = &st
+		m.appendChat(successStyle.Render(fmt.Sprintf("✓ export of %s ready (%s) — /export save [dest] until %s",
+			st.User, humanSize(st.Size), st.Expires.Local().Format("Jan 2 15:04"))))
+	case protocol.ExportFailed:
+		m.fail(fmt.Sprintf("export of %s failed: %s", st.User, st.Error))
+	}
+}
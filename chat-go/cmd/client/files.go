@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// File transfer over the server's HTTP sidecar
+// ---------------------------------------------------------------------------
+
+// uploadDoneMsg reports the outcome of an HTTP upload.
+type uploadDoneMsg struct {
+	att     *protocol.Attachment
+	caption string
+	channel string             // conversation the upload was started in
+	clip    *protocol.ClipMeta // set for /clip, see clips.go
+	err     error
+}
+
+// downloadDoneMsg reports the outcome of an HTTP download.
+type downloadDoneMsg struct {
+	path string
+	size int64
+	err  error
+}
+
+// fileTransfer is an HTTP request waiting for a file token.
+type fileTransfer func(token string) tea.Cmd
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// withFileToken runs op with a valid file token, first asking the server for
+// one when the cached token is missing or about to expire.
+func (m model) withFileToken(op fileTransfer) (model, tea.Cmd) {
+	if m.fileToken != nil && m.serverNow().Add(time.Minute).Before(m.fileToken.ExpiresAt) {
+		return m, op(m.fileToken.Token)
+	}
+	sendPkt(m.conn, protocol.TypeFileToken, map[string]string{})
+	m.waitFileToken = true
+	m.pendingFile = op
+	return m, nil
+}
+
+func cmdUpload(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["upload"].usage)
+		return m, nil
+	}
+	path, caption := args[0], strings.Join(args[1:], " ")
+	st, err := os.Stat(path)
+	if err != nil {
+		m.fail(err.Error())
+		return m, nil
+	}
+	if max := m.hello.Limits.MaxUploadSize; max > 0 && st.Size() > max {
+		m.warn(fmt.Sprintf("%s is %s; the server limit is %s",
+			filepath.Base(path), humanSize(st.Size()), humanSize(max)))
+		return m, nil
+	}
+	uploadURL, channel := m.hello.FilesURL, m.channel
+	m.appendChat(hintStyle.Render("uploading " + filepath.Base(path) + "…"))
+	return m.withFileToken(func(token string) tea.Cmd {
+		return uploadFile(uploadURL, token, path, caption, channel)
+	})
+}
+
+func cmdDownload(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 || len(args) > 2 {
+		m.warn("usage: " + commands["download"].usage)
+		return m, nil
+	}
+	fileURL := m.hello.FilesURL + "/" + url.PathEscape(args[0])
+	dest := ""
+	if len(args) == 2 {
+		dest = args[1]
+	}
+	return m.withFileToken(func(token string) tea.Cmd {
+		return downloadFile(fileURL, token, dest)
+	})
+}
+
+func uploadFile(uploadURL, token, path, caption, channel string) tea.Cmd {
+	return func() tea.Msg {
+		f, err := os.Open(path)
+		if err != nil {
+			return uploadDoneMsg{err: err}
+		}
+		defer f.Close()
+
+		req, err := http.NewRequest(http.MethodPost, uploadURL+"?name="+url.QueryEscape(filepath.Base(path)), f)
+		if err != nil {
+			return uploadDoneMsg{err: err}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return uploadDoneMsg{err: err}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return uploadDoneMsg{err: httpError(resp)}
+		}
+		var att protocol.Attachment
+		if err := json.NewDecoder(resp.Body).Decode(&att); err != nil {
+			return uploadDoneMsg{err: err}
+		}
+		return uploadDoneMsg{att: &att, caption: caption, channel: channel}
+	}
+}
+
+// downloadFile saves the file at fileURL to dest, or to the server-supplied
+// filename in the current directory when dest is empty.
+func downloadFile(fileURL, token, dest string) tea.Cmd {
+	return func() tea.Msg {
+		req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+		if err != nil {
+			return downloadDoneMsg{err: err}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return downloadDoneMsg{err: err}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return downloadDoneMsg{err: httpError(resp)}
+		}
+
+		if dest == "" {
+			dest = "download"
+			if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+				if name := filepath.Base(params["filename"]); name != "." && name != "/" {
+					dest = name
+				}
+			}
+		}
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return downloadDoneMsg{err: err}
+		}
+		n, err := io.Copy(out, resp.Body)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dest)
+			return downloadDoneMsg{err: err}
+		}
+		return downloadDoneMsg{path: dest, size: n}
+	}
+}
+
+// httpError turns a non-200 response into an error carrying the server's
+// plain-text explanation.
+func httpError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = resp.Status
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// renderAttachment formats the attachment line shown under a message.
+func renderAttachment(a *protocol.Attachment) string {
+	return hintStyle.Render(fmt.Sprintf("           📎 %s (%s, %s) — /download %s",
+		a.Name, a.ContentType, humanSize(a.Size), a.ID))
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Held messages
+// ---------------------------------------------------------------------------
+//
+// When the server's moderation service holds a message (FeatureHold),
+// moderators list what is waiting with /held and post or drop each with
+// /release.
+
+func cmdHeld(m model, args []string) (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeHeld, nil)
+	m.waitHeld = true
+	return m, nil
+}
+
+func cmdRelease(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 || len(args) > 2 || len(args) == 2 && args[1] != "drop" {
+		m.warn("usage: " + commands["release"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeRelease, protocol.ReleasePayload{ID: args[0], Drop: len(args) == 2})
+	return m, nil
+}
+
+// renderHeld lists the messages of a /held answer.
+func (m *model) renderHeld(held []protocol.HeldMessage) {
+	if len(held) == 0 {
+		m.appendChat(hintStyle.Render("  (nothing held)"))
+		return
+	}
+	for _, h := range held {
+		line := fmt.Sprintf("  %s held", h.HeldAt.Local().Format("2006-01-02 15:04"))
+		if h.Reason != "" {
+			line += ": " + h.Reason
+		}
+		var scores []string
+		for _, k := range slices.Sorted(maps.Keys(h.Scores)) {
+			scores = append(scores, fmt.Sprintf("%s %.2f", k, h.Scores[k]))
+		}
+		if len(scores) > 0 {
+			line += " (" + strings.Join(scores, ", ") + ")"
+		}
+		m.appendChat(hintStyle.Render(line))
+		msg := h.Message
+		m.appendChat(fmt.Sprintf("    [%s %s] %s %s: %s", msg.ID, msg.Timestamp.Local().Format("01-02 15:04"),
+			channelLabel(msg.Channel), msg.Username, msg.Content))
+	}
+}
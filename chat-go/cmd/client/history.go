@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Older history
+// ---------------------------------------------------------------------------
+
+// olderPageSize is how many messages each "load older" request asks for.
+const olderPageSize = 50
+
+// loadOlder requests the page of history before the oldest message shown.
+// It is triggered by Enter on an empty input or PgUp at the top of the
+// scrollback, and is a no-op while a request is already in flight.
+func (m model) loadOlder() (model, tea.Cmd) {
+	if !m.hasOlder || m.loadingOlder || m.oldestID == "" {
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeHistory, protocol.HistoryPayload{
+		Limit:   olderPageSize,
+		Batch:   true,
+		Before:  m.oldestID,
+		Channel: m.channel,
+	})
+	m.loadingOlder = true
+	m.refreshChat()
+	return m, m.spinner.Tick
+}
+
+// refreshChat redraws the viewport from the scrollback, with the
+// older-history sentinel as its first row.
+func (m *model) refreshChat() {
+	lines := m.scroll.strings()
+	if s := m.olderSentinel(); s != "" {
+		lines = append([]string{s}, lines...)
+	}
+	m.viewport.SetContent(strings.Join(lines, "\n"))
+}
+
+func (m model) olderSentinel() string {
+	switch {
+	case m.loadingOlder:
+		return m.spinner.View() + hintStyle.Render(" Loading older messages…")
+	case m.hasOlder:
+		return hintStyle.Render("↑ Load older messages (Enter or PgUp at the top)")
+	case m.oldestID != "":
+		return hintStyle.Render("— beginning of history —")
+	}
+	return ""
+}
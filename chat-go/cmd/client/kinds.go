@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Structured message kinds
+// ---------------------------------------------------------------------------
+
+// kindRenderer renders the body of a message of one kind from its Meta.  It
+// returns ok=false when the metadata is unusable, in which case the plain
+// Content fallback is shown instead.
+type kindRenderer func(b protocol.BroadcastPayload) (body string, ok bool)
+
+// kindRenderers is the plugin point for structured messages: register a
+// renderer here to give a kind its own presentation.  Kinds without one fall
+// back to Content.
+var kindRenderers = map[string]kindRenderer{
+	protocol.KindLocation: renderLocation,
+	protocol.KindClip:     renderClip,
+}
+
+// renderBody returns the text shown after "name: " for b.
+func renderBody(b protocol.BroadcastPayload) string {
+	if b.Kind == protocol.KindText {
+		return b.Content
+	}
+	if r, ok := kindRenderers[b.Kind]; ok {
+		if body, ok := r(b); ok {
+			return body
+		}
+	}
+	if b.Content == "" {
+		return hintStyle.Render(fmt.Sprintf("(%s message — not supported by this client)", b.Kind))
+	}
+	return b.Content + " " + hintStyle.Render("["+b.Kind+"]")
+}
+
+// renderLocation shows a location.  The label is cleaned here too, in
+// case the server did not: it is text from another user.
+func renderLocation(b protocol.BroadcastPayload) (string, bool) {
+	var loc protocol.LocationMeta
+	if err := json.Unmarshal(b.Meta, &loc); err != nil {
+		return "", false
+	}
+	body := fmt.Sprintf("📍 %.5f, %.5f", loc.Lat, loc.Lon)
+	if label := cleanLine(loc.Label); label != "" {
+		body += " " + label
+	}
+	return body + " " + hintStyle.Render(fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.5f&mlon=%.5f", loc.Lat, loc.Lon)), true
+}
+
+func cmdLocation(m model, args []string) (model, tea.Cmd) {
+	if len(args) < 2 {
+		m.warn("usage: " + commands["location"].usage)
+		return m, nil
+	}
+	lat, err1 := strconv.ParseFloat(args[0], 64)
+	lon, err2 := strconv.ParseFloat(args[1], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		m.warn("latitude must be -90…90 and longitude -180…180")
+		return m, nil
+	}
+	loc := protocol.LocationMeta{Lat: lat, Lon: lon, Label: strings.Join(args[2:], " ")}
+	meta, _ := json.Marshal(loc)
+	fallback := fmt.Sprintf("📍 %.5f, %.5f %s", lat, lon, loc.Label)
+	sendPkt(m.conn, protocol.TypeChat, protocol.ChatPayload{
+		Content: strings.TrimSpace(fallback),
+		Kind:    protocol.KindLocation,
+		Meta:    meta,
+		Channel: m.channel,
+	})
+	return m, nil
+}
+
+// cleanLine drops the control characters, escape sequences' ESC among
+// them, and bidirectional overrides from s, so that it cannot change the
+// terminal or how the line around it reads.
+func cleanLine(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r >= 0x202a && r <= 0x202e || r >= 0x2066 && r <= 0x2069 {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -2,28 +2,37 @@
 //
 // Screens
 // -------
-//   stateLogin  – centered login / register form
-//   stateChat   – full-screen chat with scrollable message viewport
-//   stateSearch – Ctrl+F overlay: 4 search fields + scrollable results
+//   stateLogin    – centered login / register form
+//   stateChat     – full-screen chat with scrollable message viewport
+//   stateSearch   – Ctrl+F overlay: 4 search fields + scrollable results
+//   stateNotices  – Ctrl+N overlay: recent mentions and DMs
+//   stateChannels – Ctrl+L overlay: public channels to join
+//
+//   On first run the setup wizard (setup.go) comes before all of these.
 //
 // Concurrency
 // -----------
 //   A single goroutine reads newline-delimited JSON from the TCP connection
 //   and forwards raw bytes to the pkts channel.  The Bubbletea event loop
 //   consumes one packet at a time via waitForPkt (a tea.Cmd), immediately
-//   queuing the next read after each packet is processed.
+//   queuing the next read after each packet is processed.  /connect swaps in
+//   a new connection and channel; anything still arriving on the old channel
+//   is recognised by its source and dropped.
 package main
 
 import (
-	"bufio"
+	"cmp"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -83,20 +92,37 @@ var (
 			Italic(true)
 
 	successStyle = lipgloss.NewStyle().Foreground(green)
+	mentionStyle = lipgloss.NewStyle().Foreground(yellow).Bold(true).Reverse(true)
 	errorStyle   = lipgloss.NewStyle().Foreground(red)
 	sysStyle     = lipgloss.NewStyle().Foreground(yellow).Italic(true)
 	tsStyle      = lipgloss.NewStyle().Foreground(gray)
 	myNameStyle  = lipgloss.NewStyle().Bold(true).Foreground(orange)
 	peerStyle    = lipgloss.NewStyle().Bold(true).Foreground(blue)
 	divStyle     = lipgloss.NewStyle().Foreground(gray)
+	quoteStyle   = lipgloss.NewStyle().Foreground(gray).Italic(true)
+	spellStyle   = lipgloss.NewStyle().Foreground(red).Underline(true)
 )
 
 // ---------------------------------------------------------------------------
 // Bubbletea message types
 // ---------------------------------------------------------------------------
 
-type serverPktMsg []byte    // a raw packet line arrived from the server
-type disconnectedMsg struct{} // server closed the connection
+// serverPktMsg is a raw packet line that arrived from the server on src.
+type serverPktMsg struct {
+	data []byte
+	src  chan []byte
+}
+
+type disconnectedMsg struct{ src chan []byte } // server closed the connection
+type pingTickMsg struct{}                      // time to send the next keepalive ping
+
+// dialTimeout bounds connecting to the server, TLS handshake included.
+const dialTimeout = 10 * time.Second
+
+// pingInterval is how often the client pings the server.  Pings keep the
+// connection inside the server's idle timeout and refresh the clock-skew
+// estimate.
+const pingInterval = 30 * time.Second
 
 // ---------------------------------------------------------------------------
 // Application state
@@ -108,6 +134,8 @@ const (
 	stateLogin  appState = iota
 	stateChat
 	stateSearch
+	stateNotices
+	stateChannels
 )
 
 // ---------------------------------------------------------------------------
@@ -117,9 +145,24 @@ const (
 type model struct {
 	conn net.Conn
 	pkts chan []byte // goroutine → bubbletea bridge
+	addr string      // server address, keys the local notification file
+	tls  *tlsOptions // TLS settings of this connection, for a bare /connect
+
+	profiles map[string]profile // from the profile file; see profiles.go
+	profile  string             // name of the active profile, "" when none
 
-	state appState
-	me    string // authenticated username
+	state   appState
+	me      string // authenticated username
+	channel string // conversation shown in the chat view, see conversations.go
+
+	// hello is the server's capability advertisement; nil until it arrives.
+	hello *protocol.HelloPayload
+
+	// Clock sync: skew is server time minus local time, estimated from the
+	// hello packet and refined by every pong.
+	skew      time.Duration
+	rtt       time.Duration
+	skewKnown bool
 
 	// Login / register
 	loginIsReg  bool
@@ -131,22 +174,164 @@ type model struct {
 	ready       bool
 	viewport    viewport.Model
 	chatInput   textinput.Model
-	chatLines   []string // rendered lines shown in the viewport
+	scroll      scrollback                  // what the viewport shows, see scrollback.go
+	recent      []protocol.BroadcastPayload // last maxRecent messages, for /reply
 	onlineCount int
+	batching    bool           // true while applyBatch replays sub-packets
+	replaying   bool           // true while a history batch is applied; no notifications
+
+	// Conversations other than the one on screen, and the Ctrl+L list.
+	convs     map[string]*convView
+	showConvs bool
+	muted     map[string]bool // channel → muted, see mute.go
+	locale    string          // translations wanted, see translate.go
+
+	// Sequence tracking, see seq.go.
+	seqs map[string]uint64 // channel → highest Seq seen
+	gaps []seqGap          // requested ranges, oldest first
+
+	// Older history: oldestID is the cursor for the next "load older"
+	// request and hasOlder whether the server has anything before it.
+	oldestID     string
+	hasOlder     bool
+	loadingOlder bool
+	spinner      spinner.Model
 
 	// Search overlay
 	searchFocus   int
 	searchFields  [4]textinput.Model // content / username / from / to
 	searchResults []protocol.StoredMessage
 	searchStatus  string
+	searchFuzzy   bool // Ctrl+T: tolerate typos in content words
+	searchAll     bool // Ctrl+G: search every conversation, not just the current one
+	searchSort    int  // Ctrl+O: index into searchSorts
 	waitSearch    bool // true while waiting for the server's search response
 	waitHistory   bool // true while waiting for the initial history response
+	waitSessions  bool // true while waiting for a /sessions listing
+	waitScheduled bool // true while waiting for a /scheduled listing
+	waitFileToken bool // true while waiting for a file token
+	waitUsage     bool // true while waiting for a /usage report
+	waitStats     bool // true while waiting for a /stats report
+	waitConvs     bool // true while waiting for the DM conversation list
+	waitOpenDM    bool // true while waiting for a /dm channel
+	waitMembers   bool // true while waiting for a /members page
+	waitJoin      bool // true while waiting to join a channel
+	waitPrefs     bool // true while waiting for the stored preferences
+	waitMute      bool // true while waiting for a /mute or /unmute
+	waitLocale    bool // true while waiting for a /locale
+	waitBulk      bool // true while waiting for a /bulk preview or outcome
+	waitUnlock    bool // true while waiting for an unlock code or unlock
+	waitUnread    bool // true while waiting for the read marks after login
+	waitReaders   bool // true while waiting for a /seen
+	unlockRedeem  bool // the pending unlock request carries a code
+	waitRelay     bool // true while waiting for the /relay grant list
+	waitRevisions bool // true while waiting for /revisions
+	waitHeld      bool // true while waiting for /held
+
+	// lastExport is the latest data export ready for /export save.
+	lastExport *protocol.ExportStatus
+
+	// waitChallenge is the registration waiting for its proof-of-work
+	// challenge; see pow.go.
+	waitChallenge *protocol.AuthPayload
+
+	// session holds the token the server issued at login, which /connect
+	// presents to log in again without the password.
+	session protocol.SessionPayload
+
+	// speller underlines misspelled words in chatInput; nil when off.
+	speller *speller
+
+	// hideJoins tallies join and leave notices in joins rather than
+	// showing them; see presence.go.
+	hideJoins bool
+	joins     joinTally
+
+	// bell rings the terminal bell on mentions; see mentions.go.
+	bell bool
+
+	// echo waits for the broadcast of each message sent rather than
+	// showing it at once, and refs counts the messages sent; see echo.go.
+	echo bool
+	refs int
+
+	// Typing indicators, see typing.go: who is typing where, by when
+	// they last said so, and when the user's own typing was last sent.
+	typing  map[string]map[string]time.Time
+	typedAt time.Time
+	typedIn string
+
+	// roster is who is online, user ID → username, with FeatureRoster,
+	// and rosterRev the Rev it is at with FeatureRosterDiff; see roster.go.
+	roster    map[string]string
+	rosterRev uint64
+
+	// player plays voice clips, from -player or the profile; see clips.go.
+	player string
+
+	// Rate-limit cooldown, see ratelimit.go: the last message sent and not
+	// yet echoed, the one the server refused, and when Enter works again.
+	inFlight  *protocol.ChatPayload
+	blocked   *protocol.ChatPayload
+	coolUntil time.Time
+
+	// Connection status, see status.go: the messages sent and not yet
+	// taken, and the latest round trip.
+	unacked []sentChat
+	lag     time.Duration
+
+	// quitAsk is the toast asking to confirm Ctrl+C; see quit.go.
+	quitAsk int
+
+	// Read receipts, see reads.go: the message each conversation was last
+	// marked read at, and who has seen the user's latest message in each.
+	marked map[string]string
+	seenBy map[string][]string
+
+	// bulkPending is the last /bulk request, with the token that confirms
+	// it once the preview is in.
+	bulkPending *protocol.BulkPayload
+
+	// pendingDM is the message to send once the /dm channel is known.
+	pendingDM string
+
+	// User directory, see directory.go: the /members listing, and the
+	// input a Tab completion was requested for ("" when none is).
+	members      memberQuery
+	waitComplete string
+
+	// Channel browser (Ctrl+L), see channels.go, and the channel a /leave
+	// is waiting on.
+	channelList     []protocol.ChannelInfo
+	channelSel      int
+	waitChannelList bool
+	waitLeave       string
+
+	// Notification center (Ctrl+N), see notifications.go.
+	notices    []notice // oldest first
+	noticeSel  int      // list cursor, counted from the newest
+	noticePath string   // where notices are persisted; "" when unknown
+	jump       pendingJump
+
+	// Received messages, kept across restarts; see cache.go.
+	cache *msgCache
+
+	// File transfer: the cached bearer token for the HTTP file service and
+	// the transfer waiting for a fresh one.
+	fileToken   *protocol.FileTokenPayload
+	pendingFile fileTransfer
+
+	// next is a command queued by a packet handler, run after the packet.
+	next tea.Cmd
+
+	toast toast // error on show over the chat, see toast.go
 
 	width, height int
 }
 
-func newModel(conn net.Conn, pkts chan []byte) model {
-	// --- login fields ---
+// newLoginFields returns the username and password inputs of a login form,
+// the username focused.
+func newLoginFields() [2]textinput.Model {
 	uf := textinput.New()
 	uf.Placeholder = "username"
 	uf.Focus()
@@ -159,14 +344,17 @@ func newModel(conn net.Conn, pkts chan []byte) model {
 	pf.EchoCharacter = '•'
 	pf.CharLimit = 64
 	pf.Width = 32
+	return [2]textinput.Model{uf, pf}
+}
 
+func newModel(conn net.Conn, pkts chan []byte) model {
 	// --- chat input ---
 	ci := textinput.New()
 	ci.Placeholder = "Type a message…"
 	ci.CharLimit = 500
 
 	// --- search fields ---
-	labels := []string{"content substring", "username (exact)", "YYYY-MM-DD", "YYYY-MM-DD"}
+	labels := []string{"words, \"phrase\", OR, -exclude", "username (exact)", "YYYY-MM-DD", "YYYY-MM-DD"}
 	var sf [4]textinput.Model
 	for i := range sf {
 		f := textinput.New()
@@ -175,14 +363,20 @@ func newModel(conn net.Conn, pkts chan []byte) model {
 		f.Width = 36
 		sf[i] = f
 	}
+	sf[0].CharLimit = 256
 
 	return model{
 		conn:         conn,
 		pkts:         pkts,
 		state:        stateLogin,
-		loginFields:  [2]textinput.Model{uf, pf},
+		loginFields:  newLoginFields(),
 		chatInput:    ci,
 		searchFields: sf,
+		seqs:         make(map[string]uint64),
+		marked:       make(map[string]string),
+		convs:        map[string]*convView{protocol.MainChannel: {loaded: true}},
+		muted:        make(map[string]bool),
+		spinner:      spinner.New(spinner.WithSpinner(spinner.MiniDot), spinner.WithStyle(hintStyle)),
 	}
 }
 
@@ -191,7 +385,7 @@ func newModel(conn net.Conn, pkts chan []byte) model {
 // ---------------------------------------------------------------------------
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(textinput.Blink, waitForPkt(m.pkts))
+	return tea.Batch(textinput.Blink, waitForPkt(m.pkts), pingTick())
 }
 
 // ---------------------------------------------------------------------------
@@ -199,30 +393,116 @@ func (m model) Init() tea.Cmd {
 // ---------------------------------------------------------------------------
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+	if nm, ok := next.(model); ok {
+		return nm.armToast(cmd)
+	}
+	return next, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 
 	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
-		if !m.ready {
-			m.viewport = viewport.New(msg.Width, m.vpHeight())
-			m.ready = true
-		} else {
-			m.viewport.Width = msg.Width
-			m.viewport.Height = m.vpHeight()
-		}
-		m.chatInput.Width = msg.Width - 4
+		m.resize(msg.Width, msg.Height)
 		return m, nil
 
 	case serverPktMsg:
-		m = m.handleServerPkt([]byte(msg))
-		return m, waitForPkt(m.pkts)
+		if msg.src != m.pkts {
+			return m, nil // left over from a connection we switched away from
+		}
+		m = m.handleServerPkt(msg.data)
+		next := m.next
+		m.next = nil
+		return m, tea.Batch(waitForPkt(m.pkts), next)
+
+	case connectedMsg:
+		return m.switchProfile(msg)
+
+	case connectFailedMsg:
+		m.fail(fmt.Sprintf("connect %s: %v", msg.name, msg.err))
+		return m, nil
+
+	case uploadDoneMsg:
+		if msg.err != nil {
+			m.fail("upload failed: " + msg.err.Error())
+			return m, nil
+		}
+		p := protocol.ChatPayload{
+			Content:      msg.caption,
+			AttachmentID: msg.att.ID,
+			Channel:      msg.channel,
+		}
+		if msg.clip != nil {
+			p.Kind = protocol.KindClip
+			p.Meta, _ = json.Marshal(msg.clip)
+		}
+		sendPkt(m.conn, protocol.TypeChat, p)
+		return m, nil
+
+	case workDoneMsg:
+		if msg.conn != m.conn || m.state != stateLogin {
+			return m, nil // the connection changed while solving
+		}
+		sendPkt(m.conn, protocol.TypeRegister, msg.auth)
+		m.statusMsg = "Authenticating…"
+		return m, nil
+
+	case playDoneMsg:
+		if msg.err != nil {
+			m.fail("playback failed: " + msg.err.Error())
+		}
+		return m, nil
+
+	case downloadDoneMsg:
+		if msg.err != nil {
+			m.fail("download failed: " + msg.err.Error())
+		} else {
+			m.appendChat(successStyle.Render(fmt.Sprintf("✓ saved %s (%s)", msg.path, humanSize(msg.size))))
+		}
+		return m, nil
 
 	case disconnectedMsg:
-		m.statusMsg = "disconnected from server"
-		return m, tea.Quit
+		if msg.src != m.pkts {
+			return m, nil
+		}
+		if m.state == stateLogin {
+			m.statusMsg = "disconnected from server"
+			return m, tea.Quit
+		}
+		return m.disconnect("connection to " + m.addr + " lost"), nil
+
+	case spinner.TickMsg:
+		if !m.loadingOlder {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		m.refreshChat()
+		return m, cmd
+
+	case typingExpiredMsg:
+		m.expireTyping()
+		return m, nil
+
+	case cooldownTickMsg:
+		return m.coolDown()
+
+	case pingTickMsg:
+		sendPkt(m.conn, protocol.TypePing, protocol.PingPayload{ClientTime: time.Now()})
+		m.flushJoins(false)
+		return m, pingTick()
+
+	case toastExpiredMsg:
+		if msg.id == m.toast.id {
+			m.toast = toast{id: m.toast.id}
+		}
+		return m, nil
 
 	case tea.KeyMsg:
+		if msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			m.keepOpen()
+		}
 		switch m.state {
 		case stateLogin:
 			return m.handleLoginKey(msg)
@@ -230,15 +510,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.handleChatKey(msg)
 		case stateSearch:
 			return m.handleSearchKey(msg)
+		case stateNotices:
+			return m.handleNoticesKey(msg)
+		case stateChannels:
+			return m.handleChannelsKey(msg)
 		}
 	}
 	return m, nil
 }
 
+// resize lays the chat view out for a w×h terminal.
+func (m *model) resize(w, h int) {
+	m.width = w
+	m.height = h
+	vw := w
+	if m.showConvs {
+		vw -= convPanelWidth
+	}
+	if !m.ready {
+		m.viewport = viewport.New(vw, m.vpHeight())
+		m.ready = true
+	} else {
+		m.viewport.Width = vw
+		m.viewport.Height = m.vpHeight()
+	}
+	m.chatInput.Width = w - 4
+}
+
 // vpHeight returns the number of lines available for the chat viewport.
 func (m model) vpHeight() int {
-	// header (1) + footer border (1) + footer input (1) = 3 lines reserved
-	h := m.height - 3
+	// header (1) + footer border (1) + typing line (1) + footer input (1)
+	// = 4 lines reserved
+	h := m.height - 4
 	if h < 1 {
 		h = 1
 	}
@@ -270,10 +573,33 @@ func (m model) handleLoginKey(msg tea.KeyMsg) (model, tea.Cmd) {
 		return m, textinput.Blink
 
 	case tea.KeyCtrlR:
+		if !m.supports(protocol.FeatureRegister) {
+			m.statusMsg = "this server does not allow registration"
+			return m, nil
+		}
 		m.loginIsReg = !m.loginIsReg
 		m.statusMsg = ""
 		return m, nil
 
+	case tea.KeyCtrlO:
+		// Unlock a locked account: with the password field empty, ask for
+		// a code; with the code typed there, redeem it.
+		if !m.supports(protocol.FeatureUnlock) {
+			m.statusMsg = "this server does not offer self-service unlock"
+			return m, nil
+		}
+		user := strings.TrimSpace(m.loginFields[0].Value())
+		if user == "" {
+			m.statusMsg = "type the username of the locked account first"
+			return m, nil
+		}
+		code := strings.TrimSpace(m.loginFields[1].Value())
+		sendPkt(m.conn, protocol.TypeUnlock, protocol.UnlockPayload{Username: user, Code: code})
+		m.waitUnlock = true
+		m.unlockRedeem = code != ""
+		m.loginFields[1].SetValue("")
+		return m, nil
+
 	case tea.KeyEnter:
 		user := strings.TrimSpace(m.loginFields[0].Value())
 		pass := m.loginFields[1].Value()
@@ -282,10 +608,9 @@ func (m model) handleLoginKey(msg tea.KeyMsg) (model, tea.Cmd) {
 			return m, nil
 		}
 		if m.loginIsReg {
-			sendPkt(m.conn, protocol.TypeRegister, protocol.AuthPayload{Username: user, Password: pass})
-		} else {
-			sendPkt(m.conn, protocol.TypeLogin, protocol.AuthPayload{Username: user, Password: pass})
+			return m.register(m.auth(protocol.AuthPayload{Username: user, Password: pass})), nil
 		}
+		sendPkt(m.conn, protocol.TypeLogin, m.auth(protocol.AuthPayload{Username: user, Password: pass}))
 		m.statusMsg = "Authenticating…"
 		return m, nil
 	}
@@ -299,8 +624,11 @@ func (m model) handleLoginKey(msg tea.KeyMsg) (model, tea.Cmd) {
 func (m model) handleChatKey(msg tea.KeyMsg) (model, tea.Cmd) {
 	switch msg.Type {
 	case tea.KeyCtrlC, tea.KeyCtrlQ:
-		sendPkt(m.conn, protocol.TypeQuit, map[string]string{})
-		return m, tea.Quit
+		return m.requestQuit()
+
+	case tea.KeyEsc:
+		m.toast = toast{id: m.toast.id}
+		return m, nil
 
 	case tea.KeyCtrlF:
 		// Open search overlay.
@@ -314,33 +642,92 @@ func (m model) handleChatKey(msg tea.KeyMsg) (model, tea.Cmd) {
 		}
 		return m, textinput.Blink
 
+	case tea.KeyCtrlN:
+		m.state = stateNotices
+		m.noticeSel = 0
+		m.chatInput.Blur()
+		return m, nil
+
+	case tea.KeyCtrlL:
+		if m.supports(protocol.FeatureChannels) && m.conn != nil {
+			return m.openChannelBrowser()
+		}
+		m.showConvs = !m.showConvs
+		m.resize(m.width, m.height)
+		return m, nil
+
+	case tea.KeyTab:
+		if m, ok := m.completeUser(); ok {
+			return m, nil
+		}
+		return m.cycleConversation(1)
+
+	case tea.KeyShiftTab:
+		return m.cycleConversation(-1)
+
 	case tea.KeyEnter:
 		content := strings.TrimSpace(m.chatInput.Value())
+		if strings.HasPrefix(content, "/") {
+			m.chatInput.Reset()
+			return m.runCommand(content)
+		}
+		if content != "" && m.conn == nil {
+			m.warn("not connected — /connect to reconnect")
+			return m, nil
+		}
+		if content != "" && m.archived() {
+			m.warn(channelLabel(m.channel) + " is archived: it can be read but not posted in")
+			return m, nil
+		}
+		if content != "" && m.readOnly() {
+			m.warn(channelLabel(m.channel) + " is read-only: only its creator and moderators can post")
+			return m, nil
+		}
+		if content != "" && m.coolingDown() {
+			return m, nil // keep the text; the footer says how long to wait
+		}
 		if content != "" {
-			sendPkt(m.conn, protocol.TypeChat, protocol.ChatPayload{Content: content})
+			if err := m.sendChat(protocol.ChatPayload{Content: content, Channel: m.channel}); err != nil {
+				m.fail("send failed: " + err.Error())
+				return m, nil // keep the text to try again
+			}
 			m.chatInput.Reset()
+			return m, nil
+		}
+		if m.viewport.AtTop() {
+			return m.loadOlder()
 		}
 		return m, nil
 
 	case tea.KeyPgUp:
 		m.viewport.HalfViewUp()
+		if m.viewport.AtTop() {
+			return m.loadOlder()
+		}
 		return m, nil
 
 	case tea.KeyPgDown:
 		m.viewport.HalfViewDown()
 		return m, nil
+
+	case tea.KeyRunes:
+		// In a read-only channel the input takes commands only.
+		if m.readOnly() && m.chatInput.Value() == "" && len(msg.Runes) > 0 && msg.Runes[0] != '/' {
+			return m, nil
+		}
 	}
 
 	var cmd tea.Cmd
+	before := m.chatInput.Value()
 	m.chatInput, cmd = m.chatInput.Update(msg)
+	m.noteTyping(before)
 	return m, cmd
 }
 
 func (m model) handleSearchKey(msg tea.KeyMsg) (model, tea.Cmd) {
 	switch msg.Type {
 	case tea.KeyCtrlC:
-		sendPkt(m.conn, protocol.TypeQuit, map[string]string{})
-		return m, tea.Quit
+		return m.requestQuit()
 
 	case tea.KeyEsc:
 		// Close search, return to chat.
@@ -370,6 +757,27 @@ func (m model) handleSearchKey(msg tea.KeyMsg) (model, tea.Cmd) {
 		}
 		return m, textinput.Blink
 
+	case tea.KeyCtrlT:
+		if m.supports(protocol.FeatureFuzzySearch) {
+			m.searchFuzzy = !m.searchFuzzy
+		}
+		return m, nil
+
+	case tea.KeyCtrlG:
+		if m.supports(protocol.FeatureSearchScope) {
+			m.searchAll = !m.searchAll
+		}
+		return m, nil
+
+	case tea.KeyCtrlO:
+		if m.supports(protocol.FeatureSearchSort) {
+			m.searchSort = (m.searchSort + 1) % len(searchSorts)
+		}
+		return m, nil
+
+	case tea.KeyCtrlS:
+		return m.exportSearch()
+
 	case tea.KeyEnter:
 		return m.executeSearch()
 	}
@@ -384,6 +792,14 @@ func (m model) executeSearch() (model, tea.Cmd) {
 	p := protocol.SearchPayload{
 		Query:    strings.TrimSpace(m.searchFields[0].Value()),
 		Username: strings.TrimSpace(m.searchFields[1].Value()),
+		Fuzzy:    m.searchFuzzy,
+	}
+	if m.supports(protocol.FeatureSearchScope) {
+		p.Channel = m.channel
+		p.AllChannels = m.searchAll
+	}
+	if m.supports(protocol.FeatureSearchSort) {
+		p.Sort = searchSorts[m.searchSort]
 	}
 
 	fromStr := strings.TrimSpace(m.searchFields[2].Value())
@@ -429,42 +845,180 @@ func (m model) handleServerPkt(data []byte) model {
 	if err := json.Unmarshal(data, &pkt); err != nil {
 		return m
 	}
+	return m.handlePacket(&pkt)
+}
 
+func (m model) handlePacket(pkt *protocol.Packet) model {
 	switch pkt.Type {
 
+	case protocol.TypeBatch:
+		var b protocol.BatchPayload
+		if err := json.Unmarshal(pkt.Payload, &b); err != nil {
+			return m
+		}
+		m = m.applyBatch(b)
+		m.markRead()
+
+	case protocol.TypeHello:
+		var h protocol.HelloPayload
+		if err := json.Unmarshal(pkt.Payload, &h); err != nil {
+			return m
+		}
+		m.hello = &h
+		if !m.skewKnown && !h.ServerTime.IsZero() {
+			// One-way estimate; the first pong replaces it with an
+			// RTT-corrected value.
+			m.skew = time.Until(h.ServerTime)
+		}
+		if h.Limits.MaxContentLength > 0 {
+			m.chatInput.CharLimit = h.Limits.MaxContentLength
+		}
+		if !h.HasFeature(protocol.FeatureRegister) {
+			m.loginIsReg = false
+		}
+		if h.Maintenance != "" {
+			m.appendChat(sysStyle.Render("🔧 the server is read-only: " + h.Maintenance))
+		} else if w := h.MaintenanceWindow; w != nil {
+			m.appendChat(sysStyle.Render("🔧 maintenance is scheduled for " + w.Start.Local().Format("Mon 2006-01-02 15:04") + ": " + w.Reason))
+		}
+
+	case protocol.TypePong:
+		var p protocol.PongPayload
+		if err := json.Unmarshal(pkt.Payload, &p); err != nil || p.ClientTime.IsZero() {
+			return m
+		}
+		now := time.Now()
+		m.rtt = now.Sub(p.ClientTime)
+		m.lag = m.rtt
+		m.skew = p.ServerTime.Sub(p.ClientTime.Add(m.rtt / 2))
+		m.skewKnown = true
+
 	case protocol.TypeBroadcast:
 		var b protocol.BroadcastPayload
 		if err := json.Unmarshal(pkt.Payload, &b); err != nil {
 			return m
 		}
-		ts := tsStyle.Render("[" + b.Timestamp.Local().Format("15:04:05") + "]")
-		var name string
-		if b.Username == m.me {
-			name = myNameStyle.Render(b.Username)
-		} else {
-			name = peerStyle.Render(b.Username)
+		m.receive(b)
+
+	case protocol.TypeSent:
+		var s protocol.SentPayload
+		if err := json.Unmarshal(pkt.Payload, &s); err != nil {
+			return m
+		}
+		m.confirmSent(s)
+		m.chatTaken(s.Ref, s.Message)
+		m.receive(s.Message)
+
+	case protocol.TypePoll:
+		var p protocol.Poll
+		if err := json.Unmarshal(pkt.Payload, &p); err != nil {
+			return m
+		}
+		m.showPoll(p)
+
+	case protocol.TypeChannelMode:
+		var info protocol.ChannelInfo
+		if err := json.Unmarshal(pkt.Payload, &info); err != nil {
+			return m
+		}
+		m = m.channelMode(info)
+
+	case protocol.TypeExportStatus:
+		m.showExportStatus(pkt.Payload)
+
+	case protocol.TypeTyping:
+		var t protocol.TypingPayload
+		if err := json.Unmarshal(pkt.Payload, &t); err != nil {
+			return m
+		}
+		m.showTyping(t)
+
+	case protocol.TypeMention:
+		var p protocol.MentionPayload
+		if err := json.Unmarshal(pkt.Payload, &p); err != nil {
+			return m
 		}
-		m.appendChat(ts + " " + name + ": " + b.Content)
+		m.showMention(p)
+
+	case protocol.TypeUserList:
+		var l protocol.UserListPayload
+		if err := json.Unmarshal(pkt.Payload, &l); err != nil {
+			return m
+		}
+		m.setRoster(l)
+
+	case protocol.TypeUserJoined, protocol.TypeUserLeft:
+		var r protocol.RosterPayload
+		if err := json.Unmarshal(pkt.Payload, &r); err != nil {
+			return m
+		}
+		m.updateRoster(pkt.Type, r)
+
+	case protocol.TypeRosterDiff:
+		var d protocol.RosterDiffPayload
+		if err := json.Unmarshal(pkt.Payload, &d); err != nil {
+			return m
+		}
+		m.applyRosterDiff(d)
+
+	case protocol.TypeRead:
+		var r protocol.ReadPayload
+		if err := json.Unmarshal(pkt.Payload, &r); err != nil {
+			return m
+		}
+		m.showRead(r)
+
+	case protocol.TypeDeleted:
+		var d protocol.DeletedPayload
+		if err := json.Unmarshal(pkt.Payload, &d); err != nil {
+			return m
+		}
+		m.showDeleted(d)
+
+	case protocol.TypeTranslation:
+		var t protocol.TranslationPayload
+		if err := json.Unmarshal(pkt.Payload, &t); err != nil {
+			return m
+		}
+		m.showTranslation(t)
+
+	case protocol.TypeGap:
+		var g protocol.GapPayload
+		if err := json.Unmarshal(pkt.Payload, &g); err != nil {
+			return m
+		}
+		m.appendChat(errorStyle.Render(fmt.Sprintf("⚠ %d message(s) missed while your connection was behind", g.Skipped)))
 
 	case protocol.TypeSystem:
-		var sys map[string]string
+		var sys protocol.SystemPayload
 		if err := json.Unmarshal(pkt.Payload, &sys); err != nil {
 			return m
 		}
-		msg := sys["message"]
-		m.appendChat(sysStyle.Render("⚡ " + msg))
-		// Track rough online count from join/leave announcements.
-		if strings.HasSuffix(msg, "joined the chat") {
-			m.onlineCount++
-		} else if strings.HasSuffix(msg, "left the chat") && m.onlineCount > 0 {
-			m.onlineCount--
+		msg := sys.Message
+		if sys.Kind == protocol.SystemAnnouncement || sys.Kind == "" && sys.Announcement {
+			m.appendChat(sysStyle.Bold(true).Render("📢 " + protocol.ServerName + ": " + msg))
+			return m
+		}
+		if joined, left, ok := presenceCounts(sys); ok {
+			m.showPresence(sys, joined, left)
+			return m
 		}
+		if sys.Kind == protocol.SystemModeration {
+			m.showModeration(sys)
+			return m
+		}
+		m.appendChat(sysStyle.Render("⚡ " + msg))
 
 	case protocol.TypeResponse:
 		var r protocol.ResponsePayload
 		if err := json.Unmarshal(pkt.Payload, &r); err != nil {
 			return m
 		}
+		if r.Code == protocol.ErrRateLimited {
+			m.rateLimited(r)
+			return m
+		}
+		m.chatRefused(r)
 
 		// ---- auth success ----
 		if r.Success && (strings.Contains(r.Message, "logged in as") ||
@@ -472,10 +1026,49 @@ func (m model) handleServerPkt(data []byte) model {
 			m.me = extractQuoted(r.Message)
 			m.state = stateChat
 			m.chatInput.Focus()
-			// Request recent history right away.
-			sendPkt(m.conn, protocol.TypeHistory, protocol.HistoryPayload{Limit: 50})
-			m.waitHistory = true
-			m.onlineCount = 1
+			var sess protocol.SessionPayload
+			if json.Unmarshal(r.Data, &sess) == nil && sess.Limits != nil && m.hello != nil {
+				m.hello.Limits = *sess.Limits // this account's, by role
+				m.chatInput.CharLimit = sess.Limits.MaxContentLength
+			}
+			if sess.Token != "" {
+				m.session = sess
+			}
+			m.noticePath = noticesPath(m.addr, m.me)
+			m.seqs, m.gaps = make(map[string]uint64), nil
+			if list, err := loadNotices(m.noticePath); err != nil {
+				m.fail("notifications: " + err.Error())
+			} else {
+				m.notices = list
+			}
+			var err error
+			if m.cache, err = loadCache(cachePath(m.addr, m.me)); err != nil {
+				m.fail("message cache: " + err.Error())
+			}
+			// Show the cached scrollback, or request recent history
+			// right away.
+			if !m.restoreCached() {
+				sendPkt(m.conn, protocol.TypeHistory, protocol.HistoryPayload{
+					Limit: 50,
+					Batch: m.supports(protocol.FeatureBatch),
+				})
+				m.waitHistory = true
+			}
+			if m.supports(protocol.FeatureDM) {
+				sendPkt(m.conn, protocol.TypeConversations, map[string]string{})
+				m.waitConvs = true
+			}
+			if m.supports(protocol.FeatureMute) {
+				sendPkt(m.conn, protocol.TypePreferences, map[string]string{})
+				m.waitPrefs = true
+			}
+			if m.supports(protocol.FeatureReads) {
+				sendPkt(m.conn, protocol.TypeUnread, map[string]string{})
+				m.waitUnread = true
+			}
+			if !m.supports(protocol.FeatureRoster) {
+				m.onlineCount = 1
+			}
 			return m
 		}
 
@@ -502,42 +1095,443 @@ func (m model) handleServerPkt(data []byte) model {
 			m.waitHistory = false
 			var msgs []protocol.StoredMessage
 			if err := json.Unmarshal(r.Data, &msgs); err == nil && len(msgs) > 0 {
-				lines := make([]string, 0, len(msgs))
+				lines := make([]chatLine, 0, len(msgs))
 				for _, msg := range msgs {
-					ts := tsStyle.Render("[" + msg.Timestamp.Local().Format("15:04:05") + "]")
-					var name string
-					if msg.Username == m.me {
-						name = myNameStyle.Render(msg.Username)
-					} else {
-						name = peerStyle.Render(msg.Username)
+					b := protocol.BroadcastPayload{
+						ID:         msg.ID,
+						Channel:    msg.Channel,
+						UserID:     msg.UserID,
+						Username:   msg.Username,
+						Content:    msg.Content,
+						Timestamp:  msg.Timestamp,
+						Reply:      msg.Reply,
+						Attachment: msg.Attachment,
+						Kind:       msg.Kind,
+						Meta:       msg.Meta,
+						Via:        msg.Via,
+						Sig:        msg.Sig,
+						Origin:     msg.Origin,
 					}
-					lines = append(lines, ts+" "+name+": "+msg.Content)
+					m.remember(b)
+					lines = append(lines, m.messageLine(b))
 				}
 				// Prepend history before any live messages that may have arrived.
-				m.chatLines = append(lines, m.chatLines...)
-				m.viewport.SetContent(strings.Join(m.chatLines, "\n"))
+				m.scroll.insert(0, lines)
+				m.refreshChat()
 				m.viewport.GotoBottom()
 			}
 			return m
 		}
 
+		// ---- sessions listing ----
+		if m.waitSessions {
+			m.waitSessions = false
+			if r.Success {
+				var sessions []protocol.SessionInfo
+				json.Unmarshal(r.Data, &sessions)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderSessions(sessions)
+				return m
+			}
+		}
+
+		// ---- scheduled messages listing ----
+		if m.waitScheduled {
+			m.waitScheduled = false
+			if r.Success {
+				var pending []protocol.ScheduledMessage
+				json.Unmarshal(r.Data, &pending)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderScheduled(pending)
+				return m
+			}
+		}
+
+		// ---- traffic report ----
+		if m.waitUsage {
+			m.waitUsage = false
+			if r.Success {
+				var report protocol.UsageReport
+				json.Unmarshal(r.Data, &report)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderUsage(report)
+				return m
+			}
+		}
+
+		// ---- activity statistics ----
+		if m.waitStats {
+			m.waitStats = false
+			if r.Success {
+				var report protocol.StatsReport
+				json.Unmarshal(r.Data, &report)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderStats(report)
+				return m
+			}
+		}
+
+		// ---- DM conversation list / a /dm channel ----
+		if m.waitConvs && r.Success {
+			m.waitConvs = false
+			var list []protocol.ConversationInfo
+			json.Unmarshal(r.Data, &list)
+			m.setConversations(list)
+			return m
+		}
+		if m.waitOpenDM {
+			m.waitOpenDM = false
+			text := m.pendingDM
+			m.pendingDM = ""
+			if r.Success {
+				var info protocol.ConversationInfo
+				if err := json.Unmarshal(r.Data, &info); err == nil {
+					m.setConversations([]protocol.ConversationInfo{info})
+					m, _ = m.openConversation(info.Channel)
+					if text != "" {
+						sendPkt(m.conn, protocol.TypeChat, protocol.ChatPayload{Content: text, Channel: info.Channel})
+					}
+				}
+				return m
+			}
+		}
+
+		// ---- registration challenge ----
+		if m.waitChallenge != nil {
+			auth := *m.waitChallenge
+			m.waitChallenge = nil
+			var solve tea.Cmd
+			m.statusMsg, solve = solveChallenge(m.conn, auth, r)
+			m.next = tea.Batch(m.next, solve)
+			return m
+		}
+
+		// ---- account unlock ----
+		if m.waitUnlock {
+			m.waitUnlock = false
+			switch {
+			case !r.Success:
+				m.statusMsg = r.Message
+			case m.unlockRedeem:
+				m.statusMsg = "✓ " + r.Message
+			default:
+				m.statusMsg = "✓ " + r.Message + "; type it as the password and press Ctrl+O"
+			}
+			return m
+		}
+
+		// ---- relay grants ----
+		if m.waitRelay {
+			m.waitRelay = false
+			if r.Success {
+				var grants []protocol.RelayGrant
+				json.Unmarshal(r.Data, &grants)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderRelayGrants(grants)
+				return m
+			}
+		}
+
+		// ---- message revisions ----
+		if m.waitRevisions {
+			m.waitRevisions = false
+			if r.Success {
+				var revs []protocol.MessageRevision
+				json.Unmarshal(r.Data, &revs)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderRevisions(revs)
+				return m
+			}
+		}
+
+		// ---- held messages ----
+		if m.waitHeld {
+			m.waitHeld = false
+			if r.Success {
+				var held []protocol.HeldMessage
+				json.Unmarshal(r.Data, &held)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderHeld(held)
+				return m
+			}
+		}
+
+		// ---- bulk moderation ----
+		if m.waitBulk {
+			m.waitBulk = false
+			if r.Success {
+				m.bulkAnswered(r)
+				return m
+			}
+			m.bulkPending = nil
+		}
+
+		// ---- preferences / a /mute or /locale ----
+		if m.waitPrefs || m.waitMute || m.waitLocale {
+			ack := m.waitMute || m.waitLocale
+			m.waitPrefs, m.waitMute, m.waitLocale = false, false, false
+			if r.Success {
+				var p protocol.Preferences
+				json.Unmarshal(r.Data, &p)
+				m.setPreferences(p)
+				if ack {
+					m.appendChat(successStyle.Render("✓ " + r.Message))
+				}
+				return m
+			}
+		}
+
+		// ---- read receipts ----
+		if m.waitUnread {
+			m.waitUnread = false
+			if r.Success {
+				var marks []protocol.ReadMark
+				json.Unmarshal(r.Data, &marks)
+				m.showUnread(marks)
+				return m
+			}
+		}
+		if m.waitReaders {
+			m.waitReaders = false
+			if r.Success {
+				var res protocol.ReadersPayload
+				json.Unmarshal(r.Data, &res)
+				m.showReaders(res)
+				return m
+			}
+		}
+
+		// ---- public channels ----
+		if m.waitChannelList {
+			m.waitChannelList = false
+			if r.Success {
+				json.Unmarshal(r.Data, &m.channelList)
+				return m
+			}
+		}
+		if m.waitJoin {
+			m.waitJoin = false
+			if r.Success {
+				var info protocol.ChannelInfo
+				if err := json.Unmarshal(r.Data, &info); err == nil {
+					m = m.joined(info)
+				}
+				m.appendChat(successStyle.Render("✓ " + r.Message))
+				return m
+			}
+		}
+		if m.waitLeave != "" {
+			ch := m.waitLeave
+			m.waitLeave = ""
+			if r.Success {
+				m = m.left(ch)
+				m.appendChat(successStyle.Render("✓ " + r.Message))
+				return m
+			}
+		}
+
+		// ---- user directory ----
+		if m.waitComplete != "" {
+			input := m.waitComplete
+			m.waitComplete = ""
+			if r.Success {
+				var res protocol.UserSearchResult
+				json.Unmarshal(r.Data, &res)
+				m.finishCompletion(input, res)
+				return m
+			}
+		}
+		if m.waitMembers {
+			m.waitMembers = false
+			if r.Success {
+				var res protocol.UserSearchResult
+				json.Unmarshal(r.Data, &res)
+				m.appendChat(successStyle.Render(r.Message))
+				m.renderMembers(res)
+				return m
+			}
+		}
+
+		// ---- file token for an upload/download ----
+		if m.waitFileToken {
+			m.waitFileToken = false
+			op := m.pendingFile
+			m.pendingFile = nil
+			if r.Success {
+				var t protocol.FileTokenPayload
+				if err := json.Unmarshal(r.Data, &t); err == nil && op != nil {
+					m.fileToken = &t
+					m.next = op(t.Token)
+				}
+				return m
+			}
+		}
+
 		// ---- auth failure or other server error ----
 		if !r.Success {
+			if m.loadingOlder {
+				m.loadingOlder = false
+				m.refreshChat()
+			}
 			if m.state == stateLogin {
 				m.statusMsg = r.Message
 			} else {
-				m.appendChat(errorStyle.Render("⚠ " + r.Message))
+				m.fail(strings.TrimPrefix(r.Message, "error: "))
 			}
+		} else if m.state != stateLogin {
+			// Plain acknowledgement of a slash command.
+			m.appendChat(successStyle.Render("✓ " + r.Message))
+		}
+	}
+	return m
+}
+
+// applyBatch applies every packet in b and redraws the viewport once at the
+// end.  History batches are spliced in front of any live messages that
+// arrived while the request was in flight; older-history batches in front
+// of everything already shown.
+func (m model) applyBatch(b protocol.BatchPayload) model {
+	if b.Reason == protocol.BatchGap {
+		return m.fillGap(b)
+	}
+	prepend := b.Reason == protocol.BatchHistory || b.Reason == protocol.BatchOlder
+	if prepend && b.Channel != m.channel {
+		// The user switched away while the history was on its way; apply
+		// it to the parked conversation.
+		cur := m.channel
+		m.swapView(b.Channel)
+		m = m.applyBatch(b)
+		m.swapView(cur)
+		return m
+	}
+	var live scrollback
+	recent := m.recent
+	if prepend {
+		live, m.scroll = m.scroll, scrollback{}
+	}
+
+	m.batching, m.replaying = true, prepend
+	for i := range b.Packets {
+		m = m.handlePacket(&b.Packets[i])
+	}
+	m.batching, m.replaying = false, false
+
+	if !prepend {
+		m.refreshChat()
+		m.viewport.GotoBottom()
+		return m
+	}
+
+	older := m.scroll.lines
+	m.scroll = live
+	added := m.scroll.insert(0, older)
+	if len(b.Packets) > 0 {
+		var first protocol.BroadcastPayload
+		if json.Unmarshal(b.Packets[0].Payload, &first) == nil {
+			m.oldestID = first.ID
 		}
 	}
+	m.hasOlder = b.More
+	m.refreshChat()
+
+	switch b.Reason {
+	case protocol.BatchHistory:
+		m.waitHistory = false
+		m.viewport.GotoBottom()
+	case protocol.BatchOlder:
+		// Older messages are not /reply candidates, and the view stays on
+		// the line the user was reading.
+		m.recent = recent
+		m.loadingOlder = false
+		m.viewport.SetYOffset(added)
+	}
+	m, m.next = m.resolveJump()
 	return m
 }
 
+// maxRecent bounds how many received messages are kept for /reply lookups.
+const maxRecent = 500
+
+// remember records b so /reply can find it later.
+func (m *model) remember(b protocol.BroadcastPayload) {
+	m.recent = append(m.recent, b)
+	if len(m.recent) > maxRecent {
+		m.recent = m.recent[len(m.recent)-maxRecent:]
+	}
+}
+
+// renderMessage formats a chat message, preceded by a quote line when it is
+// a reply and followed by an attachment line when a file is attached.
+func (m model) renderMessage(b protocol.BroadcastPayload) string {
+	ts := tsStyle.Render("[" + b.Timestamp.Local().Format("15:04:05") + "]")
+	if b.Username != m.me && mentions(b.Content, m.me) {
+		ts = mentionStyle.Render("[" + b.Timestamp.Local().Format("15:04:05") + "]")
+	}
+	var name string
+	if b.Username == m.me {
+		name = myNameStyle.Render(b.Username)
+	} else {
+		name = peerStyle.Render(senderName(b))
+	}
+	line := ts + " " + name + ": " + renderBody(b)
+	if b.Reply != nil {
+		line = quoteStyle.Render("           ┌ "+b.Reply.Username+": "+b.Reply.Excerpt) + "\n" + line
+	}
+	if b.Attachment != nil {
+		line += "\n" + renderAttachment(b.Attachment)
+	}
+	return line
+}
+
+// receive takes in the message b, broadcast or acknowledged.
+func (m *model) receive(b protocol.BroadcastPayload) {
+	m.remember(b)
+	m.stopTyping(b.Channel, b.Username)
+	m.chatEchoed(b)
+	if !m.replaying {
+		m.chatTaken("", b)
+	}
+	if strings.EqualFold(b.Username, m.me) && !m.replaying {
+		delete(m.seenBy, b.Channel)
+	}
+	if b.Channel == m.channel && !m.batching {
+		m.markRead()
+	}
+	if !m.replaying {
+		m.notify(b)
+	}
+	if b.Channel == m.channel || m.conv(b.Channel).loaded {
+		m.cacheMessage(b)
+	}
+	m.trackSeq(b)
+	if b.Channel != m.channel {
+		m.deliverElsewhere(b)
+		return
+	}
+	m.conv(b.Channel).lastAt = b.Timestamp
+	m.showMessage(b)
+}
+
 // appendChat adds a rendered line and scrolls the viewport to the bottom.
+// While a batch is being applied the redraw is left to applyBatch.
 func (m *model) appendChat(line string) {
-	m.chatLines = append(m.chatLines, line)
-	m.viewport.SetContent(strings.Join(m.chatLines, "\n"))
-	m.viewport.GotoBottom()
+	m.appendEntry(chatLine{text: line})
+}
+
+// showMessage adds b to the scrollback, or redraws it where it already is.
+func (m *model) showMessage(b protocol.BroadcastPayload) {
+	m.appendEntry(m.messageLine(b))
+}
+
+func (m *model) appendEntry(l chatLine) {
+	added := m.scroll.add(l)
+	if m.batching {
+		return
+	}
+	m.refreshChat()
+	if added {
+		m.viewport.GotoBottom()
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -552,6 +1546,10 @@ func (m model) View() string {
 		return m.viewChat()
 	case stateSearch:
 		return m.viewSearch()
+	case stateNotices:
+		return m.viewNotices()
+	case stateChannels:
+		return m.viewChannels()
 	}
 	return ""
 }
@@ -579,13 +1577,21 @@ func (m model) viewLogin() string {
 		return lbl + "  " + f.View()
 	}
 
+	keys := fmt.Sprintf("Tab: switch field   Enter: %s   Ctrl+R: switch to %s", mode, other)
+	if !m.supports(protocol.FeatureRegister) {
+		keys = fmt.Sprintf("Tab: switch field   Enter: %s", mode)
+	}
+	if m.supports(protocol.FeatureUnlock) {
+		keys += "   Ctrl+O: unlock code"
+	}
+
 	form := lipgloss.JoinVertical(lipgloss.Left,
 		title,
 		"",
 		renderField("Username", m.loginFields[0], m.loginFocus == 0),
 		renderField("Password", m.loginFields[1], m.loginFocus == 1),
 		"",
-		hintStyle.Render(fmt.Sprintf("Tab: switch field   Enter: %s   Ctrl+R: switch to %s", mode, other)),
+		hintStyle.Render(keys),
 		hintStyle.Render("Ctrl+C: quit"),
 		"",
 		m.renderStatus(),
@@ -599,16 +1605,49 @@ func (m model) viewChat() string {
 		return "\n  Connecting…"
 	}
 
+	conv := m.convLabel(m.channel)
+	switch {
+	case m.archived():
+		conv += " 🗄 archived"
+	case m.readOnly():
+		conv += " 🔒"
+	}
+	if n := m.unreadTotal(); n > 0 {
+		conv += fmt.Sprintf(" (%d unread elsewhere)", n)
+	}
+	alerts := "Ctrl+N: Alerts"
+	if n := m.unreadNotices(); n > 0 {
+		alerts = fmt.Sprintf("Ctrl+N: Alerts (%d)", n)
+	}
+	online := fmt.Sprintf("%d online", m.onlineCount)
+	if m.conn == nil {
+		online = "disconnected"
+	}
 	hdr := headerStyle.
 		Width(m.width).
-		Render(fmt.Sprintf(" GoChat  ·  %s  ·  %d online  ·  Ctrl+F: Search  PgUp/Dn: Scroll  Ctrl+C: Quit",
-			m.me, m.onlineCount))
-
+		Render(fmt.Sprintf(" GoChat  ·  %s  ·  %s  ·  %s  ·  Ctrl+F: Search  Ctrl+L: Chats  %s  /help  Ctrl+C: Quit",
+			m.who(), conv, online, alerts))
+
+	input := m.chatInput
+	switch {
+	case m.archived():
+		input.Placeholder = "🗄 Archived: the history stays, but nobody posts here (/commands still work)"
+	case m.readOnly():
+		input.Placeholder = "🔒 Read-only: only the creator and moderators post here (/commands still work)"
+	}
+	status := hintStyle.Render(cmp.Or(m.typingLine(), m.seenLine()))
+	if line := m.cooldownLine(); line != "" {
+		status = sysStyle.Render(line)
+	}
 	footer := footerBorderStyle.
 		Width(m.width - 2).
-		Render(m.chatInput.View())
+		Render(m.withStatus(status, m.width-4) + "\n" + spellView(input, m.speller.misspelled(input.Value())))
 
-	return lipgloss.JoinVertical(lipgloss.Left, hdr, m.viewport.View(), footer)
+	body := m.withToast(m.viewport.View(), m.viewport.Width)
+	if m.showConvs {
+		body = lipgloss.JoinHorizontal(lipgloss.Top, m.renderConvPanel(m.viewport.Height), body)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, hdr, body, footer)
 }
 
 func (m model) viewSearch() string {
@@ -639,6 +1678,27 @@ func (m model) viewSearch() string {
 	}
 
 	keyHint := hintStyle.Render("  Tab: next field   Enter: search   Esc: close")
+	if m.supports(protocol.FeatureFuzzySearch) {
+		fuzzy := "off"
+		if m.searchFuzzy {
+			fuzzy = "on"
+		}
+		keyHint = hintStyle.Render("  Tab: next field   Enter: search   Ctrl+T: fuzzy (" + fuzzy + ")   Esc: close")
+	}
+	if m.supports(protocol.FeatureSearchQuery) {
+		keyHint = hintStyle.Render(`  Content syntax: deploy "rolled back"   cats OR dogs   -spam / NOT spam   (a OR b) c`) +
+			"\n" + keyHint
+	}
+	if m.supports(protocol.FeatureSearchScope) {
+		scope := "current conversation (" + m.convLabel(m.channel) + ")"
+		if m.searchAll {
+			scope = "all conversations"
+		}
+		keyHint += "\n" + hintStyle.Render("  Scope: "+scope+"   Ctrl+G: toggle")
+	}
+	if m.supports(protocol.FeatureSearchSort) {
+		keyHint += "\n" + hintStyle.Render("  Order: "+searchSorts[m.searchSort]+"   Ctrl+O: change")
+	}
 	div := divStyle.Render(strings.Repeat("─", m.width))
 
 	// Results section.
@@ -647,7 +1707,7 @@ func (m model) viewSearch() string {
 		resultLines = append(resultLines, "  "+m.searchStatus)
 	}
 	if len(m.searchResults) > 0 {
-		resultLines = append(resultLines, "")
+		resultLines = append(resultLines, hintStyle.Render("  Ctrl+S: save these results to a file"), "")
 		for _, r := range m.searchResults {
 			ts := tsStyle.Render("[" + r.Timestamp.Local().Format("2006-01-02 15:04:05") + "]")
 			var name string
@@ -656,6 +1716,9 @@ func (m model) viewSearch() string {
 			} else {
 				name = peerStyle.Render(r.Username)
 			}
+			if m.searchAll {
+				ts += " " + hintStyle.Render(m.searchLabel(r))
+			}
 			resultLines = append(resultLines, "  "+ts+" "+name+": "+r.Content)
 		}
 	} else if m.searchStatus != "" && !m.waitSearch {
@@ -670,6 +1733,46 @@ func (m model) viewSearch() string {
 	return strings.Join(parts, "\n")
 }
 
+// searchSorts are the result orderings Ctrl+O cycles through in the search
+// overlay, starting with the default.
+var searchSorts = []string{protocol.SortNewest, protocol.SortOldest, protocol.SortRelevance}
+
+// channelLabel is how a conversation is named in the UI.
+func channelLabel(ch string) string {
+	if ch == protocol.MainChannel {
+		return "#main"
+	}
+	return "#" + ch
+}
+
+// who names the signed-in user for the header, with the profile when one
+// is active.
+func (m model) who() string {
+	if m.profile == "" {
+		return m.me
+	}
+	return m.me + "@" + m.profile
+}
+
+// auth completes p with what the client asks for at login: no echo of its
+// own messages unless the profile wants it (echo.go), and roster diffs
+// (roster.go).
+func (m model) auth(p protocol.AuthPayload) protocol.AuthPayload {
+	p.NoEcho = !m.echo
+	p.RosterDiffs = true
+	return p
+}
+
+// supports reports whether the server advertised feature in its hello.
+// Before the hello arrives (or from a server that predates it) everything is
+// assumed to be supported so the client never hides working features.
+func (m model) supports(feature string) bool {
+	if m.hello == nil {
+		return true
+	}
+	return m.hello.HasFeature(feature)
+}
+
 // renderStatus renders the login status line with appropriate colour.
 func (m model) renderStatus() string {
 	if m.statusMsg == "" {
@@ -678,6 +1781,9 @@ func (m model) renderStatus() string {
 	if strings.Contains(m.statusMsg, "Authenticating") {
 		return hintStyle.Render(m.statusMsg)
 	}
+	if strings.HasPrefix(m.statusMsg, "✓") {
+		return successStyle.Render(m.statusMsg)
+	}
 	return errorStyle.Render(m.statusMsg)
 }
 
@@ -685,30 +1791,48 @@ func (m model) renderStatus() string {
 // Helpers
 // ---------------------------------------------------------------------------
 
+// pingTick schedules the next keepalive ping.
+func pingTick() tea.Cmd {
+	return tea.Tick(pingInterval, func(time.Time) tea.Msg { return pingTickMsg{} })
+}
+
+// serverNow returns the current time on the server's clock, which is the one
+// every message timestamp is stamped with.  Use it instead of time.Now when
+// comparing against or producing server timestamps.
+func (m model) serverNow() time.Time {
+	return time.Now().Add(m.skew)
+}
+
 // waitForPkt returns a tea.Cmd that blocks until the next packet arrives on ch.
 // When ch is closed (server disconnected), it returns disconnectedMsg.
-func waitForPkt(ch <-chan []byte) tea.Cmd {
+func waitForPkt(ch chan []byte) tea.Cmd {
 	return func() tea.Msg {
 		data, ok := <-ch
 		if !ok {
-			return disconnectedMsg{}
+			return disconnectedMsg{src: ch}
 		}
-		return serverPktMsg(data)
+		return serverPktMsg{data: data, src: ch}
 	}
 }
 
 // sendPkt serialises payload into a Packet and writes it as a newline-
-// terminated JSON line to conn.
-func sendPkt(conn net.Conn, t protocol.MessageType, payload any) {
+// terminated JSON line to conn.  It does nothing while disconnected (conn is
+// nil after /disconnect).  Most callers ignore the error: a broken connection
+// is reported when the reader sees it close.
+func sendPkt(conn net.Conn, t protocol.MessageType, payload any) error {
+	if conn == nil {
+		return nil
+	}
 	pkt, err := protocol.NewPacket(t, payload)
 	if err != nil {
-		return
+		return err
 	}
 	data, err := pkt.Encode()
 	if err != nil {
-		return
+		return err
 	}
-	conn.Write(append(data, '\n'))
+	_, err = conn.Write(append(data, '\n'))
+	return err
 }
 
 // extractQuoted returns the first double-quoted string in s.
@@ -729,36 +1853,115 @@ func extractQuoted(s string) string {
 // ---------------------------------------------------------------------------
 
 func main() {
-	addr := flag.String("addr", "localhost:8080", "server address")
+	addr := flag.String("addr", "localhost:8080", "server address; tls://host:port to use TLS")
+	token := flag.String("token", "", "session token to log in with instead of a password")
+	profilesPath := flag.String("profiles", defaultProfilesPath(), "profile file (see /connect)")
+	profileName := flag.String("profile", "", "profile to start with (default: the file's default, if any)")
+	tlsCA := flag.String("tls-ca", "", "PEM file of CA certificates to trust instead of the system roots (implies TLS)")
+	tlsName := flag.String("tls-server-name", "", "name to verify in the server certificate instead of the address's host (implies TLS)")
+	tlsPins := flag.String("tls-pin", "", "comma-separated SHA-256 fingerprints of accepted server certificates (implies TLS)")
+	setup := flag.Bool("setup", false, "run the setup wizard, adding a profile to the profile file")
+	player := flag.String("player", "", "command that plays voice clips, {} standing for the file (default: mpv, ffplay, afplay or paplay)")
 	flag.Parse()
 
-	conn, err := net.Dial("tcp", *addr)
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// First run: no profile file and nothing on the command line.
+	_, statErr := os.Stat(*profilesPath)
+	firstRun := *profilesPath != "" && len(set) == 0 && errors.Is(statErr, fs.ErrNotExist)
+	if *setup || firstRun {
+		w, err := runSetup(*profilesPath, *addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "setup: %v\n", err)
+			os.Exit(1)
+		}
+		if w.quit {
+			return
+		}
+		if w.name != "" {
+			*profileName = w.name
+		}
+	}
+
+	pf, err := loadProfiles(*profilesPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		fmt.Fprintf(os.Stderr, "profiles: %v\n", err)
 		os.Exit(1)
 	}
-	defer conn.Close()
-
-	// pkts bridges the TCP reader goroutine and the Bubbletea event loop.
-	pkts := make(chan []byte, 64)
 
-	// Reader goroutine: TCP → pkts channel.
-	go func() {
-		defer close(pkts)
-		scanner := bufio.NewScanner(conn)
-		for scanner.Scan() {
-			line := make([]byte, len(scanner.Bytes()))
-			copy(line, scanner.Bytes())
-			pkts <- line
+	// An explicit -addr means "no profile" unless -profile is given too.
+	name := *profileName
+	if name == "" && !set["addr"] {
+		name = pf.Default
+	}
+	var start profile
+	if name != "" {
+		p, ok := pf.Profiles[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "profiles: no profile named %q\n", name)
+			os.Exit(1)
+		}
+		start = p
+	}
+	if set["addr"] || name == "" {
+		start.Addr = *addr
+	}
+	if *token != "" {
+		start.Token = *token
+	}
+	if *player != "" {
+		start.Player = *player
+	}
+	if *tlsCA != "" || *tlsName != "" || *tlsPins != "" {
+		o := &tlsOptions{CA: *tlsCA, ServerName: *tlsName}
+		for _, pin := range strings.Split(*tlsPins, ",") {
+			if pin = strings.TrimSpace(pin); pin != "" {
+				o.Pins = append(o.Pins, pin)
+			}
+		}
+		if err := o.validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "tls: %v\n", err)
+			os.Exit(1)
 		}
-	}()
+		start.TLS = o
+	}
+	applyTheme(start.Theme)
+
+	conn, pkts, err := dialServer(start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		os.Exit(1)
+	}
+
+	m := newModel(conn, pkts)
+	m.addr, m.tls = start.Addr, start.TLS
+	m.profiles = pf.Profiles
+	m.profile = name
+	if start.Spell != "" {
+		m.useSpeller(start.Spell)
+	}
+	m.hideJoins = start.HideJoins
+	m.bell = start.Bell
+	m.echo = start.Echo
+	m.player = start.Player
+	// Sync the clock right away rather than waiting a full ping interval, and
+	// log in when the profile or -token carries credentials.
+	m = m.start(start)
 
 	p := tea.NewProgram(
-		newModel(conn, pkts),
+		m,
 		tea.WithAltScreen(),       // use the alternate screen buffer
 		tea.WithMouseCellMotion(), // enable mouse wheel scrolling
 	)
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	if fm, ok := final.(model); ok {
+		if fm.conn != nil {
+			hangUp(fm.conn, fm.pkts) // the latest connection, after any /connect
+		}
+		fm.cache.save()
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Mentions
+// ---------------------------------------------------------------------------
+//
+// Servers with FeatureMentions say who a message mentions with a
+// TypeMention packet to each of them, and those packets, not the text,
+// decide which messages end up in the notification center.  Older servers
+// leave it to mentions() in notifications.go.  Either way a line that
+// mentions @me has its timestamp highlighted, and with /bell on (or "bell"
+// in the profile) each new mention rings the terminal bell.
+
+// showMention records the notice of a TypeMention packet.
+func (m *model) showMention(p protocol.MentionPayload) {
+	if p.ID == "" || m.muted[p.Channel] {
+		return
+	}
+	excerpt := p.Excerpt
+	if r := []rune(excerpt); len(r) > maxExcerpt {
+		excerpt = string(r[:maxExcerpt]) + "…"
+	}
+	m.addNotice(notice{
+		ID:      p.ID,
+		Channel: p.Channel,
+		From:    p.From,
+		Excerpt: excerpt,
+		At:      p.Timestamp,
+		Read:    m.state == stateChat && p.Channel == m.channel && m.viewport.AtBottom(),
+	})
+}
+
+// ringBell rings the terminal bell when /bell is on.
+func (m *model) ringBell() {
+	if m.bell {
+		m.next = tea.Batch(m.next, func() tea.Msg {
+			os.Stdout.WriteString("\a")
+			return nil
+		})
+	}
+}
+
+func cmdBell(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		state := "off"
+		if m.bell {
+			state = "on"
+		}
+		m.appendChat(sysStyle.Render("the bell on mentions is " + state + "; " + commands["bell"].usage))
+		return m, nil
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		m.bell = true
+		m.appendChat(successStyle.Render("✓ mentions ring the bell"))
+	case "off":
+		m.bell = false
+		m.appendChat(successStyle.Render("✓ mentions no longer ring the bell"))
+	default:
+		m.warn("usage: " + commands["bell"].usage)
+	}
+	return m, nil
+}
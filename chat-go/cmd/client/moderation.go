@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Moderation
+// ---------------------------------------------------------------------------
+//
+// Moderators can /kick a user off and /silence them, for a while or until
+// /unsilence; admins can also /ban and /unban (FeatureModeration).  /mute
+// stays the user's own quiet switch for a conversation.  The server checks
+// the roles and answers; a user who is silenced is told so with a notice
+// that stays in the chat.
+
+func cmdKick(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["kick"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeKick, protocol.KickPayload{User: strings.TrimPrefix(args[0], "@"), Reason: strings.Join(args[1:], " ")})
+	return m, nil
+}
+
+func cmdBan(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["ban"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeBan, protocol.BanPayload{User: strings.TrimPrefix(args[0], "@"), Reason: strings.Join(args[1:], " ")})
+	return m, nil
+}
+
+func cmdUnban(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 1 {
+		m.warn("usage: " + commands["unban"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeBan, protocol.BanPayload{User: strings.TrimPrefix(args[0], "@"), Unban: true})
+	return m, nil
+}
+
+func cmdSilence(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		m.warn("usage: " + commands["silence"].usage)
+		return m, nil
+	}
+	p := protocol.MutePayload{User: strings.TrimPrefix(args[0], "@"), Mute: true}
+	rest := args[1:]
+	if len(rest) > 0 {
+		if d, err := time.ParseDuration(rest[0]); err == nil {
+			if d <= 0 {
+				m.warn("the duration must be positive")
+				return m, nil
+			}
+			until := m.serverNow().Add(d)
+			p.Until, rest = &until, rest[1:]
+		}
+	}
+	p.Reason = strings.Join(rest, " ")
+	sendPkt(m.conn, protocol.TypeMute, p)
+	return m, nil
+}
+
+func cmdUnsilence(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 1 {
+		m.warn("usage: " + commands["unsilence"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeMute, protocol.MutePayload{User: strings.TrimPrefix(args[0], "@")})
+	return m, nil
+}
+
+// showModeration shows a moderator's notice about the user, such as being
+// silenced.
+func (m *model) showModeration(sys protocol.SystemPayload) {
+	m.appendChat(errorStyle.Render("⚠ " + sys.Message))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Muted conversations
+// ---------------------------------------------------------------------------
+//
+// The server keeps the list of conversations each user muted (FeatureMute),
+// so it is the same in every client.  A muted conversation still shows its
+// messages but adds nothing to the notification center or the unread
+// counts.
+
+// setPreferences records the preferences the server sent.
+func (m *model) setPreferences(p protocol.Preferences) {
+	m.muted = make(map[string]bool, len(p.MutedChannels))
+	for _, ch := range p.MutedChannels {
+		m.muted[ch] = true
+	}
+	m.locale = p.Locale
+}
+
+func cmdMute(m model, args []string) (model, tea.Cmd)   { return m.mute(args, true) }
+func cmdUnmute(m model, args []string) (model, tea.Cmd) { return m.mute(args, false) }
+
+func (m model) mute(args []string, on bool) (model, tea.Cmd) {
+	ch := m.channel
+	if len(args) > 0 {
+		var ok bool
+		if ch, ok = m.conversationNamed(args[0]); !ok {
+			m.warn("no conversation " + args[0])
+			return m, nil
+		}
+	}
+	sendPkt(m.conn, protocol.TypeMute, protocol.MutePayload{Channel: ch, Mute: on})
+	m.waitMute = true
+	return m, nil
+}
+
+// conversationNamed finds a conversation as the UI labels it: #main,
+// #channel or @user.
+func (m model) conversationNamed(label string) (string, bool) {
+	if peer, ok := strings.CutPrefix(label, "@"); ok {
+		for ch, cv := range m.convs {
+			if strings.EqualFold(cv.peer, peer) {
+				return ch, true
+			}
+		}
+		return "", false
+	}
+	if strings.EqualFold(strings.TrimPrefix(label, "#"), "main") {
+		return protocol.MainChannel, true
+	}
+	ch := protocol.PublicChannel(label)
+	return ch, ch != ""
+}
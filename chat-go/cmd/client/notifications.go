@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Notification center
+// ---------------------------------------------------------------------------
+//
+// Every message that mentions @me or arrives in one of my DMs is recorded as
+// a notice, unless its conversation is muted; see mentions.go for what
+// counts as a mention.  Ctrl+N lists them, newest first; Enter opens the conversation
+// and scrolls to the message, loading older history if it has to.  Notices
+// are kept in a file per server and account so they survive restarts, and
+// pruned to the newest maxNotices no older than maxNoticeAge.
+
+const (
+	maxNotices     = 200 // oldest notices are dropped beyond this
+	maxExcerpt     = 80  // runes of the message shown in the list
+	maxJumpPages   = 5   // older-history pages fetched looking for a jump target
+	noticeListSkip = 4   // header, blank line, key hints and divider
+)
+
+// maxNoticeAge is how long a notice is kept, read or not.
+const maxNoticeAge = 30 * 24 * time.Hour
+
+// notice is one mention or DM.
+type notice struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel"`
+	From    string    `json:"from"`
+	Excerpt string    `json:"excerpt"`
+	At      time.Time `json:"at"`
+	DM      bool      `json:"dm,omitempty"`
+	Read    bool      `json:"read,omitempty"`
+}
+
+// pendingJump is a jump to a message that is not loaded yet.
+type pendingJump struct {
+	id, channel string
+	pages       int // older-history pages requested so far
+}
+
+// noticesPath is where the notices of user on the server at addr are kept.
+func noticesPath(addr, user string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil || addr == "" || user == "" {
+		return ""
+	}
+	name := strings.Map(func(r rune) rune {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-') {
+			return r
+		}
+		return '_'
+	}, addr+"_"+user)
+	return filepath.Join(dir, "gochat", "notifications", name+".json")
+}
+
+// loadNotices reads the notices at path.  A missing file is not an error.
+func loadNotices(path string) ([]notice, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []notice
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pruneNotices(list), nil
+}
+
+// pruneNotices drops the notices older than maxNoticeAge and then the
+// oldest beyond maxNotices.
+func pruneNotices(list []notice) []notice {
+	list = slices.DeleteFunc(list, func(n notice) bool { return time.Since(n.At) > maxNoticeAge })
+	if len(list) > maxNotices {
+		list = list[len(list)-maxNotices:]
+	}
+	return list
+}
+
+// saveNotices writes the notices back to disk.  A failure is reported in
+// the chat but is otherwise harmless.
+func (m *model) saveNotices() {
+	if m.noticePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(m.notices, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(m.noticePath), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(m.noticePath, data, 0o600)
+	}
+	if err != nil {
+		m.fail("saving notifications: " + err.Error())
+	}
+}
+
+// mentions reports whether content contains @user as a whole word.
+func mentions(content, user string) bool {
+	if user == "" {
+		return false
+	}
+	lc, tag := strings.ToLower(content), "@"+strings.ToLower(user)
+	for i := 0; ; {
+		j := strings.Index(lc[i:], tag)
+		if j < 0 {
+			return false
+		}
+		end := i + j + len(tag)
+		if end == len(lc) || !isNameByte(lc[end]) {
+			return true
+		}
+		i = end
+	}
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// notify records b when it is a DM or, unless the server tells of mentions
+// itself, mentions me.  Messages I can see as they arrive are recorded as
+// already read.
+func (m *model) notify(b protocol.BroadcastPayload) {
+	if b.Username == m.me || b.ID == "" || m.muted[b.Channel] {
+		return
+	}
+	dm := protocol.IsDirect(b.Channel)
+	if !dm && (m.supports(protocol.FeatureMentions) || !mentions(b.Content, m.me)) {
+		return
+	}
+	line, _, _ := strings.Cut(b.Content, "\n")
+	switch {
+	case line != "":
+	case b.Attachment != nil:
+		line = "📎 " + b.Attachment.Name
+	case b.Kind != "":
+		line = "[" + b.Kind + "]"
+	}
+	if r := []rune(line); len(r) > maxExcerpt {
+		line = string(r[:maxExcerpt]) + "…"
+	}
+	m.addNotice(notice{
+		ID:      b.ID,
+		Channel: b.Channel,
+		From:    b.Username,
+		Excerpt: line,
+		At:      b.Timestamp,
+		DM:      dm,
+		Read:    m.state == stateChat && b.Channel == m.channel && m.viewport.AtBottom(),
+	})
+}
+
+// addNotice records n unless its message already has a notice, and rings
+// the bell for a new mention.
+func (m *model) addNotice(n notice) {
+	for _, x := range m.notices {
+		if x.ID == n.ID {
+			return
+		}
+	}
+	if !n.DM {
+		m.ringBell()
+	}
+	m.notices = pruneNotices(append(m.notices, n))
+	m.saveNotices()
+}
+
+// unreadNotices counts the notices not yet jumped to.
+func (m model) unreadNotices() int {
+	n := 0
+	for _, x := range m.notices {
+		if !x.Read {
+			n++
+		}
+	}
+	return n
+}
+
+// selectedNotice maps the list cursor, which counts from the newest notice,
+// to an index in m.notices.
+func (m model) selectedNotice() int {
+	return len(m.notices) - 1 - m.noticeSel
+}
+
+func (m model) handleNoticesKey(msg tea.KeyMsg) (model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m.requestQuit()
+
+	case tea.KeyEsc, tea.KeyCtrlN:
+		m.state = stateChat
+		return m, nil
+
+	case tea.KeyUp:
+		if m.noticeSel > 0 {
+			m.noticeSel--
+		}
+		return m, nil
+
+	case tea.KeyDown:
+		if m.noticeSel < len(m.notices)-1 {
+			m.noticeSel++
+		}
+		return m, nil
+
+	case tea.KeyEnter:
+		if len(m.notices) == 0 {
+			return m, nil
+		}
+		i := m.selectedNotice()
+		m.notices[i].Read = true
+		m.saveNotices()
+		return m.jumpTo(m.notices[i])
+	}
+
+	switch msg.String() {
+	case "a":
+		for i := range m.notices {
+			m.notices[i].Read = true
+		}
+		m.saveNotices()
+	case "x", "delete":
+		if len(m.notices) == 0 {
+			return m, nil
+		}
+		i := m.selectedNotice()
+		m.notices = append(m.notices[:i], m.notices[i+1:]...)
+		if m.noticeSel > 0 && m.noticeSel >= len(m.notices) {
+			m.noticeSel--
+		}
+		m.saveNotices()
+	}
+	return m, nil
+}
+
+// jumpTo opens the conversation of n and scrolls to its message, or waits
+// for the history that contains it.
+func (m model) jumpTo(n notice) (model, tea.Cmd) {
+	m.state = stateChat
+	m.chatInput.Focus()
+	if cv := m.conv(n.Channel); n.DM && cv.peer == "" {
+		cv.peer = n.From
+	}
+	m, _ = m.openConversation(n.Channel)
+	m.jump = pendingJump{id: n.ID, channel: n.Channel}
+	return m.resolveJump()
+}
+
+// resolveJump scrolls to the pending jump target once it is in the
+// scrollback, paging in older history while it is not.  It runs after
+// every history batch.
+func (m model) resolveJump() (model, tea.Cmd) {
+	j := m.jump
+	if j.id == "" || j.channel != m.channel {
+		return m, nil
+	}
+	if i, ok := m.scroll.msgs[j.id]; ok && i < m.scroll.len() {
+		row := m.scroll.rowsBefore(i)
+		if m.olderSentinel() != "" {
+			row++
+		}
+		m.viewport.SetYOffset(row)
+		m.jump = pendingJump{}
+		return m, nil
+	}
+	switch {
+	case m.loadingOlder || (m.oldestID == "" && m.scroll.len() == 0):
+		// A history request is in flight; try again when it lands.
+		return m, nil
+	case m.hasOlder && j.pages < maxJumpPages:
+		m.jump.pages++
+		return m.loadOlder()
+	}
+	m.jump = pendingJump{}
+	m.appendChat(hintStyle.Render("that message is no longer in the loaded history"))
+	return m, nil
+}
+
+func (m model) viewNotices() string {
+	if m.width == 0 {
+		return "\n  Loading…"
+	}
+
+	hdr := searchHeaderStyle.
+		Width(m.width).
+		Render(fmt.Sprintf(" Notifications  ·  %d unread  ·  Esc: return to chat  Ctrl+C: quit", m.unreadNotices()))
+	keys := hintStyle.Render("  ↑/↓: select   Enter: jump to message   a: mark all read   x: delete")
+	div := divStyle.Render(strings.Repeat("─", m.width))
+
+	if len(m.notices) == 0 {
+		return strings.Join([]string{hdr, "", keys, div, hintStyle.Render("  (no mentions or direct messages yet)")}, "\n")
+	}
+
+	// Keep the cursor on screen.
+	rows := m.height - noticeListSkip
+	if rows < 1 {
+		rows = 1
+	}
+	first := 0
+	if m.noticeSel >= rows {
+		first = m.noticeSel - rows + 1
+	}
+
+	lines := []string{hdr, "", keys, div}
+	for k := first; k < len(m.notices) && k < first+rows; k++ {
+		n := m.notices[len(m.notices)-1-k]
+		ts := tsStyle.Render("[" + n.At.Local().Format("2006-01-02 15:04") + "]")
+		where := m.convLabel(n.Channel)
+		if n.DM {
+			where = "@" + n.From
+		}
+		mark := "  "
+		if !n.Read {
+			mark = sysStyle.Render("• ")
+		}
+		line := fmt.Sprintf("%s%s %s %s: %s", mark, ts, hintStyle.Render(where), peerStyle.Render(n.From), n.Excerpt)
+		if k == m.noticeSel {
+			line = myNameStyle.Render("▸ ") + line
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
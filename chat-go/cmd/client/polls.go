@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// showPoll renders p into the scrollback.  The first time a poll is seen it
+// is appended; later updates (votes, closing) redraw the same entry in place
+// so the scrollback does not fill with tally snapshots.  Polls belong to the
+// main channel; while a DM is on screen the parked main view is updated.
+func (m *model) showPoll(p protocol.Poll) {
+	l := chatLine{poll: p.ID, text: m.renderPoll(p)}
+	if m.channel != protocol.MainChannel {
+		if m.conv(protocol.MainChannel).scroll.add(l) {
+			m.conv(protocol.MainChannel).unread++
+		}
+		return
+	}
+	m.appendEntry(l)
+}
+
+func (m model) renderPoll(p protocol.Poll) string {
+	total := 0
+	for _, o := range p.Options {
+		total += o.Votes
+	}
+
+	head := fmt.Sprintf("📊 Poll #%s by %s: %s", p.ID, p.Creator, p.Question)
+	if p.Closed {
+		head += "  [closed]"
+	}
+	lines := []string{sysStyle.Render(head)}
+	for i, o := range p.Options {
+		bar := ""
+		if total > 0 {
+			bar = strings.Repeat("█", o.Votes*20/total)
+		}
+		lines = append(lines, fmt.Sprintf("   %d) %-24s %s %s",
+			i+1, o.Text, successStyle.Render(bar), tsStyle.Render(strconv.Itoa(o.Votes))))
+	}
+	if !p.Closed {
+		lines = append(lines, hintStyle.Render(fmt.Sprintf("   /vote %s <n> to vote", p.ID)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func cmdPoll(m model, args []string) (model, tea.Cmd) {
+	parts := strings.Split(strings.Join(args, " "), "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) < 3 {
+		m.warn("usage: " + commands["poll"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypePollCreate, protocol.PollCreatePayload{
+		Question: parts[0],
+		Options:  parts[1:],
+	})
+	return m, nil
+}
+
+func cmdVote(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 2 {
+		m.warn("usage: " + commands["vote"].usage)
+		return m, nil
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		m.warn("option must be a number from the poll")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypePollVote, protocol.PollVotePayload{
+		PollID: strings.TrimPrefix(args[0], "#"),
+		Option: n - 1,
+	})
+	return m, nil
+}
+
+func cmdClosePoll(m model, args []string) (model, tea.Cmd) {
+	if len(args) != 1 {
+		m.warn("usage: " + commands["closepoll"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypePollClose, protocol.PollClosePayload{PollID: strings.TrimPrefix(args[0], "#")})
+	return m, nil
+}
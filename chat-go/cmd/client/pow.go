@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Proof of work for registration
+// ---------------------------------------------------------------------------
+//
+// Servers with FeatureProofOfWork take a registration only with the proof
+// of a fresh challenge.  The login form and the setup wizard ask for one
+// when the user registers, solve it in the background and then send the
+// registration; the status line says what is going on, since at the
+// server's difficulty that can take a few seconds.
+
+// workDoneMsg carries a registration for conn whose proof is solved.
+type workDoneMsg struct {
+	conn net.Conn
+	auth protocol.AuthPayload
+}
+
+// askChallenge asks conn's server for a challenge for auth's registration.
+func askChallenge(conn net.Conn) {
+	sendPkt(conn, protocol.TypeChallenge, map[string]string{})
+}
+
+// solveChallenge starts solving the challenge in r, the answer to
+// askChallenge, for auth.  It returns the status to show and, when r holds
+// a challenge, the command that solves it.
+func solveChallenge(conn net.Conn, auth protocol.AuthPayload, r protocol.ResponsePayload) (string, tea.Cmd) {
+	var c protocol.ChallengePayload
+	if !r.Success {
+		return r.Message, nil
+	}
+	if err := json.Unmarshal(r.Data, &c); err != nil || c.Nonce == "" {
+		return "the server sent a broken challenge", nil
+	}
+	return fmt.Sprintf("Solving the server's anti-spam challenge (%d bits)…", c.Bits), func() tea.Msg {
+		auth.Proof = protocol.SolveChallenge(c.Nonce, c.Bits)
+		return workDoneMsg{conn: conn, auth: auth}
+	}
+}
+
+// register sends auth as a registration, or first asks for a challenge
+// when the server wants a proof of work.
+func (m model) register(auth protocol.AuthPayload) model {
+	if !m.supports(protocol.FeatureProofOfWork) {
+		sendPkt(m.conn, protocol.TypeRegister, auth)
+		m.statusMsg = "Authenticating…"
+		return m
+	}
+	askChallenge(m.conn)
+	m.waitChallenge = &auth
+	m.statusMsg = "Asking for a challenge…"
+	return m
+}
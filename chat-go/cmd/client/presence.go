@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Join and leave notices
+// ---------------------------------------------------------------------------
+//
+// /joins off (or "hide_joins" in the profile) hides the notices of users
+// joining and leaving, including the server's own summaries of them.  They
+// are tallied instead, and about every joinSummaryEvery the chat shows how
+// many joined and left meanwhile, if any did.  The online count in the
+// header is kept up either way.
+
+const joinSummaryEvery = time.Minute
+
+// joinTally counts the hidden join and leave notices since since.
+type joinTally struct {
+	joined, left int
+	since        time.Time
+}
+
+// presenceCounts returns how many users sys says joined and left, and
+// whether it is such a notice at all.  Servers without FeatureSystemKinds
+// do not say what kind of notice it is, and those without FeaturePresence
+// only say it in words.
+func presenceCounts(sys protocol.SystemPayload) (joined, left int, ok bool) {
+	switch sys.Kind {
+	case protocol.SystemJoin, protocol.SystemLeave, protocol.SystemPresence:
+		return sys.Joined, sys.Left, true
+	case "":
+	default:
+		return 0, 0, false
+	}
+	switch {
+	case sys.Joined > 0 || sys.Left > 0:
+		return sys.Joined, sys.Left, true
+	case strings.HasSuffix(sys.Message, "joined the chat"):
+		return 1, 0, true
+	case strings.HasSuffix(sys.Message, "left the chat"):
+		return 0, 1, true
+	}
+	return 0, 0, false
+}
+
+// showPresence updates the online count from a join or leave notice,
+// unless the roster keeps it (see roster.go), and shows the notice, or
+// tallies it while they are hidden.
+func (m *model) showPresence(sys protocol.SystemPayload, joined, left int) {
+	switch {
+	case m.supports(protocol.FeatureRoster):
+	case sys.Online > 0:
+		m.onlineCount = sys.Online
+	default:
+		m.onlineCount = max(m.onlineCount+joined-left, 0)
+	}
+	if !m.hideJoins {
+		m.appendChat(sysStyle.Render("⚡ " + sys.Message))
+		return
+	}
+	if m.joins.joined == 0 && m.joins.left == 0 {
+		m.joins.since = time.Now()
+	}
+	m.joins.joined += joined
+	m.joins.left += left
+}
+
+// flushJoins shows the tally of hidden notices once it is joinSummaryEvery
+// old, or at once with force.
+func (m *model) flushJoins(force bool) {
+	t := m.joins
+	if t.joined == 0 && t.left == 0 || !force && time.Since(t.since) < joinSummaryEvery {
+		return
+	}
+	m.joins = joinTally{}
+	m.appendChat(sysStyle.Render(fmt.Sprintf("⚡ %d joined, %d left since %s", t.joined, t.left, t.since.Format("15:04"))))
+}
+
+func cmdJoins(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		state := "shown"
+		if m.hideJoins {
+			state = fmt.Sprintf("hidden, summed up about every %s", joinSummaryEvery)
+		}
+		m.appendChat(sysStyle.Render("join and leave notices are " + state + "; " + commands["joins"].usage))
+		return m, nil
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		m.flushJoins(true)
+		m.hideJoins = false
+		m.appendChat(successStyle.Render("✓ showing join and leave notices"))
+	case "off":
+		m.hideJoins = true
+		m.appendChat(successStyle.Render("✓ hiding join and leave notices; a summary follows every so often"))
+	default:
+		m.warn("usage: " + commands["joins"].usage)
+	}
+	return m, nil
+}
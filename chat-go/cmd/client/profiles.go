@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Profiles
+// ---------------------------------------------------------------------------
+//
+// A profile file lets one client talk to several servers or accounts:
+//
+//	{
+//	  "default": "work",
+//	  "profiles": {
+//	    "work": {"addr": "chat.corp:8080", "token": "…", "theme": "light", "spell": "en_GB"},
+//	    "home": {"addr": "localhost:8080", "username": "me", "password": "…"},
+//	    "lab":  {"addr": "tls://lab.example:8443", "tls": {"pins": ["sha256:…"]}}
+//	  }
+//	}
+//
+// It is read from $XDG_CONFIG_HOME/gochat/profiles.json (or the platform
+// equivalent) unless -profiles names another file.  /connect <name> switches
+// profile at runtime; /connect host:port reaches a server without one, and
+// a bare /connect after /disconnect (or a lost connection) dials the same
+// server again.  Every connection starts from a fresh model.
+//
+// Without a profile file the client opens with the setup wizard in setup.go,
+// which writes one.
+
+// profile is one server/account pairing.  Credentials are optional; without
+// them the login screen is shown as usual.
+type profile struct {
+	Addr     string      `json:"addr"`
+	Username string      `json:"username,omitempty"`
+	Password string      `json:"password,omitempty"`
+	Token    string      `json:"token,omitempty"`  // used instead of username/password
+	Theme    string      `json:"theme,omitempty"`  // see themes; default when empty
+	Spell    string      `json:"spell,omitempty"`  // dictionary language, see spell.go
+	Player   string      `json:"player,omitempty"` // voice clip player, see clips.go
+	TLS      *tlsOptions `json:"tls,omitempty"`    // see tls.go; also enabled by a tls:// addr
+
+	HideJoins bool `json:"hide_joins,omitempty"` // see presence.go
+	Bell      bool `json:"bell,omitempty"`       // see mentions.go
+	Echo      bool `json:"echo,omitempty"`       // see echo.go
+}
+
+type profileFile struct {
+	Default  string             `json:"default,omitempty"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+func defaultProfilesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gochat", "profiles.json")
+}
+
+// loadProfiles reads the profile file at path.  A missing file is not an
+// error; it yields no profiles.
+func loadProfiles(path string) (profileFile, error) {
+	var pf profileFile
+	if path == "" {
+		return pf, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pf, nil
+	}
+	if err != nil {
+		return pf, err
+	}
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return pf, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range pf.Profiles {
+		if p.Addr == "" {
+			return pf, fmt.Errorf("%s: profile %q has no addr", path, name)
+		}
+		if _, ok := themes[p.Theme]; !ok && p.Theme != "" {
+			return pf, fmt.Errorf("%s: profile %q: unknown theme %q", path, name, p.Theme)
+		}
+		if err := p.TLS.validate(); err != nil {
+			return pf, fmt.Errorf("%s: profile %q: %w", path, name, err)
+		}
+	}
+	if _, ok := pf.Profiles[pf.Default]; pf.Default != "" && !ok {
+		return pf, fmt.Errorf("%s: default profile %q is not defined", path, pf.Default)
+	}
+	return pf, nil
+}
+
+// saveProfiles writes pf to path, creating its directory.  Profiles may hold
+// tokens and passwords, so the file is readable by its owner only.
+func saveProfiles(path string, pf profileFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// dialServer connects to p's server, over TLS when p asks for it, and starts
+// the reader goroutine that feeds pkts; pkts is closed when the connection
+// ends.
+func dialServer(p profile) (net.Conn, chan []byte, error) {
+	addr, secure := strings.CutPrefix(p.Addr, tlsPrefix)
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secure || p.TLS != nil {
+		conn.SetDeadline(time.Now().Add(dialTimeout))
+		tc, err := dialTLS(conn, addr, p.TLS)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		conn = tc
+	}
+	pkts := make(chan []byte, 64)
+	go func() {
+		defer close(pkts)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 64*1024), protocol.MaxPacketSizeLimit)
+		for scanner.Scan() {
+			line := make([]byte, len(scanner.Bytes()))
+			copy(line, scanner.Bytes())
+			pkts <- line
+		}
+	}()
+	return conn, pkts, nil
+}
+
+// connectedMsg reports a successful /connect dial.
+type connectedMsg struct {
+	name string
+	p    profile
+	conn net.Conn
+	pkts chan []byte
+}
+
+// connectFailedMsg reports a failed /connect dial.
+type connectFailedMsg struct {
+	name string
+	err  error
+}
+
+func connectProfile(name string, p profile) tea.Cmd {
+	return func() tea.Msg {
+		conn, pkts, err := dialServer(p)
+		if err != nil {
+			return connectFailedMsg{name: name, err: err}
+		}
+		return connectedMsg{name: name, p: p, conn: conn, pkts: pkts}
+	}
+}
+
+// start sends the opening packets on a fresh connection: a clock-sync ping
+// and, when the profile carries credentials, the login.
+func (m model) start(p profile) model {
+	sendPkt(m.conn, protocol.TypePing, protocol.PingPayload{ClientTime: time.Now()})
+	switch {
+	case p.Token != "":
+		sendPkt(m.conn, protocol.TypeLogin, m.auth(protocol.AuthPayload{Token: p.Token}))
+		m.statusMsg = "Authenticating…"
+	case p.Username != "" && p.Password != "":
+		sendPkt(m.conn, protocol.TypeLogin, m.auth(protocol.AuthPayload{Username: p.Username, Password: p.Password}))
+		m.statusMsg = "Authenticating…"
+	case p.Username != "":
+		m.loginFields[0].SetValue(p.Username)
+	}
+	return m
+}
+
+// switchProfile replaces the current session with the one on msg's
+// connection.  The old connection is told we are leaving and closed; its
+// reader's remaining packets are ignored because they arrive on the old
+// channel.
+func (m model) switchProfile(msg connectedMsg) (model, tea.Cmd) {
+	if m.conn != nil {
+		sendPkt(m.conn, protocol.TypeQuit, map[string]string{})
+		m.conn.Close()
+	}
+
+	if msg.name != "" {
+		applyTheme(msg.p.Theme)
+	}
+	nm := newModel(msg.conn, msg.pkts)
+	nm.addr, nm.tls = msg.p.Addr, msg.p.TLS
+	nm.profiles = m.profiles
+	nm.profile = msg.name
+	nm.speller = m.speller
+	if msg.name == m.profile && msg.p.Addr == m.addr {
+		nm.session = m.session // a token login is not issued a new one
+	}
+	nm.hideJoins = m.hideJoins || msg.p.HideJoins
+	nm.bell = m.bell || msg.p.Bell
+	nm.echo = m.echo || msg.p.Echo
+	nm.player = cmp.Or(msg.p.Player, m.player)
+	if msg.p.Spell != "" && (m.speller == nil || m.speller.lang != msg.p.Spell) {
+		nm.useSpeller(msg.p.Spell)
+	}
+	if m.ready {
+		nm.resize(m.width, m.height)
+	}
+	nm = nm.start(msg.p)
+	return nm, tea.Batch(textinput.Blink, waitForPkt(nm.pkts))
+}
+
+// disconnect drops the connection and leaves the scrollback on screen until
+// the next /connect.
+func (m model) disconnect(why string) model {
+	if m.conn != nil {
+		m.conn.Close()
+	}
+	m.conn, m.pkts = nil, nil // the reader's leftovers no longer match m.pkts
+	m.typing, m.roster = nil, nil
+	if m.blocked != nil && m.blocked.Channel == m.channel && m.chatInput.Value() == "" {
+		m.chatInput.SetValue(m.blocked.Content) // not sent; the user can try again
+	}
+	m.notSent(m.inFlight)
+	m.notSent(m.blocked)
+	m.inFlight, m.blocked, m.coolUntil = nil, nil, time.Time{}
+	m.unacked, m.lag = nil, 0
+	m.saveCache()
+	if m.loadingOlder {
+		m.loadingOlder = false
+		m.refreshChat()
+	}
+	m.appendChat(sysStyle.Render("⚡ " + why + " — /connect to reconnect"))
+	return m
+}
+
+func cmdDisconnect(m model, _ []string) (model, tea.Cmd) {
+	sendPkt(m.conn, protocol.TypeQuit, map[string]string{})
+	return m.disconnect("disconnected from " + m.addr), nil
+}
+
+func cmdConnect(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 && m.conn == nil {
+		// Reconnect to the server we were on, logging in again when the
+		// profile has credentials or this session's token is still good,
+		// and otherwise pre-filling the username.
+		p, ok := m.profiles[m.profile]
+		if !ok {
+			p = profile{Addr: m.addr, TLS: m.tls}
+		}
+		if p.Token == "" && p.Password == "" && m.session.Token != "" &&
+			m.serverNow().Add(time.Minute).Before(m.session.ExpiresAt) {
+			p.Token = m.session.Token
+		}
+		if p.Username == "" && p.Token == "" {
+			p.Username = m.me
+		}
+		m.appendChat(hintStyle.Render("reconnecting to " + p.Addr + "…"))
+		return m, connectProfile(m.profile, p)
+	}
+	if len(args) == 0 {
+		if len(m.profiles) == 0 {
+			m.appendChat(hintStyle.Render("connected to " + m.addr + "; no profiles defined, see -profiles"))
+			return m, nil
+		}
+		names := make([]string, 0, len(m.profiles))
+		for name := range m.profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			mark := "  "
+			if name == m.profile {
+				mark = "▸ "
+			}
+			m.appendChat(hintStyle.Render(fmt.Sprintf("  %s%-12s %s", mark, name, m.profiles[name].Addr)))
+		}
+		return m, nil
+	}
+	p, ok := m.profiles[args[0]]
+	if !ok && strings.Contains(args[0], ":") {
+		m.appendChat(hintStyle.Render("connecting to " + args[0] + "…"))
+		return m, connectProfile("", profile{Addr: args[0], Username: m.me})
+	}
+	if !ok {
+		m.warn("no profile named " + args[0] + " (use host:port for a server address)")
+		return m, nil
+	}
+	m.appendChat(hintStyle.Render("connecting to " + args[0] + " (" + p.Addr + ")…"))
+	return m, connectProfile(args[0], p)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Quitting
+// ---------------------------------------------------------------------------
+//
+// Ctrl+C quits at once unless that would lose something the user wrote:
+// text still in the input, or a message sent but not yet echoed by the
+// server, held back by a rate-limit cooldown, or waiting for its /dm
+// conversation to open.  Then a toast says what would be lost, and Ctrl+C
+// again while it shows quits; any other key keeps the client open.
+//
+// On the way out the client sends TypeQuit and, once the program has ended,
+// half-closes the connection and waits up to quitLinger for the server to
+// hang up.  Closing at once, with the server's last packets unread, can
+// reset the connection before the server has read the quit.
+
+const quitLinger = time.Second
+
+// unsent describes what quitting now would lose, or is "".
+func (m model) unsent() string {
+	switch {
+	case m.inFlight != nil || m.blocked != nil || m.pendingDM != "":
+		return "a message you sent has not been delivered yet"
+	case strings.TrimSpace(m.chatInput.Value()) != "":
+		return "the text you typed has not been sent"
+	}
+	return ""
+}
+
+// requestQuit quits, or asks first when something would be lost.
+func (m model) requestQuit() (model, tea.Cmd) {
+	what := m.unsent()
+	if what == "" || m.quitAsked() {
+		sendPkt(m.conn, protocol.TypeQuit, map[string]string{})
+		return m, tea.Quit
+	}
+	m.warn(what + " — Ctrl+C again to quit anyway")
+	m.quitAsk = m.toast.id
+	return m, nil
+}
+
+// quitAsked reports whether the quit confirmation is on show.
+func (m model) quitAsked() bool {
+	return m.quitAsk != 0 && m.quitAsk == m.toast.id && m.toast.text != ""
+}
+
+// keepOpen dismisses the quit confirmation, for a key other than Ctrl+C.
+func (m *model) keepOpen() {
+	if m.quitAsked() {
+		m.toast = toast{id: m.toast.id}
+	}
+	m.quitAsk = 0
+}
+
+// chatRefused forgets the message in flight when the server refuses it.
+// A message that goes through is not answered, only broadcast (or
+// acknowledged, see echo.go), so a failure that arrives while one is in
+// flight is about it.
+func (m *model) chatRefused(r protocol.ResponsePayload) {
+	if !r.Success {
+		m.unqueue(m.inFlight)
+		m.notSent(m.inFlight)
+		m.inFlight = nil
+	}
+}
+
+// hangUp closes conn after the client has quit: it stops writing and
+// drains pkts until the server closes its end or quitLinger passes.
+func hangUp(conn net.Conn, pkts chan []byte) {
+	defer conn.Close()
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	linger := time.NewTimer(quitLinger)
+	defer linger.Stop()
+	for {
+		select {
+		case _, ok := <-pkts:
+			if !ok {
+				return
+			}
+		case <-linger.C:
+			return
+		}
+	}
+}
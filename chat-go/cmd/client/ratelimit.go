@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Rate-limit cooldown
+// ---------------------------------------------------------------------------
+//
+// When the server refuses a message for going over the posting rate
+// (protocol.ErrRateLimited), Enter stops sending until the retry time it
+// gave, the footer counts down to it, and then the refused message is sent
+// again by itself.  Which message was refused is the last one sent that has
+// not come back as a broadcast yet: messages go out one Enter at a time, so
+// there is hardly ever more than one in flight.
+
+// cooldownTickMsg redraws the countdown, and ends the cooldown when due.
+type cooldownTickMsg struct{}
+
+func cooldownTick() tea.Cmd {
+	return tea.Tick(time.Second/4, func(time.Time) tea.Msg { return cooldownTickMsg{} })
+}
+
+// sendChat sends p as the user's message, remembering it until it is
+// echoed in case it is refused.
+func (m *model) sendChat(p protocol.ChatPayload) error {
+	fresh := m.noEcho() && p.Ref == "" // not one sent again after a rate limit
+	if fresh {
+		p.Ref = m.newRef()
+	}
+	if err := sendPkt(m.conn, protocol.TypeChat, p); err != nil {
+		return err
+	}
+	if fresh {
+		m.showSending(p)
+	}
+	m.queueSent(p)
+	m.inFlight = &p
+	return nil
+}
+
+// chatEchoed forgets the message in flight once the server broadcasts it,
+// or acknowledges it (see echo.go).
+func (m *model) chatEchoed(b protocol.BroadcastPayload) {
+	if f := m.inFlight; f != nil && strings.EqualFold(b.Username, m.me) && b.Channel == f.Channel && b.Content == f.Content {
+		m.inFlight = nil
+	}
+}
+
+// rateLimited starts the cooldown of an ErrRateLimited failure.
+func (m *model) rateLimited(r protocol.ResponsePayload) {
+	var rl protocol.RateLimitPayload
+	if err := json.Unmarshal(r.Data, &rl); err != nil || rl.RetryAfterMS <= 0 {
+		m.fail(strings.TrimPrefix(r.Message, "error: "))
+		return
+	}
+	if rl.Type == protocol.TypeChat && m.inFlight != nil && m.blocked == nil {
+		m.blocked, m.inFlight = m.inFlight, nil
+		m.unqueue(m.blocked)
+	}
+	start := m.coolUntil.IsZero()
+	m.coolUntil = time.Now().Add(time.Duration(rl.RetryAfterMS) * time.Millisecond)
+	if start {
+		m.next = tea.Batch(m.next, cooldownTick())
+	}
+	msg := fmt.Sprintf("⏳ slow down: at most %d messages a minute", rl.MessagesPerMinute)
+	if m.blocked != nil {
+		msg += "; your message is sent again when allowed"
+	}
+	m.warn(msg)
+}
+
+// coolDown ends the cooldown once it is over, sending the refused message,
+// or keeps counting.
+func (m model) coolDown() (model, tea.Cmd) {
+	if m.coolUntil.IsZero() {
+		return m, nil
+	}
+	if time.Now().Before(m.coolUntil) {
+		return m, cooldownTick()
+	}
+	m.coolUntil = time.Time{}
+	if p := m.blocked; p != nil {
+		m.blocked = nil
+		if err := m.sendChat(*p); err != nil {
+			m.notSent(p)
+			m.fail("send failed: " + err.Error())
+		}
+	}
+	return m, nil
+}
+
+// coolingDown reports whether Enter is held back.
+func (m model) coolingDown() bool {
+	return !m.coolUntil.IsZero()
+}
+
+// cooldownLine is the footer's countdown, or "".
+func (m model) cooldownLine() string {
+	if !m.coolingDown() {
+		return ""
+	}
+	left := max(time.Until(m.coolUntil).Round(time.Second), time.Second)
+	if m.blocked != nil {
+		return fmt.Sprintf("⏳ rate limited: resending your message in %s", left)
+	}
+	return fmt.Sprintf("⏳ rate limited: you can send again in %s", left)
+}
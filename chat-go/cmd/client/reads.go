@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Read receipts
+// ---------------------------------------------------------------------------
+//
+// With FeatureReads the client marks the conversation on screen read up to
+// its latest message whenever that changes, and asks for its read marks
+// after login to restore the unread counts of the others.  Receipts for
+// the user's latest message in the conversation on screen show above the
+// input as "✓ seen by …"; /seen asks the server who has read a message.
+
+// markRead tells the server the conversation on screen is read up to its
+// latest message, if it has not been told already.
+func (m *model) markRead() {
+	if m.conn == nil || !m.supports(protocol.FeatureReads) {
+		return
+	}
+	b, ok := m.latestIn(m.channel, "")
+	if !ok || m.marked[m.channel] == b.ID {
+		return
+	}
+	sendPkt(m.conn, protocol.TypeMarkRead, protocol.MarkReadPayload{Channel: m.channel, ID: b.ID})
+	m.marked[m.channel] = b.ID
+}
+
+// latestIn returns the message of channel with the highest Seq among the
+// recent ones, only user's when user is set.
+func (m model) latestIn(channel, user string) (protocol.BroadcastPayload, bool) {
+	var last protocol.BroadcastPayload
+	for _, b := range m.recent {
+		if b.Channel == channel && b.ID != "" && b.Seq >= last.Seq &&
+			(user == "" || strings.EqualFold(b.Username, user)) {
+			last = b
+		}
+	}
+	return last, last.ID != ""
+}
+
+// showRead notes a receipt for the user's latest message in its
+// conversation.  Receipts for earlier ones are not shown.
+func (m *model) showRead(r protocol.ReadPayload) {
+	own, ok := m.latestIn(r.Channel, m.me)
+	if !ok || r.Seq < own.Seq || slices.Contains(m.seenBy[r.Channel], r.User) {
+		return
+	}
+	if m.seenBy == nil {
+		m.seenBy = make(map[string][]string)
+	}
+	m.seenBy[r.Channel] = append(m.seenBy[r.Channel], r.User)
+}
+
+// seenLine says who has seen the user's latest message on screen, or is "".
+func (m model) seenLine() string {
+	names := slices.Sorted(slices.Values(m.seenBy[m.channel]))
+	if len(names) == 0 {
+		return ""
+	}
+	return "✓ seen by " + joinNames(names)
+}
+
+// showUnread restores the unread counts after login.
+func (m *model) showUnread(marks []protocol.ReadMark) {
+	for _, mk := range marks {
+		m.marked[mk.Channel] = mk.ID
+		switch {
+		case mk.Unread == 0 || m.muted[mk.Channel]:
+		case mk.Channel == m.channel:
+			m.appendChat(hintStyle.Render(fmt.Sprintf("%d new message(s) since you last read this conversation", mk.Unread)))
+		default:
+			cv := m.conv(mk.Channel)
+			cv.unread = max(cv.unread, mk.Unread)
+		}
+	}
+}
+
+func cmdSeen(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 1 {
+		m.warn("usage: " + commands["seen"].usage)
+		return m, nil
+	}
+	id := ""
+	if len(args) == 1 {
+		id = args[0]
+	} else if b, ok := m.latestIn(m.channel, m.me); ok {
+		id = b.ID
+	}
+	if id == "" {
+		m.warn("no message of yours here")
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeReaders, protocol.ReadersPayload{ID: id})
+	m.waitReaders = true
+	return m, nil
+}
+
+func (m *model) showReaders(r protocol.ReadersPayload) {
+	if len(r.Users) == 0 {
+		m.appendChat(sysStyle.Render("nobody has read message " + r.ID + " yet"))
+		return
+	}
+	m.appendChat(sysStyle.Render(fmt.Sprintf("message %s was read by %s", r.ID, strings.Join(r.Users, ", "))))
+}
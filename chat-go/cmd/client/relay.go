@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Relay grants
+// ---------------------------------------------------------------------------
+//
+// Bridge bots post for people elsewhere under relay identities, each named
+// with a prefix an admin granted the bot (FeatureRelay).  /relay lists and
+// manages the grants; relayed messages show the bot after the name, and,
+// when the bot passed on a signature the server verified (FeatureSigning),
+// who wrote the message on which server, with a ✓.
+
+func cmdRelay(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		sendPkt(m.conn, protocol.TypeRelay, protocol.RelayPayload{})
+		m.waitRelay = true
+		return m, nil
+	}
+	if len(args) != 3 || args[0] != "grant" && args[0] != "revoke" {
+		m.warn("usage: " + commands["relay"].usage)
+		return m, nil
+	}
+	sendPkt(m.conn, protocol.TypeRelay, protocol.RelayPayload{
+		User:   strings.TrimPrefix(args[1], "@"),
+		Prefix: args[2],
+		Revoke: args[0] == "revoke",
+	})
+	return m, nil
+}
+
+// renderRelayGrants lists the grants of a /relay answer.
+func (m *model) renderRelayGrants(grants []protocol.RelayGrant) {
+	if len(grants) == 0 {
+		m.appendChat(hintStyle.Render("  (no relay grants)"))
+		return
+	}
+	for _, g := range grants {
+		m.appendChat(hintStyle.Render("  " + g.Username + ": " + strings.Join(g.Prefixes, "…, ") + "…"))
+	}
+}
+
+// senderName is how b's author is shown: relayed messages name the bot too,
+// and their verified origin.
+func senderName(b protocol.BroadcastPayload) string {
+	if b.Via == "" {
+		return b.Username
+	}
+	if o := b.Origin; o != nil {
+		return b.Username + " (via " + b.Via + ", " + o.Username + "@" + o.Server + " ✓)"
+	}
+	return b.Username + " (via " + b.Via + ")"
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message revisions
+// ---------------------------------------------------------------------------
+//
+// Moderators can see what removed messages said (FeatureRevisions).
+// /revisions takes a message ID, @user or #channel, in any combination.
+
+func cmdRevisions(m model, args []string) (model, tea.Cmd) {
+	var p protocol.RevisionsPayload
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "@"):
+			p.User = a[1:]
+		case strings.HasPrefix(a, "#"):
+			p.Channel = a
+		default:
+			p.ID = a
+		}
+	}
+	sendPkt(m.conn, protocol.TypeRevisions, p)
+	m.waitRevisions = true
+	return m, nil
+}
+
+// renderRevisions lists the revisions of a /revisions answer.
+func (m *model) renderRevisions(revs []protocol.MessageRevision) {
+	if len(revs) == 0 {
+		m.appendChat(hintStyle.Render("  (no revisions)"))
+		return
+	}
+	for _, r := range revs {
+		line := fmt.Sprintf("  %s %s by %s", r.At.Local().Format("2006-01-02 15:04"), r.Action, r.By)
+		if r.Reason != "" {
+			line += " (" + r.Reason + ")"
+		}
+		m.appendChat(hintStyle.Render(line))
+		msg := r.Message
+		m.appendChat(fmt.Sprintf("    [%s %s] %s %s: %s", msg.ID, msg.Timestamp.Local().Format("01-02 15:04"),
+			channelLabel(msg.Channel), msg.Username, msg.Content))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Roster
+// ---------------------------------------------------------------------------
+//
+// With FeatureRoster the server sends who is online after login
+// (TypeUserList) and every user who comes or goes after that
+// (TypeUserJoined, TypeUserLeft), and the header's online count comes from
+// that roster alone.  Older servers only say it in their join and leave
+// notices, which showPresence counts instead.
+//
+// With FeatureRosterDiff the client asks for the changes in batches
+// (TypeRosterDiff).  They are numbered after the list; when one is missing
+// the client asks for the list again (TypeRoster), meanwhile applying what
+// it has, since a diff only says who is online now and who is not.
+
+// setRoster replaces the roster with the server's list.
+func (m *model) setRoster(l protocol.UserListPayload) {
+	m.roster = make(map[string]string, len(l.Users))
+	for _, u := range l.Users {
+		m.roster[u.UserID] = u.Username
+	}
+	m.rosterRev = l.Rev
+	m.onlineCount = len(m.roster)
+}
+
+// applyRosterDiff applies a TypeRosterDiff, asking for the whole list
+// when one before it went missing.
+func (m *model) applyRosterDiff(d protocol.RosterDiffPayload) {
+	if m.roster == nil {
+		m.roster = make(map[string]string)
+	}
+	if d.Rev != m.rosterRev+1 {
+		sendPkt(m.conn, protocol.TypeRoster, map[string]string{})
+	}
+	m.rosterRev = d.Rev
+	for _, u := range d.Joined {
+		m.roster[u.UserID] = u.Username
+	}
+	for _, id := range d.Left {
+		delete(m.roster, id)
+	}
+	m.onlineCount = cmp.Or(d.Online, len(m.roster))
+}
+
+// updateRoster applies a TypeUserJoined or TypeUserLeft.
+func (m *model) updateRoster(t protocol.MessageType, r protocol.RosterPayload) {
+	if m.roster == nil {
+		m.roster = make(map[string]string)
+	}
+	if t == protocol.TypeUserJoined {
+		m.roster[r.User.UserID] = r.User.Username
+	} else {
+		delete(m.roster, r.User.UserID)
+	}
+	m.onlineCount = cmp.Or(r.Online, len(m.roster))
+}
+
+func cmdWho(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 0 {
+		m.warn("usage: " + commands["who"].usage)
+		return m, nil
+	}
+	names := slices.SortedFunc(maps.Values(m.roster), func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	m.appendChat(sysStyle.Render(fmt.Sprintf("%d online: %s", len(names), strings.Join(names, ", "))))
+	return m, nil
+}
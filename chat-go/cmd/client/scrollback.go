@@ -0,0 +1,172 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Scrollback
+// ---------------------------------------------------------------------------
+//
+// A conversation's scrollback is a list of entries, not of strings: a
+// message keeps its server ID and the payload it was rendered from, and a
+// poll its ID, so a packet about something already shown finds its entry
+// and redraws it in place.  A message delivered again live replaces its
+// entry; a deletion turns it into a tombstone that stays one; translations
+// are kept under it.
+//
+// History, cache and gap splices insert older material around what is
+// shown.  An entry they bring that is already there is dropped, since the
+// one shown is at least as new and in the right place: a message that
+// arrived live while its history was on the way, or is both cached and in
+// the server's catch-up after a reconnect, is shown once.  Lines that are
+// not messages or polls, like notices, have no ID and are never merged.
+
+// chatLine is one entry of the scrollback.
+type chatLine struct {
+	id    string                     // message ID, or ""
+	poll  string                     // poll ID, or ""
+	ref   string                     // Ref of an own message on its way, see echo.go; or ""
+	msg   *protocol.BroadcastPayload // the message shown; nil when deleted
+	text  string                     // as rendered
+	notes []string                   // shown under text, e.g. translations
+}
+
+// rows is how many screen rows l takes, before wrapping.
+func (l chatLine) rows() int {
+	return strings.Count(l.text, "\n") + 1 + len(l.notes)
+}
+
+func (l chatLine) String() string {
+	if len(l.notes) == 0 {
+		return l.text
+	}
+	return l.text + "\n" + strings.Join(l.notes, "\n")
+}
+
+// scrollback is the entries of a conversation, oldest first, with the
+// index of each message and poll.  The zero value is empty and ready.
+type scrollback struct {
+	lines []chatLine
+	msgs  map[string]int // message ID → index in lines
+	polls map[string]int // poll ID → index in lines
+}
+
+func (s *scrollback) len() int { return len(s.lines) }
+
+// message returns the entry of the message with the given ID.
+func (s *scrollback) message(id string) (*chatLine, bool) {
+	i, ok := s.msgs[id]
+	if !ok || i >= len(s.lines) {
+		return nil, false
+	}
+	return &s.lines[i], true
+}
+
+// pollLine returns the entry of the poll with the given ID.
+func (s *scrollback) pollLine(id string) (*chatLine, bool) {
+	i, ok := s.polls[id]
+	if !ok || i >= len(s.lines) {
+		return nil, false
+	}
+	return &s.lines[i], true
+}
+
+// add appends l, or when l is a message or poll already shown redraws its
+// entry in place; it reports whether l was appended.  A deleted message
+// stays deleted.
+func (s *scrollback) add(l chatLine) bool {
+	if old, ok := s.find(l); ok {
+		if old.id == "" || old.msg != nil {
+			old.msg, old.text = l.msg, l.text
+		}
+		return false
+	}
+	s.lines = append(s.lines, l)
+	s.index(len(s.lines)-1, l)
+	return true
+}
+
+// insert puts ls in front of the entry at index at, leaving out the
+// messages and polls already shown.  It returns how many rows it added.
+func (s *scrollback) insert(at int, ls []chatLine) int {
+	ls = slices.DeleteFunc(slices.Clone(ls), func(l chatLine) bool {
+		_, ok := s.find(l)
+		return ok
+	})
+	ls = dedupe(ls)
+	if len(ls) == 0 {
+		return 0
+	}
+	s.lines = slices.Insert(s.lines, min(at, len(s.lines)), ls...)
+	s.reindex()
+	rows := 0
+	for _, l := range ls {
+		rows += l.rows()
+	}
+	return rows
+}
+
+// rowsBefore is how many screen rows the entries before index i take.
+func (s *scrollback) rowsBefore(i int) int {
+	rows := 0
+	for _, l := range s.lines[:min(i, len(s.lines))] {
+		rows += l.rows()
+	}
+	return rows
+}
+
+// strings renders every entry.
+func (s *scrollback) strings() []string {
+	out := make([]string, len(s.lines))
+	for i, l := range s.lines {
+		out[i] = l.String()
+	}
+	return out
+}
+
+func (s *scrollback) find(l chatLine) (*chatLine, bool) {
+	switch {
+	case l.id != "":
+		return s.message(l.id)
+	case l.poll != "":
+		return s.pollLine(l.poll)
+	}
+	return nil, false
+}
+
+func (s *scrollback) index(i int, l chatLine) {
+	if s.msgs == nil {
+		s.msgs, s.polls = make(map[string]int), make(map[string]int)
+	}
+	switch {
+	case l.id != "":
+		s.msgs[l.id] = i
+	case l.poll != "":
+		s.polls[l.poll] = i
+	}
+}
+
+func (s *scrollback) reindex() {
+	s.msgs, s.polls = nil, nil
+	for i, l := range s.lines {
+		s.index(i, l)
+	}
+}
+
+// dedupe drops the messages and polls of ls that an earlier entry of ls
+// already shows.
+func dedupe(ls []chatLine) []chatLine {
+	var seen scrollback
+	return slices.DeleteFunc(ls, func(l chatLine) bool {
+		return !seen.add(l)
+	})
+}
+
+// messageLine renders b as a scrollback entry.
+func (m model) messageLine(b protocol.BroadcastPayload) chatLine {
+	return chatLine{id: b.ID, msg: &b, text: m.renderMessage(b)}
+}
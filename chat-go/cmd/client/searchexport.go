@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Search export
+// ---------------------------------------------------------------------------
+//
+// Ctrl+S in the search overlay, or /savesearch after closing it, writes the
+// results of the last search to a local file, so an incident can be
+// written up without copying from the terminal.  The file is CSV, or JSON
+// when its name ends in .json; without a name it is search-<time>.csv in
+// the current directory.  Like downloads, an existing file is never
+// overwritten.  Results can include direct messages, so the file is
+// private to the user.
+
+// searchColumns are the CSV columns, one row per message.
+var searchColumns = []string{"time", "conversation", "user", "message", "id"}
+
+func cmdSaveSearch(m model, args []string) (model, tea.Cmd) {
+	if len(args) > 1 {
+		m.warn("usage: " + commands["savesearch"].usage)
+		return m, nil
+	}
+	dest := ""
+	if len(args) == 1 {
+		dest = args[0]
+	}
+	path, err := m.saveSearch(dest)
+	if err != nil {
+		m.warn(err.Error())
+		return m, nil
+	}
+	m.appendChat(successStyle.Render(fmt.Sprintf("saved %d search result(s) to %s", len(m.searchResults), path)))
+	return m, nil
+}
+
+// saveSearch writes m.searchResults to dest, or to a new file named after
+// the time, and returns the path it wrote.
+func (m model) saveSearch(dest string) (string, error) {
+	if len(m.searchResults) == 0 {
+		return "", errors.New("no search results to save")
+	}
+	if dest == "" {
+		dest = "search-" + time.Now().Format("20060102-150405") + ".csv"
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(filepath.Ext(dest), ".json") {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(m.searchResults)
+	} else {
+		err = m.writeSearchCSV(out)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+func (m model) writeSearchCSV(out *os.File) error {
+	w := csv.NewWriter(out)
+	w.Write(searchColumns)
+	for _, r := range m.searchResults {
+		w.Write([]string{
+			r.Timestamp.UTC().Format(time.RFC3339),
+			m.searchLabel(r),
+			r.Username,
+			r.Content,
+			r.ID,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// exportSearch is Ctrl+S in the search overlay.
+func (m model) exportSearch() (model, tea.Cmd) {
+	path, err := m.saveSearch("")
+	if err != nil {
+		m.searchStatus = errorStyle.Render(err.Error())
+		return m, nil
+	}
+	m.searchStatus = successStyle.Render(fmt.Sprintf("saved %d result(s) to %s", len(m.searchResults), path))
+	return m, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Sequence gaps
+// ---------------------------------------------------------------------------
+//
+// The server numbers each channel's messages 1, 2, 3… (FeatureSeq).  A live
+// broadcast whose Seq jumps past the last one seen means messages were lost
+// on the way — the send buffer overflowed, or the connection blipped — so
+// the client asks for exactly that range and splices the answer in where
+// the messages belong, just above the message that revealed the gap.
+//
+// A conversation restored from the message cache starts with an open gap
+// (before 0) after its last cached message; the first live message closes
+// it, so the answer and the live messages do not overlap.
+
+// seqGap is a range of missing messages waiting for the server's answer.
+type seqGap struct {
+	channel       string
+	after, before uint64 // missing: after < Seq < before (or all after, while before is 0)
+	anchor        string // ID of the message at Seq before
+	resume        bool   // catching up after the cache: no notifications
+}
+
+// trackSeq records b's sequence number and requests whatever b skipped.
+func (m *model) trackSeq(b protocol.BroadcastPayload) {
+	if b.Seq == 0 {
+		return
+	}
+	last := m.seqs[b.Channel]
+	m.seqs[b.Channel] = max(last, b.Seq)
+	open := slices.IndexFunc(m.gaps, func(g seqGap) bool { return g.channel == b.Channel && g.before == 0 })
+	if !m.replaying && open >= 0 {
+		m.gaps[open].before, m.gaps[open].anchor = b.Seq, b.ID
+		return
+	}
+	if m.replaying || last == 0 || b.Seq <= last+1 {
+		return
+	}
+	if b.Channel != m.channel && !m.conv(b.Channel).loaded {
+		return // its history is fetched in full when it is opened
+	}
+	g := seqGap{channel: b.Channel, after: last, before: b.Seq, anchor: b.ID}
+	m.gaps = append(m.gaps, g)
+	m.requestGap(g)
+}
+
+func (m *model) requestGap(g seqGap) {
+	sendPkt(m.conn, protocol.TypeHistory, protocol.HistoryPayload{
+		Channel:   g.channel,
+		AfterSeq:  g.after,
+		BeforeSeq: g.before,
+		Limit:     olderPageSize,
+		Batch:     true,
+	})
+}
+
+// fillGap splices a BatchGap answer into its conversation.  The server
+// answers a connection's requests in order, so the batch belongs to the
+// oldest pending gap of its channel.
+func (m model) fillGap(b protocol.BatchPayload) model {
+	if b.Channel != m.channel {
+		cur := m.channel
+		m.swapView(b.Channel)
+		m = m.fillGap(b)
+		m.swapView(cur)
+		return m
+	}
+	i := slices.IndexFunc(m.gaps, func(g seqGap) bool { return g.channel == b.Channel })
+	if i < 0 {
+		return m
+	}
+	g := m.gaps[i]
+
+	var (
+		lines []chatLine
+		last  = g.after
+	)
+	for _, pkt := range b.Packets {
+		var msg protocol.BroadcastPayload
+		if json.Unmarshal(pkt.Payload, &msg) != nil || msg.Seq <= last || (g.before != 0 && msg.Seq >= g.before) {
+			continue
+		}
+		m.remember(msg)
+		m.cacheMessage(msg)
+		if !g.resume {
+			m.notify(msg)
+		}
+		lines = append(lines, m.messageLine(msg))
+		last = msg.Seq
+	}
+	if b.More && (g.before == 0 || last+1 < g.before) {
+		g.after = last
+		m.gaps[i] = g
+		m.requestGap(g)
+	} else {
+		m.gaps = slices.Delete(m.gaps, i, i+1)
+		if missing := int(g.before-g.after-1) - len(lines); g.before != 0 && missing > 0 {
+			lines = append(lines, chatLine{text: errorStyle.Render(fmt.Sprintf("⚠ %d message(s) could not be recovered", missing))})
+		}
+	}
+
+	at, ok := m.scroll.msgs[g.anchor]
+	if !ok {
+		at = m.scroll.len()
+	}
+	m.scroll.insert(at, lines)
+	m.refreshChat()
+	return m
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// First-run setup
+// ---------------------------------------------------------------------------
+//
+// When there is no profile file and no flags were given (or with -setup),
+// the client opens with a short wizard instead of the login form: it asks
+// for the server address, creates an account there (or signs in to an
+// existing one), lets the user pick a theme with a live preview, and saves
+// the result as the default profile.  The wizard's connection is closed
+// afterwards and the client starts from the new profile as usual, so a
+// server that issues session tokens logs the user straight in.
+//
+// Passwords are never written; without a token the saved profile only
+// pre-fills the username.  Esc skips the wizard and nothing is saved.
+
+type setupStep int
+
+const (
+	setupAddr setupStep = iota
+	setupAccount
+	setupTheme
+)
+
+// setupDialedMsg reports the wizard's dial to the server.
+type setupDialedMsg struct {
+	conn net.Conn
+	pkts chan []byte
+	err  error
+}
+
+// setupModel is the wizard.  It is a separate tea.Model run before the chat
+// client's own program.
+type setupModel struct {
+	path string // profile file to write
+	step setupStep
+
+	addr     textinput.Model
+	fields   [2]textinput.Model // [0]=username  [1]=password
+	focus    int
+	register bool // create an account rather than sign in
+
+	conn  net.Conn
+	pkts  chan []byte
+	hello *protocol.HelloPayload
+	user  string // account signed in to
+	token string // session token, "" when the server issues none
+
+	themes   []string
+	themeSel int
+
+	status string
+	busy   bool // waiting on the server
+
+	// challenged is the registration waiting for its proof-of-work
+	// challenge, and next the command solving it; see pow.go.
+	challenged *protocol.AuthPayload
+	next       tea.Cmd
+
+	name   string // profile saved; "" when skipped
+	quit   bool   // Ctrl+C: leave the client altogether
+	width  int
+	height int
+}
+
+func newSetupModel(path, addr string) setupModel {
+	a := textinput.New()
+	a.Placeholder = "host:port or tls://host:port"
+	a.SetValue(addr)
+	a.CharLimit = 256
+	a.Width = 40
+	a.Focus()
+
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return setupModel{path: path, addr: a, fields: newLoginFields(), register: true, themes: names}
+}
+
+// runSetup runs the wizard and returns its final state.
+func runSetup(path, addr string) (setupModel, error) {
+	final, err := tea.NewProgram(newSetupModel(path, addr), tea.WithAltScreen()).Run()
+	m, _ := final.(setupModel)
+	m.hangUp()
+	return m, err
+}
+
+func (m setupModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m setupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		return m, nil
+
+	case setupDialedMsg:
+		m.busy = false
+		if msg.err != nil {
+			m.status = "could not connect: " + msg.err.Error()
+			return m, nil
+		}
+		m.conn, m.pkts = msg.conn, msg.pkts
+		m.step, m.status = setupAccount, ""
+		m.addr.Blur()
+		return m, tea.Batch(textinput.Blink, waitForPkt(m.pkts))
+
+	case serverPktMsg:
+		if msg.src != m.pkts {
+			return m, nil
+		}
+		m = m.handlePkt(msg.data)
+		if m.pkts == nil {
+			return m, nil // hung up after signing in
+		}
+		next := m.next
+		m.next = nil
+		return m, tea.Batch(waitForPkt(m.pkts), next)
+
+	case workDoneMsg:
+		if msg.conn != m.conn || m.step != setupAccount {
+			return m, nil
+		}
+		sendPkt(m.conn, protocol.TypeRegister, msg.auth)
+		m.status = "Creating your account…"
+		return m, nil
+
+	case disconnectedMsg:
+		if msg.src != m.pkts || m.step != setupAccount {
+			return m, nil
+		}
+		m.hangUp()
+		m = m.toAddr()
+		m.status = "the server closed the connection"
+		return m, textinput.Blink
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			m.quit = true
+			return m, tea.Quit
+		case tea.KeyEsc:
+			return m, tea.Quit
+		}
+		switch m.step {
+		case setupAddr:
+			return m.handleAddrKey(msg)
+		case setupAccount:
+			return m.handleAccountKey(msg)
+		case setupTheme:
+			return m.handleThemeKey(msg)
+		}
+	}
+	return m, nil
+}
+
+func (m setupModel) handleAddrKey(msg tea.KeyMsg) (setupModel, tea.Cmd) {
+	if msg.Type == tea.KeyEnter {
+		if m.busy {
+			return m, nil
+		}
+		addr := strings.TrimSpace(m.addr.Value())
+		if addr == "" {
+			m.status = "enter the server's address"
+			return m, nil
+		}
+		m.busy, m.status = true, "Connecting to "+addr+"…"
+		return m, func() tea.Msg {
+			conn, pkts, err := dialServer(profile{Addr: addr})
+			return setupDialedMsg{conn: conn, pkts: pkts, err: err}
+		}
+	}
+	var cmd tea.Cmd
+	m.addr, cmd = m.addr.Update(msg)
+	return m, cmd
+}
+
+func (m setupModel) handleAccountKey(msg tea.KeyMsg) (setupModel, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyTab, tea.KeyShiftTab:
+		m.focus = (m.focus + 1) % 2
+		for i := range m.fields {
+			if i == m.focus {
+				m.fields[i].Focus()
+			} else {
+				m.fields[i].Blur()
+			}
+		}
+		return m, textinput.Blink
+
+	case tea.KeyCtrlR:
+		if !m.canRegister() {
+			m.status = "this server does not allow registration"
+			return m, nil
+		}
+		m.register = !m.register
+		m.status = ""
+		return m, nil
+
+	case tea.KeyCtrlB:
+		m.hangUp()
+		return m.toAddr(), textinput.Blink
+
+	case tea.KeyEnter:
+		if m.busy {
+			return m, nil
+		}
+		user := strings.TrimSpace(m.fields[0].Value())
+		pass := m.fields[1].Value()
+		if user == "" || pass == "" {
+			m.status = "username and password are required"
+			return m, nil
+		}
+		auth := protocol.AuthPayload{Username: user, Password: pass}
+		switch {
+		case m.register && m.canRegister() && m.hello != nil && m.hello.HasFeature(protocol.FeatureProofOfWork):
+			askChallenge(m.conn)
+			m.challenged = &auth
+			m.status = "Asking for a challenge…"
+		case m.register && m.canRegister():
+			sendPkt(m.conn, protocol.TypeRegister, auth)
+			m.status = "Creating your account…"
+		default:
+			sendPkt(m.conn, protocol.TypeLogin, auth)
+			m.status = "Signing in…"
+		}
+		m.busy = true
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.fields[m.focus], cmd = m.fields[m.focus].Update(msg)
+	return m, cmd
+}
+
+func (m setupModel) handleThemeKey(msg tea.KeyMsg) (setupModel, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyUp:
+		if m.themeSel > 0 {
+			m.themeSel--
+		}
+	case tea.KeyDown:
+		if m.themeSel < len(m.themes)-1 {
+			m.themeSel++
+		}
+	case tea.KeyEnter:
+		return m.save()
+	}
+	applyTheme(m.themes[m.themeSel])
+	return m, nil
+}
+
+// handlePkt handles the packets the wizard cares about: the server's hello
+// and the answer to the register or login.
+func (m setupModel) handlePkt(data []byte) setupModel {
+	var pkt protocol.Packet
+	if err := json.Unmarshal(data, &pkt); err != nil {
+		return m
+	}
+	switch pkt.Type {
+	case protocol.TypeHello:
+		var h protocol.HelloPayload
+		if json.Unmarshal(pkt.Payload, &h) == nil {
+			m.hello = &h
+			m.register = m.canRegister()
+		}
+
+	case protocol.TypeResponse:
+		if !m.busy {
+			return m
+		}
+		var r protocol.ResponsePayload
+		if err := json.Unmarshal(pkt.Payload, &r); err != nil {
+			return m
+		}
+		if m.challenged != nil {
+			auth := *m.challenged
+			m.challenged = nil
+			m.status, m.next = solveChallenge(m.conn, auth, r)
+			m.busy = m.next != nil
+			return m
+		}
+		m.busy = false
+		if !r.Success {
+			m.status = r.Message
+			return m
+		}
+		m.user = extractQuoted(r.Message)
+		var sess protocol.SessionPayload
+		if len(r.Data) > 0 && json.Unmarshal(r.Data, &sess) == nil {
+			m.token = sess.Token
+		}
+		sendPkt(m.conn, protocol.TypeQuit, map[string]string{})
+		m.hangUp()
+		m.step, m.status = setupTheme, ""
+		for i, name := range m.themes {
+			if name == "dark" {
+				m.themeSel = i
+			}
+		}
+		applyTheme(m.themes[m.themeSel])
+	}
+	return m
+}
+
+// save adds the new profile to the profile file and makes it the default.
+func (m setupModel) save() (setupModel, tea.Cmd) {
+	pf, err := loadProfiles(m.path)
+	if err != nil {
+		m.status = err.Error()
+		return m, nil
+	}
+	if pf.Profiles == nil {
+		pf.Profiles = make(map[string]profile)
+	}
+	addr := strings.TrimSpace(m.addr.Value())
+	p := profile{Addr: addr, Username: m.user, Token: m.token, Theme: m.themes[m.themeSel]}
+	if p.Token != "" {
+		p.Username = ""
+	}
+	name := profileName(addr)
+	pf.Profiles[name] = p
+	pf.Default = name
+	if err := saveProfiles(m.path, pf); err != nil {
+		m.status = "could not save: " + err.Error()
+		return m, nil
+	}
+	m.name = name
+	return m, tea.Quit
+}
+
+// profileName names the profile for addr after its host.
+func profileName(addr string) string {
+	host := strings.TrimPrefix(addr, tlsPrefix)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return "default"
+	}
+	return host
+}
+
+func (m setupModel) canRegister() bool {
+	return m.hello == nil || m.hello.HasFeature(protocol.FeatureRegister)
+}
+
+// toAddr returns to the first step, keeping what was typed.
+func (m setupModel) toAddr() setupModel {
+	m.step, m.status, m.busy = setupAddr, "", false
+	m.addr.Focus()
+	return m
+}
+
+// hangUp closes the wizard's connection, if any.  Its reader's remaining
+// packets no longer match m.pkts and are ignored.
+func (m *setupModel) hangUp() {
+	if m.conn != nil {
+		m.conn.Close()
+	}
+	m.conn, m.pkts, m.hello = nil, nil, nil
+}
+
+func (m setupModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	title := titleStyle.Render("  Welcome to GoChat  ")
+
+	var body []string
+	switch m.step {
+	case setupAddr:
+		body = []string{
+			hintStyle.Render("Step 1 of 3 · Which server do you want to chat on?"),
+			"",
+			focusedLabelStyle.Render("Server") + "  " + m.addr.View(),
+			"",
+			hintStyle.Render("Enter: connect   Esc: skip setup   Ctrl+C: quit"),
+		}
+
+	case setupAccount:
+		mode, other := "sign in", "create an account"
+		if m.register && m.canRegister() {
+			mode, other = "create account", "sign in instead"
+		}
+		keys := fmt.Sprintf("Tab: switch field   Enter: %s   Ctrl+R: %s", mode, other)
+		if !m.canRegister() {
+			keys = "Tab: switch field   Enter: sign in"
+		}
+		label := func(i int, s string) string {
+			if i == m.focus {
+				return focusedLabelStyle.Render(s)
+			}
+			return labelStyle.Render(s)
+		}
+		step := "Step 2 of 3 · Sign in to " + m.addr.Value()
+		if m.register && m.canRegister() {
+			step = "Step 2 of 3 · Create your account on " + m.addr.Value()
+		}
+		body = []string{
+			hintStyle.Render(step),
+			"",
+			label(0, "Username") + "  " + m.fields[0].View(),
+			label(1, "Password") + "  " + m.fields[1].View(),
+			"",
+			hintStyle.Render(keys),
+			hintStyle.Render("Ctrl+B: change server   Esc: skip setup   Ctrl+C: quit"),
+		}
+
+	case setupTheme:
+		body = []string{
+			successStyle.Render("✓ signed in as " + m.user),
+			"",
+			hintStyle.Render("Step 3 of 3 · Pick a theme"),
+			"",
+		}
+		for i, name := range m.themes {
+			if i == m.themeSel {
+				body = append(body, myNameStyle.Render("▸ "+name)+"  "+peerStyle.Render("peer")+" "+sysStyle.Render("notice")+" "+errorStyle.Render("error"))
+			} else {
+				body = append(body, "  "+name)
+			}
+		}
+		body = append(body, "",
+			hintStyle.Render("↑/↓: preview   Enter: save and start chatting   Esc: skip setup"),
+			hintStyle.Render("saved to "+m.path))
+	}
+
+	status := hintStyle.Render(m.status)
+	if !m.busy {
+		status = errorStyle.Render(m.status)
+	}
+	form := lipgloss.JoinVertical(lipgloss.Left, append(append([]string{title, ""}, body...), "", status)...)
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, form)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ---------------------------------------------------------------------------
+// Spell-check
+// ---------------------------------------------------------------------------
+//
+// With a dictionary loaded, words in the compose field that it does not
+// know are underlined as you type.  The language comes from the profile's
+// "spell" field (e.g. "en_US") or /spell <language>; the dictionary is the
+// first of
+//
+//	$XDG_CONFIG_HOME/gochat/dict/<language>.txt   one word per line
+//	$XDG_CONFIG_HOME/gochat/dict/<language>.dic   hunspell format
+//	/usr/share/hunspell/<language>.dic
+//	/usr/share/myspell/<language>.dic
+//	/usr/share/dict/words                         English only
+//
+// Hunspell affix rules are not applied, so only the stems in a .dic file
+// are known; for English, common endings (-s, -ed, -ing, …) are stripped
+// before giving up on a word.  Commands, @mentions, #channels, links and
+// words with digits or in capitals are not checked.  A message too long
+// to fit the field scrolls, and is shown without underlines.
+
+// spellLang is a dictionary name such as "en", "en_US" or "pt_BR".
+var spellLang = regexp.MustCompile(`^[a-zA-Z]{2,3}([_-][a-zA-Z0-9]+)*$`)
+
+// speller knows the words of one language.
+type speller struct {
+	lang  string
+	path  string
+	words map[string]bool // lowercase
+	stems bool            // strip English endings, see known
+}
+
+// englishEndings are tried, in order, on English words not in the
+// dictionary: the ending is replaced with the given stem ending.
+var englishEndings = [][2]string{
+	{"'s", ""}, {"s", ""}, {"es", ""}, {"ies", "y"},
+	{"ed", ""}, {"ed", "e"}, {"ied", "y"}, {"ing", ""}, {"ing", "e"},
+	{"ly", ""}, {"er", ""}, {"er", "e"}, {"est", ""}, {"est", "e"},
+}
+
+// dictPaths lists where the dictionary of lang is looked for, in order.
+func dictPaths(lang string) []string {
+	var paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths,
+			filepath.Join(dir, "gochat", "dict", lang+".txt"),
+			filepath.Join(dir, "gochat", "dict", lang+".dic"))
+	}
+	paths = append(paths,
+		filepath.Join("/usr/share/hunspell", lang+".dic"),
+		filepath.Join("/usr/share/myspell", lang+".dic"))
+	if strings.HasPrefix(lang, "en") {
+		paths = append(paths, "/usr/share/dict/words")
+	}
+	return paths
+}
+
+// loadSpeller reads the dictionary of lang.
+func loadSpeller(lang string) (*speller, error) {
+	if !spellLang.MatchString(lang) {
+		return nil, fmt.Errorf("%q is not a language name such as en_US", lang)
+	}
+	lang = strings.ReplaceAll(lang, "-", "_")
+	for _, path := range dictPaths(lang) {
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sp := &speller{lang: lang, path: path, words: make(map[string]bool), stems: strings.HasPrefix(lang, "en")}
+		sc := bufio.NewScanner(f)
+		for first := true; sc.Scan(); first = false {
+			w, _, _ := strings.Cut(sc.Text(), "/") // hunspell affix flags
+			w = strings.TrimSpace(w)
+			if w == "" || w[0] == '#' || first && strings.HasSuffix(path, ".dic") && isDigits(w) {
+				continue // blank, comment or the word count of a .dic
+			}
+			sp.words[strings.ToLower(w)] = true
+		}
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(sp.words) == 0 {
+			return nil, fmt.Errorf("%s: no words", path)
+		}
+		return sp, nil
+	}
+	return nil, fmt.Errorf("no dictionary for %s; put one word per line in %s", lang, dictPaths(lang)[0])
+}
+
+func isDigits(s string) bool {
+	return strings.TrimFunc(s, unicode.IsDigit) == ""
+}
+
+// known reports whether word, in lowercase, is in the dictionary.
+func (sp *speller) known(word string) bool {
+	if sp.words[word] {
+		return true
+	}
+	if !sp.stems {
+		return false
+	}
+	for _, e := range englishEndings {
+		stem, ok := strings.CutSuffix(word, e[0])
+		if !ok || len(stem) < 2 {
+			continue
+		}
+		if sp.words[stem+e[1]] {
+			return true
+		}
+		// sitting → sit, stopped → stop
+		if n := len(stem); e[1] == "" && stem[n-1] == stem[n-2] && sp.words[stem[:n-1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// misspelled returns the words of text that are not in the dictionary, as
+// [start, end) rune offsets.
+func (sp *speller) misspelled(text string) [][2]int {
+	if sp == nil || strings.HasPrefix(text, "/") {
+		return nil
+	}
+	var bad [][2]int
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && !unicode.IsSpace(runes[end]) {
+			end++
+		}
+		bad = append(bad, sp.checkField(runes, i, end)...)
+		i = end
+	}
+	return bad
+}
+
+// checkField checks the words of runes[start:end], a run without spaces.
+func (sp *speller) checkField(runes []rune, start, end int) [][2]int {
+	field := string(runes[start:end])
+	if strings.ContainsAny(field, "@#") || strings.Contains(field, "://") || strings.HasPrefix(field, "www.") ||
+		strings.ContainsFunc(field, unicode.IsDigit) {
+		return nil
+	}
+	var bad [][2]int
+	for i := start; i < end; {
+		if !unicode.IsLetter(runes[i]) {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < end && (unicode.IsLetter(runes[j]) ||
+			(runes[j] == '\'' || runes[j] == '’') && j+1 < end && unicode.IsLetter(runes[j+1])) {
+			j++
+		}
+		word := strings.ReplaceAll(string(runes[i:j]), "’", "'")
+		if j-i > 1 && strings.ToUpper(word) != word && !sp.known(strings.ToLower(word)) {
+			bad = append(bad, [2]int{i, j})
+		}
+		i = j
+	}
+	return bad
+}
+
+// spellView renders ti like textinput.View, with the runes in bad
+// underlined.  Only a value that fits the field is handled, as textinput
+// does not scroll it then; otherwise ti.View() is returned.
+func spellView(ti textinput.Model, bad [][2]int) string {
+	value := []rune(ti.Value())
+	if len(bad) == 0 || len(value) == 0 || ti.EchoMode != textinput.EchoNormal ||
+		ti.Width > 0 && lipgloss.Width(string(value)) > ti.Width {
+		return ti.View()
+	}
+	plain := ti.TextStyle.Inline(true)
+	wrong := plain.Inherit(spellStyle)
+	pos := min(ti.Position(), len(value))
+
+	var b strings.Builder
+	b.WriteString(ti.PromptStyle.Render(ti.Prompt))
+	for i, k := 0, 0; i < len(value); {
+		for k < len(bad) && bad[k][1] <= i {
+			k++
+		}
+		if i == pos {
+			cur := ti.Cursor
+			cur.SetChar(string(value[i]))
+			b.WriteString(cur.View())
+			i++
+			continue
+		}
+		// The longest run from i that is all inside or all outside a bad
+		// word, and stops short of the cursor.
+		in := k < len(bad) && bad[k][0] <= i
+		j := len(value)
+		if in {
+			j = bad[k][1]
+		} else if k < len(bad) {
+			j = bad[k][0]
+		}
+		if i < pos {
+			j = min(j, pos)
+		}
+		style := plain
+		if in {
+			style = wrong
+		}
+		b.WriteString(style.Render(string(value[i:j])))
+		i = j
+	}
+	if pos == len(value) {
+		cur := ti.Cursor
+		cur.SetChar(" ")
+		b.WriteString(cur.View())
+	}
+	if ti.Width > 0 {
+		padding := ti.Width - lipgloss.Width(string(value))
+		if pos < len(value) {
+			padding++
+		}
+		b.WriteString(plain.Render(strings.Repeat(" ", max(0, padding))))
+	}
+	return b.String()
+}
+
+// useSpeller loads the dictionary of lang for the compose field, reporting
+// whether it could.
+func (m *model) useSpeller(lang string) bool {
+	sp, err := loadSpeller(lang)
+	if err != nil {
+		m.warn("spell-check: " + err.Error())
+		return false
+	}
+	m.speller = sp
+	return true
+}
+
+func cmdSpell(m model, args []string) (model, tea.Cmd) {
+	switch {
+	case len(args) == 0 && m.speller == nil:
+		m.appendChat(sysStyle.Render("spell-check is off; /spell <language> turns it on, e.g. /spell en_US"))
+	case len(args) == 0:
+		m.appendChat(sysStyle.Render(fmt.Sprintf("spell-checking in %s (%d words from %s); /spell off turns it off",
+			m.speller.lang, len(m.speller.words), m.speller.path)))
+	case args[0] == "off":
+		m.speller = nil
+		m.appendChat(sysStyle.Render("spell-check is off"))
+	default:
+		if m.useSpeller(args[0]) {
+			m.appendChat(successStyle.Render(fmt.Sprintf("✓ spell-checking in %s (%d words)", m.speller.lang, len(m.speller.words))))
+		}
+	}
+	return m, nil
+}
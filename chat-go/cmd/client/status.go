@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Connection status
+// ---------------------------------------------------------------------------
+//
+// The right end of the footer says how the connection is doing, e.g.
+// "● connected · 0 queued · 38ms".  Queued counts the user's messages the
+// server has not taken yet: those sent and not yet broadcast back or
+// acknowledged (see echo.go), and one held back by a rate limit (see
+// ratelimit.go).  The time is the latest round trip, of a message until it
+// was taken or of a keepalive ping.
+
+// sentChat is a message sent and not yet taken by the server.
+type sentChat struct {
+	channel, content, ref string
+	at                    time.Time
+}
+
+// queueSent counts p, just sent, as queued.
+func (m *model) queueSent(p protocol.ChatPayload) {
+	m.unacked = append(m.unacked, sentChat{channel: p.Channel, content: p.Content, ref: p.Ref, at: time.Now()})
+}
+
+// chatTaken stops counting the message the server took as b, found by the
+// ref it was sent with or else by its content, and times it.
+func (m *model) chatTaken(ref string, b protocol.BroadcastPayload) {
+	if !strings.EqualFold(b.Username, m.me) {
+		return
+	}
+	i := slices.IndexFunc(m.unacked, func(s sentChat) bool {
+		if ref != "" {
+			return s.ref == ref
+		}
+		return s.channel == b.Channel && s.content == b.Content
+	})
+	if i < 0 {
+		return
+	}
+	m.lag = time.Since(m.unacked[i].at)
+	m.unacked = slices.Delete(m.unacked, i, i+1)
+}
+
+// unqueue stops counting p, which the server refused.
+func (m *model) unqueue(p *protocol.ChatPayload) {
+	if p == nil {
+		return
+	}
+	m.unacked = slices.DeleteFunc(m.unacked, func(s sentChat) bool {
+		if p.Ref != "" {
+			return s.ref == p.Ref
+		}
+		return s.channel == p.Channel && s.content == p.Content
+	})
+}
+
+// queued is how many of the user's messages the server has not taken.
+func (m model) queued() int {
+	n := len(m.unacked)
+	if m.blocked != nil {
+		n++
+	}
+	return n
+}
+
+// connStatus renders the footer's connection status.
+func (m model) connStatus() string {
+	if m.conn == nil {
+		return errorStyle.Render("○ disconnected")
+	}
+	line := fmt.Sprintf(" · %d queued", m.queued())
+	if m.lag > 0 {
+		line += " · " + fmtLag(m.lag)
+	}
+	return successStyle.Render("● connected") + hintStyle.Render(line)
+}
+
+// fmtLag renders a round trip the way the status shows it: "38ms",
+// "1.2s".
+func fmtLag(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// withStatus puts the connection status at the right end of the footer's
+// status line, which is width columns wide, cutting left short to fit.
+func (m model) withStatus(left string, width int) string {
+	right := m.connStatus()
+	room := width - lipgloss.Width(right) - 1
+	if room < 0 {
+		return left
+	}
+	left = lipgloss.NewStyle().MaxWidth(room).Render(left)
+	return left + strings.Repeat(" ", room-lipgloss.Width(left)+1) + right
+}
@@ -0,0 +1,57 @@
+package main
+
+import "github.com/charmbracelet/lipgloss"
+
+// ---------------------------------------------------------------------------
+// Themes
+// ---------------------------------------------------------------------------
+
+// palette is the set of colours the styles in main.go are built from.
+type palette struct {
+	accent, accent2, focus lipgloss.Color // headers, search header, focused label
+	ok, bad, warn          lipgloss.Color // success, error, system notices
+	dim, text, self, peer  lipgloss.Color // hints, header text, own name, others
+}
+
+// themes is keyed by the name used in a profile's "theme" field; "" is the
+// default.
+var themes = map[string]palette{
+	"":     {purple, teal, cyan, green, red, yellow, gray, white, orange, blue},
+	"dark": {purple, teal, cyan, green, red, yellow, gray, white, orange, blue},
+	"light": {
+		lipgloss.Color("55"), lipgloss.Color("24"), lipgloss.Color("25"),
+		lipgloss.Color("28"), lipgloss.Color("160"), lipgloss.Color("130"),
+		lipgloss.Color("244"), lipgloss.Color("255"), lipgloss.Color("166"), lipgloss.Color("25"),
+	},
+	"mono": {
+		lipgloss.Color("238"), lipgloss.Color("240"), lipgloss.Color("255"),
+		lipgloss.Color("252"), lipgloss.Color("255"), lipgloss.Color("250"),
+		lipgloss.Color("244"), lipgloss.Color("255"), lipgloss.Color("255"), lipgloss.Color("252"),
+	},
+}
+
+// applyTheme recolours the global styles.  Lines already rendered into the
+// chat keep their old colours.  Unknown names fall back to the default.
+func applyTheme(name string) {
+	p, ok := themes[name]
+	if !ok {
+		p = themes[""]
+	}
+	headerStyle = headerStyle.Background(p.accent).Foreground(p.text)
+	searchHeaderStyle = searchHeaderStyle.Background(p.accent2).Foreground(p.text)
+	footerBorderStyle = footerBorderStyle.BorderForeground(p.dim)
+	titleStyle = titleStyle.Foreground(p.accent)
+	labelStyle = labelStyle.Foreground(p.dim)
+	focusedLabelStyle = focusedLabelStyle.Foreground(p.focus)
+	hintStyle = hintStyle.Foreground(p.dim)
+	successStyle = successStyle.Foreground(p.ok)
+	mentionStyle = mentionStyle.Foreground(p.warn)
+	errorStyle = errorStyle.Foreground(p.bad)
+	sysStyle = sysStyle.Foreground(p.warn)
+	tsStyle = tsStyle.Foreground(p.dim)
+	myNameStyle = myNameStyle.Foreground(p.self)
+	peerStyle = peerStyle.Foreground(p.peer)
+	divStyle = divStyle.Foreground(p.dim)
+	quoteStyle = quoteStyle.Foreground(p.dim)
+	spellStyle = spellStyle.Foreground(p.bad)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ---------------------------------------------------------------------------
+// Toasts
+// ---------------------------------------------------------------------------
+//
+// Errors the user should see but that are not part of the conversation — a
+// rejected message, a mistyped command, a failed upload — show in a one-line
+// toast over the bottom of the chat view instead of in the scrollback.  A
+// toast replaces the previous one and goes away after toastTTL or on Esc.
+// Things that belong where they happened in the conversation, such as a gap
+// in the message sequence, still go to the scrollback.
+
+const toastTTL = 5 * time.Second
+
+type severity int
+
+const (
+	sevWarn  severity = iota // the user can fix it: usage, unsupported command
+	sevError                 // something failed: server refusal, I/O error
+)
+
+// toast is the message on show; text is "" when there is none.
+type toast struct {
+	text  string
+	sev   severity
+	id    int  // distinguishes a toast from the one it replaced
+	armed bool // its expiry timer is running
+}
+
+// toastExpiredMsg dismisses toast id if it is still on show.
+type toastExpiredMsg struct{ id int }
+
+// warn shows a warning toast.
+func (m *model) warn(text string) { m.showToast(sevWarn, text) }
+
+// fail shows an error toast.
+func (m *model) fail(text string) { m.showToast(sevError, text) }
+
+func (m *model) showToast(sev severity, text string) {
+	m.toast = toast{text: text, sev: sev, id: m.toast.id + 1}
+}
+
+// armToast starts the expiry timer of a new toast.  Update calls it after
+// every message, so code that shows a toast need not return a command.
+func (m model) armToast(cmd tea.Cmd) (model, tea.Cmd) {
+	if m.toast.text == "" || m.toast.armed {
+		return m, cmd
+	}
+	m.toast.armed = true
+	id := m.toast.id
+	return m, tea.Batch(cmd, tea.Tick(toastTTL, func(time.Time) tea.Msg { return toastExpiredMsg{id} }))
+}
+
+// renderToast draws the toast across width columns.
+func (m model) renderToast(width int) string {
+	style := sysStyle
+	if m.toast.sev == sevError {
+		style = errorStyle
+	}
+	return style.Reverse(true).Bold(true).Width(width).MaxHeight(1).Render(" ⚠ " + m.toast.text)
+}
+
+// withToast lays the toast, if any, over the last line of the view v, which
+// is width columns wide.
+func (m model) withToast(v string, width int) string {
+	if m.toast.text == "" {
+		return v
+	}
+	lines := strings.Split(v, "\n")
+	lines[len(lines)-1] = m.renderToast(width)
+	return strings.Join(lines, "\n")
+}
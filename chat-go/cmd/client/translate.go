@@ -0,0 +1,52 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Translations
+// ---------------------------------------------------------------------------
+//
+// With /locale set (FeatureTranslate), the server follows messages in other
+// languages with a translation, which is shown under the message it
+// belongs to.  A translation for a message no longer in the scrollback is
+// dropped.
+
+func cmdLocale(m model, args []string) (model, tea.Cmd) {
+	if len(args) == 0 {
+		if m.locale == "" {
+			m.appendChat(sysStyle.Render("translations are off; /locale <language> turns them on, e.g. /locale de"))
+		} else {
+			m.appendChat(sysStyle.Render("translating messages into " + m.locale + "; /locale off turns it off"))
+		}
+		return m, nil
+	}
+	locale := args[0]
+	if locale == "off" {
+		locale = ""
+	}
+	sendPkt(m.conn, protocol.TypeLocale, protocol.LocalePayload{Locale: locale})
+	m.waitLocale = true
+	return m, nil
+}
+
+// showTranslation adds t under the message it translates.
+func (m *model) showTranslation(t protocol.TranslationPayload) {
+	line := quoteStyle.Render("  ↳ " + t.Source + "→" + t.Locale + ": " + t.Content)
+	if t.Source == "" {
+		line = quoteStyle.Render("  ↳ " + t.Locale + ": " + t.Content)
+	}
+	scroll := &m.scroll
+	if t.Channel != m.channel {
+		scroll = &m.conv(t.Channel).scroll
+	}
+	if l, ok := scroll.message(t.ID); ok && l.msg != nil {
+		l.notes = append(l.notes, line)
+		if t.Channel == m.channel {
+			m.refreshChat()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Typing indicators
+// ---------------------------------------------------------------------------
+//
+// While the user types a message (not a /command), the client tells the
+// server every typingEvery (FeatureTyping), and shows the line above the
+// input "alice is typing…" for the others in the conversation.  An
+// indicator lasts typingTTL unless it is refreshed, and goes as soon as
+// that user's message arrives.
+
+const (
+	typingEvery = 3 * time.Second
+	typingTTL   = 6 * time.Second
+)
+
+// typingExpiredMsg prompts a look for indicators past typingTTL.
+type typingExpiredMsg struct{}
+
+// noteTyping tells the server the user is typing in the current
+// conversation, if the input changed from before and it has not been told
+// within typingEvery.
+func (m *model) noteTyping(before string) {
+	v := m.chatInput.Value()
+	if v == before || strings.TrimSpace(v) == "" || strings.HasPrefix(v, "/") ||
+		m.conn == nil || !m.supports(protocol.FeatureTyping) || m.readOnly() {
+		return
+	}
+	if m.typedIn == m.channel && time.Since(m.typedAt) < typingEvery {
+		return
+	}
+	sendPkt(m.conn, protocol.TypeTyping, protocol.TypingPayload{Channel: m.channel})
+	m.typedAt, m.typedIn = time.Now(), m.channel
+}
+
+// showTyping records that t.User is typing in t.Channel.
+func (m *model) showTyping(t protocol.TypingPayload) {
+	if t.User == "" || t.User == m.me {
+		return
+	}
+	if m.typing == nil {
+		m.typing = make(map[string]map[string]time.Time)
+	}
+	if m.typing[t.Channel] == nil {
+		m.typing[t.Channel] = make(map[string]time.Time)
+	}
+	m.typing[t.Channel][t.User] = time.Now()
+	m.next = tea.Batch(m.next, tea.Tick(typingTTL, func(time.Time) tea.Msg { return typingExpiredMsg{} }))
+}
+
+// stopTyping forgets that user was typing in channel.
+func (m *model) stopTyping(channel, user string) {
+	delete(m.typing[channel], user)
+}
+
+// expireTyping forgets the indicators older than typingTTL.
+func (m *model) expireTyping() {
+	for ch, users := range m.typing {
+		for user, at := range users {
+			if time.Since(at) >= typingTTL {
+				delete(users, user)
+			}
+		}
+		if len(users) == 0 {
+			delete(m.typing, ch)
+		}
+	}
+}
+
+// typingLine says who is typing in the current conversation, or is "".
+func (m model) typingLine() string {
+	var names []string
+	for user, at := range m.typing[m.channel] {
+		if time.Since(at) < typingTTL {
+			names = append(names, user)
+		}
+	}
+	slices.Sort(names)
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0] + " is typing…"
+	}
+	return joinNames(names) + " are typing…"
+}
+
+// joinNames lists names for a status line: "a and b", "a, b and c", or
+// "N people" for more.
+func joinNames(names []string) string {
+	switch len(names) {
+	case 1:
+		return names[0]
+	case 2:
+		return names[0] + " and " + names[1]
+	case 3:
+		return names[0] + ", " + names[1] + " and " + names[2]
+	}
+	return fmt.Sprintf("%d people", len(names))
+}
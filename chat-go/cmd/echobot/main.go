@@ -0,0 +1,91 @@
+// Command echobot is the reference bot built on internal/bot.  It answers
+//
+//	!echo <text>   with <text>, as a reply
+//	!help          with the list of commands
+//
+// in the main channel and in any DM sent to it.  Copy it as the starting
+// point for an integration.
+//
+// The bot logs in with a session token: run the server with -jwt-keys, log
+// in once as the bot's account and keep the token from the login response.
+// Pass it with -token or $CHAT_TOKEN.  Without a token, -user and
+// $CHAT_PASSWORD are used instead.
+//
+// The SDK takes care of the rest: lost connections are retried with
+// exponential backoff (-min-backoff, -max-backoff), a rejected token stops
+// the bot, and replies are paced to -rate per second with bursts of -burst
+// so a flood of !echo commands cannot turn the bot into a flood itself.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"chat/internal/bot"
+)
+
+// maxEcho bounds how much of the caller's text is repeated back.
+const maxEcho = 400
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "server address")
+	useTLS := flag.Bool("tls", false, "connect with TLS (system roots)")
+	token := flag.String("token", os.Getenv("CHAT_TOKEN"), "session token (default $CHAT_TOKEN)")
+	user := flag.String("user", "", "username to log in with when no token is given (password from $CHAT_PASSWORD)")
+	rate := flag.Float64("rate", bot.DefaultRate, "messages per second the bot may send")
+	burst := flag.Int("burst", bot.DefaultBurst, "messages the bot may send at once before -rate applies")
+	minBackoff := flag.Duration("min-backoff", bot.DefaultMinBackoff, "first wait before reconnecting")
+	maxBackoff := flag.Duration("max-backoff", bot.DefaultMaxBackoff, "longest wait before reconnecting")
+	flag.Parse()
+
+	if *token == "" && *user == "" {
+		log.Fatal("echobot: need -token (or $CHAT_TOKEN) or -user with $CHAT_PASSWORD")
+	}
+	cfg := bot.Config{
+		Addr:       *addr,
+		Token:      *token,
+		Username:   *user,
+		Password:   os.Getenv("CHAT_PASSWORD"),
+		Rate:       *rate,
+		Burst:      *burst,
+		MinBackoff: *minBackoff,
+		MaxBackoff: *maxBackoff,
+	}
+	if *useTLS {
+		cfg.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	b := bot.New(cfg)
+	b.Command("echo", "repeat the rest of the line", echo)
+	b.Command("help", "list commands", func(ctx context.Context, b *bot.Bot, m bot.Message) {
+		b.Reply(ctx, m, b.Help())
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := b.Run(ctx); err != nil {
+		log.Fatalf("echobot: %v", err)
+	}
+	log.Println("echobot: stopped")
+}
+
+func echo(ctx context.Context, b *bot.Bot, m bot.Message) {
+	text := m.Args
+	if text == "" {
+		b.Reply(ctx, m, "usage: !echo <text>")
+		return
+	}
+	if r := []rune(text); len(r) > maxEcho {
+		text = string(r[:maxEcho]) + "…"
+	}
+	if err := b.Reply(ctx, m, text); err != nil {
+		// Typically bot.ErrNotConnected while reconnecting; the command is
+		// dropped rather than queued so a stale echo is never sent.
+		log.Printf("echobot: reply to %s: %v", m.Username, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxCommits = 5    // commits listed per push
+	maxSummary = 1500 // characters per chat message, well under the server limit
+)
+
+// The parts of GitHub's event payloads the summaries use.
+type (
+	ghUser struct {
+		Login string `json:"login"`
+	}
+	ghRepo struct {
+		FullName string `json:"full_name"`
+	}
+	ghCommit struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	}
+	pushEvent struct {
+		Ref     string     `json:"ref"`
+		Created bool       `json:"created"`
+		Deleted bool       `json:"deleted"`
+		Forced  bool       `json:"forced"`
+		Compare string     `json:"compare"`
+		Commits []ghCommit `json:"commits"`
+		Repo    ghRepo     `json:"repository"`
+		Sender  ghUser     `json:"sender"`
+	}
+	pullRequestEvent struct {
+		Action string `json:"action"`
+		PR     struct {
+			Number  int    `json:"number"`
+			Title   string `json:"title"`
+			HTMLURL string `json:"html_url"`
+			Merged  bool   `json:"merged"`
+		} `json:"pull_request"`
+		Repo   ghRepo `json:"repository"`
+		Sender ghUser `json:"sender"`
+	}
+	issuesEvent struct {
+		Action string `json:"action"`
+		Issue  struct {
+			Number  int    `json:"number"`
+			Title   string `json:"title"`
+			HTMLURL string `json:"html_url"`
+		} `json:"issue"`
+		Repo   ghRepo `json:"repository"`
+		Sender ghUser `json:"sender"`
+	}
+)
+
+// summarize renders a delivery of the given X-GitHub-Event type.  It
+// returns "" for events and actions that are not worth a message.
+func summarize(event string, body []byte) (string, error) {
+	var text string
+	switch event {
+	case "push":
+		var e pushEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			return "", fmt.Errorf("bad push payload: %w", err)
+		}
+		text = formatPush(e)
+	case "pull_request":
+		var e pullRequestEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			return "", fmt.Errorf("bad pull_request payload: %w", err)
+		}
+		text = formatPullRequest(e)
+	case "issues":
+		var e issuesEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			return "", fmt.Errorf("bad issues payload: %w", err)
+		}
+		text = formatIssue(e)
+	}
+	if r := []rune(text); len(r) > maxSummary {
+		text = string(r[:maxSummary]) + "…"
+	}
+	return text, nil
+}
+
+func formatPush(e pushEvent) string {
+	kind, name := "branch", strings.TrimPrefix(e.Ref, "refs/heads/")
+	if tag, ok := strings.CutPrefix(e.Ref, "refs/tags/"); ok {
+		kind, name = "tag", tag
+	}
+	where := e.Repo.FullName + ":" + name
+	switch {
+	case e.Deleted:
+		return fmt.Sprintf("🗑 %s deleted %s %s", e.Sender.Login, kind, where)
+	case e.Created && (kind == "tag" || len(e.Commits) == 0):
+		return fmt.Sprintf("🌱 %s created %s %s", e.Sender.Login, kind, where)
+	case len(e.Commits) == 0:
+		return ""
+	}
+
+	verb := "pushed"
+	if e.Forced {
+		verb = "force-pushed"
+	}
+	lines := []string{fmt.Sprintf("📦 %s %s %d commit%s to %s", e.Sender.Login, verb, len(e.Commits), plural(len(e.Commits)), where)}
+	for i, c := range e.Commits {
+		if i == maxCommits {
+			lines = append(lines, fmt.Sprintf("   … and %d more", len(e.Commits)-maxCommits))
+			break
+		}
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		lines = append(lines, fmt.Sprintf("   %.7s %s", c.ID, subject))
+	}
+	if e.Compare != "" {
+		lines = append(lines, "   "+e.Compare)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatPullRequest(e pullRequestEvent) string {
+	action := e.Action
+	switch {
+	case action == "closed" && e.PR.Merged:
+		action = "merged"
+	case action == "ready_for_review":
+		action = "marked ready for review"
+	case action != "opened" && action != "closed" && action != "reopened":
+		return ""
+	}
+	return fmt.Sprintf("🔀 %s %s PR #%d in %s: %s\n   %s",
+		e.Sender.Login, action, e.PR.Number, e.Repo.FullName, e.PR.Title, e.PR.HTMLURL)
+}
+
+func formatIssue(e issuesEvent) string {
+	switch e.Action {
+	case "opened", "closed", "reopened":
+	default:
+		return ""
+	}
+	return fmt.Sprintf("🐛 %s %s issue #%d in %s: %s\n   %s",
+		e.Sender.Login, e.Action, e.Issue.Number, e.Repo.FullName, e.Issue.Title, e.Issue.HTMLURL)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
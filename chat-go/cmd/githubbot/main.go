@@ -0,0 +1,152 @@
+// Command githubbot posts GitHub activity into the chat.  It serves a
+// webhook endpoint for GitHub and turns push, pull request and issue events
+// into short summaries in one channel:
+//
+//	📦 alice pushed 2 commits to acme/api:main
+//	   3f9c2e1 Fix retry loop
+//	   a01b7d4 Bump version
+//	🔀 bob opened PR #42 in acme/api: Add rate limiting
+//	   https://github.com/acme/api/pull/42
+//
+// Point a repository or organisation webhook at http://<-listen>/github with
+// content type application/json and a secret.  Every delivery must carry a
+// valid X-Hub-Signature-256 for that secret (-secret or
+// $GITHUB_WEBHOOK_SECRET); the bot refuses to start without one.
+//
+// The bot logs in like cmd/echobot: -token or $CHAT_TOKEN, or -user with
+// $CHAT_PASSWORD.  While it is reconnecting, deliveries are answered with
+// 503 so they can be redelivered from GitHub's webhook settings.
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"chat/internal/bot"
+	"chat/internal/protocol"
+)
+
+const (
+	maxPayload  = 5 << 20 // bytes of webhook body accepted
+	postTimeout = 10 * time.Second
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "chat server address")
+	useTLS := flag.Bool("tls", false, "connect to the chat server with TLS (system roots)")
+	token := flag.String("token", os.Getenv("CHAT_TOKEN"), "session token (default $CHAT_TOKEN)")
+	user := flag.String("user", "", "username to log in with when no token is given (password from $CHAT_PASSWORD)")
+	listen := flag.String("listen", ":8090", "HTTP address for GitHub webhook deliveries")
+	secret := flag.String("secret", os.Getenv("GITHUB_WEBHOOK_SECRET"), "webhook secret (default $GITHUB_WEBHOOK_SECRET)")
+	channel := flag.String("channel", protocol.MainChannel, "channel to post in (default: the main channel)")
+	flag.Parse()
+
+	if *token == "" && *user == "" {
+		log.Fatal("githubbot: need -token (or $CHAT_TOKEN) or -user with $CHAT_PASSWORD")
+	}
+	if *secret == "" {
+		log.Fatal("githubbot: need -secret or $GITHUB_WEBHOOK_SECRET")
+	}
+	cfg := bot.Config{
+		Addr:     *addr,
+		Token:    *token,
+		Username: *user,
+		Password: os.Getenv("CHAT_PASSWORD"),
+	}
+	if *useTLS {
+		cfg.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	b := bot.New(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /github", &webhook{bot: b, secret: []byte(*secret), channel: *channel})
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("githubbot: webhooks on http://%s/github", *listen)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("githubbot: %v", err)
+		}
+	}()
+
+	err := b.Run(ctx)
+	srv.Close()
+	if err != nil {
+		log.Fatalf("githubbot: %v", err)
+	}
+	log.Println("githubbot: stopped")
+}
+
+// webhook receives GitHub deliveries and posts their summaries.
+type webhook struct {
+	bot     *bot.Bot
+	secret  []byte
+	channel string
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !validSignature(h.secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		log.Printf("githubbot: rejected delivery %s from %s: bad signature", r.Header.Get("X-GitHub-Delivery"), r.RemoteAddr)
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "ping" {
+		w.Write([]byte("pong\n"))
+		return
+	}
+	text, err := summarize(event, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if text == "" {
+		w.WriteHeader(http.StatusNoContent) // an event or action we do not report
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), postTimeout)
+	defer cancel()
+	if err := h.bot.Send(ctx, h.channel, text); err != nil {
+		log.Printf("githubbot: post %s event: %v", event, err)
+		http.Error(w, "chat unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validSignature checks GitHub's "sha256=<hex HMAC of the body>" header.
+func validSignature(secret, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
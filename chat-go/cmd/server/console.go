@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"chat/internal/server"
+)
+
+// ---------------------------------------------------------------------------
+// Local console
+// ---------------------------------------------------------------------------
+//
+// With -console - the server reads operator commands from stdin; with
+// -console PATH it listens on a Unix socket at PATH instead (mode 0600),
+// for use with e.g. `socat - UNIX-CONNECT:PATH` when the server runs under
+// a supervisor.  Either way the console needs no account and no network
+// access: being on the machine is the credential.
+
+// consoleCommand is one console command.
+type consoleCommand struct {
+	usage string
+	help  string
+	run   func(con *console, args string) error
+}
+
+// console is one console session: stdin, or one socket connection.
+type console struct {
+	srv    *server.Server
+	out    io.Writer
+	reload func() error // nil when there is nothing to reload
+}
+
+var consoleCommands map[string]consoleCommand
+
+func init() {
+	consoleCommands = map[string]consoleCommand{
+		"help":      {"help", "list the commands", (*console).help},
+		"list":      {"list", "list the sessions", (*console).list},
+		"kick":      {"kick CONN-ID|USER [REASON]", "disconnect a session, or every session of a user", (*console).kick},
+		"broadcast": {"broadcast MESSAGE", "send an announcement to everyone connected", (*console).broadcast},
+		"reload":    {"reload", "re-read the -role-limits file", (*console).reloadLimits},
+		"stats":     {"stats", "show users, messages and who is online", (*console).stats},
+	}
+}
+
+// serveConsole runs console sessions on addr, "-" for stdin or a Unix
+// socket path, until the server stops.
+func serveConsole(srv *server.Server, addr string, reload func() error) error {
+	if addr == "-" {
+		go (&console{srv: srv, out: os.Stdout, reload: reload}).serve(os.Stdin)
+		return nil
+	}
+	// A socket left by a previous run would make Listen fail.
+	if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(addr)
+	}
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return fmt.Errorf("console: %w", err)
+	}
+	if err := os.Chmod(addr, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("console: %w", err)
+	}
+	slog.Info("listening", "component", "console", "addr", addr)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					slog.Error("accept failed", "component", "console", "err", err)
+				}
+				return
+			}
+			go func() {
+				defer conn.Close()
+				(&console{srv: srv, out: conn, reload: reload}).serve(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// serve runs commands read from in, one per line, until EOF or "quit".
+func (con *console) serve(in io.Reader) {
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return
+		}
+		name, args, _ := strings.Cut(line, " ")
+		cmd, ok := consoleCommands[name]
+		if !ok {
+			fmt.Fprintf(con.out, "unknown command %q; try help\n", name)
+			continue
+		}
+		if err := cmd.run(con, strings.TrimSpace(args)); err != nil {
+			fmt.Fprintf(con.out, "%s: %v\n", name, err)
+		}
+	}
+}
+
+func (con *console) help(string) error {
+	tw := tabwriter.NewWriter(con.out, 0, 4, 2, ' ', 0)
+	for _, name := range []string{"list", "kick", "broadcast", "reload", "stats", "help"} {
+		c := consoleCommands[name]
+		fmt.Fprintf(tw, "%s\t%s\n", c.usage, c.help)
+	}
+	fmt.Fprintf(tw, "quit\tend this console session\n")
+	return tw.Flush()
+}
+
+func (con *console) list(string) error {
+	sessions := con.srv.Sessions()
+	tw := tabwriter.NewWriter(con.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CONN\tUSER\tADDRESS\tCONNECTED\n")
+	for _, s := range sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s ago\n", s.ConnID, s.Username, s.RemoteAddr, time.Since(s.ConnectedSince).Round(time.Second))
+	}
+	tw.Flush()
+	fmt.Fprintf(con.out, "%d session(s)\n", len(sessions))
+	return nil
+}
+
+func (con *console) kick(args string) error {
+	target, reason, _ := strings.Cut(args, " ")
+	if target == "" {
+		return errors.New("usage: " + consoleCommands["kick"].usage)
+	}
+	n := con.srv.Kick(target, strings.TrimSpace(reason))
+	if n == 0 {
+		return fmt.Errorf("no session or user %q online", target)
+	}
+	fmt.Fprintf(con.out, "%d session(s) closed\n", n)
+	return nil
+}
+
+func (con *console) broadcast(args string) error {
+	if err := con.srv.Announce(args); err != nil {
+		return err
+	}
+	fmt.Fprintln(con.out, "announcement sent")
+	return nil
+}
+
+func (con *console) reloadLimits(string) error {
+	if con.reload == nil {
+		return errors.New("nothing to reload: the server was started without -role-limits")
+	}
+	if err := con.reload(); err != nil {
+		return err
+	}
+	fmt.Fprintln(con.out, "role limits reloaded")
+	return nil
+}
+
+func (con *console) stats(string) error {
+	r := con.srv.Stats()
+	fmt.Fprintf(con.out, "%d user(s), %d message(s), %d online (peak %d)\n", r.Users, r.Messages, r.Online, r.PeakOnline)
+	if len(r.TopUsers) > 0 {
+		top := make([]string, len(r.TopUsers))
+		for i, u := range r.TopUsers {
+			top[i] = fmt.Sprintf("%s %d", u.Username, u.Messages)
+		}
+		fmt.Fprintf(con.out, "most active: %s\n", strings.Join(top, ", "))
+	}
+	return nil
+}
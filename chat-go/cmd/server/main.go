@@ -2,35 +2,452 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"chat/internal/auth"
 	"chat/internal/server"
+	"chat/internal/store"
 )
 
 func main() {
 	addr    := flag.String("addr", ":8080", "TCP address to listen on")
 	dataDir := flag.String("data", "./data", "directory for persistent storage")
-	workers := flag.Int("workers", 4, "number of message-persistence worker goroutines")
+	workers := flag.Int("workers", 0, "fixed number of message-persistence worker goroutines (overrides -workers-min and -workers-max)")
+	workersMin := flag.Int("workers-min", 1, "fewest message-persistence workers; the pool grows and shrinks with the load")
+	workersMax := flag.Int("workers-max", 16, "most message-persistence workers")
+
+	durability := flag.String("durability", "async", "message archive durability: none (written at shutdown), async (written, not synced), fsync-batch (synced per batch of queued messages) or fsync-message (synced per message)")
+
+	replAddr := flag.String("replication-addr", "", "TCP address to accept standby servers on (secret from $REPLICATION_SECRET); over TLS with -tls-cert, else loopback only")
+	standbyOf := flag.String("standby-of", "", "run read-only as a standby of the primary with this -replication-addr; SIGUSR1 promotes it")
+	standbyCA := flag.String("standby-ca", "", "PEM CA certificate to verify the -standby-of primary with, dialling it over TLS (else it must be a loopback address)")
+	promoteAfter := flag.Duration("promote-after", 0, "promote a standby on its own once the primary has been unreachable this long (0 = only on SIGUSR1)")
+
+	authMode := flag.String("auth", "store", "login backend: store (local accounts) or ldap")
+	ldapURL := flag.String("ldap-url", "", "LDAP server URL, e.g. ldaps://dc1.corp.example.com")
+	ldapStartTLS := flag.Bool("ldap-starttls", false, "upgrade ldap:// connections with StartTLS")
+	ldapBindDN := flag.String("ldap-bind-dn", "", "service account DN for user lookup (password from $LDAP_BIND_PASSWORD)")
+	ldapBaseDN := flag.String("ldap-base-dn", "", "search base for user entries")
+	ldapUserAttr := flag.String("ldap-user-attr", "uid", "attribute matched against the login name (uid, sAMAccountName)")
+	ldapUserDN := flag.String("ldap-user-dn", "", "direct-bind DN template when no bind DN is set, e.g. uid=%s,ou=people,dc=example,dc=org")
+	ldapGroupAttr := flag.String("ldap-group-attr", "memberOf", "user attribute listing group DNs")
+	ldapGroupRoles := flag.String("ldap-group-roles", "", "group-to-role mapping, e.g. admin:cn=chat-admins,ou=groups,dc=example,dc=org;moderator:cn=...")
+
+	jwtKeys := flag.String("jwt-keys", "", "file of \"<key-id> <secret>\" lines; enables session tokens, first key signs")
+	jwtTTL := flag.Duration("jwt-ttl", 24*time.Hour, "lifetime of issued session tokens")
+
+	httpAddr := flag.String("http", "", "HTTP address for file upload/download, e.g. :8081 (disabled when empty)")
+	publicURL := flag.String("public-url", "", "externally reachable base URL of the HTTP service (default http://<-http>)")
+	maxUpload := flag.Int64("max-upload", 10<<20, "maximum upload size in bytes")
+	uploadTypes := flag.String("upload-types", "", "comma-separated media types accepted for upload (default: images, pdf, zip, text/plain, wav, mp3, ogg)")
+	maxClip := flag.Duration("max-clip", 2*time.Minute, "longest voice clip accepted")
+
+	readOnly := flag.String("read-only", "", "start in read-only maintenance mode with this reason shown to users")
+	maxBPS := flag.Int64("max-bps", 0, "per-connection bandwidth ceiling in bytes/second, each direction (0 = unlimited)")
+	overflow := flag.String("overflow", "disconnect", "what to do when a client's send buffer fills: disconnect, skip (send a gap marker) or spill (queue on disk)")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "how long a write to a client may take before the connection is dropped (1s to 10m)")
+	sendBuffer := flag.Int("send-buffer", 256, "packets that may queue for a client before -overflow applies (16 to 65536)")
+	maxPacket := flag.Int("max-packet", 64*1024, "longest JSON line a client may send, in bytes (16 KiB to 16 MiB)")
+	slowGrace := flag.Duration("slow-grace", 5*time.Second, "with -overflow disconnect, how long a client with a full send buffer has to catch up before it is dropped (0 = drop at once)")
+	quietJoins := flag.Int("quiet-joins", 0, "stop announcing each login once more than this many users are online, and post a summary of joins and leaves every -join-summary instead (0 = always announce)")
+	joinSummary := flag.Duration("join-summary", time.Minute, "with -quiet-joins, how often to post the summary")
+	privateRoster := flag.Bool("private-roster", false, "show regular users only the users online who share a public channel with them; moderators and admins see everyone")
+	rosterBatch := flag.Duration("roster-batch", 0, "send clients that ask for it the roster's changes as one diff this often, instead of one packet per change (0 = off)")
+	rosterSnapshot := flag.Duration("roster-snapshot", 10*time.Minute, "with -roster-batch, how often to send those clients the whole roster again")
+	spoolWindow := flag.Duration("spool-window", 0, "keep messages for disconnected users this long and replay them to clients that reconnect with catch_up (e.g. 2m; 0 = off)")
+	allow := flag.String("allow", "", "comma-separated networks (CIDR) or addresses that may connect; see server.AccessPolicy")
+	deny := flag.String("deny", "", "comma-separated networks (CIDR) or addresses refused at connect time")
+	geoIP := flag.String("geoip", "", "CSV of first-address,last-address,country ranges (e.g. DB-IP IP to Country Lite) for -allow-countries/-deny-countries")
+	allowCountries := flag.String("allow-countries", "", "comma-separated country codes that may connect (needs -geoip)")
+	denyCountries := flag.String("deny-countries", "", "comma-separated country codes refused at connect time (needs -geoip)")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate for serving over TLS (with -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	inactiveDays := flag.Int("inactive-days", 0, "deactivate or delete accounts nobody has logged in to for this many days (0 = never)")
+	inactiveWarn := flag.Int("inactive-warn-days", 14, "flag idle accounts and email their owners this many days before acting")
+	inactiveAction := flag.String("inactive-action", "deactivate", "what to do with inactive accounts: deactivate or delete")
+	inactiveDryRun := flag.Bool("inactive-dry-run", false, "only log and audit what the inactivity policy would do")
+	smtpAddr := flag.String("smtp", "", "SMTP relay host:port for email to users (disabled when empty)")
+	smtpFrom := flag.String("smtp-from", "", "sender address for email to users")
+	smtpUser := flag.String("smtp-user", "", "SMTP username (password from $SMTP_PASSWORD)")
+	authHookURL := flag.String("auth-hook-url", "", "URL to POST account events to: registrations and first logins (signed with $AUTH_HOOK_SECRET)")
+	authVetoURL := flag.String("auth-veto-url", "", "URL asked before each registration, which may refuse it")
+	authVetoOpen := flag.Bool("auth-veto-fail-open", false, "allow registrations while -auth-veto-url cannot be reached")
+	registerWork := flag.Int("register-work", 0, "make clients solve a proof-of-work challenge of this many bits to register, slowing down scripted sign-ups (e.g. 20; 0 = off)")
+	signingKey := flag.String("signing-key", "", "file holding the Ed25519 key to sign posted messages with, created when missing (signing is off when empty)")
+	signingName := flag.String("signing-name", "", "this server's name in message signatures (default: the host name)")
+	peerKeys := flag.String("peer-keys", "", "file of \"<server-name> <public-key>\" lines: peers whose signed messages bridges may pass on (needs -signing-key)")
+	wordFilter := flag.String("word-filter", "", "file of \"<reject|mask|flag> <word>\" lines checked against public channel messages; \"word*\" matches prefixes")
+	admins := flag.String("admin", "", "comma-separated usernames given the admin role at startup, or as soon as they register; register them before opening the server to others")
+	slowQuery := flag.Duration("slow-query", time.Second, "log store operations, such as searches, that take longer than this (0 = off)")
+	lockAfter := flag.Int("lock-after", 0, "lock accounts after this many wrong passwords in a row (0 = never)")
+	ntfyURL := flag.String("ntfy-url", "", "ntfy server for notifying users, e.g. https://ntfy.sh (access token from $NTFY_TOKEN)")
+	feeds := flag.String("feeds", "", "JSON file of RSS/Atom feeds to post into chat (see server.FeedsConfig)")
+	moderationURL := flag.String("moderation-url", "", "scoring service to POST messages to before they go out (see server.HTTPModerator; signed with $MODERATION_SECRET)")
+	moderationTimeout := flag.Duration("moderation-timeout", 5*time.Second, "with -moderation-url, how long a message may wait for its scores")
+	moderationFailClosed := flag.Bool("moderation-fail-closed", false, "with -moderation-url, refuse messages that could not be scored instead of posting them")
+	moderationFlag := flag.Float64("moderation-flag", 0.5, "with -moderation-url, flag messages to moderators from this score (0 = never)")
+	moderationHold := flag.Float64("moderation-hold", 0.8, "with -moderation-url, hold messages for review from this score (0 = never)")
+	moderationReject := flag.Float64("moderation-reject", 0.95, "with -moderation-url, reject messages from this score (0 = never)")
+	translateURL := flag.String("translate-url", "", "LibreTranslate server for translating messages into readers' locales, e.g. http://localhost:5000 (API key from $TRANSLATE_API_KEY)")
+	postRate := flag.Float64("post-rate", 0, "messages a second each account may post, for roles -role-limits gives no rate (0 = unlimited)")
+	postBurst := flag.Int("post-burst", 10, "with -post-rate, how many messages an account may post at once")
+	consoleAddr := flag.String("console", "", "local admin console: - for stdin, or the path of a Unix socket to listen on")
+	roleLimits := flag.String("role-limits", "", "JSON file of per-role message length, upload size and posting rate (see server.RoleLimits)")
+	namePolicy := flag.String("name-policy", "", "JSON file of the lengths, characters and reserved words allowed in new channel names and topics (see server.NamePolicy)")
+	stampGranularity := flag.Duration("timestamp-granularity", 0, "privacy: round the message times clients see down to this (e.g. 1m); admins still see exact times in history and search")
+	stampFuzz := flag.Duration("timestamp-fuzz", 0, "privacy: also shift the message times clients see by a random amount up to this (e.g. 30s)")
+	grace := flag.Duration("grace", 0, "on SIGINT/SIGTERM, warn users and wait this long before closing (e.g. 5m); a second signal skips the wait")
+	logLevel := flag.String("log-level", "info", "least severe log records to write: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log record format: text (key=value) or json (one object a line)")
 	flag.Parse()
 
-	srv, err := server.New(*dataDir, *workers)
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fatalf("init server: %v", err)
+	}
+
+	cfg := server.Config{
+		DataDir:       *dataDir,
+		MinWorkers:    *workersMin,
+		MaxWorkers:    *workersMax,
+		Durability:    *durability,
+		HTTPAddr:      *httpAddr,
+
+		ReplicationAddr:   *replAddr,
+		ReplicationSecret: os.Getenv("REPLICATION_SECRET"),
+		StandbyOf:         *standbyOf,
+		StandbyCA:         *standbyCA,
+		PromoteAfter:      *promoteAfter,
+
+		PublicURL:     *publicURL,
+		MaxUploadSize: *maxUpload,
+		MaxClipLength: *maxClip,
+
+		ReadOnly:       *readOnly != "",
+		ReadOnlyReason: *readOnly,
+		ShutdownGrace:  *grace,
+		MaxBytesPerSec: *maxBPS,
+		Overflow:       *overflow,
+		WriteTimeout:   *writeTimeout,
+		SendBuffer:     *sendBuffer,
+		MaxPacketSize:  *maxPacket,
+		SlowGrace:      *slowGrace,
+		SpoolWindow:    *spoolWindow,
+		PostRate:       *postRate,
+		PostBurst:      *postBurst,
+		QuietJoins:     *quietJoins,
+		JoinSummary:    *joinSummary,
+		PrivateRoster:  *privateRoster,
+		RosterBatch:    *rosterBatch,
+		RosterSnapshot: *rosterSnapshot,
+		TLSCert:        *tlsCert,
+		TLSKey:         *tlsKey,
+		RegisterWork:   *registerWork,
+		SlowQuery:      *slowQuery,
+
+		TimestampGranularity: *stampGranularity,
+		TimestampFuzz:        *stampFuzz,
+	}
+	if *workers > 0 {
+		cfg.MinWorkers, cfg.MaxWorkers = *workers, *workers
+	}
+	for _, t := range strings.Split(*uploadTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			cfg.UploadTypes = append(cfg.UploadTypes, t)
+		}
+	}
+	for _, name := range strings.Split(*admins, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.Admins = append(cfg.Admins, name)
+		}
+	}
+
+	switch *authMode {
+	case "store":
+	case "ldap":
+		roles, err := parseGroupRoles(*ldapGroupRoles)
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		p, err := auth.NewLDAP(auth.LDAPConfig{
+			URL:          *ldapURL,
+			StartTLS:     *ldapStartTLS,
+			BindDN:       *ldapBindDN,
+			BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:       *ldapBaseDN,
+			UserAttr:     *ldapUserAttr,
+			UserDN:       *ldapUserDN,
+			GroupAttr:    *ldapGroupAttr,
+			GroupRoles:   roles,
+		})
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Auth = p
+	default:
+		fatalf("init server: unknown -auth %q (want store or ldap)", *authMode)
+	}
+
+	if *jwtKeys != "" {
+		k, err := auth.LoadKeyring(*jwtKeys, *jwtTTL)
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Tokens = k
+	}
+
+	if *smtpAddr != "" {
+		if *smtpFrom == "" {
+			fatalf("init server: -smtp needs -smtp-from")
+		}
+		cfg.Mailer = &server.Mailer{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			Username: *smtpUser,
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+	if *inactiveDays > 0 {
+		day := 24 * time.Hour
+		p := &server.InactivityPolicy{
+			After:  time.Duration(*inactiveDays) * day,
+			Warn:   time.Duration(*inactiveWarn) * day,
+			Action: *inactiveAction,
+			DryRun: *inactiveDryRun,
+		}
+		if err := p.Validate(); err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Inactive = p
+	}
+	if cfg.Mailer != nil {
+		cfg.Notifiers = append(cfg.Notifiers, cfg.Mailer)
+	}
+	if *ntfyURL != "" {
+		cfg.Notifiers = append(cfg.Notifiers, &server.Ntfy{URL: *ntfyURL, Token: os.Getenv("NTFY_TOKEN")})
+	}
+	if *lockAfter > 0 {
+		p := &server.LockoutPolicy{Attempts: *lockAfter}
+		if err := p.Validate(); err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Lockout = p
+	}
+
+	if *authHookURL != "" || *authVetoURL != "" {
+		h := &server.AuthHooks{
+			URL:      *authHookURL,
+			VetoURL:  *authVetoURL,
+			Secret:   os.Getenv("AUTH_HOOK_SECRET"),
+			FailOpen: *authVetoOpen,
+		}
+		if err := h.Validate(); err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.AuthHooks = h
+	}
+
+	if *signingKey != "" {
+		sg := &server.Signing{Name: *signingName}
+		if sg.Name == "" {
+			sg.Name, _ = os.Hostname()
+		}
+		var err error
+		if sg.Key, err = server.LoadSigningKey(*signingKey); err != nil {
+			fatalf("init server: %v", err)
+		}
+		if *peerKeys != "" {
+			if sg.Peers, err = server.LoadPeers(*peerKeys); err != nil {
+				fatalf("init server: %v", err)
+			}
+		}
+		if err := sg.Validate(); err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Signing = sg
+	} else if *peerKeys != "" {
+		fatalf("init server: -peer-keys needs -signing-key")
+	}
+
+	if *roleLimits != "" {
+		rl, err := server.LoadRoleLimits(*roleLimits)
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.RoleLimits = rl
+	}
+
+	if *namePolicy != "" {
+		np, err := server.LoadNamePolicy(*namePolicy)
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.NamePolicy = np
+	}
+
+	if *wordFilter != "" {
+		wf, err := server.LoadWordFilter(*wordFilter)
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.WordFilter = wf
+	}
+
+	if *feeds != "" {
+		fc, err := server.LoadFeeds(*feeds)
+		if err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Feeds = fc
+	}
+
+	if *allow != "" || *deny != "" || *allowCountries != "" || *denyCountries != "" {
+		p := &server.AccessPolicy{
+			AllowCountries: server.ParseCountries(*allowCountries),
+			DenyCountries:  server.ParseCountries(*denyCountries),
+		}
+		var err error
+		if p.Allow, err = server.ParsePrefixes(*allow); err != nil {
+			fatalf("init server: %v", err)
+		}
+		if p.Deny, err = server.ParsePrefixes(*deny); err != nil {
+			fatalf("init server: %v", err)
+		}
+		if *geoIP != "" {
+			if p.GeoIP, err = server.LoadGeoIP(*geoIP); err != nil {
+				fatalf("init server: %v", err)
+			}
+		}
+		if err := p.Validate(); err != nil {
+			fatalf("init server: %v", err)
+		}
+		cfg.Access = p
+	}
+
+	if *moderationURL != "" {
+		cfg.Moderator = &server.HTTPModerator{
+			URL:      *moderationURL,
+			Secret:   os.Getenv("MODERATION_SECRET"),
+			FlagAt:   *moderationFlag,
+			HoldAt:   *moderationHold,
+			RejectAt: *moderationReject,
+		}
+		cfg.ModerationTimeout = *moderationTimeout
+		cfg.ModerationFailClosed = *moderationFailClosed
+	}
+
+	if *translateURL != "" {
+		cfg.Transformer = &server.LibreTranslate{
+			URL:    *translateURL,
+			APIKey: os.Getenv("TRANSLATE_API_KEY"),
+		}
+	}
+
+	srv, err := server.New(cfg)
 	if err != nil {
-		log.Fatalf("init server: %v", err)
+		fatalf("init server: %v", err)
 	}
 
 	// Graceful shutdown on SIGINT / SIGTERM.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
 	go func() {
 		<-quit
-		log.Println("[server] shutting down…")
+		slog.Info("shutting down", "component", "server")
+		go func() {
+			<-quit
+			slog.Info("second signal, skipping the countdown", "component", "server")
+			srv.Shutdown()
+		}()
 		srv.Shutdown()
+		close(stopped)
+	}()
+
+	if *consoleAddr != "" {
+		var reload func() error
+		if *roleLimits != "" {
+			reload = func() error {
+				rl, err := server.LoadRoleLimits(*roleLimits)
+				if err != nil {
+					return err
+				}
+				return srv.SetRoleLimits(rl)
+			}
+		}
+		if err := serveConsole(srv, *consoleAddr, reload); err != nil {
+			fatalf("init server: %v", err)
+		}
+		if *consoleAddr != "-" {
+			defer os.Remove(*consoleAddr)
+		}
+	}
+
+	// Promote a standby on SIGUSR1.
+	promote := make(chan os.Signal, 1)
+	signal.Notify(promote, syscall.SIGUSR1)
+	go func() {
+		for range promote {
+			slog.Info("promoting on SIGUSR1", "component", "server")
+			srv.Promote()
+		}
 	}()
 
 	if err := srv.ListenAndServe(*addr); err != nil {
-		log.Printf("[server] stopped: %v", err)
+		slog.Error("stopped", "component", "server", "err", err)
+		return
+	}
+	<-stopped
+}
+
+// setupLogging makes slog's default logger, which the server logs to,
+// write the records of level and above to stderr in format.
+func setupLogging(level, format string) error {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("-log-level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("unknown -log-format %q (want text or json)", format)
+	}
+	return nil
+}
+
+// fatalf logs why the server cannot start, and exits.
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// parseGroupRoles parses "role:groupDN;role:groupDN" into a groupDN → role map.
+// The role comes first because group DNs themselves contain '=' and ','.
+func parseGroupRoles(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, dn, ok := strings.Cut(entry, ":")
+		if !ok || role == "" || dn == "" {
+			return nil, fmt.Errorf("bad -ldap-group-roles entry %q (want role:groupDN)", entry)
+		}
+		role = strings.TrimSpace(role)
+		if store.RoleRank(role) == 0 {
+			return nil, fmt.Errorf("bad -ldap-group-roles entry %q: unknown role %q", entry, role)
+		}
+		out[strings.TrimSpace(dn)] = role
 	}
+	return out, nil
 }
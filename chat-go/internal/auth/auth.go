@@ -0,0 +1,31 @@
+// Package auth provides pluggable credential verification for the chat server.
+//
+// By default the server checks passwords against its own Store.  A Provider
+// replaces that check with an external account database (for example an LDAP
+// directory); the server then keeps a local shadow account for each external
+// user so messages still have a stable user ID.
+package auth
+
+import "errors"
+
+// ErrInvalidCredentials is returned when the username or password is wrong.
+// Providers return it for every "bad login" case so callers never learn which
+// half of the pair was incorrect.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Identity is the result of a successful authentication.
+type Identity struct {
+	Username string // canonical username as reported by the provider
+	Role     string // chat role, e.g. store.RoleMember or store.RoleAdmin
+}
+
+// Provider verifies credentials against an external account database.
+type Provider interface {
+	// Name identifies the provider in logs and on shadow accounts ("ldap").
+	Name() string
+
+	// Authenticate checks username/password and returns the verified
+	// identity, ErrInvalidCredentials, or an error describing why the
+	// provider itself could not be reached.
+	Authenticate(username, password string) (*Identity, error)
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, signed with an
+// unknown key, tampered with, or expired.
+var ErrInvalidToken = errors.New("invalid or expired session token")
+
+// Claims is the payload of a session token.  Field names follow RFC 7519.
+type Claims struct {
+	Subject   string   `json:"sub"` // user ID
+	Username  string   `json:"name"`
+	Roles     []string `json:"roles"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// Keyring signs and verifies HS256 JSON Web Tokens.
+//
+// Key rotation: the first key is the active signing key; every key verifies.
+// To rotate, put a new key at the top of the keys file and keep the old one
+// below it until all tokens it signed have expired.
+type Keyring struct {
+	active string            // key ID used for signing
+	keys   map[string][]byte // key ID → HMAC secret
+	ttl    time.Duration
+}
+
+// NewKeyring builds a Keyring from ordered (id, secret) pairs.
+func NewKeyring(ids []string, secrets [][]byte, ttl time.Duration) (*Keyring, error) {
+	if len(ids) == 0 || len(ids) != len(secrets) {
+		return nil, fmt.Errorf("jwt: at least one signing key is required")
+	}
+	k := &Keyring{active: ids[0], keys: make(map[string][]byte, len(ids)), ttl: ttl}
+	for i, id := range ids {
+		if len(secrets[i]) < 32 {
+			return nil, fmt.Errorf("jwt: key %q is shorter than 32 bytes", id)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", id)
+		}
+		k.keys[id] = secrets[i]
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keys file with one "<key-id> <secret>" pair per line.
+// Blank lines and lines starting with '#' are ignored.
+func LoadKeyring(path string, ttl time.Duration) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	var secrets [][]byte
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, secret, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("jwt: %s:%d: want \"<key-id> <secret>\"", path, n)
+		}
+		ids = append(ids, id)
+		secrets = append(secrets, []byte(strings.TrimSpace(secret)))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	return NewKeyring(ids, secrets, ttl)
+}
+
+// TTL reports how long issued tokens stay valid.
+func (k *Keyring) TTL() time.Duration { return k.ttl }
+
+// Issue signs a token for the given user that expires after the keyring TTL.
+func (k *Keyring) Issue(userID, username string, roles []string) (string, time.Time, error) {
+	now := time.Now().UTC()
+	exp := now.Add(k.ttl)
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": k.active})
+	body, err := json.Marshal(Claims{
+		Subject:   userID,
+		Username:  username,
+		Roles:     roles,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: exp.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signing := b64(header) + "." + b64(body)
+	return signing + "." + b64(sign(k.keys[k.active], signing)), exp, nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+// No Store lookup is involved, which is the point: any server holding the
+// keys can resume a session.
+func (k *Keyring) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	rawHeader, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	rawBody, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, err3 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	key, ok := k.keys[header.Kid]
+	if !ok || !hmac.Equal(sig, sign(key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var c Claims
+	if err := json.Unmarshal(rawBody, &c); err != nil || c.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	return &c, nil
+}
+
+func sign(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	secretA = bytes.Repeat([]byte("a"), 32)
+	secretB = bytes.Repeat([]byte("b"), 32)
+)
+
+func mustKeyring(t *testing.T, ids []string, secrets [][]byte, ttl time.Duration) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(ids, secrets, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		secrets [][]byte
+		wantErr bool
+	}{
+		{"one key", []string{"k1"}, [][]byte{secretA}, false},
+		{"two keys", []string{"k2", "k1"}, [][]byte{secretB, secretA}, false},
+		{"no keys", nil, nil, true},
+		{"mismatched lengths", []string{"k1", "k2"}, [][]byte{secretA}, true},
+		{"short secret", []string{"k1"}, [][]byte{[]byte("short")}, true},
+		{"duplicate id", []string{"k1", "k1"}, [][]byte{secretA, secretB}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.ids, tt.secrets, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyringVerify(t *testing.T) {
+	k1 := mustKeyring(t, []string{"k1"}, [][]byte{secretA}, time.Hour)
+	rotated := mustKeyring(t, []string{"k2", "k1"}, [][]byte{secretB, secretA}, time.Hour)
+	other := mustKeyring(t, []string{"k1"}, [][]byte{secretB}, time.Hour)
+	expired := mustKeyring(t, []string{"k1"}, [][]byte{secretA}, -time.Second)
+
+	issue := func(k *Keyring) string {
+		tok, _, err := k.Issue("u1", "alice", []string{"member"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	good := issue(k1)
+	parts := strings.Split(good, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`))
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"u2","name":"mallory","exp":9999999999}`))
+
+	tests := []struct {
+		name   string
+		verify *Keyring
+		token  string
+		ok     bool
+	}{
+		{"valid", k1, good, true},
+		{"old key after rotation", rotated, good, true},
+		{"new key after rotation", rotated, issue(rotated), true},
+		{"new key unknown to old ring", k1, issue(rotated), false},
+		{"same kid, different secret", other, good, false},
+		{"expired", expired, issue(expired), false},
+		{"tampered body", k1, parts[0] + "." + forged + "." + parts[2], false},
+		{"alg none", k1, none + "." + parts[1] + "." + parts[2], false},
+		{"missing signature", k1, parts[0] + "." + parts[1], false},
+		{"bad base64", k1, parts[0] + ".!!!." + parts[2], false},
+		{"empty", k1, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.verify.Verify(tt.token)
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("err = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if c.Subject != "u1" || c.Username != "alice" || len(c.Roles) != 1 || c.Roles[0] != "member" {
+				t.Errorf("claims = %+v", c)
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{"keys with comments", "# rotated 2024-01\nk2 " + string(secretB) + "\n\nk1 " + string(secretA) + "\n", false},
+		{"missing secret", "k1\n", true},
+		{"only comments", "# nothing here\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			k, err := LoadKeyring(path, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && k.active != "k2" {
+				t.Errorf("active key = %q, want the first one listed", k.active)
+			}
+		})
+	}
+}
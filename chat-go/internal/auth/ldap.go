@@ -0,0 +1,513 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"chat/internal/store"
+)
+
+// LDAPConfig configures bind-based verification against an LDAP or Active
+// Directory server.
+//
+// Two lookup styles are supported:
+//
+//   - Search then bind: when BindDN is set, the provider binds as that
+//     service account, searches BaseDN for an entry whose UserAttr equals the
+//     login name, and then binds as the entry it found.
+//   - Direct bind: when BindDN is empty, the user's DN is built from the
+//     UserDN template ("uid=%s,ou=people,dc=example,dc=org", or
+//     "%s@corp.example.com" for an AD user principal name).
+//
+// In both cases the user's GroupAttr values (memberOf by default) are matched
+// against GroupRoles to pick a chat role; the highest-ranked match wins.
+type LDAPConfig struct {
+	URL      string // ldap://host:389 or ldaps://host:636
+	StartTLS bool   // upgrade a plain ldap:// connection before binding
+
+	BindDN       string // service account used for user lookup (optional)
+	BindPassword string
+
+	BaseDN   string // search base for user entries
+	UserAttr string // attribute holding the login name: uid, sAMAccountName
+	UserDN   string // direct-bind DN template, used when BindDN is empty
+
+	GroupAttr   string            // attribute listing group DNs on the user entry
+	GroupRoles  map[string]string // group DN → chat role
+	DefaultRole string            // role for users in none of GroupRoles
+
+	Timeout time.Duration // dial + per-request timeout
+}
+
+// LDAP authenticates users with a simple bind against a directory server.
+type LDAP struct {
+	cfg LDAPConfig
+}
+
+// NewLDAP validates cfg and returns an LDAP provider.
+func NewLDAP(cfg LDAPConfig) (*LDAP, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("ldap: url must look like ldap://host:389 or ldaps://host:636")
+	}
+	if cfg.BindDN == "" && cfg.UserDN == "" {
+		return nil, fmt.Errorf("ldap: either a bind DN or a user DN template is required")
+	}
+	if cfg.BindDN != "" && cfg.BaseDN == "" {
+		return nil, fmt.Errorf("ldap: search-then-bind requires a base DN")
+	}
+	if cfg.UserAttr == "" {
+		cfg.UserAttr = "uid"
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = "memberOf"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = store.RoleMember
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	// Group DNs are compared case-insensitively.
+	roles := make(map[string]string, len(cfg.GroupRoles))
+	for dn, role := range cfg.GroupRoles {
+		roles[normalizeDN(dn)] = role
+	}
+	cfg.GroupRoles = roles
+	return &LDAP{cfg: cfg}, nil
+}
+
+// Name implements Provider.
+func (l *LDAP) Name() string { return "ldap" }
+
+// Authenticate implements Provider.
+func (l *LDAP) Authenticate(username, password string) (*Identity, error) {
+	// An empty password turns a simple bind into an "unauthenticated" bind,
+	// which most directories accept.  Never let that through.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	c, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	var entry *ldapEntry
+	if l.cfg.BindDN != "" {
+		if err := c.bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind: %w", err)
+		}
+		entry, err = c.search(l.cfg.BaseDN, l.cfg.UserAttr, username, []string{l.cfg.UserAttr, l.cfg.GroupAttr})
+		if err != nil {
+			return nil, fmt.Errorf("ldap: user search: %w", err)
+		}
+		if entry == nil {
+			return nil, ErrInvalidCredentials
+		}
+		if err := c.bind(entry.dn, password); err != nil {
+			return nil, bindError(err)
+		}
+	} else {
+		dn := fmt.Sprintf(l.cfg.UserDN, escapeDN(username))
+		if err := c.bind(dn, password); err != nil {
+			return nil, bindError(err)
+		}
+		if l.cfg.BaseDN != "" {
+			// Read our own entry for group membership; a failure here only
+			// costs the user their elevated role.
+			entry, _ = c.search(l.cfg.BaseDN, l.cfg.UserAttr, username, []string{l.cfg.UserAttr, l.cfg.GroupAttr})
+		}
+	}
+
+	id := &Identity{Username: username, Role: l.cfg.DefaultRole}
+	if entry != nil {
+		if v := entry.first(l.cfg.UserAttr); v != "" {
+			id.Username = v
+		}
+		for _, g := range entry.attrs[strings.ToLower(l.cfg.GroupAttr)] {
+			if role, ok := l.cfg.GroupRoles[normalizeDN(g)]; ok && store.RoleRank(role) > store.RoleRank(id.Role) {
+				id.Role = role
+			}
+		}
+	}
+	return id, nil
+}
+
+func (l *LDAP) dial() (*ldapConn, error) {
+	u, _ := url.Parse(l.cfg.URL)
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "ldaps" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	}
+
+	d := &net.Dialer{Timeout: l.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(d, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = d.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: connect: %w", err)
+	}
+
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn), timeout: l.cfg.Timeout}
+	if u.Scheme == "ldap" && l.cfg.StartTLS {
+		if err := c.startTLS(u.Hostname()); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: starttls: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// bindError maps an "invalid credentials" bind result to ErrInvalidCredentials
+// and passes every other failure through.
+func bindError(err error) error {
+	var re *ldapResultError
+	if errors.As(err, &re) && re.code == ldapInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return fmt.Errorf("ldap: user bind: %w", err)
+}
+
+// ---------------------------------------------------------------------------
+// Minimal LDAPv3 client (RFC 4511): simple bind, single-filter search, and
+// StartTLS.  Just enough for credential checks, built on encoding/asn1.
+// ---------------------------------------------------------------------------
+
+const (
+	ldapInvalidCredentials = 49
+	ldapStartTLSOID        = "1.3.6.1.4.1.1466.20037"
+
+	appBindRequest     = 0
+	appBindResponse    = 1
+	appUnbindRequest   = 2
+	appSearchRequest   = 3
+	appSearchEntry     = 4
+	appSearchDone      = 5
+	appExtendedRequest = 23
+	appExtendedResult  = 24
+)
+
+type ldapResultError struct {
+	code int
+	msg  string
+}
+
+func (e *ldapResultError) Error() string {
+	if e.msg != "" {
+		return fmt.Sprintf("result code %d: %s", e.code, e.msg)
+	}
+	return fmt.Sprintf("result code %d", e.code)
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string // keyed by lower-case attribute name
+}
+
+func (e *ldapEntry) first(attr string) string {
+	if v := e.attrs[strings.ToLower(attr)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+type ldapConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	msgID   int
+	timeout time.Duration
+}
+
+func (c *ldapConn) close() {
+	c.send(ber(asn1.ClassApplication, appUnbindRequest, false, nil))
+	c.conn.Close()
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	op := ber(asn1.ClassApplication, appBindRequest, true, concat(
+		mustMarshal(3),
+		mustMarshal([]byte(dn)),
+		ber(asn1.ClassContextSpecific, 0, false, []byte(password)),
+	))
+	if err := c.send(op); err != nil {
+		return err
+	}
+	tag, body, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if tag != appBindResponse {
+		return fmt.Errorf("unexpected response tag %d to bind", tag)
+	}
+	return parseResult(body)
+}
+
+func (c *ldapConn) startTLS(serverName string) error {
+	op := ber(asn1.ClassApplication, appExtendedRequest, true,
+		ber(asn1.ClassContextSpecific, 0, false, []byte(ldapStartTLSOID)))
+	if err := c.send(op); err != nil {
+		return err
+	}
+	tag, body, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if tag != appExtendedResult {
+		return fmt.Errorf("unexpected response tag %d to starttls", tag)
+	}
+	if err := parseResult(body); err != nil {
+		return err
+	}
+	tc := tls.Client(c.conn, &tls.Config{ServerName: serverName})
+	tc.SetDeadline(time.Now().Add(c.timeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tc
+	c.r = bufio.NewReader(tc)
+	return nil
+}
+
+// search looks for a single entry under base whose attr equals value.
+// It returns nil, nil when nothing matches.
+func (c *ldapConn) search(base, attr, value string, want []string) (*ldapEntry, error) {
+	var attrList []byte
+	for _, a := range want {
+		attrList = append(attrList, mustMarshal([]byte(a))...)
+	}
+	filter := ber(asn1.ClassContextSpecific, 3, true, concat( // equalityMatch
+		mustMarshal([]byte(attr)),
+		mustMarshal([]byte(value)),
+	))
+	op := ber(asn1.ClassApplication, appSearchRequest, true, concat(
+		mustMarshal([]byte(base)),
+		mustMarshal(asn1.Enumerated(2)), // scope: wholeSubtree
+		mustMarshal(asn1.Enumerated(0)), // derefAliases: never
+		mustMarshal(2),                  // sizeLimit: detect ambiguous matches
+		mustMarshal(int(c.timeout/time.Second)),
+		mustMarshal(false), // typesOnly
+		filter,
+		ber(asn1.ClassUniversal, asn1.TagSequence, true, attrList),
+	))
+	if err := c.send(op); err != nil {
+		return nil, err
+	}
+
+	var found []*ldapEntry
+	for {
+		tag, body, err := c.recv()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case appSearchEntry:
+			e, err := parseEntry(body)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, e)
+		case appSearchDone:
+			if err := parseResult(body); err != nil && len(found) == 0 {
+				return nil, err
+			}
+			if len(found) != 1 {
+				// Zero matches, or an ambiguous login name: refuse both.
+				return nil, nil
+			}
+			return found[0], nil
+		default:
+			// Referrals and intermediate responses are ignored.
+		}
+	}
+}
+
+func (c *ldapConn) send(op []byte) error {
+	c.msgID++
+	msg := ber(asn1.ClassUniversal, asn1.TagSequence, true, concat(mustMarshal(c.msgID), op))
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// recv reads one LDAPMessage and returns its protocolOp application tag and
+// the op's content bytes.
+func (c *ldapConn) recv() (int, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	raw, err := readTLV(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var msg asn1.RawValue
+	if _, err := asn1.Unmarshal(raw, &msg); err != nil {
+		return 0, nil, fmt.Errorf("decode message: %w", err)
+	}
+	var id int
+	rest, err := asn1.Unmarshal(msg.Bytes, &id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("decode message id: %w", err)
+	}
+	var op asn1.RawValue
+	if _, err := asn1.Unmarshal(rest, &op); err != nil {
+		return 0, nil, fmt.Errorf("decode protocol op: %w", err)
+	}
+	if op.Class != asn1.ClassApplication {
+		return 0, nil, fmt.Errorf("unexpected protocol op class %d", op.Class)
+	}
+	return op.Tag, op.Bytes, nil
+}
+
+// parseResult decodes an LDAPResult and returns nil on success.
+func parseResult(body []byte) error {
+	var code asn1.Enumerated
+	rest, err := asn1.Unmarshal(body, &code)
+	if err != nil {
+		return fmt.Errorf("decode result: %w", err)
+	}
+	if code == 0 {
+		return nil
+	}
+	var matched, diag []byte
+	if rest, err = asn1.Unmarshal(rest, &matched); err == nil {
+		asn1.Unmarshal(rest, &diag)
+	}
+	return &ldapResultError{code: int(code), msg: string(diag)}
+}
+
+func parseEntry(body []byte) (*ldapEntry, error) {
+	var dn []byte
+	rest, err := asn1.Unmarshal(body, &dn)
+	if err != nil {
+		return nil, fmt.Errorf("decode entry dn: %w", err)
+	}
+	var list asn1.RawValue
+	if _, err := asn1.Unmarshal(rest, &list); err != nil {
+		return nil, fmt.Errorf("decode entry attributes: %w", err)
+	}
+	e := &ldapEntry{dn: string(dn), attrs: make(map[string][]string)}
+	for b := list.Bytes; len(b) > 0; {
+		var pa asn1.RawValue
+		if b, err = asn1.Unmarshal(b, &pa); err != nil {
+			return nil, fmt.Errorf("decode attribute: %w", err)
+		}
+		var name []byte
+		vals, err := asn1.Unmarshal(pa.Bytes, &name)
+		if err != nil {
+			return nil, fmt.Errorf("decode attribute name: %w", err)
+		}
+		var set asn1.RawValue
+		if _, err := asn1.Unmarshal(vals, &set); err != nil {
+			return nil, fmt.Errorf("decode attribute values: %w", err)
+		}
+		key := strings.ToLower(string(name))
+		for v := set.Bytes; len(v) > 0; {
+			var val []byte
+			if v, err = asn1.Unmarshal(v, &val); err != nil {
+				return nil, fmt.Errorf("decode attribute value: %w", err)
+			}
+			e.attrs[key] = append(e.attrs[key], string(val))
+		}
+	}
+	return e, nil
+}
+
+// readTLV reads one complete BER element (definite length only) from r.
+func readTLV(r *bufio.Reader) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	hdr := []byte{tag, first}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			hdr = append(hdr, b)
+			length = length<<8 | int(b)
+		}
+	}
+	buf := make([]byte, len(hdr)+length)
+	copy(buf, hdr)
+	if _, err := io.ReadFull(r, buf[len(hdr):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func ber(class, tag int, compound bool, content []byte) []byte {
+	return mustMarshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: compound, Bytes: content})
+}
+
+func mustMarshal(v any) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("ldap: marshal %T: %v", v, err))
+	}
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// escapeDN escapes a value for use inside a distinguished name (RFC 4514).
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(s)-1 && r == ' ':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// normalizeDN lower-cases dn and strips spaces around RDN separators so
+// "CN=Admins, OU=Groups" and "cn=admins,ou=groups" compare equal.
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
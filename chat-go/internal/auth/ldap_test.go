@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/store"
+)
+
+func TestEscapeDN(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"alice", "alice"},
+		{"smith, john", `smith\, john`},
+		{"a+b", `a\+b`},
+		{`say "hi"`, `say \"hi\"`},
+		{`back\slash`, `back\\slash`},
+		{"<x>;y=z", `\<x\>\;y\=z`},
+		{" lead", `\ lead`},
+		{"trail ", `trail\ `},
+		{"#hash", `\#hash`},
+		{"mid#dle", "mid#dle"},
+		{"nul\x00", `nul\00`},
+		{"ünïcode", "ünïcode"},
+	}
+	for _, tt := range tests {
+		if got := escapeDN(tt.in); got != tt.want {
+			t.Errorf("escapeDN(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDN(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"CN=Admins, OU=Groups, DC=Example", "cn=admins,ou=groups,dc=example"},
+		{" cn=ops ,ou=groups", "cn=ops,ou=groups"},
+		{"cn=x", "CN=X"},
+	}
+	for _, tt := range tests {
+		if normalizeDN(tt.a) != normalizeDN(tt.b) {
+			t.Errorf("normalizeDN(%q) = %q, normalizeDN(%q) = %q; want equal",
+				tt.a, normalizeDN(tt.a), tt.b, normalizeDN(tt.b))
+		}
+	}
+}
+
+func TestReadTLV(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	tests := []struct {
+		name    string
+		in      []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"short form", mustMarshal([]byte("hi")), mustMarshal([]byte("hi")), false},
+		{"long form", mustMarshal(long), mustMarshal(long), false},
+		{"trailing bytes left unread", append(mustMarshal(7), 0xff), mustMarshal(7), false},
+		{"indefinite length", []byte{0x30, 0x80, 0x00, 0x00}, nil, true},
+		{"oversized length", []byte{0x04, 0x85, 1, 0, 0, 0, 0}, nil, true},
+		{"truncated content", []byte{0x04, 0x05, 'a', 'b'}, nil, true},
+		{"truncated header", []byte{0x04}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readTLV(bufio.NewReader(bytes.NewReader(tt.in)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	result := func(code int, diag string) []byte {
+		return concat(mustMarshal(asn1.Enumerated(code)), mustMarshal([]byte("")), mustMarshal([]byte(diag)))
+	}
+	tests := []struct {
+		name     string
+		body     []byte
+		wantCode int // 0: success, -1: decode error
+		wantMsg  string
+	}{
+		{"success", result(0, ""), 0, ""},
+		{"invalid credentials", result(ldapInvalidCredentials, "bad password"), ldapInvalidCredentials, "bad password"},
+		{"code only", mustMarshal(asn1.Enumerated(32)), 32, ""},
+		{"garbage", []byte{0xff}, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseResult(tt.body)
+			var re *ldapResultError
+			switch {
+			case tt.wantCode == 0:
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+			case tt.wantCode < 0:
+				if err == nil || errors.As(err, &re) {
+					t.Fatalf("err = %v, want a decode error", err)
+				}
+			default:
+				if !errors.As(err, &re) {
+					t.Fatalf("err = %v, want *ldapResultError", err)
+				}
+				if re.code != tt.wantCode || re.msg != tt.wantMsg {
+					t.Errorf("got code %d msg %q, want %d %q", re.code, re.msg, tt.wantCode, tt.wantMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		wantDN  string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name:   "attributes keyed lower-case",
+			body:   testEntry("uid=alice,ou=people", map[string][]string{"UID": {"alice"}, "memberOf": {"cn=a", "cn=b"}}),
+			wantDN: "uid=alice,ou=people",
+			want:   map[string][]string{"uid": {"alice"}, "memberof": {"cn=a", "cn=b"}},
+		},
+		{
+			name:   "no attributes",
+			body:   testEntry("uid=bob", nil),
+			wantDN: "uid=bob",
+			want:   map[string][]string{},
+		},
+		{
+			name:    "missing attribute list",
+			body:    mustMarshal([]byte("uid=carol")),
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			body:    []byte{0x04, 0x09, 'x'},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseEntry(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if e.dn != tt.wantDN {
+				t.Errorf("dn = %q, want %q", e.dn, tt.wantDN)
+			}
+			if len(e.attrs) != len(tt.want) {
+				t.Errorf("attrs = %v, want %v", e.attrs, tt.want)
+			}
+			for k, v := range tt.want {
+				if strings.Join(e.attrs[k], "|") != strings.Join(v, "|") {
+					t.Errorf("attrs[%q] = %v, want %v", k, e.attrs[k], v)
+				}
+			}
+		})
+	}
+}
+
+// TestSearchFilterValueVerbatim checks that the login name reaches the
+// directory as the raw assertion value of an equalityMatch, so filter
+// metacharacters in it match literally instead of widening the search.
+func TestSearchFilterValueVerbatim(t *testing.T) {
+	dir := newFakeDirectory(t)
+	dir.users["uid=svc"] = "svc-pw"
+	l, err := NewLDAP(LDAPConfig{URL: dir.url, BindDN: "uid=svc", BindPassword: "svc-pw", BaseDN: "ou=people"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"*", "a*)(uid=*", `x\2a`} {
+		if _, err := l.Authenticate(name, "pw"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q) err = %v, want ErrInvalidCredentials", name, err)
+		}
+		if got := dir.lastFilter(); got != "uid="+name {
+			t.Errorf("Authenticate(%q) sent filter %q, want equalityMatch uid=%s", name, got, name)
+		}
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	dir := newFakeDirectory(t)
+	dir.users["uid=svc"] = "svc-pw"
+	dir.users["uid=alice,ou=people"] = "alice-pw"
+	dir.users["uid=bob,ou=people"] = "bob-pw"
+	dir.users[`uid=smith\, j,ou=people`] = "smith-pw"
+	dir.entries = []fakeEntry{
+		{dn: "uid=alice,ou=people", attrs: map[string][]string{"uid": {"Alice"}, "memberOf": {"CN=Admins, OU=Groups"}}},
+		{dn: "uid=bob,ou=people", attrs: map[string][]string{"uid": {"bob"}}},
+		{dn: "uid=twin1,ou=people", attrs: map[string][]string{"uid": {"twin"}}},
+		{dn: "uid=twin2,ou=people", attrs: map[string][]string{"uid": {"twin"}}},
+	}
+	roles := map[string]string{"cn=admins,ou=groups": store.RoleAdmin}
+	search := LDAPConfig{URL: dir.url, BindDN: "uid=svc", BindPassword: "svc-pw", BaseDN: "ou=people", GroupRoles: roles}
+	direct := LDAPConfig{URL: dir.url, UserDN: "uid=%s,ou=people", GroupRoles: roles}
+	badService := search
+	badService.BindPassword = "wrong"
+
+	tests := []struct {
+		name     string
+		cfg      LDAPConfig
+		user, pw string
+		want     *Identity
+		wantErr  error // nil: success; ErrInvalidCredentials; errSome: any other error
+	}{
+		{"search then bind", search, "alice", "alice-pw", &Identity{Username: "Alice", Role: store.RoleAdmin}, nil},
+		{"search default role", search, "bob", "bob-pw", &Identity{Username: "bob", Role: store.RoleMember}, nil},
+		{"search wrong password", search, "alice", "nope", nil, ErrInvalidCredentials},
+		{"search unknown user", search, "mallory", "x", nil, ErrInvalidCredentials},
+		{"search ambiguous user", search, "twin", "x", nil, ErrInvalidCredentials},
+		{"empty password", search, "alice", "", nil, ErrInvalidCredentials},
+		{"empty username", search, "", "x", nil, ErrInvalidCredentials},
+		{"service bind fails", badService, "alice", "alice-pw", nil, errSome},
+		{"direct bind", direct, "bob", "bob-pw", &Identity{Username: "bob", Role: store.RoleMember}, nil},
+		{"direct bind wrong password", direct, "bob", "nope", nil, ErrInvalidCredentials},
+		{"direct bind escapes DN", direct, "smith, j", "smith-pw", &Identity{Username: "smith, j", Role: store.RoleMember}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewLDAP(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := l.Authenticate(tt.user, tt.pw)
+			switch {
+			case tt.wantErr == errSome:
+				if err == nil || errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("err = %v, want a provider error", err)
+				}
+				return
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				return
+			}
+			if *id != *tt.want {
+				t.Errorf("identity = %+v, want %+v", *id, *tt.want)
+			}
+		})
+	}
+}
+
+var errSome = errors.New("some provider error")
+
+func testEntry(dn string, attrs map[string][]string) []byte {
+	var list []byte
+	for name, vals := range attrs {
+		var set []byte
+		for _, v := range vals {
+			set = append(set, mustMarshal([]byte(v))...)
+		}
+		list = append(list, ber(asn1.ClassUniversal, asn1.TagSequence, true, concat(
+			mustMarshal([]byte(name)),
+			ber(asn1.ClassUniversal, asn1.TagSet, true, set),
+		))...)
+	}
+	return concat(mustMarshal([]byte(dn)), ber(asn1.ClassUniversal, asn1.TagSequence, true, list))
+}
+
+type fakeEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// fakeDirectory is a tiny in-process LDAP server: it answers simple binds
+// against users and case-insensitive equalityMatch searches against entries.
+type fakeDirectory struct {
+	url     string
+	users   map[string]string // DN → password
+	entries []fakeEntry
+	filters chan string
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	d := &fakeDirectory{
+		url:     "ldap://" + ln.Addr().String(),
+		users:   make(map[string]string),
+		filters: make(chan string, 16),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDirectory) lastFilter() string {
+	select {
+	case f := <-d.filters:
+		return f
+	case <-time.After(time.Second):
+		return ""
+	}
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(id, tag int, body []byte) {
+		conn.Write(ber(asn1.ClassUniversal, asn1.TagSequence, true, concat(
+			mustMarshal(id), ber(asn1.ClassApplication, tag, true, body))))
+	}
+	result := func(code int) []byte {
+		return concat(mustMarshal(asn1.Enumerated(code)), mustMarshal([]byte("")), mustMarshal([]byte("")))
+	}
+	for {
+		raw, err := readTLV(r)
+		if err != nil {
+			return
+		}
+		var msg, op asn1.RawValue
+		var id int
+		asn1.Unmarshal(raw, &msg)
+		rest, _ := asn1.Unmarshal(msg.Bytes, &id)
+		asn1.Unmarshal(rest, &op)
+
+		switch op.Tag {
+		case appBindRequest:
+			var version int
+			var dn []byte
+			var pw asn1.RawValue
+			rest, _ := asn1.Unmarshal(op.Bytes, &version)
+			rest, _ = asn1.Unmarshal(rest, &dn)
+			asn1.Unmarshal(rest, &pw)
+			code := ldapInvalidCredentials
+			if want, ok := d.users[string(dn)]; ok && want == string(pw.Bytes) {
+				code = 0
+			}
+			reply(id, appBindResponse, result(code))
+		case appSearchRequest:
+			var base []byte
+			var scope, deref asn1.Enumerated
+			var size, limit int
+			var typesOnly bool
+			var filter asn1.RawValue
+			rest, _ := asn1.Unmarshal(op.Bytes, &base)
+			rest, _ = asn1.Unmarshal(rest, &scope)
+			rest, _ = asn1.Unmarshal(rest, &deref)
+			rest, _ = asn1.Unmarshal(rest, &size)
+			rest, _ = asn1.Unmarshal(rest, &limit)
+			rest, _ = asn1.Unmarshal(rest, &typesOnly)
+			asn1.Unmarshal(rest, &filter)
+			if filter.Class != asn1.ClassContextSpecific || filter.Tag != 3 {
+				reply(id, appSearchDone, result(53)) // unwillingToPerform
+				continue
+			}
+			var attr, value []byte
+			rest, _ = asn1.Unmarshal(filter.Bytes, &attr)
+			asn1.Unmarshal(rest, &value)
+			select {
+			case d.filters <- string(attr) + "=" + string(value):
+			default:
+			}
+			for _, e := range d.entries {
+				if v := e.attrs[string(attr)]; len(v) > 0 && strings.EqualFold(v[0], string(value)) {
+					reply(id, appSearchEntry, testEntry(e.dn, e.attrs))
+				}
+			}
+			reply(id, appSearchDone, result(0))
+		case appUnbindRequest:
+			return
+		}
+	}
+}
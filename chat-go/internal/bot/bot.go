@@ -0,0 +1,471 @@
+// Package bot is a small SDK for programs that take part in the chat as a
+// user: integrations, notifiers and command bots.
+//
+// A Bot logs in with a session token (or a username and password), keeps the
+// connection alive with pings, reconnects with exponential backoff when it
+// drops, and paces everything it sends through a token bucket so a busy bot
+// cannot flood a channel.  Messages that start with the command prefix
+// ("!" by default) are dispatched to handlers registered with Command:
+//
+//	b := bot.New(bot.Config{Addr: "localhost:8080", Token: token})
+//	b.Command("ping", "answer with pong", func(ctx context.Context, b *bot.Bot, m bot.Message) {
+//		b.Reply(ctx, m, "pong")
+//	})
+//	err := b.Run(ctx)
+//
+// Handlers run one at a time, in arrival order, on a goroutine of their own so
+// a slow handler never stalls reading from the server.  History and catch-up
+// replays are not dispatched: a bot only answers what is said while it is
+// connected.  cmd/echobot is a complete example.
+package bot
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// Defaults for the zero values in Config.
+const (
+	DefaultPrefix     = "!"
+	DefaultRate       = 1.0 // messages per second
+	DefaultBurst      = 5
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+
+	dialTimeout  = 10 * time.Second
+	pingInterval = 30 * time.Second
+	queueSize    = 64 // messages waiting for a handler before new ones are dropped
+	stableAfter  = time.Minute
+)
+
+// ErrAuth wraps a login the server rejected.  Retrying will not help, so Run
+// returns it instead of reconnecting.
+var ErrAuth = errors.New("login rejected")
+
+// ErrNotConnected is returned by Send while the bot is between connections.
+var ErrNotConnected = errors.New("not connected")
+
+// Config describes how a Bot connects and behaves.
+type Config struct {
+	Addr string      // server address, host:port
+	TLS  *tls.Config // nil for plain TCP
+
+	// Token is a session token from a previous login.  Without one, Username
+	// and Password are used; when the server issues tokens the bot switches
+	// to the token it is given for later reconnects.
+	Token    string
+	Username string
+	Password string
+
+	Prefix string // command prefix; DefaultPrefix when empty
+
+	// Rate and Burst bound outgoing messages: up to Burst at once, refilled
+	// at Rate per second.
+	Rate  float64
+	Burst int
+
+	// The wait between reconnect attempts starts at MinBackoff and doubles,
+	// up to MaxBackoff, while attempts keep failing.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	Logf func(format string, args ...any) // log.Printf when nil
+}
+
+// Message is a chat message addressed to the bot's handlers.  For a command,
+// Command is its name without the prefix and Args the rest of the line.
+type Message struct {
+	protocol.BroadcastPayload
+	Command string
+	Args    string
+}
+
+// HandlerFunc handles one command.  ctx is cancelled when Run returns.
+type HandlerFunc func(ctx context.Context, b *Bot, m Message)
+
+type command struct {
+	help string
+	run  HandlerFunc
+}
+
+// Bot is a chat client driven by handlers.  Create one with New.
+type Bot struct {
+	cfg     Config
+	limiter *limiter
+
+	mu       sync.Mutex
+	conn     net.Conn // nil between connections
+	me       string
+	token    string
+	signer   *protocol.SignerInfo // the server's signing key, if it signs
+	commands map[string]command
+	other    HandlerFunc
+}
+
+// New returns a Bot for cfg with defaults filled in.  It does not connect;
+// call Run.
+func New(cfg Config) *Bot {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = DefaultRate
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = DefaultBurst
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.MinBackoff)
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = log.Printf
+	}
+	return &Bot{
+		cfg:      cfg,
+		limiter:  newLimiter(cfg.Rate, cfg.Burst),
+		token:    cfg.Token,
+		commands: make(map[string]command),
+	}
+}
+
+// Command registers h for messages of the form "<prefix>name args…".  Names
+// are matched case-insensitively.  Register commands before calling Run.
+func (b *Bot) Command(name, help string, h HandlerFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands[strings.ToLower(name)] = command{help: help, run: h}
+}
+
+// OnMessage registers h for every message from other users that is not a
+// registered command.
+func (b *Bot) OnMessage(h HandlerFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.other = h
+}
+
+// Help lists the registered commands, one per line, for a help command.
+func (b *Bot) Help() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("%s%s — %s", b.cfg.Prefix, name, b.commands[name].help)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Username is the name the bot is logged in as, or "" before the first
+// login.
+func (b *Bot) Username() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.me
+}
+
+// Send posts text in channel (protocol.MainChannel or a DM channel), waiting
+// for the rate limiter first.
+func (b *Bot) Send(ctx context.Context, channel, text string) error {
+	return b.post(ctx, protocol.ChatPayload{Content: text, Channel: channel})
+}
+
+// SendAs posts text in channel as the relay identity as, e.g. the IRC
+// user a bridge passes the message on for.  The server must have granted
+// the bot a prefix of the name (protocol.FeatureRelay); relayed messages
+// cannot go to DM channels.
+func (b *Bot) SendAs(ctx context.Context, channel, as, text string) error {
+	return b.post(ctx, protocol.ChatPayload{Content: text, Channel: channel, As: as})
+}
+
+// Forward passes on m, a message the bot read on another server, to
+// channel here as the relay identity as, like SendAs.  When the other
+// server signed m (protocol.FeatureSigning), its signature goes along as the
+// origin, and this server posts the message only if it trusts that server
+// and the signature holds.
+func (b *Bot) Forward(ctx context.Context, channel, as string, m Message) error {
+	origin := m.Origin // a message bridged there already keeps its first origin
+	if origin == nil {
+		origin = m.Sig
+	}
+	return b.post(ctx, protocol.ChatPayload{Content: m.Content, Channel: channel, As: as, Origin: origin})
+}
+
+// Signer is the key the server signs messages with, from its hello, or nil
+// when it does not sign them.  Peers that are to trust messages the bot
+// forwards from this server need its name and public key.
+func (b *Bot) Signer() *protocol.SignerInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.signer
+}
+
+// Reply answers m in its conversation, quoting it.
+func (b *Bot) Reply(ctx context.Context, m Message, text string) error {
+	return b.post(ctx, protocol.ChatPayload{Content: text, Channel: m.Channel, ReplyTo: m.ID})
+}
+
+func (b *Bot) post(ctx context.Context, p protocol.ChatPayload) error {
+	if err := b.limiter.wait(ctx); err != nil {
+		return err
+	}
+	return b.write(protocol.TypeChat, p)
+}
+
+// write sends one packet on the current connection.
+func (b *Bot) write(t protocol.MessageType, payload any) error {
+	pkt, err := protocol.NewPacket(t, payload)
+	if err != nil {
+		return err
+	}
+	data, err := pkt.Encode()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return ErrNotConnected
+	}
+	b.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err = b.conn.Write(append(data, '\n'))
+	return err
+}
+
+// Run connects and serves until ctx is cancelled, reconnecting whenever the
+// connection is lost.  It returns nil after cancellation and an error
+// wrapping ErrAuth when the server rejects the login.
+func (b *Bot) Run(ctx context.Context) error {
+	queue := make(chan Message, queueSize)
+	defer close(queue)
+	go b.dispatch(ctx, queue)
+
+	backoff := b.cfg.MinBackoff
+	for {
+		start := time.Now()
+		err := b.session(ctx, queue)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrAuth) {
+			return err
+		}
+		if time.Since(start) > stableAfter {
+			backoff = b.cfg.MinBackoff
+		}
+		wait := backoff + rand.N(backoff/2+1)
+		b.cfg.Logf("[bot] %v; reconnecting in %s", err, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff = min(backoff*2, b.cfg.MaxBackoff)
+	}
+}
+
+// session runs one connection: dial, log in, then read until it fails.
+func (b *Bot) session(ctx context.Context, queue chan<- Message) error {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", b.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if b.cfg.TLS != nil {
+		cfg := b.cfg.TLS.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(b.cfg.Addr)
+		}
+		conn = tls.Client(conn, cfg)
+	}
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		b.mu.Lock()
+		b.conn = nil
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	go func() {
+		t := time.NewTicker(pingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				b.write(protocol.TypePing, protocol.PingPayload{ClientTime: time.Now()})
+			case <-ctx.Done():
+				conn.Close() // unblocks the reader
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	b.mu.Lock()
+	auth := protocol.AuthPayload{Token: b.token}
+	if b.token == "" {
+		auth = protocol.AuthPayload{Username: b.cfg.Username, Password: b.cfg.Password}
+	}
+	b.mu.Unlock()
+	if err := b.write(protocol.TypeLogin, auth); err != nil {
+		return err
+	}
+
+	loggedIn := false
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), protocol.MaxPacketSizeLimit)
+	for scanner.Scan() {
+		var pkt protocol.Packet
+		if err := json.Unmarshal(scanner.Bytes(), &pkt); err != nil {
+			continue
+		}
+		switch pkt.Type {
+		case protocol.TypeHello:
+			var h protocol.HelloPayload
+			if err := json.Unmarshal(pkt.Payload, &h); err == nil {
+				b.mu.Lock()
+				b.signer = h.Signer
+				b.mu.Unlock()
+			}
+
+		case protocol.TypeResponse:
+			var r protocol.ResponsePayload
+			if err := json.Unmarshal(pkt.Payload, &r); err != nil {
+				continue
+			}
+			if !loggedIn {
+				if !r.Success {
+					return fmt.Errorf("%w: %s", ErrAuth, r.Message)
+				}
+				loggedIn = true
+				b.loggedIn(r)
+				continue
+			}
+			if !r.Success {
+				b.cfg.Logf("[bot] server: %s", r.Message)
+			}
+
+		case protocol.TypeBroadcast:
+			var m Message
+			if err := json.Unmarshal(pkt.Payload, &m.BroadcastPayload); err != nil {
+				continue
+			}
+			if me := b.Username(); m.Username == me || m.Via == me { // our own, possibly relayed
+				continue
+			}
+			if rest, ok := strings.CutPrefix(m.Content, b.cfg.Prefix); ok {
+				name, args, _ := strings.Cut(strings.TrimSpace(rest), " ")
+				m.Command, m.Args = strings.ToLower(name), strings.TrimSpace(args)
+			}
+			select {
+			case queue <- m:
+			default:
+				b.cfg.Logf("[bot] busy, dropped message %s from %s", m.ID, m.Username)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed by server")
+}
+
+// loggedIn records who we are and, when the server issued one, the session
+// token to use for the next reconnect.
+func (b *Bot) loggedIn(r protocol.ResponsePayload) {
+	_, quoted, _ := strings.Cut(r.Message, "logged in as ")
+	name, err := strconv.Unquote(quoted)
+	if err != nil {
+		name = quoted
+	}
+	var sess protocol.SessionPayload
+	json.Unmarshal(r.Data, &sess)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.me = name
+	if sess.Token != "" {
+		b.token = sess.Token
+	}
+	b.cfg.Logf("[bot] logged in as %s on %s", name, b.cfg.Addr)
+}
+
+// dispatch runs handlers for queued messages until queue is closed.
+func (b *Bot) dispatch(ctx context.Context, queue <-chan Message) {
+	for m := range queue {
+		b.mu.Lock()
+		h := b.other
+		if cmd, ok := b.commands[m.Command]; ok {
+			h = cmd.run
+		}
+		b.mu.Unlock()
+		if h != nil {
+			h(ctx, b, m)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Rate limiting
+// ---------------------------------------------------------------------------
+
+// limiter is a token bucket shared by every Send.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes one token, sleeping until one is available or ctx ends.
+func (l *limiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		need := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		select {
+		case <-time.After(need):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -3,7 +3,13 @@
 package protocol
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,17 +21,142 @@ const (
 	TypeRegister MessageType = "register"
 	TypeLogin    MessageType = "login"
 	TypeChat     MessageType = "chat"
+	TypeDelete   MessageType = "delete" // delete one of the caller's messages, or any as an admin
+	TypeTyping   MessageType = "typing" // the caller is composing; relayed to the others in the conversation
 	TypeSearch   MessageType = "search"
 	TypeHistory  MessageType = "history"
 	TypeUsers    MessageType = "users"
+	TypeRoster   MessageType = "roster" // send the roster again as a TypeUserList; see FeatureRosterDiff
 	TypeQuit     MessageType = "quit"
 
+	TypeMarkRead MessageType = "mark_read" // the caller has read a conversation up to a message
+	TypeUnread   MessageType = "unread"    // the caller's read marks, with unread counts
+	TypeReaders  MessageType = "readers"   // who has read a message
+
+	TypeSessions    MessageType = "sessions"     // list active sessions
+	TypeKillSession MessageType = "kill_session" // terminate one session
+	TypePing        MessageType = "ping"         // keepalive + clock sync; answered with TypePong
+
+	TypeScheduled       MessageType = "scheduled"        // list own scheduled messages
+	TypeCancelScheduled MessageType = "cancel_scheduled" // cancel one scheduled message
+
+	TypePollCreate MessageType = "poll_create"
+	TypePollVote   MessageType = "poll_vote"
+	TypePollClose  MessageType = "poll_close"
+
+	TypeFileToken MessageType = "file_token" // get a bearer token for the HTTP file service
+
+	TypeMaintenance MessageType = "maintenance" // admin: toggle read-only mode
+	TypeUsage       MessageType = "usage"       // admin: per-connection traffic counters
+	TypeStats       MessageType = "stats"       // admin: activity statistics
+	TypeAnnounce    MessageType = "announce"    // admin: notice to everyone, sent as the server
+	TypeBulk        MessageType = "bulk"        // admin: bulk moderation, confirmed by a second request
+	TypeRelay       MessageType = "relay"       // admin: list, grant or revoke relay identities for bots
+	TypeRevisions   MessageType = "revisions"   // moderators: what deleted messages said
+	TypeKick        MessageType = "kick"        // moderators: disconnect a user
+	TypeBan         MessageType = "ban"         // admin: ban an account, or lift the ban
+	TypeHeld        MessageType = "held"        // moderators: messages held for review
+	TypeRelease     MessageType = "release"     // moderators: post or drop a held message
+
+	TypeConversations MessageType = "conversations" // list the caller's direct-message conversations
+	TypeOpenDM        MessageType = "open_dm"       // get (or create) the DM channel with a user
+	TypeUserSearch    MessageType = "user_search"   // registered users by username prefix
+
+	TypeChannelList MessageType = "channel_list" // public channels, most active first
+	TypeJoin        MessageType = "join"         // join (or create) a public channel
+	TypeLeave       MessageType = "leave"        // leave a public channel
+	TypeTopic       MessageType = "topic"        // set a public channel's topic
+	TypeChannelMode MessageType = "channel_mode" // make a public channel an announcement channel, or not
+	TypeArchive     MessageType = "archive"      // admin: archive a public channel, or restore it
+
+	TypePreferences MessageType = "preferences" // get the caller's stored preferences
+	TypeMute        MessageType = "mute"        // mute or unmute a conversation, or as a moderator a user
+	TypeLocale      MessageType = "locale"      // set the language the caller reads translations in
+	TypeExport      MessageType = "export"      // prepare a download of the caller's data
+
+	TypeUnlock    MessageType = "unlock"    // before login: get or use a code that unlocks a locked account
+	TypeChallenge MessageType = "challenge" // before register: get a proof-of-work challenge
+
 	// Server → Client
+	TypeHello     MessageType = "hello" // first packet on every connection
 	TypeResponse  MessageType = "response"
 	TypeBroadcast MessageType = "broadcast"
 	TypeSystem    MessageType = "system"
+	TypeBatch     MessageType = "batch" // several packets in one frame
+	TypePong      MessageType = "pong"  // reply to TypePing
+	TypePoll      MessageType = "poll"  // current state of a poll, sent on every change
+	TypeGap       MessageType = "gap"   // broadcasts were skipped because the client fell behind
+
+	TypeDeleted      MessageType = "deleted"       // a message was deleted; clients drop or tombstone its line
+	TypeTranslation  MessageType = "translation"   // a broadcast rendered in the reader's locale
+	TypeExportStatus MessageType = "export_status" // progress and outcome of a TypeExport
+	TypeUserList     MessageType = "user_list"     // everyone online, sent once after login
+	TypeUserJoined   MessageType = "user_joined"   // a user came online
+	TypeUserLeft     MessageType = "user_left"     // a user's last session ended
+	TypeRead         MessageType = "read"          // read receipt: a user has read the recipient's messages
+	TypeMention      MessageType = "mention"       // the recipient was @mentioned in a message
+	TypeSent         MessageType = "sent"          // the sender's own message, in place of its broadcast; see FeatureNoEcho
+	TypeRosterDiff   MessageType = "roster_diff"   // users who came or went lately, in one packet; see FeatureRosterDiff
+)
+
+// Version is the wire protocol revision advertised in the hello packet.
+const Version = 1
+
+// Feature names advertised in HelloPayload.Features.  A client should treat
+// any feature missing from the list as unsupported and hide the related UI.
+const (
+	FeatureRegister    = "register"     // self-service account creation
+	FeatureTokens      = "tokens"       // session tokens issued on login
+	FeatureSessions    = "sessions"     // session listing and remote logout
+	FeatureBatch       = "batch"        // history replay as a single TypeBatch frame
+	FeatureSchedule    = "schedule"     // ChatPayload.SendAt and scheduled-message management
+	FeaturePolls       = "polls"        // poll create/vote/close
+	FeatureAttachments = "attachments"  // HTTP file service + ChatPayload.Attachment
+	FeatureKinds       = "kinds"        // ChatPayload.Kind and Meta passthrough
+	FeatureSearchQuery = "search-query" // boolean/phrase syntax in SearchPayload.Query
+	FeatureFuzzySearch = "search-fuzzy" // SearchPayload.Fuzzy
+	FeatureSearchScope = "search-scope" // SearchPayload.Channel and AllChannels
+	FeatureSearchSort  = "search-sort"  // SearchPayload.Sort
+	FeatureMaintenance = "maintenance"  // admin read-only toggle
+	FeatureUsage       = "usage"        // admin traffic report
+	FeatureStats       = "stats"        // admin activity statistics
+	FeatureDM          = "dm"           // direct messages: ChatPayload.Channel, TypeOpenDM, TypeConversations
+	FeatureSeq         = "seq"          // per-channel BroadcastPayload.Seq and HistoryPayload.AfterSeq
+	FeatureCatchUp     = "catchup"      // AuthPayload.CatchUp replays messages missed across a reconnect
+	FeatureUserSearch  = "user-search"  // TypeUserSearch directory lookups
+	FeatureChannels    = "channels"     // public channels: TypeChannelList, TypeJoin, TypeLeave, TypeTopic
+	FeatureMute        = "mute"         // TypePreferences and TypeMute
+	FeatureAnnounce    = "announce"     // admin TypeAnnounce
+	FeatureTranslate   = "translate"    // TypeLocale and TypeTranslation annotations
+	FeatureBulk        = "bulk"         // admin TypeBulk
+	FeatureUnlock      = "unlock"       // accounts lock after failed logins; TypeUnlock
+	FeatureRelay       = "relay"        // ChatPayload.As, StoredMessage.Via and admin TypeRelay
+	FeatureExport      = "export"       // TypeExport and TypeExportStatus
+	FeatureRevisions   = "revisions"    // moderator TypeRevisions
+	FeatureChannelMode = "channel-mode" // announcement channels: TypeChannelMode, ChannelInfo.ReadOnly
+	FeaturePresence    = "presence"     // SystemPayload.Joined, Left and Online
+	FeatureDelete      = "delete"       // TypeDelete and TypeDeleted
+	FeatureSystemKinds = "system-kinds" // SystemPayload.Kind and its structured fields
+	FeatureTyping      = "typing"       // TypeTyping indicators
+	FeatureRoster      = "roster"       // TypeUserList, TypeUserJoined and TypeUserLeft
+	FeatureClips       = "clips"        // KindClip audio attachments and Limits.MaxClipSeconds
+	FeatureErrorCodes  = "error-codes"  // ResponsePayload.Code
+	FeatureReads       = "reads"        // TypeMarkRead, TypeRead receipts, TypeUnread and TypeReaders
+	FeatureMentions    = "mentions"     // TypeMention
+	FeatureArchive     = "archive"      // admin TypeArchive; ChannelInfo.Archived
+	FeatureProofOfWork = "register-pow" // registration needs TypeChallenge and AuthPayload.Proof
+	FeatureSigning     = "signing"      // HelloPayload.Signer, message Sig and ChatPayload.Origin
+	FeatureModeration  = "moderation"   // TypeKick, TypeBan and MutePayload.User
+	FeatureMaintWindow = "maint-window" // MaintenancePayload.Start and End
+	FeatureNoEcho      = "no-echo"      // AuthPayload.NoEcho, ChatPayload.Ref and TypeSent
+	FeatureRosterDiff  = "roster-diff"  // AuthPayload.RosterDiffs, TypeRosterDiff, TypeRoster and UserListPayload.Rev
+	FeatureHold        = "hold"         // messages held for review; moderator TypeHeld and TypeRelease
 )
 
+// ServerName is the identity the server's own notices are sent under.  No
+// account can take it; see store.ReservedName.
+const ServerName = "server"
+
 // Packet is the top-level wire format.  Every packet is a single JSON object
 // followed by a newline character (\n).
 type Packet struct {
@@ -51,54 +182,994 @@ func (p *Packet) Encode() ([]byte, error) {
 // Payload types
 // ---------------------------------------------------------------------------
 
-// AuthPayload is used for both /register and /login.
+// AuthPayload is used for both /register and /login.  A login may carry a
+// session Token instead of a username/password pair.
 type AuthPayload struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Token    string `json:"token,omitempty"`
+	Proof    string `json:"proof,omitempty"` // register: solves the latest TypeChallenge
+
+	// CatchUp asks for the messages missed since this user's last session
+	// ended, as a BatchCatchUp batch after the login response.  Servers
+	// advertising FeatureCatchUp keep them for a short window only.
+	CatchUp bool `json:"catch_up,omitempty"`
+
+	// NoEcho asks not to be sent the broadcasts of this connection's own
+	// messages: a server advertising FeatureNoEcho acknowledges each with
+	// a TypeSent instead, so the client can show a message as it sends it.
+	// Older servers ignore it and echo as before.
+	NoEcho bool `json:"no_echo,omitempty"`
+
+	// RosterDiffs asks for the roster's changes gathered into a
+	// TypeRosterDiff every little while rather than a TypeUserJoined or
+	// TypeUserLeft each, on servers advertising FeatureRosterDiff.
+	RosterDiffs bool `json:"roster_diffs,omitempty"`
+}
+
+// ChallengePayload is the Data of the response to TypeChallenge.  On
+// servers with FeatureProofOfWork a registration must carry the Proof of
+// the connection's latest challenge: a string such that the SHA-256 hash
+// of Nonce followed by it starts with Bits zero bits (see ProofOK).  Every
+// registration attempt uses the challenge up, and it lapses at Expires.
+type ChallengePayload struct {
+	Nonce   string    `json:"nonce"`
+	Bits    int       `json:"bits"`
+	Expires time.Time `json:"expires"`
+}
+
+// ProofOK reports whether proof solves the challenge of nonce and bits.
+func ProofOK(nonce, proof string, bits int) bool {
+	sum := sha256.Sum256([]byte(nonce + proof))
+	if bits > len(sum)*8 {
+		return false
+	}
+	for i := range bits {
+		if sum[i/8]&(0x80>>(i%8)) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SolveChallenge finds a proof for the challenge of nonce and bits by
+// counting up from zero.  It takes about 2^bits hashes.
+func SolveChallenge(nonce string, bits int) string {
+	for n := 0; ; n++ {
+		if proof := strconv.Itoa(n); ProofOK(nonce, proof, bits) {
+			return proof
+		}
+	}
+}
+
+// Signature is a server's Ed25519 signature of one of its messages.  It
+// covers Server, ID, Username and the message's content, but not where or
+// when the message was posted, so it still holds when a bridge reposts
+// the message on another server.  KeyID names the key (see KeyID) and Sig
+// is the signature in base64.
+type Signature struct {
+	Server   string `json:"server"`
+	KeyID    string `json:"key_id"`
+	ID       string `json:"id"`       // the message's ID on Server
+	Username string `json:"username"` // its author's name on Server
+	Sig      string `json:"sig"`
+}
+
+// KeyID identifies an Ed25519 public key: the first 8 bytes of its SHA-256
+// hash, in hex.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
 }
 
-// ChatPayload carries a user's chat message.
+// SignMessage signs the message id, by username on server, whose content is
+// content.
+func SignMessage(key ed25519.PrivateKey, server, id, username, content string) *Signature {
+	s := &Signature{Server: server, KeyID: KeyID(key.Public().(ed25519.PublicKey)), ID: id, Username: username}
+	s.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(key, s.signed(content)))
+	return s
+}
+
+// Verify reports whether s is pub's signature of its message with content.
+func (s *Signature) Verify(pub ed25519.PublicKey, content string) bool {
+	sig, err := base64.StdEncoding.DecodeString(s.Sig)
+	return err == nil && s.KeyID == KeyID(pub) && ed25519.Verify(pub, s.signed(content), sig)
+}
+
+// signed is what a Signature signs.
+func (s *Signature) signed(content string) []byte {
+	data, _ := json.Marshal([]string{"gochat-message-v1", s.Server, s.ID, s.Username, content})
+	return data
+}
+
+// UnlockPayload asks for a code that unlocks the locked account Username,
+// sent to the owner out of band, or, with Code, uses it.  Both requests
+// work without logging in.
+type UnlockPayload struct {
+	Username string `json:"username"`
+	Code     string `json:"code,omitempty"`
+}
+
+// SessionPayload is returned as the Data of a successful login or register
+// response when the server issues session tokens or sets limits by role.
+// Limits, when set, are the caller's and replace those of the hello packet.
+type SessionPayload struct {
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Limits    *Limits   `json:"limits,omitempty"`
+}
+
+// ChatPayload carries a user's chat message.  When SendAt is set and in the
+// future the server holds the message and broadcasts it at that time.
 type ChatPayload struct {
-	Content string `json:"content"`
+	Content string     `json:"content"`
+	SendAt  *time.Time `json:"send_at,omitempty"`
+	ReplyTo string     `json:"reply_to,omitempty"` // ID of the message being answered
+	Channel string     `json:"channel,omitempty"`  // conversation to post in; MainChannel when empty
+	Ref     string     `json:"ref,omitempty"`      // the client's name for it, returned in the TypeSent
+
+	// As posts the message as a relay identity of the caller, e.g. the
+	// IRC user a bridge bot passes it on for.  It needs a grant from an
+	// admin (TypeRelay) for a prefix of the name.
+	As string `json:"as,omitempty"`
+
+	// Origin, with As, is the signature of the message by the peer server
+	// a bridge passes it on from.  A server with FeatureSigning takes the
+	// message only if it trusts that server's key and the signature holds
+	// for Content, and keeps the signature with it.
+	Origin *Signature `json:"origin,omitempty"`
+
+	// AttachmentID references a file previously uploaded to the HTTP file
+	// service.  Content may be empty when an attachment is present.
+	AttachmentID string `json:"attachment_id,omitempty"`
+
+	// Kind and Meta carry structured messages ("location", "bot-card", …).
+	// The server stores and relays them untouched; Content is the plain-text
+	// fallback shown by clients that do not know the kind.
+	Kind string          `json:"kind,omitempty"`
+	Meta json.RawMessage `json:"meta,omitempty"` // a JSON object
+}
+
+// Well-known message kinds.  Any other lowercase name is relayed as well.
+const (
+	KindText     = ""         // plain chat message
+	KindLocation = "location" // Meta: LocationMeta
+	KindClip     = "clip"     // Meta: ClipMeta; AttachmentID is the recording
+)
+
+// LocationMeta is the Meta of a KindLocation message: a point, in degrees,
+// with an optional one-line Label of at most MaxLocationLabel characters.
+type LocationMeta struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Label string  `json:"label,omitempty"`
+}
+
+// MaxLocationLabel is the longest LocationMeta.Label, in characters.
+const MaxLocationLabel = 100
+
+// ClipMeta is the Meta of a KindClip message: the attached audio lasts
+// DurationMS milliseconds and is encoded with Codec ("opus", "vorbis",
+// "pcm", "mp3", …).  The server checks the duration against
+// Limits.MaxClipSeconds but does not decode the audio.
+type ClipMeta struct {
+	DurationMS int64  `json:"duration_ms"`
+	Codec      string `json:"codec"`
+}
+
+// FileTokenPayload is the Data of a successful TypeFileToken response.  The
+// token goes in an "Authorization: Bearer" header for uploads and downloads.
+type FileTokenPayload struct {
+	Token     string    `json:"token"`
+	UploadURL string    `json:"upload_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Attachment describes an uploaded file referenced by a message.
+type Attachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+// SystemPayload is a TypeSystem notice.  From is always ServerName.
+// Message is for people; clients that treat notices differently should
+// branch on Kind, one of the System kinds, rather than on the text.  Kind
+// is empty from servers without FeatureSystemKinds.  Which of the other
+// fields are set depends on Kind: User for a join or leave, and for a
+// change to Channel made by User; At for when a shutdown or maintenance
+// happens.
+//
+// With FeaturePresence, notices of users coming and going say how many
+// logged in (Joined) and out (Left) and how many users are Online now: one
+// join or leave, or a summary of a busy period.
+type SystemPayload struct {
+	From    string    `json:"from"`
+	Kind    string    `json:"kind,omitempty"`
+	Message string    `json:"message"`
+	User    string    `json:"user,omitempty"`
+	Channel string    `json:"channel,omitempty"`
+	At      time.Time `json:"at,omitzero"`
+	Joined  int       `json:"joined,omitempty"`
+	Left    int       `json:"left,omitempty"`
+	Online  int       `json:"online,omitempty"`
+
+	// Deprecated: Kind is SystemAnnouncement.  Still set for older
+	// clients.
+	Announcement bool `json:"announcement,omitempty"`
+}
+
+// System notice kinds, for SystemPayload.Kind.
+const (
+	SystemNotice       = "notice"       // anything not listed below
+	SystemWelcome      = "welcome"      // greeting on a new connection
+	SystemJoin         = "join"         // User logged in
+	SystemLeave        = "leave"        // User logged out
+	SystemPresence     = "presence"     // joins and leaves summed up over a busy period
+	SystemAnnouncement = "announcement" // an admin's TypeAnnounce
+	SystemShutdown     = "shutdown"     // the server restarts At
+	SystemMaintenance  = "maintenance"  // read-only mode starts, is scheduled for At, or ends
+	SystemChannel      = "channel"      // User changed Channel's topic or mode
+	SystemDisconnect   = "disconnect"   // the last notice before the server closes this connection
+	SystemModeration   = "moderation"   // User muted the recipient or lifted the mute; or, to moderators, the word filter flagged User's message
+)
+
+// AnnouncePayload is an admin's notice for every connected user.
+type AnnouncePayload struct {
+	Message string `json:"message"`
+}
+
+// MaintenancePayload turns read-only mode on or off.  Reason is shown to
+// users while it is on.
+//
+// With FeatureMaintWindow, a Start in the future schedules a window
+// instead of starting one, and End, for a window or for read-only mode
+// turned on now, makes it end by itself.  Turning maintenance off ends or
+// cancels the window.  The server answers with the MaintenanceWindow.
+type MaintenancePayload struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Start   time.Time `json:"start,omitzero"`
+	End     time.Time `json:"end,omitzero"`
+}
+
+// MaintenanceWindow is a scheduled read-only period.  End is zero for a
+// window that lasts until an admin ends it.
+type MaintenanceWindow struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end,omitzero"`
+	Reason string    `json:"reason"`
+}
+
+// Bulk moderation operations, for BulkPayload.Op.
+const (
+	BulkDeleteMessages = "delete_messages" // every message User posted between Since and Until
+	BulkPurgeChannel   = "purge_channel"   // every message in Channel
+	BulkUnban          = "unban"           // reactivate accounts deactivated between Since and Until
+)
+
+// BulkPayload asks for a bulk moderation operation.  Since and Until bound
+// the time range when set.  Without Confirm nothing is done: the server
+// answers with a BulkPreview, and repeating the request with its Token as
+// Confirm carries the operation out, answering with the BulkPreview of
+// what was done.
+type BulkPayload struct {
+	Op      string    `json:"op"`
+	User    string    `json:"user,omitempty"`
+	Channel string    `json:"channel,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+	Until   time.Time `json:"until,omitzero"`
+	Confirm string    `json:"confirm,omitempty"`
+}
+
+// BulkPreview says how many messages or accounts a bulk operation affects.
+type BulkPreview struct {
+	Op      string    `json:"op"`
+	Count   int       `json:"count"`
+	Token   string    `json:"token,omitempty"` // confirms the operation, once, until Expires
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// ExportPayload asks for a copy of everything the server holds about the
+// caller's account, or, for admins, User's.  The server answers at once
+// and prepares the bundle in the background, sending TypeExportStatus
+// packets as it goes.
+type ExportPayload struct {
+	User string `json:"user,omitempty"`
+}
+
+// Export states, for ExportStatus.State.
+const (
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// ExportStatus reports on a TypeExport.  While running, Done of Total
+// messages have been gone through.  Once ready, the JSON bundle can be
+// fetched from URL with a file-service token (TypeFileToken) by the one
+// who asked for it, until Expires.
+type ExportStatus struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	State   string    `json:"state"`
+	Done    int       `json:"done,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	URL     string    `json:"url,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Expires time.Time `json:"expires,omitzero"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// RevisionsPayload asks for the kept revisions of messages, newest first:
+// those of message ID, of messages User wrote or of messages in Channel.
+// Every field is optional; Limit defaults to the server's page size.
+type RevisionsPayload struct {
+	ID      string `json:"id,omitempty"`
+	User    string `json:"user,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// TypingPayload says User is composing a message in Channel.  Clients send
+// it without User, every few seconds while they type; the server relays it
+// with User to the other readers of Channel, and neither answers nor keeps
+// it.  Readers should forget it after a few seconds, or when User posts.
+type TypingPayload struct {
+	Channel string `json:"channel,omitempty"`
+	User    string `json:"user,omitempty"`
+}
+
+// MarkReadPayload says the caller has read Channel up to and including
+// message ID.  Marks only move forward; the server does not answer.
+type MarkReadPayload struct {
+	Channel string `json:"channel,omitempty"`
+	ID      string `json:"id"`
+}
+
+// ReadMark is how far a user has read a conversation: up to message ID,
+// the Seq'th of Channel, marked At.  In a TypeUnread response Unread counts
+// the messages by others after it.
+type ReadMark struct {
+	Channel string    `json:"channel,omitempty"`
+	ID      string    `json:"id"`
+	Seq     uint64    `json:"seq"`
+	At      time.Time `json:"at"`
+	Unread  int       `json:"unread,omitempty"`
+}
+
+// ReadPayload is a TypeRead receipt, sent to the authors of the messages
+// User has just read: everything in Channel up to message ID, the Seq'th.
+type ReadPayload struct {
+	Channel string `json:"channel,omitempty"`
+	ID      string `json:"id"`
+	Seq     uint64 `json:"seq"`
+	User    string `json:"user"`
+}
+
+// MentionPayload tells a user they were @mentioned by From in message ID
+// of Channel, whose first line is Excerpt.  It comes besides the message
+// itself, to users who can read the conversation and have not muted it.
+type MentionPayload struct {
+	ID        string    `json:"id"`
+	Channel   string    `json:"channel,omitempty"`
+	From      string    `json:"from"`
+	Excerpt   string    `json:"excerpt"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReadersPayload asks who has read message ID (TypeReaders), and is the
+// Data of the response, with their usernames.
+type ReadersPayload struct {
+	ID    string   `json:"id"`
+	Users []string `json:"users,omitempty"`
+}
+
+// DeletePayload asks for message ID to be deleted.
+type DeletePayload struct {
+	ID string `json:"id"`
+}
+
+// DeletedPayload tells the readers of a conversation that message ID is
+// gone, deleted by By: its author, or an admin.
+type DeletedPayload struct {
+	ID      string `json:"id"`
+	Channel string `json:"channel,omitempty"`
+	By      string `json:"by"`
+}
+
+// Revision actions, for MessageRevision.Action.
+const (
+	RevisionDeleted = "deleted"
+)
+
+// MessageRevision is a message as it was before Action, done by By at At.
+type MessageRevision struct {
+	Message StoredMessage `json:"message"`
+	Action  string        `json:"action"`
+	By      string        `json:"by"`
+	Reason  string        `json:"reason,omitempty"`
+	At      time.Time     `json:"at"`
+}
+
+// HeldMessage is a message the server's moderation provider held back for
+// a moderator to post or drop, with the provider's Reason and Scores, e.g.
+// "spam": 0.9.  TypeHeld answers with []HeldMessage, oldest first.
+type HeldMessage struct {
+	Message StoredMessage      `json:"message"`
+	Reason  string             `json:"reason,omitempty"`
+	Scores  map[string]float64 `json:"scores,omitempty"`
+	HeldAt  time.Time          `json:"held_at"`
+}
+
+// ReleasePayload posts the held message ID, or with Drop discards it.
+type ReleasePayload struct {
+	ID   string `json:"id"`
+	Drop bool   `json:"drop,omitempty"`
+}
+
+// RelayPayload grants User the relay identities whose names start with
+// Prefix, or with Revoke takes the grant back.  Without User it lists the
+// grants, answering with []RelayGrant.
+type RelayPayload struct {
+	User   string `json:"user,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Revoke bool   `json:"revoke,omitempty"`
+}
+
+// RelayGrant is an account's relay grants.
+type RelayGrant struct {
+	Username string   `json:"username"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// CancelScheduledPayload names the scheduled message to cancel.
+type CancelScheduledPayload struct {
+	ID string `json:"id"`
 }
 
 // SearchPayload carries search criteria.  All fields are optional and are
 // combined with AND logic: only messages matching every non-empty criterion
 // are returned.
+//
+// Query supports quoted phrases, AND/OR/NOT, "-word" exclusions and
+// parentheses, e.g. `deploy -staging ("rolled back" OR revert)`.  Servers
+// advertising FeatureSearchQuery parse it that way; older servers treat the
+// whole Query as one substring.
 type SearchPayload struct {
-	Query    string     `json:"query"`              // case-insensitive content substring
+	Query    string     `json:"query"`              // content query, see above
+	Fuzzy    bool       `json:"fuzzy,omitempty"`    // tolerate typos in query words
 	Username string     `json:"username,omitempty"` // exact username (case-insensitive)
 	From     *time.Time `json:"from,omitempty"`     // inclusive start of timestamp range
 	To       *time.Time `json:"to,omitempty"`       // inclusive end of timestamp range
+
+	// Channel limits the search to one conversation (MainChannel by
+	// default); AllChannels searches every conversation the caller can see.
+	Channel     string `json:"channel,omitempty"`
+	AllChannels bool   `json:"all_channels,omitempty"`
+
+	Sort string `json:"sort,omitempty"` // one of the Sort* constants; SortOldest when empty
 }
 
-// HistoryPayload requests the last N messages.
+// Search result orderings.
+const (
+	SortOldest    = "oldest"
+	SortNewest    = "newest"
+	SortRelevance = "relevance" // best matches first, recent messages breaking ties
+)
+
+// MainChannel is the conversation every user is in.  Messages without a
+// Channel belong to it.
+const MainChannel = ""
+
+// Direct-message channels are named after their two members' user IDs, so
+// each pair of users has exactly one.
+const directPrefix = "dm:"
+
+// DirectChannel returns the DM channel between the users with IDs a and b.
+func DirectChannel(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return directPrefix + a + ":" + b
+}
+
+// DirectMembers returns the user IDs of a DM channel; ok is false when ch is
+// not one.
+func DirectMembers(ch string) (a, b string, ok bool) {
+	rest, found := strings.CutPrefix(ch, directPrefix)
+	if !found {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// IsDirect reports whether ch is a direct-message channel.
+func IsDirect(ch string) bool { return strings.HasPrefix(ch, directPrefix) }
+
+// MaxChannelName is the longest public channel name.  Public channels are
+// named by whoever creates them, e.g. "go" or "random"; clients show them
+// with a leading '#', which the server accepts and drops.
+const MaxChannelName = 32
+
+// PublicChannel normalises a channel name as typed ("#Go") to the channel
+// ("go"), or returns "" when it is not a valid name: 1 to MaxChannelName
+// characters out of a-z, 0-9, '-' and '_'.
+func PublicChannel(name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+	if name == "" || len(name) > MaxChannelName {
+		return ""
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return ""
+		}
+	}
+	return name
+}
+
+// IsPublic reports whether ch is a public channel other than MainChannel.
+func IsPublic(ch string) bool { return ch != MainChannel && !IsDirect(ch) }
+
+// Preferences are per-user settings the server keeps, so they follow the
+// user from client to client.  TypePreferences and TypeMute answer with the
+// caller's current Preferences.
+type Preferences struct {
+	// MutedChannels are conversations (MainChannel, a public channel or a
+	// DM) that raise no notifications or unread counts.  Their messages are
+	// still delivered.
+	MutedChannels []string `json:"muted_channels,omitempty"`
+
+	// Locale is the language, as a BCP 47 tag such as "de" or "pt-BR",
+	// the user wants messages translated into (FeatureTranslate).  Empty
+	// means no translations.
+	Locale string `json:"locale,omitempty"`
+}
+
+// MutePayload mutes (Mute true) or unmutes a conversation.
+//
+// With FeatureModeration, a moderator sets User instead of Channel to mute
+// that user everywhere, until Until or until they are unmuted: they cannot
+// post, and are told so, with Reason.  Only users of a lower role can be
+// muted.
+type MutePayload struct {
+	Channel string `json:"channel"`
+	Mute    bool   `json:"mute"`
+
+	User   string     `json:"user,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// KickPayload disconnects every session of User, who is told Reason and
+// may log in again.  Moderators can kick users of a lower role.
+type KickPayload struct {
+	User   string `json:"user"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// BanPayload bans User, who is disconnected and cannot log in until an
+// admin lifts the ban (Unban true).  Reason is shown to them.  Admins can
+// ban users of a lower role.
+type BanPayload struct {
+	User   string `json:"user"`
+	Reason string `json:"reason,omitempty"`
+	Unban  bool   `json:"unban,omitempty"`
+}
+
+// LocalePayload sets Preferences.Locale; an empty Locale turns translations
+// off.  The server answers with the caller's Preferences.
+type LocalePayload struct {
+	Locale string `json:"locale"`
+}
+
+// TranslationPayload annotates the broadcast ID, in Channel, with its
+// content rendered in the reader's Locale.  It arrives some time after the
+// broadcast, and only for messages not already in that language.
+type TranslationPayload struct {
+	ID      string `json:"id"`
+	Channel string `json:"channel,omitempty"`
+	Locale  string `json:"locale"`
+	Source  string `json:"source,omitempty"` // detected language of the original
+	Content string `json:"content"`
+}
+
+// ChannelPayload names a public channel for TypeJoin and TypeLeave, with
+// Topic for TypeTopic, with Announce for TypeChannelMode and with Archived
+// for TypeArchive (false restores the channel).  TypeJoin answers with a
+// ChannelInfo.
+type ChannelPayload struct {
+	Channel  string `json:"channel"`
+	Topic    string `json:"topic,omitempty"`
+	Announce bool   `json:"announce,omitempty"`
+	Archived bool   `json:"archived,omitempty"`
+}
+
+// ChannelInfo describes a public channel.  TypeChannelList answers with a
+// list of them, the most recently active first; archived channels are
+// listed only to admins.  When a channel's mode changes or it is archived
+// or restored, its members' sessions are sent a TypeChannelMode packet
+// with their new ChannelInfo.
+type ChannelInfo struct {
+	Channel  string    `json:"channel"`
+	Topic    string    `json:"topic,omitempty"`
+	Members  int       `json:"members"`
+	Joined   bool      `json:"joined,omitempty"`    // the caller is a member
+	LastAt   time.Time `json:"last_at,omitzero"`    // time of the latest message
+	Announce bool      `json:"announce,omitempty"`  // only the creator and moderators may post
+	Archived bool      `json:"archived,omitempty"`  // nobody may post or join
+	ReadOnly bool      `json:"read_only,omitempty"` // the caller may not post
+}
+
+// OpenDMPayload names the user to open a direct conversation with.  The
+// response Data is a ConversationInfo.
+type OpenDMPayload struct {
+	Username string `json:"username"`
+}
+
+// ConversationInfo describes one of the caller's direct conversations or,
+// on servers with FeatureChannels, a public channel they joined.
+// TypeConversations answers with a list of them, most recent first.
+type ConversationInfo struct {
+	Channel  string    `json:"channel"`
+	Peer     string    `json:"peer"`                // the other member's username; "" for a public channel
+	LastAt   time.Time `json:"last_at,omitzero"`    // time of the latest message
+	ReadOnly bool      `json:"read_only,omitempty"` // an announcement channel the caller may not post in
+	Archived bool      `json:"archived,omitempty"`  // an archived channel; ReadOnly too
+}
+
+// UserSearchPayload looks up registered users whose name starts with
+// Prefix (case-insensitive; "" lists everyone), in alphabetical order.
+// After is the last username of the previous page.  The response Data is a
+// UserSearchResult.
+type UserSearchPayload struct {
+	Prefix string `json:"prefix"`
+	After  string `json:"after,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // server default when 0
+}
+
+// UserSearchResult is one page of a user search.
+type UserSearchResult struct {
+	Users []DirectoryEntry `json:"users"`
+	More  bool             `json:"more,omitempty"` // ask again with After = the last username
+}
+
+// DirectoryEntry is one registered user.
+type DirectoryEntry struct {
+	Username string `json:"username"`
+	Online   bool   `json:"online,omitempty"`
+}
+
+// HistoryPayload requests the last N messages, or with Before the N messages
+// preceding the message with that ID.  When Batch is set the server replies
+// with a single TypeBatch of TypeBroadcast packets (reason BatchHistory, or
+// BatchOlder for a Before request) instead of a TypeResponse carrying the
+// messages as Data.
 type HistoryPayload struct {
-	Limit int `json:"limit"`
+	Limit   int    `json:"limit"`
+	Batch   bool   `json:"batch,omitempty"`
+	Before  string `json:"before,omitempty"`  // message ID cursor
+	Channel string `json:"channel,omitempty"` // conversation; MainChannel when empty
+
+	// AfterSeq and BeforeSeq, when either is set, ask for the messages
+	// with AfterSeq < Seq < BeforeSeq instead (BeforeSeq 0: no upper
+	// bound), oldest first.  Clients use them to fill a sequence gap.
+	AfterSeq  uint64 `json:"after_seq,omitempty"`
+	BeforeSeq uint64 `json:"before_seq,omitempty"`
 }
 
-// ResponsePayload is the generic server acknowledgement.
+// Batch reasons.
+const (
+	BatchHistory = "history" // reply to a HistoryPayload with Batch set
+	BatchOlder   = "older"   // reply to a HistoryPayload with Batch and Before set
+	BatchCatchUp = "catchup" // packets missed while a session was away
+	BatchGap     = "gap"     // reply to a HistoryPayload with AfterSeq/BeforeSeq
+)
+
+// BatchPayload carries several packets that the receiver should apply as one
+// unit, e.g. render once after the last one instead of after every packet.
+type BatchPayload struct {
+	Reason  string   `json:"reason"`
+	Packets []Packet `json:"packets"`
+	More    bool     `json:"more,omitempty"`    // history batches: older messages exist
+	Channel string   `json:"channel,omitempty"` // history batches: the conversation
+}
+
+// HelloPayload is sent by the server as soon as a connection is accepted.
+type HelloPayload struct {
+	Server     string    `json:"server"`
+	Version    int       `json:"version"`
+	Features   []string  `json:"features"`
+	Limits     Limits    `json:"limits"`
+	ServerTime time.Time `json:"server_time"`         // lets the client estimate clock skew
+	FilesURL   string    `json:"files_url,omitempty"` // base URL of the HTTP file service
+
+	// Maintenance is the reason the server is read-only, or empty when
+	// chat is open.
+	Maintenance string `json:"maintenance,omitempty"`
+
+	// MaintenanceWindow is the scheduled maintenance window that is
+	// under way or coming up, if any.
+	MaintenanceWindow *MaintenanceWindow `json:"maintenance_window,omitempty"`
+
+	// Signer is the key the server signs its messages with, on servers
+	// with FeatureSigning.
+	Signer *SignerInfo `json:"signer,omitempty"`
+}
+
+// SignerInfo names a server's message signing key.  PublicKey is the
+// Ed25519 public key in base64, and KeyID its KeyID.
+type SignerInfo struct {
+	Server    string `json:"server"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// Limits advertises the sizes the server enforces.  Packets exceeding them
+// are rejected, so clients should stop users before they hit the limit.
+type Limits struct {
+	MaxContentLength  int   `json:"max_content_length"`            // characters per chat message
+	MaxPacketSize     int   `json:"max_packet_size"`               // bytes per JSON line
+	MaxHistory        int   `json:"max_history"`                   // messages per history request
+	MaxUploadSize     int64 `json:"max_upload_size,omitempty"`     // bytes per uploaded file
+	MessagesPerMinute int   `json:"messages_per_minute,omitempty"` // chat messages and polls; 0 means unlimited
+	Burst             int   `json:"burst,omitempty"`               // of those, how many may be sent at once
+	MaxClipSeconds    int   `json:"max_clip_seconds,omitempty"`    // length of a KindClip recording
+}
+
+// MaxPacketSizeLimit is the largest Limits.MaxPacketSize a server may
+// announce, and so the longest line a client must be ready to read before
+// it has seen the hello.  The server keeps the batch frames it sends within
+// MaxPacketSize as well.
+const MaxPacketSizeLimit = 16 << 20
+
+// HasFeature reports whether name is listed in h.Features.
+func (h *HelloPayload) HasFeature(name string) bool {
+	for _, f := range h.Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PingPayload is sent by the client; ClientTime is echoed back in the pong.
+type PingPayload struct {
+	ClientTime time.Time `json:"client_time"`
+}
+
+// PongPayload answers a ping.  With the echoed ClientTime and the arrival
+// time of the pong, the client can estimate round-trip time and skew:
+//
+//	rtt  = now - ClientTime
+//	skew = ServerTime - (ClientTime + rtt/2)
+type PongPayload struct {
+	ClientTime time.Time `json:"client_time"`
+	ServerTime time.Time `json:"server_time"`
+}
+
+// GapPayload tells a client that fell behind how many broadcasts it missed.
+// The client can re-request history to fill the hole.
+type GapPayload struct {
+	Skipped int `json:"skipped"`
+}
+
+// ResponsePayload is the generic server acknowledgement.  A failure may
+// carry a Code, one of the Err codes, for clients to act on without
+// parsing Message; Data then has the details the code says.
 type ResponsePayload struct {
 	Success bool            `json:"success"`
 	Message string          `json:"message"`
+	Code    string          `json:"code,omitempty"`
 	Data    json.RawMessage `json:"data,omitempty"`
 }
 
+// Error codes, for ResponsePayload.Code.
+const (
+	ErrRateLimited = "RATE_LIMITED" // Data: RateLimitPayload
+	ErrPolicy      = "POLICY"       // Data: PolicyPayload
+)
+
+// PolicyPayload is the Data of an ErrPolicy failure: a channel name or
+// topic broke a rule of the server's naming policy.
+type PolicyPayload struct {
+	Field string `json:"field"` // PolicyChannel or PolicyTopic
+	Rule  string `json:"rule"`  // PolicyLength, PolicyCharset or PolicyReserved
+}
+
+// PolicyPayload fields and rules.
+const (
+	PolicyChannel = "channel" // the name of a new channel
+	PolicyTopic   = "topic"
+
+	PolicyLength   = "length"   // too short or too long
+	PolicyCharset  = "charset"  // a character the policy does not allow
+	PolicyReserved = "reserved" // a name, beginning or word kept for admins
+)
+
+// RateLimitPayload is the Data of an ErrRateLimited failure: a packet of
+// Type was dropped for going over the posting rate, and the next one is
+// allowed RetryAfterMS milliseconds later.
+type RateLimitPayload struct {
+	Type              MessageType `json:"type"`
+	RetryAfterMS      int64       `json:"retry_after_ms"`
+	MessagesPerMinute int         `json:"messages_per_minute"`
+	Burst             int         `json:"burst"`
+}
+
 // BroadcastPayload is sent to every connected client when a message is posted.
 type BroadcastPayload struct {
-	UserID    string    `json:"user_id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
+	ID         string          `json:"id"`
+	Seq        uint64          `json:"seq,omitempty"`     // position in the channel, 1, 2, 3…; see FeatureSeq
+	Channel    string          `json:"channel,omitempty"` // conversation; MainChannel when empty
+	To         string          `json:"to,omitempty"`      // recipient's username in a DM
+	UserID     string          `json:"user_id"`
+	Username   string          `json:"username"`
+	Content    string          `json:"content"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Reply      *Quote          `json:"reply,omitempty"`
+	Attachment *Attachment     `json:"attachment,omitempty"`
+	Kind       string          `json:"kind,omitempty"`
+	Meta       json.RawMessage `json:"meta,omitempty"`
+	Via        string          `json:"via,omitempty"` // the bot that relayed it, when posted with ChatPayload.As
+	Sig        *Signature      `json:"sig,omitempty"`
+	Origin     *Signature      `json:"origin,omitempty"`
+}
+
+// SentPayload is sent, on a connection that logged in with
+// AuthPayload.NoEcho, for each message it posts, in the place of the
+// message's broadcast: Message is what everyone else was sent, and Ref the
+// ChatPayload.Ref it was posted with.  A scheduled message is not sent at
+// once, so when it goes out it is broadcast to its sender too.
+type SentPayload struct {
+	Ref     string           `json:"ref,omitempty"`
+	Message BroadcastPayload `json:"message"`
+}
+
+// Quote is a trimmed copy of a parent message embedded in a reply, so the
+// reply can be rendered with context without fetching the parent.
+type Quote struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Excerpt  string `json:"excerpt"` // first line of the parent, truncated
 }
 
 // StoredMessage is the on-disk representation of a chat message.
 type StoredMessage struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
+	ID         string          `json:"id"`
+	Seq        uint64          `json:"seq,omitempty"`
+	Channel    string          `json:"channel,omitempty"`
+	To         string          `json:"to,omitempty"`
+	UserID     string          `json:"user_id"`
+	Username   string          `json:"username"`
+	Content    string          `json:"content"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Reply      *Quote          `json:"reply,omitempty"`
+	Attachment *Attachment     `json:"attachment,omitempty"`
+	Kind       string          `json:"kind,omitempty"`
+	Meta       json.RawMessage `json:"meta,omitempty"`
+	Via        string          `json:"via,omitempty"`
+	Sig        *Signature      `json:"sig,omitempty"`
+	Origin     *Signature      `json:"origin,omitempty"`
+}
+
+// SessionsPayload requests the caller's active sessions.  Admins may set All
+// to list every session on the server.
+type SessionsPayload struct {
+	All bool `json:"all,omitempty"`
+}
+
+// KillSessionPayload names the session (connection ID) to terminate.
+type KillSessionPayload struct {
+	ConnID string `json:"conn_id"`
+}
+
+// SessionInfo describes one authenticated connection.
+type SessionInfo struct {
+	ConnID         string    `json:"conn_id"`
+	UserID         string    `json:"user_id"`
+	Username       string    `json:"username"`
+	RemoteAddr     string    `json:"remote_addr"`
+	ConnectedSince time.Time `json:"connected_since"`
+	Current        bool      `json:"current,omitempty"` // the requesting connection
+}
+
+// UsageReport is the Data of a successful TypeUsage response.
+type UsageReport struct {
+	Connections []UsageInfo `json:"connections"`
+	Total       UsageInfo   `json:"total"` // all connections since the server started
+}
+
+// UsageInfo is the traffic of one connection.  Packet counts are JSON lines,
+// so a batch counts once.
+type UsageInfo struct {
+	ConnID         string    `json:"conn_id,omitempty"`
+	Username       string    `json:"username,omitempty"`
+	RemoteAddr     string    `json:"remote_addr,omitempty"`
+	ConnectedSince time.Time `json:"connected_since,omitzero"`
+	BytesIn        uint64    `json:"bytes_in"`
+	BytesOut       uint64    `json:"bytes_out"`
+	PacketsIn      uint64    `json:"packets_in"`
+	PacketsOut     uint64    `json:"packets_out"`
+	SendQueued     int       `json:"send_queued,omitempty"` // packets waiting in the send buffer
+}
+
+// StatsReport is the Data of a successful TypeStats response.
+type StatsReport struct {
+	Users        int         `json:"users"`    // registered accounts
+	Messages     int         `json:"messages"` // stored messages, all channels
+	Online       int         `json:"online"`   // users online now
+	PeakOnline   int         `json:"peak_online"`
+	PeakAt       time.Time   `json:"peak_at,omitzero"`
+	Daily        []DayCount  `json:"daily"`     // oldest first, quiet days included
+	TopUsers     []UserCount `json:"top_users"` // most messages over the Daily window
+	StorageBytes int64       `json:"storage_bytes"`
+}
+
+// DayCount is the number of messages posted on one UTC day.
+type DayCount struct {
+	Day      string `json:"day"` // YYYY-MM-DD
+	Messages int    `json:"messages"`
+}
+
+// UserCount is the number of messages one user posted.
+type UserCount struct {
+	Username string `json:"username"`
+	Messages int    `json:"messages"`
+}
+
+// ScheduledMessage is a chat message waiting for its SendAt time.
+type ScheduledMessage struct {
+	ID         string          `json:"id"`
+	Channel    string          `json:"channel,omitempty"`
+	To         string          `json:"to,omitempty"`
+	UserID     string          `json:"user_id"`
+	Username   string          `json:"username"`
+	Content    string          `json:"content"`
+	SendAt     time.Time       `json:"send_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Reply      *Quote          `json:"reply,omitempty"`
+	Attachment *Attachment     `json:"attachment,omitempty"`
+	Kind       string          `json:"kind,omitempty"`
+	Meta       json.RawMessage `json:"meta,omitempty"`
+}
+
+// PollCreatePayload opens a new poll.
+type PollCreatePayload struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+// PollVotePayload casts (or changes) the caller's vote.  Option is a
+// zero-based index into Poll.Options.
+type PollVotePayload struct {
+	PollID string `json:"poll_id"`
+	Option int    `json:"option"`
+}
+
+// PollClosePayload closes a poll to further votes.
+type PollClosePayload struct {
+	PollID string `json:"poll_id"`
+}
+
+// Poll is the public state of a poll, broadcast with every change.  Who voted
+// for what is never sent.
+type Poll struct {
+	ID        string       `json:"id"`
+	CreatorID string       `json:"creator_id"`
+	Creator   string       `json:"creator"`
+	Question  string       `json:"question"`
+	Options   []PollOption `json:"options"`
+	Closed    bool         `json:"closed"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// PollOption is one answer and its current tally.
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
 }
 
 // UserInfo describes a currently online user.
@@ -106,3 +1177,38 @@ type UserInfo struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 }
+
+// UserListPayload is everyone online when a session logs in.  From then on
+// the session is told of every change with a RosterPayload, so a client
+// can keep the roster without reading system notices.
+//
+// A session that logged in with AuthPayload.RosterDiffs is told of the
+// changes with RosterDiffPayloads instead, and sent the whole list again
+// now and then, and whenever it asks with TypeRoster.  Rev numbers the
+// list and the diffs after it, 1, 2, 3…: a client that finds one missing
+// has missed a change, and should ask for the list.
+type UserListPayload struct {
+	Users []UserInfo `json:"users"`
+	Rev   uint64     `json:"rev,omitempty"`
+}
+
+// RosterDiffPayload is what changed in the roster since the last diff or
+// list: the users who came online and the IDs of those who went offline.
+// A user who came and went in the meantime is only in the list of their
+// latest change.  Online is as in RosterPayload.
+type RosterDiffPayload struct {
+	Rev    uint64     `json:"rev"`
+	Joined []UserInfo `json:"joined,omitempty"`
+	Left   []string   `json:"left,omitempty"`
+	Online int        `json:"online"`
+}
+
+// RosterPayload says User came online (TypeUserJoined) or went offline
+// (TypeUserLeft), leaving Online users.  A user with several sessions
+// comes online with the first and goes with the last.  Online is zero for
+// a user the server shows only some of the users online; the roster is
+// all they get to count.
+type RosterPayload struct {
+	User   UserInfo `json:"user"`
+	Online int      `json:"online"`
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Connection access control
+// ---------------------------------------------------------------------------
+//
+// An AccessPolicy decides, as each chat connection is accepted and before
+// a byte is read from it, whether its address may connect at all.  Refused
+// connections are closed at once and recorded in the audit log (at most
+// once a minute per address, so a scanner cannot flood it) and counted in
+// chat_connections_rejected_total.
+//
+// The rules, in order:
+//
+//  1. an address in Deny is refused;
+//  2. an address in Allow is accepted, whatever its country;
+//  3. with country rules, an address whose country is in DenyCountries,
+//     or not in a non-empty AllowCountries, is refused;
+//  4. without them, a non-empty Allow refuses everything else.
+//
+// The HTTP sidecar and the replication listener are not covered.
+
+const (
+	rejectLogEvery = time.Minute // per address
+	maxRejectLog   = 4096        // addresses remembered for throttling
+)
+
+// ActionConnRejected is the audit action for a refused connection.
+const ActionConnRejected = "connection_rejected"
+
+// AccessPolicy lists who may connect.
+type AccessPolicy struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+
+	// Country rules take ISO 3166-1 alpha-2 codes ("DE") and need GeoIP.
+	AllowCountries []string
+	DenyCountries  []string
+	GeoIP          *GeoIP
+}
+
+// ParsePrefixes parses a comma-separated list of CIDR prefixes and plain
+// addresses, e.g. "10.0.0.0/8, 192.0.2.7, 2001:db8::/32".
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, f := range strings.Split(list, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			a, err := netip.ParseAddr(f)
+			if err != nil {
+				return nil, fmt.Errorf("access: bad address %q", f)
+			}
+			out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(f)
+		if err != nil {
+			return nil, fmt.Errorf("access: bad network %q", f)
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// ParseCountries parses a comma-separated list of country codes.
+func ParseCountries(list string) []string {
+	var out []string
+	for _, f := range strings.Split(list, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, strings.ToUpper(f))
+		}
+	}
+	return out
+}
+
+// Validate checks p for settings that cannot work.
+func (p *AccessPolicy) Validate() error {
+	countries := append(slices.Clone(p.AllowCountries), p.DenyCountries...)
+	if len(countries) > 0 && p.GeoIP == nil {
+		return fmt.Errorf("access: country rules need a GeoIP database")
+	}
+	for _, cc := range countries {
+		if len(cc) != 2 || strings.ToUpper(cc) != cc {
+			return fmt.Errorf("access: %q is not a two-letter country code", cc)
+		}
+	}
+	return nil
+}
+
+// Check decides whether addr may connect; when it may not, reason says why.
+func (p *AccessPolicy) Check(addr netip.Addr) (ok bool, reason string) {
+	addr = addr.Unmap()
+	in := func(list []netip.Prefix) bool {
+		return slices.ContainsFunc(list, func(n netip.Prefix) bool { return n.Contains(addr) })
+	}
+	switch {
+	case in(p.Deny):
+		return false, "address is on the deny list"
+	case in(p.Allow):
+		return true, ""
+	case len(p.AllowCountries) > 0 || len(p.DenyCountries) > 0:
+		cc := p.GeoIP.Country(addr)
+		if slices.Contains(p.DenyCountries, cc) {
+			return false, "connections from " + cc + " are blocked"
+		}
+		if len(p.AllowCountries) > 0 && !slices.Contains(p.AllowCountries, cc) {
+			if cc == "" {
+				return false, "country unknown and not on the allow list"
+			}
+			return false, "country " + cc + " is not on the allow list"
+		}
+	case len(p.Allow) > 0:
+		return false, "address is not on the allow list"
+	}
+	return true, ""
+}
+
+// GeoIP maps addresses to countries from a table of address ranges.
+type GeoIP struct {
+	ranges []geoRange // sorted by start, not overlapping
+}
+
+type geoRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// LoadGeoIP reads a CSV table of "first address,last address,country"
+// lines, the layout of the free DB-IP "IP to Country Lite" download; any
+// further columns are ignored, as are blank lines and lines starting with
+// '#'.
+func LoadGeoIP(path string) (*GeoIP, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	defer f.Close()
+
+	var g GeoIP
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		cols := strings.Split(line, ",")
+		if len(cols) < 3 {
+			return nil, fmt.Errorf("geoip: %s:%d: want first,last,country", path, n)
+		}
+		var r geoRange
+		r.start, err = netip.ParseAddr(strings.Trim(cols[0], `" `))
+		if err == nil {
+			r.end, err = netip.ParseAddr(strings.Trim(cols[1], `" `))
+		}
+		if err != nil || r.start.Is4() != r.end.Is4() || r.end.Less(r.start) {
+			return nil, fmt.Errorf("geoip: %s:%d: bad address range", path, n)
+		}
+		r.start, r.end = r.start.Unmap(), r.end.Unmap()
+		r.country = strings.ToUpper(strings.Trim(cols[2], `" `))
+		g.ranges = append(g.ranges, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	slices.SortFunc(g.ranges, func(a, b geoRange) int { return a.start.Compare(b.start) })
+	return &g, nil
+}
+
+// Country returns the country code of addr, or "" when it is not listed.
+func (g *GeoIP) Country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	// The last range starting at or before addr.
+	i, found := slices.BinarySearchFunc(g.ranges, addr, func(r geoRange, a netip.Addr) int { return r.start.Compare(a) })
+	if !found {
+		i--
+	}
+	if i < 0 || g.ranges[i].end.Less(addr) {
+		return ""
+	}
+	return g.ranges[i].country
+}
+
+// rejections throttles the audit records of refused connections.
+type rejections struct {
+	count atomic.Uint64
+
+	mu   sync.Mutex
+	last map[netip.Addr]time.Time // last audit record per address
+}
+
+// admit applies the access policy to a freshly accepted connection.
+func (s *Server) admit(conn net.Conn) bool {
+	p := s.cfg.Access
+	if p == nil {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return true // not an IP connection
+	}
+	addr := ap.Addr().Unmap()
+	ok, reason := p.Check(addr)
+	if ok {
+		return true
+	}
+	s.rejects.count.Add(1)
+	if s.rejects.shouldLog(addr, time.Now()) {
+		logger("access").Warn("refused", "addr", addr, "reason", reason)
+		err := s.store.Audit(store.AuditEntry{
+			At:     time.Now().UTC(),
+			Actor:  protocol.ServerName,
+			Action: ActionConnRejected,
+			Target: addr.String(),
+			Detail: reason,
+		})
+		if err != nil {
+			logger("store").Error("writing the audit log failed", "err", err)
+		}
+	}
+	return false
+}
+
+// shouldLog reports whether a refusal of addr at now is due an audit
+// record, and if so notes it.
+func (r *rejections) shouldLog(addr netip.Addr, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last == nil {
+		r.last = make(map[netip.Addr]time.Time)
+	}
+	if t, ok := r.last[addr]; ok && now.Sub(t) < rejectLogEvery {
+		return false
+	}
+	if len(r.last) >= maxRejectLog {
+		for a, t := range r.last {
+			if now.Sub(t) >= rejectLogEvery {
+				delete(r.last, a)
+			}
+		}
+		if len(r.last) >= maxRejectLog {
+			return false // too many addresses at once; they are still counted
+		}
+	}
+	r.last[addr] = now
+	return true
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Announcements
+// ---------------------------------------------------------------------------
+//
+// An admin's announcement goes to every connected client as a system notice
+// from protocol.ServerName rather than from the admin's account, the same
+// identity as the server's own notices.  Because the Store refuses that name
+// (and "system") for accounts, a notice from it cannot be faked by a user.
+// Who sent it is kept in the moderation log.
+
+func (s *Server) handleAnnounce(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("announcements require the admin role")
+		return
+	}
+	var p protocol.AnnouncePayload
+	err := json.Unmarshal(raw, &p)
+	p.Message = sanitizeText(p.Message)
+	if err != nil || strings.TrimSpace(p.Message) == "" {
+		c.sendError("announce requires {message}")
+		return
+	}
+	if utf8.RuneCountInString(p.Message) > s.limitsFor(c.getRole()).MaxContentLength {
+		c.sendError("announcement too long")
+		return
+	}
+	s.broadcastSystem(protocol.SystemAnnouncement, p.Message)
+	s.events.Publish(moderationEvent(c, ActionAnnounce, "", p.Message))
+	c.logger("server").Info("announced", "message", p.Message)
+	c.sendResponse(true, "announcement sent", nil)
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Account webhooks
+// ---------------------------------------------------------------------------
+//
+// With Config.AuthHooks the server tells an external service (a CRM, a
+// directory sync job) about accounts as they appear: every self-service
+// registration, and the first login of an account made some other way, such
+// as an LDAP user's shadow account or one created with chatctl.  Events are
+// POSTed as AuthHookEvent JSON from the event bus, off the login path, and
+// retried a few times; one that still fails is logged and dropped.
+//
+// VetoURL, when set, is asked before each registration and may refuse it,
+// e.g. for a blocked name or address.  Until it answers the client waits;
+// when it cannot be reached registrations are refused, or with FailOpen
+// allowed.  Both endpoints can check the X-Chat-Signature-256 header, an
+// HMAC-SHA256 of the body keyed with Secret, to know the request is ours.
+
+// Account events sent to AuthHooks.URL.
+const (
+	AuthHookRegistered = "registered"
+	AuthHookFirstLogin = "first_login"
+)
+
+const (
+	authHookTimeout  = 10 * time.Second
+	authHookAttempts = 3
+	authHookBackoff  = 2 * time.Second // doubled after each failed attempt
+)
+
+// AuthHooks are the endpoints of an external account service.
+type AuthHooks struct {
+	URL      string // receives account events; "" sends none
+	VetoURL  string // asked before each registration; "" allows all
+	Secret   string // signs request bodies, see X-Chat-Signature-256
+	FailOpen bool   // allow registrations while VetoURL cannot be reached
+	Client   *http.Client
+}
+
+// AuthHookEvent is the body POSTed to AuthHooks.URL.
+type AuthHookEvent struct {
+	Event    string    `json:"event"` // AuthHookRegistered or AuthHookFirstLogin
+	At       time.Time `json:"at"`
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Source   string    `json:"source,omitempty"` // the provider of an external account, e.g. "ldap"
+	Addr     string    `json:"addr,omitempty"`   // the client's address
+}
+
+// AuthHookVeto is the body POSTed to AuthHooks.VetoURL, which answers with
+// an AuthHookVerdict.
+type AuthHookVeto struct {
+	Event    string `json:"event"` // always "register"
+	Username string `json:"username"`
+	Addr     string `json:"addr,omitempty"`
+}
+
+// AuthHookVerdict is VetoURL's answer.  Reason is shown to the client when
+// the registration is refused.
+type AuthHookVerdict struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate checks that h names at least one endpoint.
+func (h *AuthHooks) Validate() error {
+	if h.URL == "" && h.VetoURL == "" {
+		return errors.New("auth hooks: need an event URL or a veto URL")
+	}
+	return nil
+}
+
+// post sends body to url and returns the response body of a 2xx answer.
+func (h *AuthHooks) post(ctx context.Context, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Chat-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return data, nil
+}
+
+// vetoRegistration asks the veto hook whether c may register username.  It
+// returns why not, or "" to go ahead.
+func (s *Server) vetoRegistration(c *Client, username string) string {
+	h := s.cfg.AuthHooks
+	if h == nil || h.VetoURL == "" {
+		return ""
+	}
+	body, _ := json.Marshal(AuthHookVeto{Event: "register", Username: username, Addr: c.remoteAddr})
+	ctx, cancel := context.WithTimeout(context.Background(), authHookTimeout)
+	defer cancel()
+	data, err := h.post(ctx, h.VetoURL, body)
+	var v AuthHookVerdict
+	if err == nil {
+		err = json.Unmarshal(data, &v)
+	}
+	switch {
+	case err != nil && h.FailOpen:
+		logger("authhook").Warn("veto check failed, allowing", "user", username, "err", err)
+		return ""
+	case err != nil:
+		logger("authhook").Error("veto check failed", "user", username, "err", err)
+		return "registration is unavailable right now; try again later"
+	case !v.Allow:
+		logger("authhook").Info("registration vetoed", "user", username, "reason", v.Reason)
+		if v.Reason == "" {
+			return "registration refused"
+		}
+		return "registration refused: " + v.Reason
+	}
+	return ""
+}
+
+// authHookEvent is the authhooks subscriber: it sends registrations and
+// first logins to the event hook.
+func (s *Server) authHookEvent(e Event) {
+	ev := AuthHookEvent{At: e.At, UserID: e.UserID, Username: e.Username, Addr: e.Addr}
+	switch {
+	case e.Type == EventRegister:
+		ev.Event = AuthHookRegistered
+	case e.Type == EventJoin && e.First:
+		ev.Event = AuthHookFirstLogin
+	default:
+		return
+	}
+	if u := s.store.GetUserByID(e.UserID); u != nil {
+		ev.Role, ev.Source = u.Role, u.Source
+	}
+	if ev.Role == "" {
+		ev.Role = store.RoleMember
+	}
+	body, _ := json.Marshal(ev)
+	h := s.cfg.AuthHooks
+	wait := authHookBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), authHookTimeout)
+		_, err := h.post(ctx, h.URL, body)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt == authHookAttempts {
+			logger("authhook").Error("not delivered", "event", ev.Event, "user", ev.Username, "attempts", attempt, "err", err)
+			return
+		}
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-s.quit:
+			logger("authhook").Warn("not delivered: shutting down", "event", ev.Event, "user", ev.Username)
+			return
+		}
+	}
+}
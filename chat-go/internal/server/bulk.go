@@ -0,0 +1,268 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Bulk moderation
+// ---------------------------------------------------------------------------
+//
+// Admins can act on many messages or accounts at once (TypeBulk):
+//
+//   - delete_messages removes what one user posted, optionally within a
+//     time range; it matches by user ID, or by username once the account
+//     is gone;
+//   - purge_channel empties a conversation's history;
+//   - unban reactivates deactivated accounts, optionally only those
+//     deactivated within a time range.  Bans (TypeBan) are not lifted
+//     this way, only one by one.
+//
+// Nothing happens on the first request.  It is answered with how much
+// would be affected and a confirmation token, valid for bulkConfirmTTL,
+// once, for the same admin and the same request.  The confirmed operation
+// works on what matches at that point, so the count may differ from the
+// preview.  Each one carried out is recorded in the moderation log, and
+// the messages it removes stay available to moderators as revisions.
+
+const bulkConfirmTTL = 2 * time.Minute
+
+// Bulk moderation actions.
+const (
+	ActionBulkDelete = "bulk_delete_messages"
+	ActionBulkPurge  = "bulk_purge_channel"
+	ActionBulkUnban  = "bulk_unban"
+)
+
+// bulkGrant is an outstanding confirmation token.
+type bulkGrant struct {
+	userID  string
+	req     protocol.BulkPayload // without Confirm
+	expires time.Time
+}
+
+// bulkTokens holds the outstanding confirmation tokens.
+type bulkTokens struct {
+	mu     sync.Mutex
+	grants map[string]bulkGrant // token → grant
+}
+
+// issue returns a new token for req by the user with the given ID.
+func (b *bulkTokens) issue(userID string, req protocol.BulkPayload) (string, time.Time) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.grants == nil {
+		b.grants = make(map[string]bulkGrant)
+	}
+	for t, g := range b.grants {
+		if now.After(g.expires) {
+			delete(b.grants, t)
+		}
+	}
+	g := bulkGrant{userID: userID, req: req, expires: now.Add(bulkConfirmTTL)}
+	b.grants[token] = g
+	return token, g.expires
+}
+
+// redeem uses up token, reporting whether it was issued to the user for
+// req and is still valid.
+func (b *bulkTokens) redeem(token, userID string, req protocol.BulkPayload) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.grants[token]
+	delete(b.grants, token)
+	return ok && g.userID == userID && sameBulk(g.req, req) && time.Now().Before(g.expires)
+}
+
+// sameBulk reports whether a and b ask for the same operation.
+func sameBulk(a, b protocol.BulkPayload) bool {
+	return a.Op == b.Op && a.User == b.User && a.Channel == b.Channel &&
+		a.Since.Equal(b.Since) && a.Until.Equal(b.Until)
+}
+
+// bulkOp is a validated bulk request.
+type bulkOp struct {
+	action string
+	target string // for the moderation log
+	what   string // e.g. "messages from alice"
+
+	// messages selects the messages to delete; users the accounts to
+	// reactivate.  Exactly one is set.
+	messages func(*protocol.StoredMessage) bool
+	users    func() []string
+}
+
+// bulkCount returns how much op affects now.
+func (s *Server) bulkCount(op bulkOp) int {
+	if op.users != nil {
+		return len(op.users())
+	}
+	return s.store.CountMessages(op.messages)
+}
+
+func (s *Server) handleBulk(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("bulk moderation requires the admin role")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	var p protocol.BulkPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("bulk requires {op, ...}")
+		return
+	}
+	confirm := p.Confirm
+	p.Confirm = ""
+	op, err := s.bulkOp(&p)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+
+	if confirm == "" {
+		n := s.bulkCount(op)
+		if n == 0 {
+			c.sendResponse(true, "nothing to do: no "+op.what, protocol.BulkPreview{Op: p.Op})
+			return
+		}
+		token, exp := s.bulk.issue(c.getUserID(), p)
+		c.sendResponse(true, fmt.Sprintf("%d %s; confirm within %d minutes", n, op.what, int(bulkConfirmTTL.Minutes())),
+			protocol.BulkPreview{Op: p.Op, Count: n, Token: token, Expires: exp.UTC()})
+		return
+	}
+	if !s.bulk.redeem(confirm, c.getUserID(), p) {
+		c.sendError("confirmation token is invalid or expired; request a new one")
+		return
+	}
+
+	var n int
+	if op.users != nil {
+		n, err = s.store.ReactivateUsers(op.users())
+	} else {
+		err = s.store.Update(func(tx *store.Tx) error {
+			n = tx.TombstoneMessages(op.messages, c.getUsername(), "bulk "+p.Op)
+			return nil
+		})
+	}
+	if err != nil {
+		c.logger("store").Error("bulk operation failed", "op", p.Op, "err", err)
+		c.sendError("could not carry out the operation")
+		return
+	}
+	detail := fmt.Sprintf("%d %s", n, op.what)
+	s.events.Publish(moderationEvent(c, op.action, op.target, detail))
+	c.logger("server").Info("bulk operation", "op", p.Op, "detail", detail)
+	c.sendResponse(true, "done: "+detail, protocol.BulkPreview{Op: p.Op, Count: n})
+}
+
+// bulkOp validates p, normalising its channel, and works out what it
+// selects.
+func (s *Server) bulkOp(p *protocol.BulkPayload) (bulkOp, error) {
+	if !p.Since.IsZero() && !p.Until.IsZero() && p.Until.Before(p.Since) {
+		return bulkOp{}, fmt.Errorf("until is before since")
+	}
+	inRange := func(t time.Time) bool {
+		return (p.Since.IsZero() || !t.Before(p.Since)) && (p.Until.IsZero() || t.Before(p.Until))
+	}
+	span := describeSpan(p.Since, p.Until)
+
+	switch p.Op {
+	case protocol.BulkDeleteMessages:
+		if p.User == "" {
+			return bulkOp{}, fmt.Errorf("delete_messages requires {user}")
+		}
+		if p.Channel != "" {
+			return bulkOp{}, fmt.Errorf("delete_messages does not take a channel")
+		}
+		username := p.User
+		match := func(m *protocol.StoredMessage) bool {
+			return strings.EqualFold(m.Username, username) && inRange(m.Timestamp)
+		}
+		if u := s.store.GetUser(p.User); u != nil {
+			id := u.ID
+			username = u.Username
+			match = func(m *protocol.StoredMessage) bool { return m.UserID == id && inRange(m.Timestamp) }
+		}
+		return bulkOp{
+			action:   ActionBulkDelete,
+			target:   username,
+			what:     "messages from " + username + span,
+			messages: match,
+		}, nil
+
+	case protocol.BulkPurgeChannel:
+		if p.User != "" || !p.Since.IsZero() || !p.Until.IsZero() {
+			return bulkOp{}, fmt.Errorf("purge_channel takes only a channel")
+		}
+		if protocol.IsPublic(p.Channel) {
+			name := protocol.PublicChannel(p.Channel)
+			if name == "" {
+				return bulkOp{}, fmt.Errorf("no such conversation %s", p.Channel)
+			}
+			p.Channel = name
+		}
+		if _, _, ok := protocol.DirectMembers(p.Channel); protocol.IsDirect(p.Channel) && !ok {
+			return bulkOp{}, fmt.Errorf("no such conversation %s", p.Channel)
+		}
+		ch := p.Channel
+		return bulkOp{
+			action:   ActionBulkPurge,
+			target:   ch,
+			what:     "messages in " + channelName(ch),
+			messages: func(m *protocol.StoredMessage) bool { return m.Channel == ch },
+		}, nil
+
+	case protocol.BulkUnban:
+		if p.User != "" || p.Channel != "" {
+			return bulkOp{}, fmt.Errorf("unban takes only a time range")
+		}
+		return bulkOp{
+			action: ActionBulkUnban,
+			what:   "deactivated accounts" + span,
+			users: func() []string {
+				var ids []string
+				for _, u := range s.store.SnapshotUsers() {
+					if u.Deactivated() && inRange(u.DeactivatedAt) {
+						ids = append(ids, u.ID)
+					}
+				}
+				return ids
+			},
+		}, nil
+	}
+	return bulkOp{}, fmt.Errorf("unknown bulk operation %q", p.Op)
+}
+
+// describeSpan renders a time range for messages and the moderation log.
+func describeSpan(since, until time.Time) string {
+	const layout = "2006-01-02 15:04 MST"
+	switch {
+	case since.IsZero() && until.IsZero():
+		return ""
+	case until.IsZero():
+		return " since " + since.UTC().Format(layout)
+	case since.IsZero():
+		return " before " + until.UTC().Format(layout)
+	}
+	return " from " + since.UTC().Format(layout) + " to " + until.UTC().Format(layout)
+}
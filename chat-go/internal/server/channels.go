@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Public channels
+// ---------------------------------------------------------------------------
+//
+// Besides the main channel, anyone can open a public channel by joining it;
+// TypeChannelList lets users discover the existing ones.  Like a DM, a
+// channel's messages go only to its members' sessions, and only members may
+// read, search or post in it.  The channel's creator and moderators may set
+// its topic, and may make it an announcement channel, in which only they
+// can post and everyone else reads.
+//
+// Admins can archive a channel that has run its course.  It drops out of
+// the channel list (admins still see it, marked), takes no new members and
+// no more messages, scheduled ones included, while its members keep
+// reading its history.  Restoring it undoes all of that.
+//
+// New channel names and topics must also suit Config.NamePolicy
+// (namepolicy.go).
+
+// sendChannel delivers pkt to every session of the members of a public
+// channel.
+func (s *Server) sendChannel(channel string, pkt *protocol.Packet) {
+	members := s.store.ChannelMembers(channel)
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, c := range s.sessions {
+		if members[c.getUserID()] {
+			c.sendPacket(pkt)
+		}
+	}
+}
+
+func (s *Server) handleChannelList(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	admin := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin)
+	list := s.store.Channels(c.getUserID(), admin)
+	for i := range list {
+		list[i].LastAt = s.stamps.coarse(list[i].Channel, list[i].LastAt)
+	}
+	c.sendResponse(true, fmt.Sprintf("%d channel(s)", len(list)), list)
+}
+
+// channelArg decodes a ChannelPayload and normalises its channel name.
+func channelArg(c *Client, raw json.RawMessage, what string) (protocol.ChannelPayload, bool) {
+	var p protocol.ChannelPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.Channel == "" {
+		c.sendError(what + " requires {channel}")
+		return p, false
+	}
+	name := protocol.PublicChannel(p.Channel)
+	if name == "" {
+		c.sendError(fmt.Sprintf("invalid channel name %q (use up to %d of a-z, 0-9, '-' and '_')", p.Channel, protocol.MaxChannelName))
+		return p, false
+	}
+	p.Channel = name
+	return p, true
+}
+
+func (s *Server) handleJoin(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	p, ok := channelArg(c, raw, "join")
+	if !ok || s.refuseWrite(c) {
+		return
+	}
+	if _, exists := s.store.ChannelCreator(p.Channel); !exists {
+		admin := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin)
+		if refusePolicy(c, s.cfg.NamePolicy.CheckChannel(p.Channel, admin)) {
+			return
+		}
+	}
+	peers := s.rosterPeers(c.getUserID())
+	info, created, err := s.store.JoinChannel(p.Channel, c.getUserID())
+	if errors.Is(err, store.ErrArchived) {
+		c.sendError("#" + p.Channel + " is archived")
+		return
+	}
+	if err != nil {
+		c.logger("store").Error("saving channels failed", "err", err)
+		c.sendError("could not join #" + p.Channel)
+		return
+	}
+	info.LastAt = s.stamps.coarse(info.Channel, info.LastAt)
+	msg := "joined #" + p.Channel
+	if created {
+		msg = "created #" + p.Channel
+		c.logger("server").Info("created a channel", "channel", p.Channel)
+	}
+	c.sendResponse(true, msg, info)
+	s.resendRoster(c.getUserID(), peers)
+}
+
+func (s *Server) handleLeave(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	p, ok := channelArg(c, raw, "leave")
+	if !ok {
+		return
+	}
+	peers := s.rosterPeers(c.getUserID())
+	if err := s.store.LeaveChannel(p.Channel, c.getUserID()); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	c.sendResponse(true, "left #"+p.Channel, nil)
+	s.resendRoster(c.getUserID(), peers)
+}
+
+func (s *Server) handleTopic(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	p, ok := channelArg(c, raw, "topic")
+	if !ok || s.refuseWrite(c) {
+		return
+	}
+	if !s.channelOwner(c, p.Channel, "set its topic") {
+		return
+	}
+	topic := strings.TrimSpace(sanitizeLine(p.Topic))
+	admin := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin)
+	if refusePolicy(c, s.cfg.NamePolicy.CheckTopic(topic, admin)) {
+		return
+	}
+	if err := s.store.SetTopic(p.Channel, topic); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	c.sendResponse(true, "topic of #"+p.Channel+" set", nil)
+	notice := fmt.Sprintf("%s cleared the topic of #%s", c.getUsername(), p.Channel)
+	if topic != "" {
+		notice = fmt.Sprintf("%s set the topic of #%s: %s", c.getUsername(), p.Channel, topic)
+	}
+	s.sendChannel(p.Channel, channelNotice(c, p.Channel, notice))
+}
+
+// channelOwner reports whether c created the public channel name or is a
+// moderator.  If not, it tells c that only they can do what.
+func (s *Server) channelOwner(c *Client, name, what string) bool {
+	creator, exists := s.store.ChannelCreator(name)
+	switch {
+	case !exists:
+		c.sendError("no channel #" + name)
+		return false
+	case creator != c.getUserID() && store.RoleRank(c.getRole()) < store.RoleRank(store.RoleModerator):
+		c.sendError("only the creator of #" + name + " or a moderator can " + what)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleChannelMode(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	p, ok := channelArg(c, raw, "channel mode")
+	if !ok || s.refuseWrite(c) || !s.channelOwner(c, p.Channel, "change its mode") {
+		return
+	}
+	if err := s.store.SetAnnounce(p.Channel, p.Announce); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	notice := fmt.Sprintf("%s made #%s an announcement channel: only its creator and moderators can post", c.getUsername(), p.Channel)
+	if !p.Announce {
+		notice = fmt.Sprintf("%s opened #%s: every member can post again", c.getUsername(), p.Channel)
+	}
+	c.logger("server").Info("set the channel mode", "channel", p.Channel, "announce", p.Announce)
+	c.sendResponse(true, "mode of #"+p.Channel+" set", nil)
+	s.sendChannel(p.Channel, channelNotice(c, p.Channel, notice))
+	s.sendChannelInfo(p.Channel)
+}
+
+func (s *Server) handleArchive(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("archiving channels requires the admin role")
+		return
+	}
+	p, ok := channelArg(c, raw, "archive")
+	if !ok || s.refuseWrite(c) {
+		return
+	}
+	if err := s.store.SetArchived(p.Channel, p.Archived); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	verb, notice := "restored", fmt.Sprintf("%s restored #%s: it is open again", c.getUsername(), p.Channel)
+	if p.Archived {
+		verb, notice = "archived", fmt.Sprintf("%s archived #%s: its history stays readable, but nobody can post or join", c.getUsername(), p.Channel)
+	}
+	c.logger("server").Info(verb+" a channel", "channel", p.Channel)
+	c.sendResponse(true, verb+" #"+p.Channel, nil)
+	s.sendChannel(p.Channel, channelNotice(c, p.Channel, notice))
+	s.sendChannelInfo(p.Channel)
+}
+
+// channelNotice builds the notice that c changed the public channel name.
+func channelNotice(c *Client, name, msg string) *protocol.Packet {
+	return systemNotice(protocol.SystemPayload{
+		Kind:    protocol.SystemChannel,
+		Message: msg,
+		User:    c.getUsername(),
+		Channel: name,
+	})
+}
+
+// sendChannelInfo sends every session of the members of a public channel
+// their own ChannelInfo for it, so clients can tell whether they may post.
+func (s *Server) sendChannelInfo(channel string) {
+	members := s.store.ChannelMembers(channel)
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, c := range s.sessions {
+		if !members[c.getUserID()] {
+			continue
+		}
+		info, ok := s.store.ChannelInfo(channel, c.getUserID())
+		if !ok {
+			return
+		}
+		info.LastAt = s.stamps.coarse(info.Channel, info.LastAt)
+		if pkt, err := protocol.NewPacket(protocol.TypeChannelMode, info); err == nil {
+			c.sendPacket(pkt)
+		}
+	}
+}
@@ -5,18 +5,56 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"chat/internal/protocol"
 )
 
 const (
-	sendBufSize  = 256           // buffered send channel capacity
-	writeTimeout = 10 * time.Second
-	readTimeout  = 5 * time.Minute // idle connection timeout
+	ctrlBufSize = 64              // buffered control channel capacity
+	readTimeout = 5 * time.Minute // idle connection timeout
 )
 
+// Defaults and bounds of Config.WriteTimeout, SendBuffer and
+// MaxPacketSize.  A slow satellite link wants a longer write timeout, a
+// LAN kiosk a short one and a small buffer; the bounds keep a typo from
+// stalling the Hub or eating the heap.  maxPacketSize is a floor because
+// messages at maxContentLength must still fit a packet.
+const (
+	defaultWriteTimeout = 10 * time.Second
+	defaultSendBuffer   = 256
+	defaultPacketSize   = bufio.MaxScanTokenSize
+
+	minWriteTimeout, maxWriteTimeout = time.Second, 10 * time.Minute
+	minSendBuffer, maxSendBuffer     = 16, 1 << 16
+	minPacketSize, maxPacketSize     = 16 << 10, protocol.MaxPacketSizeLimit
+)
+
+// connSettings fills in the connection defaults of cfg and checks them.
+func connSettings(cfg *Config) error {
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = defaultWriteTimeout
+	}
+	if cfg.SendBuffer == 0 {
+		cfg.SendBuffer = defaultSendBuffer
+	}
+	if cfg.MaxPacketSize == 0 {
+		cfg.MaxPacketSize = defaultPacketSize
+	}
+	switch {
+	case cfg.WriteTimeout < minWriteTimeout || cfg.WriteTimeout > maxWriteTimeout:
+		return fmt.Errorf("write timeout: want %v to %v, not %v", minWriteTimeout, maxWriteTimeout, cfg.WriteTimeout)
+	case cfg.SendBuffer < minSendBuffer || cfg.SendBuffer > maxSendBuffer:
+		return fmt.Errorf("send buffer: want %d to %d packets, not %d", minSendBuffer, maxSendBuffer, cfg.SendBuffer)
+	case cfg.MaxPacketSize < minPacketSize || cfg.MaxPacketSize > maxPacketSize:
+		return fmt.Errorf("max packet size: want %d to %d bytes, not %d", minPacketSize, maxPacketSize, cfg.MaxPacketSize)
+	}
+	return nil
+}
+
 // Client represents one TCP connection.
 //
 // Two goroutines are spawned per client:
@@ -28,25 +66,71 @@ const (
 //
 // This decouples reading from writing so a slow writer never blocks readers.
 type Client struct {
-	id       string // unique connection identifier
-	server   *Server
-	conn     net.Conn
-	send     chan []byte // outbound newline-terminated JSON packets
+	id          string // unique connection identifier
+	server      *Server
+	conn        net.Conn
+	send        chan []byte   // outbound newline-terminated JSON packets
+	ctrl        chan []byte   // control packets, written ahead of send
+	closed      chan struct{} // closed by the Hub once it lets go of the client
+	remoteAddr  string
+	connectedAt time.Time
+
+	usage    usage        // traffic counters, see usage.go
+	inLimit  *byteLimiter // nil when unlimited; used only by readPump
+	outLimit *byteLimiter // nil when unlimited; used only by writePump
+	typedAt  time.Time    // last typing indicator relayed, see typing.go; ditto
+	pow      powChallenge // latest registration challenge, see pow.go; ditto
+
+	// Overflow state, see overflow.go and slow.go.  skipped and slow are
+	// owned by the Hub goroutine; spill is nil unless the policy is
+	// OverflowSpill.
+	skipped int
+	slow    *slowState
+	spill   *spillQueue
+
+	catchUp atomic.Bool // replay the reconnect spool on login, see spool.go
+	noEcho  atomic.Bool // acknowledge own messages instead of echoing them, see order.go
+	diffs   atomic.Bool // asked for roster diffs, see rosterdiff.go
+
+	// moderated is closed once the latest message being moderated has
+	// been dealt with, see moderator.go; owned by readPump.
+	moderated chan struct{}
+
+	// roster gathers the roster changes for the next diff; nil unless the
+	// session gets diffs.  Set by addOnline with the Server's onlineMu held.
+	roster *rosterDiff
 
 	// Authenticated identity.  Protected by mu because readPump sets them
 	// after a successful login/register, and other goroutines may read them.
 	mu       sync.RWMutex
 	userID   string
 	username string
+	role     string
 }
 
 func newClient(id string, conn net.Conn, srv *Server) *Client {
-	return &Client{
-		id:     id,
-		conn:   conn,
-		server: srv,
-		send:   make(chan []byte, sendBufSize),
+	c := &Client{
+		id:          id,
+		conn:        conn,
+		server:      srv,
+		send:        make(chan []byte, srv.cfg.SendBuffer),
+		ctrl:        make(chan []byte, ctrlBufSize),
+		closed:      make(chan struct{}),
+		remoteAddr:  conn.RemoteAddr().String(),
+		connectedAt: time.Now().UTC(),
+		inLimit:     newByteLimiter(srv.cfg.MaxBytesPerSec),
+		outLimit:    newByteLimiter(srv.cfg.MaxBytesPerSec),
+	}
+	if srv.cfg.Overflow == OverflowSpill {
+		c.spill = newSpillQueue(srv.spillDir(), id)
 	}
+	return c
+}
+
+func (c *Client) getUserID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.userID
 }
 
 func (c *Client) getUsername() string {
@@ -61,11 +145,18 @@ func (c *Client) isAuthenticated() bool {
 	return c.userID != ""
 }
 
-func (c *Client) setIdentity(userID, username string) {
+func (c *Client) getRole() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.role
+}
+
+func (c *Client) setIdentity(userID, username, role string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.userID = userID
 	c.username = username
+	c.role = role
 }
 
 // readPump reads packets from the TCP connection line by line and dispatches
@@ -74,11 +165,19 @@ func (c *Client) readPump() {
 	defer func() {
 		c.server.hub.unregister <- c
 		c.server.removeOnline(c)
+		if c.isAuthenticated() {
+			c.server.events.Publish(sessionEvent(EventLeave, c))
+		}
 		c.conn.Close()
 	}()
 
 	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), c.server.cfg.MaxPacketSize)
 	for scanner.Scan() {
+		n := len(scanner.Bytes()) + 1 // + newline
+		c.usage.countIn(n)
+		c.server.traffic.countIn(n)
+		c.inLimit.wait(n)
 		c.conn.SetDeadline(time.Now().Add(readTimeout))
 
 		var pkt protocol.Packet
@@ -92,30 +191,191 @@ func (c *Client) readPump() {
 
 // writePump drains the send channel and writes each payload to the TCP
 // connection.  A write deadline is set for every write to prevent blocking
-// indefinitely on a stuck client.
+// indefinitely on a stuck client.  Control packets are always written first;
+// once the send channel is empty it replays anything the Hub spilled to disk.
+// Neither channel is ever closed, since other goroutines may still be
+// sending on them; writePump ends when closed is, once it has written what
+// was queued.
 func (c *Client) writePump() {
 	defer c.conn.Close()
+	defer c.spill.remove()
+
+	for {
+		select {
+		case data := <-c.ctrl:
+			if !c.write(data) {
+				return
+			}
+			continue
+		default:
+		}
+
+		if len(c.send) == 0 {
+			if line := c.spill.pop(); line != nil {
+				if !c.write(line) {
+					return
+				}
+				continue
+			}
+		}
+
+		select {
+		case data := <-c.ctrl:
+			if !c.write(data) {
+				return
+			}
+		case data := <-c.send:
+			if !c.write(data) {
+				return
+			}
+		case <-c.closed:
+			c.flushSend()
+			c.flushControl()
+			return
+		}
+	}
+}
+
+// flushSend writes whatever packets are still queued on send once the Hub
+// has let go of the client.
+func (c *Client) flushSend() {
+	for {
+		select {
+		case data := <-c.send:
+			if !c.write(data) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
 
-	for data := range c.send {
-		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-		if _, err := c.conn.Write(data); err != nil {
+// flushControl writes whatever control packets are still queued, so a
+// final shutdown notice is not lost when the Hub lets go of the client.
+func (c *Client) flushControl() {
+	for {
+		select {
+		case data := <-c.ctrl:
+			if !c.write(data) {
+				return
+			}
+		default:
 			return
 		}
 	}
 }
 
-// sendPacket marshals pkt, appends a newline, and queues it on the send channel.
-// Non-blocking: if the buffer is full the packet is silently dropped.
+func (c *Client) write(data []byte) bool {
+	c.outLimit.wait(len(data))
+	c.conn.SetWriteDeadline(time.Now().Add(c.server.cfg.WriteTimeout))
+	if _, err := c.conn.Write(data); err != nil {
+		return false
+	}
+	c.usage.countOut(len(data))
+	c.server.traffic.countOut(len(data))
+	return true
+}
+
+// disconnect writes reason to the client as a final system notice and closes
+// the connection.  The notice bypasses the send channel so it is on the wire
+// before the socket closes; readPump then sees EOF and unregisters as usual.
+func (c *Client) disconnect(reason string) {
+	if data, err := systemPacket(protocol.SystemDisconnect, reason).Encode(); err == nil {
+		c.conn.SetWriteDeadline(time.Now().Add(c.server.cfg.WriteTimeout))
+		c.conn.Write(append(data, '\n'))
+	}
+	c.conn.Close()
+}
+
+// sendPacket marshals pkt, appends a newline, and queues it on the send
+// channel, or the ctrl channel for control packets.  Non-blocking: if the
+// buffer is full the packet is silently dropped.
 func (c *Client) sendPacket(pkt *protocol.Packet) {
 	data, err := pkt.Encode()
 	if err != nil {
 		return
 	}
 	line := append(data, '\n')
+	q := c.send
+	if isControl(pkt.Type) {
+		q = c.ctrl
+	}
 	select {
-	case c.send <- line:
+	case q <- line:
+	default:
+	}
+}
+
+// sendBatch wraps pkts in a TypeBatch frame described by b.  The frame is
+// queued as a unit, so the send buffer cost is one slot regardless of
+// len(pkts).
+//
+// No frame is longer than MaxPacketSize, which is what clients size their
+// line buffer for.  When pkts do not fit, a history batch keeps as many of
+// its newest packets as do and a gap batch as many of its oldest, with More
+// set, so the client pages for the rest as it does anyway; any other batch
+// is sent as several frames.
+func (c *Client) sendBatch(b protocol.BatchPayload, pkts []*protocol.Packet) {
+	var frames [][]protocol.Packet
+	switch b.Reason {
+	case protocol.BatchHistory, protocol.BatchOlder:
+		newest := slices.Clone(pkts)
+		slices.Reverse(newest)
+		frames = c.packBatch(b, newest)
+		slices.Reverse(frames[0])
+		if len(frames) > 1 {
+			frames, b.More = frames[:1], true
+		}
+	case protocol.BatchGap:
+		frames = c.packBatch(b, pkts)
+		if len(frames) > 1 {
+			frames, b.More = frames[:1], true
+		}
 	default:
+		frames = c.packBatch(b, pkts)
 	}
+	for _, f := range frames {
+		b.Packets = f
+		pkt, err := protocol.NewPacket(protocol.TypeBatch, b)
+		if err != nil {
+			return
+		}
+		c.sendPacket(pkt)
+	}
+}
+
+// packBatch splits pkts, in order, into runs that each fit one TypeBatch
+// frame described by b.  A packet too long for a frame of its own is sent
+// alone all the same.
+func (c *Client) packBatch(b protocol.BatchPayload, pkts []*protocol.Packet) [][]protocol.Packet {
+	b.Packets = []protocol.Packet{}
+	empty, _ := protocol.NewPacket(protocol.TypeBatch, b)
+	frame, _ := empty.Encode()
+	room := c.server.cfg.MaxPacketSize - len(frame) - 1 // the newline
+
+	var (
+		runs [][]protocol.Packet
+		run  = []protocol.Packet{}
+		used int
+	)
+	for _, p := range pkts {
+		data, err := p.Encode()
+		if err != nil {
+			continue
+		}
+		n := len(data)
+		if len(run) > 0 {
+			n++ // the comma
+		}
+		if len(run) > 0 && used+n > room {
+			runs = append(runs, run)
+			run, used, n = nil, 0, len(data)
+		}
+		run = append(run, *p)
+		used += n
+	}
+	return append(runs, run)
 }
 
 // sendResponse is a convenience helper for TypeResponse packets.
@@ -142,8 +402,21 @@ func (c *Client) sendError(msg string) {
 	c.sendPacket(pkt)
 }
 
-// sendSystem sends a server system-notice to this client only.
-func (c *Client) sendSystem(msg string) {
-	pkt, _ := protocol.NewPacket(protocol.TypeSystem, map[string]string{"message": msg})
+// sendErrorCode sends an error packet with a protocol.Err code, and data
+// as the code describes.
+func (c *Client) sendErrorCode(code, msg string, data any) {
+	raw, _ := json.Marshal(data)
+	pkt, _ := protocol.NewPacket(protocol.TypeResponse, protocol.ResponsePayload{
+		Success: false,
+		Message: fmt.Sprintf("error: %s", msg),
+		Code:    code,
+		Data:    raw,
+	})
 	c.sendPacket(pkt)
 }
+
+// sendSystem sends a server system-notice of the given kind to this client
+// only.
+func (c *Client) sendSystem(kind, msg string) {
+	c.sendPacket(systemPacket(kind, msg))
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Voice clips
+// ---------------------------------------------------------------------------
+//
+// A voice clip is a KindClip message whose attachment is a short audio
+// recording and whose Meta says how long it is and how it is encoded, so
+// clients can show "🔊 0:12" before anyone downloads it.  The recording is
+// uploaded like any other file; the server checks that it is audio and that
+// the stated duration is within Config.MaxClipLength, and stores the
+// metadata with the message.
+
+const (
+	defaultMaxClip = 2 * time.Minute
+	maxCodecLength = 32
+)
+
+func (s *Server) maxClip() time.Duration {
+	if s.cfg.MaxClipLength > 0 {
+		return s.cfg.MaxClipLength
+	}
+	return defaultMaxClip
+}
+
+// checkClip validates the Meta and attachment of a KindClip message, and
+// returns the Meta as it is stored: only the fields of protocol.ClipMeta.
+func (s *Server) checkClip(meta json.RawMessage, att *protocol.Attachment) (json.RawMessage, error) {
+	if att == nil || !isAudio(att.ContentType) {
+		return nil, fmt.Errorf("a clip needs an uploaded audio file")
+	}
+	var clip protocol.ClipMeta
+	if err := json.Unmarshal(meta, &clip); err != nil {
+		return nil, fmt.Errorf("clip meta must be {duration_ms, codec}")
+	}
+	if clip.DurationMS <= 0 {
+		return nil, fmt.Errorf("clip duration must be positive")
+	}
+	if max := s.maxClip(); time.Duration(clip.DurationMS)*time.Millisecond > max {
+		return nil, fmt.Errorf("clip too long (max %s)", max)
+	}
+	if clip.Codec == "" || len(clip.Codec) > maxCodecLength {
+		return nil, fmt.Errorf("clip codec must be 1-%d bytes", maxCodecLength)
+	}
+	for _, r := range clip.Codec {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return nil, fmt.Errorf("invalid clip codec %q (use a-z, 0-9, '-', '_' and '.')", clip.Codec)
+		}
+	}
+	return json.Marshal(clip)
+}
+
+// isAudio reports whether ctype is a sniffed audio type.  Ogg is sniffed
+// as application/ogg whatever it holds.
+func isAudio(ctype string) bool {
+	media, _, err := mime.ParseMediaType(ctype)
+	return err == nil && (strings.HasPrefix(media, "audio/") || media == "application/ogg")
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Console
+// ---------------------------------------------------------------------------
+//
+// These methods are what cmd/server's local console runs: whoever can type
+// on the server's stdin or reach its control socket is already the
+// operator, so none of them checks a role.  What they do is recorded like
+// an admin's action, with protocol.ServerName as the actor.
+
+// Sessions lists every authenticated connection, oldest first.
+func (s *Server) Sessions() []protocol.SessionInfo {
+	s.onlineMu.RLock()
+	out := make([]protocol.SessionInfo, 0, len(s.sessions))
+	for _, sc := range s.sessions {
+		out = append(out, protocol.SessionInfo{
+			ConnID:         sc.id,
+			UserID:         sc.getUserID(),
+			Username:       sc.getUsername(),
+			RemoteAddr:     sc.remoteAddr,
+			ConnectedSince: sc.connectedAt,
+		})
+	}
+	s.onlineMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedSince.Before(out[j].ConnectedSince) })
+	return out
+}
+
+// Kick disconnects the session with connection ID target, or every session
+// of the user named target, and returns how many it closed.
+func (s *Server) Kick(target, reason string) int {
+	s.onlineMu.RLock()
+	var victims []*Client
+	for _, sc := range s.sessions {
+		if sc.id == target || strings.EqualFold(sc.getUsername(), target) {
+			victims = append(victims, sc)
+		}
+	}
+	s.onlineMu.RUnlock()
+
+	notice := "This session was terminated by an administrator."
+	if reason != "" {
+		notice = fmt.Sprintf("This session was terminated by an administrator: %s", reason)
+	}
+	detail := "from the console"
+	if reason != "" {
+		detail += ": " + reason
+	}
+	for _, c := range victims {
+		c.disconnect(notice)
+		s.events.Publish(Event{
+			Type:     EventModeration,
+			Username: protocol.ServerName,
+			Action:   ActionKillSession,
+			Target:   c.getUsername(),
+			Reason:   "session " + c.id + " " + detail,
+		})
+		c.logger("console").Info("killed the session")
+	}
+	return len(victims)
+}
+
+// Announce sends msg to every connected client as an announcement.
+func (s *Server) Announce(msg string) error {
+	msg = sanitizeText(msg)
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("nothing to announce")
+	}
+	if utf8.RuneCountInString(msg) > maxContentLength {
+		return fmt.Errorf("announcement too long (max %d characters)", maxContentLength)
+	}
+	s.broadcastSystem(protocol.SystemAnnouncement, msg)
+	s.events.Publish(Event{
+		Type:     EventModeration,
+		Username: protocol.ServerName,
+		Action:   ActionAnnounce,
+		Reason:   msg,
+	})
+	logger("console").Info("announced", "message", msg)
+	return nil
+}
+
+// Stats returns the statistics report an admin's stats request gets.
+func (s *Server) Stats() protocol.StatsReport {
+	return s.statsReport()
+}
+
+// SetRoleLimits replaces Config.RoleLimits.  Connections pick up the new
+// limits with their next post or upload; the limits a client was sent at
+// login are not updated until it logs in again.
+func (s *Server) SetRoleLimits(rl map[string]RoleLimits) error {
+	if err := validRoleLimits(rl, s.cfg.MaxPacketSize); err != nil {
+		return err
+	}
+	s.roleLimits.Store(&rl)
+	logger("console").Info("role limits reloaded", "roles", len(rl))
+	return nil
+}
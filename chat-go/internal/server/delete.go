@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Message deletion
+// ---------------------------------------------------------------------------
+//
+// Users can delete their own messages, and admins anyone's.  The store
+// keeps a revision of what was deleted (see revisions.go), so a user
+// cannot hide what they said from moderators this way.  Whoever can read
+// the conversation gets a TypeDeleted packet to drop or tombstone the
+// line.  Only archived messages can be deleted: one that the worker pool
+// has not saved yet is not found, and neither is a scheduled one, which
+// TypeCancelScheduled is for.
+
+// ActionDeleteMessage is the moderation action of an admin deleting
+// someone else's message.
+const ActionDeleteMessage = "delete_message"
+
+func (s *Server) handleDelete(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	var p protocol.DeletePayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.ID == "" {
+		c.sendError("delete requires {id}")
+		return
+	}
+	msg := s.store.GetMessage(p.ID)
+	own := msg != nil && msg.UserID == c.getUserID()
+	admin := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin)
+	// Others' messages are "not found" rather than "not yours", so
+	// members cannot probe for IDs in conversations they do not read.
+	if msg == nil || !own && !admin {
+		c.sendError(fmt.Sprintf("no message %q of yours", p.ID))
+		return
+	}
+
+	reason := "deleted by its author"
+	if !own {
+		reason = "deleted by an admin"
+	}
+	ok, err := s.store.DeleteMessage(msg.ID, c.getUsername(), reason)
+	if err != nil {
+		c.logger("store").Error("deleting a message failed", "msg_id", msg.ID, "err", err)
+		c.sendError("could not delete the message")
+		return
+	}
+	if !ok { // deleted meanwhile, by another session or request
+		c.sendError(fmt.Sprintf("no message %q of yours", p.ID))
+		return
+	}
+
+	pkt, _ := protocol.NewPacket(protocol.TypeDeleted, protocol.DeletedPayload{
+		ID:      msg.ID,
+		Channel: msg.Channel,
+		By:      c.getUsername(),
+	})
+	s.sendConversation(msg.Channel, pkt)
+	if !own {
+		s.events.Publish(moderationEvent(c, ActionDeleteMessage, msg.Username, "message "+msg.ID))
+		c.logger("server").Info("deleted a message", "msg_id", msg.ID, "target", msg.Username)
+	}
+	c.sendResponse(true, "message deleted", nil)
+}
+
+// sendConversation delivers pkt to whoever may read channel: everyone for
+// MainChannel, a public channel's members, or a DM's two users.
+func (s *Server) sendConversation(channel string, pkt *protocol.Packet) {
+	switch {
+	case protocol.IsDirect(channel):
+		a, b, _ := protocol.DirectMembers(channel)
+		s.onlineMu.RLock()
+		defer s.onlineMu.RUnlock()
+		for _, c := range s.sessions {
+			if c.getUserID() == a || c.getUserID() == b {
+				c.sendPacket(pkt)
+			}
+		}
+	case protocol.IsPublic(channel):
+		s.sendChannel(channel, pkt)
+	default:
+		s.broadcast(pkt)
+	}
+}
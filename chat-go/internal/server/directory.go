@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// User directory
+// ---------------------------------------------------------------------------
+//
+// TypeUserSearch lists registered accounts by username prefix, a page at a
+// time, whether or not they are online.  Deactivated accounts are left out.
+
+// Page sizes for TypeUserSearch.
+const (
+	defaultUserSearch = 20
+	maxUserSearch     = 100
+)
+
+// handleUserSearch looks up registered users by name, so clients can offer
+// completion for users who are not online.
+func (s *Server) handleUserSearch(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var p protocol.UserSearchPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("user_search requires {prefix}")
+		return
+	}
+	if p.Limit <= 0 {
+		p.Limit = defaultUserSearch
+	}
+	p.Limit = min(p.Limit, maxUserSearch)
+
+	users, more := s.store.SearchUsers(p.Prefix, p.After, p.Limit)
+	res := protocol.UserSearchResult{Users: make([]protocol.DirectoryEntry, len(users)), More: more}
+	s.onlineMu.RLock()
+	for i, u := range users {
+		res.Users[i] = protocol.DirectoryEntry{Username: u.Username, Online: s.online[u.ID] != nil}
+	}
+	s.onlineMu.RUnlock()
+	c.sendResponse(true, fmt.Sprintf("%d user(s)", len(users)), res)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Direct messages
+// ---------------------------------------------------------------------------
+//
+// A DM lives in a channel named after its two members (protocol.
+// DirectChannel).  The Hub delivers it to the members' sessions only, and
+// only members may read, search or post in it.
+
+// recipient checks that c may post in channel and returns the username the
+// message is addressed to: "" for the main channel and public channels, the
+// other member for a DM.
+func (s *Server) recipient(c *Client, channel string) (string, error) {
+	return s.recipientFor(c.getUserID(), channel)
+}
+
+// recipientFor is recipient for the user with the given ID.
+func (s *Server) recipientFor(userID, channel string) (string, error) {
+	if channel == protocol.MainChannel {
+		return "", nil
+	}
+	if !protocol.IsDirect(channel) {
+		if !s.store.InChannel(channel, userID) {
+			return "", fmt.Errorf("you have not joined #%s", channel)
+		}
+		return "", nil
+	}
+	a, b, ok := protocol.DirectMembers(channel)
+	if !ok || (a != userID && b != userID) {
+		return "", fmt.Errorf("no such conversation %q", channel)
+	}
+	peerID := a
+	if peerID == userID {
+		peerID = b
+	}
+	peer := s.store.GetUserByID(peerID)
+	if peer == nil {
+		return "", fmt.Errorf("no such conversation %q", channel)
+	}
+	return peer.Username, nil
+}
+
+// visibleChannels is every conversation c can read: the main channel, its
+// DMs and the public channels it joined.
+func (s *Server) visibleChannels(c *Client) []string {
+	channels := []string{protocol.MainChannel}
+	for _, conv := range s.store.Conversations(c.getUserID()) {
+		channels = append(channels, conv.Channel)
+	}
+	return channels
+}
+
+func (s *Server) handleConversations(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	convs := s.store.Conversations(c.getUserID())
+	for i := range convs {
+		convs[i].LastAt = s.stamps.coarse(convs[i].Channel, convs[i].LastAt)
+	}
+	c.sendResponse(true, fmt.Sprintf("%d conversation(s)", len(convs)), convs)
+}
+
+func (s *Server) handleOpenDM(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var p protocol.OpenDMPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.Username == "" {
+		c.sendError("open_dm requires {username}")
+		return
+	}
+	peer := s.store.GetUser(strings.TrimPrefix(p.Username, "@"))
+	if peer == nil {
+		c.sendError(fmt.Sprintf("no user %q", p.Username))
+		return
+	}
+	if peer.ID == c.getUserID() {
+		c.sendError("you cannot message yourself")
+		return
+	}
+	info := protocol.ConversationInfo{
+		Channel: protocol.DirectChannel(c.getUserID(), peer.ID),
+		Peer:    peer.Username,
+	}
+	c.sendResponse(true, "conversation with "+peer.Username, info)
+}
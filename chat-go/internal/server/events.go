@@ -0,0 +1,289 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Event bus
+// ---------------------------------------------------------------------------
+//
+// Handlers do not deliver, persist or count what they do themselves: they
+// publish an Event and whoever cares subscribes to it.  The core
+// subscriptions made in subscribeCore are
+//
+//	hub       – announces joins, or sums them up (presence.go); the Hub
+//	            fans messages out itself before publishing them (order.go)
+//	store     – queues messages for persistence on the worker pool
+//	metrics   – counts events for /metrics
+//	stats     – records the peak of users online (stats.go)
+//	accounts  – records when each account was last seen (inactive.go)
+//	audit     – writes moderation actions to the audit log
+//	authhooks – tells an external service about new accounts (authhooks.go)
+//	transform – annotates messages for readers' locales (translate.go)
+//	mentions  – tells users they were @mentioned (mentions.go)
+//
+// and Server.Events lets plugins, webhooks and the like add their own
+// without touching the dispatch path.
+//
+// Publish runs the synchronous subscribers in subscription order on the
+// publishing goroutine, so they see events in the order they happened and
+// must not block; anything slow belongs in a SubscribeQueue subscriber,
+// which gets its own goroutine and buffer and loses events (counted in
+// chat_event_subscriber_dropped_total) rather than stall the publisher.
+
+// EventType names a kind of Event.
+type EventType string
+
+const (
+	EventMessage    EventType = "message"    // a chat message was posted
+	EventRegister   EventType = "register"   // an account was registered; EventJoin follows
+	EventJoin       EventType = "join"       // a session logged in
+	EventLeave      EventType = "leave"      // an authenticated session ended
+	EventModeration EventType = "moderation" // a moderator or admin acted
+)
+
+// Moderation actions.
+const (
+	ActionKillSession    = "kill_session"
+	ActionMaintenanceOn  = "maintenance_on"
+	ActionMaintenanceOff = "maintenance_off"
+	ActionPollClose      = "poll_close"
+	ActionAnnounce       = "announce"
+)
+
+const eventQueueSize = 256 // default SubscribeQueue buffer
+
+// Event is something that happened on the server.  Which fields are set
+// depends on Type.
+type Event struct {
+	Type EventType
+	At   time.Time
+
+	// Message is the posted message (EventMessage).
+	Message *protocol.StoredMessage
+
+	// The session that joined or left, or the moderator who acted.
+	ConnID   string
+	UserID   string
+	Username string
+	Addr     string // the client's address
+
+	// First marks an EventJoin that is the account's first login, other
+	// than the one right after registering.
+	First bool
+
+	// Moderation details: what was done, to what, and why.
+	Action string
+	Target string
+	Reason string
+}
+
+// Bus distributes Events to subscribers.  The zero value is ready to use.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []*subscription
+	next int
+
+	published atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+type subscription struct {
+	id    int
+	name  string
+	types map[EventType]bool // nil means every type
+	fn    func(Event)
+
+	queue chan Event    // nil for synchronous subscribers
+	done  chan struct{} // closed when a queued subscription is cancelled
+}
+
+func (sub *subscription) wants(t EventType) bool {
+	return sub.types == nil || sub.types[t]
+}
+
+// Subscribe calls fn for every published event of the given types (all
+// types when none are given), on the publisher's goroutine.  fn must return
+// quickly.  The returned function cancels the subscription.
+func (b *Bus) Subscribe(name string, fn func(Event), types ...EventType) (cancel func()) {
+	return b.add(&subscription{name: name, fn: fn, types: typeSet(types)})
+}
+
+// SubscribeQueue is like Subscribe but hands events to fn on a goroutine of
+// its own through a buffer of size events (eventQueueSize when size <= 0).
+// Events that find the buffer full are dropped for this subscriber.
+func (b *Bus) SubscribeQueue(name string, size int, fn func(Event), types ...EventType) (cancel func()) {
+	if size <= 0 {
+		size = eventQueueSize
+	}
+	sub := &subscription{
+		name:  name,
+		fn:    fn,
+		types: typeSet(types),
+		queue: make(chan Event, size),
+		done:  make(chan struct{}),
+	}
+	go sub.drain()
+	return b.add(sub)
+}
+
+func (b *Bus) add(sub *subscription) func() {
+	b.mu.Lock()
+	b.next++
+	sub.id = b.next
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(func() { b.remove(sub) }) }
+}
+
+func (b *Bus) remove(sub *subscription) {
+	b.mu.Lock()
+	for i, s := range b.subs {
+		if s == sub {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	if sub.done != nil {
+		close(sub.done)
+	}
+}
+
+// Publish delivers e to its subscribers, stamping At if it is unset.
+func (b *Bus) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	b.published.Add(1)
+
+	// Subscribers may publish or (un)subscribe themselves, so they run
+	// without the lock held.
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		if !sub.wants(e.Type) {
+			continue
+		}
+		if sub.queue == nil {
+			sub.fn(e)
+			continue
+		}
+		select {
+		case sub.queue <- e:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// drain runs a queued subscriber until it is cancelled.  A panicking
+// subscriber is logged and kept running.
+func (sub *subscription) drain() {
+	for {
+		select {
+		case e := <-sub.queue:
+			sub.call(e)
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+func (sub *subscription) call(e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger("events").Error("subscriber panicked", "subscriber", sub.name, "event", e.Type, "panic", r)
+		}
+	}()
+	sub.fn(e)
+}
+
+func typeSet(types []EventType) map[EventType]bool {
+	if len(types) == 0 {
+		return nil
+	}
+	set := make(map[EventType]bool, len(types))
+	for _, t := range types {
+		set[t] = true
+	}
+	return set
+}
+
+// Events returns the server's event bus for plugins to subscribe to.
+func (s *Server) Events() *Bus { return &s.events }
+
+// subscribeCore wires the server's own features to the bus.
+func (s *Server) subscribeCore() {
+	s.events.Subscribe("hub", s.deliverEvent, EventJoin, EventLeave)
+	s.events.Subscribe("store", func(e Event) { s.pool.submit(e.Message) }, EventMessage)
+	s.events.Subscribe("metrics", s.eventCounts.count)
+	s.events.Subscribe("stats", s.recordPeak, EventJoin)
+	s.events.Subscribe("accounts", s.touchUser, EventJoin, EventLeave)
+	s.events.Subscribe("audit", s.auditModeration, EventModeration)
+	s.events.Subscribe("mentions", s.mentionEvent, EventMessage)
+	if s.cfg.SpoolWindow > 0 {
+		s.events.Subscribe("spool", s.spoolEvent, EventMessage, EventJoin, EventLeave)
+	}
+	if h := s.cfg.AuthHooks; h != nil && h.URL != "" {
+		s.events.SubscribeQueue("authhooks", 0, s.authHookEvent, EventRegister, EventJoin)
+	}
+	if s.cfg.Transformer != nil {
+		s.events.SubscribeQueue("transform", 0, s.transformEvent, EventMessage)
+	}
+}
+
+// auditModeration records a moderation event in the audit log.
+func (s *Server) auditModeration(e Event) {
+	err := s.store.Audit(store.AuditEntry{
+		At:     e.At,
+		Actor:  e.Username,
+		Action: e.Action,
+		Target: e.Target,
+		Detail: e.Reason,
+	})
+	if err != nil {
+		logger("store").Error("writing the audit log failed", "err", err)
+	}
+}
+
+// eventCounts tallies events by type for /metrics.
+type eventCounts struct {
+	messages, joins, leaves, moderation atomic.Uint64
+}
+
+func (n *eventCounts) count(e Event) {
+	switch e.Type {
+	case EventMessage:
+		n.messages.Add(1)
+	case EventJoin:
+		n.joins.Add(1)
+	case EventLeave:
+		n.leaves.Add(1)
+	case EventModeration:
+		n.moderation.Add(1)
+	}
+}
+
+// sessionEvent builds a join or leave event for c.
+func sessionEvent(t EventType, c *Client) Event {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Event{Type: t, ConnID: c.id, UserID: c.getUserID(), Username: c.getUsername(), Addr: c.remoteAddr}
+}
+
+// moderationEvent builds an event recording that c did action to target.
+func moderationEvent(c *Client, action, target, reason string) Event {
+	e := sessionEvent(EventModeration, c)
+	e.Action, e.Target, e.Reason = action, target, reason
+	return e
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Personal data export
+// ---------------------------------------------------------------------------
+//
+// A user can ask for a copy of everything the server holds about their
+// account (TypeExport); admins can ask for anyone's.  The bundle is built
+// by store.ExportUser in the background, with TypeExportStatus packets to
+// every session of whoever asked: progress through the message archive,
+// then the download URL on the HTTP sidecar, which only they can fetch.
+// Bundles are kept under <dataDir>/exports for exportTTL and dropped at
+// restart.  Each request is written to the audit log.
+
+const (
+	exportTTL      = 24 * time.Hour
+	exportProgress = 2 * time.Second // least time between progress packets
+)
+
+// ActionDataExport is the audit action of an export request.
+const ActionDataExport = "data_export"
+
+// exportJob is one requested export.
+type exportJob struct {
+	id      string
+	ownerID string // who asked, and may download it
+	user    string // whose data it is
+	path    string
+	size    int64
+	ready   bool
+	expires time.Time // of the bundle, once ready
+}
+
+// exportJobs holds the exports running or ready for download.
+type exportJobs struct {
+	mu   sync.Mutex
+	byID map[string]*exportJob
+}
+
+// start registers a new export of user's data for ownerID, refusing while
+// ownerID has another one running.  Expired bundles are removed.
+func (x *exportJobs) start(ownerID, user, dir string) (*exportJob, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byID == nil {
+		x.byID = make(map[string]*exportJob)
+	}
+	now := time.Now()
+	for id, j := range x.byID {
+		switch {
+		case j.ownerID == ownerID && !j.ready:
+			return nil, fmt.Errorf("your export of %s's data is still being prepared", j.user)
+		case j.ready && now.After(j.expires):
+			os.Remove(j.path)
+			delete(x.byID, id)
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	j := &exportJob{id: id, ownerID: ownerID, user: user, path: filepath.Join(dir, id+".json")}
+	x.byID[id] = j
+	return j, nil
+}
+
+// finish marks j ready for download, or forgets it when err is set.
+func (x *exportJobs) finish(j *exportJob, size int64, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err != nil {
+		delete(x.byID, j.id)
+		return
+	}
+	j.size, j.ready, j.expires = size, true, time.Now().Add(exportTTL)
+}
+
+// ready returns the export id if it is ready and belongs to ownerID.
+func (x *exportJobs) ready(id, ownerID string) (exportJob, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	j, ok := x.byID[id]
+	if !ok || !j.ready || j.ownerID != ownerID || time.Now().After(j.expires) {
+		return exportJob{}, false
+	}
+	return *j, true
+}
+
+func (s *Server) exportDir() string {
+	return filepath.Join(s.cfg.DataDir, "exports")
+}
+
+func (s *Server) handleExport(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.cfg.HTTPAddr == "" {
+		c.sendError("data export needs the HTTP file service, which is not enabled on this server")
+		return
+	}
+	var p protocol.ExportPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("export requires {} or {user}")
+		return
+	}
+	user := c.getUsername()
+	if p.User != "" && !strings.EqualFold(p.User, user) {
+		if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+			c.sendError("exporting another user's data requires the admin role")
+			return
+		}
+		u := s.store.GetUser(p.User)
+		if u == nil {
+			c.sendError(fmt.Sprintf("user %q not found", p.User))
+			return
+		}
+		user = u.Username
+	}
+	j, err := s.exports.start(c.getUserID(), user, s.exportDir())
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	s.events.Publish(moderationEvent(c, ActionDataExport, user, "export "+j.id))
+	c.sendResponse(true, fmt.Sprintf("preparing an export of %s's data; you will be told when it is ready", user),
+		protocol.ExportStatus{ID: j.id, User: user, State: protocol.ExportRunning})
+	go s.runExport(j)
+}
+
+// runExport builds and writes the bundle of j, reporting to its owner.
+func (s *Server) runExport(j *exportJob) {
+	st := protocol.ExportStatus{ID: j.id, User: j.user, State: protocol.ExportRunning}
+	var last time.Time
+	x, err := s.store.ExportUser(j.user, func(done, total int) {
+		if done < total && time.Since(last) < exportProgress {
+			return
+		}
+		last = time.Now()
+		st.Done, st.Total = done, total
+		s.sendExportStatus(j.ownerID, st)
+	})
+	var size int64
+	if err == nil {
+		size, err = writeExport(j.path, x)
+	}
+	s.exports.finish(j, size, err)
+	st.Done, st.Total = 0, 0
+	if err != nil {
+		logger("export").Error("export failed", "user", j.user, "err", err)
+		st.State, st.Error = protocol.ExportFailed, "the export could not be written"
+		s.sendExportStatus(j.ownerID, st)
+		return
+	}
+	logger("export").Info("export ready", "user", j.user, "size", size)
+	st.State, st.URL, st.Size, st.Expires = protocol.ExportReady, s.exportURL(j.id), size, time.Now().Add(exportTTL).UTC()
+	s.sendExportStatus(j.ownerID, st)
+}
+
+// writeExport saves x as JSON at path, readable by the server only, and
+// returns its size.
+func writeExport(path string, x *store.UserExport) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, err
+	}
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// sendExportStatus sends st to every session of the user with the given ID.
+func (s *Server) sendExportStatus(userID string, st protocol.ExportStatus) {
+	pkt, err := protocol.NewPacket(protocol.TypeExportStatus, st)
+	if err != nil {
+		return
+	}
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, c := range s.sessions {
+		if c.getUserID() == userID {
+			c.sendPacket(pkt)
+		}
+	}
+}
+
+// exportURL is where the bundle id is downloaded from.
+func (s *Server) exportURL(id string) string {
+	return strings.TrimSuffix(s.filesURL(), "/files") + "/exports/" + id
+}
+
+func (s *Server) httpExport(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.grantFor(r)
+	if !ok {
+		http.Error(w, "missing or expired bearer token", http.StatusUnauthorized)
+		return
+	}
+	j, ok := s.exports.ready(r.PathValue("id"), g.userID)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	name := fmt.Sprintf("chat-export-%s-%s.json", j.user, j.expires.Add(-exportTTL).Format("20060102"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeFile(w, r, j.path)
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Feed watcher
+// ---------------------------------------------------------------------------
+//
+// The server can poll RSS and Atom feeds and post their new entries as a bot
+// account.  Entry IDs already posted are kept in the Store (feeds.json), so a
+// restart neither reposts old entries nor misses ones published meanwhile.
+// The first poll of a feed only records what is already there: subscribing
+// to a feed does not flood the channel with its back catalogue.
+
+const (
+	defaultFeedUser     = "feeds"
+	defaultFeedInterval = 15 * time.Minute
+	minFeedInterval     = time.Minute
+	feedTimeout         = 30 * time.Second
+	maxFeedSize         = 5 << 20 // bytes of feed document read
+	maxFeedPosts        = 10      // entries posted per poll; the rest are skipped
+	feedSeenKeep        = 500     // entry IDs remembered per feed
+	feedUserAgent       = "chat-server feed watcher"
+)
+
+// FeedsConfig is the -feeds file: the account to post as and the feeds to
+// watch.
+//
+//	{
+//	  "user": "feeds",
+//	  "feeds": [
+//	    {"url": "https://go.dev/blog/feed.atom", "interval": "1h"},
+//	    {"url": "https://example.org/status.rss", "channel": "@alice"}
+//	  ]
+//	}
+type FeedsConfig struct {
+	User  string `json:"user,omitempty"` // bot account name; defaultFeedUser when empty
+	Feeds []Feed `json:"feeds"`
+}
+
+// Feed is one watched feed.  Channel is "" for the main channel or "@name"
+// to post as DMs to that user.  Title overrides the feed's own title in
+// posts.
+type Feed struct {
+	URL      string       `json:"url"`
+	Interval feedInterval `json:"interval,omitempty"`
+	Channel  string       `json:"channel,omitempty"`
+	Title    string       `json:"title,omitempty"`
+}
+
+// feedInterval is a time.Duration written as a string such as "15m".
+type feedInterval time.Duration
+
+func (d *feedInterval) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("interval must be a duration string such as \"15m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("interval: %w", err)
+	}
+	*d = feedInterval(v)
+	return nil
+}
+
+// LoadFeeds reads and checks a -feeds file.
+func LoadFeeds(path string) (*FeedsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("feeds: %w", err)
+	}
+	var fc FeedsConfig
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("feeds: parse %s: %w", path, err)
+	}
+	if fc.User == "" {
+		fc.User = defaultFeedUser
+	}
+	for i := range fc.Feeds {
+		f := &fc.Feeds[i]
+		if !strings.HasPrefix(f.URL, "http://") && !strings.HasPrefix(f.URL, "https://") {
+			return nil, fmt.Errorf("feeds: %q is not an http(s) URL", f.URL)
+		}
+		if f.Interval == 0 {
+			f.Interval = feedInterval(defaultFeedInterval)
+		}
+		if time.Duration(f.Interval) < minFeedInterval {
+			return nil, fmt.Errorf("feeds: %s: interval must be at least %s", f.URL, minFeedInterval)
+		}
+		if f.Channel != protocol.MainChannel && !strings.HasPrefix(f.Channel, "@") {
+			return nil, fmt.Errorf("feeds: %s: channel must be empty (main) or @username", f.URL)
+		}
+	}
+	return &fc, nil
+}
+
+// runFeeds starts one poller per configured feed.  It returns at once; the
+// pollers stop when s.quit is closed.
+func (s *Server) runFeeds() {
+	fc := s.cfg.Feeds
+	bot, err := s.store.BotUser(fc.User)
+	if err != nil {
+		logger("feeds").Error("feeds disabled", "err", err)
+		return
+	}
+	client := &http.Client{Timeout: feedTimeout}
+	for _, f := range fc.Feeds {
+		p := &feedPoller{s: s, feed: f, botID: bot.ID, botName: bot.Username, client: client}
+		go p.run()
+	}
+	logger("feeds").Info("watching", "feeds", len(fc.Feeds), "as", bot.Username)
+}
+
+// feedPoller polls one feed.  The validators from the last response are
+// sent back so an unchanged feed costs a 304.
+type feedPoller struct {
+	s       *Server
+	feed    Feed
+	botID   string
+	botName string
+	client  *http.Client
+
+	etag         string
+	lastModified string
+}
+
+func (p *feedPoller) run() {
+	t := time.NewTicker(time.Duration(p.feed.Interval))
+	defer t.Stop()
+	for {
+		if p.s.maint.get() == "" { // held until maintenance ends
+			if err := p.poll(); err != nil {
+				logger("feeds").Warn("poll failed", "feed", p.feed.URL, "err", err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-p.s.quit:
+			return
+		}
+	}
+}
+
+func (p *feedPoller) poll() error {
+	title, entries, err := p.fetch()
+	if err != nil || entries == nil {
+		return err
+	}
+	if p.feed.Title != "" {
+		title = p.feed.Title
+	}
+
+	seen, known := p.s.store.FeedSeen(p.feed.URL)
+	var fresh []feedEntry
+	for _, e := range entries {
+		if !seen[e.id] {
+			fresh = append(fresh, e)
+		}
+	}
+	if known && len(fresh) == 0 {
+		return nil
+	}
+	var channel, to string
+	if known {
+		// Resolved before anything is recorded, so entries for a user who
+		// does not exist yet wait for them.
+		if channel, to, err = p.target(); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]string, len(fresh))
+	for i, e := range fresh {
+		ids[i] = e.id
+	}
+	// Record before posting: a failed save then costs a missed entry rather
+	// than the same entry posted on every poll.
+	if err := p.s.store.MarkFeedSeen(p.feed.URL, ids, feedSeenKeep); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	if !known {
+		logger("feeds").Info("first poll, existing entries skipped", "feed", p.feed.URL, "entries", len(fresh))
+		return nil
+	}
+	if len(fresh) > maxFeedPosts {
+		logger("feeds").Info("too many new entries, posting the latest", "feed", p.feed.URL, "entries", len(fresh), "posted", maxFeedPosts)
+		fresh = fresh[:maxFeedPosts]
+	}
+	// Feeds list newest first; post oldest first so the channel reads in
+	// order.
+	for i := len(fresh) - 1; i >= 0; i-- {
+		e := fresh[i]
+		content := fmt.Sprintf("📰 %s: %s", title, e.title)
+		if e.link != "" {
+			content += "\n" + e.link
+		}
+		if r := []rune(content); len(r) > maxContentLength {
+			content = string(r[:maxContentLength-1]) + "…"
+		}
+		now := time.Now().UTC()
+		p.s.post(&protocol.StoredMessage{
+			ID:        p.s.newMessageID(now),
+			Channel:   channel,
+			To:        to,
+			UserID:    p.botID,
+			Username:  p.botName,
+			Content:   content,
+			Timestamp: now,
+		})
+	}
+	logger("feeds").Debug("posted", "feed", p.feed.URL, "entries", len(fresh))
+	return nil
+}
+
+// target resolves the feed's channel.  "@name" is looked up on every poll
+// so a user who registers after the server starts still gets the DMs.
+func (p *feedPoller) target() (channel, to string, err error) {
+	name, ok := strings.CutPrefix(p.feed.Channel, "@")
+	if !ok {
+		return protocol.MainChannel, "", nil
+	}
+	u := p.s.store.GetUser(name)
+	if u == nil {
+		return "", "", fmt.Errorf("no user %q to post to", name)
+	}
+	return protocol.DirectChannel(p.botID, u.ID), u.Username, nil
+}
+
+// fetch downloads and parses the feed.  entries is nil when the server
+// answered 304 Not Modified.
+func (p *feedPoller) fetch() (title string, entries []feedEntry, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.feed.URL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("User-Agent", feedUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	if p.lastModified != "" {
+		req.Header.Set("If-Modified-Since", p.lastModified)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return "", nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return "", nil, fmt.Errorf("GET: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return "", nil, err
+	}
+	title, entries, err = parseFeed(body)
+	if err != nil {
+		return "", nil, err
+	}
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
+	if entries == nil {
+		entries = []feedEntry{} // an empty feed is not "not modified"
+	}
+	return title, entries, nil
+}
+
+// feedEntry is an RSS item or Atom entry reduced to what gets posted.
+type feedEntry struct {
+	id, title, link string
+}
+
+// The subset of RSS 2.0 and Atom the watcher reads.
+type (
+	rssDoc struct {
+		Channel struct {
+			Title string `xml:"title"`
+			Items []struct {
+				GUID  string `xml:"guid"`
+				Title string `xml:"title"`
+				Link  string `xml:"link"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	atomDoc struct {
+		Title   string `xml:"title"`
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title string `xml:"title"`
+			Links []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+)
+
+// parseFeed reads an RSS 2.0 or Atom document.  An entry's ID is its
+// guid/id, falling back to its link and then its title.
+func parseFeed(data []byte) (string, []feedEntry, error) {
+	var root struct{ XMLName xml.Name }
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return "", nil, fmt.Errorf("parse: %w", err)
+	}
+	var (
+		title   string
+		entries []feedEntry
+	)
+	switch root.XMLName.Local {
+	case "rss":
+		var doc rssDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return "", nil, fmt.Errorf("parse rss: %w", err)
+		}
+		title = doc.Channel.Title
+		for _, it := range doc.Channel.Items {
+			entries = append(entries, newFeedEntry(it.GUID, it.Title, it.Link))
+		}
+	case "feed":
+		var doc atomDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return "", nil, fmt.Errorf("parse atom: %w", err)
+		}
+		title = doc.Title
+		for _, e := range doc.Entries {
+			link := ""
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			entries = append(entries, newFeedEntry(e.ID, e.Title, link))
+		}
+	default:
+		return "", nil, fmt.Errorf("not an RSS or Atom feed (root element <%s>)", root.XMLName.Local)
+	}
+	return strings.TrimSpace(sanitizeLine(title)), entries, nil
+}
+
+func newFeedEntry(id, title, link string) feedEntry {
+	e := feedEntry{
+		id:    strings.TrimSpace(id),
+		title: strings.Join(strings.Fields(sanitizeLine(title)), " "),
+		link:  strings.TrimSpace(sanitizeLine(link)),
+	}
+	if e.title == "" {
+		e.title = "(untitled)"
+	}
+	switch {
+	case e.id != "":
+	case e.link != "":
+		e.id = e.link
+	default:
+		e.id = e.title
+	}
+	return e
+}
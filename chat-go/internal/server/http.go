@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// HTTP sidecar – file upload/download
+// ---------------------------------------------------------------------------
+//
+// Binary data never travels over the JSON line protocol.  Instead a client
+// asks for a bearer token with a TypeFileToken packet, uploads the file over
+// HTTP, and then sends a chat message whose AttachmentID references the
+// upload.  Downloads use the same token, and only the uploader and those
+// who can read a message with the file attached may download it; to
+// anyone else it does not exist.
+//
+//	POST /files?name=<filename>   raw request body; returns protocol.Attachment
+//	GET  /files/{id}              streams the file
+//	GET  /exports/{id}            a personal data export, see export.go
+
+const (
+	fileTokenTTL         = time.Hour
+	defaultMaxUploadSize = 10 << 20 // 10 MiB
+)
+
+// DefaultUploadTypes are the media types accepted when Config.UploadTypes is
+// empty.  Types are checked against the sniffed content, not the client's
+// claim.
+var DefaultUploadTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+	"application/pdf", "application/zip", "text/plain",
+	"audio/wave", "audio/mpeg", "application/ogg", // voice clips, see clips.go
+}
+
+// fileGrant is what a file-service bearer token stands for.
+type fileGrant struct {
+	userID   string
+	username string
+	maxSize  int64 // upload limit of the user's role
+	expires  time.Time
+}
+
+// newHTTPServer builds the sidecar's http.Server and routes.
+func (s *Server) newHTTPServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /files", s.httpUpload)
+	mux.HandleFunc("GET /files/{id}", s.httpDownload)
+	mux.HandleFunc("GET /exports/{id}", s.httpExport)
+	mux.HandleFunc("GET /metrics", s.httpMetrics)
+	mux.HandleFunc("GET /admin/usage", s.httpUsage)
+	mux.HandleFunc("GET /admin/stats", s.httpStats)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// serveHTTP runs the sidecar until Shutdown closes it.
+func (s *Server) serveHTTP() {
+	logger("http").Info("listening", "addr", s.httpSrv.Addr)
+	if err := s.httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger("http").Error("stopped", "err", err)
+	}
+}
+
+// filesURL is the externally reachable base URL of the file service.
+func (s *Server) filesURL() string {
+	if s.cfg.PublicURL != "" {
+		return strings.TrimRight(s.cfg.PublicURL, "/") + "/files"
+	}
+	host, port, err := net.SplitHostPort(s.cfg.HTTPAddr)
+	if err != nil {
+		return ""
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/files"
+}
+
+func (s *Server) maxUploadSize() int64 {
+	if s.cfg.MaxUploadSize > 0 {
+		return s.cfg.MaxUploadSize
+	}
+	return defaultMaxUploadSize
+}
+
+func (s *Server) handleFileToken(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.cfg.HTTPAddr == "" {
+		c.sendError("file transfer is not enabled on this server")
+		return
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	exp := time.Now().Add(fileTokenTTL)
+
+	s.fileMu.Lock()
+	now := time.Now()
+	for t, g := range s.fileTokens {
+		if now.After(g.expires) {
+			delete(s.fileTokens, t)
+		}
+	}
+	s.fileTokens[token] = fileGrant{
+		userID:   c.getUserID(),
+		username: c.getUsername(),
+		maxSize:  s.limitsFor(c.getRole()).MaxUploadSize,
+		expires:  exp,
+	}
+	s.fileMu.Unlock()
+
+	c.sendResponse(true, "file token issued", protocol.FileTokenPayload{
+		Token:     token,
+		UploadURL: s.filesURL(),
+		ExpiresAt: exp.UTC(),
+	})
+}
+
+// grantFor validates the request's bearer token.
+func (s *Server) grantFor(r *http.Request) (fileGrant, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return fileGrant{}, false
+	}
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	g, ok := s.fileTokens[token]
+	if !ok || time.Now().After(g.expires) {
+		return fileGrant{}, false
+	}
+	return g, true
+}
+
+// httpAdmin checks that the request's bearer token belongs to an admin and
+// writes the error response when it does not.
+func (s *Server) httpAdmin(w http.ResponseWriter, r *http.Request) bool {
+	g, ok := s.grantFor(r)
+	if !ok {
+		http.Error(w, "missing or expired bearer token", http.StatusUnauthorized)
+		return false
+	}
+	if u := s.store.GetUserByID(g.userID); u == nil || store.RoleRank(u.Role) < store.RoleRank(store.RoleAdmin) {
+		http.Error(w, "admin role required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (s *Server) httpUpload(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.grantFor(r)
+	if !ok {
+		http.Error(w, "missing or expired bearer token", http.StatusUnauthorized)
+		return
+	}
+	if reason := s.maint.get(); reason != "" {
+		http.Error(w, "maintenance in progress: "+reason, http.StatusServiceUnavailable)
+		return
+	}
+	name := filepath.Base(sanitizeLine(r.URL.Query().Get("name")))
+	if name == "." || name == "/" || name == "" {
+		http.Error(w, "name query parameter is required", http.StatusBadRequest)
+		return
+	}
+	limit := g.maxSize
+	if r.ContentLength > limit {
+		http.Error(w, fmt.Sprintf("file exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body := bufio.NewReaderSize(http.MaxBytesReader(w, r.Body, limit), 512)
+	head, _ := body.Peek(512)
+	ctype := http.DetectContentType(head)
+	if !s.uploadTypeAllowed(ctype) {
+		http.Error(w, fmt.Sprintf("content type %s is not allowed", ctype), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	f, err := s.store.CreateFile(g.userID, name, ctype, body)
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		http.Error(w, fmt.Sprintf("file exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logger("http").Error("upload failed", "user", g.username, "err", err)
+		http.Error(w, "upload failed", http.StatusInternalServerError)
+		return
+	}
+	logger("http").Info("uploaded", "user", g.username, "file", f.ID, "content_type", f.ContentType, "size", f.Size)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.attachmentFor(f.ID))
+}
+
+func (s *Server) httpDownload(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.grantFor(r)
+	if !ok {
+		http.Error(w, "missing or expired bearer token", http.StatusUnauthorized)
+		return
+	}
+	f, path, ok := s.store.GetFile(r.PathValue("id"))
+	if !ok || !s.mayDownload(g.userID, f) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.Name}))
+	http.ServeFile(w, r, path)
+}
+
+// mayDownload reports whether the user with the given ID uploaded f or can
+// read a message it is attached to.
+func (s *Server) mayDownload(userID string, f *store.File) bool {
+	if f.OwnerID == userID {
+		return true
+	}
+	return slices.ContainsFunc(s.store.FileChannels(f.ID), func(ch string) bool {
+		return s.mayRead(userID, ch)
+	})
+}
+
+// uploadTypeAllowed compares the media type (ignoring parameters such as
+// charset) against the configured allow-list.
+func (s *Server) uploadTypeAllowed(ctype string) bool {
+	media, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	allowed := s.cfg.UploadTypes
+	if len(allowed) == 0 {
+		allowed = DefaultUploadTypes
+	}
+	for _, t := range allowed {
+		if strings.EqualFold(t, media) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentFor builds the message-facing description of an uploaded file.
+func (s *Server) attachmentFor(id string) *protocol.Attachment {
+	f, _, ok := s.store.GetFile(id)
+	if !ok {
+		return nil
+	}
+	return &protocol.Attachment{
+		ID:          f.ID,
+		Name:        f.Name,
+		ContentType: f.ContentType,
+		Size:        f.Size,
+		URL:         s.filesURL() + "/" + f.ID,
+	}
+}
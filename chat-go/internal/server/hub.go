@@ -1,6 +1,11 @@
 package server
 
-import "log"
+import (
+	"sync/atomic"
+	"time"
+
+	"chat/internal/protocol"
+)
 
 // Hub is the central message router.  It owns the set of connected clients and
 // fans out every broadcast to all of them.
@@ -12,65 +17,235 @@ import "log"
 //     mutex is needed for the map itself.
 //   • Other goroutines communicate with the Hub exclusively through channels:
 //       register   – add a new client
-//       unregister – remove a client and close its send channel
+//       unregister – remove a client and end its writePump (Client.closed)
+//       posts      – number a chat message and deliver it to its readers
+//                    (see order.go)
 //       broadcast  – deliver a JSON-encoded packet to every client
+//       control    – the same for control packets (see isControl)
 //   • Each Client has a buffered send channel (size 256).  If the buffer fills
-//     up (slow/stuck client), the Hub drops that client rather than blocking
-//     the entire broadcast.
+//     up (slow/stuck client), the Hub applies the overflow policy (see
+//     overflow.go) rather than blocking the entire broadcast.
+//   • Control packets take a separate path end to end: the Hub serves its
+//     control channel before broadcasts, and each Client has a small ctrl
+//     channel that writePump empties before touching send.  A flood of chat
+//     therefore never delays pongs, presence or shutdown notices.  A control
+//     packet that finds a client's ctrl channel full is dropped for that
+//     client only.
 type Hub struct {
 	clients    map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
+	posts      chan *hubPost
 	broadcast  chan []byte // newline-terminated JSON packet
+	control    chan []byte // ditto, high priority
+	reseq      chan map[string]uint64
 	done       chan struct{}
+	stopped    chan struct{} // closed when Run returns
+	running    atomic.Bool   // set by Start
+
+	// Message order, see order.go.  onPost delivers and publishes each
+	// message once it is numbered.
+	seqs   map[string]uint64 // last Seq of each channel
+	lastAt time.Time         // Timestamp of the last message
+	onPost func(*hubPost)
+
+	overflow string        // Overflow* policy for full send buffers
+	grace    time.Duration // see slow.go
+	stats    overflowStats // read by the metrics endpoint
+	drops    dropStats     // ditto
+
+	// Send buffer occupancy over all clients, sampled every monitorTick.
+	sendMax   atomic.Int64
+	sendTotal atomic.Int64
 }
 
-func newHub() *Hub {
+func newHub(overflow string, grace time.Duration, seqs map[string]uint64) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		posts:      make(chan *hubPost, 256),
 		broadcast:  make(chan []byte, 256),
+		control:    make(chan []byte, 64),
+		reseq:      make(chan map[string]uint64),
 		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		seqs:       seqs,
+		overflow:   overflow,
+		grace:      grace,
 	}
 }
 
-// Run processes hub events.  It must be launched as a goroutine.
+// Start launches Run on a goroutine of its own.
+func (h *Hub) Start() {
+	h.running.Store(true)
+	go h.Run()
+}
+
+// Run processes hub events.  It must be launched as a goroutine; see Start.
 func (h *Hub) Run() {
+	defer close(h.stopped)
+	tick := time.NewTicker(monitorTick)
+	defer tick.Stop()
 	for {
 		select {
+		case data := <-h.control:
+			h.deliverControl(data)
+			continue
+		default:
+		}
+
+		select {
+		case data := <-h.control:
+			h.deliverControl(data)
+
 		case c := <-h.register:
 			h.clients[c] = true
-			log.Printf("[hub] +client %s (%s)  total=%d", c.username, c.id, len(h.clients))
+			c.logger("hub").Debug("client registered", "total", len(h.clients))
 
 		case c := <-h.unregister:
 			if _, ok := h.clients[c]; ok {
 				delete(h.clients, c)
-				close(c.send)
-				log.Printf("[hub] -client %s (%s)  total=%d", c.username, c.id, len(h.clients))
+				close(c.closed)
+				c.logger("hub").Debug("client unregistered", "total", len(h.clients))
 			}
 
+		case p := <-h.posts:
+			h.number(p)
+			h.onPost(p)
+
 		case data := <-h.broadcast:
 			for c := range h.clients {
-				select {
-				case c.send <- data:
-				default:
-					// Client is not draining its send channel; drop it.
-					delete(h.clients, c)
-					close(c.send)
-					log.Printf("[hub] dropped slow client %s", c.username)
-				}
+				h.deliver(c, data)
 			}
 
+		case h.seqs = <-h.reseq:
+
+		case <-tick.C:
+			h.sample()
+			h.checkSlow()
+
 		case <-h.done:
-			// Close every outstanding send channel so writePumps unblock.
+			// Number and publish what was posted, so it is persisted, then
+			// let go of every client so writePumps unblock.
+			for len(h.posts) > 0 {
+				p := <-h.posts
+				h.number(p)
+				h.onPost(p)
+			}
 			for c := range h.clients {
-				close(c.send)
+				close(c.closed)
+			}
+			return
+		}
+	}
+}
+
+// sample records how full the clients' send buffers are.
+func (h *Hub) sample() {
+	var most, total int
+	for c := range h.clients {
+		n := len(c.send)
+		most = max(most, n)
+		total += n
+	}
+	h.sendMax.Store(int64(most))
+	h.sendTotal.Store(int64(total))
+}
+
+// isControl reports whether packets of type t travel on the priority path.
+func isControl(t protocol.MessageType) bool {
+	switch t {
+	case protocol.TypeHello, protocol.TypePong, protocol.TypeSystem,
+		protocol.TypeUserList, protocol.TypeUserJoined, protocol.TypeUserLeft:
+		return true
+	}
+	return false
+}
+
+func (h *Hub) deliverControl(data []byte) {
+	for c := range h.clients {
+		select {
+		case c.ctrl <- data:
+		default:
+		}
+	}
+}
+
+// deliver queues data for c, applying the overflow policy when c's send
+// buffer is full.
+func (h *Hub) deliver(c *Client, data []byte) {
+	switch h.overflow {
+	case OverflowSkip:
+		if c.skipped > 0 {
+			select {
+			case c.send <- gapPacket(c.skipped):
+				c.skipped = 0
+			default:
+				c.skipped++
+				h.stats.skipped.Add(1)
+				return
+			}
+		}
+		select {
+		case c.send <- data:
+		default:
+			if c.skipped == 0 {
+				c.logger("hub").Warn("fell behind, skipping broadcasts")
 			}
+			c.skipped++
+			h.stats.skipped.Add(1)
+		}
+		return
+
+	case OverflowSpill:
+		spilled, err := c.spill.pushIfActive(data)
+		if err == nil && !spilled {
+			select {
+			case c.send <- data:
+				return
+			default:
+				c.logger("hub").Warn("fell behind, spilling to disk")
+				err = c.spill.push(data)
+			}
+		}
+		if err == nil {
+			h.stats.spilled.Add(1)
 			return
 		}
+		h.drop(c, dropSpillError, err.Error())
+		return
+	}
+
+	if c.slow != nil && !h.recover(c) {
+		h.behind(c, data)
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		// Client is not draining its send channel; see slow.go.
+		h.behind(c, data)
 	}
 }
 
-// Stop signals the hub to shut down.
-func (h *Hub) Stop() { close(h.done) }
+// drop disconnects a client that cannot keep up, for reason, with detail
+// for the log.
+func (h *Hub) drop(c *Client, reason, detail string) {
+	delete(h.clients, c)
+	close(c.closed)
+	h.stats.dropped.Add(1)
+	h.drops.count(reason)
+	if detail != "" {
+		reason += " " + detail
+	}
+	c.logger("hub").Warn("dropped a slow client", "reason", reason)
+}
+
+// Stop shuts the hub down and waits for Run to return, if it was started.
+func (h *Hub) Stop() {
+	close(h.done)
+	if h.running.Load() {
+		<-h.stopped
+	}
+}
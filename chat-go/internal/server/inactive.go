@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Inactive account clean-up
+// ---------------------------------------------------------------------------
+//
+// With an InactivityPolicy configured, a background task goes through the
+// accounts every inactiveTick.  An account nobody has logged in or out of
+// for After-Warn is flagged and, when it has an email address and a Mailer
+// is configured, its owner is told what will happen.  Once the account has
+// been idle for After and flagged for at least Warn, it is deactivated or
+// deleted.  Logging in clears the flag, so the warning period always runs
+// in full — including for accounts that were already idle for years when
+// the policy was switched on.
+//
+// Admins, bot accounts and accounts of an external auth provider are left
+// alone, as is anyone online.  Every step is written to the audit log; in
+// DryRun mode the steps are logged and audited but nothing is changed and
+// no mail is sent.
+
+const inactiveTick = time.Hour
+
+// Inactivity policy actions.
+const (
+	InactiveDeactivate = "deactivate"
+	InactiveDelete     = "delete"
+)
+
+// InactivityPolicy configures the inactive account clean-up.
+type InactivityPolicy struct {
+	After  time.Duration // idle time before the account is acted on
+	Warn   time.Duration // how long before that the owner is warned
+	Action string        // InactiveDeactivate or InactiveDelete
+	DryRun bool
+}
+
+// Validate checks the policy's settings.
+func (p *InactivityPolicy) Validate() error {
+	switch {
+	case p.Action != InactiveDeactivate && p.Action != InactiveDelete:
+		return fmt.Errorf("inactive accounts: unknown action %q (want %s or %s)", p.Action, InactiveDeactivate, InactiveDelete)
+	case p.Warn < 0 || p.Warn >= p.After:
+		return fmt.Errorf("inactive accounts: the warning period must be shorter than the inactivity limit")
+	}
+	return nil
+}
+
+// touchUser is the accounts subscriber: it keeps LastSeenAt current on
+// every login and logout.
+func (s *Server) touchUser(e Event) {
+	if err := s.store.TouchUser(e.UserID); err != nil {
+		logger("store").Error("saving users failed", "err", err)
+	}
+}
+
+// runInactive must be launched as a goroutine; it returns when s.quit is
+// closed.
+func (s *Server) runInactive() {
+	p := s.cfg.Inactive
+	logger("inactive").Info("watching for idle accounts", "action", p.Action, "after", p.After, "warn", p.Warn, "dry_run", p.DryRun)
+
+	t := time.NewTicker(inactiveTick)
+	defer t.Stop()
+	for {
+		if s.maint.get() == "" { // held until maintenance ends
+			s.sweepInactive(time.Now().UTC())
+		}
+		select {
+		case <-t.C:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// sweepInactive makes one pass over the accounts.
+func (s *Server) sweepInactive(now time.Time) {
+	p := s.cfg.Inactive
+	s.onlineMu.RLock()
+	online := make(map[string]bool, len(s.online))
+	for id := range s.online {
+		online[id] = true
+	}
+	s.onlineMu.RUnlock()
+
+	for _, u := range s.store.SnapshotUsers() {
+		if online[u.ID] || u.Deactivated() || u.Source != "" || u.Role == store.RoleAdmin {
+			continue
+		}
+		idle := now.Sub(u.LastActive())
+		switch {
+		case u.InactiveWarnedAt.IsZero() && idle >= p.After-p.Warn:
+			s.warnInactive(u, now)
+		case !u.InactiveWarnedAt.IsZero() && idle >= p.After && now.Sub(u.InactiveWarnedAt) >= p.Warn:
+			s.retireInactive(u)
+		}
+	}
+}
+
+// warnInactive flags u and mails its owner.
+func (s *Server) warnInactive(u store.User, now time.Time) {
+	p := s.cfg.Inactive
+	deadline := now.Add(p.Warn)
+	if d := u.LastActive().Add(p.After); d.After(deadline) {
+		deadline = d
+	}
+	detail := fmt.Sprintf("last seen %s; to be %sd after %s", u.LastActive().Format(time.DateOnly), p.Action, deadline.Format(time.DateOnly))
+	s.auditInactive("flag_inactive", u, detail)
+	if p.DryRun {
+		return
+	}
+	if err := s.store.FlagInactive(u.ID, now); err != nil {
+		logger("inactive").Error("flagging failed", "target", u.Username, "err", err)
+		return
+	}
+	if u.Email == "" || s.cfg.Mailer == nil {
+		return
+	}
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"nobody has logged in to your chat account %q since %s.\n"+
+		"Unless you log in before %s, the account will be %sd.\n",
+		u.Username, u.Username, u.LastActive().Format(time.DateOnly), deadline.Format(time.DateOnly), p.Action)
+	if err := s.cfg.Mailer.Send(u.Email, "Your chat account is inactive", body); err != nil {
+		logger("inactive").Error("notifying failed", "target", u.Username, "err", err)
+		return
+	}
+	s.auditInactive("notify_inactive", u, "mailed "+u.Email)
+}
+
+// retireInactive deactivates or deletes u.
+func (s *Server) retireInactive(u store.User) {
+	p := s.cfg.Inactive
+	s.auditInactive(p.Action+"_inactive", u, "last seen "+u.LastActive().Format(time.DateOnly))
+	if p.DryRun {
+		return
+	}
+	var err error
+	if p.Action == InactiveDelete {
+		err = s.store.DeleteUser(u.ID)
+	} else {
+		err = s.store.DeactivateUser(u.ID)
+	}
+	if err != nil {
+		logger("inactive").Error(p.Action+" failed", "target", u.Username, "err", err)
+	}
+}
+
+func (s *Server) auditInactive(action string, u store.User, detail string) {
+	logger("inactive").Info(action, "target", u.Username, "detail", detail, "dry_run", s.cfg.Inactive.DryRun)
+	err := s.store.Audit(store.AuditEntry{
+		Actor:  "server",
+		Action: action,
+		Target: u.Username,
+		Detail: detail,
+		DryRun: s.cfg.Inactive.DryRun,
+	})
+	if err != nil {
+		logger("store").Error("writing the audit log failed", "err", err)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message kinds
+// ---------------------------------------------------------------------------
+//
+// The Meta of a structured message is shown to other users as well, so it
+// is cleaned like Content before it is stored.  The kinds the server knows
+// are checked field by field and stored with only their own fields: a
+// location's point must be on the globe and its label is one clean line,
+// and a clip is checked with its recording (see clips.go).  For any other
+// kind every string in the object, keys included, is sanitized, and the
+// object stored as it then is.
+
+// kindMeta checks and cleans the Meta of a message of the given kind,
+// returning it as it is stored.  checkKind has vetted both already.
+func kindMeta(kind string, meta json.RawMessage) (json.RawMessage, error) {
+	switch {
+	case len(meta) == 0:
+		return meta, nil
+	case kind == protocol.KindLocation:
+		return checkLocation(meta)
+	case kind == protocol.KindClip:
+		return meta, nil // checked with its attachment by checkClip
+	}
+	return sanitizeMeta(meta)
+}
+
+// checkLocation validates the Meta of a KindLocation message.
+func checkLocation(meta json.RawMessage) (json.RawMessage, error) {
+	var loc struct {
+		Lat, Lon *float64
+		Label    string
+	}
+	if err := json.Unmarshal(meta, &loc); err != nil || loc.Lat == nil || loc.Lon == nil {
+		return nil, fmt.Errorf("location meta must be {lat, lon[, label]}")
+	}
+	if *loc.Lat < -90 || *loc.Lat > 90 || *loc.Lon < -180 || *loc.Lon > 180 {
+		return nil, fmt.Errorf("latitude must be -90…90 and longitude -180…180")
+	}
+	label := sanitizeLine(loc.Label)
+	if utf8.RuneCountInString(label) > protocol.MaxLocationLabel {
+		return nil, fmt.Errorf("location label too long (max %d characters)", protocol.MaxLocationLabel)
+	}
+	return json.Marshal(protocol.LocationMeta{Lat: *loc.Lat, Lon: *loc.Lon, Label: label})
+}
+
+// sanitizeMeta cleans every string in meta, a JSON object.  Numbers are
+// kept as they were written.
+func sanitizeMeta(meta json.RawMessage) (json.RawMessage, error) {
+	d := json.NewDecoder(bytes.NewReader(meta))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("meta must be a JSON object")
+	}
+	return json.Marshal(sanitizeValue(v))
+}
+
+func sanitizeValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		return sanitizeText(v)
+	case []any:
+		for i := range v {
+			v[i] = sanitizeValue(v[i])
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[sanitizeLine(k)] = sanitizeValue(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Per-role limits
+// ---------------------------------------------------------------------------
+//
+// Config.RoleLimits can give each role its own message length, upload size
+// and posting rate, e.g. short messages and a slow rate for members and
+// more room for moderators and admins.  A role that is not listed, and a
+// zero field, take the server-wide default; for the posting rate that is
+// Config.PostRate and PostBurst, unlimited unless set.  The roles are store.RoleMember,
+// RoleModerator and RoleAdmin; connections that have not logged in cannot
+// post at all.
+//
+// The hello packet advertises the member limits, which is what a new
+// account gets; the login response (SessionPayload.Limits) carries the
+// caller's own.  Posting (chat and poll creation) is counted per account,
+// across its connections, by takePost once a message has passed the checks
+// on what it says, so a refused message costs nothing; one refused later,
+// by moderation or as scheduled too far ahead, is given back with
+// refundPost.  The counts are kept in the Store, so they outlast the
+// connection and, saved every rateLimitTick, a restart; runRateLimits
+// saves them and forgets those that have refilled.
+
+const rateLimitTick = time.Minute
+
+// maxRoleContentLength caps RoleLimits.MaxContentLength so that a message
+// still fits a packet of packetSize bytes after JSON escaping.
+func maxRoleContentLength(packetSize int) int { return packetSize / 8 }
+
+// RoleLimits are the limits for one role.
+type RoleLimits struct {
+	MaxContentLength  int   `json:"max_content_length,omitempty"`  // characters per chat message
+	MaxUploadSize     int64 `json:"max_upload_size,omitempty"`     // bytes per uploaded file
+	MessagesPerMinute int   `json:"messages_per_minute,omitempty"` // 0 means unlimited
+	Burst             int   `json:"burst,omitempty"`               // messages at once; 0 means a minute's worth
+}
+
+// LoadRoleLimits reads and checks a -role-limits file: a JSON object of
+// role name to RoleLimits.  New checks the content lengths again against
+// the packet size actually configured.
+func LoadRoleLimits(path string) (map[string]RoleLimits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("role limits: %w", err)
+	}
+	var rl map[string]RoleLimits
+	if err := json.Unmarshal(data, &rl); err != nil {
+		return nil, fmt.Errorf("role limits: parse %s: %w", path, err)
+	}
+	return rl, validRoleLimits(rl, maxPacketSize)
+}
+
+func validRoleLimits(rl map[string]RoleLimits, packetSize int) error {
+	for role, l := range rl {
+		switch role {
+		case store.RoleMember, store.RoleModerator, store.RoleAdmin:
+		default:
+			return fmt.Errorf("role limits: unknown role %q", role)
+		}
+		if l.MaxContentLength < 0 || l.MaxUploadSize < 0 || l.MessagesPerMinute < 0 || l.Burst < 0 {
+			return fmt.Errorf("role limits: %s: limits cannot be negative", role)
+		}
+		if most := maxRoleContentLength(packetSize); l.MaxContentLength > most {
+			return fmt.Errorf("role limits: %s: max_content_length is at most %d", role, most)
+		}
+	}
+	return nil
+}
+
+// limitsFor returns the limits of role.
+func (s *Server) limitsFor(role string) protocol.Limits {
+	l := protocol.Limits{
+		MaxContentLength: maxContentLength,
+		MaxPacketSize:    s.cfg.MaxPacketSize,
+		MaxHistory:       maxHistory,
+	}
+	if s.cfg.HTTPAddr != "" {
+		l.MaxUploadSize = s.maxUploadSize()
+		l.MaxClipSeconds = int(s.maxClip().Seconds())
+	}
+	if role == "" {
+		role = store.RoleMember
+	}
+	rl := (*s.roleLimits.Load())[role]
+	if rl.MaxContentLength > 0 {
+		l.MaxContentLength = rl.MaxContentLength
+	}
+	if rl.MaxUploadSize > 0 && s.cfg.HTTPAddr != "" {
+		l.MaxUploadSize = rl.MaxUploadSize
+	}
+	l.MessagesPerMinute = cmp.Or(rl.MessagesPerMinute, s.postRatePerMinute())
+	l.Burst = cmp.Or(rl.Burst, s.cfg.PostBurst)
+	switch {
+	case l.MessagesPerMinute == 0:
+		l.Burst = 0
+	case l.Burst == 0:
+		l.Burst = l.MessagesPerMinute
+	}
+	return l
+}
+
+// postRatePerMinute is Config.PostRate in messages a minute, at least one
+// when it is set at all.
+func (s *Server) postRatePerMinute() int {
+	if s.cfg.PostRate <= 0 {
+		return 0
+	}
+	return max(1, int(math.Round(s.cfg.PostRate*60)))
+}
+
+// takePost counts a post of type typ (chat or poll creation) against c's
+// posting rate, telling c and returning false when it is over.
+func (s *Server) takePost(c *Client, typ protocol.MessageType) bool {
+	l := s.limitsFor(c.getRole())
+	if l.MessagesPerMinute == 0 {
+		return true
+	}
+	wait := s.store.TakeRate("post:"+c.getUserID(), float64(l.MessagesPerMinute)/60, float64(l.Burst), time.Now().UTC())
+	if wait > 0 {
+		c.sendErrorCode(protocol.ErrRateLimited,
+			fmt.Sprintf("slow down: at most %d messages a minute, %d at once; try again in %s",
+				l.MessagesPerMinute, l.Burst, wait.Round(100*time.Millisecond)),
+			protocol.RateLimitPayload{
+				Type:              typ,
+				RetryAfterMS:      wait.Milliseconds() + 1, // rounded up, so it is never early
+				MessagesPerMinute: l.MessagesPerMinute,
+				Burst:             l.Burst,
+			})
+		return false
+	}
+	return true
+}
+
+// refundPost gives back the post takePost counted for c, which was refused
+// after all.
+func (s *Server) refundPost(c *Client) {
+	l := s.limitsFor(c.getRole())
+	if l.MessagesPerMinute == 0 {
+		return
+	}
+	s.store.RefundRate("post:"+c.getUserID(), float64(l.MessagesPerMinute)/60, float64(l.Burst))
+}
+
+// runRateLimits must be launched as a goroutine; it returns when s.quit is
+// closed, and Shutdown's Flush saves the counts a last time.
+func (s *Server) runRateLimits() {
+	t := time.NewTicker(rateLimitTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.quit:
+			return
+		}
+		if n := s.store.PruneRateLimits(time.Now().UTC()); n > 0 {
+			logger("limits").Debug("forgot refilled rate limits", "count", n)
+		}
+		if err := s.store.SaveRateLimits(); err != nil {
+			logger("store").Error("saving rate limits failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Account lockout
+// ---------------------------------------------------------------------------
+//
+// With a LockoutPolicy, an account is locked after Attempts wrong passwords
+// in a row.  A locked account refuses password logins, and session tokens
+// issued before it was locked too.
+// The owner can unlock it without an admin (FeatureUnlock):
+//
+//  1. TypeUnlock with the username sends a one-time code through every
+//     configured Notifier that has an address for the account;
+//  2. TypeUnlock with the username and the code unlocks it.
+//
+// The answer to the first step is the same whether or not the account
+// exists, is locked or can be reached, so it cannot be used to probe for
+// accounts.  A code is good for unlockCodeTTL and unlockTries guesses, and
+// a new one can be had after unlockResend.  Locks and unlocks go to the
+// audit log.  Accounts of an external auth provider are left to it.
+
+const (
+	unlockCodeTTL = 15 * time.Minute
+	unlockTries   = 5
+	unlockResend  = time.Minute
+)
+
+// Lockout actions.
+const (
+	ActionAccountLocked   = "account_locked"
+	ActionAccountUnlocked = "account_unlocked"
+)
+
+// LockoutPolicy configures account lockout.
+type LockoutPolicy struct {
+	Attempts int // wrong passwords in a row that lock the account
+}
+
+// Validate checks the policy's settings.
+func (p *LockoutPolicy) Validate() error {
+	if p.Attempts < 1 {
+		return fmt.Errorf("lockout: attempts must be at least 1")
+	}
+	return nil
+}
+
+// unlockCode is an outstanding unlock code.
+type unlockCode struct {
+	code    string
+	sent    time.Time
+	expires time.Time
+	tries   int
+}
+
+// unlockCodes holds the outstanding unlock codes.
+type unlockCodes struct {
+	mu     sync.Mutex
+	byUser map[string]*unlockCode // by user ID
+}
+
+// issue makes a new code for the user with the given ID, unless one was
+// sent less than unlockResend ago.
+func (u *unlockCodes) issue(userID string, now time.Time) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.byUser == nil {
+		u.byUser = make(map[string]*unlockCode)
+	}
+	for id, uc := range u.byUser {
+		if now.After(uc.expires) {
+			delete(u.byUser, id)
+		}
+	}
+	if uc, ok := u.byUser[userID]; ok && now.Sub(uc.sent) < unlockResend {
+		return "", false
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(100_000_000))
+	if err != nil {
+		return "", false
+	}
+	code := fmt.Sprintf("%08d", n)
+	u.byUser[userID] = &unlockCode{code: code, sent: now, expires: now.Add(unlockCodeTTL)}
+	return code, true
+}
+
+// redeem checks code against the user's, using up a try, and drops the
+// code once it matches or has no tries left.
+func (u *unlockCodes) redeem(userID, code string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	uc, ok := u.byUser[userID]
+	if !ok || time.Now().After(uc.expires) {
+		delete(u.byUser, userID)
+		return false
+	}
+	uc.tries++
+	match := subtle.ConstantTimeCompare([]byte(uc.code), []byte(code)) == 1
+	if match || uc.tries >= unlockTries {
+		delete(u.byUser, userID)
+	}
+	return match
+}
+
+// selfUnlock reports whether users can unlock their accounts themselves.
+func (s *Server) selfUnlock() bool {
+	return s.cfg.Lockout != nil && len(s.cfg.Notifiers) > 0
+}
+
+// loginFailed answers a failed password login, counting a wrong password
+// against the account.
+func (s *Server) loginFailed(c *Client, username string, err error) {
+	switch {
+	case errors.Is(err, store.ErrLocked) && s.selfUnlock():
+		c.sendError(err.Error() + "; ask for an unlock code")
+		return
+	case errors.Is(err, store.ErrLocked):
+		c.sendError(err.Error() + "; ask an admin to unlock it")
+		return
+	case !errors.Is(err, store.ErrIncorrectPassword) || s.cfg.Lockout == nil:
+		c.sendError(err.Error())
+		return
+	}
+	u, locked, serr := s.store.LoginFailed(username, s.cfg.Lockout.Attempts)
+	if serr != nil {
+		c.logger("store").Error("saving users failed", "err", serr)
+	}
+	if !locked {
+		c.sendError(err.Error())
+		return
+	}
+	s.events.Publish(Event{
+		Type:     EventModeration,
+		Username: protocol.ServerName,
+		Action:   ActionAccountLocked,
+		Target:   u.Username,
+		Reason:   fmt.Sprintf("%d failed logins, the last from %s", u.FailedLogins, c.remoteAddr),
+	})
+	c.logger("auth").Warn("locked an account", "target", u.Username, "failed_logins", u.FailedLogins)
+	c.sendError(fmt.Sprintf("%v; account %q %v", err, u.Username, store.ErrLocked))
+}
+
+func (s *Server) handleUnlock(c *Client, raw json.RawMessage) {
+	if c.isAuthenticated() {
+		c.sendError("you are already logged in")
+		return
+	}
+	if !s.selfUnlock() {
+		c.sendError("this server does not offer self-service unlock; ask an admin")
+		return
+	}
+	var p protocol.UnlockPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.Username == "" {
+		c.sendError("unlock requires {username} or {username, code}")
+		return
+	}
+	u := s.store.GetUser(p.Username)
+	if p.Code == "" {
+		if u != nil && u.Locked() && u.Source == "" {
+			if code, ok := s.unlocks.issue(u.ID, time.Now()); ok {
+				go s.sendUnlockCode(*u, code)
+			}
+		}
+		c.sendResponse(true, "if that account is locked and has a contact address, a code is on its way", nil)
+		return
+	}
+	if u == nil || !s.unlocks.redeem(u.ID, p.Code) {
+		c.sendError("invalid or expired unlock code")
+		return
+	}
+	if err := s.store.UnlockUser(u.ID); err != nil {
+		c.logger("store").Error("saving users failed", "err", err)
+		c.sendError("could not unlock the account")
+		return
+	}
+	s.events.Publish(Event{
+		Type:     EventModeration,
+		ConnID:   c.id,
+		UserID:   u.ID,
+		Username: u.Username,
+		Action:   ActionAccountUnlocked,
+		Target:   u.Username,
+		Reason:   "unlock code, from " + c.remoteAddr,
+	})
+	c.logger("auth").Info("unlocked with a code", "target", u.Username)
+	c.sendResponse(true, fmt.Sprintf("account %q unlocked; you can log in now", u.Username), nil)
+}
+
+// sendUnlockCode delivers code to u through every notifier that can reach
+// it.
+func (s *Server) sendUnlockCode(u store.User, code string) {
+	body := fmt.Sprintf("Your chat account %q was locked after too many failed logins.\n\n"+
+		"Unlock code: %s\n\nIt is good for %d minutes.  If you did not ask for it, someone "+
+		"else may be trying your password; consider changing it once you are back in.",
+		u.Username, code, int(unlockCodeTTL.Minutes()))
+	sent := 0
+	for _, n := range s.cfg.Notifiers {
+		err := n.Notify(&u, "Chat account unlock code", body)
+		switch {
+		case errors.Is(err, ErrNoAddress):
+		case err != nil:
+			logger("auth").Error("sending an unlock code failed", "target", u.Username, "via", n.Name(), "err", err)
+		default:
+			sent++
+		}
+	}
+	logger("auth").Info("unlock code sent", "target", u.Username, "ways", sent)
+}
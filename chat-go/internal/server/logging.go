@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log/slog"
+)
+
+// ---------------------------------------------------------------------------
+// Logging
+// ---------------------------------------------------------------------------
+//
+// The server logs through log/slog's default logger, so the program picks
+// the level and the format (cmd/server's -log-level and -log-format).  Each
+// record says which part of the server it comes from in "component", and
+// one about a connection also carries the connection's "conn" ID, "user"
+// once logged in, and remote "addr".  Errors are logged at slog.LevelError,
+// trouble the server works around, like a client falling behind, at
+// LevelWarn, what users and admins do at LevelInfo, and the routine
+// comings and goings of connections and deliveries at LevelDebug.
+
+// logger returns the logger of a part of the server, e.g. "hub".
+func logger(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// logger returns the logger for what happens on c, as part of component.
+func (c *Client) logger(component string) *slog.Logger {
+	l := logger(component).With("conn", c.id)
+	if name := c.getUsername(); name != "" {
+		l = l.With("user", name)
+	}
+	return l.With("addr", c.remoteAddr)
+}
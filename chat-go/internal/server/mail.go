@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain-text email through an SMTP relay.  net/smtp upgrades
+// the connection with STARTTLS when the relay offers it; Username, when
+// set, authenticates with PLAIN, which net/smtp only allows over TLS or to
+// localhost.
+type Mailer struct {
+	Addr     string // host:port of the relay
+	From     string
+	Username string
+	Password string
+}
+
+// Send mails subject and body to the address to.
+func (m *Mailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("mail: header value contains a newline")
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	return nil
+}
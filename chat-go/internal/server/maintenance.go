@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Read-only maintenance mode
+// ---------------------------------------------------------------------------
+//
+// While maintenance is on, clients stay connected and can read (history,
+// search, users) but packets that change state are refused, and scheduled
+// messages are held until it ends.  Useful during migrations and backups.
+//
+// Admins can also schedule a window ahead of time.  The server announces
+// it when it is scheduled and again maintReminders before it starts, turns
+// read-only on at the start and off at the end, if it has one.  Turning
+// maintenance off by hand ends a window early or cancels one that has not
+// started.  There is one window at a time: scheduling another, or turning
+// read-only on by hand, replaces it.  Windows are not kept across
+// restarts.
+
+const defaultMaintenanceReason = "maintenance in progress"
+
+// maintReminders are how long before a window starts it is announced.
+var maintReminders = []time.Duration{24 * time.Hour, time.Hour, 15 * time.Minute, 5 * time.Minute, time.Minute}
+
+// Maintenance window actions.
+const (
+	ActionMaintenanceScheduled = "maintenance_scheduled"
+	ActionMaintenanceCancelled = "maintenance_cancelled"
+)
+
+// maintenance is the read-only switch.
+type maintenance struct {
+	mu     sync.RWMutex
+	on     bool
+	reason string
+	window *maintWindow // scheduled or under way
+}
+
+// maintWindow is a scheduled maintenance window.
+type maintWindow struct {
+	protocol.MaintenanceWindow
+	by     Event         // who scheduled it, for the moderation log
+	cancel chan struct{} // closed when it is replaced or cancelled
+}
+
+func (m *maintenance) set(on bool, reason string) {
+	if reason == "" {
+		reason = defaultMaintenanceReason
+	}
+	m.mu.Lock()
+	m.on, m.reason = on, reason
+	m.mu.Unlock()
+}
+
+// get returns the reason, or "" when the server is writable.
+func (m *maintenance) get() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.on {
+		return ""
+	}
+	return m.reason
+}
+
+// schedule makes w the maintenance window, replacing any other.
+func (m *maintenance) schedule(w *maintWindow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.window != nil {
+		close(m.window.cancel)
+	}
+	m.window = w
+}
+
+// cancelWindow drops the maintenance window and returns it, or nil.
+func (m *maintenance) cancelWindow() *maintWindow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.window
+	if w != nil {
+		close(w.cancel)
+		m.window = nil
+	}
+	return w
+}
+
+// begin turns read-only on for w, unless w is no longer the window.
+func (m *maintenance) begin(w *maintWindow) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.window != w {
+		return false
+	}
+	m.on, m.reason = true, w.Reason
+	return true
+}
+
+// end turns read-only off and drops w, unless w is no longer the window.
+func (m *maintenance) end(w *maintWindow) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.window != w {
+		return false
+	}
+	m.on, m.window = false, nil
+	return true
+}
+
+// upcoming returns the maintenance window, or nil.
+func (m *maintenance) upcoming() *protocol.MaintenanceWindow {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.window == nil {
+		return nil
+	}
+	w := m.window.MaintenanceWindow
+	return &w
+}
+
+// refuseWrite tells c the server is read-only and returns true while
+// maintenance is on.  Handlers that change state call it first.
+func (s *Server) refuseWrite(c *Client) bool {
+	reason := s.maint.get()
+	if reason == "" {
+		return false
+	}
+	c.sendError("maintenance in progress: " + reason)
+	return true
+}
+
+func (s *Server) handleMaintenance(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("maintenance mode requires the admin role")
+		return
+	}
+	var p protocol.MaintenancePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("maintenance requires {enabled, reason}")
+		return
+	}
+	if s.isStandby() {
+		c.sendError("this server is a standby and stays read-only until it is promoted")
+		return
+	}
+	p.Reason = sanitizeLine(p.Reason)
+	now := time.Now()
+	if !p.Enabled {
+		w := s.maint.cancelWindow()
+		if w != nil && w.Start.After(now) {
+			s.events.Publish(moderationEvent(c, ActionMaintenanceCancelled, "", w.Reason))
+			c.logger("server").Info("cancelled the maintenance window", "start", w.Start)
+			s.broadcastSystem(protocol.SystemMaintenance, "🔧 the maintenance scheduled for "+describeWindow(w.MaintenanceWindow)+" is cancelled")
+			c.sendResponse(true, "maintenance window cancelled", nil)
+			return
+		}
+		s.maint.set(false, "")
+		s.events.Publish(moderationEvent(c, ActionMaintenanceOff, "", ""))
+		c.logger("server").Info("disabled read-only mode")
+		s.broadcastSystem(protocol.SystemMaintenance, "🔧 maintenance finished; chat is open again")
+		c.sendResponse(true, "read-only mode off", nil)
+		return
+	}
+	if !p.End.IsZero() && (!p.End.After(now) || !p.End.After(p.Start)) {
+		c.sendError("a maintenance window must end after it starts, and in the future")
+		return
+	}
+	if p.Start.After(now) || !p.End.IsZero() {
+		s.scheduleMaintenance(c, p)
+		return
+	}
+	s.maint.cancelWindow()
+	s.maint.set(true, p.Reason)
+	s.events.Publish(moderationEvent(c, ActionMaintenanceOn, "", s.maint.get()))
+	c.logger("server").Info("enabled read-only mode", "reason", s.maint.get())
+	s.broadcastSystem(protocol.SystemMaintenance, "🔧 the server is now read-only: "+s.maint.get())
+	c.sendResponse(true, "read-only mode on", nil)
+}
+
+// scheduleMaintenance sets up the window p describes, which starts now if
+// p.Start is not in the future.
+func (s *Server) scheduleMaintenance(c *Client, p protocol.MaintenancePayload) {
+	if p.Reason == "" {
+		p.Reason = defaultMaintenanceReason
+	}
+	w := &maintWindow{
+		MaintenanceWindow: protocol.MaintenanceWindow{Start: p.Start.UTC(), End: p.End.UTC(), Reason: p.Reason},
+		by:                moderationEvent(c, ActionMaintenanceScheduled, "", ""),
+		cancel:            make(chan struct{}),
+	}
+	if now := time.Now(); !w.Start.After(now) {
+		w.Start = now.UTC()
+	}
+	w.by.Reason = describeWindow(w.MaintenanceWindow) + ": " + w.Reason
+	s.maint.schedule(w)
+	s.events.Publish(w.by)
+	c.logger("server").Info("scheduled maintenance", "window", w.by.Reason)
+	if w.Start.After(time.Now()) {
+		s.broadcast(systemNotice(protocol.SystemPayload{
+			Kind:    protocol.SystemMaintenance,
+			Message: "🔧 maintenance is scheduled for " + w.by.Reason + "; the server will be read-only",
+			At:      w.Start,
+		}))
+	}
+	go s.runMaintenance(w)
+	c.sendResponse(true, "maintenance window scheduled", w.MaintenanceWindow)
+}
+
+// runMaintenance announces w as it approaches, then starts and ends it.
+// It returns early when w is replaced or cancelled, or the server stops.
+func (s *Server) runMaintenance(w *maintWindow) {
+	wait := func(at time.Time) bool {
+		t := time.NewTimer(time.Until(at))
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return true
+		case <-w.cancel:
+		case <-s.quit:
+		}
+		return false
+	}
+	for _, lead := range maintReminders {
+		at := w.Start.Add(-lead)
+		if !at.After(time.Now()) {
+			continue
+		}
+		if !wait(at) {
+			return
+		}
+		s.broadcast(systemNotice(protocol.SystemPayload{
+			Kind:    protocol.SystemMaintenance,
+			Message: fmt.Sprintf("🔧 maintenance starts in %s: %s", shortDuration(lead), w.Reason),
+			At:      w.Start,
+		}))
+	}
+	if !wait(w.Start) || !s.maint.begin(w) {
+		return
+	}
+	e := w.by
+	e.At, e.Action, e.Reason = time.Time{}, ActionMaintenanceOn, w.Reason
+	s.events.Publish(e)
+	logger("server").Info("scheduled maintenance started", "reason", w.Reason)
+	notice := "🔧 the server is now read-only: " + w.Reason
+	if !w.End.IsZero() {
+		notice += " (until " + w.End.Format("15:04 MST") + ")"
+	}
+	s.broadcastSystem(protocol.SystemMaintenance, notice)
+
+	if w.End.IsZero() || !wait(w.End) || !s.maint.end(w) {
+		return
+	}
+	e.At, e.Action, e.Reason = time.Time{}, ActionMaintenanceOff, ""
+	s.events.Publish(e)
+	logger("server").Info("scheduled maintenance finished")
+	s.broadcastSystem(protocol.SystemMaintenance, "🔧 maintenance finished; chat is open again")
+}
+
+// describeWindow renders w's times for notices and the moderation log.
+func describeWindow(w protocol.MaintenanceWindow) string {
+	const layout = "2006-01-02 15:04 MST"
+	if w.End.IsZero() {
+		return w.Start.Format(layout)
+	}
+	if w.End.Sub(w.Start) < 24*time.Hour && w.End.YearDay() == w.Start.YearDay() {
+		return w.Start.Format(layout) + "–" + w.End.Format("15:04")
+	}
+	return w.Start.Format(layout) + " to " + w.End.Format(layout)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Mentions
+// ---------------------------------------------------------------------------
+//
+// Every posted message is scanned for @username, and each user it names
+// who can read the conversation, has not muted it and is not its author
+// gets a TypeMention packet besides the message itself, so clients need
+// not guess from the text what counts as a mention.  A name runs to the
+// first character that cannot be in one; a full stop ending a sentence is
+// not part of it.  At most maxMentions users are told per message.
+
+const maxMentions = 20
+
+// mentionEvent is the "mentions" subscriber to EventMessage.
+func (s *Server) mentionEvent(e Event) {
+	msg := e.Message
+	var to map[string]bool
+	for _, name := range mentionedNames(msg.Content) {
+		u := s.store.GetUser(name)
+		if u == nil || u.ID == msg.UserID || !s.mayRead(u.ID, msg.Channel) || s.store.Muted(u.ID, msg.Channel) {
+			continue
+		}
+		if to == nil {
+			to = make(map[string]bool)
+		}
+		to[u.ID] = true
+		if len(to) == maxMentions {
+			break
+		}
+	}
+	if to == nil {
+		return
+	}
+	pkt, _ := protocol.NewPacket(protocol.TypeMention, protocol.MentionPayload{
+		ID:        msg.ID,
+		Channel:   msg.Channel,
+		From:      msg.Username,
+		Excerpt:   quoteOf(msg).Excerpt,
+		Timestamp: s.stamps.coarse(msg.ID, msg.Timestamp),
+	})
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, c := range s.sessions {
+		if to[c.getUserID()] {
+			c.sendPacket(pkt)
+		}
+	}
+}
+
+// mentionedNames returns the names after each @ in content, in order.
+func mentionedNames(content string) []string {
+	var names []string
+	for rest := content; ; {
+		i := strings.IndexByte(rest, '@')
+		if i < 0 {
+			return names
+		}
+		rest = rest[i+1:]
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.'
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		if name := strings.TrimRight(rest[:end], "."); name != "" {
+			names = append(names, name)
+		}
+		rest = rest[end:]
+	}
+}
+
+// mayRead reports whether the user with the given ID can read channel.
+func (s *Server) mayRead(userID, channel string) bool {
+	switch {
+	case protocol.IsDirect(channel):
+		a, b, _ := protocol.DirectMembers(channel)
+		return userID == a || userID == b
+	case protocol.IsPublic(channel):
+		return s.store.InChannel(channel, userID)
+	}
+	return true
+}
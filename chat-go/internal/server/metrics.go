@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Metrics
+// ---------------------------------------------------------------------------
+//
+// GET /metrics on the HTTP sidecar serves counters in the Prometheus text
+// exposition format.  Per-connection detail is in /admin/usage instead, to
+// keep label cardinality bounded.  The only labels are the store's
+// operations, a fixed list.
+
+// metric is one exported sample.
+type metric struct {
+	name, help, kind string // kind: counter or gauge
+	value            func() uint64
+}
+
+func (s *Server) metrics() []metric {
+	ms := []metric{
+		{"chat_connections", "Open TCP connections.", "gauge",
+			func() uint64 { return uint64(s.openConns.Load()) }},
+		{"chat_bytes_received_total", "Bytes read from clients.", "counter", s.traffic.bytesIn.Load},
+		{"chat_bytes_sent_total", "Bytes written to clients.", "counter", s.traffic.bytesOut.Load},
+		{"chat_packets_received_total", "Packets read from clients.", "counter", s.traffic.packetsIn.Load},
+		{"chat_packets_sent_total", "Packets written to clients.", "counter", s.traffic.packetsOut.Load},
+		{"chat_connections_rejected_total", "Connections refused by the access policy.", "counter", s.rejects.count.Load},
+		{"chat_hub_clients_dropped_total", "Clients disconnected for falling behind.", "counter", s.hub.stats.dropped.Load},
+		{"chat_hub_drops_buffer_full_total", "Clients disconnected for a send buffer still full after the grace period.", "counter", s.hub.drops.bufferFull.Load},
+		{"chat_hub_drops_spill_failed_total", "Clients disconnected for a spill file that could not be written.", "counter", s.hub.drops.spillError.Load},
+		{"chat_hub_slow_clients", "Clients in their grace period to catch up.", "gauge",
+			func() uint64 { return uint64(s.hub.drops.slow.Load()) }},
+		{"chat_hub_slow_recovered_total", "Clients that caught up within the grace period.", "counter", s.hub.drops.recovered.Load},
+		{"chat_hub_packets_skipped_total", "Broadcasts skipped for clients that fell behind.", "counter", s.hub.stats.skipped.Load},
+		{"chat_hub_packets_spilled_total", "Broadcasts spilled to disk for clients that fell behind.", "counter", s.hub.stats.spilled.Load},
+		{"chat_hub_broadcast_queue", "Broadcasts waiting for the hub.", "gauge",
+			func() uint64 { return uint64(len(s.hub.broadcast)) }},
+		{"chat_hub_control_queue", "Control packets waiting for the hub.", "gauge",
+			func() uint64 { return uint64(len(s.hub.control)) }},
+		{"chat_client_send_queue_max", "Packets in the fullest client send buffer.", "gauge",
+			func() uint64 { return uint64(s.hub.sendMax.Load()) }},
+		{"chat_client_send_queue_total", "Packets in all client send buffers.", "gauge",
+			func() uint64 { return uint64(s.hub.sendTotal.Load()) }},
+		{"chat_persist_queue", "Messages waiting to be written to the store.", "gauge",
+			func() uint64 { return uint64(s.pool.pending.Load()) }},
+		{"chat_persist_workers", "Persistence workers running.", "gauge",
+			func() uint64 { return uint64(s.pool.size.Load()) }},
+		{"chat_persist_save_microseconds", "Mean time of a persistence save over the last second.", "gauge",
+			func() uint64 { return uint64(s.pool.latency.Load() / 1000) }},
+		{"chat_persist_resizes_total", "Times the persistence pool grew or shrank.", "counter", s.pool.resizes.Load},
+		{"chat_messages_posted_total", "Chat messages posted.", "counter", s.eventCounts.messages.Load},
+		{"chat_joins_total", "Sessions that logged in.", "counter", s.eventCounts.joins.Load},
+		{"chat_leaves_total", "Logged-in sessions that ended.", "counter", s.eventCounts.leaves.Load},
+		{"chat_moderation_actions_total", "Actions taken by moderators and admins.", "counter", s.eventCounts.moderation.Load},
+		{"chat_events_published_total", "Events published on the internal bus.", "counter", s.events.published.Load},
+		{"chat_event_subscriber_dropped_total", "Events dropped for queued subscribers that fell behind.", "counter", s.events.dropped.Load},
+	}
+	return append(ms, s.storeMetrics()...)
+}
+
+// storeMetrics are the store's operation timings, one sample per operation.
+func (s *Server) storeMetrics() []metric {
+	families := []struct {
+		name, help, kind string
+		value            func(store.OpStat) uint64
+	}{
+		{"chat_store_operations_total", "Store operations run, by operation.", "counter",
+			func(o store.OpStat) uint64 { return o.Calls }},
+		{"chat_store_operation_microseconds_total", "Time spent in store operations, by operation.", "counter",
+			func(o store.OpStat) uint64 { return uint64(o.Total.Microseconds()) }},
+		{"chat_store_operation_max_microseconds", "Longest store operation since the start, by operation.", "gauge",
+			func(o store.OpStat) uint64 { return uint64(o.Max.Microseconds()) }},
+		{"chat_store_slow_operations_total", "Store operations slower than -slow-query, by operation.", "counter",
+			func(o store.OpStat) uint64 { return o.Slow }},
+	}
+	var ms []metric
+	for _, f := range families {
+		for _, op := range store.Ops {
+			ms = append(ms, metric{fmt.Sprintf("%s{op=%q}", f.name, op), f.help, f.kind,
+				func() uint64 { return f.value(s.store.OpStat(op)) }})
+		}
+	}
+	return ms
+}
+
+func (s *Server) httpMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, s.metrics())
+}
+
+func writeMetrics(w io.Writer, ms []metric) {
+	last := ""
+	for _, m := range ms {
+		family, _, _ := strings.Cut(m.name, "{") // labelled samples share one HELP and TYPE
+		if family != last {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family, m.help, family, m.kind)
+			last = family
+		}
+		fmt.Fprintf(w, "%s %d\n", m.name, m.value())
+	}
+}
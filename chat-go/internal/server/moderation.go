@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Moderation
+// ---------------------------------------------------------------------------
+//
+// Moderators can kick a user (TypeKick), which closes every session of
+// theirs but lets them log in again, and mute one (MutePayload.User), which
+// keeps them from posting, for a while or until they are unmuted.  Admins
+// can also ban a user (TypeBan): the account is disconnected and cannot
+// log in until the ban is lifted.  Bans and mutes are kept with the
+// account (see the store's moderation.go), so they outlast a restart.
+//
+// Nobody can act on a user of their own role or above, so moderators
+// cannot silence each other and nobody can lock out the admins.  The
+// affected user is always told who acted and why, and every action goes
+// to the moderation log.  Config.Admins names accounts made admins at
+// startup, so a new server has someone to hand out roles.
+
+// Moderation actions.
+const (
+	ActionKick   = "kick"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+	ActionMute   = "mute"
+	ActionUnmute = "unmute"
+)
+
+// maxModReason bounds the reason given for a moderation action.
+const maxModReason = 200
+
+// modTarget checks that c, with at least the role min, may act on the user
+// named username with verb, and returns that user.  It sends c the error
+// when not.
+func (s *Server) modTarget(c *Client, verb, username, min string) (*store.User, bool) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return nil, false
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(min) {
+		c.sendError(fmt.Sprintf("%s requires the %s role", verb, min))
+		return nil, false
+	}
+	u := s.store.GetUser(strings.TrimPrefix(username, "@"))
+	if u == nil {
+		c.sendError(fmt.Sprintf("user %q not found", username))
+		return nil, false
+	}
+	if store.RoleRank(u.Role) >= store.RoleRank(c.getRole()) {
+		c.sendError(fmt.Sprintf("you cannot %s %s: they have the %s role", verb, u.Username, u.Role))
+		return nil, false
+	}
+	return u, true
+}
+
+// modReason cleans the reason for an action, or explains why it cannot
+// be used.
+func modReason(reason string) (string, error) {
+	reason = strings.TrimSpace(sanitizeLine(reason))
+	if utf8.RuneCountInString(reason) > maxModReason {
+		return "", fmt.Errorf("reason too long (max %d characters)", maxModReason)
+	}
+	return reason, nil
+}
+
+// withReason appends ": reason" to what, when there is a reason.
+func withReason(what, reason string) string {
+	if reason == "" {
+		return what
+	}
+	return what + ": " + reason
+}
+
+// disconnectUser closes every session of the user with the given ID, telling
+// it notice, and returns how many it closed.
+func (s *Server) disconnectUser(userID, notice string) int {
+	s.onlineMu.RLock()
+	var victims []*Client
+	for _, sc := range s.sessions {
+		if sc.getUserID() == userID {
+			victims = append(victims, sc)
+		}
+	}
+	s.onlineMu.RUnlock()
+	for _, c := range victims {
+		c.disconnect(notice)
+	}
+	return len(victims)
+}
+
+func (s *Server) handleKick(c *Client, raw json.RawMessage) {
+	var p protocol.KickPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.User == "" {
+		c.sendError("kick requires {user[, reason]}")
+		return
+	}
+	reason, err := modReason(p.Reason)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	u, ok := s.modTarget(c, "kick", p.User, store.RoleModerator)
+	if !ok {
+		return
+	}
+	n := s.disconnectUser(u.ID, withReason("You were kicked by "+c.getUsername(), reason)+".")
+	if n == 0 {
+		c.sendError(u.Username + " is not online")
+		return
+	}
+	s.events.Publish(moderationEvent(c, ActionKick, u.Username, reason))
+	c.logger("server").Info("kicked", "target", u.Username, "sessions", n)
+	c.sendResponse(true, fmt.Sprintf("kicked %s", u.Username), nil)
+}
+
+func (s *Server) handleBan(c *Client, raw json.RawMessage) {
+	var p protocol.BanPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.User == "" {
+		c.sendError("ban requires {user[, reason, unban]}")
+		return
+	}
+	reason, err := modReason(p.Reason)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	verb := "ban"
+	if p.Unban {
+		verb = "unban"
+	}
+	u, ok := s.modTarget(c, verb, p.User, store.RoleAdmin)
+	if !ok || s.refuseWrite(c) {
+		return
+	}
+	if p.Unban {
+		if !u.Banned() {
+			c.sendError(u.Username + " is not banned")
+			return
+		}
+		if err := s.store.UnbanUser(u.ID); err != nil {
+			c.logger("store").Error("saving users failed", "err", err)
+			c.sendError("could not save the change")
+			return
+		}
+		s.events.Publish(moderationEvent(c, ActionUnban, u.Username, reason))
+		c.logger("server").Info("unbanned", "target", u.Username)
+		c.sendResponse(true, fmt.Sprintf("%s may log in again", u.Username), nil)
+		return
+	}
+	if err := s.store.BanUser(u.ID, reason); err != nil {
+		c.logger("store").Error("saving users failed", "err", err)
+		c.sendError("could not save the ban")
+		return
+	}
+	s.disconnectUser(u.ID, withReason("You were banned by "+c.getUsername(), reason)+".")
+	s.events.Publish(moderationEvent(c, ActionBan, u.Username, reason))
+	c.logger("server").Info("banned", "target", u.Username)
+	c.sendResponse(true, fmt.Sprintf("banned %s", u.Username), nil)
+}
+
+// banned is why u may not log in, or "".
+func banned(u *store.User) string {
+	if !u.Banned() {
+		return ""
+	}
+	return withReason(fmt.Sprintf("account %q is banned", u.Username), u.BanReason)
+}
+
+// muteUser is a moderator's TypeMute with User set.
+func (s *Server) muteUser(c *Client, p protocol.MutePayload) {
+	reason, err := modReason(p.Reason)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	verb := "mute"
+	if !p.Mute {
+		verb = "unmute"
+	}
+	u, ok := s.modTarget(c, verb, p.User, store.RoleModerator)
+	if !ok || s.refuseWrite(c) {
+		return
+	}
+
+	var until time.Time
+	if p.Until != nil {
+		until = p.Until.UTC()
+	}
+	var notice, done string
+	if p.Mute {
+		if p.Until != nil && !until.After(time.Now()) {
+			c.sendError("until must be in the future")
+			return
+		}
+		err = s.store.MuteUser(u.ID, until, reason)
+		notice, done = "You were muted by "+c.getUsername(), "muted "+u.Username
+		if !until.IsZero() {
+			notice += " until " + until.Format(time.RFC1123)
+			done += " until " + until.Format(time.RFC1123)
+		}
+		notice = withReason(notice, reason)
+	} else {
+		if muted, _, _ := s.store.MuteOf(u.ID); !muted {
+			c.sendError(u.Username + " is not muted")
+			return
+		}
+		err = s.store.UnmuteUser(u.ID)
+		notice, done = c.getUsername()+" lifted your mute", "unmuted "+u.Username
+	}
+	if err != nil {
+		c.logger("store").Error("saving users failed", "err", err)
+		c.sendError("could not save the change")
+		return
+	}
+
+	pkt := systemNotice(protocol.SystemPayload{Kind: protocol.SystemModeration, Message: notice + ".", User: c.getUsername()})
+	s.onlineMu.RLock()
+	for _, sc := range s.sessions {
+		if sc.getUserID() == u.ID {
+			sc.sendPacket(pkt)
+		}
+	}
+	s.onlineMu.RUnlock()
+
+	action := ActionMute
+	if !p.Mute {
+		action = ActionUnmute
+	}
+	detail := reason
+	if !until.IsZero() {
+		detail = withReason("until "+until.Format(time.RFC3339), reason)
+	}
+	s.events.Publish(moderationEvent(c, action, u.Username, detail))
+	c.logger("server").Info(action, "target", u.Username, "detail", detail)
+	c.sendResponse(true, done, nil)
+}
+
+// refuseMuted tells c it may not post when its user is muted, and reports
+// whether it did.
+func (s *Server) refuseMuted(c *Client) bool {
+	muted, until, reason := s.store.MuteOf(c.getUserID())
+	if !muted {
+		return false
+	}
+	what := "you are muted"
+	if !until.IsZero() {
+		what += " until " + until.Format(time.RFC1123)
+	}
+	c.sendError(withReason(what, reason))
+	return true
+}
+
+// promoteAdmins gives the accounts in Config.Admins the admin role.
+func (s *Server) promoteAdmins() {
+	for _, name := range s.cfg.Admins {
+		u := s.store.GetUser(name)
+		if u == nil || u.Role == store.RoleAdmin {
+			continue
+		}
+		if u.Source != "" {
+			logger("server").Warn("-admin ignored: the account's role comes from its login backend", "user", u.Username, "backend", u.Source)
+			continue
+		}
+		if err := s.store.SetRole(u.Username, store.RoleAdmin); err != nil {
+			logger("store").Error("saving users failed", "err", err)
+			continue
+		}
+		logger("server").Info("made an admin", "user", u.Username)
+	}
+}
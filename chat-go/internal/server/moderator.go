@@ -0,0 +1,409 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Content moderation
+// ---------------------------------------------------------------------------
+//
+// With Config.Moderator every message with text posted in a channel is
+// judged before it goes out, e.g. scored for spam and
+// toxicity by an outside service.  The Moderator's Verdict decides: allow
+// posts it; flag posts it but tells the moderators online and writes it to
+// the moderation log, like the word filter; hold keeps it back for a
+// moderator to post or drop (TypeHeld, TypeRelease); reject refuses it,
+// telling the author why.  Direct messages are never sent out to be judged.
+//
+// The Moderator is called off the connection's read loop, so a slow one
+// holds up only the messages it judges, and for at most
+// Config.ModerationTimeout.  A connection's messages still go out in the
+// order they were sent: each waits for the one before.  When the Moderator
+// fails or times out the message is posted as if allowed, or with
+// Config.ModerationFailClosed refused.
+//
+// Held messages are kept in memory, up to maxHeld, and lost on a restart.
+// A scheduled message is judged when it is scheduled, and one that was
+// held goes out when it is released.
+//
+// HTTPModerator is a Moderator that asks an HTTP service for scores and
+// turns them into a Verdict with thresholds.
+
+const (
+	defaultModerationTimeout = 5 * time.Second
+	maxHeld                  = 1000
+)
+
+// Verdict actions.
+const (
+	ModAllow  = "allow"
+	ModFlag   = "flag"
+	ModHold   = "hold"
+	ModReject = "reject"
+)
+
+// Moderation log actions for held messages.  Flagged ones are logged as
+// ActionFlagged, like the word filter's.
+const (
+	ActionHeld     = "held"
+	ActionReleased = "released"
+	ActionDropped  = "dropped"
+)
+
+// Moderator judges a chat message before it is posted.
+type Moderator interface {
+	// Moderate returns what to do with msg.  It must give up when ctx is
+	// done.
+	Moderate(ctx context.Context, msg *protocol.StoredMessage) (Verdict, error)
+}
+
+// Verdict is a Moderator's judgement of a message.  Reason is shown to the
+// author of a rejected message and to the moderators; Scores, e.g.
+// "spam": 0.9, only to the moderators.
+type Verdict struct {
+	Action string // ModAllow, ModFlag, ModHold or ModReject; "" is ModAllow
+	Reason string
+	Scores map[string]float64
+}
+
+// heldMessages are the messages held for review, oldest first.
+type heldMessages struct {
+	mu   sync.Mutex
+	msgs []protocol.HeldMessage
+}
+
+// add holds h, reporting false when too many are held already.
+func (q *heldMessages) add(h protocol.HeldMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.msgs) >= maxHeld {
+		return false
+	}
+	q.msgs = append(q.msgs, h)
+	return true
+}
+
+// take removes and returns the held message id.
+func (q *heldMessages) take(id string) (protocol.HeldMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.IndexFunc(q.msgs, func(h protocol.HeldMessage) bool { return h.Message.ID == id })
+	if i < 0 {
+		return protocol.HeldMessage{}, false
+	}
+	h := q.msgs[i]
+	q.msgs = slices.Delete(q.msgs, i, i+1)
+	return h, true
+}
+
+func (q *heldMessages) list() []protocol.HeldMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Clone(q.msgs)
+}
+
+func (s *Server) moderationTimeout() time.Duration {
+	if s.cfg.ModerationTimeout > 0 {
+		return s.cfg.ModerationTimeout
+	}
+	return defaultModerationTimeout
+}
+
+// moderate has the Moderator judge msg, which c is posting, and calls post
+// when it may go out.  Without a Moderator post is called at once;
+// otherwise on a goroutine of its own, after the post of c's message
+// before.  It runs on c's readPump.
+func (s *Server) moderate(c *Client, msg *protocol.StoredMessage, post func()) {
+	m := s.cfg.Moderator
+	if m == nil {
+		post()
+		return
+	}
+	prev, done := c.moderated, make(chan struct{})
+	c.moderated = done
+	go func() {
+		defer close(done)
+		v := Verdict{Action: ModAllow}
+		var err error
+		if msg.Content != "" && !protocol.IsDirect(msg.Channel) {
+			ctx, cancel := context.WithTimeout(context.Background(), s.moderationTimeout())
+			v, err = m.Moderate(ctx, msg)
+			cancel()
+		}
+		if prev != nil {
+			<-prev
+		}
+		switch {
+		case err != nil && s.cfg.ModerationFailClosed:
+			c.logger("moderation").Error("moderation failed, refusing", "msg_id", msg.ID, "err", err)
+			s.refundPost(c)
+			s.ifOnline(c, func() { c.sendError("message not sent: it could not be checked; try again later") })
+		case err != nil:
+			c.logger("moderation").Warn("moderation failed, posting", "msg_id", msg.ID, "err", err)
+			post()
+		case v.Action == ModReject:
+			c.logger("moderation").Info("rejected", "msg_id", msg.ID, "channel", msg.Channel, "reason", v.Reason)
+			why := "message not sent"
+			if v.Reason != "" {
+				why += ": " + v.Reason
+			}
+			s.refundPost(c)
+			s.ifOnline(c, func() { c.sendError(why) })
+		case v.Action == ModHold:
+			s.holdMessage(c, msg, v)
+		case v.Action == ModFlag:
+			post()
+			s.flagModerated(msg, v)
+		default:
+			post()
+		}
+	}()
+}
+
+// ifOnline calls fn if c is still logged in, with the session list locked.
+func (s *Server) ifOnline(c *Client, fn func()) {
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	if s.sessions[c.id] == c {
+		fn()
+	}
+}
+
+// holdMessage keeps msg back for review, telling c and the moderators.
+func (s *Server) holdMessage(c *Client, msg *protocol.StoredMessage, v Verdict) {
+	h := protocol.HeldMessage{Message: *msg, Reason: v.Reason, Scores: v.Scores, HeldAt: time.Now().UTC()}
+	if !s.held.add(h) {
+		c.logger("moderation").Warn("too many held messages, refusing", "msg_id", msg.ID)
+		s.refundPost(c)
+		s.ifOnline(c, func() { c.sendError("message not sent: it needs a moderator's review, and too many are waiting") })
+		return
+	}
+	s.ifOnline(c, func() { c.sendError("message held: a moderator will review it before it is posted") })
+	what := fmt.Sprintf("message %s in %s: %s", msg.ID, channelName(msg.Channel), verdictSummary(v))
+	s.events.Publish(Event{
+		Type:     EventModeration,
+		Username: protocol.ServerName,
+		Action:   ActionHeld,
+		Target:   msg.Username,
+		Reason:   what,
+	})
+	c.logger("moderation").Info("held", "msg_id", msg.ID, "channel", msg.Channel, "reason", v.Reason, "scores", v.Scores)
+	s.sendModerators(systemNotice(protocol.SystemPayload{
+		Kind:    protocol.SystemModeration,
+		Message: fmt.Sprintf("Held for review: %s's %s — /held to see it.", msg.Username, what),
+		User:    msg.Username,
+		Channel: msg.Channel,
+	}))
+}
+
+// flagModerated tells the moderators online that the Moderator flagged
+// msg, and logs it.
+func (s *Server) flagModerated(msg *protocol.StoredMessage, v Verdict) {
+	what := fmt.Sprintf("message %s in %s: %s", msg.ID, channelName(msg.Channel), verdictSummary(v))
+	s.events.Publish(Event{
+		Type:     EventModeration,
+		Username: protocol.ServerName,
+		Action:   ActionFlagged,
+		Target:   msg.Username,
+		Reason:   what,
+	})
+	logger("moderation").Info("flagged", "target", msg.Username, "msg_id", msg.ID, "channel", msg.Channel, "reason", v.Reason, "scores", v.Scores)
+	s.sendModerators(systemNotice(protocol.SystemPayload{
+		Kind:    protocol.SystemModeration,
+		Message: fmt.Sprintf("Flagged: %s's %s.", msg.Username, what),
+		User:    msg.Username,
+		Channel: msg.Channel,
+	}))
+}
+
+// verdictSummary puts v's reason and scores in a few words.
+func verdictSummary(v Verdict) string {
+	var parts []string
+	if v.Reason != "" {
+		parts = append(parts, v.Reason)
+	}
+	for _, k := range slices.Sorted(maps.Keys(v.Scores)) {
+		parts = append(parts, fmt.Sprintf("%s %.2f", k, v.Scores[k]))
+	}
+	if len(parts) == 0 {
+		return "no reason given"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sendModerators sends pkt to the sessions of moderators and admins.
+func (s *Server) sendModerators(pkt *protocol.Packet) {
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, sc := range s.sessions {
+		if store.RoleRank(sc.getRole()) >= store.RoleRank(store.RoleModerator) {
+			sc.sendPacket(pkt)
+		}
+	}
+}
+
+func (s *Server) handleHeld(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleModerator) {
+		c.sendError("held messages require the moderator role")
+		return
+	}
+	held := s.held.list()
+	c.sendResponse(true, fmt.Sprintf("%d message(s) held", len(held)), held)
+}
+
+func (s *Server) handleRelease(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleModerator) {
+		c.sendError("releasing held messages requires the moderator role")
+		return
+	}
+	var p protocol.ReleasePayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.ID == "" {
+		c.sendError("release requires {id[, drop]}")
+		return
+	}
+	if !p.Drop && s.refuseWrite(c) {
+		return
+	}
+	h, ok := s.held.take(p.ID)
+	if !ok {
+		c.sendError(fmt.Sprintf("no held message %q", p.ID))
+		return
+	}
+	msg := h.Message
+	if p.Drop {
+		s.events.Publish(moderationEvent(c, ActionDropped, msg.Username, "message "+msg.ID))
+		c.logger("moderation").Info("dropped a held message", "msg_id", msg.ID, "target", msg.Username)
+		c.sendResponse(true, "dropped message "+msg.ID, nil)
+		return
+	}
+	msg.Timestamp = time.Now().UTC()
+	s.post(&msg)
+	s.events.Publish(moderationEvent(c, ActionReleased, msg.Username, "message "+msg.ID))
+	c.logger("moderation").Info("released a held message", "msg_id", msg.ID, "target", msg.Username)
+	c.sendResponse(true, "posted message "+msg.ID, nil)
+}
+
+// ---------------------------------------------------------------------------
+// HTTP moderation service
+// ---------------------------------------------------------------------------
+
+// HTTPModerator is a Moderator that POSTs each message, as a
+// ModerationRequest, to a scoring service, which answers with a
+// ModerationResponse.  An Action in the answer is taken as it is;
+// otherwise the highest score decides, against the thresholds: at RejectAt
+// or over the message is rejected, at HoldAt held, at FlagAt flagged.  A
+// zero threshold is not used.  With Secret set the body is signed in the
+// X-Chat-Signature-256 header, as for AuthHooks.
+type HTTPModerator struct {
+	URL    string
+	Secret string
+	Client *http.Client
+
+	FlagAt, HoldAt, RejectAt float64
+}
+
+// ModerationRequest is the body HTTPModerator POSTs.
+type ModerationRequest struct {
+	ID       string `json:"id"`
+	Channel  string `json:"channel,omitempty"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+}
+
+// ModerationResponse is the scoring service's answer.  Scores are between
+// 0 and 1, e.g. "spam": 0.93, "toxicity": 0.02.
+type ModerationResponse struct {
+	Scores map[string]float64 `json:"scores"`
+	Action string             `json:"action,omitempty"` // ModAllow, ModFlag, ModHold or ModReject
+	Reason string             `json:"reason,omitempty"`
+}
+
+func (h *HTTPModerator) Moderate(ctx context.Context, msg *protocol.StoredMessage) (Verdict, error) {
+	body, _ := json.Marshal(ModerationRequest{
+		ID:       msg.ID,
+		Channel:  msg.Channel,
+		UserID:   msg.UserID,
+		Username: msg.Username,
+		Content:  msg.Content,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, fmt.Errorf("moderation: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Chat-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("moderation: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return Verdict{}, fmt.Errorf("moderation: %s", resp.Status)
+	}
+	var r ModerationResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&r); err != nil {
+		return Verdict{}, fmt.Errorf("moderation: %w", err)
+	}
+	v := Verdict{Action: r.Action, Reason: r.Reason, Scores: r.Scores}
+	switch v.Action {
+	case ModAllow, ModFlag, ModHold, ModReject:
+		return v, nil
+	case "":
+	default:
+		return Verdict{}, fmt.Errorf("moderation: unknown action %q", v.Action)
+	}
+	top, what := 0.0, ""
+	for k, score := range r.Scores {
+		if score > top || score == top && k < what {
+			top, what = score, k
+		}
+	}
+	switch {
+	case h.RejectAt > 0 && top >= h.RejectAt:
+		v.Action = ModReject
+	case h.HoldAt > 0 && top >= h.HoldAt:
+		v.Action = ModHold
+	case h.FlagAt > 0 && top >= h.FlagAt:
+		v.Action = ModFlag
+	default:
+		v.Action = ModAllow
+	}
+	if v.Reason == "" && v.Action != ModAllow {
+		v.Reason = "looks like " + what
+	}
+	return v, nil
+}
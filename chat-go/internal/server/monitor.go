@@ -0,0 +1,67 @@
+package server
+
+import (
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Queue depth monitoring
+// ---------------------------------------------------------------------------
+//
+// runMonitor samples the server's internal queues once a second and logs a
+// warning when one of them passes queueWarnAt of its capacity, and again
+// when it has drained below queueClearAt.  The same depths are exported as
+// gauges on /metrics.
+
+const (
+	monitorTick  = time.Second
+	queueWarnAt  = 0.75 // fraction of capacity that triggers a warning
+	queueClearAt = 0.50 // fraction below which the warning is cleared
+)
+
+// queueGauge is one monitored queue.
+type queueGauge struct {
+	name   string
+	depth  func() int
+	cap    int
+	warned bool
+}
+
+func (s *Server) queueGauges() []*queueGauge {
+	return []*queueGauge{
+		{name: "hub broadcast backlog", depth: func() int { return len(s.hub.broadcast) }, cap: cap(s.hub.broadcast)},
+		{name: "hub control backlog", depth: func() int { return len(s.hub.control) }, cap: cap(s.hub.control)},
+		{name: "fullest client send buffer", depth: func() int { return int(s.hub.sendMax.Load()) }, cap: s.cfg.SendBuffer},
+		{name: "persistence queue", depth: func() int { return len(s.pool.jobs) }, cap: cap(s.pool.jobs)},
+	}
+}
+
+// runMonitor must be launched as a goroutine; it returns when s.quit is
+// closed.
+func (s *Server) runMonitor() {
+	gauges := s.queueGauges()
+	t := time.NewTicker(monitorTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			for _, g := range gauges {
+				g.check()
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (g *queueGauge) check() {
+	n := g.depth()
+	switch {
+	case !g.warned && float64(n) >= queueWarnAt*float64(g.cap):
+		g.warned = true
+		logger("monitor").Warn("queue filling up", "queue", g.name, "len", n, "cap", g.cap)
+	case g.warned && float64(n) < queueClearAt*float64(g.cap):
+		g.warned = false
+		logger("monitor").Info("queue back to normal", "queue", g.name, "len", n, "cap", g.cap)
+	}
+}
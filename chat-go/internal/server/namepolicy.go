@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Channel name and topic policy
+// ---------------------------------------------------------------------------
+//
+// Any name of a-z, 0-9, '-' and '_' up to protocol.MaxChannelName makes a
+// channel, and any topic up to store.MaxTopicLength is taken.
+// Config.NamePolicy narrows that down for the whole server: how long names
+// and topics may be, which characters they may use, and which names, name
+// beginnings and topic words are reserved, e.g. the "admin-" channels.
+// Admins may use what is reserved; the other rules hold for everyone.
+//
+// The policy applies when a channel is created and when its topic is set.
+// Channels that exist already keep their names, and a topic that is
+// cleared is always fine.  A refusal is a PolicyError, sent to the client
+// with protocol.ErrPolicy and a PolicyPayload saying which rule it broke.
+
+// NamePolicy is what the names of new public channels and channel topics
+// must be like.  Load one with LoadNamePolicy.
+type NamePolicy struct {
+	Channel TextPolicy `json:"channel"`
+	Topic   TextPolicy `json:"topic"`
+}
+
+// TextPolicy is what a channel name or topic must be like.  A zero field
+// allows whatever the protocol does.  Reserved is whole names for a
+// channel, and words for a topic; both it and ReservedPrefixes ignore
+// case.
+type TextPolicy struct {
+	MinLength        int      `json:"min_length,omitempty"`        // characters
+	MaxLength        int      `json:"max_length,omitempty"`        // characters
+	Charset          string   `json:"charset,omitempty"`           // as inside a regexp's [], e.g. "a-z0-9-"
+	Reserved         []string `json:"reserved,omitempty"`          // for admins only
+	ReservedPrefixes []string `json:"reserved_prefixes,omitempty"` // for admins only, e.g. "admin-"
+
+	charset *regexp.Regexp
+}
+
+// PolicyError is a channel name or topic that Config.NamePolicy refuses.
+type PolicyError struct {
+	Field string // protocol.PolicyChannel or PolicyTopic
+	Rule  string // protocol.PolicyLength, PolicyCharset or PolicyReserved
+	Msg   string
+}
+
+func (e *PolicyError) Error() string { return e.Msg }
+
+// LoadNamePolicy reads and checks a -name-policy file, a JSON NamePolicy.
+func LoadNamePolicy(path string) (*NamePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("name policy: %w", err)
+	}
+	var p NamePolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("name policy: parse %s: %w", path, err)
+	}
+	if err := p.Channel.compile("channel", protocol.MaxChannelName); err != nil {
+		return nil, err
+	}
+	if err := p.Topic.compile("topic", store.MaxTopicLength); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// compile checks t, whose texts can be up to most characters long, and
+// prepares its charset.
+func (t *TextPolicy) compile(field string, most int) error {
+	switch {
+	case t.MinLength < 0 || t.MaxLength < 0:
+		return fmt.Errorf("name policy: %s: lengths cannot be negative", field)
+	case t.MaxLength > most:
+		return fmt.Errorf("name policy: %s: max_length is at most %d", field, most)
+	case t.MaxLength > 0 && t.MinLength > t.MaxLength:
+		return fmt.Errorf("name policy: %s: min_length is over max_length", field)
+	}
+	if t.Charset != "" {
+		re, err := regexp.Compile(`^[` + t.Charset + `]*$`)
+		if err != nil {
+			return fmt.Errorf("name policy: %s: bad charset %q: %w", field, t.Charset, err)
+		}
+		t.charset = re
+	}
+	return nil
+}
+
+// CheckChannel returns a PolicyError when p does not allow a new channel
+// named name; admin says whether an admin creates it.  A nil p allows
+// anything.
+func (p *NamePolicy) CheckChannel(name string, admin bool) error {
+	if p == nil {
+		return nil
+	}
+	return p.Channel.check(protocol.PolicyChannel, "channel names", name, []string{name}, admin)
+}
+
+// CheckTopic returns a PolicyError when p does not allow topic; admin
+// says whether an admin sets it.  A nil p allows anything.
+func (p *NamePolicy) CheckTopic(topic string, admin bool) error {
+	if p == nil || topic == "" {
+		return nil
+	}
+	words := strings.FieldsFunc(topic, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+	})
+	return p.Topic.check(protocol.PolicyTopic, "topics", topic, words, admin)
+}
+
+// check checks text, a field of the given name, described as what, with
+// its words.
+func (t *TextPolicy) check(field, what, text string, words []string, admin bool) error {
+	refuse := func(rule, format string, args ...any) error {
+		return &PolicyError{Field: field, Rule: rule, Msg: fmt.Sprintf(format, args...)}
+	}
+	n := utf8.RuneCountInString(text)
+	switch {
+	case t.MinLength > 0 && n < t.MinLength:
+		return refuse(protocol.PolicyLength, "%s need at least %d characters", what, t.MinLength)
+	case t.MaxLength > 0 && n > t.MaxLength:
+		return refuse(protocol.PolicyLength, "%s can have at most %d characters", what, t.MaxLength)
+	case t.charset != nil && !t.charset.MatchString(text):
+		return refuse(protocol.PolicyCharset, "%s can only use the characters [%s]", what, t.Charset)
+	case admin:
+		return nil
+	}
+	lower := strings.ToLower(text)
+	for _, pre := range t.ReservedPrefixes {
+		if strings.HasPrefix(lower, strings.ToLower(pre)) {
+			return refuse(protocol.PolicyReserved, "%s starting with %q are reserved for admins", what, pre)
+		}
+	}
+	for _, w := range words {
+		for _, r := range t.Reserved {
+			if strings.EqualFold(w, r) {
+				return refuse(protocol.PolicyReserved, "%q is reserved for admins", r)
+			}
+		}
+	}
+	return nil
+}
+
+// refusePolicy tells c why the policy refused, when err is a PolicyError,
+// and reports whether it was.
+func refusePolicy(c *Client, err error) bool {
+	var pe *PolicyError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	c.sendErrorCode(protocol.ErrPolicy, pe.Msg, protocol.PolicyPayload{Field: pe.Field, Rule: pe.Rule})
+	return true
+}
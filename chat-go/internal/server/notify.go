@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Out-of-band notifications
+// ---------------------------------------------------------------------------
+//
+// A Notifier reaches a user outside the chat, for things they need while
+// they cannot log in, such as an unlock code.  Each one uses its own
+// address on the account: Mailer the Email, Ntfy the ntfy topic.  Both are
+// set by an admin (chatctl set-email, set-ntfy).
+
+// ErrNoAddress is returned by a Notifier that has no address for the user.
+var ErrNoAddress = errors.New("no address on the account")
+
+// Notifier delivers a short message to a user.
+type Notifier interface {
+	Notify(u *store.User, subject, body string) error
+	Name() string // for logs and the audit trail, e.g. "email"
+}
+
+// Notify mails the message to u.Email.
+func (m *Mailer) Notify(u *store.User, subject, body string) error {
+	if u.Email == "" {
+		return ErrNoAddress
+	}
+	return m.Send(u.Email, subject, body)
+}
+
+// Name implements Notifier.
+func (m *Mailer) Name() string { return "email" }
+
+// Ntfy publishes messages to the user's topic on an ntfy server
+// (https://ntfy.sh or a self-hosted one).  Topics on a public server can
+// be read by anyone who knows the name, so users should pick hard-to-guess
+// ones, or the server should require Token.
+type Ntfy struct {
+	URL    string // base URL, e.g. https://ntfy.sh
+	Token  string // access token, if the server requires one
+	Client *http.Client
+}
+
+const ntfyTimeout = 10 * time.Second
+
+// Notify publishes the message to u.Ntfy.
+func (n *Ntfy) Notify(u *store.User, subject, body string) error {
+	if u.Ntfy == "" {
+		return ErrNoAddress
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ntfyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(n.URL, "/")+"/"+u.Ntfy, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("ntfy: %w", err)
+	}
+	req.Header.Set("Title", subject)
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ntfy: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ntfy: %s", resp.Status)
+	}
+	return nil
+}
+
+// Name implements Notifier.
+func (n *Ntfy) Name() string { return "ntfy" }
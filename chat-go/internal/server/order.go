@@ -0,0 +1,105 @@
+package server
+
+import (
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message order
+// ---------------------------------------------------------------------------
+//
+// Every chat message, whatever its conversation, passes through the Hub
+// goroutine, which gives it its place: the next Seq of its channel and a
+// Timestamp later than that of the message before it.  The Hub then queues
+// it for its readers and publishes it on the event bus, so every client
+// receives messages in the same order, and the store subscriber hands them
+// to the worker pool, which saves each conversation's messages in that
+// order too (see pool.go).
+// History and the archive therefore agree with what was broadcast.
+//
+// A client that logged in with AuthPayload.NoEcho is not sent the
+// broadcasts of its own messages: it shows them as it sends them, and the
+// Hub acknowledges each with a TypeSent carrying the numbered message in
+// the broadcast's place, so the sender learns the ID and Seq it got
+// without receiving its own message back.
+
+// hubPost is a message on its way through the Hub.
+type hubPost struct {
+	msg *protocol.StoredMessage
+	to  map[string]bool // IDs of the users who may read it; nil for everyone
+
+	from *Client // sent TypeSent instead of the broadcast; nil unless it asked for no echo
+	ref  string  // from's ChatPayload.Ref, returned in the TypeSent
+}
+
+// post hands msg to the Hub to be numbered, delivered and published.
+// Handlers return before that happens; EventMessage subscribers run on the
+// Hub goroutine and so must not post, or block.
+func (s *Server) post(msg *protocol.StoredMessage) {
+	s.postFrom(nil, "", msg)
+}
+
+// postFrom posts msg sent by c, which when it asked for no echo is
+// acknowledged with a TypeSent carrying ref instead of the broadcast.  c is
+// nil for messages the server posts itself.
+func (s *Server) postFrom(c *Client, ref string, msg *protocol.StoredMessage) {
+	s.signMessage(msg)
+	p := &hubPost{msg: msg}
+	if c != nil && c.noEcho.Load() {
+		p.from, p.ref = c, ref
+	}
+	switch ch := msg.Channel; {
+	case protocol.IsDirect(ch):
+		a, b, _ := protocol.DirectMembers(ch)
+		p.to = map[string]bool{a: true, b: true}
+	case protocol.IsPublic(ch):
+		p.to = s.store.ChannelMembers(ch)
+	}
+	s.hub.posts <- p
+}
+
+// number gives p's message its place in the order; see above.
+func (h *Hub) number(p *hubPost) {
+	msg := p.msg
+	h.seqs[msg.Channel]++
+	msg.Seq = h.seqs[msg.Channel]
+	if !msg.Timestamp.After(h.lastAt) {
+		msg.Timestamp = h.lastAt.Add(time.Nanosecond)
+	}
+	h.lastAt = msg.Timestamp
+}
+
+// deliverPost queues the numbered message of p for its readers and
+// publishes it.  It runs on the Hub goroutine.
+func (s *Server) deliverPost(p *hubPost) {
+	msg := s.stamps.message(p.msg)
+	data, err := newBroadcast(msg).Encode()
+	if err != nil {
+		return
+	}
+	var ack []byte
+	if p.from != nil {
+		pkt, _ := protocol.NewPacket(protocol.TypeSent, protocol.SentPayload{Ref: p.ref, Message: broadcastOf(msg)})
+		if ack, err = pkt.Encode(); err != nil {
+			return
+		}
+		ack = append(ack, '\n')
+	}
+	s.hub.fanOut(append(data, '\n'), p.to, p.from, ack)
+	s.events.Publish(Event{Type: EventMessage, At: p.msg.Timestamp, Message: p.msg})
+}
+
+// fanOut delivers data to every client, or with to set to the
+// authenticated clients it names, except that from, when set, gets ack.
+func (h *Hub) fanOut(data []byte, to map[string]bool, from *Client, ack []byte) {
+	for c := range h.clients {
+		switch {
+		case c == from:
+			h.deliver(c, ack)
+		case to == nil || to[c.getUserID()]:
+			h.deliver(c, data)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Send-buffer overflow
+// ---------------------------------------------------------------------------
+//
+// When a client's send channel is full the Hub applies Config.Overflow:
+//
+//	disconnect  drop the client (the original behaviour), after a grace
+//	            period to catch up, see slow.go
+//	skip        drop the packet for that client only, then send a TypeGap
+//	            marker with the count ahead of the next packet that fits
+//	spill       append the packet to a per-client queue file under
+//	            <DataDir>/spill; writePump replays it once the channel drains
+//
+// Spilled packets keep their order: while a queue is non-empty every new
+// broadcast for that client is appended to it rather than sent directly.
+
+// Overflow policies for Config.Overflow.
+const (
+	OverflowDisconnect = "disconnect"
+	OverflowSkip       = "skip"
+	OverflowSpill      = "spill"
+)
+
+// maxSpillSize bounds one client's spill file.  A client that falls this far
+// behind is disconnected after all.
+const maxSpillSize = 64 << 20
+
+func validOverflow(p string) error {
+	switch p {
+	case "", OverflowDisconnect, OverflowSkip, OverflowSpill:
+		return nil
+	}
+	return fmt.Errorf("unknown overflow policy %q (want %s, %s or %s)",
+		p, OverflowDisconnect, OverflowSkip, OverflowSpill)
+}
+
+// overflowStats counts what the Hub did with packets that did not fit.
+type overflowStats struct {
+	dropped atomic.Uint64 // clients disconnected
+	skipped atomic.Uint64 // packets skipped under OverflowSkip
+	spilled atomic.Uint64 // packets written to a spill file
+}
+
+// gapPacket encodes the marker sent after n skipped broadcasts.
+func gapPacket(n int) []byte {
+	pkt, _ := protocol.NewPacket(protocol.TypeGap, protocol.GapPayload{Skipped: n})
+	data, _ := pkt.Encode()
+	return append(data, '\n')
+}
+
+// spillQueue is a FIFO of packets on disk.  The Hub pushes, writePump pops;
+// the file is created on first use and truncated whenever it empties.
+type spillQueue struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	wr     int64 // write offset
+	rd     int64 // read offset
+	lens   []int // sizes of the queued packets, oldest first
+	closed bool  // set once the owning writePump has exited
+}
+
+// spillDir holds the per-connection spill files.
+func (s *Server) spillDir() string { return filepath.Join(s.cfg.DataDir, "spill") }
+
+func newSpillQueue(dir, connID string) *spillQueue {
+	return &spillQueue{path: filepath.Join(dir, connID+".q")}
+}
+
+// pushIfActive appends line when the queue already holds packets, so new
+// packets stay behind the spilled ones.  It reports whether it did.
+func (q *spillQueue) pushIfActive(line []byte) (bool, error) {
+	if q == nil {
+		return false, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.lens) == 0 {
+		return false, nil
+	}
+	return true, q.pushLocked(line)
+}
+
+// push appends line to the queue.
+func (q *spillQueue) push(line []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pushLocked(line)
+}
+
+func (q *spillQueue) pushLocked(line []byte) error {
+	if q.closed {
+		return nil // writePump is gone; the client is being torn down
+	}
+	if q.wr+int64(len(line)) > maxSpillSize {
+		return fmt.Errorf("spill queue full (%d bytes)", q.wr)
+	}
+	if q.f == nil {
+		f, err := os.OpenFile(q.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		q.f = f
+	}
+	if _, err := q.f.WriteAt(line, q.wr); err != nil {
+		return err
+	}
+	q.wr += int64(len(line))
+	q.lens = append(q.lens, len(line))
+	return nil
+}
+
+// pop removes and returns the oldest packet, or nil when the queue is empty.
+func (q *spillQueue) pop() []byte {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.lens) == 0 {
+		return nil
+	}
+	line := make([]byte, q.lens[0])
+	if _, err := q.f.ReadAt(line, q.rd); err != nil {
+		return nil
+	}
+	q.rd += int64(len(line))
+	q.lens = q.lens[1:]
+	if len(q.lens) == 0 {
+		q.f.Truncate(0)
+		q.wr, q.rd, q.lens = 0, 0, nil
+	}
+	return line
+}
+
+// remove deletes the spill file; later pushes are ignored.
+func (q *spillQueue) remove() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.lens = nil
+	if q.f != nil {
+		q.f.Close()
+		os.Remove(q.path)
+		q.f = nil
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// testPacket encodes a chat packet whose content is name.
+func testPacket(name string) []byte {
+	pkt, _ := protocol.NewPacket(protocol.TypeChat, protocol.ChatPayload{Content: name})
+	data, _ := pkt.Encode()
+	return append(data, '\n')
+}
+
+// describePacket turns a packet from testPacket or gapPacket back into a
+// short label: the chat content, or "gap:N".
+func describePacket(data []byte) string {
+	var p protocol.Packet
+	if err := json.Unmarshal(data, &p); err != nil {
+		return "bad packet"
+	}
+	switch p.Type {
+	case protocol.TypeGap:
+		var g protocol.GapPayload
+		json.Unmarshal(p.Payload, &g)
+		return fmt.Sprintf("gap:%d", g.Skipped)
+	case protocol.TypeChat:
+		var c protocol.ChatPayload
+		json.Unmarshal(p.Payload, &c)
+		return c.Content
+	}
+	return string(p.Type)
+}
+
+// TestHubOverflow fills a two-packet send buffer, lets the client read what
+// was queued, broadcasts once more and then reads everything left, as
+// writePump would: the send channel first, then the spill file.
+func TestHubOverflow(t *testing.T) {
+	tests := []struct {
+		policy    string
+		grace     time.Duration
+		want      []string // what the client reads, in order
+		connected bool
+		dropped   uint64
+		skipped   uint64
+		spilled   uint64
+		recovered uint64
+	}{
+		{
+			policy:  OverflowDisconnect,
+			want:    []string{"p1", "p2"},
+			dropped: 1,
+		},
+		{
+			policy:    OverflowDisconnect,
+			grace:     time.Hour,
+			want:      []string{"p1", "p2", "gap:2", "p5"},
+			connected: true,
+			recovered: 1,
+		},
+		{
+			policy:    OverflowSkip,
+			want:      []string{"p1", "p2", "gap:2", "p5"},
+			connected: true,
+			skipped:   2,
+		},
+		{
+			policy:    OverflowSpill,
+			want:      []string{"p1", "p2", "p3", "p4", "p5"},
+			connected: true,
+			spilled:   3, // p5 queues behind p3 and p4
+		},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/grace=%v", tt.policy, tt.grace), func(t *testing.T) {
+			h := newHub(tt.policy, tt.grace, nil)
+			c := &Client{
+				id:     "c1",
+				send:   make(chan []byte, 2),
+				closed: make(chan struct{}),
+			}
+			if tt.policy == OverflowSpill {
+				c.spill = newSpillQueue(t.TempDir(), c.id)
+				defer c.spill.remove()
+			}
+			h.clients[c] = true
+			broadcast := func(names ...string) {
+				for _, n := range names {
+					for cl := range h.clients {
+						h.deliver(cl, testPacket(n))
+					}
+				}
+			}
+			var got []string
+			readSend := func() {
+				for len(c.send) > 0 {
+					got = append(got, describePacket(<-c.send))
+				}
+			}
+
+			broadcast("p1", "p2", "p3", "p4")
+			readSend()
+			broadcast("p5")
+			readSend()
+			for line := c.spill.pop(); line != nil; line = c.spill.pop() {
+				got = append(got, describePacket(line))
+			}
+
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("client read %v, want %v", got, tt.want)
+			}
+			if h.clients[c] != tt.connected {
+				t.Errorf("connected = %v, want %v", h.clients[c], tt.connected)
+			}
+			select {
+			case <-c.closed:
+				if tt.connected {
+					t.Error("closed the client's writePump but kept the client")
+				}
+			default:
+				if !tt.connected {
+					t.Error("dropped the client without closing its writePump")
+				}
+			}
+			stats := []struct {
+				name      string
+				got, want uint64
+			}{
+				{"dropped", h.stats.dropped.Load(), tt.dropped},
+				{"skipped", h.stats.skipped.Load(), tt.skipped},
+				{"spilled", h.stats.spilled.Load(), tt.spilled},
+				{"recovered", h.drops.recovered.Load(), tt.recovered},
+			}
+			for _, s := range stats {
+				if s.got != s.want {
+					t.Errorf("%s = %d, want %d", s.name, s.got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidOverflow(t *testing.T) {
+	tests := []struct {
+		policy string
+		ok     bool
+	}{
+		{"", true},
+		{OverflowDisconnect, true},
+		{OverflowSkip, true},
+		{OverflowSpill, true},
+		{"block", false},
+		{"Skip", false},
+	}
+	for _, tt := range tests {
+		if err := validOverflow(tt.policy); (err == nil) != tt.ok {
+			t.Errorf("validOverflow(%q) = %v, want ok=%v", tt.policy, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+const (
+	maxPollOptions    = 10
+	maxPollTextLength = 200 // characters per question or option
+)
+
+func (s *Server) handlePollCreate(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.refuseWrite(c) || s.refuseMuted(c) {
+		return
+	}
+	var p protocol.PollCreatePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("poll_create requires {question, options}")
+		return
+	}
+	p.Question = strings.TrimSpace(sanitizeLine(p.Question))
+	var options []string
+	for _, o := range p.Options {
+		if o = strings.TrimSpace(sanitizeLine(o)); o != "" {
+			options = append(options, o)
+		}
+	}
+	if p.Question == "" || len(options) < 2 || len(options) > maxPollOptions {
+		c.sendError(fmt.Sprintf("a poll needs a question and 2–%d options", maxPollOptions))
+		return
+	}
+	for _, t := range append([]string{p.Question}, options...) {
+		if len([]rune(t)) > maxPollTextLength {
+			c.sendError(fmt.Sprintf("poll text is limited to %d characters", maxPollTextLength))
+			return
+		}
+	}
+	if !s.takePost(c, protocol.TypePollCreate) {
+		return
+	}
+
+	poll, err := s.store.CreatePoll(c.getUserID(), c.getUsername(), p.Question, options)
+	if err != nil {
+		c.logger("store").Error("saving polls failed", "err", err)
+	}
+	s.broadcastPoll(poll)
+}
+
+func (s *Server) handlePollVote(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	var p protocol.PollVotePayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.PollID == "" {
+		c.sendError("poll_vote requires {poll_id, option}")
+		return
+	}
+	poll, err := s.store.VotePoll(p.PollID, c.getUserID(), p.Option)
+	if poll == nil {
+		c.sendError(err.Error())
+		return
+	}
+	if err != nil {
+		c.logger("store").Error("saving polls failed", "err", err)
+	}
+	s.broadcastPoll(poll)
+}
+
+func (s *Server) handlePollClose(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	var p protocol.PollClosePayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.PollID == "" {
+		c.sendError("poll_close requires {poll_id}")
+		return
+	}
+	isMod := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleModerator)
+	poll, err := s.store.ClosePoll(p.PollID, c.getUserID(), isMod)
+	if poll == nil {
+		c.sendError(err.Error())
+		return
+	}
+	if err != nil {
+		c.logger("store").Error("saving polls failed", "err", err)
+	}
+	if poll.CreatorID != c.getUserID() {
+		s.events.Publish(moderationEvent(c, ActionPollClose, poll.ID, ""))
+	}
+	s.broadcastPoll(poll)
+}
+
+// broadcastPoll sends the poll's current tallies to every client.
+func (s *Server) broadcastPoll(poll *protocol.Poll) {
+	pkt, _ := protocol.NewPacket(protocol.TypePoll, poll)
+	s.broadcast(pkt)
+}
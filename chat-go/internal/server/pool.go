@@ -0,0 +1,266 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Worker pool – async message persistence
+// ---------------------------------------------------------------------------
+//
+// workerPool persists chat messages in the background so the broadcast path
+// (which runs inside the Hub goroutine) is never blocked by disk I/O.
+//
+// The pool sizes itself between Config.MinWorkers and Config.MaxWorkers.
+// Once every poolTick it looks at the queue and at how long saves took
+// since the last look: it adds a worker while messages pile up (poolGrowAt
+// or more queued) or saves are slow (poolSlowSave) with some queued, and
+// retires an idle one after poolIdleTicks quiet ticks in a row.  The size
+// is exported on /metrics as chat_persist_workers.
+//
+// Whatever the size, the messages of a conversation reach the archive in
+// the order they were queued, which is the order the Hub broadcast them
+// (see order.go).  Workers take messages, or batches of them, one at a
+// time and in order; a save waits only for the saves taken before it of
+// the same conversations, so a slow conversation does not hold up the
+// others.  The queue depth on /metrics counts every message not yet saved,
+// waiting in the queue or for its turn.
+
+// maxSaveBatch bounds how many queued messages a worker saves at once with
+// store.DurabilityBatch.
+const maxSaveBatch = 256
+
+const (
+	defaultMinWorkers = 1
+	defaultMaxWorkers = 16
+
+	poolTick      = time.Second
+	poolGrowAt    = 64                    // queued messages that call for another worker
+	poolSlowSave  = 50 * time.Millisecond // mean save time that calls for another worker
+	poolIdleTicks = 30                    // quiet ticks before a worker is retired
+)
+
+type workerPool struct {
+	jobs     chan *protocol.StoredMessage
+	retire   chan struct{} // taken by an idle worker, which then exits
+	done     chan struct{} // closed to stop the sizing loop
+	sizer    sync.WaitGroup
+	wg       sync.WaitGroup
+	min, max int
+	save     func([]*protocol.StoredMessage) error
+	batch    bool // take whatever is queued, see drain
+
+	takeMu sync.Mutex // held by the worker waiting for the next job
+	tailMu sync.Mutex
+	tails  map[string]chan struct{} // per channel, the last job taken; closed once saved
+
+	pending atomic.Int64  // messages queued or taken and not yet saved
+	size    atomic.Int64  // running workers
+	saves   atomic.Uint64 // saves since the last tick
+	saveNS  atomic.Uint64 // time they took
+	latency atomic.Int64  // mean save time over the last tick, ns
+	resizes atomic.Uint64
+}
+
+// poolBounds returns the worker bounds of cfg, with the defaults filled in.
+func poolBounds(cfg Config) (lo, hi int, err error) {
+	lo, hi = cfg.MinWorkers, cfg.MaxWorkers
+	if lo == 0 {
+		lo = defaultMinWorkers
+	}
+	if hi == 0 {
+		hi = max(lo, defaultMaxWorkers)
+	}
+	if lo < 1 || hi < lo {
+		return 0, 0, fmt.Errorf("persistence workers: want 1 <= min (%d) <= max (%d)", lo, hi)
+	}
+	return lo, hi, nil
+}
+
+func newWorkerPool(lo, hi int, s *store.Store) *workerPool {
+	p := &workerPool{
+		jobs:   make(chan *protocol.StoredMessage, 1024),
+		retire: make(chan struct{}),
+		done:   make(chan struct{}),
+		min:    lo,
+		max:    hi,
+		tails:  make(map[string]chan struct{}),
+	}
+	if s.Durability() == store.DurabilityBatch {
+		p.save, p.batch = s.SaveMessages, true
+	} else {
+		p.save = func(msgs []*protocol.StoredMessage) error { return s.SaveMessage(msgs[0]) }
+	}
+	for range lo {
+		p.grow()
+	}
+	p.sizer.Add(1)
+	go p.runSizer()
+	return p
+}
+
+// grow starts one more worker.
+func (p *workerPool) grow() {
+	p.size.Add(1)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			j, ok := p.take()
+			if !ok {
+				return
+			}
+			p.commit(j)
+		}
+	}()
+}
+
+// poolJob is a message, or batch of them, taken by a worker.
+type poolJob struct {
+	msgs  []*protocol.StoredMessage
+	after []chan struct{} // the jobs taken before of the same channels
+	done  chan struct{}   // closed once msgs are saved
+}
+
+// take waits for the next job: a message, or with p.batch everything
+// queued.  It reports false when the worker should exit.
+func (p *workerPool) take() (*poolJob, bool) {
+	p.takeMu.Lock()
+	defer p.takeMu.Unlock()
+	j := &poolJob{done: make(chan struct{})}
+	select {
+	case msg, ok := <-p.jobs:
+		if !ok {
+			return nil, false
+		}
+		j.msgs = []*protocol.StoredMessage{msg}
+		if p.batch {
+			j.msgs = p.drain(msg)
+		}
+	case <-p.retire:
+		p.size.Add(-1)
+		return nil, false
+	}
+	p.tailMu.Lock()
+	for _, m := range j.msgs {
+		if prev, ok := p.tails[m.Channel]; ok && prev != j.done {
+			j.after = append(j.after, prev)
+		}
+		p.tails[m.Channel] = j.done
+	}
+	p.tailMu.Unlock()
+	return j, true
+}
+
+// commit saves j once the jobs it comes after are saved.
+func (p *workerPool) commit(j *poolJob) {
+	for _, prev := range j.after {
+		<-prev
+	}
+	start := time.Now()
+	if err := p.save(j.msgs); err != nil {
+		logger("store").Error("saving messages failed", "err", err)
+	}
+	p.saveNS.Add(uint64(time.Since(start)))
+	p.saves.Add(1)
+	close(j.done)
+	p.tailMu.Lock()
+	for _, m := range j.msgs {
+		if p.tails[m.Channel] == j.done {
+			delete(p.tails, m.Channel)
+		}
+	}
+	p.tailMu.Unlock()
+	p.pending.Add(-int64(len(j.msgs)))
+}
+
+// runSizer resizes the pool every poolTick until p.done is closed.  It
+// runs for a fixed-size pool too, to keep the latency gauge up to date.
+func (p *workerPool) runSizer() {
+	defer p.sizer.Done()
+	t := time.NewTicker(poolTick)
+	defer t.Stop()
+	idle := 0
+	for {
+		select {
+		case <-t.C:
+		case <-p.done:
+			return
+		}
+		var mean time.Duration
+		if n := p.saves.Swap(0); n > 0 {
+			mean = time.Duration(p.saveNS.Swap(0) / n)
+		}
+		p.latency.Store(int64(mean))
+		queued, size := int(p.pending.Load()), int(p.size.Load())
+
+		switch {
+		case queued >= poolGrowAt || queued > 0 && mean >= poolSlowSave:
+			idle = 0
+			if size < p.max {
+				p.grow()
+				p.resized(size, size+1, queued, mean)
+			}
+		case queued == 0 && mean < poolSlowSave:
+			if idle++; idle < poolIdleTicks || size <= p.min {
+				continue
+			}
+			select {
+			case p.retire <- struct{}{}:
+				idle = 0
+				p.resized(size, size-1, queued, mean)
+			default: // every worker is busy after all
+			}
+		default:
+			idle = 0
+		}
+	}
+}
+
+func (p *workerPool) resized(from, to, queued int, mean time.Duration) {
+	p.resizes.Add(1)
+	logger("pool").Info("resized", "from", from, "to", to, "queued", queued, "mean_save", mean.Round(time.Microsecond))
+}
+
+// drain returns first and whatever else is queued right now, up to
+// maxSaveBatch messages.
+func (p *workerPool) drain(first *protocol.StoredMessage) []*protocol.StoredMessage {
+	msgs := []*protocol.StoredMessage{first}
+	for len(msgs) < maxSaveBatch {
+		select {
+		case msg, ok := <-p.jobs:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+	return msgs
+}
+
+func (p *workerPool) submit(msg *protocol.StoredMessage) {
+	// Non-blocking submit; drop silently if the queue is full.
+	p.pending.Add(1)
+	select {
+	case p.jobs <- msg:
+	default:
+		p.pending.Add(-1)
+		logger("pool").Warn("job queue full, message dropped from persistence", "msg_id", msg.ID)
+	}
+}
+
+// stop stops resizing, then lets the workers finish the queue.
+func (p *workerPool) stop() {
+	close(p.done)
+	p.sizer.Wait()
+	close(p.jobs)
+	p.wg.Wait()
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// newTestPool returns a pool of n workers that saves with save instead of a
+// Store.
+func newTestPool(n int, batch bool, save func([]*protocol.StoredMessage) error) *workerPool {
+	p := &workerPool{
+		jobs:   make(chan *protocol.StoredMessage, 1024),
+		retire: make(chan struct{}),
+		done:   make(chan struct{}),
+		min:    n,
+		max:    n,
+		tails:  make(map[string]chan struct{}),
+		save:   save,
+		batch:  batch,
+	}
+	for range n {
+		p.grow()
+	}
+	p.sizer.Add(1)
+	go p.runSizer()
+	return p
+}
+
+// TestPoolOrder checks that each conversation's messages are saved in the
+// order they were submitted, however many workers race to save them.
+func TestPoolOrder(t *testing.T) {
+	tests := []struct {
+		workers int
+		batch   bool
+	}{
+		{1, false},
+		{4, false},
+		{16, false},
+		{1, true},
+		{4, true},
+		{16, true},
+	}
+	channels := []string{protocol.MainChannel, "#a", "#b", "#slow"}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("workers=%d/batch=%v", tt.workers, tt.batch), func(t *testing.T) {
+			var mu sync.Mutex
+			saved := make(map[string][]uint64)
+			p := newTestPool(tt.workers, tt.batch, func(msgs []*protocol.StoredMessage) error {
+				for _, m := range msgs {
+					if m.Channel == "#slow" {
+						time.Sleep(100 * time.Microsecond)
+					}
+				}
+				mu.Lock()
+				for _, m := range msgs {
+					saved[m.Channel] = append(saved[m.Channel], m.Seq)
+				}
+				mu.Unlock()
+				return nil
+			})
+
+			const perChannel = 200
+			for i := range perChannel {
+				for _, ch := range channels {
+					p.submit(&protocol.StoredMessage{ID: fmt.Sprint(ch, i), Channel: ch, Seq: uint64(i + 1)})
+				}
+			}
+			p.stop()
+
+			for _, ch := range channels {
+				seqs := saved[ch]
+				if len(seqs) != perChannel {
+					t.Errorf("%s: saved %d messages, want %d", ch, len(seqs), perChannel)
+					continue
+				}
+				for i, seq := range seqs {
+					if seq != uint64(i+1) {
+						t.Errorf("%s: save %d was seq %d, want %d", ch, i, seq, i+1)
+						break
+					}
+				}
+			}
+			if n := p.pending.Load(); n != 0 {
+				t.Errorf("pending = %d after stop, want 0", n)
+			}
+		})
+	}
+}
+
+// TestPoolSlowConversation checks that a save stuck on one conversation
+// holds up only that conversation's later messages.
+func TestPoolSlowConversation(t *testing.T) {
+	release := make(chan struct{})
+	savedOther := make(chan string, 8)
+	var mu sync.Mutex
+	var stuckOrder []string
+	p := newTestPool(3, false, func(msgs []*protocol.StoredMessage) error {
+		m := msgs[0]
+		if m.Channel == "#stuck" {
+			if m.ID == "s1" {
+				<-release
+			}
+			mu.Lock()
+			stuckOrder = append(stuckOrder, m.ID)
+			mu.Unlock()
+			return nil
+		}
+		savedOther <- m.ID
+		return nil
+	})
+
+	p.submit(&protocol.StoredMessage{ID: "s1", Channel: "#stuck"})
+	p.submit(&protocol.StoredMessage{ID: "s2", Channel: "#stuck"})
+	p.submit(&protocol.StoredMessage{ID: "o1", Channel: "#other"})
+	select {
+	case id := <-savedOther:
+		if id != "o1" {
+			t.Errorf("saved %s, want o1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stuck conversation held up another one")
+	}
+	close(release)
+	p.stop()
+	if fmt.Sprint(stuckOrder) != "[s1 s2]" {
+		t.Errorf("stuck conversation saved as %v, want [s1 s2]", stuckOrder)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Proof of work for registration
+// ---------------------------------------------------------------------------
+//
+// With Config.RegisterWork set, a client must ask for a challenge before it
+// registers and spend some CPU time solving it (see
+// protocol.ChallengePayload).  A person signing up does not notice a second
+// or so; a script creating thousands of accounts pays it every time, since
+// each challenge is good for one attempt on one connection.  Every added
+// bit doubles the work: around 20 takes a second on a laptop.
+
+const (
+	maxWorkBits    = 32
+	challengeTTL   = 5 * time.Minute
+	maxProofLength = 64
+)
+
+// powChallenge is the latest challenge issued to a connection.
+type powChallenge struct {
+	nonce   string
+	expires time.Time
+}
+
+func validRegisterWork(bits int) error {
+	if bits < 0 || bits > maxWorkBits {
+		return fmt.Errorf("registration proof of work must be 0 to %d bits, not %d", maxWorkBits, bits)
+	}
+	return nil
+}
+
+// powRequired reports whether registering takes a proof of work.
+func (s *Server) powRequired() bool {
+	return s.cfg.RegisterWork > 0 && s.auth == nil
+}
+
+func (s *Server) handleChallenge(c *Client) {
+	if !s.powRequired() {
+		c.sendError("this server does not ask for a proof of work")
+		return
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	c.pow = powChallenge{nonce: hex.EncodeToString(b[:]), expires: time.Now().Add(challengeTTL)}
+	c.sendResponse(true, fmt.Sprintf("solve for %d bits", s.cfg.RegisterWork), protocol.ChallengePayload{
+		Nonce:   c.pow.nonce,
+		Bits:    s.cfg.RegisterWork,
+		Expires: c.pow.expires.UTC(),
+	})
+}
+
+// checkWork uses up c's challenge and returns why proof does not solve it,
+// or "" when it does or none is needed.
+func (s *Server) checkWork(c *Client, proof string) string {
+	if !s.powRequired() {
+		return ""
+	}
+	ch := c.pow
+	c.pow = powChallenge{}
+	switch {
+	case ch.nonce == "":
+		return "registration needs a proof of work: ask for a challenge first"
+	case time.Now().After(ch.expires):
+		return "the challenge has expired; ask for a new one"
+	case len(proof) > maxProofLength || !protocol.ProofOK(ch.nonce, proof, s.cfg.RegisterWork):
+		return "the proof of work does not solve the challenge"
+	}
+	return ""
+}
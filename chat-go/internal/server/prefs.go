@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Preferences
+// ---------------------------------------------------------------------------
+//
+// Users may mute conversations.  The server still delivers their messages;
+// clients use the muted list to skip notifications and unread counts, and
+// anything on the event bus that notifies users on its own should check
+// Muted before it does.  The locale users read translations in is kept here
+// too; see translate.go.
+
+// Muted reports whether the user with the given ID muted channel.
+func (s *Server) Muted(userID, channel string) bool {
+	return s.store.Muted(userID, channel)
+}
+
+func (s *Server) handlePreferences(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	c.sendResponse(true, "preferences", s.store.Preferences(c.getUserID()))
+}
+
+func (s *Server) handleMute(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var p protocol.MutePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("mute requires {channel, mute}")
+		return
+	}
+	if p.User != "" {
+		s.muteUser(c, p)
+		return
+	}
+	if protocol.IsPublic(p.Channel) {
+		name := protocol.PublicChannel(p.Channel)
+		if name == "" {
+			c.sendError("no such conversation " + p.Channel)
+			return
+		}
+		p.Channel = name
+	}
+	if _, err := s.recipient(c, p.Channel); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	prefs, err := s.store.SetMuted(c.getUserID(), p.Channel, p.Mute)
+	if err != nil {
+		c.logger("store").Error("saving preferences failed", "err", err)
+		c.sendError("could not save your preferences")
+		return
+	}
+	what := "unmuted"
+	if p.Mute {
+		what = "muted"
+	}
+	c.sendResponse(true, what+" "+channelName(p.Channel), prefs)
+}
+
+// channelName is how responses refer to a conversation.
+func channelName(ch string) string {
+	switch {
+	case ch == protocol.MainChannel:
+		return "the main channel"
+	case protocol.IsDirect(ch):
+		return "the conversation"
+	}
+	return "#" + ch
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Join notices
+// ---------------------------------------------------------------------------
+//
+// Every login is announced to everyone as "<name> joined the chat", and
+// every logout as "<name> left the chat".  In a busy room that is mostly
+// noise, so with Config.QuietJoins the server stops announcing them once
+// more than that many users are online: it counts logins and logouts
+// instead, and every Config.JoinSummary in which there were any it
+// broadcasts "N joined, M left".  Every such notice carries its Kind and
+// SystemPayload.Joined, Left and Online, so clients can keep their online
+// count without parsing the text, and hide them.
+//
+// Under Config.PrivateRoster the summaries still count everyone, without
+// naming anyone.
+
+const defaultJoinSummary = time.Minute
+
+// presence counts the logins and logouts not announced since the last
+// summary.
+type presence struct {
+	mu           sync.Mutex
+	joined, left int
+}
+
+func (p *presence) add(joined, left int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.joined += joined
+	p.left += left
+}
+
+func (p *presence) take() (joined, left int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	joined, left = p.joined, p.left
+	p.joined, p.left = 0, 0
+	return joined, left
+}
+
+// deliverEvent is the hub subscriber: it announces a join or a leave, or
+// when quiet counts it towards the next summary.
+func (s *Server) deliverEvent(e Event) {
+	online := s.onlineCount()
+	quiet := s.cfg.QuietJoins > 0 && online > s.cfg.QuietJoins
+	switch {
+	case quiet && e.Type == EventJoin:
+		s.presence.add(1, 0)
+	case quiet && e.Type == EventLeave:
+		s.presence.add(0, 1)
+	case e.Type == EventJoin:
+		s.announce(e, protocol.SystemJoin, e.Username+" joined the chat", 1, 0, online)
+	case e.Type == EventLeave && !s.shuttingDown.Load(): // not everyone, one by one
+		s.announce(e, protocol.SystemLeave, e.Username+" left the chat", 0, 1, online)
+	}
+}
+
+// announce broadcasts the notice that e's user joined or left.  Under
+// Config.PrivateRoster it goes only to the sessions that may see the user
+// (roster.go), and those that see only their peers are not told how many
+// are online.
+func (s *Server) announce(e Event, kind, msg string, joined, left, online int) {
+	peers := s.rosterPeers(e.UserID)
+	if peers == nil {
+		s.broadcast(presencePacket(kind, e.Username, msg, joined, left, online))
+		return
+	}
+	all := presencePacket(kind, e.Username, msg, joined, left, online)
+	mine := presencePacket(kind, e.Username, msg, joined, left, 0)
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, sc := range s.sessions {
+		switch {
+		case seesAll(sc):
+			sc.sendPacket(all)
+		case peers[sc.getUserID()]:
+			sc.sendPacket(mine)
+		}
+	}
+}
+
+// runPresence must be launched as a goroutine; it returns when s.quit is
+// closed.
+func (s *Server) runPresence() {
+	every := s.cfg.JoinSummary
+	if every <= 0 {
+		every = defaultJoinSummary
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.quit:
+			return
+		}
+		joined, left := s.presence.take()
+		if joined == 0 && left == 0 {
+			continue
+		}
+		online := s.onlineCount()
+		msg := fmt.Sprintf("%d joined, %d left in the last %s · %d online", joined, left, shortDuration(every), online)
+		s.broadcast(presencePacket(protocol.SystemPresence, "", msg, joined, left, online))
+	}
+}
+
+func presencePacket(kind, user, msg string, joined, left, online int) *protocol.Packet {
+	return systemNotice(protocol.SystemPayload{
+		Kind:    kind,
+		Message: msg,
+		User:    user,
+		Joined:  joined,
+		Left:    left,
+		Online:  online,
+	})
+}
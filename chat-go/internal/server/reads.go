@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Read receipts
+// ---------------------------------------------------------------------------
+//
+// Clients say how far the user has read each conversation (TypeMarkRead),
+// and the store keeps the mark.  When a mark moves, the authors of the
+// messages it passed over get a TypeRead receipt, so a sender learns that
+// their message was seen without everyone hearing of every read.  Anyone
+// in a conversation can ask who has read one of its messages
+// (TypeReaders), and a client coming back asks for its marks and the
+// unread counts after them (TypeUnread).
+
+// handleMarkRead moves c's read mark.  Like typing, it is not answered,
+// and one that cannot be applied is dropped.
+func (s *Server) handleMarkRead(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() || s.maint.get() != "" { // no writes, and no need to say so
+		return
+	}
+	var p protocol.MarkReadPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.ID == "" {
+		return
+	}
+	msg := s.store.GetMessage(p.ID)
+	if msg == nil || msg.Channel != p.Channel {
+		return
+	}
+	if _, err := s.recipient(c, p.Channel); err != nil {
+		return
+	}
+	prev, moved, err := s.store.MarkRead(c.getUserID(), msg)
+	if err != nil {
+		c.logger("store").Error("saving read marks failed", "err", err)
+	}
+	if !moved {
+		return
+	}
+
+	authors := s.store.Authors(msg.Channel, prev, msg.Seq)
+	delete(authors, c.getUserID())
+	if len(authors) == 0 {
+		return
+	}
+	pkt, _ := protocol.NewPacket(protocol.TypeRead, protocol.ReadPayload{
+		Channel: msg.Channel,
+		ID:      msg.ID,
+		Seq:     msg.Seq,
+		User:    c.getUsername(),
+	})
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, sc := range s.sessions {
+		if authors[sc.getUserID()] {
+			sc.sendPacket(pkt)
+		}
+	}
+}
+
+func (s *Server) handleUnread(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var marks []protocol.ReadMark
+	for _, m := range s.store.ReadMarks(c.getUserID()) {
+		// Marks in channels since left are kept, should the user come back.
+		if _, err := s.recipient(c, m.Channel); err == nil {
+			marks = append(marks, m)
+		}
+	}
+	c.sendResponse(true, fmt.Sprintf("%d read mark(s)", len(marks)), marks)
+}
+
+func (s *Server) handleReaders(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var p protocol.ReadersPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.ID == "" {
+		c.sendError("readers requires {id}")
+		return
+	}
+	msg := s.store.GetMessage(p.ID)
+	if msg != nil {
+		if _, err := s.recipient(c, msg.Channel); err != nil {
+			msg = nil
+		}
+	}
+	if msg == nil {
+		c.sendError(fmt.Sprintf("no message %q", p.ID))
+		return
+	}
+	res := protocol.ReadersPayload{ID: msg.ID}
+	for _, id := range s.store.Readers(msg) {
+		if u := s.store.GetUserByID(id); u != nil {
+			res.Users = append(res.Users, u.Username)
+		}
+	}
+	slices.Sort(res.Users)
+	c.sendResponse(true, fmt.Sprintf("read by %d", len(res.Users)), res)
+}
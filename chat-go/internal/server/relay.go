@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Relay identities
+// ---------------------------------------------------------------------------
+//
+// A bridge bot passes on messages from many people elsewhere (IRC, Matrix,
+// a mailing list).  Rather than open a connection per person, it logs in
+// once and sets ChatPayload.As to the name each message should appear
+// under.  Each such name is a relay identity: an account of its own
+// (store.SourceRelay), created on first use, so its messages have a stable
+// user ID, but one that cannot log in and belongs to the bot.
+//
+// A bot may only use names that start with a prefix an admin granted it
+// with TypeRelay, e.g. "irc-" for "irc-alice", so it cannot speak as the
+// server's own users.  Relayed messages carry the bot's name in Via.  They
+// go to the main channel and the public channels the bot joined, never
+// to DMs, and are not scheduled; they count against the bot's posting
+// rate.
+
+// Relay grant actions.
+const (
+	ActionRelayGrant  = "relay_grant"
+	ActionRelayRevoke = "relay_revoke"
+)
+
+// relayAs returns c's relay identity called name, for a message to
+// channel.
+func (s *Server) relayAs(c *Client, name, channel string) (*store.User, error) {
+	if protocol.IsDirect(channel) {
+		return nil, fmt.Errorf("relay identities cannot send direct messages")
+	}
+	if sanitizeLine(name) != name {
+		return nil, fmt.Errorf("relay names cannot contain control characters or escape sequences")
+	}
+	return s.store.RelayUser(c.getUserID(), name)
+}
+
+func (s *Server) handleRelay(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("relay grants require the admin role")
+		return
+	}
+	var p protocol.RelayPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("relay requires {} or {user, prefix[, revoke]}")
+		return
+	}
+	if p.User == "" {
+		grants := s.store.RelayGrants()
+		c.sendResponse(true, fmt.Sprintf("%d account(s) with relay grants", len(grants)), grants)
+		return
+	}
+	if p.Prefix == "" {
+		c.sendError("relay requires {user, prefix[, revoke]}")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	action, change, verb := ActionRelayGrant, s.store.GrantRelay, "may now post as"
+	if p.Revoke {
+		action, change, verb = ActionRelayRevoke, s.store.RevokeRelay, "may no longer post as"
+	}
+	if err := change(p.User, p.Prefix); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	s.events.Publish(moderationEvent(c, action, p.User, "prefix "+p.Prefix))
+	c.logger("server").Info(action, "target", p.User, "prefix", p.Prefix)
+	c.sendResponse(true, fmt.Sprintf("%s %s %s…", p.User, verb, p.Prefix), nil)
+}
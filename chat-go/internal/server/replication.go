@@ -0,0 +1,346 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Hot standby replication
+// ---------------------------------------------------------------------------
+//
+// A primary started with Config.ReplicationAddr accepts standby servers on
+// that address.  A standby (Config.StandbyOf) connects, proves it knows
+// ReplicationSecret, and is sent the primary's accounts and message
+// archive: a snapshot, then every entry of the Store's replication log as
+// it is written, with a heartbeat while nothing happens.  Reconnecting to
+// the same run of the primary resumes after the last change applied.
+//
+// A standby serves clients read-only, like maintenance mode, and runs no
+// feeds or inactivity sweeps until it is promoted: by Promote (SIGUSR1 in
+// cmd/server), or on its own once the primary has been unreachable for
+// Config.PromoteAfter.  Promotion stops the tailing and opens the server
+// for writes; sending clients to it (DNS, a load balancer, profiles) is up
+// to the operator, and so is making sure the old primary stays down.
+//
+// The secret and the accounts, password hashes included, cross the link,
+// so it runs over TLS when the primary has a certificate (Config.TLSCert)
+// and the standby is given the CA to check it with (Config.StandbyCA).
+// Without TLS both ends must use a loopback address; reach a primary on
+// another host through a tunnel (ssh -L, stunnel) that ends on loopback.
+
+const (
+	replHeartbeat = 5 * time.Second
+	replTimeout   = 3 * replHeartbeat // silence after which the link is dead
+	replSnapTime  = 2 * time.Minute   // allowance for sending a snapshot
+	replRetry     = 2 * time.Second   // pause before a standby redials
+)
+
+// replHello is a standby's opening line.
+type replHello struct {
+	Secret string `json:"secret"`
+	Run    string `json:"run,omitempty"` // primary run it last followed
+	LSN    uint64 `json:"lsn,omitempty"` // last change applied from that run
+}
+
+// replFrame is a line from the primary.  One with neither Snapshot nor
+// Change is a heartbeat.
+type replFrame struct {
+	Run      string          `json:"run"`
+	Snapshot *store.Snapshot `json:"snapshot,omitempty"`
+	Change   *store.Change   `json:"change,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// standby is a standby server's link to its primary.
+type standby struct {
+	mu       sync.Mutex
+	conn     net.Conn      // current link, closed by Promote
+	promoted chan struct{} // closed by Promote
+	once     sync.Once
+	tls      *tls.Config // nil when the link to the primary is plain TCP
+}
+
+// replicationTLS checks the replication settings of cfg, and returns the
+// TLS settings a standby dials its primary with, if any.
+func replicationTLS(cfg Config) (*tls.Config, error) {
+	if (cfg.ReplicationAddr != "" || cfg.StandbyOf != "") && cfg.ReplicationSecret == "" {
+		return nil, errors.New("replication needs a secret")
+	}
+	if cfg.ReplicationAddr != "" && cfg.TLSCert == "" && !loopback(cfg.ReplicationAddr) {
+		return nil, fmt.Errorf("replication address %q is not a loopback one: serve TLS, or tunnel to loopback", cfg.ReplicationAddr)
+	}
+	if cfg.StandbyOf == "" {
+		return nil, nil
+	}
+	if cfg.StandbyCA == "" {
+		if !loopback(cfg.StandbyOf) {
+			return nil, fmt.Errorf("primary %q is not a loopback address: give its CA to dial it over TLS, or tunnel to loopback", cfg.StandbyOf)
+		}
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(cfg.StandbyOf)
+	if err != nil {
+		return nil, fmt.Errorf("primary %q: %w", cfg.StandbyOf, err)
+	}
+	pem, err := os.ReadFile(cfg.StandbyCA)
+	if err != nil {
+		return nil, fmt.Errorf("standby CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("standby CA: no certificates in %s", cfg.StandbyCA)
+	}
+	return &tls.Config{RootCAs: roots, ServerName: host, MinVersion: tls.VersionTLS12}, nil
+}
+
+// loopback reports whether addr, a host:port, is on this machine only.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ---- primary ----
+
+func (s *Server) serveReplication() {
+	logger("repl").Info("accepting standbys", "addr", s.replLn.Addr().String())
+	for {
+		conn, err := s.replLn.Accept()
+		if err != nil {
+			return // closed by Shutdown
+		}
+		go s.serveStandby(conn)
+	}
+}
+
+// serveStandby streams the replication log to one standby until the link
+// fails or the server shuts down.
+func (s *Server) serveStandby(conn net.Conn) {
+	defer conn.Close()
+	peer := conn.RemoteAddr()
+
+	conn.SetReadDeadline(time.Now().Add(replTimeout))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	var h replHello
+	if err != nil || json.Unmarshal(line, &h) != nil {
+		return
+	}
+	enc := json.NewEncoder(conn)
+	send := func(f replFrame, within time.Duration) error {
+		f.Run = s.runID
+		conn.SetWriteDeadline(time.Now().Add(within))
+		return enc.Encode(f)
+	}
+	if subtle.ConstantTimeCompare([]byte(h.Secret), []byte(s.cfg.ReplicationSecret)) != 1 {
+		send(replFrame{Error: "wrong replication secret"}, replTimeout)
+		logger("repl").Warn("refused a standby: wrong secret", "standby", peer.String())
+		return
+	}
+
+	lsn := h.LSN
+	changes, wake, ok := s.store.ChangesSince(lsn)
+	if h.Run != s.runID || !ok {
+		snap := s.store.ReplicationSnapshot()
+		if err := send(replFrame{Snapshot: &snap}, replSnapTime); err != nil {
+			logger("repl").Warn("standby lost", "standby", peer.String(), "err", err)
+			return
+		}
+		lsn = snap.LSN
+		changes, wake, _ = s.store.ChangesSince(lsn)
+		logger("repl").Info("standby connected, sent a snapshot", "standby", peer.String(), "lsn", lsn)
+	} else {
+		logger("repl").Info("standby resumed", "standby", peer.String(), "lsn", lsn)
+	}
+
+	tick := time.NewTicker(replHeartbeat)
+	defer tick.Stop()
+	for {
+		for _, c := range changes {
+			if err := send(replFrame{Change: &c}, replTimeout); err != nil {
+				logger("repl").Warn("standby lost", "standby", peer.String(), "err", err)
+				return
+			}
+			lsn = c.LSN
+		}
+		select {
+		case <-wake:
+		case <-tick.C:
+			if err := send(replFrame{}, replTimeout); err != nil {
+				logger("repl").Warn("standby lost", "standby", peer.String(), "err", err)
+				return
+			}
+		case <-s.quit:
+			return
+		}
+		if changes, wake, ok = s.store.ChangesSince(lsn); !ok {
+			send(replFrame{Error: "standby fell behind the replication log, or messages were removed"}, replTimeout)
+			logger("repl").Warn("standby needs a new snapshot; it will resync", "standby", peer.String(), "lsn", lsn)
+			return
+		}
+	}
+}
+
+// ---- standby ----
+
+// runStandby follows the primary until the server is promoted or shut
+// down, redialling whenever the link drops.
+func (s *Server) runStandby() {
+	var run string
+	var lsn uint64
+	last := time.Now() // last word from the primary
+	for {
+		err := s.followPrimary(&run, &lsn, &last)
+		select {
+		case <-s.standby.promoted:
+			s.takeOver()
+			return
+		case <-s.quit:
+			return
+		default:
+		}
+		logger("repl").Warn("lost the primary", "primary", s.cfg.StandbyOf, "err", err)
+		if p := s.cfg.PromoteAfter; p > 0 && time.Since(last) >= p {
+			logger("repl").Warn("no word from the primary, promoting", "primary", s.cfg.StandbyOf, "after", p)
+			s.Promote()
+			s.takeOver()
+			return
+		}
+		select {
+		case <-time.After(replRetry):
+		case <-s.standby.promoted:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// followPrimary applies what the primary sends until the link fails.
+func (s *Server) followPrimary(run *string, lsn *uint64, last *time.Time) error {
+	var conn net.Conn
+	var err error
+	if s.standby.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: replTimeout}, "tcp", s.cfg.StandbyOf, s.standby.tls)
+	} else {
+		conn, err = net.DialTimeout("tcp", s.cfg.StandbyOf, replTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.standby.mu.Lock()
+	s.standby.conn = conn
+	s.standby.mu.Unlock()
+	select {
+	case <-s.standby.promoted: // promoted while dialling
+		return nil
+	default:
+	}
+
+	hello, _ := json.Marshal(replHello{Secret: s.cfg.ReplicationSecret, Run: *run, LSN: *lsn})
+	conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	if _, err := conn.Write(append(hello, '\n')); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	timeout := replSnapTime // the first frame may be a large snapshot
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		timeout = replTimeout
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		*last = time.Now()
+		var f replFrame
+		if err := json.Unmarshal(line, &f); err != nil {
+			return fmt.Errorf("bad frame: %w", err)
+		}
+		switch {
+		case f.Error != "":
+			return errors.New(f.Error)
+		case f.Snapshot != nil:
+			if err := s.store.ApplySnapshot(*f.Snapshot); err != nil {
+				return err
+			}
+			*run, *lsn = f.Run, f.Snapshot.LSN
+			logger("repl").Info("loaded a snapshot", "primary", s.cfg.StandbyOf, "lsn", *lsn,
+				"users", len(f.Snapshot.Users), "messages", len(f.Snapshot.Messages))
+		case f.Change != nil:
+			if f.Run != *run || f.Change.LSN != *lsn+1 {
+				*run = "" // resync from a snapshot
+				return fmt.Errorf("change %s/%d does not follow %d", f.Run, f.Change.LSN, *lsn)
+			}
+			if err := s.store.ApplyChange(*f.Change); err != nil {
+				return err
+			}
+			*lsn = f.Change.LSN
+		}
+	}
+}
+
+// Promote makes a standby the primary.  It is safe to call more than once,
+// and does nothing on a server that is not a standby.
+func (s *Server) Promote() {
+	if s.cfg.StandbyOf == "" {
+		return
+	}
+	s.standby.once.Do(func() {
+		close(s.standby.promoted)
+		s.standby.mu.Lock()
+		if s.standby.conn != nil {
+			s.standby.conn.Close()
+		}
+		s.standby.mu.Unlock()
+	})
+}
+
+// isStandby reports whether the server is still following a primary.
+func (s *Server) isStandby() bool {
+	if s.cfg.StandbyOf == "" {
+		return false
+	}
+	select {
+	case <-s.standby.promoted:
+		return false
+	default:
+		return true
+	}
+}
+
+// takeOver finishes a promotion once the link to the old primary is gone:
+// message numbering continues from what was replicated and the server
+// opens for writes.
+func (s *Server) takeOver() {
+	s.hub.reseq <- s.store.LastSeqs()
+	s.maint.set(false, "")
+	s.runJobs()
+	logger("repl").Info("promoted: no longer a standby", "primary", s.cfg.StandbyOf)
+	s.broadcastSystem(protocol.SystemMaintenance, "this server has taken over as the primary; chat is open again")
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Message revisions
+// ---------------------------------------------------------------------------
+//
+// Removing a message from the archive does not forget it: the store keeps
+// a revision of it (see store.Tx.TombstoneMessages), and moderators can
+// look those up with TypeRevisions to see what was said before it went.
+// Direct messages' revisions are shown to admins only, as the messages
+// themselves are.
+
+const (
+	revisionsPage    = 50
+	revisionsMaxPage = 500
+)
+
+func (s *Server) handleRevisions(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleModerator) {
+		c.sendError("message revisions require the moderator role")
+		return
+	}
+	var p protocol.RevisionsPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("revisions requires {[id][, user][, channel][, limit]}")
+		return
+	}
+	limit := p.Limit
+	if limit <= 0 {
+		limit = revisionsPage
+	}
+	limit = min(limit, revisionsMaxPage)
+
+	userID := ""
+	if p.User != "" {
+		u := s.store.GetUser(p.User)
+		if u == nil {
+			c.sendError(fmt.Sprintf("user %q not found", p.User))
+			return
+		}
+		userID = u.ID
+	}
+	channel := p.Channel
+	if protocol.IsPublic(channel) {
+		if channel = protocol.PublicChannel(channel); channel == "" {
+			c.sendError(fmt.Sprintf("no such conversation %s", p.Channel))
+			return
+		}
+	}
+	admin := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin)
+	if protocol.IsDirect(channel) && !admin {
+		c.sendError("revisions of direct messages require the admin role")
+		return
+	}
+
+	revs := s.store.Revisions(func(r *protocol.MessageRevision) bool {
+		m := &r.Message
+		return (p.ID == "" || m.ID == p.ID) &&
+			(userID == "" || m.UserID == userID) &&
+			(channel == "" || m.Channel == channel) &&
+			(admin || !protocol.IsDirect(m.Channel))
+	}, limit)
+	what := "revision(s)"
+	switch {
+	case p.ID != "":
+		what += " of message " + p.ID
+	case userID != "":
+		what += " of messages from " + p.User
+	}
+	if channel != "" {
+		what += " in " + channelName(channel)
+	}
+	c.sendResponse(true, fmt.Sprintf("%d %s", len(revs), what), revs)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Roster
+// ---------------------------------------------------------------------------
+//
+// Besides the join and leave notices meant for people (presence.go), every
+// logged-in session is sent the roster as data: a TypeUserList of everyone
+// online when it logs in, then a TypeUserJoined or TypeUserLeft whenever a
+// user comes or goes, whatever Config.QuietJoins says.  Both are sent with
+// onlineMu held by the change they describe, so a session never hears of a
+// change before its list, or of one its list already has.
+//
+// With Config.PrivateRoster a regular user only sees the users online who
+// share a public channel with them, the main channel aside since everyone
+// is in it; moderators and admins still see everyone.  That goes for the
+// roster, TypeUsers and the join and leave notices alike.  Joining or
+// leaving a channel changes whom a user shares one with, so both sides are
+// sent the TypeUserJoined and TypeUserLeft that bring their rosters up to
+// date.  A user who sees only some of the users online is not told how
+// many there are in all: RosterPayload.Online and SystemPayload.Online are
+// left at zero for them.
+//
+// Sessions that asked for roster diffs get the changes in batches instead
+// (rosterdiff.go).
+
+// rosterPeers returns the IDs of the users who may see the user with the
+// given ID online, and whom that user may see in turn, under
+// Config.PrivateRoster; nil means everyone.
+func (s *Server) rosterPeers(userID string) map[string]bool {
+	if !s.cfg.PrivateRoster {
+		return nil
+	}
+	return s.store.ChannelPeers(userID)
+}
+
+// seesAll reports whether c sees everyone online whatever the peers.
+func seesAll(c *Client) bool {
+	return store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleModerator)
+}
+
+// sees reports whether c may see a user online whose rosterPeers are
+// peers.
+func sees(c *Client, peers map[string]bool) bool {
+	return peers == nil || peers[c.getUserID()] || seesAll(c)
+}
+
+// rosterPacket builds the TypeUserJoined or TypeUserLeft about u, leaving
+// online users.
+func rosterPacket(t protocol.MessageType, u protocol.UserInfo, online int) *protocol.Packet {
+	pkt, _ := protocol.NewPacket(t, protocol.RosterPayload{User: u, Online: online})
+	return pkt
+}
+
+// sendRosterLocked tells every logged-in session, other than c, that may
+// see c's user that the user came online or went offline.  peers are the
+// user's rosterPeers.  onlineMu must be held.
+func (s *Server) sendRosterLocked(t protocol.MessageType, c *Client, peers map[string]bool) {
+	u := protocol.UserInfo{UserID: c.getUserID(), Username: c.getUsername()}
+	all, mine := rosterPacket(t, u, len(s.online)), rosterPacket(t, u, 0)
+	online := t == protocol.TypeUserJoined
+	for _, sc := range s.sessions {
+		switch {
+		case sc == c || !sees(sc, peers):
+		case peers == nil || seesAll(sc):
+			tellLocked(sc, u, online, all)
+		default:
+			tellLocked(sc, u, online, mine)
+		}
+	}
+}
+
+// sendUserListLocked sends c everyone online whom it may see.  peers are
+// the rosterPeers of c's user.  onlineMu must be held.
+func (s *Server) sendUserListLocked(c *Client, peers map[string]bool) {
+	if seesAll(c) {
+		peers = nil
+	}
+	users := make([]protocol.UserInfo, 0, len(s.online))
+	for _, o := range s.online {
+		if peers == nil || peers[o.getUserID()] {
+			users = append(users, protocol.UserInfo{UserID: o.getUserID(), Username: o.getUsername()})
+		}
+	}
+	l := protocol.UserListPayload{Users: users}
+	if c.roster != nil {
+		l.Rev = c.roster.listed()
+	}
+	pkt, err := protocol.NewPacket(protocol.TypeUserList, l)
+	if err != nil {
+		return
+	}
+	c.sendPacket(pkt)
+}
+
+// resendRoster brings rosters up to date after the user with the given ID
+// joined or left a public channel, given their rosterPeers before.  Each
+// pair of users online who now see each other, or no longer do, is told so
+// on both sides.
+func (s *Server) resendRoster(userID string, before map[string]bool) {
+	if before == nil {
+		return
+	}
+	after := s.rosterPeers(userID)
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	me, ok := s.online[userID]
+	if !ok {
+		return
+	}
+	for id, o := range s.online {
+		if before[id] == after[id] {
+			continue
+		}
+		t := protocol.TypeUserLeft
+		if after[id] {
+			t = protocol.TypeUserJoined
+		}
+		s.sendPeerLocked(userID, t, protocol.UserInfo{UserID: id, Username: o.getUsername()})
+		s.sendPeerLocked(id, t, protocol.UserInfo{UserID: userID, Username: me.getUsername()})
+	}
+}
+
+// sendPeerLocked tells the sessions of the user with the given ID that see
+// only their peers that u came online or went offline, as t says.
+// onlineMu must be held.
+func (s *Server) sendPeerLocked(userID string, t protocol.MessageType, u protocol.UserInfo) {
+	pkt := rosterPacket(t, u, 0)
+	for _, sc := range s.sessions {
+		if sc.getUserID() == userID && !seesAll(sc) {
+			tellLocked(sc, u, t == protocol.TypeUserJoined, pkt)
+		}
+	}
+}
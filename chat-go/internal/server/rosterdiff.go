@@ -0,0 +1,171 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Roster diffs
+// ---------------------------------------------------------------------------
+//
+// On a busy server the roster changes all the time, and a TypeUserJoined or
+// TypeUserLeft per change to every session adds up.  With
+// Config.RosterBatch a session that logs in with AuthPayload.RosterDiffs
+// gets the changes gathered instead: every RosterBatch, if anything
+// changed, one TypeRosterDiff with each user's latest change, so a user who
+// drops and reconnects in between costs one entry or none.  Every
+// RosterSnapshot the session is sent the whole roster again, in case it
+// missed a diff its queue had no room for, and it can ask for it at any
+// time with TypeRoster.  The list and the diffs are numbered (Rev) so the
+// client can tell when it missed one.
+//
+// The diffs say what the immediate packets would have, private roster
+// included: sendRosterLocked and sendPeerLocked add a change to a diffing
+// session's pending diff rather than send it.
+
+const defaultRosterSnapshot = 10 * time.Minute
+
+// rosterDiff is the diff state of a session that asked for diffs.
+type rosterDiff struct {
+	mu      sync.Mutex
+	rev     uint64                  // of the latest list or diff sent
+	changes map[string]rosterChange // by user ID, since then
+	listAt  time.Time               // when the latest list was sent
+}
+
+// rosterChange is the latest change of a user: online or not.
+type rosterChange struct {
+	user   protocol.UserInfo
+	online bool
+}
+
+// add records that u came online or went offline.
+func (d *rosterDiff) add(u protocol.UserInfo, online bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.changes == nil {
+		d.changes = make(map[string]rosterChange)
+	}
+	d.changes[u.UserID] = rosterChange{u, online}
+}
+
+// listed numbers a list about to be sent, dropping the changes it shows.
+func (d *rosterDiff) listed() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rev++
+	d.changes = nil
+	d.listAt = time.Now()
+	return d.rev
+}
+
+// take returns the pending diff, leaving Online for the caller, or false
+// when nothing changed.
+func (d *rosterDiff) take() (protocol.RosterDiffPayload, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.changes) == 0 {
+		return protocol.RosterDiffPayload{}, false
+	}
+	d.rev++
+	p := protocol.RosterDiffPayload{Rev: d.rev}
+	for id, ch := range d.changes {
+		if ch.online {
+			p.Joined = append(p.Joined, ch.user)
+		} else {
+			p.Left = append(p.Left, id)
+		}
+	}
+	slices.SortFunc(p.Joined, func(a, b protocol.UserInfo) int { return cmp.Compare(a.UserID, b.UserID) })
+	slices.Sort(p.Left)
+	d.changes = nil
+	return p, true
+}
+
+// listDue reports whether the whole list is due again.
+func (d *rosterDiff) listDue(every time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return time.Since(d.listAt) >= every
+}
+
+// tellLocked tells sc that u came online or went offline: at once with
+// pkt, or in its next diff.  onlineMu must be held.
+func tellLocked(sc *Client, u protocol.UserInfo, online bool, pkt *protocol.Packet) {
+	if sc.roster != nil {
+		sc.roster.add(u, online)
+		return
+	}
+	sc.sendPacket(pkt)
+}
+
+// runRosterDiffs must be launched as a goroutine; it returns when s.quit
+// is closed.
+func (s *Server) runRosterDiffs() {
+	every := s.cfg.RosterSnapshot
+	if every <= 0 {
+		every = defaultRosterSnapshot
+	}
+	t := time.NewTicker(s.cfg.RosterBatch)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.quit:
+			return
+		}
+		for _, c := range s.flushRosterDiffs(every) {
+			s.resendUserList(c)
+		}
+	}
+}
+
+// flushRosterDiffs sends every diffing session its pending diff, and
+// returns those whose whole list is due instead.
+func (s *Server) flushRosterDiffs(every time.Duration) (due []*Client) {
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, sc := range s.sessions {
+		switch {
+		case sc.roster == nil:
+		case sc.roster.listDue(every):
+			due = append(due, sc)
+		default:
+			p, ok := sc.roster.take()
+			if !ok {
+				continue
+			}
+			if !s.cfg.PrivateRoster || seesAll(sc) {
+				p.Online = len(s.online)
+			}
+			if pkt, err := protocol.NewPacket(protocol.TypeRosterDiff, p); err == nil {
+				sc.sendPacket(pkt)
+			}
+		}
+	}
+	return due
+}
+
+// resendUserList sends c the whole roster again, if it is still online.
+func (s *Server) resendUserList(c *Client) {
+	peers := s.rosterPeers(c.getUserID())
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	if s.sessions[c.id] == c {
+		s.sendUserListLocked(c, peers)
+	}
+}
+
+// handleRoster sends the caller the whole roster again.
+func (s *Server) handleRoster(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	s.resendUserList(c)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ---------------------------------------------------------------------------
+// Sanitization
+// ---------------------------------------------------------------------------
+//
+// Text that other users will see in a terminal is cleaned before it is
+// stored or sent on: message content and metadata (see kinds.go),
+// announcements, topics, polls, feed entries and file names.  Cleaning
+// removes
+//
+//   - escape sequences (CSI, OSC, DCS and the like, in their 7-bit ESC
+//     form and 8-bit C1 form), which could move the cursor, rewrite the
+//     screen, set the window title or plant a misleading OSC 8 hyperlink;
+//   - every other control character except newline and tab;
+//   - the bidirectional overrides and isolates, which can make a link or
+//     a command read differently from what it is;
+//
+// and replaces invalid UTF-8 with U+FFFD.  Usernames are not cleaned but
+// refused when cleaning would change them.
+
+// sanitizeText cleans multi-line text, keeping newlines and tabs.
+func sanitizeText(s string) string { return sanitize(s, true) }
+
+// sanitizeLine cleans single-line text; newlines and tabs become spaces.
+func sanitizeLine(s string) string { return sanitize(s, false) }
+
+func sanitize(s string, multiline bool) string {
+	if isClean(s, multiline) {
+		return s
+	}
+	rs := []rune(strings.ToValidUTF8(strings.ReplaceAll(s, "\r\n", "\n"), "�"))
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(rs); i++ {
+		switch r := rs[i]; {
+		case r == 0x1b || isC1Introducer(r):
+			i = skipEscape(rs, i)
+		case r == '\n' || r == '\t':
+			if multiline {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte(' ')
+			}
+		case unicode.IsControl(r) || isBidiControl(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isClean reports whether s needs no cleaning, the common case.
+func isClean(s string, multiline bool) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\t':
+			if !multiline {
+				return false
+			}
+		case unicode.IsControl(r) || isBidiControl(r):
+			return false
+		}
+	}
+	return true
+}
+
+func isBidiControl(r rune) bool {
+	return r >= 0x202a && r <= 0x202e || r >= 0x2066 && r <= 0x2069
+}
+
+// isC1Introducer reports whether r starts an 8-bit escape sequence: CSI,
+// or one of the string controls.
+func isC1Introducer(r rune) bool {
+	switch r {
+	case 0x9b, 0x90, 0x98, 0x9d, 0x9e, 0x9f:
+		return true
+	}
+	return false
+}
+
+// skipEscape returns the index of the last rune of the escape sequence
+// starting at rs[i].  An unterminated sequence runs to the end of rs.
+func skipEscape(rs []rune, i int) int {
+	kind := rs[i]
+	j := i + 1
+	if kind == 0x1b {
+		if j == len(rs) {
+			return i
+		}
+		switch rs[j] {
+		case '[':
+			kind = 0x9b
+		case 'P':
+			kind = 0x90
+		case 'X':
+			kind = 0x98
+		case ']':
+			kind = 0x9d
+		case '^':
+			kind = 0x9e
+		case '_':
+			kind = 0x9f
+		}
+		if kind != 0x1b {
+			j++
+		}
+	}
+	switch kind {
+	case 0x1b: // ESC, intermediates, final
+		for j < len(rs) && rs[j] >= 0x20 && rs[j] <= 0x2f {
+			j++
+		}
+		if j < len(rs) && rs[j] >= 0x30 && rs[j] <= 0x7e {
+			return j
+		}
+		return j - 1
+	case 0x9b: // CSI, parameters and intermediates, final
+		for j < len(rs) && rs[j] >= 0x20 && rs[j] <= 0x3f {
+			j++
+		}
+		if j < len(rs) && rs[j] >= 0x40 && rs[j] <= 0x7e {
+			return j
+		}
+		return j - 1
+	}
+	// A control string, ended by ST (ESC \ or 0x9c) or, for OSC, BEL.
+	for ; j < len(rs); j++ {
+		switch {
+		case rs[j] == 0x9c, rs[j] == 0x07 && kind == 0x9d:
+			return j
+		case rs[j] == 0x1b && j+1 < len(rs) && rs[j+1] == '\\':
+			return j + 1
+		}
+	}
+	return len(rs) - 1
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"time"
+
+	"chat/internal/protocol"
+)
+
+const (
+	schedulerTick       = time.Second
+	maxScheduledPerUser = 20
+	maxScheduleAhead    = 30 * 24 * time.Hour
+)
+
+// runScheduler delivers scheduled messages once their SendAt time arrives.
+// Pending messages live in the Store, so anything due while the server was
+// down goes out on the first tick after a restart.  It must be launched as a
+// goroutine and returns when s.quit is closed.
+func (s *Server) runScheduler() {
+	t := time.NewTicker(schedulerTick)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			if s.maint.get() != "" {
+				continue // held until maintenance ends
+			}
+			due, err := s.store.TakeDueScheduled(now.UTC())
+			if err != nil {
+				logger("store").Error("saving scheduled messages failed", "err", err)
+			}
+			for _, sm := range due {
+				if why := s.refuseScheduled(sm, now); why != "" {
+					logger("scheduler").Info("dropped: "+why, "msg_id", sm.ID, "user", sm.Username, "channel", sm.Channel)
+					continue
+				}
+				s.post(&protocol.StoredMessage{
+					ID:         sm.ID,
+					Channel:    sm.Channel,
+					To:         sm.To,
+					UserID:     sm.UserID,
+					Username:   sm.Username,
+					Content:    sm.Content,
+					Timestamp:  now.UTC(),
+					Reply:      sm.Reply,
+					Attachment: sm.Attachment,
+					Kind:       sm.Kind,
+					Meta:       sm.Meta,
+				})
+				logger("scheduler").Debug("delivered", "msg_id", sm.ID, "user", sm.Username)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// refuseScheduled runs handleChat's checks on the author of sm again, since
+// they may have lost the right to post it since scheduling it, and returns
+// why it is dropped, or "" when it may go out.
+func (s *Server) refuseScheduled(sm *protocol.ScheduledMessage, now time.Time) string {
+	u := s.store.GetUserByID(sm.UserID)
+	switch {
+	case u == nil || u.Deactivated():
+		return "the author's account is gone"
+	case u.Banned() || u.Muted(now):
+		return "the author is banned or muted"
+	}
+	if _, err := s.recipientFor(u.ID, sm.Channel); err != nil {
+		return "the author can no longer post in the conversation"
+	}
+	if protocol.IsPublic(sm.Channel) && !s.store.MayPost(sm.Channel, u.ID) {
+		if s.store.ChannelArchived(sm.Channel) {
+			return "the channel is archived"
+		}
+		return "the channel is an announcement channel"
+	}
+	return ""
+}
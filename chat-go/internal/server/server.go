@@ -8,15 +8,23 @@
 //  │  Accepts TCP connections; spawns readPump + writePump    │
 //  │  goroutines for each Client.                             │
 //  └───────────────────┬─────────────────────────────────────┘
+//                      │  handlers publish Events
+//                      ▼
+//  ┌─────────────────────────────────────────────────────────┐
+//  │  Event bus  (events.go)                                  │
+//  │  Hands every post, join, leave and moderation action to  │
+//  │  its subscribers: Hub, Worker Pool, metrics, plugins.    │
+//  └───────────────────┬─────────────────────────────────────┘
 //                      │  register / unregister / broadcast channels
 //                      ▼
 //  ┌─────────────────────────────────────────────────────────┐
 //  │  Hub goroutine                                           │
-//  │  Owns the clients map; fans out broadcasts.              │
+//  │  Owns the clients map; numbers chat messages and fans    │
+//  │  out broadcasts, so every client sees the same order.    │
 //  └─────────────────────────────────────────────────────────┘
 //
 //  ┌─────────────────────────────────────────────────────────┐
-//  │  Worker Pool  (N goroutines)                             │
+//  │  Worker Pool  (min–max goroutines, sized to the load)    │
 //  │  Asynchronously persist messages to disk so the hot      │
 //  │  broadcast path is never blocked by I/O.                 │
 //  └─────────────────────────────────────────────────────────┘
@@ -28,95 +36,359 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"chat/internal/auth"
 	"chat/internal/protocol"
 	"chat/internal/store"
 )
 
 // ---------------------------------------------------------------------------
-// Worker pool – async message persistence
+// Server
 // ---------------------------------------------------------------------------
 
-// workerPool persists chat messages in the background so the broadcast path
-// (which runs inside the Hub goroutine) is never blocked by disk I/O.
-type workerPool struct {
-	jobs chan *protocol.StoredMessage
-	wg   sync.WaitGroup
-}
+// Limits enforced on client requests and advertised in the hello packet.
+const (
+	maxContentLength = 2000 // characters per chat message
+	maxHistory       = 500  // messages per history request
+	maxQuoteLength   = 80   // characters of the parent kept in a reply quote
+	maxKindLength    = 32   // bytes in a message kind name
+	maxMetaSize      = 4096 // bytes of structured message metadata
+)
 
-func newWorkerPool(n int, s *store.Store) *workerPool {
-	p := &workerPool{
-		jobs: make(chan *protocol.StoredMessage, 1024),
-	}
-	for i := 0; i < n; i++ {
-		p.wg.Add(1)
-		go func() {
-			defer p.wg.Done()
-			for msg := range p.jobs {
-				if err := s.SaveMessage(msg); err != nil {
-					log.Printf("[store] save error: %v", err)
-				}
-			}
-		}()
-	}
-	return p
-}
+// Config holds the settings used to construct a Server.
+type Config struct {
+	DataDir string // where users.json and the message archive live
 
-func (p *workerPool) submit(msg *protocol.StoredMessage) {
-	// Non-blocking submit; drop silently if the queue is full.
-	select {
-	case p.jobs <- msg:
-	default:
-		log.Printf("[pool] job queue full – message dropped from persistence")
-	}
-}
+	// MinWorkers and MaxWorkers bound the persistence pool, which resizes
+	// itself with the load (see pool.go).  Zero means 1 and 16.
+	MinWorkers int
+	MaxWorkers int
 
-func (p *workerPool) stop() {
-	close(p.jobs)
-	p.wg.Wait()
-}
+	// Durability is how hard the message archive tries to reach the disk:
+	// one of the store.Durability* levels, "" meaning async.
+	Durability string
 
-// ---------------------------------------------------------------------------
-// Server
-// ---------------------------------------------------------------------------
+	// Auth, when non-nil, verifies logins against an external account
+	// database instead of the Store.  Self-service registration is disabled
+	// while an external provider is configured.
+	Auth auth.Provider
+
+	// Tokens, when non-nil, signs a session token on every successful login
+	// so the client can later log in again without a password.
+	Tokens *auth.Keyring
+
+	// HTTPAddr enables the HTTP sidecar (file upload/download) when set.
+	// PublicURL is the sidecar's externally reachable base URL; it defaults
+	// to http://<HTTPAddr>.
+	HTTPAddr      string
+	PublicURL     string
+	MaxUploadSize int64         // bytes; 0 means defaultMaxUploadSize
+	UploadTypes   []string      // allowed media types; nil means DefaultUploadTypes
+	MaxClipLength time.Duration // of voice clips; 0 means defaultMaxClip
+
+	// ReadOnly starts the server in maintenance mode with the given reason
+	// (see maintenance.go); admins can turn it off at runtime.
+	ReadOnly       bool
+	ReadOnlyReason string
+
+	// ShutdownGrace is how long Shutdown counts down, warning users, before
+	// closing connections.  Zero shuts down immediately.
+	ShutdownGrace time.Duration
+
+	// MaxBytesPerSec caps each connection's traffic in each direction.
+	// Zero means unlimited.
+	MaxBytesPerSec int64
+
+	// Overflow is what the Hub does when a client's send buffer is full:
+	// OverflowDisconnect (the default), OverflowSkip or OverflowSpill.
+	// Under OverflowDisconnect a client has SlowGrace to catch up before
+	// it is dropped (see slow.go); zero drops it at once.
+	Overflow  string
+	SlowGrace time.Duration
+
+	// WriteTimeout bounds each write to a client, SendBuffer is how many
+	// packets may queue for one, and MaxPacketSize is the longest line a
+	// client may send, and the longest batch frame it is sent.  Zero takes
+	// the default; see client.go for the defaults and the ranges allowed.
+	WriteTimeout  time.Duration
+	SendBuffer    int
+	MaxPacketSize int
+
+	// TLSCert and TLSKey, when both set, are PEM files for serving the chat
+	// protocol over TLS.
+	TLSCert string
+	TLSKey  string
+
+	// Feeds, when non-nil, lists RSS/Atom feeds whose new entries are
+	// posted by a bot account (see feeds.go).
+	Feeds *FeedsConfig
+
+	// Inactive, when non-nil, flags and then deactivates or deletes
+	// accounts nobody uses (see inactive.go).
+	Inactive *InactivityPolicy
+
+	// Mailer, when non-nil, is used to email users, e.g. inactivity
+	// warnings.
+	Mailer *Mailer
+
+	// Lockout, when non-nil, locks accounts after repeated wrong
+	// passwords.  With Notifiers set, owners can unlock them with a code
+	// sent through those (see lockout.go).
+	Lockout   *LockoutPolicy
+	Notifiers []Notifier
+
+	// Access, when non-nil, decides which addresses may connect (see
+	// access.go).
+	Access *AccessPolicy
+
+	// AuthHooks, when non-nil, tells an external service about new
+	// accounts and lets it refuse registrations (see authhooks.go).
+	AuthHooks *AuthHooks
+
+	// RegisterWork, when positive, makes clients solve a proof-of-work
+	// challenge of this many bits to register (see pow.go).
+	RegisterWork int
+
+	// Admins are usernames given the admin role at startup, or when the
+	// account is registered, so a new server has an admin (see
+	// moderation.go).
+	Admins []string
+
+	// Signing, when non-nil, signs the messages the server posts and
+	// verifies the origin of those bridged from its peers (see
+	// signing.go).
+	Signing *Signing
+
+	// WordFilter, when non-nil, rejects, masks or flags the messages
+	// posted in public channels that use its words (see wordfilter.go).
+	WordFilter *WordFilter
+
+	// Transformer, when non-nil, renders messages for readers who set a
+	// locale, e.g. translates them (see translate.go).
+	Transformer Transformer
+
+	// SpoolWindow, when positive, keeps the messages a user misses for
+	// this long after their last session ends, for a client that logs in
+	// again with AuthPayload.CatchUp (see spool.go).
+	SpoolWindow time.Duration
+
+	// QuietJoins, when positive, stops announcing each login once more
+	// than this many users are online; joins and leaves are summed up
+	// every JoinSummary instead, a minute when zero (see presence.go).
+	QuietJoins  int
+	JoinSummary time.Duration
+
+	// PrivateRoster shows regular users only the users online who share a
+	// public channel with them; moderators and admins see everyone (see
+	// roster.go).
+	PrivateRoster bool
+
+	// RosterBatch, when positive, sends the sessions that ask for it
+	// (AuthPayload.RosterDiffs) the roster's changes as one diff every
+	// RosterBatch, and the whole roster every RosterSnapshot, ten minutes
+	// when zero (see rosterdiff.go).
+	RosterBatch    time.Duration
+	RosterSnapshot time.Duration
+
+	// NamePolicy, when set, restricts the names of new public channels
+	// and channel topics (see namepolicy.go).
+	NamePolicy *NamePolicy
+
+	// Moderator, when set, judges the messages posted outside DMs before
+	// they go out, within ModerationTimeout, five seconds when zero.  When
+	// it fails they are posted anyway, or with ModerationFailClosed
+	// refused (see moderator.go).
+	Moderator            Moderator
+	ModerationTimeout    time.Duration
+	ModerationFailClosed bool
+
+	// RoleLimits, when set, gives roles their own message length, upload
+	// size and posting rate (see limits.go).  PostRate, in messages a
+	// second, and PostBurst, messages at once (zero: a minute's worth),
+	// limit the posting of roles that set no rate of their own.
+	RoleLimits map[string]RoleLimits
+	PostRate   float64
+	PostBurst  int
+
+	// TimestampGranularity and TimestampFuzz, when positive, round down
+	// and then blur the message times clients are sent (see
+	// timestamps.go).
+	TimestampGranularity time.Duration
+	TimestampFuzz        time.Duration
+
+	// ReplicationAddr, when set, accepts standby servers on this address.
+	// StandbyOf, when set, makes this server a read-only standby of the
+	// primary whose ReplicationAddr it names, until Promote is called or,
+	// with PromoteAfter, the primary has been unreachable that long.  Both
+	// ends need the same ReplicationSecret (see replication.go).  A primary
+	// with TLSCert serves standbys over TLS, and a standby with StandbyCA
+	// (a PEM file) dials its primary over TLS and verifies it with that;
+	// without TLS the address must be a loopback one.
+	ReplicationAddr   string
+	ReplicationSecret string
+	StandbyOf         string
+	StandbyCA         string
+	PromoteAfter      time.Duration
+
+	// SlowQuery, when positive, logs the store operations that take
+	// longer, such as searches through a large archive.  They are timed
+	// for /metrics either way.
+	SlowQuery time.Duration
+}
 
 // Server ties together the Hub, Store, and WorkerPool.
 type Server struct {
+	cfg      Config
 	hub      *Hub
 	store    *store.Store
 	pool     *workerPool
+	auth     auth.Provider
+	tokens   *auth.Keyring
 	listener net.Listener
+	tlsConf  *tls.Config  // nil when serving plain TCP
+	stamps   *stampPolicy // nil when timestamps are sent as they are
 
 	// online tracks authenticated clients for /users queries.
 	// A separate RWMutex is used here so listing online users does not
 	// require a round-trip through the Hub's event channel.
 	onlineMu sync.RWMutex
 	online   map[string]*Client // userID → Client
+	sessions map[string]*Client // connID → Client, every authenticated connection
 
 	connID atomic.Uint64 // monotonically increasing connection counter
+	quit   chan struct{} // closed by Shutdown to stop background goroutines
+	maint  maintenance   // read-only switch
+
+	events      Bus         // see events.go
+	eventCounts eventCounts // tallied by the metrics subscriber
+
+	spools   spools   // see spool.go
+	presence presence // joins and leaves not yet announced, see presence.go
+
+	traffic   usage        // totals over all connections, see usage.go
+	openConns atomic.Int64 // currently open TCP connections
+
+	shuttingDown atomic.Bool
+	hurry        chan struct{} // closed by a second Shutdown to end the countdown
+	hurryOnce    sync.Once
+
+	roleLimits atomic.Pointer[map[string]RoleLimits] // Config.RoleLimits, or what the console reloaded
+
+	rejects rejections  // connections refused by Config.Access
+	bulk    bulkTokens  // outstanding bulk moderation confirmations
+	unlocks unlockCodes // outstanding account unlock codes
+	exports exportJobs  // personal data exports, running or ready
+	held    heldMessages // held for review by Config.Moderator
+
+	// Replication; see replication.go.
+	runID   string       // this run of the server, as a primary
+	replLn  net.Listener // accepts standbys
+	standby standby
+
+	// HTTP sidecar state; see http.go.
+	httpSrv    *http.Server
+	fileMu     sync.Mutex
+	fileTokens map[string]fileGrant // bearer token → grant
 }
 
-// New creates a Server.  dataDir is where users.json and messages.json live.
-// workers controls the number of persistence goroutines in the pool.
-func New(dataDir string, workers int) (*Server, error) {
-	st, err := store.New(dataDir)
+// New creates a Server from cfg.
+func New(cfg Config) (*Server, error) {
+	if err := validOverflow(cfg.Overflow); err != nil {
+		return nil, err
+	}
+	if err := store.ValidDurability(cfg.Durability); err != nil {
+		return nil, err
+	}
+	standbyTLS, err := replicationTLS(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := connSettings(&cfg); err != nil {
+		return nil, err
+	}
+	if err := validRoleLimits(cfg.RoleLimits, cfg.MaxPacketSize); err != nil {
+		return nil, err
+	}
+	if err := validRegisterWork(cfg.RegisterWork); err != nil {
+		return nil, err
+	}
+	stamps, err := newStampPolicy(cfg.TimestampGranularity, cfg.TimestampFuzz)
 	if err != nil {
 		return nil, err
 	}
-	h := newHub()
-	return &Server{
-		hub:    h,
-		store:  st,
-		pool:   newWorkerPool(workers, st),
-		online: make(map[string]*Client),
-	}, nil
+	minWorkers, maxWorkers, err := poolBounds(cfg)
+	if err != nil {
+		return nil, err
+	}
+	st, err := store.New(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	st.SetDurability(cfg.Durability)
+	st.SetSlowLog(cfg.SlowQuery)
+	h := newHub(cfg.Overflow, cfg.SlowGrace, st.LastSeqs())
+	s := &Server{
+		cfg:      cfg,
+		stamps:   stamps,
+		hub:      h,
+		store:    st,
+		pool:     newWorkerPool(minWorkers, maxWorkers, st),
+		auth:     cfg.Auth,
+		tokens:   cfg.Tokens,
+		online:   make(map[string]*Client),
+		sessions: make(map[string]*Client),
+		quit:     make(chan struct{}),
+		hurry:    make(chan struct{}),
+		runID:    newRunID(),
+		standby:  standby{promoted: make(chan struct{}), tls: standbyTLS},
+
+		fileTokens: make(map[string]fileGrant),
+	}
+	h.onPost = s.deliverPost
+	s.roleLimits.Store(&cfg.RoleLimits)
+	s.subscribeCore()
+	if cfg.ReadOnly {
+		s.maint.set(true, cfg.ReadOnlyReason)
+	}
+	if cfg.StandbyOf != "" {
+		s.maint.set(true, "standby of "+cfg.StandbyOf+"; read-only until promoted")
+	} else {
+		s.promoteAdmins()
+	}
+	if cfg.ReplicationAddr != "" {
+		st.EnableReplicationLog()
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		s.tlsConf = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if cfg.Overflow == OverflowSpill {
+		// Queues left by a previous run belong to connections that no
+		// longer exist.
+		os.RemoveAll(s.spillDir())
+		if err := os.MkdirAll(s.spillDir(), 0o700); err != nil {
+			return nil, fmt.Errorf("spill dir: %w", err)
+		}
+	}
+	// Exports of a previous run can no longer be asked for.
+	os.RemoveAll(s.exportDir())
+	return s, nil
 }
 
 // ListenAndServe starts the Hub and then accepts TCP connections on addr.
@@ -125,10 +397,46 @@ func (s *Server) ListenAndServe(addr string) error {
 	if err != nil {
 		return err
 	}
+	if s.tlsConf != nil {
+		ln = tls.NewListener(ln, s.tlsConf)
+	}
 	s.listener = ln
-	log.Printf("[server] listening on %s", addr)
+	if s.tlsConf != nil {
+		logger("server").Info("listening", "addr", addr, "tls", true)
+	} else {
+		logger("server").Info("listening", "addr", addr)
+	}
 
-	go s.hub.Run()
+	s.hub.Start()
+	go s.runScheduler()
+	go s.runMonitor()
+	go s.runRateLimits()
+	if s.cfg.QuietJoins > 0 {
+		go s.runPresence()
+	}
+	if s.cfg.RosterBatch > 0 {
+		go s.runRosterDiffs()
+	}
+	if s.cfg.StandbyOf != "" {
+		go s.runStandby()
+	} else {
+		s.runJobs()
+	}
+	if s.cfg.ReplicationAddr != "" {
+		s.replLn, err = net.Listen("tcp", s.cfg.ReplicationAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		if s.tlsConf != nil {
+			s.replLn = tls.NewListener(s.replLn, s.tlsConf)
+		}
+		go s.serveReplication()
+	}
+	if s.cfg.HTTPAddr != "" {
+		s.httpSrv = s.newHTTPServer(s.cfg.HTTPAddr)
+		go s.serveHTTP()
+	}
 
 	for {
 		conn, err := ln.Accept()
@@ -136,17 +444,49 @@ func (s *Server) ListenAndServe(addr string) error {
 			// Closed by Shutdown.
 			return nil
 		}
+		if !s.admit(conn) {
+			conn.Close()
+			continue
+		}
 		go s.serveConn(conn)
 	}
 }
 
-// Shutdown cleanly stops the server.
+// runJobs starts the background jobs that change state on their own; a
+// standby starts them when it is promoted.
+func (s *Server) runJobs() {
+	if s.cfg.Feeds != nil {
+		s.runFeeds()
+	}
+	if s.cfg.Inactive != nil {
+		go s.runInactive()
+	}
+}
+
+// Shutdown cleanly stops the server.  With Config.ShutdownGrace set it first
+// broadcasts a countdown; calling Shutdown again meanwhile cuts it short.
 func (s *Server) Shutdown() {
+	if !s.shuttingDown.CompareAndSwap(false, true) {
+		s.hurryOnce.Do(func() { close(s.hurry) })
+		return
+	}
+	s.countdown(s.cfg.ShutdownGrace)
+
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	close(s.quit)
+	if s.httpSrv != nil {
+		s.httpSrv.Close()
+	}
+	if s.replLn != nil {
+		s.replLn.Close()
+	}
 	s.hub.Stop()
 	s.pool.stop()
+	if err := s.store.Flush(); err != nil {
+		logger("store").Error("flush failed", "err", err)
+	}
 }
 
 // serveConn creates a Client for conn and launches its read/write pumps.
@@ -154,39 +494,166 @@ func (s *Server) serveConn(conn net.Conn) {
 	id := fmt.Sprintf("conn-%d", s.connID.Add(1))
 	c := newClient(id, conn, s)
 	s.hub.register <- c
+	s.openConns.Add(1)
+	defer s.openConns.Add(-1)
 
 	// writePump runs in its own goroutine; readPump runs in this one.
 	go c.writePump()
-	c.sendSystem("Welcome to GoChat! Use /register or /login to get started.")
+	hello, _ := protocol.NewPacket(protocol.TypeHello, s.hello())
+	c.sendPacket(hello)
+	c.sendSystem(protocol.SystemWelcome, "Welcome to GoChat! Use /register or /login to get started.")
 	c.readPump()
 }
 
+// hello describes what this server supports so clients can adapt their UI.
+func (s *Server) hello() protocol.HelloPayload {
+	features := []string{
+		protocol.FeatureSessions,
+		protocol.FeatureBatch,
+		protocol.FeatureSchedule,
+		protocol.FeaturePolls,
+		protocol.FeatureKinds,
+		protocol.FeatureSearchQuery,
+		protocol.FeatureFuzzySearch,
+		protocol.FeatureSearchScope,
+		protocol.FeatureSearchSort,
+		protocol.FeatureMaintenance,
+		protocol.FeatureMaintWindow,
+		protocol.FeatureAnnounce,
+		protocol.FeatureBulk,
+		protocol.FeatureUsage,
+		protocol.FeatureStats,
+		protocol.FeatureDM,
+		protocol.FeatureSeq,
+		protocol.FeatureUserSearch,
+		protocol.FeatureChannels,
+		protocol.FeatureChannelMode,
+		protocol.FeaturePresence,
+		protocol.FeatureMute,
+		protocol.FeatureRelay,
+		protocol.FeatureRevisions,
+		protocol.FeatureDelete,
+		protocol.FeatureSystemKinds,
+		protocol.FeatureTyping,
+		protocol.FeatureRoster,
+		protocol.FeatureErrorCodes,
+		protocol.FeatureReads,
+		protocol.FeatureMentions,
+		protocol.FeatureArchive,
+		protocol.FeatureModeration,
+		protocol.FeatureNoEcho,
+	}
+	if s.auth == nil {
+		features = append(features, protocol.FeatureRegister)
+	}
+	if s.powRequired() {
+		features = append(features, protocol.FeatureProofOfWork)
+	}
+	if s.tokens != nil {
+		features = append(features, protocol.FeatureTokens)
+	}
+	if s.cfg.HTTPAddr != "" {
+		features = append(features, protocol.FeatureAttachments, protocol.FeatureExport, protocol.FeatureClips)
+	}
+	if s.cfg.SpoolWindow > 0 {
+		features = append(features, protocol.FeatureCatchUp)
+	}
+	if s.cfg.Transformer != nil {
+		features = append(features, protocol.FeatureTranslate)
+	}
+	if s.selfUnlock() {
+		features = append(features, protocol.FeatureUnlock)
+	}
+	if s.cfg.Signing != nil {
+		features = append(features, protocol.FeatureSigning)
+	}
+	if s.cfg.RosterBatch > 0 {
+		features = append(features, protocol.FeatureRosterDiff)
+	}
+	if s.cfg.Moderator != nil {
+		features = append(features, protocol.FeatureHold)
+	}
+	h := protocol.HelloPayload{
+		Server:            "GoChat",
+		Version:           protocol.Version,
+		Features:          features,
+		Limits:            s.limitsFor(store.RoleMember),
+		ServerTime:        time.Now().UTC(),
+		Maintenance:       s.maint.get(),
+		MaintenanceWindow: s.maint.upcoming(),
+		Signer:            s.signer(),
+	}
+	if s.cfg.HTTPAddr != "" {
+		h.FilesURL = s.filesURL()
+	}
+	return h
+}
+
 // ---------------------------------------------------------------------------
 // Online user tracking
 // ---------------------------------------------------------------------------
 
 func (s *Server) addOnline(c *Client) {
+	id := c.getUserID()
+	peers := s.rosterPeers(id)
 	s.onlineMu.Lock()
 	defer s.onlineMu.Unlock()
-	s.online[c.userID] = c
+	_, was := s.online[id]
+	s.online[id] = c
+	s.sessions[c.id] = c
+	if c.diffs.Load() && s.cfg.RosterBatch > 0 {
+		c.roster = new(rosterDiff)
+	}
+	if !was {
+		s.sendRosterLocked(protocol.TypeUserJoined, c, peers)
+	}
+	s.sendUserListLocked(c, peers)
 }
 
 func (s *Server) removeOnline(c *Client) {
 	if !c.isAuthenticated() {
 		return
 	}
+	id := c.getUserID()
+	peers := s.rosterPeers(id)
 	s.onlineMu.Lock()
 	defer s.onlineMu.Unlock()
-	delete(s.online, c.userID)
+	delete(s.sessions, c.id)
+	if s.online[id] != c {
+		return
+	}
+	// Another session of the same user keeps them online.
+	delete(s.online, id)
+	for _, other := range s.sessions {
+		if other.getUserID() == id {
+			s.online[id] = other
+			return
+		}
+	}
+	s.sendRosterLocked(protocol.TypeUserLeft, c, peers)
+}
+
+// onlineCount is the number of users online.
+func (s *Server) onlineCount() int {
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	return len(s.online)
 }
 
-func (s *Server) onlineUsers() []protocol.UserInfo {
+// onlineUsers lists the users online whom c may see (roster.go).
+func (s *Server) onlineUsers(c *Client) []protocol.UserInfo {
+	peers := s.rosterPeers(c.getUserID())
+	if seesAll(c) {
+		peers = nil
+	}
 	s.onlineMu.RLock()
 	defer s.onlineMu.RUnlock()
 
 	out := make([]protocol.UserInfo, 0, len(s.online))
-	for _, c := range s.online {
-		out = append(out, protocol.UserInfo{UserID: c.userID, Username: c.username})
+	for _, o := range s.online {
+		if peers == nil || peers[o.getUserID()] {
+			out = append(out, protocol.UserInfo{UserID: o.getUserID(), Username: o.getUsername()})
+		}
 	}
 	return out
 }
@@ -201,14 +668,96 @@ func (s *Server) handlePacket(c *Client, pkt *protocol.Packet) {
 		s.handleRegister(c, pkt.Payload)
 	case protocol.TypeLogin:
 		s.handleLogin(c, pkt.Payload)
+	case protocol.TypeUnlock:
+		s.handleUnlock(c, pkt.Payload)
+	case protocol.TypeChallenge:
+		s.handleChallenge(c)
 	case protocol.TypeChat:
 		s.handleChat(c, pkt.Payload)
+	case protocol.TypeDelete:
+		s.handleDelete(c, pkt.Payload)
+	case protocol.TypeTyping:
+		s.handleTyping(c, pkt.Payload)
+	case protocol.TypeMarkRead:
+		s.handleMarkRead(c, pkt.Payload)
+	case protocol.TypeUnread:
+		s.handleUnread(c)
+	case protocol.TypeReaders:
+		s.handleReaders(c, pkt.Payload)
 	case protocol.TypeSearch:
 		s.handleSearch(c, pkt.Payload)
 	case protocol.TypeHistory:
 		s.handleHistory(c, pkt.Payload)
 	case protocol.TypeUsers:
 		s.handleUsers(c)
+	case protocol.TypeRoster:
+		s.handleRoster(c)
+	case protocol.TypeSessions:
+		s.handleSessions(c, pkt.Payload)
+	case protocol.TypeKillSession:
+		s.handleKillSession(c, pkt.Payload)
+	case protocol.TypePing:
+		s.handlePing(c, pkt.Payload)
+	case protocol.TypeScheduled:
+		s.handleScheduled(c)
+	case protocol.TypeCancelScheduled:
+		s.handleCancelScheduled(c, pkt.Payload)
+	case protocol.TypePollCreate:
+		s.handlePollCreate(c, pkt.Payload)
+	case protocol.TypePollVote:
+		s.handlePollVote(c, pkt.Payload)
+	case protocol.TypePollClose:
+		s.handlePollClose(c, pkt.Payload)
+	case protocol.TypeFileToken:
+		s.handleFileToken(c)
+	case protocol.TypeMaintenance:
+		s.handleMaintenance(c, pkt.Payload)
+	case protocol.TypeAnnounce:
+		s.handleAnnounce(c, pkt.Payload)
+	case protocol.TypeExport:
+		s.handleExport(c, pkt.Payload)
+	case protocol.TypeRelay:
+		s.handleRelay(c, pkt.Payload)
+	case protocol.TypeRevisions:
+		s.handleRevisions(c, pkt.Payload)
+	case protocol.TypeHeld:
+		s.handleHeld(c)
+	case protocol.TypeRelease:
+		s.handleRelease(c, pkt.Payload)
+	case protocol.TypeBulk:
+		s.handleBulk(c, pkt.Payload)
+	case protocol.TypeUsage:
+		s.handleUsage(c)
+	case protocol.TypeStats:
+		s.handleStats(c)
+	case protocol.TypeConversations:
+		s.handleConversations(c)
+	case protocol.TypeOpenDM:
+		s.handleOpenDM(c, pkt.Payload)
+	case protocol.TypeUserSearch:
+		s.handleUserSearch(c, pkt.Payload)
+	case protocol.TypeChannelList:
+		s.handleChannelList(c)
+	case protocol.TypeJoin:
+		s.handleJoin(c, pkt.Payload)
+	case protocol.TypeLeave:
+		s.handleLeave(c, pkt.Payload)
+	case protocol.TypeTopic:
+		s.handleTopic(c, pkt.Payload)
+	case protocol.TypeChannelMode:
+		s.handleChannelMode(c, pkt.Payload)
+	case protocol.TypeArchive:
+		s.handleArchive(c, pkt.Payload)
+	case protocol.TypePreferences:
+		s.handlePreferences(c)
+	case protocol.TypeMute:
+		s.handleMute(c, pkt.Payload)
+	case protocol.TypeKick:
+		s.handleKick(c, pkt.Payload)
+	case protocol.TypeBan:
+		s.handleBan(c, pkt.Payload)
+	case protocol.TypeLocale:
+		s.handleLocale(c, pkt.Payload)
 	case protocol.TypeQuit:
 		c.conn.Close()
 	default:
@@ -221,39 +770,180 @@ func (s *Server) handlePacket(c *Client, pkt *protocol.Packet) {
 // ---------------------------------------------------------------------------
 
 func (s *Server) handleRegister(c *Client, raw json.RawMessage) {
+	if c.isAuthenticated() {
+		c.sendError("already logged in")
+		return
+	}
 	var p protocol.AuthPayload
 	if err := json.Unmarshal(raw, &p); err != nil || p.Username == "" || p.Password == "" {
 		c.sendError("register requires {username, password}")
 		return
 	}
+	if s.auth != nil {
+		c.sendError(fmt.Sprintf("registration is disabled; sign in with your %s credentials", s.auth.Name()))
+		return
+	}
+	if sanitizeLine(p.Username) != p.Username {
+		c.sendError("usernames cannot contain control characters or escape sequences")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	if why := s.checkWork(c, p.Proof); why != "" {
+		c.sendError(why)
+		return
+	}
+	if why := s.vetoRegistration(c, p.Username); why != "" {
+		c.sendError(why)
+		return
+	}
 	u, err := s.store.RegisterUser(p.Username, p.Password)
 	if err != nil {
 		c.sendError(err.Error())
 		return
 	}
-	c.setIdentity(u.ID, u.Username)
+	s.promoteAdmins()
+	if fresh := s.store.GetUserByID(u.ID); fresh != nil {
+		u = fresh // promoteAdmins may just have made it an admin
+	}
+	c.noEcho.Store(p.NoEcho)
+	c.diffs.Store(p.RosterDiffs)
+	c.setIdentity(u.ID, u.Username, u.Role)
 	s.addOnline(c)
-	c.sendResponse(true, fmt.Sprintf("registered and logged in as %q", u.Username), nil)
-	s.broadcastSystem(fmt.Sprintf("%s joined the chat", u.Username))
-	log.Printf("[server] registered %s (%s)", u.Username, u.ID)
+	c.sendResponse(true, fmt.Sprintf("registered and logged in as %q", u.Username), s.issueSession(u))
+	s.events.Publish(sessionEvent(EventRegister, c))
+	s.events.Publish(sessionEvent(EventJoin, c))
+	c.logger("server").Info("registered", "user_id", u.ID)
 }
 
 func (s *Server) handleLogin(c *Client, raw json.RawMessage) {
+	if c.isAuthenticated() {
+		c.sendError("already logged in")
+		return
+	}
 	var p protocol.AuthPayload
-	if err := json.Unmarshal(raw, &p); err != nil || p.Username == "" || p.Password == "" {
-		c.sendError("login requires {username, password}")
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("login requires {username, password} or {token}")
+		return
+	}
+	c.catchUp.Store(p.CatchUp)
+	c.noEcho.Store(p.NoEcho)
+	c.diffs.Store(p.RosterDiffs)
+	if p.Token != "" {
+		s.handleTokenLogin(c, p.Token)
 		return
 	}
-	u, err := s.store.Authenticate(p.Username, p.Password)
+	if p.Username == "" || p.Password == "" {
+		c.sendError("login requires {username, password} or {token}")
+		return
+	}
+	u, err := s.authenticate(p.Username, p.Password)
+	if err != nil {
+		s.loginFailed(c, p.Username, err)
+		return
+	}
+	if why := banned(u); why != "" {
+		c.sendError(why)
+		return
+	}
+	first := u.LastSeenAt.IsZero()
+	if err := s.store.LoginSucceeded(u.ID); err != nil {
+		c.logger("store").Error("saving users failed", "err", err)
+	}
+	c.setIdentity(u.ID, u.Username, u.Role)
+	s.addOnline(c)
+	c.sendResponse(true, fmt.Sprintf("logged in as %q", u.Username), s.issueSession(u))
+	join := sessionEvent(EventJoin, c)
+	join.First = first
+	s.events.Publish(join)
+	c.logger("server").Info("login", "user_id", u.ID)
+}
+
+// handleTokenLogin resumes a session from a signed token.  Verifying the
+// token needs no Store lookup, but the account is looked up anyway: the
+// token establishes identity only, and the account, its role and whether
+// it may log in at all are the Store's as they are now, not as they were
+// when the token was issued.
+func (s *Server) handleTokenLogin(c *Client, token string) {
+	if s.tokens == nil {
+		c.sendError("this server does not issue session tokens")
+		return
+	}
+	claims, err := s.tokens.Verify(token)
 	if err != nil {
 		c.sendError(err.Error())
 		return
 	}
-	c.setIdentity(u.ID, u.Username)
+	// Tokens outlive accounts: refuse ones whose account has since been
+	// deleted, deactivated, banned or locked.
+	u := s.store.GetUserByID(claims.Subject)
+	switch {
+	case u == nil:
+		c.sendError(fmt.Sprintf("account %q no longer exists", claims.Username))
+		return
+	case u.Deactivated():
+		c.sendError(fmt.Sprintf("account %q was deactivated for inactivity; ask an admin to reactivate it", u.Username))
+		return
+	case u.Banned():
+		c.sendError(banned(u))
+		return
+	case u.Locked():
+		s.loginFailed(c, u.Username, fmt.Errorf("account %q %w", u.Username, store.ErrLocked))
+		return
+	}
+	c.setIdentity(u.ID, u.Username, u.Role)
 	s.addOnline(c)
-	c.sendResponse(true, fmt.Sprintf("logged in as %q", u.Username), nil)
-	s.broadcastSystem(fmt.Sprintf("%s joined the chat", u.Username))
-	log.Printf("[server] login %s (%s)", u.Username, u.ID)
+	c.sendResponse(true, fmt.Sprintf("logged in as %q", u.Username), s.sessionFor(u.Role, "", time.Time{}))
+	s.events.Publish(sessionEvent(EventJoin, c))
+	c.logger("server").Info("token login", "user_id", claims.Subject)
+}
+
+// issueSession signs a session token for u, when tokens are enabled.  The
+// result is used as the Data of the login response.
+func (s *Server) issueSession(u *store.User) any {
+	if s.tokens == nil {
+		return s.sessionFor(u.Role, "", time.Time{})
+	}
+	token, exp, err := s.tokens.Issue(u.ID, u.Username, []string{u.Role})
+	if err != nil {
+		logger("auth").Error("issuing a token failed", "user", u.Username, "err", err)
+		return s.sessionFor(u.Role, "", time.Time{})
+	}
+	return s.sessionFor(u.Role, token, exp)
+}
+
+// sessionFor builds the Data of a login response for role, adding its
+// limits when they depend on the role; it returns nil when there is
+// nothing to send.
+func (s *Server) sessionFor(role, token string, exp time.Time) any {
+	sess := protocol.SessionPayload{Token: token, ExpiresAt: exp}
+	if *s.roleLimits.Load() != nil {
+		l := s.limitsFor(role)
+		sess.Limits = &l
+	}
+	if sess.Token == "" && sess.Limits == nil {
+		return nil
+	}
+	return sess
+}
+
+// authenticate verifies credentials with the configured provider, falling
+// back to the Store's own accounts when none is set.  Externally verified
+// users get a local shadow account so their messages have a stable user ID.
+func (s *Server) authenticate(username, password string) (*store.User, error) {
+	if s.auth == nil {
+		return s.store.Authenticate(username, password)
+	}
+	id, err := s.auth.Authenticate(username, password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		return nil, err
+	}
+	if err != nil {
+		logger("auth").Error("login backend failed", "backend", s.auth.Name(), "err", err)
+		return nil, fmt.Errorf("authentication service unavailable")
+	}
+	return s.store.UpsertExternalUser(id.Username, id.Role, s.auth.Name())
 }
 
 func (s *Server) handleChat(c *Client, raw json.RawMessage) {
@@ -261,33 +951,220 @@ func (s *Server) handleChat(c *Client, raw json.RawMessage) {
 		c.sendError("you must login or register first")
 		return
 	}
+	if s.refuseWrite(c) || s.refuseMuted(c) {
+		return
+	}
 	var p protocol.ChatPayload
-	if err := json.Unmarshal(raw, &p); err != nil || p.Content == "" {
-		c.sendError("chat requires {content}")
+	err := json.Unmarshal(raw, &p)
+	p.Content = sanitizeText(p.Content)
+	if err != nil || (p.Content == "" && p.AttachmentID == "" && p.Kind == "") {
+		c.sendError("chat requires {content}, {attachment_id} or {kind}")
+		return
+	}
+	if max := s.limitsFor(c.getRole()).MaxContentLength; utf8.RuneCountInString(p.Content) > max {
+		c.sendError(fmt.Sprintf("message too long (max %d characters)", max))
+		return
+	}
+	if err := checkKind(p.Kind, p.Meta); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	meta, err := kindMeta(p.Kind, p.Meta)
+	if err != nil {
+		c.sendError(err.Error())
 		return
 	}
+	p.Meta = meta
+	to, err := s.recipient(c, p.Channel)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	if protocol.IsPublic(p.Channel) && !s.store.MayPost(p.Channel, c.getUserID()) {
+		if s.store.ChannelArchived(p.Channel) {
+			c.sendError("#" + p.Channel + " is archived: it can be read but not posted in")
+			return
+		}
+		c.sendError("#" + p.Channel + " is an announcement channel: only its creator and moderators can post")
+		return
+	}
+	userID, username, via := c.getUserID(), c.getUsername(), ""
+	if p.As != "" {
+		if p.SendAt != nil {
+			c.sendError("relayed messages cannot be scheduled")
+			return
+		}
+		u, err := s.relayAs(c, p.As, p.Channel)
+		if err != nil {
+			c.sendError(err.Error())
+			return
+		}
+		userID, username, via = u.ID, u.Username, c.getUsername()
+	}
+	if p.Origin != nil {
+		if p.As == "" {
+			c.sendError("origin requires as: only relayed messages have one")
+			return
+		}
+		if err := s.checkOrigin(p.Origin, p.Content); err != nil {
+			c.sendError(err.Error())
+			return
+		}
+	}
+
+	var reply *protocol.Quote
+	if p.ReplyTo != "" {
+		parent := s.store.GetMessage(p.ReplyTo)
+		if parent == nil || parent.Channel != p.Channel {
+			c.sendError(fmt.Sprintf("cannot reply: no message %q", p.ReplyTo))
+			return
+		}
+		reply = quoteOf(parent)
+	}
+
+	var att *protocol.Attachment
+	if p.AttachmentID != "" {
+		f, _, ok := s.store.GetFile(p.AttachmentID)
+		if !ok || f.OwnerID != c.getUserID() {
+			c.sendError(fmt.Sprintf("no uploaded file %q", p.AttachmentID))
+			return
+		}
+		att = s.attachmentFor(f.ID)
+	}
+	if p.Kind == protocol.KindClip {
+		if p.Meta, err = s.checkClip(p.Meta, att); err != nil {
+			c.sendError(err.Error())
+			return
+		}
+	}
 
 	now := time.Now().UTC()
 	msg := &protocol.StoredMessage{
-		ID:        fmt.Sprintf("%d", now.UnixNano()),
-		UserID:    c.userID,
-		Username:  c.username,
-		Content:   p.Content,
-		Timestamp: now,
-	}
-
-	// 1. Broadcast immediately to all connected clients (fast path).
-	bcast, _ := protocol.NewPacket(protocol.TypeBroadcast, protocol.BroadcastPayload{
-		UserID:    msg.UserID,
-		Username:  msg.Username,
-		Content:   msg.Content,
-		Timestamp: msg.Timestamp,
+		ID:         s.newMessageID(now),
+		Channel:    p.Channel,
+		To:         to,
+		UserID:     userID,
+		Username:   username,
+		Content:    p.Content,
+		Timestamp:  now,
+		Reply:      reply,
+		Attachment: att,
+		Kind:       p.Kind,
+		Meta:       p.Meta,
+		Via:        via,
+		Origin:     p.Origin,
+	}
+	if !s.filterMessage(c, msg) || !s.takePost(c, protocol.TypeChat) {
+		return
+	}
+	s.moderate(c, msg, func() {
+		if p.SendAt != nil && p.SendAt.After(now) {
+			s.ifOnline(c, func() { s.scheduleChat(c, msg, p.SendAt.UTC()) })
+			return
+		}
+		s.postFrom(c, p.Ref, msg)
 	})
-	data, _ := bcast.Encode()
-	s.hub.broadcast <- append(data, '\n')
+}
+
+// checkKind validates a message kind name and its metadata.  Kinds are
+// lowercase names such as "location" or "bot-card"; the server does not
+// interpret them.
+func checkKind(kind string, meta json.RawMessage) error {
+	if len(kind) > maxKindLength {
+		return fmt.Errorf("kind too long (max %d bytes)", maxKindLength)
+	}
+	for _, r := range kind {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return fmt.Errorf("invalid kind %q (use a-z, 0-9, '-' and '.')", kind)
+		}
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	if kind == "" {
+		return fmt.Errorf("meta requires a kind")
+	}
+	if len(meta) > maxMetaSize {
+		return fmt.Errorf("meta too large (max %d bytes)", maxMetaSize)
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(meta, &obj); err != nil {
+		return fmt.Errorf("meta must be a JSON object")
+	}
+	return nil
+}
+
+// quoteOf trims msg to its author and first line for embedding in a reply.
+func quoteOf(msg *protocol.StoredMessage) *protocol.Quote {
+	line, _, _ := strings.Cut(msg.Content, "\n")
+	switch {
+	case line != "":
+	case msg.Attachment != nil:
+		line = "📎 " + msg.Attachment.Name
+	case msg.Kind != "":
+		line = "[" + msg.Kind + "]"
+	}
+	if r := []rune(line); len(r) > maxQuoteLength {
+		line = string(r[:maxQuoteLength]) + "…"
+	}
+	return &protocol.Quote{ID: msg.ID, Username: msg.Username, Excerpt: line}
+}
 
-	// 2. Persist asynchronously via the worker pool (slow path).
-	s.pool.submit(msg)
+// scheduleChat stores msg for delivery at sendAt.
+func (s *Server) scheduleChat(c *Client, msg *protocol.StoredMessage, sendAt time.Time) {
+	if sendAt.Sub(msg.Timestamp) > maxScheduleAhead {
+		c.sendError(fmt.Sprintf("messages can be scheduled at most %d days ahead", int(maxScheduleAhead.Hours()/24)))
+		s.refundPost(c)
+		return
+	}
+	sm := &protocol.ScheduledMessage{
+		Channel:    msg.Channel,
+		To:         msg.To,
+		UserID:     msg.UserID,
+		Username:   msg.Username,
+		Content:    msg.Content,
+		SendAt:     sendAt,
+		CreatedAt:  msg.Timestamp,
+		Reply:      msg.Reply,
+		Attachment: msg.Attachment,
+		Kind:       msg.Kind,
+		Meta:       msg.Meta,
+	}
+	if err := s.store.AddScheduled(sm, maxScheduledPerUser); err != nil {
+		c.sendError(err.Error())
+		s.refundPost(c)
+		return
+	}
+	c.sendResponse(true, fmt.Sprintf("message %s scheduled for %s", sm.ID, sendAt.Format(time.RFC3339)), sm)
+}
+
+func (s *Server) handleScheduled(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	pending := s.store.ListScheduled(c.getUserID())
+	c.sendResponse(true, fmt.Sprintf("%d scheduled message(s)", len(pending)), pending)
+}
+
+func (s *Server) handleCancelScheduled(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.refuseWrite(c) {
+		return
+	}
+	var p protocol.CancelScheduledPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.ID == "" {
+		c.sendError("cancel_scheduled requires {id}")
+		return
+	}
+	if err := s.store.CancelScheduled(c.getUserID(), p.ID); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	c.sendResponse(true, fmt.Sprintf("scheduled message %s cancelled", p.ID), nil)
 }
 
 func (s *Server) handleSearch(c *Client, raw json.RawMessage) {
@@ -304,7 +1181,33 @@ func (s *Server) handleSearch(c *Client, raw json.RawMessage) {
 		c.sendError("provide at least one search criterion (query, username, from, or to)")
 		return
 	}
-	results := s.store.Search(p.Query, p.Username, p.From, p.To)
+	switch p.Sort {
+	case "", protocol.SortOldest, protocol.SortNewest, protocol.SortRelevance:
+	default:
+		c.sendError(fmt.Sprintf("unknown sort %q (want oldest, newest or relevance)", p.Sort))
+		return
+	}
+	q, err := store.ParseQuery(p.Query, p.Fuzzy)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	channels := s.visibleChannels(c)
+	if !p.AllChannels {
+		if _, err := s.recipient(c, p.Channel); err != nil {
+			c.sendError(err.Error())
+			return
+		}
+		channels = []string{p.Channel}
+	}
+	results := s.messagesFor(c, s.store.Search(store.SearchFilter{
+		Query:    q,
+		Username: p.Username,
+		From:     p.From,
+		To:       p.To,
+		Channels: channels,
+		Sort:     p.Sort,
+	}))
 	c.sendResponse(true, fmt.Sprintf("%d result(s)", len(results)), results)
 }
 
@@ -320,22 +1223,203 @@ func (s *Server) handleHistory(c *Client, raw json.RawMessage) {
 	if p.Limit <= 0 {
 		p.Limit = 20
 	}
-	msgs := s.store.GetHistory(p.Limit)
+	if p.Limit > maxHistory {
+		p.Limit = maxHistory
+	}
+	if _, err := s.recipient(c, p.Channel); err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	if p.AfterSeq > 0 || p.BeforeSeq > 0 {
+		s.historyRange(c, p)
+		return
+	}
+	msgs, more, ok := s.store.HistoryBefore(p.Channel, p.Before, p.Limit)
+	if !ok {
+		c.sendError(fmt.Sprintf("no message %q", p.Before))
+		return
+	}
+	msgs = s.messagesFor(c, msgs)
+	if p.Batch {
+		pkts := make([]*protocol.Packet, len(msgs))
+		for i, m := range msgs {
+			pkts[i] = newBroadcast(m)
+		}
+		reason := protocol.BatchHistory
+		if p.Before != "" {
+			reason = protocol.BatchOlder
+		}
+		c.sendBatch(protocol.BatchPayload{Reason: reason, More: more, Channel: p.Channel}, pkts)
+		return
+	}
+	if p.Before != "" {
+		c.sendResponse(true, fmt.Sprintf("%d older message(s)", len(msgs)), msgs)
+		return
+	}
 	c.sendResponse(true, fmt.Sprintf("last %d message(s)", len(msgs)), msgs)
 }
 
+// historyRange answers a history request for a span of sequence numbers.
+func (s *Server) historyRange(c *Client, p protocol.HistoryPayload) {
+	msgs, more := s.store.HistoryRange(p.Channel, p.AfterSeq, p.BeforeSeq, p.Limit)
+	msgs = s.messagesFor(c, msgs)
+	if !p.Batch {
+		c.sendResponse(true, fmt.Sprintf("%d message(s) after #%d", len(msgs), p.AfterSeq), msgs)
+		return
+	}
+	pkts := make([]*protocol.Packet, len(msgs))
+	for i, m := range msgs {
+		pkts[i] = newBroadcast(m)
+	}
+	c.sendBatch(protocol.BatchPayload{Reason: protocol.BatchGap, More: more, Channel: p.Channel}, pkts)
+}
+
 func (s *Server) handleUsers(c *Client) {
 	if !c.isAuthenticated() {
 		c.sendError("you must login first")
 		return
 	}
-	users := s.onlineUsers()
+	users := s.onlineUsers(c)
 	c.sendResponse(true, fmt.Sprintf("%d user(s) online", len(users)), users)
 }
 
-// broadcastSystem sends a system notice to every connected client.
-func (s *Server) broadcastSystem(msg string) {
-	pkt, _ := protocol.NewPacket(protocol.TypeSystem, map[string]string{"message": msg})
-	data, _ := pkt.Encode()
+// handlePing answers immediately, authenticated or not, so clients can sync
+// their clock before logging in.
+func (s *Server) handlePing(c *Client, raw json.RawMessage) {
+	var p protocol.PingPayload
+	json.Unmarshal(raw, &p)
+	pkt, _ := protocol.NewPacket(protocol.TypePong, protocol.PongPayload{
+		ClientTime: p.ClientTime,
+		ServerTime: time.Now().UTC(),
+	})
+	c.sendPacket(pkt)
+}
+
+func (s *Server) handleSessions(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var p protocol.SessionsPayload
+	json.Unmarshal(raw, &p)
+	if p.All && store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("listing all sessions requires the admin role")
+		return
+	}
+
+	me := c.getUserID()
+	s.onlineMu.RLock()
+	out := make([]protocol.SessionInfo, 0)
+	for _, sc := range s.sessions {
+		userID := sc.getUserID()
+		if !p.All && userID != me {
+			continue
+		}
+		out = append(out, protocol.SessionInfo{
+			ConnID:         sc.id,
+			UserID:         userID,
+			Username:       sc.getUsername(),
+			RemoteAddr:     sc.remoteAddr,
+			ConnectedSince: sc.connectedAt,
+			Current:        sc == c,
+		})
+	}
+	s.onlineMu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedSince.Before(out[j].ConnectedSince) })
+	c.sendResponse(true, fmt.Sprintf("%d active session(s)", len(out)), out)
+}
+
+func (s *Server) handleKillSession(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	var p protocol.KillSessionPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.ConnID == "" {
+		c.sendError("kill_session requires {conn_id}")
+		return
+	}
+
+	s.onlineMu.RLock()
+	target, ok := s.sessions[p.ConnID]
+	s.onlineMu.RUnlock()
+
+	isAdmin := store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin)
+	// Non-admins get the same answer for "no such session" and "not yours"
+	// so connection IDs of other users cannot be probed.
+	own := ok && target.getUserID() == c.getUserID()
+	if !ok || (!own && !isAdmin) {
+		c.sendError(fmt.Sprintf("no session %q", p.ConnID))
+		return
+	}
+
+	c.sendResponse(true, fmt.Sprintf("session %s terminated", p.ConnID), nil)
+	if own {
+		target.disconnect("This session was signed out from another session.")
+	} else {
+		target.disconnect("This session was terminated by an administrator.")
+		s.events.Publish(moderationEvent(c, ActionKillSession, target.getUsername(), "session "+p.ConnID))
+	}
+	c.logger("server").Info("killed a session", "target_conn", p.ConnID, "target", target.getUsername())
+}
+
+// newBroadcast builds the TypeBroadcast packet announcing msg.
+func newBroadcast(msg *protocol.StoredMessage) *protocol.Packet {
+	pkt, _ := protocol.NewPacket(protocol.TypeBroadcast, broadcastOf(msg))
+	return pkt
+}
+
+// broadcastOf is msg as broadcast.
+func broadcastOf(msg *protocol.StoredMessage) protocol.BroadcastPayload {
+	return protocol.BroadcastPayload{
+		ID:         msg.ID,
+		Seq:        msg.Seq,
+		Channel:    msg.Channel,
+		To:         msg.To,
+		UserID:     msg.UserID,
+		Username:   msg.Username,
+		Content:    msg.Content,
+		Timestamp:  msg.Timestamp,
+		Reply:      msg.Reply,
+		Attachment: msg.Attachment,
+		Kind:       msg.Kind,
+		Meta:       msg.Meta,
+		Via:        msg.Via,
+		Sig:        msg.Sig,
+		Origin:     msg.Origin,
+	}
+}
+
+// broadcast queues pkt for delivery to every connected client.
+func (s *Server) broadcast(pkt *protocol.Packet) {
+	data, err := pkt.Encode()
+	if err != nil {
+		return
+	}
+	if isControl(pkt.Type) {
+		s.hub.control <- append(data, '\n')
+		return
+	}
 	s.hub.broadcast <- append(data, '\n')
 }
+
+// broadcastSystem sends a system notice of the given kind to every
+// connected client.
+func (s *Server) broadcastSystem(kind, msg string) {
+	s.broadcast(systemPacket(kind, msg))
+}
+
+// systemPacket builds a system notice of the given kind.
+func systemPacket(kind, msg string) *protocol.Packet {
+	return systemNotice(protocol.SystemPayload{Kind: kind, Message: msg})
+}
+
+// systemNotice builds the TypeSystem packet of p, from the server's own
+// identity, which no account can hold.
+func systemNotice(p protocol.SystemPayload) *protocol.Packet {
+	p.From = protocol.ServerName
+	p.Announcement = p.Kind == protocol.SystemAnnouncement
+	pkt, _ := protocol.NewPacket(protocol.TypeSystem, p)
+	return pkt
+}
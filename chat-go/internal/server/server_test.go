@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"chat/internal/auth"
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// lastResponse returns the last TypeResponse queued for c.
+func lastResponse(t *testing.T, c *Client) protocol.ResponsePayload {
+	t.Helper()
+	var resp *protocol.ResponsePayload
+	for len(c.send) > 0 {
+		var p protocol.Packet
+		if err := json.Unmarshal(<-c.send, &p); err != nil || p.Type != protocol.TypeResponse {
+			continue
+		}
+		resp = new(protocol.ResponsePayload)
+		json.Unmarshal(p.Payload, resp)
+	}
+	if resp == nil {
+		t.Fatal("no response sent")
+	}
+	return *resp
+}
+
+// TestTokenLogin issues a token for alice, changes her account as each case
+// says, and logs in with the token.
+func TestTokenLogin(t *testing.T) {
+	keys, err := auth.NewKeyring([]string{"k1"}, [][]byte{bytes.Repeat([]byte("k"), 32)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		change   func(t *testing.T, st *store.Store, u *store.User)
+		token    string // instead of alice's
+		noTokens bool   // server without a keyring
+		wantOK   bool
+		wantRole string
+		wantMsg  string // substring of the response
+	}{
+		{
+			name:     "valid",
+			wantOK:   true,
+			wantRole: store.RoleMember,
+		},
+		{
+			name: "role taken from the store",
+			change: func(t *testing.T, st *store.Store, u *store.User) {
+				if err := st.SetRole(u.Username, store.RoleAdmin); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantOK:   true,
+			wantRole: store.RoleAdmin,
+		},
+		{
+			name:    "forged token",
+			token:   "e30.e30.c2ln",
+			wantMsg: auth.ErrInvalidToken.Error(),
+		},
+		{
+			name:     "tokens disabled",
+			noTokens: true,
+			wantMsg:  "does not issue session tokens",
+		},
+		{
+			name: "deleted account",
+			change: func(t *testing.T, st *store.Store, u *store.User) {
+				if err := st.DeleteUser(u.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMsg: "no longer exists",
+		},
+		{
+			name: "deactivated account",
+			change: func(t *testing.T, st *store.Store, u *store.User) {
+				if err := st.DeactivateUser(u.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMsg: "deactivated",
+		},
+		{
+			name: "banned account",
+			change: func(t *testing.T, st *store.Store, u *store.User) {
+				if err := st.BanUser(u.ID, "spam"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantMsg: "banned",
+		},
+		{
+			name: "locked account",
+			change: func(t *testing.T, st *store.Store, u *store.User) {
+				if _, locked, err := st.LoginFailed(u.Username, 1); err != nil || !locked {
+					t.Fatalf("LoginFailed = %v, %v; want the account locked", locked, err)
+				}
+			},
+			wantMsg: "locked",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{DataDir: t.TempDir(), Tokens: keys}
+			if tt.noTokens {
+				cfg.Tokens = nil
+			}
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(s.Shutdown)
+			u, err := s.store.RegisterUser("alice", "alice-password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, _, err := keys.Issue(u.ID, u.Username, []string{u.Role})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				token = tt.token
+			}
+			if tt.change != nil {
+				tt.change(t, s.store, u)
+			}
+
+			conn, peer := net.Pipe()
+			defer conn.Close()
+			defer peer.Close()
+			c := newClient("c1", conn, s)
+			s.handleTokenLogin(c, token)
+
+			resp := lastResponse(t, c)
+			if resp.Success != tt.wantOK {
+				t.Fatalf("success = %v (%q), want %v", resp.Success, resp.Message, tt.wantOK)
+			}
+			if !strings.Contains(resp.Message, tt.wantMsg) {
+				t.Errorf("message = %q, want it to contain %q", resp.Message, tt.wantMsg)
+			}
+			if !tt.wantOK {
+				if c.isAuthenticated() {
+					t.Error("refused login left the connection logged in")
+				}
+				return
+			}
+			if c.getUserID() != u.ID || c.getRole() != tt.wantRole {
+				t.Errorf("logged in as %s with role %q, want %s with %q", c.getUserID(), c.getRole(), u.ID, tt.wantRole)
+			}
+		})
+	}
+}
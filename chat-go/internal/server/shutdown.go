@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// countdownMarks are the points before shutdown at which users are warned,
+// in addition to the moment the countdown starts.
+var countdownMarks = []time.Duration{
+	30 * time.Minute, 10 * time.Minute, 5 * time.Minute, time.Minute,
+	30 * time.Second, 10 * time.Second,
+}
+
+// countdown announces the coming restart to everyone and returns once grace
+// has elapsed or s.hurry is closed.
+func (s *Server) countdown(grace time.Duration) {
+	if grace <= 0 {
+		return
+	}
+	deadline := time.Now().Add(grace)
+	notice := func(msg string) {
+		s.broadcast(systemNotice(protocol.SystemPayload{Kind: protocol.SystemShutdown, Message: msg, At: deadline.UTC()}))
+	}
+	notice("⏳ server restarting in " + shortDuration(grace))
+	logger("server").Info("restarting", "grace", grace)
+
+	for _, mark := range countdownMarks {
+		if mark >= grace {
+			continue
+		}
+		select {
+		case <-time.After(time.Until(deadline.Add(-mark))):
+			notice("⏳ server restarting in " + shortDuration(mark))
+		case <-s.hurry:
+			return
+		}
+	}
+	select {
+	case <-time.After(time.Until(deadline)):
+		notice("⏳ server restarting now")
+	case <-s.hurry:
+	}
+}
+
+// shortDuration formats d as "2h", "5m", "1m30s" or "10s".
+func shortDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	m, sec := int(d/time.Minute), int(d%time.Minute/time.Second)
+	switch {
+	case m >= 60 && m%60 == 0 && sec == 0:
+		return fmt.Sprintf("%dh", m/60)
+	case m == 0:
+		return fmt.Sprintf("%ds", sec)
+	case sec == 0:
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dm%ds", m, sec)
+}
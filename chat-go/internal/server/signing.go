@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message signing
+// ---------------------------------------------------------------------------
+//
+// With Config.Signing every message the server posts carries its Ed25519
+// signature (StoredMessage.Sig), and hello announces the public key, so a
+// bridge or a federated server that reads the message elsewhere can tell it
+// really came from here.  The signature covers the server's name, the
+// message ID, the author's name and the content (see protocol.Signature).
+//
+// A bridge bot that reposts such a message here passes the signature on as
+// ChatPayload.Origin, along with the relay identity it posts as.  The
+// message is taken only if the signature is by one of the Peers and holds
+// for the content, and it is kept as the message's Origin, so readers see
+// who on which server really wrote it.  A message without an Origin is
+// relayed as before; one with a bad Origin is refused.
+
+// maxOriginField bounds the names and IDs of a Signature taken from a
+// client.
+const maxOriginField = 128
+
+// Signing is the server's message signing key and the peer servers whose
+// signatures it trusts.
+type Signing struct {
+	Name  string // this server's name in its signatures
+	Key   ed25519.PrivateKey
+	Peers map[string]ed25519.PublicKey // server name → its public key
+}
+
+// Validate checks that s has a usable name and key.
+func (s *Signing) Validate() error {
+	if err := checkServerName(s.Name); err != nil {
+		return fmt.Errorf("signing: %w", err)
+	}
+	if len(s.Key) != ed25519.PrivateKeySize {
+		return errors.New("signing: need an Ed25519 private key")
+	}
+	if _, ok := s.Peers[s.Name]; ok {
+		return fmt.Errorf("signing: peer %q has this server's own name", s.Name)
+	}
+	return nil
+}
+
+func checkServerName(name string) error {
+	if name == "" || len(name) > maxOriginField || strings.ContainsAny(name, " \t") || sanitizeLine(name) != name {
+		return fmt.Errorf("invalid server name %q", name)
+	}
+	return nil
+}
+
+// LoadSigningKey reads the Ed25519 key in path, a base64 seed on one line.
+// When path does not exist a new key is made and written there, so a
+// server's key stays the same from one run to the next.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		rand.Read(seed)
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(seed)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("signing: %w", err)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing: %s: want a base64 Ed25519 seed of %d bytes", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// LoadPeers reads a peers file with one "<server-name> <public-key>" pair
+// per line, the key in base64 as hello announces it.  Blank lines and
+// lines starting with '#' are ignored.
+func LoadPeers(path string) (map[string]ed25519.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("peers: %w", err)
+	}
+	defer f.Close()
+
+	peers := make(map[string]ed25519.PublicKey)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, key, ok := strings.Cut(line, " ")
+		pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if !ok || err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("peers: %s:%d: want \"<server-name> <base64 Ed25519 public key>\"", path, n)
+		}
+		if err := checkServerName(name); err != nil {
+			return nil, fmt.Errorf("peers: %s:%d: %w", path, n, err)
+		}
+		if _, dup := peers[name]; dup {
+			return nil, fmt.Errorf("peers: %s:%d: duplicate server %q", path, n, name)
+		}
+		peers[name] = ed25519.PublicKey(pub)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("peers: %w", err)
+	}
+	return peers, nil
+}
+
+// signer is the signing key hello announces, or nil without one.
+func (s *Server) signer() *protocol.SignerInfo {
+	sg := s.cfg.Signing
+	if sg == nil {
+		return nil
+	}
+	pub := sg.Key.Public().(ed25519.PublicKey)
+	return &protocol.SignerInfo{
+		Server:    sg.Name,
+		KeyID:     protocol.KeyID(pub),
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	}
+}
+
+// signMessage sets msg.Sig, when the server signs messages.
+func (s *Server) signMessage(msg *protocol.StoredMessage) {
+	if sg := s.cfg.Signing; sg != nil {
+		msg.Sig = protocol.SignMessage(sg.Key, sg.Name, msg.ID, msg.Username, msg.Content)
+	}
+}
+
+// checkOrigin verifies the signature a bridge passed on with a message
+// whose content is content.
+func (s *Server) checkOrigin(o *protocol.Signature, content string) error {
+	sg := s.cfg.Signing
+	if sg == nil {
+		return errors.New("this server does not verify message signatures")
+	}
+	for _, f := range []string{o.Server, o.KeyID, o.ID, o.Username} {
+		if f == "" || len(f) > maxOriginField || sanitizeLine(f) != f {
+			return errors.New("malformed origin signature")
+		}
+	}
+	pub, ok := sg.Peers[o.Server]
+	if !ok {
+		return fmt.Errorf("origin server %q is not a trusted peer", o.Server)
+	}
+	if !o.Verify(pub, content) {
+		return fmt.Errorf("the origin signature of %s does not verify", o.Server)
+	}
+	return nil
+}
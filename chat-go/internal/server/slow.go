@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Slow clients
+// ---------------------------------------------------------------------------
+//
+// Under OverflowDisconnect a client whose send buffer fills is not dropped
+// at once.  It gets Config.SlowGrace to catch up, during which the Hub
+// counts the packets it could not queue, by type.  If the buffer drains to
+// half its size in time, the client is sent a TypeGap marker with how many
+// it missed and carries on; otherwise it is dropped, and the log names the
+// packet types that filled its buffer and that it missed.
+//
+// Drops are counted per reason on /metrics, with recoveries and the number
+// of clients currently in their grace period.
+
+// Why clients are dropped, for dropStats.
+const (
+	dropBufferFull = "send buffer full"
+	dropSpillError = "spill failed"
+)
+
+// slowState is a client in its grace period.  It is owned by the Hub
+// goroutine.
+type slowState struct {
+	since  time.Time
+	missed map[string]int // packet type → packets not queued
+}
+
+// dropStats counts slow-client handling, read by the metrics endpoint.
+type dropStats struct {
+	bufferFull atomic.Uint64
+	spillError atomic.Uint64
+	recovered  atomic.Uint64
+	slow       atomic.Int64 // clients in their grace period, sampled
+}
+
+func (d *dropStats) count(reason string) {
+	switch reason {
+	case dropBufferFull:
+		d.bufferFull.Add(1)
+	case dropSpillError:
+		d.spillError.Add(1)
+	}
+}
+
+// behind handles data not fitting in c's send buffer: it starts or
+// continues c's grace period, or drops c once it is over.
+func (h *Hub) behind(c *Client, data []byte) {
+	if h.grace <= 0 {
+		h.drop(c, dropBufferFull, "")
+		return
+	}
+	if c.slow == nil {
+		c.slow = &slowState{since: time.Now(), missed: make(map[string]int)}
+		c.logger("hub").Warn("fell behind", "grace", h.grace)
+	}
+	c.slow.missed[packetType(data)]++
+	h.expire(c)
+}
+
+// expire drops c if its grace period is over.
+func (h *Hub) expire(c *Client) {
+	if time.Since(c.slow.since) < h.grace {
+		return
+	}
+	h.drop(c, dropBufferFull, fmt.Sprintf("for %v; buffer held %s; missed %s",
+		h.grace, countTypes(c.send), describeTypes(c.slow.missed)))
+}
+
+// recover ends c's grace period with a gap marker if its send buffer has
+// drained to half, reporting whether it did.
+func (h *Hub) recover(c *Client) bool {
+	if len(c.send) > cap(c.send)/2 {
+		return false
+	}
+	n := 0
+	for _, k := range c.slow.missed {
+		n += k
+	}
+	select {
+	case c.send <- gapPacket(n):
+	default:
+		return false
+	}
+	c.logger("hub").Info("caught up", "after", time.Since(c.slow.since).Round(time.Millisecond), "missed", describeTypes(c.slow.missed))
+	c.slow = nil
+	h.drops.recovered.Add(1)
+	return true
+}
+
+// checkSlow gives the clients in their grace period a chance to recover
+// without waiting for the next broadcast, and drops those out of time.
+func (h *Hub) checkSlow() {
+	n := 0
+	for c := range h.clients {
+		if c.slow == nil || h.recover(c) {
+			continue
+		}
+		h.expire(c)
+		if c.slow != nil {
+			n++
+		}
+	}
+	h.drops.slow.Store(int64(n))
+}
+
+// packetType returns the type of the encoded packet data.
+func packetType(data []byte) string {
+	var p struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(data, &p) != nil || p.Type == "" {
+		return "unknown"
+	}
+	return p.Type
+}
+
+// countTypes empties q, which is about to be closed, and describes what
+// was in it.
+func countTypes(q chan []byte) string {
+	n := make(map[string]int)
+	for {
+		select {
+		case data := <-q:
+			n[packetType(data)]++
+		default:
+			return describeTypes(n)
+		}
+	}
+}
+
+// describeTypes renders packet counts by type, largest first, e.g.
+// "240 message, 16 presence".
+func describeTypes(n map[string]int) string {
+	if len(n) == 0 {
+		return "nothing"
+	}
+	types := slices.SortedFunc(maps.Keys(n), func(a, b string) int {
+		if n[a] != n[b] {
+			return n[b] - n[a]
+		}
+		return strings.Compare(a, b)
+	})
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%d %s", n[t], t)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Reconnect spool
+// ---------------------------------------------------------------------------
+//
+// With Config.SpoolWindow set, the server keeps the messages a user would
+// have received for a short while after their last session ends.  If they
+// log in again within the window and ask for it (AuthPayload.CatchUp), the
+// messages are replayed as one BatchCatchUp batch, so a brief network blip
+// loses nothing.  This is not an offline queue: after the window, or past
+// maxSpool messages, the oldest are dropped and the batch starts with a
+// TypeGap packet counting them.
+
+const maxSpool = 500 // messages kept per disconnected user
+
+// spool holds the messages one disconnected user missed.
+type spool struct {
+	until   time.Time
+	pkts    []*protocol.Packet
+	dropped int
+}
+
+// spools tracks the users inside their reconnect window.
+type spools struct {
+	mu     sync.Mutex
+	byUser map[string]*spool // user ID → spool
+}
+
+// spoolEvent is the spool subscriber.
+func (s *Server) spoolEvent(e Event) {
+	switch e.Type {
+	case EventLeave:
+		s.spoolLeave(e)
+	case EventMessage:
+		s.spoolMessage(e)
+	case EventJoin:
+		s.spoolJoin(e)
+	}
+}
+
+// spoolLeave opens a spool for a user whose last session just ended.
+func (s *Server) spoolLeave(e Event) {
+	s.onlineMu.RLock()
+	_, still := s.online[e.UserID]
+	s.onlineMu.RUnlock()
+	if still {
+		return // another session is receiving everything
+	}
+	s.spools.mu.Lock()
+	defer s.spools.mu.Unlock()
+	if s.spools.byUser == nil {
+		s.spools.byUser = make(map[string]*spool)
+	}
+	s.spools.byUser[e.UserID] = &spool{until: e.At.Add(s.cfg.SpoolWindow)}
+}
+
+// spoolMessage adds a message to the spool of every user inside their
+// window who may read it.
+func (s *Server) spoolMessage(e Event) {
+	s.spools.mu.Lock()
+	defer s.spools.mu.Unlock()
+	if len(s.spools.byUser) == 0 {
+		return
+	}
+	a, b, dm := protocol.DirectMembers(e.Message.Channel)
+	var members map[string]bool
+	if protocol.IsPublic(e.Message.Channel) {
+		members = s.store.ChannelMembers(e.Message.Channel)
+	}
+	var pkt *protocol.Packet
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for id, sp := range s.spools.byUser {
+		if e.At.After(sp.until) {
+			delete(s.spools.byUser, id)
+			continue
+		}
+		if dm && id != a && id != b || members != nil && !members[id] {
+			continue
+		}
+		if s.online[id] != nil {
+			continue // logged in again and gets it live; spoolJoin is on its way
+		}
+		if pkt == nil {
+			pkt = newBroadcast(s.stamps.message(e.Message))
+		}
+		if len(sp.pkts) == maxSpool {
+			sp.pkts = sp.pkts[1:]
+			sp.dropped++
+		}
+		sp.pkts = append(sp.pkts, pkt)
+	}
+}
+
+// spoolJoin closes the user's spool and, when the new session asked for
+// it, replays the contents.
+func (s *Server) spoolJoin(e Event) {
+	s.spools.mu.Lock()
+	sp := s.spools.byUser[e.UserID]
+	delete(s.spools.byUser, e.UserID)
+	s.spools.mu.Unlock()
+	if sp == nil || e.At.After(sp.until) {
+		return
+	}
+
+	s.onlineMu.RLock()
+	c := s.sessions[e.ConnID]
+	s.onlineMu.RUnlock()
+	if c == nil || !c.catchUp.Load() {
+		return
+	}
+	pkts := sp.pkts
+	if sp.dropped > 0 {
+		gap, _ := protocol.NewPacket(protocol.TypeGap, protocol.GapPayload{Skipped: sp.dropped})
+		pkts = append([]*protocol.Packet{gap}, pkts...)
+	}
+	if len(pkts) > 0 {
+		c.sendBatch(protocol.BatchPayload{Reason: protocol.BatchCatchUp}, pkts)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+const (
+	statsDays     = 14 // days of per-day message counts in a report
+	statsTopUsers = 10 // most active users listed
+)
+
+// recordPeak is the stats subscriber: every join may set a new record for
+// users online at once.
+func (s *Server) recordPeak(Event) {
+	s.onlineMu.RLock()
+	n := len(s.online)
+	s.onlineMu.RUnlock()
+	if err := s.store.RecordOnline(n); err != nil {
+		logger("store").Error("saving stats failed", "err", err)
+	}
+}
+
+func (s *Server) statsReport() protocol.StatsReport {
+	r := s.store.Stats(statsDays, statsTopUsers)
+	s.onlineMu.RLock()
+	r.Online = len(s.online)
+	s.onlineMu.RUnlock()
+	return r
+}
+
+func (s *Server) handleStats(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("stats requires the admin role")
+		return
+	}
+	r := s.statsReport()
+	c.sendResponse(true, fmt.Sprintf("%d user(s), %d message(s)", r.Users, r.Messages), r)
+}
+
+// httpStats serves the statistics report as JSON to admins on the HTTP
+// sidecar.
+func (s *Server) httpStats(w http.ResponseWriter, r *http.Request) {
+	if !s.httpAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.statsReport())
+}
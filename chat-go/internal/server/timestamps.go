@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Timestamp privacy
+// ---------------------------------------------------------------------------
+//
+// Exact message times say more than what was written: when someone is
+// awake, or who answers whom within seconds.  With TimestampGranularity
+// set, the times clients are sent are rounded down to a multiple of it;
+// with TimestampFuzz set, they are then moved later by up to that much.
+// The offset is derived from the message ID with a key made up at start,
+// so a message shows the same time wherever it appears during a run, but
+// nobody can work the offset out.  Message order is still given by Seq.
+// In this mode message IDs are random too, since they normally encode the
+// time they were posted.
+//
+// The archive keeps the precise times.  Admins get them in history and
+// search answers; broadcasts and catch-up replays, which are shared by
+// many readers, carry the coarse ones for everybody, as do conversation
+// and channel listings.
+
+// stampPolicy coarsens the timestamps sent to clients.
+type stampPolicy struct {
+	granularity time.Duration
+	fuzz        time.Duration
+	key         []byte // for the fuzz offsets
+}
+
+func newStampPolicy(granularity, fuzz time.Duration) (*stampPolicy, error) {
+	if granularity < 0 || fuzz < 0 {
+		return nil, fmt.Errorf("timestamp granularity and fuzz cannot be negative")
+	}
+	if granularity == 0 && fuzz == 0 {
+		return nil, nil
+	}
+	p := &stampPolicy{granularity: granularity, fuzz: fuzz, key: make([]byte, 32)}
+	rand.Read(p.key)
+	return p, nil
+}
+
+// coarse returns the time to show for t, which belongs to key (a message
+// ID or a channel).  A nil policy changes nothing.
+func (p *stampPolicy) coarse(key string, t time.Time) time.Time {
+	if p == nil || t.IsZero() {
+		return t
+	}
+	if p.granularity > 0 {
+		t = t.Truncate(p.granularity)
+	}
+	if p.fuzz > 0 {
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte(key))
+		off := time.Duration(binary.BigEndian.Uint64(mac.Sum(nil)) % uint64(p.fuzz))
+		if p.fuzz >= time.Second {
+			off = off.Truncate(time.Second) // no telltale nanoseconds
+		}
+		t = t.Add(off)
+	}
+	return t
+}
+
+// message returns msg as clients see it: a copy with a coarse timestamp.
+func (p *stampPolicy) message(msg *protocol.StoredMessage) *protocol.StoredMessage {
+	if p == nil {
+		return msg
+	}
+	cp := *msg
+	cp.Timestamp = p.coarse(msg.ID, msg.Timestamp)
+	return &cp
+}
+
+// newMessageID returns the ID of a message posted at now.
+func (s *Server) newMessageID(now time.Time) string {
+	if s.stamps == nil {
+		return fmt.Sprintf("%d", now.UnixNano())
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%d", binary.BigEndian.Uint64(b[:])>>1)
+}
+
+// messagesFor returns msgs as c may see them: precise for admins, coarse
+// for everyone else.
+func (s *Server) messagesFor(c *Client, msgs []*protocol.StoredMessage) []*protocol.StoredMessage {
+	if s.stamps == nil || store.RoleRank(c.getRole()) >= store.RoleRank(store.RoleAdmin) {
+		return msgs
+	}
+	out := make([]*protocol.StoredMessage, len(msgs))
+	for i, m := range msgs {
+		out[i] = s.stamps.message(m)
+	}
+	return out
+}
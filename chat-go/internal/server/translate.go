@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Translations
+// ---------------------------------------------------------------------------
+//
+// Users may set a locale (TypeLocale, kept in their Preferences).  When the
+// server has a Transformer, every plain chat message is handed to it, off
+// the delivery path, with the locales of the readers online; each rendering
+// it returns goes to the readers of that locale as a TypeTranslation
+// annotation of the broadcast they already have.  The sender gets none, and
+// neither does a reader whose language the message is already in.
+//
+// LibreTranslate is a Transformer for a LibreTranslate server; anything else
+// that rewrites text per reader (transliteration, a glossary, profanity
+// masking) can plug in the same way.
+
+const transformTimeout = 15 * time.Second // per message, all locales
+
+// Transformer renders a chat message for readers in other locales.
+type Transformer interface {
+	// Transform returns msg rendered for those of locales that need it.
+	// It may return fewer renderings than locales, or none.
+	Transform(ctx context.Context, msg *protocol.StoredMessage, locales []string) ([]Rendering, error)
+}
+
+// Rendering is a message rendered for one locale.
+type Rendering struct {
+	Locale  string // one of the locales asked for
+	Source  string // the message's detected language, "" if unknown
+	Content string
+}
+
+// validLocale reports whether tag looks like a BCP 47 language tag:
+// a 2-8 letter language and optional subtags of 1-8 letters or digits.
+func validLocale(tag string) bool {
+	if len(tag) > 35 {
+		return false
+	}
+	for i, part := range strings.Split(tag, "-") {
+		if len(part) < 1 || len(part) > 8 || (i == 0 && len(part) < 2) {
+			return false
+		}
+		for _, r := range part {
+			letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+			if !letter && (i == 0 || r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Server) handleLocale(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if s.cfg.Transformer == nil {
+		c.sendError("this server does not translate messages")
+		return
+	}
+	var p protocol.LocalePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		c.sendError("locale requires {locale}")
+		return
+	}
+	if p.Locale != "" && !validLocale(p.Locale) {
+		c.sendError(fmt.Sprintf("invalid locale %q (want a language tag such as de or pt-BR)", p.Locale))
+		return
+	}
+	prefs, err := s.store.SetLocale(c.getUserID(), p.Locale)
+	if err != nil {
+		c.logger("store").Error("saving preferences failed", "err", err)
+		c.sendError("could not save your preferences")
+		return
+	}
+	msg := "translations off"
+	if p.Locale != "" {
+		msg = "translating messages into " + p.Locale
+	}
+	c.sendResponse(true, msg, prefs)
+}
+
+// eachReader calls fn for every online session, other than the sender's,
+// that receives msg, with the lock on the session list held.
+func (s *Server) eachReader(msg *protocol.StoredMessage, fn func(c *Client)) {
+	var reads func(userID string) bool
+	switch ch := msg.Channel; {
+	case protocol.IsDirect(ch):
+		a, b, _ := protocol.DirectMembers(ch)
+		reads = func(id string) bool { return id == a || id == b }
+	case protocol.IsPublic(ch):
+		members := s.store.ChannelMembers(ch)
+		reads = func(id string) bool { return members[id] }
+	default:
+		reads = func(string) bool { return true }
+	}
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, c := range s.sessions {
+		if c.getUserID() != msg.UserID && reads(c.getUserID()) {
+			fn(c)
+		}
+	}
+}
+
+// transformEvent runs the Transformer over a posted message and sends the
+// renderings to the readers who asked for them.
+func (s *Server) transformEvent(e Event) {
+	msg := e.Message
+	if msg.Content == "" || msg.Kind != "" {
+		return // kinds carry their own structure; leave them alone
+	}
+	var locales []string
+	s.eachReader(msg, func(c *Client) {
+		if l := s.store.Locale(c.getUserID()); l != "" && !slices.Contains(locales, l) {
+			locales = append(locales, l)
+		}
+	})
+	if len(locales) == 0 {
+		return
+	}
+	slices.Sort(locales)
+
+	ctx, cancel := context.WithTimeout(context.Background(), transformTimeout)
+	defer cancel()
+	out, err := s.cfg.Transformer.Transform(ctx, msg, locales)
+	if err != nil {
+		logger("translate").Warn("transform failed", "msg_id", msg.ID, "err", err)
+	}
+	pkts := make(map[string]*protocol.Packet, len(out))
+	for _, r := range out {
+		if r.Content == "" || r.Content == msg.Content {
+			continue
+		}
+		pkts[r.Locale], _ = protocol.NewPacket(protocol.TypeTranslation, protocol.TranslationPayload{
+			ID:      msg.ID,
+			Channel: msg.Channel,
+			Locale:  r.Locale,
+			Source:  r.Source,
+			Content: r.Content,
+		})
+	}
+	if len(pkts) == 0 {
+		return
+	}
+	s.eachReader(msg, func(c *Client) {
+		if pkt := pkts[s.store.Locale(c.getUserID())]; pkt != nil {
+			c.sendPacket(pkt)
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// LibreTranslate
+// ---------------------------------------------------------------------------
+
+// LibreTranslate is a Transformer that translates messages with a
+// LibreTranslate server (https://libretranslate.com, or self-hosted).  It
+// detects a message's language once and translates it into every other
+// locale asked for, by the locale's language subtag ("pt-BR" → "pt").
+type LibreTranslate struct {
+	URL    string // base URL, e.g. http://localhost:5000
+	APIKey string // optional
+	Client *http.Client
+}
+
+func (lt *LibreTranslate) Transform(ctx context.Context, msg *protocol.StoredMessage, locales []string) ([]Rendering, error) {
+	var detected []struct {
+		Language   string  `json:"language"`
+		Confidence float64 `json:"confidence"`
+	}
+	if err := lt.call(ctx, "/detect", map[string]string{"q": msg.Content}, &detected); err != nil {
+		return nil, err
+	}
+	source := ""
+	if len(detected) > 0 {
+		source = detected[0].Language
+	}
+
+	var out []Rendering
+	for _, locale := range locales {
+		target, _, _ := strings.Cut(strings.ToLower(locale), "-")
+		if target == source {
+			continue
+		}
+		from := source
+		if from == "" {
+			from = "auto"
+		}
+		var res struct {
+			TranslatedText string `json:"translatedText"`
+		}
+		err := lt.call(ctx, "/translate", map[string]string{
+			"q":      msg.Content,
+			"source": from,
+			"target": target,
+			"format": "text",
+		}, &res)
+		if err != nil {
+			return out, fmt.Errorf("into %s: %w", locale, err)
+		}
+		out = append(out, Rendering{Locale: locale, Source: source, Content: res.TranslatedText})
+	}
+	return out, nil
+}
+
+// call POSTs req as JSON to path and decodes the answer into resp.
+func (lt *LibreTranslate) call(ctx context.Context, path string, req map[string]string, resp any) error {
+	if lt.APIKey != "" {
+		req["api_key"] = lt.APIKey
+	}
+	body, _ := json.Marshal(req)
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(lt.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("libretranslate: %w", err)
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	client := lt.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(hr)
+	if err != nil {
+		return fmt.Errorf("libretranslate: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = res.Status
+		}
+		return fmt.Errorf("libretranslate %s: %s", path, e.Error)
+	}
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return fmt.Errorf("libretranslate %s: %w", path, err)
+	}
+	return nil
+}
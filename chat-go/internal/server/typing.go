@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Typing indicators
+// ---------------------------------------------------------------------------
+//
+// A TypeTyping packet is relayed to the sessions of the other users who
+// can read its conversation, and nothing else happens: it is not stored,
+// published or answered, and one that cannot be relayed is dropped without
+// an error, so a client never has to wait on it.  Relayed packets take the
+// ordinary send path and are the first to go when a reader falls behind.
+
+// typingMinGap is how often one connection's typing is relayed at most,
+// whatever its client sends.
+const typingMinGap = time.Second
+
+func (s *Server) handleTyping(c *Client, raw json.RawMessage) {
+	if !c.isAuthenticated() {
+		return
+	}
+	now := time.Now()
+	if now.Sub(c.typedAt) < typingMinGap {
+		return
+	}
+	var p protocol.TypingPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return
+	}
+	if _, err := s.recipient(c, p.Channel); err != nil {
+		return
+	}
+	if protocol.IsPublic(p.Channel) && !s.store.MayPost(p.Channel, c.getUserID()) {
+		return
+	}
+	if muted, _, _ := s.store.MuteOf(c.getUserID()); muted {
+		return
+	}
+	c.typedAt = now
+
+	var readers map[string]bool // nil for everyone
+	switch {
+	case protocol.IsDirect(p.Channel):
+		a, b, _ := protocol.DirectMembers(p.Channel)
+		readers = map[string]bool{a: true, b: true}
+	case protocol.IsPublic(p.Channel):
+		readers = s.store.ChannelMembers(p.Channel)
+	}
+	pkt, _ := protocol.NewPacket(protocol.TypeTyping, protocol.TypingPayload{Channel: p.Channel, User: c.getUsername()})
+
+	s.onlineMu.RLock()
+	defer s.onlineMu.RUnlock()
+	for _, sc := range s.sessions {
+		if sc.getUserID() != c.getUserID() && (readers == nil || readers[sc.getUserID()]) {
+			sc.sendPacket(pkt)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"chat/internal/protocol"
+	"chat/internal/store"
+)
+
+// ---------------------------------------------------------------------------
+// Bandwidth accounting
+// ---------------------------------------------------------------------------
+
+// usage counts the traffic of one connection, or of the whole server.
+type usage struct {
+	bytesIn, bytesOut     atomic.Uint64
+	packetsIn, packetsOut atomic.Uint64
+}
+
+func (u *usage) countIn(n int) {
+	u.bytesIn.Add(uint64(n))
+	u.packetsIn.Add(1)
+}
+
+func (u *usage) countOut(n int) {
+	u.bytesOut.Add(uint64(n))
+	u.packetsOut.Add(1)
+}
+
+// byteLimiter is a token bucket that paces one direction of a connection to
+// rate bytes per second, with one second's worth of burst.  It is used by a
+// single goroutine, so it needs no locking.
+type byteLimiter struct {
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func newByteLimiter(rate int64) *byteLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &byteLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may pass.  A nil limiter never blocks.  Reading
+// slower applies TCP backpressure to a flooding sender; writing slower lets
+// the send buffer fill, and the Hub's overflow policy takes over from there.
+func (l *byteLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// usageInfo snapshots c's counters.
+func (c *Client) usageInfo() protocol.UsageInfo {
+	return protocol.UsageInfo{
+		ConnID:         c.id,
+		Username:       c.getUsername(),
+		RemoteAddr:     c.remoteAddr,
+		ConnectedSince: c.connectedAt,
+		BytesIn:        c.usage.bytesIn.Load(),
+		BytesOut:       c.usage.bytesOut.Load(),
+		PacketsIn:      c.usage.packetsIn.Load(),
+		PacketsOut:     c.usage.packetsOut.Load(),
+		SendQueued:     len(c.send),
+	}
+}
+
+// usageReport lists every authenticated connection, heaviest talkers first,
+// with server-wide totals since start.
+func (s *Server) usageReport() protocol.UsageReport {
+	s.onlineMu.RLock()
+	conns := make([]protocol.UsageInfo, 0, len(s.sessions))
+	for _, c := range s.sessions {
+		conns = append(conns, c.usageInfo())
+	}
+	s.onlineMu.RUnlock()
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].BytesIn+conns[i].BytesOut > conns[j].BytesIn+conns[j].BytesOut
+	})
+	return protocol.UsageReport{
+		Connections: conns,
+		Total: protocol.UsageInfo{
+			BytesIn:    s.traffic.bytesIn.Load(),
+			BytesOut:   s.traffic.bytesOut.Load(),
+			PacketsIn:  s.traffic.packetsIn.Load(),
+			PacketsOut: s.traffic.packetsOut.Load(),
+		},
+	}
+}
+
+func (s *Server) handleUsage(c *Client) {
+	if !c.isAuthenticated() {
+		c.sendError("you must login first")
+		return
+	}
+	if store.RoleRank(c.getRole()) < store.RoleRank(store.RoleAdmin) {
+		c.sendError("usage requires the admin role")
+		return
+	}
+	r := s.usageReport()
+	c.sendResponse(true, fmt.Sprintf("%d connection(s)", len(r.Connections)), r)
+}
+
+// httpUsage serves the usage report as JSON to admins on the HTTP sidecar.
+func (s *Server) httpUsage(w http.ResponseWriter, r *http.Request) {
+	if !s.httpAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.usageReport())
+}
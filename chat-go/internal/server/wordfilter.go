@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Word filter
+// ---------------------------------------------------------------------------
+//
+// With Config.WordFilter the server checks each message posted in a public
+// channel against a list of words before it goes out.  Each word has its
+// own action: FilterReject refuses the message, telling the author which
+// word was the problem; FilterMask posts it with the word starred out; and
+// FilterFlag posts it as it is but tells the moderators online and writes
+// it to the moderation log, so someone can look.  When a message has words
+// with different actions, reject wins over mask, and a masked message can
+// still be flagged.  Direct messages are not filtered.
+//
+// Words match whole words of the message, ignoring case; "scam*" also
+// matches the words that start with "scam".  A bridged message that is
+// masked loses its Origin, since the signature no longer holds for what is
+// posted.
+
+// Word filter actions.
+const (
+	FilterReject = "reject"
+	FilterMask   = "mask"
+	FilterFlag   = "flag"
+)
+
+// ActionFlagged is the moderation log's record of a message the word
+// filter flagged.
+const ActionFlagged = "flagged"
+
+// WordFilter is a list of words and what to do with the messages that use
+// them.  Load one with LoadWordFilter.
+type WordFilter struct {
+	words    map[string]string // lowercase word → action
+	prefixes []filterPrefix    // the "word*" entries, in file order
+}
+
+type filterPrefix struct {
+	prefix, action string
+}
+
+// LoadWordFilter reads a word list with one "<action> <word>" pair per
+// line, where the action is reject, mask or flag and a word ending in '*'
+// matches every word it starts.  Blank lines and lines starting with '#'
+// are ignored.
+func LoadWordFilter(path string) (*WordFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("word filter: %w", err)
+	}
+	defer file.Close()
+
+	f := &WordFilter{words: make(map[string]string)}
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(file)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("word filter: %s:%d: want \"<action> <word>\"", path, n)
+		}
+		action, word := fields[0], strings.ToLower(fields[1])
+		switch action {
+		case FilterReject, FilterMask, FilterFlag:
+		default:
+			return nil, fmt.Errorf("word filter: %s:%d: unknown action %q (use reject, mask or flag)", path, n, action)
+		}
+		stem, prefix := strings.CutSuffix(word, "*")
+		if stem == "" || strings.IndexFunc(stem, func(r rune) bool { return !isWordRune(r) }) >= 0 {
+			return nil, fmt.Errorf("word filter: %s:%d: %q is not a word", path, n, fields[1])
+		}
+		if seen[word] {
+			return nil, fmt.Errorf("word filter: %s:%d: duplicate word %q", path, n, word)
+		}
+		seen[word] = true
+		if prefix {
+			f.prefixes = append(f.prefixes, filterPrefix{stem, action})
+		} else {
+			f.words[stem] = action
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("word filter: %w", err)
+	}
+	return f, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// actionFor is what to do about word, lowercase, or "".
+func (f *WordFilter) actionFor(word string) string {
+	if a, ok := f.words[word]; ok {
+		return a
+	}
+	for _, p := range f.prefixes {
+		if strings.HasPrefix(word, p.prefix) {
+			return p.action
+		}
+	}
+	return ""
+}
+
+// filterVerdict is what the filter made of a message.
+type filterVerdict struct {
+	text    string   // the text to post, with the masked words starred out
+	reject  string   // the first word that has the message refused, or ""
+	flagged []string // the words to flag, each once
+}
+
+// check runs text through f.
+func (f *WordFilter) check(text string) filterVerdict {
+	var v filterVerdict
+	var out strings.Builder
+	start := -1
+	end := func(i int) {
+		word := text[start:i]
+		switch f.actionFor(strings.ToLower(word)) {
+		case FilterReject:
+			if v.reject == "" {
+				v.reject = word
+			}
+		case FilterMask:
+			out.WriteString(strings.Repeat("*", len([]rune(word))))
+			start = -1
+			return
+		case FilterFlag:
+			if !containsFold(v.flagged, word) {
+				v.flagged = append(v.flagged, word)
+			}
+		}
+		out.WriteString(word)
+		start = -1
+	}
+	for i, r := range text {
+		switch {
+		case isWordRune(r):
+			if start < 0 {
+				start = i
+			}
+			continue
+		case start >= 0:
+			end(i)
+		}
+		out.WriteRune(r)
+	}
+	if start >= 0 {
+		end(len(text))
+	}
+	v.text = out.String()
+	return v
+}
+
+func containsFold(words []string, word string) bool {
+	for _, w := range words {
+		if strings.EqualFold(w, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterMessage applies Config.WordFilter to msg, which c is about to
+// post, and reports whether it may go out.  It tells c when not.
+func (s *Server) filterMessage(c *Client, msg *protocol.StoredMessage) bool {
+	f := s.cfg.WordFilter
+	if f == nil || !protocol.IsPublic(msg.Channel) || msg.Content == "" {
+		return true
+	}
+	v := f.check(msg.Content)
+	if v.reject != "" {
+		c.sendError(fmt.Sprintf("message not sent: %q is not allowed here", v.reject))
+		return false
+	}
+	if v.text != msg.Content {
+		msg.Content, msg.Origin = v.text, nil
+	}
+	if len(v.flagged) > 0 {
+		s.flagMessage(msg, v.flagged)
+	}
+	return true
+}
+
+// flagMessage tells the moderators online that msg uses the flagged words,
+// and logs it.
+func (s *Server) flagMessage(msg *protocol.StoredMessage, words []string) {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = fmt.Sprintf("%q", w)
+	}
+	what := fmt.Sprintf("message %s in #%s: %s", msg.ID, msg.Channel, strings.Join(quoted, ", "))
+	s.events.Publish(Event{
+		Type:     EventModeration,
+		Username: protocol.ServerName,
+		Action:   ActionFlagged,
+		Target:   msg.Username,
+		Reason:   what,
+	})
+	logger("wordfilter").Info("flagged", "target", msg.Username, "msg_id", msg.ID, "channel", msg.Channel, "words", words)
+
+	s.sendModerators(systemNotice(protocol.SystemPayload{
+		Kind:    protocol.SystemModeration,
+		Message: fmt.Sprintf("Word filter: %s's message %s in #%s uses %s.", msg.Username, msg.ID, msg.Channel, strings.Join(quoted, ", ")),
+		User:    msg.Username,
+		Channel: msg.Channel,
+	}))
+}
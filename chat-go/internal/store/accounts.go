@@ -0,0 +1,270 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Account lifecycle
+// ---------------------------------------------------------------------------
+//
+// The server records when each account was last seen (login or logout) so
+// an inactivity policy can flag accounts nobody uses any more, warn their
+// owners and later deactivate or delete them.  A deactivated account keeps
+// its name and messages but cannot log in until an admin reactivates it
+// (chatctl reactivate).
+//
+// Accounts can also be locked after too many wrong passwords in a row.  A
+// locked account refuses password logins until it is unlocked, by its
+// owner with a one-time code (see the server's lockout.go) or by an admin
+// (chatctl unlock).
+
+// Login errors the server treats specially.
+var (
+	ErrIncorrectPassword = errors.New("incorrect password")
+	ErrLocked            = errors.New("is locked after too many failed logins")
+)
+
+// LastActive is when u was last seen, or when it was created if it has not
+// logged in since activity tracking began.
+func (u *User) LastActive() time.Time {
+	if u.LastSeenAt.After(u.CreatedAt) {
+		return u.LastSeenAt
+	}
+	return u.CreatedAt
+}
+
+// Deactivated reports whether u has been deactivated.
+func (u *User) Deactivated() bool { return !u.DeactivatedAt.IsZero() }
+
+// Locked reports whether u is locked after failed logins.
+func (u *User) Locked() bool { return !u.LockedAt.IsZero() }
+
+// TouchUser records that the account with the given ID is in use, clearing
+// any inactivity warning.  Unknown IDs (token logins for accounts this
+// store does not hold) are ignored.
+func (s *Store) TouchUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return nil
+	}
+	u.LastSeenAt = time.Now().UTC()
+	u.InactiveWarnedAt = time.Time{}
+	return s.saveUsersLocked()
+}
+
+// SnapshotUsers returns a copy of every account, safe to read while the
+// Store keeps changing.
+func (s *Store) SnapshotUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]User, 0, len(s.byID))
+	for _, u := range s.byID {
+		out = append(out, *u)
+	}
+	return out
+}
+
+// SearchUsers returns up to n active accounts whose username starts with
+// prefix, ignoring case, ordered by username and starting after the
+// username after.  more reports that further matches exist.
+func (s *Store) SearchUsers(prefix, after string, n int) (users []User, more bool) {
+	defer s.timed(OpSearchUsers, time.Now(), nil)
+	prefix, after = strings.ToLower(prefix), strings.ToLower(after)
+	s.mu.RLock()
+	keys := make([]string, 0, 16)
+	for key, u := range s.users {
+		if strings.HasPrefix(key, prefix) && key > after && !u.Deactivated() {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	if len(keys) > n {
+		keys, more = keys[:n], true
+	}
+	users = make([]User, len(keys))
+	for i, key := range keys {
+		users[i] = *s.users[key]
+	}
+	s.mu.RUnlock()
+	return users, more
+}
+
+// FlagInactive records that the owner of account id was warned about its
+// inactivity at the given time.
+func (s *Store) FlagInactive(id string, at time.Time) error {
+	return s.updateUser(id, func(u *User) { u.InactiveWarnedAt = at })
+}
+
+// DeactivateUser stops the account with the given ID from logging in.
+func (s *Store) DeactivateUser(id string) error {
+	return s.updateUser(id, func(u *User) { u.DeactivatedAt = time.Now().UTC() })
+}
+
+// ReactivateUser lets a deactivated account log in again and restarts its
+// inactivity clock.
+func (s *Store) ReactivateUser(username string) error {
+	u := s.GetUser(username)
+	if u == nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+	return s.updateUser(u.ID, reactivate)
+}
+
+// ReactivateUsers reactivates the accounts with the given IDs that are
+// deactivated, saving once, and returns how many it reactivated.
+func (s *Store) ReactivateUsers(ids []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if u, ok := s.byID[id]; ok && u.Deactivated() {
+			reactivate(u)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveUsersLocked()
+}
+
+func reactivate(u *User) {
+	u.DeactivatedAt = time.Time{}
+	u.InactiveWarnedAt = time.Time{}
+	u.LastSeenAt = time.Now().UTC()
+}
+
+// SetEmail sets the address inactivity warnings are sent to; "" removes it.
+func (s *Store) SetEmail(username, email string) error {
+	u := s.GetUser(username)
+	if u == nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+	if email != "" && !strings.Contains(email, "@") {
+		return fmt.Errorf("%q is not an email address", email)
+	}
+	return s.updateUser(u.ID, func(u *User) { u.Email = email })
+}
+
+// SetNtfy sets the ntfy topic unlock codes are sent to; "" removes it.
+func (s *Store) SetNtfy(username, topic string) error {
+	u := s.GetUser(username)
+	if u == nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+	if strings.ContainsAny(topic, "/?# ") {
+		return fmt.Errorf("%q is not an ntfy topic name", topic)
+	}
+	return s.updateUser(u.ID, func(u *User) { u.Ntfy = topic })
+}
+
+// LoginFailed counts a wrong password for the account with the given
+// username and locks it when that makes limit in a row; a limit of 0 never
+// locks.  It returns the account after the change, or nil for an unknown
+// username, and whether this failure locked it.
+func (s *Store) LoginFailed(username string, limit int) (u *User, locked bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		return nil, false, nil
+	}
+	u.FailedLogins++
+	if limit > 0 && u.FailedLogins >= limit && !u.Locked() {
+		u.LockedAt = time.Now().UTC()
+		locked = true
+	}
+	cp := *u
+	return &cp, locked, s.saveUsersLocked()
+}
+
+// LoginSucceeded clears the failed-login count of the account with the
+// given ID.
+func (s *Store) LoginSucceeded(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok || u.FailedLogins == 0 {
+		return nil
+	}
+	u.FailedLogins = 0
+	return s.saveUsersLocked()
+}
+
+// UnlockUser unlocks the account with the given ID and clears its
+// failed-login count.
+func (s *Store) UnlockUser(id string) error {
+	return s.updateUser(id, func(u *User) {
+		u.LockedAt = time.Time{}
+		u.FailedLogins = 0
+	})
+}
+
+// DeleteUser removes the account with the given ID and its pending
+// scheduled messages.  Messages it already posted stay in the archive under
+// its username; see Tx.PurgeMessages for removing them too.
+func (s *Store) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unscheduled, _, err := s.deleteUserLocked(id)
+	if err != nil {
+		return err
+	}
+	if unscheduled {
+		if err := s.saveScheduledLocked(); err != nil {
+			return err
+		}
+	}
+	return s.saveUsersLocked()
+}
+
+// deleteUserLocked removes the account in memory only.  unscheduled reports
+// whether scheduled messages went with it; undo puts everything back.
+func (s *Store) deleteUserLocked(id string) (unscheduled bool, undo func(), err error) {
+	u, ok := s.byID[id]
+	if !ok {
+		return false, nil, fmt.Errorf("no user with ID %q", id)
+	}
+	key := strings.ToLower(u.Username)
+	delete(s.byID, id)
+	delete(s.users, key)
+
+	// A fresh slice rather than filtering in place, so undo can restore
+	// the old one as it was.
+	old := s.scheduled
+	var kept []*protocol.ScheduledMessage
+	for _, sm := range s.scheduled {
+		if sm.UserID != id {
+			kept = append(kept, sm)
+		}
+	}
+	unscheduled = len(kept) != len(old)
+	if unscheduled {
+		s.scheduled = kept
+	}
+	return unscheduled, func() {
+		s.users[key] = u
+		s.byID[id] = u
+		s.scheduled = old
+	}, nil
+}
+
+func (s *Store) updateUser(id string, change func(*User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("no user with ID %q", id)
+	}
+	change(u)
+	return s.saveUsersLocked()
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AuditEntry is one line of the audit log: who did what to whom.  Actor is
+// a username, or "server" for automatic actions.
+type AuditEntry struct {
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	DryRun bool      `json:"dry_run,omitempty"` // reported only, nothing changed
+}
+
+// Audit appends e to <dataDir>/audit.jsonl, one JSON object per line.  The
+// log is only ever appended to, never rewritten.
+func (s *Store) Audit(e AuditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(s.dataDir, "audit.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("store: open audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("store: write audit log: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// MaxTopicLength is the longest channel topic, in bytes.
+const MaxTopicLength = 200
+
+// ErrArchived is returned by JoinChannel for an archived channel.
+var ErrArchived = errors.New("the channel is archived")
+
+// channel is the persisted form of a public channel.  Members are user IDs.
+// In an announcement channel only the creator and moderators may post.  In
+// an archived one, archived at Archived, nobody may, and nobody new may join.
+type channel struct {
+	Name      string    `json:"name"`
+	Topic     string    `json:"topic,omitempty"`
+	CreatorID string    `json:"creator_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Members   []string  `json:"members"`
+	Announce  bool      `json:"announce,omitempty"`
+	Archived  time.Time `json:"archived,omitzero"`
+}
+
+// JoinChannel adds the user with the given ID to the public channel name,
+// creating the channel when it does not exist yet.  created reports that it
+// did not.  An archived channel takes no new members.
+func (s *Store) JoinChannel(name, userID string) (info protocol.ChannelInfo, created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if ok && !ch.Archived.IsZero() && !slices.Contains(ch.Members, userID) {
+		return info, false, ErrArchived
+	}
+	if !ok {
+		ch = &channel{Name: name, CreatorID: userID, CreatedAt: time.Now().UTC()}
+		s.channels[name] = ch
+		created = true
+	}
+	if !slices.Contains(ch.Members, userID) {
+		ch.Members = append(ch.Members, userID)
+	}
+	return s.channelInfoLocked(ch, userID, s.lastActivityLocked()), created, s.saveChannelsLocked()
+}
+
+// LeaveChannel removes the user with the given ID from the public channel
+// name.  The channel and its history stay, even when nobody is left.
+func (s *Store) LeaveChannel(name, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok || !slices.Contains(ch.Members, userID) {
+		return fmt.Errorf("you are not in #%s", name)
+	}
+	ch.Members = slices.DeleteFunc(ch.Members, func(id string) bool { return id == userID })
+	return s.saveChannelsLocked()
+}
+
+// SetTopic changes the topic of the public channel name.
+func (s *Store) SetTopic(name, topic string) error {
+	if len(topic) > MaxTopicLength {
+		return fmt.Errorf("topic too long (max %d bytes)", MaxTopicLength)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok {
+		return fmt.Errorf("no channel #%s", name)
+	}
+	ch.Topic = topic
+	return s.saveChannelsLocked()
+}
+
+// SetAnnounce makes the public channel name an announcement channel, or a
+// regular one again.
+func (s *Store) SetAnnounce(name string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	if !ok {
+		return fmt.Errorf("no channel #%s", name)
+	}
+	ch.Announce = on
+	return s.saveChannelsLocked()
+}
+
+// SetArchived archives the public channel name, or restores it.
+func (s *Store) SetArchived(name string, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.channels[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("no channel #%s", name)
+	case on && !ch.Archived.IsZero():
+		return fmt.Errorf("#%s is already archived", name)
+	case !on && ch.Archived.IsZero():
+		return fmt.Errorf("#%s is not archived", name)
+	}
+	ch.Archived = time.Time{}
+	if on {
+		ch.Archived = time.Now().UTC()
+	}
+	return s.saveChannelsLocked()
+}
+
+// ChannelArchived reports whether the public channel name is archived.
+func (s *Store) ChannelArchived(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels[name]
+	return ok && !ch.Archived.IsZero()
+}
+
+// ChannelCreator returns the ID of the user who created the public channel
+// name, and whether the channel exists.
+func (s *Store) ChannelCreator(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels[name]
+	if !ok {
+		return "", false
+	}
+	return ch.CreatorID, true
+}
+
+// InChannel reports whether the user with the given ID is a member of the
+// public channel name.
+func (s *Store) InChannel(name, userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels[name]
+	return ok && slices.Contains(ch.Members, userID)
+}
+
+// MayPost reports whether the user with the given ID may post in the public
+// channel name, which they are assumed to have joined: anyone may, unless it
+// is an announcement channel or archived.
+func (s *Store) MayPost(name, userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels[name]
+	return ok && s.mayPostLocked(ch, userID)
+}
+
+func (s *Store) mayPostLocked(ch *channel, userID string) bool {
+	if !ch.Archived.IsZero() {
+		return false
+	}
+	if !ch.Announce || ch.CreatorID == userID {
+		return true
+	}
+	u := s.byID[userID]
+	return u != nil && RoleRank(u.Role) >= RoleRank(RoleModerator)
+}
+
+// ChannelInfo describes the public channel name to the user with the given
+// ID, and reports whether it exists.
+func (s *Store) ChannelInfo(name, userID string) (protocol.ChannelInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels[name]
+	if !ok {
+		return protocol.ChannelInfo{}, false
+	}
+	return s.channelInfoLocked(ch, userID, s.lastActivityLocked()), true
+}
+
+// ChannelMembers returns the IDs of the members of the public channel name.
+func (s *Store) ChannelMembers(name string) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]bool)
+	if ch, ok := s.channels[name]; ok {
+		for _, id := range ch.Members {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// ChannelPeers returns the IDs of the users who share a public channel
+// with the user with the given ID, the user included.
+func (s *Store) ChannelPeers(userID string) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := map[string]bool{userID: true}
+	for _, ch := range s.channels {
+		if slices.Contains(ch.Members, userID) {
+			for _, id := range ch.Members {
+				out[id] = true
+			}
+		}
+	}
+	return out
+}
+
+// Channels lists every public channel as seen by the user with the given
+// ID, the most recently active first; channels without messages follow,
+// largest first.  Archived channels are left out unless archived is set.
+func (s *Store) Channels(userID string, archived bool) []protocol.ChannelInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	last := s.lastActivityLocked()
+	out := make([]protocol.ChannelInfo, 0, len(s.channels))
+	for _, ch := range s.channels {
+		if !ch.Archived.IsZero() && !archived {
+			continue
+		}
+		out = append(out, s.channelInfoLocked(ch, userID, last))
+	}
+	slices.SortFunc(out, func(a, b protocol.ChannelInfo) int {
+		return cmp.Or(
+			b.LastAt.Compare(a.LastAt),
+			cmp.Compare(b.Members, a.Members),
+			cmp.Compare(a.Channel, b.Channel),
+		)
+	})
+	return out
+}
+
+// channelInfoLocked describes ch to the user with the given ID.  Members
+// whose account has since been deleted are not counted.
+func (s *Store) channelInfoLocked(ch *channel, userID string, last map[string]time.Time) protocol.ChannelInfo {
+	info := protocol.ChannelInfo{
+		Channel:  ch.Name,
+		Topic:    ch.Topic,
+		LastAt:   last[ch.Name],
+		Announce: ch.Announce,
+		Archived: !ch.Archived.IsZero(),
+		ReadOnly: !s.mayPostLocked(ch, userID),
+	}
+	for _, id := range ch.Members {
+		if _, ok := s.byID[id]; ok {
+			info.Members++
+		}
+		if id == userID {
+			info.Joined = true
+		}
+	}
+	return info
+}
+
+// lastActivityLocked maps each public channel to the time of its latest
+// message.
+func (s *Store) lastActivityLocked() map[string]time.Time {
+	last := make(map[string]time.Time, len(s.channels))
+	for i := len(s.messages) - 1; i >= 0 && len(last) < len(s.channels); i-- {
+		m := s.messages[i]
+		if _, ok := s.channels[m.Channel]; ok {
+			if _, seen := last[m.Channel]; !seen {
+				last[m.Channel] = m.Timestamp
+			}
+		}
+	}
+	return last
+}
+
+func (s *Store) loadChannels() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "channels.json"))
+	if err != nil {
+		return nil
+	}
+	var list []*channel
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("store: parse channels.json: %w", err)
+	}
+	for _, ch := range list {
+		s.channels[ch.Name] = ch
+	}
+	return nil
+}
+
+func (s *Store) saveChannelsLocked() error {
+	list := make([]*channel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		list = append(list, ch)
+	}
+	slices.SortFunc(list, func(a, b *channel) int { return cmp.Compare(a.Name, b.Name) })
+	return writeJSON(filepath.Join(s.dataDir, "channels.json"), list)
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message durability
+// ---------------------------------------------------------------------------
+//
+// The message archive is by far the Store's busiest data, so how hard it
+// tries to reach the disk is the operator's choice:
+//
+//	none           kept in memory and written only by Flush (at shutdown);
+//	               a crash loses every message since the start
+//	async          appended to its day's file on every save, leaving the OS
+//	               to decide when the data reaches the disk (the default)
+//	fsync-batch    appended and fsynced once per SaveMessages call, so the
+//	               server's persistence workers sync once per batch
+//	fsync-message  appended and fsynced on every save
+//
+// A crash mid-append leaves at most the last line of a day file cut short,
+// which the next start drops (see partitions.go).  The synced levels
+// rewrite a day, after a deletion, through a temporary file renamed into
+// place, so a crash mid-write leaves the previous day intact.  The other
+// JSON files change rarely and are not affected.
+
+// Durability levels for SetDurability.
+const (
+	DurabilityNone    = "none"
+	DurabilityAsync   = "async"
+	DurabilityBatch   = "fsync-batch"
+	DurabilityMessage = "fsync-message"
+)
+
+// ValidDurability reports an error unless d is one of the Durability
+// levels or "" (async).
+func ValidDurability(d string) error {
+	switch d {
+	case "", DurabilityNone, DurabilityAsync, DurabilityBatch, DurabilityMessage:
+		return nil
+	}
+	return fmt.Errorf("unknown durability %q (want %s, %s, %s or %s)",
+		d, DurabilityNone, DurabilityAsync, DurabilityBatch, DurabilityMessage)
+}
+
+// SetDurability chooses how the message archive is saved from now on.
+func (s *Store) SetDurability(d string) error {
+	if err := ValidDurability(d); err != nil {
+		return err
+	}
+	if d == "" {
+		d = DurabilityAsync
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.durability = d
+	return nil
+}
+
+// Durability returns the level set with SetDurability.
+func (s *Store) Durability() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.durability == "" {
+		return DurabilityAsync
+	}
+	return s.durability
+}
+
+// SaveMessages appends msgs to the archive and saves it once.
+func (s *Store) SaveMessages(msgs []*protocol.StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msgs...)
+	return s.saveMessagesLocked()
+}
+
+// Flush writes and syncs the message archive if it has changes not yet on
+// disk, as it does with durability none, and saves the rate limit
+// counters.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveRateLimitsLocked(); err != nil {
+		return err
+	}
+	if !s.unsaved {
+		return nil
+	}
+	if err := s.writeDaysLocked(true); err != nil {
+		return err
+	}
+	s.unsaved = false
+	return nil
+}
+
+func (s *Store) saveMessagesLocked() error {
+	defer s.timed(OpSaveMessages, time.Now(), func() string { return fmt.Sprintf("%d message(s), durability %s", len(s.messages), s.durability) })
+	s.replicateLocked(false)
+	s.layoutLocked()
+	switch s.durability {
+	case DurabilityNone:
+		s.unsaved = true
+		return nil
+	case DurabilityBatch, DurabilityMessage:
+		return s.writeDaysLocked(true)
+	}
+	return s.writeDaysLocked(false)
+}
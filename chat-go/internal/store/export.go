@@ -0,0 +1,139 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Personal data export
+// ---------------------------------------------------------------------------
+//
+// ExportUser gathers everything the store holds about one account so that
+// its owner can have a copy: the profile, preferences, joined channels,
+// the messages it posted, the direct messages it took part in, its pending
+// scheduled messages and the files it uploaded (their metadata; the
+// contents stay behind their download URLs).  The password hash is left
+// out.  The server hands the bundle out on request (TypeExport) and
+// chatctl export writes it to a file.
+
+// ExportFormat is the version of the UserExport layout.
+const ExportFormat = 1
+
+// exportChunk is how many archived messages ExportUser goes through
+// between progress reports.
+const exportChunk = 5000
+
+// UserExport is one account's data.
+type UserExport struct {
+	Format         int                          `json:"format"`
+	ExportedAt     time.Time                    `json:"exported_at"`
+	Profile        ExportProfile                `json:"profile"`
+	Preferences    protocol.Preferences         `json:"preferences"`
+	ReadMarks      []protocol.ReadMark          `json:"read_marks"`
+	Channels       []string                     `json:"channels"`        // public channels joined
+	Messages       []*protocol.StoredMessage    `json:"messages"`        // posted in the main and public channels
+	DirectMessages []*protocol.StoredMessage    `json:"direct_messages"` // sent and received
+	Scheduled      []*protocol.ScheduledMessage `json:"scheduled"`
+	Files          []File                       `json:"files"`
+}
+
+// ExportProfile is the account itself, without its password hash.
+type ExportProfile struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Role          string    `json:"role,omitempty"`
+	Source        string    `json:"source,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Email         string    `json:"email,omitempty"`
+	Ntfy          string    `json:"ntfy,omitempty"`
+	LastSeenAt    time.Time `json:"last_seen_at,omitzero"`
+	DeactivatedAt time.Time `json:"deactivated_at,omitzero"`
+	LockedAt      time.Time `json:"locked_at,omitzero"`
+	BannedAt      time.Time `json:"banned_at,omitzero"`
+	RelayPrefixes []string  `json:"relay_prefixes,omitempty"`
+}
+
+// ExportUser collects the data of the account username.  progress, when
+// not nil, is called as the message archive is gone through, with how many
+// of its messages have been looked at so far.
+func (s *Store) ExportUser(username string, progress func(done, total int)) (*UserExport, error) {
+	defer s.timed(OpExport, time.Now(), func() string { return "user " + username })
+	s.mu.RLock()
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, fmt.Errorf("user %q not found", username)
+	}
+	x := &UserExport{
+		Format:     ExportFormat,
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			ID:            u.ID,
+			Username:      u.Username,
+			Role:          u.Role,
+			Source:        u.Source,
+			CreatedAt:     u.CreatedAt,
+			Email:         u.Email,
+			Ntfy:          u.Ntfy,
+			LastSeenAt:    u.LastSeenAt,
+			DeactivatedAt: u.DeactivatedAt,
+			LockedAt:      u.LockedAt,
+			BannedAt:      u.BannedAt,
+			RelayPrefixes: slices.Clone(u.RelayPrefixes),
+		},
+		Preferences:    clonePrefs(s.prefs[u.ID]),
+		ReadMarks:      []protocol.ReadMark{},
+		Channels:       []string{},
+		Messages:       []*protocol.StoredMessage{},
+		DirectMessages: []*protocol.StoredMessage{},
+		Scheduled:      []*protocol.ScheduledMessage{},
+		Files:          []File{},
+	}
+	for _, m := range s.reads[u.ID] {
+		x.ReadMarks = append(x.ReadMarks, m)
+	}
+	for _, ch := range s.channels {
+		if slices.Contains(ch.Members, u.ID) {
+			x.Channels = append(x.Channels, ch.Name)
+		}
+	}
+	for _, m := range s.scheduled {
+		if m.UserID == u.ID {
+			x.Scheduled = append(x.Scheduled, m)
+		}
+	}
+	for _, f := range s.files {
+		if f.OwnerID == u.ID {
+			x.Files = append(x.Files, *f)
+		}
+	}
+	// The archive is gone through without holding the lock; see snapshot.
+	archive := s.messages
+	s.mu.RUnlock()
+
+	slices.Sort(x.Channels)
+	slices.SortFunc(x.Scheduled, func(a, b *protocol.ScheduledMessage) int { return a.SendAt.Compare(b.SendAt) })
+	slices.SortFunc(x.Files, func(a, b File) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	for i, m := range archive {
+		if a, b, dm := protocol.DirectMembers(m.Channel); dm {
+			if a == u.ID || b == u.ID {
+				x.DirectMessages = append(x.DirectMessages, m)
+			}
+		} else if m.UserID == u.ID {
+			x.Messages = append(x.Messages, m)
+		}
+		if progress != nil && (i+1)%exportChunk == 0 {
+			progress(i+1, len(archive))
+		}
+	}
+	if progress != nil {
+		progress(len(archive), len(archive))
+	}
+	return x, nil
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SourceBot marks an account the server itself posts as, such as the feed
+// watcher.  Bot accounts have no password and cannot log in.
+const SourceBot = "bot"
+
+// BotUser returns the bot account named username, creating it on first use.
+// It refuses a name that already belongs to a person.
+func (s *Store) BotUser(username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ReservedName(username) {
+		return nil, fmt.Errorf("username %q is reserved", username)
+	}
+	key := strings.ToLower(username)
+	if u, ok := s.users[key]; ok {
+		if u.Source != SourceBot {
+			return nil, fmt.Errorf("username %q belongs to a regular account", username)
+		}
+		return copyUser(u), nil
+	}
+	u := &User{
+		ID:        generateID(),
+		Username:  username,
+		Role:      RoleMember,
+		Source:    SourceBot,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.users[key] = u
+	s.byID[u.ID] = u
+	return copyUser(u), s.saveUsersLocked()
+}
+
+// FeedSeen returns the entry IDs already handled for the feed at url, and
+// whether the feed has been polled before at all.
+func (s *Store) FeedSeen(url string) (map[string]bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids, known := s.feeds[url]
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	return seen, known
+}
+
+// MarkFeedSeen records ids as handled for the feed at url, keeping the most
+// recent keep IDs so the state does not grow forever.  A call with no ids
+// still marks the feed as known.
+func (s *Store) MarkFeedSeen(url string, ids []string, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := append(s.feeds[url], ids...)
+	if len(all) > keep {
+		all = all[len(all)-keep:]
+	}
+	if all == nil {
+		all = []string{}
+	}
+	s.feeds[url] = all
+	return s.saveFeedsLocked()
+}
+
+func (s *Store) loadFeeds() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "feeds.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.feeds); err != nil {
+		return fmt.Errorf("store: parse feeds.json: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) saveFeedsLocked() error {
+	return writeJSON(filepath.Join(s.dataDir, "feeds.json"), s.feeds)
+}
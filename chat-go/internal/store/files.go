@@ -0,0 +1,124 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// File is the metadata of an uploaded attachment.  The bytes live in
+// <dataDir>/files/<ID>.  The ID is random, not derived from the time like
+// other IDs, so that it cannot be guessed; the server still checks who
+// may download it.
+type File struct {
+	ID          string    `json:"id"`
+	OwnerID     string    `json:"owner_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreateFile streams r into the files directory and records its metadata.
+// The copy happens outside the store lock; only the metadata update is
+// serialised.
+func (s *Store) CreateFile(ownerID, name, contentType string, r io.Reader) (*File, error) {
+	dir := filepath.Join(s.dataDir, "files")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("store: create files dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("store: create upload: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	h := sha256.New()
+	size, err := io.Copy(tmp, io.TeeReader(r, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		ID:          newFileID(),
+		OwnerID:     ownerID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, f.ID)); err != nil {
+		return nil, fmt.Errorf("store: save upload: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[f.ID] = f
+	return f, s.saveFilesLocked()
+}
+
+// GetFile returns the metadata and on-disk path of an uploaded file.
+func (s *Store) GetFile(id string) (*File, string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[id]
+	if !ok {
+		return nil, "", false
+	}
+	return f, filepath.Join(s.dataDir, "files", f.ID), true
+}
+
+// FileChannels returns the conversations with a message that references
+// the uploaded file id.
+func (s *Store) FileChannels(id string) []string {
+	var chans []string
+	for _, m := range s.snapshot() {
+		if m.Attachment != nil && m.Attachment.ID == id && !slices.Contains(chans, m.Channel) {
+			chans = append(chans, m.Channel)
+		}
+	}
+	return chans
+}
+
+// newFileID returns 128 random bits, hex-encoded.
+func newFileID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Store) loadFiles() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "files.json"))
+	if err != nil {
+		return nil
+	}
+	var files []*File
+	if err := json.Unmarshal(data, &files); err != nil {
+		return fmt.Errorf("store: parse files.json: %w", err)
+	}
+	for _, f := range files {
+		s.files[f.ID] = f
+	}
+	return nil
+}
+
+func (s *Store) saveFilesLocked() error {
+	files := make([]*File, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	return writeJSON(filepath.Join(s.dataDir, "files.json"), files)
+}
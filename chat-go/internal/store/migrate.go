@@ -0,0 +1,88 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Bulk export / import, used by chatctl migrate
+// ---------------------------------------------------------------------------
+
+// importChunk is how many records Import adds between progress callbacks.
+const importChunk = 1000
+
+// Users returns copies of every account ordered by ID.
+func (s *Store) Users() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*User, 0, len(s.byID))
+	for _, u := range s.byID {
+		out = append(out, copyUser(u))
+	}
+	slices.SortFunc(out, func(a, b *User) int { return strings.Compare(a.ID, b.ID) })
+	return out
+}
+
+// Messages returns every stored message in archive order.
+func (s *Store) Messages() []*protocol.StoredMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.messages)
+}
+
+// Import loads users and messages into an empty Store and saves them.
+// progress, when non-nil, is called as records are added.
+func (s *Store) Import(users []*User, msgs []*protocol.StoredMessage, progress func(kind string, done, total int)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.byID) > 0 || len(s.messages) > 0 {
+		return fmt.Errorf("store: import target %s is not empty", s.dataDir)
+	}
+	report := func(kind string, done, total int) {
+		if progress != nil && (done%importChunk == 0 || done == total) {
+			progress(kind, done, total)
+		}
+	}
+	for i, u := range users {
+		key := strings.ToLower(u.Username)
+		if _, dup := s.users[key]; dup {
+			return fmt.Errorf("store: import: duplicate username %q", u.Username)
+		}
+		cp := *u
+		s.users[key] = &cp
+		s.byID[cp.ID] = &cp
+		report("users", i+1, len(users))
+	}
+	s.messages = make([]*protocol.StoredMessage, 0, len(msgs))
+	for i, m := range msgs {
+		cp := *m
+		s.messages = append(s.messages, &cp)
+		report("messages", i+1, len(msgs))
+	}
+	if err := s.saveUsersLocked(); err != nil {
+		return err
+	}
+	return s.saveMessagesLocked()
+}
+
+// Checksum is a digest of users and messages that is independent of the
+// backend they came from, for verifying a migration.
+func Checksum(users []*User, msgs []*protocol.StoredMessage) string {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, u := range users {
+		enc.Encode(u)
+	}
+	for _, m := range msgs {
+		enc.Encode(m)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Moderation
+// ---------------------------------------------------------------------------
+//
+// Moderators can mute an account, for a while or until they lift it: a
+// muted account stays logged in and reads as before, but cannot post.
+// Admins can ban an account, which then cannot log in at all until it is
+// unbanned.  Unlike deactivation, a ban is a decision about the person,
+// not the account's idleness: it carries a reason, shown when they try to
+// log in, and reactivating inactive accounts does not lift it.
+
+// Banned reports whether u is banned.
+func (u *User) Banned() bool { return !u.BannedAt.IsZero() }
+
+// Muted reports whether u is muted at the given time.
+func (u *User) Muted(at time.Time) bool {
+	return !u.MutedAt.IsZero() && (u.MutedUntil.IsZero() || at.Before(u.MutedUntil))
+}
+
+// SetRole gives the account username the given role.
+func (s *Store) SetRole(username, role string) error {
+	if role == "" || RoleRank(role) == 0 {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	u := s.GetUser(username)
+	if u == nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+	return s.updateUser(u.ID, func(u *User) { u.Role = role })
+}
+
+// BanUser stops the account with the given ID from logging in, for reason.
+func (s *Store) BanUser(id, reason string) error {
+	return s.updateUser(id, func(u *User) { u.BannedAt, u.BanReason = time.Now().UTC(), reason })
+}
+
+// UnbanUser lifts the ban of the account with the given ID.
+func (s *Store) UnbanUser(id string) error {
+	return s.updateUser(id, func(u *User) { u.BannedAt, u.BanReason = time.Time{}, "" })
+}
+
+// MuteUser stops the account with the given ID from posting, for reason,
+// until the given time, or until it is unmuted when that is zero.
+func (s *Store) MuteUser(id string, until time.Time, reason string) error {
+	return s.updateUser(id, func(u *User) {
+		u.MutedAt, u.MutedUntil, u.MuteReason = time.Now().UTC(), until.UTC(), reason
+	})
+}
+
+// UnmuteUser lifts the mute of the account with the given ID.
+func (s *Store) UnmuteUser(id string) error {
+	return s.updateUser(id, func(u *User) { u.MutedAt, u.MutedUntil, u.MuteReason = time.Time{}, time.Time{}, "" })
+}
+
+// MuteOf reports whether the account with the given ID is muted now, and if
+// so until when (zero: until it is unmuted) and why.
+func (s *Store) MuteOf(id string) (muted bool, until time.Time, reason string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.byID[id]
+	if !ok || !u.Muted(time.Now()) {
+		return false, time.Time{}, ""
+	}
+	return true, u.MutedUntil, u.MuteReason
+}
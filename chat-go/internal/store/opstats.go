@@ -0,0 +1,146 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Operation timing
+// ---------------------------------------------------------------------------
+//
+// The Store times the operations whose cost grows with the data: those
+// that go through the whole message archive, like searches, and the
+// saves of the archive and users.json.  OpStats tells how often each
+// ran and for how long, for the server's /metrics.  With SetSlowLog, a call
+// that takes longer than the threshold is also logged, with what it was
+// asked for: its filters and result size, never message content or
+// search terms.
+
+// Timed operations, for OpStats.
+const (
+	OpSearch        = "search"         // Search
+	OpHistory       = "history"        // HistoryBefore, HistoryRange
+	OpConversations = "conversations"  // Conversations
+	OpCount         = "count_messages" // CountMessages
+	OpRevisions     = "revisions"      // Revisions
+	OpStats         = "stats"          // Stats
+	OpSearchUsers   = "search_users"   // SearchUsers
+	OpExport        = "export"         // ExportUser
+	OpSaveMessages  = "save_messages"  // writing the message archive
+	OpSaveUsers     = "save_users"     // writing users.json
+)
+
+// Ops lists the timed operations in the order OpStats reports them.
+var Ops = []string{
+	OpSearch, OpHistory, OpConversations, OpCount, OpRevisions, OpStats,
+	OpSearchUsers, OpExport, OpSaveMessages, OpSaveUsers,
+}
+
+// OpStat is what OpStats reports about one operation.
+type OpStat struct {
+	Op    string
+	Calls uint64
+	Slow  uint64        // calls longer than the SetSlowLog threshold
+	Total time.Duration // time spent in all calls
+	Max   time.Duration // the longest call
+}
+
+// opCounter tallies one operation.
+type opCounter struct {
+	calls, slow, nanos, max atomic.Uint64
+}
+
+// opTimes tallies every operation.  ops is filled in by New and only read
+// after.
+type opTimes struct {
+	slowAfter atomic.Int64 // nanoseconds; 0 logs nothing
+	ops       map[string]*opCounter
+}
+
+func newOpCounters() map[string]*opCounter {
+	ops := make(map[string]*opCounter, len(Ops))
+	for _, op := range Ops {
+		ops[op] = new(opCounter)
+	}
+	return ops
+}
+
+// SetSlowLog makes the Store log the calls that take longer than d; 0
+// turns the log off.  Calls are timed and counted either way.
+func (s *Store) SetSlowLog(d time.Duration) {
+	s.times.slowAfter.Store(int64(max(d, 0)))
+}
+
+// OpStats reports on every timed operation, in the order of Ops.
+func (s *Store) OpStats() []OpStat {
+	out := make([]OpStat, 0, len(Ops))
+	for _, op := range Ops {
+		out = append(out, s.OpStat(op))
+	}
+	return out
+}
+
+// OpStat reports on the timed operation op.
+func (s *Store) OpStat(op string) OpStat {
+	c, ok := s.times.ops[op]
+	if !ok {
+		return OpStat{Op: op}
+	}
+	return OpStat{
+		Op:    op,
+		Calls: c.calls.Load(),
+		Slow:  c.slow.Load(),
+		Total: time.Duration(c.nanos.Load()),
+		Max:   time.Duration(c.max.Load()),
+	}
+}
+
+// timed records a call of op that began at start.  detail is called only
+// for a slow call, to say what it was asked for.
+func (s *Store) timed(op string, start time.Time, detail func() string) {
+	d := time.Since(start)
+	c := s.times.ops[op]
+	c.calls.Add(1)
+	c.nanos.Add(uint64(d))
+	for {
+		m := c.max.Load()
+		if uint64(d) <= m || c.max.CompareAndSwap(m, uint64(d)) {
+			break
+		}
+	}
+	if slow := s.times.slowAfter.Load(); slow > 0 && int64(d) > slow {
+		c.slow.Add(1)
+		attrs := []any{"op", op, "took", d.Round(time.Microsecond)}
+		if detail != nil {
+			attrs = append(attrs, "detail", detail())
+		}
+		logger().Warn("slow operation", attrs...)
+	}
+}
+
+// describe sums up f for the slow log, leaving out the query itself.
+func (f SearchFilter) describe() string {
+	var parts []string
+	if f.Query != nil {
+		parts = append(parts, "a query")
+	}
+	if f.Username != "" {
+		parts = append(parts, "from "+f.Username)
+	}
+	if f.From != nil || f.To != nil {
+		parts = append(parts, "a time range")
+	}
+	if f.Channels != nil {
+		parts = append(parts, fmt.Sprintf("%d conversation(s)", len(f.Channels)))
+	}
+	if f.Sort != "" {
+		parts = append(parts, "sorted by "+f.Sort)
+	}
+	if len(parts) == 0 {
+		return "everything"
+	}
+	return strings.Join(parts, ", ")
+}
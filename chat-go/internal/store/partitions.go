@@ -0,0 +1,480 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message partitions
+// ---------------------------------------------------------------------------
+//
+// The message archive is written as one file per UTC day,
+// messages-2024-06-01.jsonl, holding a JSON message per line.  A save
+// appends the new messages to their day's file instead of rewriting the
+// archive; only the days a deletion touched are rewritten, and a day left
+// empty loses its file.  messages-index.json lists the days with how many
+// messages each holds and the times of the first and last, for the tools
+// that only need the overview.
+//
+// The day files are what counts: the Store reads every one in the data
+// directory at startup, oldest first, and rewrites the index if it is out
+// of date.  So a day can be pruned by moving or deleting its file while
+// the server is stopped, and brought back by moving it back (chatctl prune
+// does the former).
+//
+// In memory the archive stays one list, in the order of the files, and
+// s.days records where each day starts in it.  A search with a date range
+// looks only at the days that overlap it.  A message stamped before the
+// last day — a clock set back — goes into that last day's file, so the
+// days never interleave.
+//
+// A data directory with the messages.json of earlier versions is converted
+// on the first start; the old file is kept as messages.json.bak.
+
+const (
+	partitionIndex = "messages-index.json"
+	legacyArchive  = "messages.json"
+	dayLayout      = "2006-01-02"
+)
+
+// archiveTx is how a transaction marks the archive as changed.  It is not
+// a file: committing writes the day files that changed and the index.
+const archiveTx = "messages"
+
+// Partition is one day of the message archive, as messages-index.json lists
+// it.
+type Partition struct {
+	Day      string    `json:"day"`  // UTC, 2006-01-02
+	File     string    `json:"file"` // in the data directory
+	Messages int       `json:"messages"`
+	First    time.Time `json:"first"` // the earliest message
+	Last     time.Time `json:"last"`  // the latest message
+}
+
+// partition is a Partition in memory: where it is in s.messages, and its
+// first and last message, by which a save tells whether it changed.
+// Archived messages are never changed in place, so the same count between
+// the same two messages is the same day.
+type partition struct {
+	Partition
+	off        int
+	head, tail *protocol.StoredMessage
+}
+
+func partitionFile(day string) string {
+	return "messages-" + day + ".jsonl"
+}
+
+// dayOf parses the day of a partition file's name.
+func dayOf(file string) (string, bool) {
+	day, ok := strings.CutPrefix(file, "messages-")
+	day, ok2 := strings.CutSuffix(day, ".jsonl")
+	if !ok || !ok2 {
+		return "", false
+	}
+	if _, err := time.Parse(dayLayout, day); err != nil {
+		return "", false
+	}
+	return day, true
+}
+
+// place adds m, at offset i of the archive, to the last of days or a new
+// day after it.
+func place(days []partition, m *protocol.StoredMessage, i int) []partition {
+	day := m.Timestamp.UTC().Format(dayLayout)
+	if n := len(days); n > 0 && day <= days[n-1].Day {
+		p := &days[n-1]
+		p.Messages++
+		p.tail = m
+		p.First, p.Last = minTime(p.First, m.Timestamp), maxTime(p.Last, m.Timestamp)
+		return days
+	}
+	return append(days, partition{
+		Partition: Partition{Day: day, File: partitionFile(day), Messages: 1, First: m.Timestamp, Last: m.Timestamp},
+		off:       i,
+		head:      m,
+		tail:      m,
+	})
+}
+
+// layoutLocked brings s.days up to date with s.messages: the messages
+// appended since are placed after the others, and an archive that changed
+// otherwise is divided again.  s.days is replaced, never changed in place,
+// since snapshots and s.disk share it.
+func (s *Store) layoutLocked() {
+	n := 0
+	if k := len(s.days); k > 0 {
+		n = s.days[k-1].off + s.days[k-1].Messages
+	}
+	days := s.days
+	switch {
+	case len(s.messages) == n && (n == 0 || s.messages[n-1] == days[len(days)-1].tail):
+		return
+	case len(s.messages) > n && (n == 0 || s.messages[n-1] == days[len(days)-1].tail):
+		days = slices.Clone(days)
+	default:
+		days, n = nil, 0
+	}
+	for i := n; i < len(s.messages); i++ {
+		days = place(days, s.messages[i], i)
+	}
+	s.days = days
+}
+
+// dayChangesLocked works out what to write to bring the day files from
+// s.disk to s.days: the whole content of the files to rewrite, nil for a
+// day that is gone, and, when appendOK, what to add at the end of those
+// that only gained messages.
+func (s *Store) dayChangesLocked(appendOK bool) (rewrite, appends map[string][]byte, err error) {
+	rewrite, appends = make(map[string][]byte), make(map[string][]byte)
+	written := make(map[string]partition, len(s.disk))
+	for _, q := range s.disk {
+		written[q.Day] = q
+	}
+	for _, p := range s.days {
+		q, ok := written[p.Day]
+		delete(written, p.Day)
+		msgs := s.messages[p.off : p.off+p.Messages]
+		switch {
+		case ok && q.head == p.head && q.tail == p.tail && q.Messages == p.Messages:
+			continue
+		case ok && appendOK && q.head == p.head && q.Messages < p.Messages && msgs[q.Messages-1] == q.tail:
+			if appends[p.File], err = encodeLines(msgs[q.Messages:]); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if rewrite[p.File], err = encodeLines(msgs); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for _, q := range written {
+		rewrite[q.File] = nil
+	}
+	return rewrite, appends, nil
+}
+
+// writeDaysLocked brings the day files and the index up to date with
+// s.days, syncing them when sync is set.
+func (s *Store) writeDaysLocked(sync bool) error {
+	rewrite, appends, err := s.dayChangesLocked(true)
+	if err != nil {
+		return err
+	}
+	if len(rewrite) == 0 && len(appends) == 0 {
+		return nil
+	}
+	for file, data := range appends {
+		if err := appendFile(filepath.Join(s.dataDir, file), data, sync); err != nil {
+			s.unsureLocked(rewrite, appends)
+			return err
+		}
+	}
+	for file, data := range rewrite {
+		path := filepath.Join(s.dataDir, file)
+		switch {
+		case data == nil:
+			err = os.Remove(path)
+		case sync:
+			err = writeFileSynced(path, data)
+		default:
+			err = os.WriteFile(path, data, 0o644)
+		}
+		if err != nil && !(data == nil && os.IsNotExist(err)) {
+			s.unsureLocked(rewrite, appends)
+			return err
+		}
+	}
+	s.disk = s.days
+	return s.writeIndexLocked(sync)
+}
+
+// unsureLocked records, after a write failed part way, that the day files
+// it was to change may hold anything: the next save rewrites them whole,
+// rather than appending to them again, and removes those of days that are
+// gone.
+func (s *Store) unsureLocked(rewrite, appends map[string][]byte) {
+	disk := slices.Clone(s.days)
+	for i, p := range disk {
+		_, r := rewrite[p.File]
+		_, a := appends[p.File]
+		if r || a {
+			disk[i].head = nil
+		}
+	}
+	for file, data := range rewrite {
+		if day, ok := dayOf(file); ok && data == nil {
+			disk = append(disk, partition{Partition: Partition{Day: day, File: file}})
+		}
+	}
+	s.disk = disk
+}
+
+// indexLocked is the content of messages-index.json for s.days.
+func (s *Store) indexLocked() ([]byte, error) {
+	index := make([]Partition, len(s.days))
+	for i, p := range s.days {
+		index[i] = p.Partition
+	}
+	return json.MarshalIndent(index, "", "  ")
+}
+
+func (s *Store) writeIndexLocked(sync bool) error {
+	data, err := s.indexLocked()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dataDir, partitionIndex)
+	if sync {
+		return writeFileSynced(path, data)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadMessages reads the day files, or converts the messages.json of an
+// earlier version into them.
+func (s *Store) loadMessages() error {
+	legacy := filepath.Join(s.dataDir, legacyArchive)
+	if data, err := os.ReadFile(legacy); err == nil {
+		if err := json.Unmarshal(data, &s.messages); err != nil {
+			return fmt.Errorf("store: parse %s: %w", legacyArchive, err)
+		}
+		s.dedupeMessages()
+		s.layoutLocked()
+		if err := s.writeDaysLocked(true); err != nil {
+			return fmt.Errorf("store: convert %s: %w", legacyArchive, err)
+		}
+		if err := os.Rename(legacy, legacy+".bak"); err != nil {
+			return fmt.Errorf("store: convert %s: %w", legacyArchive, err)
+		}
+		logger().Info("moved the message archive into day files", "days", len(s.days), "kept", legacyArchive+".bak")
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.dataDir, "messages-*.jsonl"))
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	var days []partition
+	for _, path := range paths { // sorted, and so oldest first
+		day, ok := dayOf(filepath.Base(path))
+		if !ok {
+			continue
+		}
+		msgs, torn, err := readLines(path)
+		if err != nil {
+			return fmt.Errorf("store: parse %s: %w", filepath.Base(path), err)
+		}
+		if len(msgs) == 0 {
+			os.Remove(path)
+			continue
+		}
+		p := partition{Partition: Partition{Day: day, File: partitionFile(day), First: msgs[0].Timestamp, Last: msgs[0].Timestamp}, off: len(s.messages)}
+		for _, m := range msgs {
+			p.Messages++
+			p.First, p.Last = minTime(p.First, m.Timestamp), maxTime(p.Last, m.Timestamp)
+		}
+		p.head, p.tail = msgs[0], msgs[len(msgs)-1]
+		s.messages = append(s.messages, msgs...)
+		days = append(days, p)
+		if torn {
+			// Rewritten by the next save, before anything is appended.
+			logger().Warn("the last save was cut short; the file is rewritten on the next save", "file", p.File)
+			p.head = nil
+		}
+		s.disk = append(s.disk, p)
+	}
+	s.days = days
+	n := len(s.messages)
+	s.dedupeMessages()
+	if len(s.messages) != n {
+		s.days = nil
+		s.layoutLocked()
+	}
+
+	// Bring the index up to date after a crash or a file moved by hand.
+	data, err := s.indexLocked()
+	if err != nil {
+		return err
+	}
+	if old, _ := os.ReadFile(filepath.Join(s.dataDir, partitionIndex)); !bytes.Equal(old, data) && len(s.days) > 0 {
+		return s.writeIndexLocked(false)
+	}
+	return nil
+}
+
+// readLines reads a day file.  torn reports that its last line has no
+// newline: a save was cut short, and an incomplete message was dropped.
+func readLines(path string) (msgs []*protocol.StoredMessage, torn bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		last := i == len(lines)-1
+		var m protocol.StoredMessage
+		if err := json.Unmarshal(line, &m); err != nil {
+			if last {
+				return msgs, true, nil
+			}
+			return nil, false, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		msgs = append(msgs, &m)
+		torn = last
+	}
+	return msgs, torn, nil
+}
+
+func encodeLines(msgs []*protocol.StoredMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// appendFile adds data at the end of the file at path, creating it.
+func appendFile(path string, data []byte, sync bool) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// writeFileSynced replaces the file at path with data through a synced
+// temporary file.
+func writeFileSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// Partitions lists the days of the message archive, oldest first.
+func (s *Store) Partitions() []Partition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Partition, len(s.days))
+	for i, p := range s.days {
+		out[i] = p.Partition
+	}
+	return out
+}
+
+// daysBetween returns the days of the archive that may hold messages
+// stamped from from to to, either of which may be nil, and the size of the
+// whole archive.  Like snapshot, the slices stay as they are.
+func (s *Store) daysBetween(from, to *time.Time) (days [][]*protocol.StoredMessage, total int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.days {
+		if from != nil && p.Last.Before(*from) || to != nil && p.First.After(*to) {
+			continue
+		}
+		days = append(days, s.messages[p.off:p.off+p.Messages])
+	}
+	return days, len(s.messages)
+}
+
+// PruneDays takes the days before before (2006-01-02) out of the archive
+// and returns them.  Their files are deleted or, with archiveDir, moved
+// there, from where they can be moved back while the server is stopped.
+// When a file cannot be moved the days before it are still pruned.
+func (s *Store) PruneDays(before, archiveDir string) ([]Partition, error) {
+	if _, err := time.Parse(dayLayout, before); err != nil {
+		return nil, fmt.Errorf("store: prune: want a day like 2024-06-01, not %q", before)
+	}
+	if archiveDir != "" {
+		if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+			return nil, fmt.Errorf("store: prune: %w", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write what is only in memory first, so the files are the archive.
+	s.layoutLocked()
+	if err := s.writeDaysLocked(true); err != nil {
+		return nil, err
+	}
+	s.unsaved = false
+
+	var gone []Partition
+	var pruneErr error
+	k := 0
+	for _, p := range s.days {
+		if p.Day >= before {
+			break
+		}
+		src := filepath.Join(s.dataDir, p.File)
+		var err error
+		if archiveDir != "" {
+			err = os.Rename(src, filepath.Join(archiveDir, p.File))
+		} else {
+			err = os.Remove(src)
+		}
+		if err != nil {
+			pruneErr = fmt.Errorf("store: prune %s: %w", p.Day, err)
+			break
+		}
+		gone = append(gone, p.Partition)
+		k += p.Messages
+	}
+	if len(gone) == 0 {
+		return nil, pruneErr
+	}
+	s.messages = slices.Clone(s.messages[k:])
+	days := slices.Clone(s.days[len(gone):])
+	for i := range days {
+		days[i].off -= k
+	}
+	s.days, s.disk = days, days
+	s.replicateLocked(false)
+	if err := s.writeIndexLocked(true); err != nil && pruneErr == nil {
+		pruneErr = err
+	}
+	return gone, pruneErr
+}
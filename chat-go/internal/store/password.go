@@ -0,0 +1,82 @@
+package store
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ---------------------------------------------------------------------------
+// Passwords
+// ---------------------------------------------------------------------------
+//
+// Passwords are kept as bcrypt hashes, which carry their own salt and cost.
+// Accounts from before bcrypt still have an unsalted hex SHA-256; they keep
+// working, and the next successful login replaces it with a bcrypt hash, as
+// it does a bcrypt hash of another cost than passwordCost.  bcrypt is slow
+// on purpose, so hashes are computed and compared outside the store's lock.
+
+// passwordCost is the bcrypt cost of new hashes.
+const passwordCost = bcrypt.DefaultCost
+
+// MaxPasswordLength is the longest password bcrypt takes, in bytes.
+const MaxPasswordLength = 72
+
+func hashPassword(pw string) (string, error) {
+	if len(pw) > MaxPasswordLength {
+		return "", fmt.Errorf("password too long (max %d bytes)", MaxPasswordLength)
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(pw), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// checkPassword reports whether pw matches hash, and whether hash is due to
+// be replaced.
+func checkPassword(hash, pw string) (ok, stale bool) {
+	if legacyHash(hash) {
+		sum := sha256.Sum256([]byte(pw))
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(hex.EncodeToString(sum[:]))) == 1, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost != passwordCost
+}
+
+// legacyHash reports whether hash is an unsalted SHA-256 from before bcrypt.
+func legacyHash(hash string) bool {
+	return len(hash) == sha256.Size*2 && !strings.HasPrefix(hash, "$")
+}
+
+// rehash stores a fresh hash of pw for the account with the given ID, whose
+// hash old it just matched, unless the hash has changed meanwhile.  Failing
+// only costs the upgrade, so it is logged rather than returned.
+func (s *Store) rehash(id, old, pw string) {
+	hash, err := hashPassword(pw)
+	if err != nil {
+		logger().Error("rehashing a password failed", "user", id, "err", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.byID[id]
+	if !ok || u.PasswordHash != old {
+		return
+	}
+	u.PasswordHash = hash
+	if err := s.saveUsersLocked(); err != nil {
+		logger().Error("saving users failed", "err", err)
+		return
+	}
+	if legacyHash(old) {
+		logger().Info("upgraded a password hash to bcrypt", "user", u.Username)
+	}
+}
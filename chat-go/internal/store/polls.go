@@ -0,0 +1,130 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// poll is the persisted form of a poll.  Votes maps user ID → option index so
+// each user has at most one vote and can change it.
+type poll struct {
+	ID        string         `json:"id"`
+	CreatorID string         `json:"creator_id"`
+	Creator   string         `json:"creator"`
+	Question  string         `json:"question"`
+	Options   []string       `json:"options"`
+	Votes     map[string]int `json:"votes"`
+	Closed    bool           `json:"closed"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// public returns the tallied, voter-free view of p.
+func (p *poll) public() *protocol.Poll {
+	out := &protocol.Poll{
+		ID:        p.ID,
+		CreatorID: p.CreatorID,
+		Creator:   p.Creator,
+		Question:  p.Question,
+		Options:   make([]protocol.PollOption, len(p.Options)),
+		Closed:    p.Closed,
+		CreatedAt: p.CreatedAt,
+	}
+	for i, text := range p.Options {
+		out.Options[i].Text = text
+	}
+	for _, opt := range p.Votes {
+		out.Options[opt].Votes++
+	}
+	return out
+}
+
+// CreatePoll opens a new poll.  Poll IDs are short sequential numbers so they
+// are easy to type in a /vote command.
+func (s *Store) CreatePoll(creatorID, creator, question string, options []string) (*protocol.Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pollSeq++
+	p := &poll{
+		ID:        strconv.Itoa(s.pollSeq),
+		CreatorID: creatorID,
+		Creator:   creator,
+		Question:  question,
+		Options:   options,
+		Votes:     make(map[string]int),
+		CreatedAt: time.Now().UTC(),
+	}
+	s.polls[p.ID] = p
+	return p.public(), s.savePollsLocked()
+}
+
+// VotePoll records userID's vote for option, replacing any earlier vote.
+func (s *Store) VotePoll(id, userID string, option int) (*protocol.Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.polls[id]
+	if !ok {
+		return nil, fmt.Errorf("no poll #%s", id)
+	}
+	if p.Closed {
+		return nil, fmt.Errorf("poll #%s is closed", id)
+	}
+	if option < 0 || option >= len(p.Options) {
+		return nil, fmt.Errorf("poll #%s has options 1–%d", id, len(p.Options))
+	}
+	p.Votes[userID] = option
+	return p.public(), s.savePollsLocked()
+}
+
+// ClosePoll stops voting on a poll.  Only the creator may close it unless
+// force is set (moderators).
+func (s *Store) ClosePoll(id, userID string, force bool) (*protocol.Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.polls[id]
+	if !ok {
+		return nil, fmt.Errorf("no poll #%s", id)
+	}
+	if p.CreatorID != userID && !force {
+		return nil, fmt.Errorf("only %s can close poll #%s", p.Creator, id)
+	}
+	if p.Closed {
+		return nil, fmt.Errorf("poll #%s is already closed", id)
+	}
+	p.Closed = true
+	return p.public(), s.savePollsLocked()
+}
+
+func (s *Store) loadPolls() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "polls.json"))
+	if err != nil {
+		return nil
+	}
+	var polls []*poll
+	if err := json.Unmarshal(data, &polls); err != nil {
+		return fmt.Errorf("store: parse polls.json: %w", err)
+	}
+	for _, p := range polls {
+		s.polls[p.ID] = p
+		if n, err := strconv.Atoi(p.ID); err == nil && n > s.pollSeq {
+			s.pollSeq = n
+		}
+	}
+	return nil
+}
+
+func (s *Store) savePollsLocked() error {
+	polls := make([]*poll, 0, len(s.polls))
+	for _, p := range s.polls {
+		polls = append(polls, p)
+	}
+	return writeJSON(filepath.Join(s.dataDir, "polls.json"), polls)
+}
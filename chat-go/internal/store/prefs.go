@@ -0,0 +1,97 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"chat/internal/protocol"
+)
+
+// Preferences returns the stored preferences of the user with the given ID.
+func (s *Store) Preferences(userID string) protocol.Preferences {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clonePrefs(s.prefs[userID])
+}
+
+// SetMuted mutes or unmutes channel for the user with the given ID and
+// returns their updated preferences.
+func (s *Store) SetMuted(userID, channel string, mute bool) (protocol.Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.prefs[userID]
+	i := slices.Index(p.MutedChannels, channel)
+	switch {
+	case mute && i < 0:
+		p.MutedChannels = append(p.MutedChannels, channel)
+		slices.Sort(p.MutedChannels)
+	case !mute && i >= 0:
+		p.MutedChannels = slices.Delete(p.MutedChannels, i, i+1)
+	default:
+		return clonePrefs(p), nil
+	}
+	return s.setPrefsLocked(userID, p)
+}
+
+// SetLocale sets the language the user with the given ID reads
+// translations in ("" for none) and returns their updated preferences.
+func (s *Store) SetLocale(userID, locale string) (protocol.Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.prefs[userID]
+	if p.Locale == locale {
+		return clonePrefs(p), nil
+	}
+	p.Locale = locale
+	return s.setPrefsLocked(userID, p)
+}
+
+// Locale returns the translation locale of the user with the given ID.
+func (s *Store) Locale(userID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.prefs[userID].Locale
+}
+
+// setPrefsLocked stores p as the user's preferences, dropping the entry
+// when nothing is set, and saves.
+func (s *Store) setPrefsLocked(userID string, p protocol.Preferences) (protocol.Preferences, error) {
+	if len(p.MutedChannels) == 0 && p.Locale == "" {
+		delete(s.prefs, userID)
+	} else {
+		s.prefs[userID] = p
+	}
+	return clonePrefs(p), s.savePrefsLocked()
+}
+
+func clonePrefs(p protocol.Preferences) protocol.Preferences {
+	p.MutedChannels = slices.Clone(p.MutedChannels)
+	return p
+}
+
+// Muted reports whether the user with the given ID muted channel.
+func (s *Store) Muted(userID, channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Contains(s.prefs[userID].MutedChannels, channel)
+}
+
+func (s *Store) loadPrefs() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "prefs.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.prefs); err != nil {
+		return fmt.Errorf("store: parse prefs.json: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) savePrefsLocked() error {
+	return writeJSON(filepath.Join(s.dataDir, "prefs.json"), s.prefs)
+}
@@ -0,0 +1,427 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ---------------------------------------------------------------------------
+// Search query language
+// ---------------------------------------------------------------------------
+//
+//	hello world         both words (implicit AND)
+//	"hello world"       exact phrase
+//	cats OR dogs        either word
+//	cats -dogs          cats but not dogs (NOT dogs is the same)
+//	(cats OR dogs) food grouping
+//
+// Words and phrases are case-insensitive substring matches, so "deploy"
+// also finds "deployment".  AND binds tighter than OR.
+//
+// In fuzzy mode a word also matches any word of the message within a small
+// edit distance (see fuzzyDistance), so "recieve" finds "receive".  Phrases
+// always match exactly.
+
+// MaxFuzzyTerms caps the words in a fuzzy query; each one is compared with
+// every word of every message, so the cost grows with the term count.
+const MaxFuzzyTerms = 8
+
+// Query is a parsed search expression.  The zero value matches everything.
+type Query struct {
+	root  node
+	fuzzy bool
+}
+
+// ParseQuery parses s into a Query.  An empty or all-space s yields a Query
+// that matches every message.  With fuzzy set, words tolerate typos.
+func ParseQuery(s string, fuzzy bool) (*Query, error) {
+	toks, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return &Query{}, nil
+	}
+	if fuzzy {
+		words := 0
+		for _, t := range toks {
+			if t.kind == tokWord {
+				words++
+			}
+		}
+		if words > MaxFuzzyTerms {
+			return nil, fmt.Errorf("fuzzy search allows at most %d words", MaxFuzzyTerms)
+		}
+	}
+	p := &queryParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %s in query", p.toks[p.pos])
+	}
+	return &Query{root: root, fuzzy: fuzzy}, nil
+}
+
+// Match reports whether content satisfies q.
+func (q *Query) Match(content string) bool {
+	if q == nil || q.root == nil {
+		return true
+	}
+	return q.root.match(&queryDoc{lower: strings.ToLower(content), fuzzy: q.fuzzy})
+}
+
+// Relevance scoring: each positive word or phrase adds 1+ln(count) for the
+// times it occurs, and a recency bonus of up to recencyWeight decays with
+// a half-life of recencyHalfLife, so among equally good matches the newer
+// one ranks first and a week-old exact hit still beats a fresh weak one.
+const (
+	recencyWeight   = 1.0
+	recencyHalfLife = 7 * 24 * time.Hour
+)
+
+// Score rates how well content, posted age ago, matches q.  Higher is better.
+func (q *Query) Score(content string, age time.Duration) float64 {
+	var score float64
+	if q != nil && q.root != nil {
+		lower := strings.ToLower(content)
+		var terms []string
+		q.root.terms(&terms, false)
+		for _, t := range terms {
+			if n := strings.Count(lower, t); n > 0 {
+				score += 1 + math.Log(float64(n))
+			}
+		}
+	}
+	return score + recencyWeight*math.Exp2(-float64(age)/float64(recencyHalfLife))
+}
+
+// queryDoc is one message being matched.  Its words are split on first use
+// and only in fuzzy mode.
+type queryDoc struct {
+	lower string
+	fuzzy bool
+	words [][]rune
+	split bool
+}
+
+func (d *queryDoc) wordList() [][]rune {
+	if !d.split {
+		for _, w := range strings.FieldsFunc(d.lower, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			d.words = append(d.words, []rune(w))
+		}
+		d.split = true
+	}
+	return d.words
+}
+
+type node interface {
+	match(d *queryDoc) bool
+	terms(out *[]string, negated bool) // collects the positive words/phrases
+}
+
+type termNode string   // a single word
+type phraseNode string // a quoted phrase, never fuzzy
+type notNode struct{ n node }
+type andNode []node
+type orNode []node
+
+func (p phraseNode) match(d *queryDoc) bool { return strings.Contains(d.lower, string(p)) }
+func (n notNode) match(d *queryDoc) bool    { return !n.n.match(d) }
+
+func (t termNode) match(d *queryDoc) bool {
+	if strings.Contains(d.lower, string(t)) {
+		return true
+	}
+	if !d.fuzzy {
+		return false
+	}
+	term := []rune(string(t))
+	k := fuzzyDistance(len(term))
+	if k == 0 {
+		return false
+	}
+	for _, w := range d.wordList() {
+		if withinDistance(term, w, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t termNode) terms(out *[]string, negated bool) {
+	if !negated {
+		*out = append(*out, string(t))
+	}
+}
+
+func (p phraseNode) terms(out *[]string, negated bool) {
+	if !negated {
+		*out = append(*out, string(p))
+	}
+}
+
+func (n notNode) terms(out *[]string, negated bool) { n.n.terms(out, !negated) }
+
+func (a andNode) terms(out *[]string, negated bool) {
+	for _, n := range a {
+		n.terms(out, negated)
+	}
+}
+
+func (o orNode) terms(out *[]string, negated bool) {
+	for _, n := range o {
+		n.terms(out, negated)
+	}
+}
+
+func (a andNode) match(d *queryDoc) bool {
+	for _, n := range a {
+		if !n.match(d) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o orNode) match(d *queryDoc) bool {
+	for _, n := range o {
+		if n.match(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyDistance is the number of typos tolerated in a word of n runes.
+// Short words must match exactly (one edit turns "cat" into "car", "hat",
+// "at", …) and very long ones are not worth the quadratic comparison.
+func fuzzyDistance(n int) int {
+	switch {
+	case n < 4 || n > 32:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// withinDistance reports whether a and b are at most k edits apart, where an
+// edit is an insertion, deletion, substitution or swap of adjacent runes
+// (optimal string alignment distance).  It gives up as soon as a whole row
+// exceeds k.
+func withinDistance(a, b []rune, k int) bool {
+	if d := len(a) - len(b); d > k || -d > k {
+		return false
+	}
+	prev2 := make([]int, len(b)+1) // row i-2, for transpositions
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > k {
+			return false
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)] <= k
+}
+
+// ---------------------------------------------------------------------------
+// Lexer and recursive-descent parser
+// ---------------------------------------------------------------------------
+
+type tokKind int
+
+const (
+	tokWord tokKind = iota
+	tokPhrase
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokPhrase:
+		return fmt.Sprintf("%q", t.text)
+	case tokLParen:
+		return "'('"
+	case tokRParen:
+		return "')'"
+	}
+	return t.text
+}
+
+func lexQuery(s string) ([]token, error) {
+	var toks []token
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		switch c := r[i]; {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen})
+			i++
+		case c == '-' && (i == 0 || unicode.IsSpace(r[i-1]) || r[i-1] == '('):
+			toks = append(toks, token{kind: tokNot, text: "-"})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(r) && r[end] != '"' {
+				end++
+			}
+			if end == len(r) {
+				return nil, fmt.Errorf("unterminated quote in query")
+			}
+			if phrase := strings.TrimSpace(string(r[i+1 : end])); phrase != "" {
+				toks = append(toks, token{kind: tokPhrase, text: strings.ToLower(phrase)})
+			}
+			i = end + 1
+		default:
+			start := i
+			for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != '(' && r[i] != ')' && r[i] != '"' {
+				i++
+			}
+			word := string(r[start:i])
+			switch word {
+			case "AND":
+				toks = append(toks, token{kind: tokAnd, text: word})
+			case "OR":
+				toks = append(toks, token{kind: tokOr, text: word})
+			case "NOT":
+				toks = append(toks, token{kind: tokNot, text: word})
+			default:
+				toks = append(toks, token{kind: tokWord, text: strings.ToLower(word)})
+			}
+		}
+	}
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *queryParser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// parseOr := parseAnd ("OR" parseAnd)*
+func (p *queryParser) parseOr() (node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := orNode{first}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokOr {
+			break
+		}
+		p.pos++
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, n)
+	}
+	if len(or) == 1 {
+		return first, nil
+	}
+	return or, nil
+}
+
+// parseAnd := parseUnary (["AND"] parseUnary)*
+func (p *queryParser) parseAnd() (node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	and := andNode{first}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == tokOr || t.kind == tokRParen {
+			break
+		}
+		if t.kind == tokAnd {
+			p.pos++
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, n)
+	}
+	if len(and) == 1 {
+		return first, nil
+	}
+	return and, nil
+}
+
+// parseUnary := ("NOT" | "-") parseUnary | "(" parseOr ")" | word | phrase
+func (p *queryParser) parseUnary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("query ends unexpectedly")
+	}
+	p.pos++
+	switch t.kind {
+	case tokNot:
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.kind != tokRParen {
+			return nil, fmt.Errorf("missing ')' in query")
+		}
+		p.pos++
+		return n, nil
+	case tokWord:
+		return termNode(t.text), nil
+	case tokPhrase:
+		return phraseNode(t.text), nil
+	}
+	return nil, fmt.Errorf("unexpected %s in query", t)
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Rate limit counters
+// ---------------------------------------------------------------------------
+//
+// The server counts what it rate-limits, like each account's posting, in
+// token buckets kept here rather than on the connection, so that logging
+// in again, on another connection or after a restart, does not refill
+// them.  A bucket is saved in ratelimits.json by SaveRateLimits, which the
+// server calls once a minute, and by Flush; a crash loses at most the last
+// minute's counting.  The buckets taken from travel to standbys with the
+// next change to the accounts or the archive (see replication.go), so a promoted standby goes on counting where the
+// primary stopped.
+//
+// A bucket that has refilled holds nothing a new one would not, so
+// PruneRateLimits forgets the buckets that are full by then.
+
+// RateCounter is one token bucket.
+type RateCounter struct {
+	Tokens float64   `json:"tokens"`
+	At     time.Time `json:"at"`   // when Tokens was counted
+	Full   time.Time `json:"full"` // when the bucket is full again
+}
+
+// TakeRate takes one token from the bucket key, which is refilled at rate
+// tokens a second up to burst.  It returns 0 when it took one, and
+// otherwise how long until there is one to take.  A key seen for the first
+// time starts with a full bucket.
+func (s *Store) TakeRate(key string, rate, burst float64, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.limits[key]
+	if !ok {
+		b = RateCounter{Tokens: burst, At: now}
+	}
+	b.Tokens = min(burst, b.Tokens+max(0, now.Sub(b.At).Seconds())*rate)
+	b.At = now
+	var wait time.Duration
+	if b.Tokens < 1 {
+		wait = time.Duration((1 - b.Tokens) / rate * float64(time.Second))
+	} else {
+		b.Tokens--
+	}
+	b.Full = now.Add(time.Duration((burst - b.Tokens) / rate * float64(time.Second)))
+	s.limits[key] = b
+	s.limitsUnsaved = true
+	if s.repl != nil {
+		s.repl.limits[key] = true
+	}
+	return wait
+}
+
+// RefundRate gives back a token taken from the bucket key with TakeRate,
+// for something that was refused after all.
+func (s *Store) RefundRate(key string, rate, burst float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.limits[key]
+	if !ok {
+		return // refilled and pruned meanwhile
+	}
+	b.Tokens = min(burst, b.Tokens+1)
+	b.Full = b.At.Add(time.Duration((burst - b.Tokens) / rate * float64(time.Second)))
+	s.limits[key] = b
+	s.limitsUnsaved = true
+	if s.repl != nil {
+		s.repl.limits[key] = true
+	}
+}
+
+// PruneRateLimits forgets the buckets that are full at now, returning how
+// many.
+func (s *Store) PruneRateLimits(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.limits)
+	maps.DeleteFunc(s.limits, func(_ string, b RateCounter) bool { return !b.Full.After(now) })
+	if len(s.limits) < n {
+		s.limitsUnsaved = true
+	}
+	return n - len(s.limits)
+}
+
+// SaveRateLimits writes the buckets to ratelimits.json, if they changed
+// since the last save.
+func (s *Store) SaveRateLimits() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveRateLimitsLocked()
+}
+
+func (s *Store) loadRateLimits() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "ratelimits.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.limits); err != nil {
+		return fmt.Errorf("store: parse ratelimits.json: %w", err)
+	}
+	if s.limits == nil {
+		s.limits = make(map[string]RateCounter)
+	}
+	return nil
+}
+
+func (s *Store) saveRateLimitsLocked() error {
+	if !s.limitsUnsaved {
+		return nil
+	}
+	if err := writeJSON(filepath.Join(s.dataDir, "ratelimits.json"), s.limits); err != nil {
+		return err
+	}
+	s.limitsUnsaved = false
+	return nil
+}
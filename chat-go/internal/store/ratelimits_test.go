@@ -0,0 +1,83 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateRefund runs TakeRate and RefundRate against one bucket that
+// refills a token a second up to two.
+func TestRateRefund(t *testing.T) {
+	const rate, burst = 1, 2
+	type step struct {
+		op   string        // "take", "refund", or "prune"
+		at   time.Duration // since the start of the test
+		want int64         // for take, the wait in ns; for prune, buckets pruned
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then wait", []step{
+			{"take", 0, 0},
+			{"take", 0, 0},
+			{"take", 0, int64(time.Second)},
+			{"take", 500 * time.Millisecond, int64(500 * time.Millisecond)},
+		}},
+		{"refund after refusal", []step{
+			{"take", 0, 0},
+			{"take", 0, 0},
+			{"refund", 0, 0},
+			{"take", 0, 0},
+			{"take", 0, int64(time.Second)},
+		}},
+		{"refund never overfills", []step{
+			{"take", 0, 0},
+			{"refund", 0, 0},
+			{"refund", 0, 0},
+			{"take", 0, 0},
+			{"take", 0, 0},
+			{"take", 0, int64(time.Second)},
+		}},
+		{"refund of a pruned bucket", []step{
+			{"take", 0, 0},
+			{"prune", 2 * time.Second, 1},
+			{"refund", 2 * time.Second, 0},
+			{"prune", 2 * time.Second, 0},
+			{"take", 2 * time.Second, 0},
+			{"take", 2 * time.Second, 0},
+			{"take", 2 * time.Second, int64(time.Second)},
+		}},
+		{"refunded bucket is full sooner", []step{
+			{"take", 0, 0},
+			{"take", 0, 0},
+			{"refund", 0, 0},
+			{"prune", time.Second - time.Millisecond, 0},
+			{"prune", time.Second, 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i, st := range tt.steps {
+				now := start.Add(st.at)
+				var got int64
+				switch st.op {
+				case "take":
+					got = int64(s.TakeRate("post:u1", rate, burst, now))
+				case "refund":
+					s.RefundRate("post:u1", rate, burst)
+				case "prune":
+					got = int64(s.PruneRateLimits(now))
+				}
+				if got != st.want {
+					t.Errorf("step %d (%s at %v) = %d, want %d", i, st.op, st.at, got, st.want)
+				}
+			}
+		})
+	}
+}
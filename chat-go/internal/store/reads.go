@@ -0,0 +1,105 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Read marks
+// ---------------------------------------------------------------------------
+//
+// A read mark is how far a user has read one conversation, kept by message
+// Seq so that it can be compared and counted from.  They are saved in
+// reads.json, keyed by user ID and then by channel.
+
+// readMarks are one user's read marks, keyed by channel.
+type readMarks map[string]protocol.ReadMark
+
+// MarkRead moves the read mark of the user with the given ID in msg's
+// channel up to msg.  It returns the Seq of the mark before, and false
+// when the mark was already at or past msg, which leaves it alone.
+func (s *Store) MarkRead(userID string, msg *protocol.StoredMessage) (prev uint64, moved bool, err error) {
+	if msg.Seq == 0 {
+		return 0, false, nil // archived before messages were numbered
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	marks := s.reads[userID]
+	prev = marks[msg.Channel].Seq
+	if prev >= msg.Seq {
+		return prev, false, nil
+	}
+	if marks == nil {
+		marks = make(readMarks)
+		s.reads[userID] = marks
+	}
+	marks[msg.Channel] = protocol.ReadMark{Channel: msg.Channel, ID: msg.ID, Seq: msg.Seq, At: time.Now().UTC()}
+	return prev, true, s.saveReadsLocked()
+}
+
+// ReadMarks returns the read marks of the user with the given ID, with
+// Unread counting the later messages by others in each conversation.
+func (s *Store) ReadMarks(userID string) []protocol.ReadMark {
+	s.mu.RLock()
+	marks := make([]protocol.ReadMark, 0, len(s.reads[userID]))
+	at := make(map[string]int, len(s.reads[userID]))
+	for ch, m := range s.reads[userID] {
+		at[ch] = len(marks)
+		marks = append(marks, m)
+	}
+	s.mu.RUnlock()
+
+	for _, m := range s.snapshot() {
+		if i, ok := at[m.Channel]; ok && m.Seq > marks[i].Seq && m.UserID != userID {
+			marks[i].Unread++
+		}
+	}
+	return marks
+}
+
+// Readers returns the IDs of the users, other than its author, whose mark
+// in msg's channel is at or past msg.
+func (s *Store) Readers(msg *protocol.StoredMessage) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []string
+	for userID, marks := range s.reads {
+		if m, ok := marks[msg.Channel]; ok && msg.Seq > 0 && m.Seq >= msg.Seq && userID != msg.UserID {
+			ids = append(ids, userID)
+		}
+	}
+	return ids
+}
+
+// Authors returns the IDs of those who posted in channel with
+// after < Seq <= upto.
+func (s *Store) Authors(channel string, after, upto uint64) map[string]bool {
+	ids := make(map[string]bool)
+	for _, m := range s.snapshot() {
+		if m.Channel == channel && m.Seq > after && m.Seq <= upto {
+			ids[m.UserID] = true
+		}
+	}
+	return ids
+}
+
+func (s *Store) loadReads() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "reads.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.reads); err != nil {
+		return fmt.Errorf("store: parse reads.json: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) saveReadsLocked() error {
+	return writeJSON(filepath.Join(s.dataDir, "reads.json"), s.reads)
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// SourceRelay marks a relay identity: an account that another account,
+// typically a bridge bot, posts as, such as an IRC user whose messages the
+// bot passes on.  Like bot accounts, relay identities have no password and
+// cannot log in.  An account may only post as identities whose names start
+// with one of its RelayPrefixes, which admins grant.
+const SourceRelay = "relay"
+
+// relayGranted reports whether u may post as the identity username.
+func relayGranted(u *User, username string) bool {
+	name := strings.ToLower(username)
+	for _, p := range u.RelayPrefixes {
+		if p = strings.ToLower(p); len(name) > len(p) && strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantRelay lets the account username post as the relay identities whose
+// names start with prefix.
+func (s *Store) GrantRelay(username, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		return fmt.Errorf("user %q not found", username)
+	}
+	if u.Source == SourceBot || u.Source == SourceRelay {
+		return fmt.Errorf("%q cannot log in, so it cannot relay", u.Username)
+	}
+	if prefix == "" || strings.ContainsFunc(prefix, func(r rune) bool { return r <= ' ' }) {
+		return fmt.Errorf("%q is not a name prefix", prefix)
+	}
+	if slices.ContainsFunc(u.RelayPrefixes, func(p string) bool { return strings.EqualFold(p, prefix) }) {
+		return nil
+	}
+	u.RelayPrefixes = append(slices.Clip(u.RelayPrefixes), prefix)
+	return s.saveUsersLocked()
+}
+
+// RevokeRelay takes back a grant made with GrantRelay.  Identities already
+// created stay, with their messages, but can no longer be posted as.
+func (s *Store) RevokeRelay(username, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[strings.ToLower(username)]
+	if !ok {
+		return fmt.Errorf("user %q not found", username)
+	}
+	i := slices.IndexFunc(u.RelayPrefixes, func(p string) bool { return strings.EqualFold(p, prefix) })
+	if i < 0 {
+		return fmt.Errorf("%s has no relay grant for %q", u.Username, prefix)
+	}
+	u.RelayPrefixes = slices.Concat(u.RelayPrefixes[:i], u.RelayPrefixes[i+1:])
+	return s.saveUsersLocked()
+}
+
+// RelayGrants lists the accounts with relay grants, by username.
+func (s *Store) RelayGrants() []protocol.RelayGrant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []protocol.RelayGrant
+	for _, u := range s.users {
+		if len(u.RelayPrefixes) > 0 {
+			out = append(out, protocol.RelayGrant{Username: u.Username, Prefixes: slices.Clone(u.RelayPrefixes)})
+		}
+	}
+	slices.SortFunc(out, func(a, b protocol.RelayGrant) int { return strings.Compare(a.Username, b.Username) })
+	return out
+}
+
+// RelayUser returns the relay identity username of the account with ID
+// ownerID, creating it on first use.  It refuses a name the owner has no
+// grant for and one that already belongs to another account.
+func (s *Store) RelayUser(ownerID, username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner, ok := s.byID[ownerID]
+	if !ok || !relayGranted(owner, username) {
+		return nil, fmt.Errorf("you have no relay grant for %q", username)
+	}
+	if ReservedName(username) {
+		return nil, fmt.Errorf("username %q is reserved", username)
+	}
+	key := strings.ToLower(username)
+	if u, ok := s.users[key]; ok {
+		if u.Source != SourceRelay || u.RelayOf != ownerID {
+			return nil, fmt.Errorf("username %q belongs to another account", username)
+		}
+		return copyUser(u), nil
+	}
+	u := &User{
+		ID:        generateID(),
+		Username:  username,
+		Role:      RoleMember,
+		Source:    SourceRelay,
+		RelayOf:   ownerID,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.users[key] = u
+	s.byID[u.ID] = u
+	return copyUser(u), s.saveUsersLocked()
+}
@@ -0,0 +1,251 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Replication log
+// ---------------------------------------------------------------------------
+//
+// A primary server keeps an in-memory log of the changes to its accounts
+// and message archive so a standby can follow along (see the server's
+// replication.go).  Each save of users.json or the message archive, and each
+// committed transaction, appends one Change with the next log sequence
+// number (LSN): the accounts that were added or changed, the IDs of those
+// deleted, and the messages appended.  The rate limit counters taken from
+// since the last Change (see ratelimits.go) go along with them.  Accounts
+// are only compared with what was shipped when users.json is saved, so
+// saving a message costs no more than the messages it appends.
+//
+// Removing messages (a purge, pruning old days) is not logged: it empties
+// the log and skips an LSN, so every standby starts over from a Snapshot.
+// Otherwise the log keeps the most recent changes up to about maxReplBytes
+// of them.  A standby that falls further behind, or that was following an
+// earlier run of the primary, starts over from a Snapshot too.  Channels,
+// polls, preferences and files are not replicated.
+
+const maxReplBytes = 64 << 20
+
+// Change is one entry of the replication log.
+type Change struct {
+	LSN          uint64                    `json:"lsn"`
+	Users        []*User                   `json:"users,omitempty"`         // added or changed accounts
+	DeletedUsers []string                  `json:"deleted_users,omitempty"` // IDs of removed accounts
+	Messages     []*protocol.StoredMessage `json:"messages,omitempty"`      // appended
+	Limits       map[string]RateCounter    `json:"limits,omitempty"`        // changed rate limit counters
+}
+
+// Snapshot is the replicated state as of LSN.
+type Snapshot struct {
+	LSN      uint64                    `json:"lsn"`
+	Users    []*User                   `json:"users"`
+	Messages []*protocol.StoredMessage `json:"messages"`
+	Limits   map[string]RateCounter    `json:"limits,omitempty"`
+}
+
+// replLog is what the log has shipped so far, to work out the next Change.
+type replLog struct {
+	users   map[string]User // by ID, as last shipped
+	msgs    int             // length of the archive when last shipped
+	last    *protocol.StoredMessage
+	limits  map[string]bool // counters taken from since the last Change
+	lsn     uint64
+	changes []Change      // newest last
+	size    int           // estimated bytes of changes
+	wake    chan struct{} // closed and replaced on every change
+}
+
+// EnableReplicationLog starts recording changes for standbys.
+func (s *Store) EnableReplicationLog() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.repl != nil {
+		return
+	}
+	r := &replLog{users: make(map[string]User, len(s.byID)), limits: make(map[string]bool), wake: make(chan struct{})}
+	for id, u := range s.byID {
+		r.users[id] = *u
+	}
+	r.msgs = len(s.messages)
+	if r.msgs > 0 {
+		r.last = s.messages[r.msgs-1]
+	}
+	s.repl = r
+}
+
+// ReplicationSnapshot returns the replicated state and the LSN it is at.
+func (s *Store) ReplicationSnapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := Snapshot{Messages: slices.Clone(s.messages), Limits: maps.Clone(s.limits)}
+	for _, u := range s.userListLocked() {
+		snap.Users = append(snap.Users, copyUser(u))
+	}
+	if s.repl != nil {
+		snap.LSN = s.repl.lsn
+	}
+	return snap
+}
+
+// ChangesSince returns the changes after lsn, and a channel that is closed
+// when the next one is logged.  ok is false when the log no longer reaches
+// back to lsn (or has never reached it) and the caller needs a snapshot.
+func (s *Store) ChangesSince(lsn uint64) (changes []Change, wake <-chan struct{}, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := s.repl
+	if r == nil {
+		return nil, nil, false
+	}
+	if lsn > r.lsn {
+		return nil, nil, false
+	}
+	if lsn == r.lsn {
+		return nil, r.wake, true
+	}
+	first := r.lsn - uint64(len(r.changes)) + 1
+	if lsn+1 < first {
+		return nil, nil, false
+	}
+	return slices.Clone(r.changes[lsn+1-first:]), r.wake, true
+}
+
+// replicateLocked logs what changed in the archive and the rate limit
+// counters since the last call, and in the accounts too when users is set.
+// Savers call it once their state is final.
+func (s *Store) replicateLocked(users bool) {
+	r := s.repl
+	if r == nil {
+		return
+	}
+	var c Change
+	if users {
+		for id, u := range s.byID {
+			if old, ok := r.users[id]; !ok || !reflect.DeepEqual(old, *u) {
+				cp := *u
+				c.Users = append(c.Users, &cp)
+				r.users[id] = cp
+			}
+		}
+		for id := range r.users {
+			if _, ok := s.byID[id]; !ok {
+				c.DeletedUsers = append(c.DeletedUsers, id)
+				delete(r.users, id)
+			}
+		}
+	}
+	n := len(s.messages)
+	reset := n < r.msgs || r.msgs > 0 && s.messages[r.msgs-1] != r.last
+	if !reset {
+		c.Messages = slices.Clone(s.messages[r.msgs:])
+	}
+	r.msgs, r.last = n, nil
+	if n > 0 {
+		r.last = s.messages[n-1]
+	}
+	for key := range r.limits {
+		// A pruned counter is full, and the standby prunes it itself.
+		if b, ok := s.limits[key]; ok {
+			if c.Limits == nil {
+				c.Limits = make(map[string]RateCounter)
+			}
+			c.Limits[key] = b
+		}
+	}
+	clear(r.limits)
+
+	switch {
+	case reset:
+		r.lsn++ // logged nowhere, so every standby needs a snapshot
+		r.changes, r.size = nil, 0
+	case len(c.Users) == 0 && len(c.DeletedUsers) == 0 && len(c.Messages) == 0 && len(c.Limits) == 0:
+		return
+	default:
+		r.lsn++
+		c.LSN = r.lsn
+		r.changes = append(r.changes, c)
+		r.size += changeSize(c)
+		k := 0
+		for ; k < len(r.changes)-1 && r.size > maxReplBytes; k++ {
+			r.size -= changeSize(r.changes[k])
+		}
+		r.changes = slices.Delete(r.changes, 0, k)
+	}
+	close(r.wake)
+	r.wake = make(chan struct{})
+}
+
+// changeSize estimates how much of the log c takes up.
+func changeSize(c Change) int {
+	n := 64 + 512*len(c.Users) + 32*len(c.DeletedUsers) + 96*len(c.Limits)
+	for _, m := range c.Messages {
+		n += 256 + len(m.Content) + len(m.Meta)
+	}
+	return n
+}
+
+// ApplySnapshot replaces the accounts and the archive with snap's, on a
+// standby.
+func (s *Store) ApplySnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = make(map[string]*User, len(snap.Users))
+	s.byID = make(map[string]*User, len(snap.Users))
+	for _, u := range snap.Users {
+		s.users[strings.ToLower(u.Username)] = u
+		s.byID[u.ID] = u
+	}
+	s.messages = snap.Messages
+	s.limits, s.limitsUnsaved = snap.Limits, true
+	if s.limits == nil {
+		s.limits = make(map[string]RateCounter)
+	}
+	if err := s.saveUsersLocked(); err != nil {
+		return err
+	}
+	return s.saveMessagesLocked()
+}
+
+// ApplyChange applies a primary's change on a standby.
+func (s *Store) ApplyChange(c Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range c.Users {
+		if old, ok := s.byID[u.ID]; ok && !strings.EqualFold(old.Username, u.Username) {
+			delete(s.users, strings.ToLower(old.Username))
+		}
+		s.users[strings.ToLower(u.Username)] = u
+		s.byID[u.ID] = u
+	}
+	for _, id := range c.DeletedUsers {
+		if u, ok := s.byID[id]; ok {
+			delete(s.users, strings.ToLower(u.Username))
+			delete(s.byID, id)
+		}
+	}
+	if len(c.Users) > 0 || len(c.DeletedUsers) > 0 {
+		if err := s.saveUsersLocked(); err != nil {
+			return fmt.Errorf("store: apply change %d: %w", c.LSN, err)
+		}
+	}
+	maps.Copy(s.limits, c.Limits)
+	if len(c.Limits) > 0 {
+		s.limitsUnsaved = true // saved with SaveRateLimits, like the primary's
+	}
+	if len(c.Messages) > 0 {
+		s.messages = append(s.messages, c.Messages...)
+		if err := s.saveMessagesLocked(); err != nil {
+			return fmt.Errorf("store: apply change %d: %w", c.LSN, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Message revisions
+// ---------------------------------------------------------------------------
+//
+// When messages are removed, by moderators or by their authors, the store
+// keeps what they said as protocol.MessageRevisions in revisions.json, so
+// later moderation can consider it.  Messages cannot be edited, so deletions are the only
+// revisions there are.  Erasing an account's messages (Tx.PurgeMessages)
+// erases their revisions too and keeps none of its own.  The file holds
+// the newest maxRevisions.
+
+const maxRevisions = 50_000
+
+// TombstoneMessages removes every message match selects, like
+// DeleteMessages, and keeps a revision of each saying by removed it and
+// why.
+func (tx *Tx) TombstoneMessages(match func(*protocol.StoredMessage) bool, by, reason string) int {
+	s := tx.s
+	now := time.Now().UTC()
+	var revs []*protocol.MessageRevision
+	n := tx.DeleteMessages(func(m *protocol.StoredMessage) bool {
+		if !match(m) {
+			return false
+		}
+		revs = append(revs, &protocol.MessageRevision{Message: *m, Action: protocol.RevisionDeleted, By: by, Reason: reason, At: now})
+		return true
+	})
+	if n > 0 {
+		old := s.revisions
+		s.revisions = append(s.revisions[:len(s.revisions):len(s.revisions)], revs...)
+		if len(s.revisions) > maxRevisions {
+			s.revisions = s.revisions[len(s.revisions)-maxRevisions:]
+		}
+		tx.changed(func() { s.revisions = old }, "revisions.json")
+	}
+	return n
+}
+
+// DeleteMessage removes the message with the given ID, keeping a revision
+// of it like TombstoneMessages, and reports whether there was one.
+func (s *Store) DeleteMessage(id, by, reason string) (bool, error) {
+	var n int
+	err := s.Update(func(tx *Tx) error {
+		n = tx.TombstoneMessages(func(m *protocol.StoredMessage) bool { return m.ID == id }, by, reason)
+		return nil
+	})
+	return n > 0, err
+}
+
+// dropRevisions removes the revisions of the messages match selects.
+func (tx *Tx) dropRevisions(match func(*protocol.StoredMessage) bool) {
+	s := tx.s
+	old := s.revisions
+	kept := make([]*protocol.MessageRevision, 0, len(old))
+	for _, r := range old {
+		if !match(&r.Message) {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) < len(old) {
+		s.revisions = kept
+		tx.changed(func() { s.revisions = old }, "revisions.json")
+	}
+}
+
+// Revisions returns up to limit kept revisions that match selects, newest
+// first.
+func (s *Store) Revisions(match func(*protocol.MessageRevision) bool, limit int) []protocol.MessageRevision {
+	defer s.timed(OpRevisions, time.Now(), nil)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []protocol.MessageRevision{}
+	for i := len(s.revisions) - 1; i >= 0 && len(out) < limit; i-- {
+		if r := s.revisions[i]; match(r) {
+			out = append(out, *r)
+		}
+	}
+	return out
+}
+
+func (s *Store) loadRevisions() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "revisions.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.revisions); err != nil {
+		return fmt.Errorf("store: parse revisions.json: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// AddScheduled queues msg for later delivery.  It fails when the user already
+// has limit messages pending.
+func (s *Store) AddScheduled(msg *protocol.ScheduledMessage, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, m := range s.scheduled {
+		if m.UserID == msg.UserID {
+			n++
+		}
+	}
+	if n >= limit {
+		return fmt.Errorf("you already have %d scheduled message(s); cancel one first", n)
+	}
+	msg.ID = generateID()
+	s.scheduled = append(s.scheduled, msg)
+	return s.saveScheduledLocked()
+}
+
+// ListScheduled returns userID's pending messages, soonest first.
+func (s *Store) ListScheduled(userID string) []*protocol.ScheduledMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*protocol.ScheduledMessage, 0)
+	for _, m := range s.scheduled {
+		if m.UserID == userID {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SendAt.Before(out[j].SendAt) })
+	return out
+}
+
+// CancelScheduled removes the pending message id if it belongs to userID.
+func (s *Store) CancelScheduled(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.scheduled {
+		if m.ID == id && m.UserID == userID {
+			s.scheduled = append(s.scheduled[:i], s.scheduled[i+1:]...)
+			return s.saveScheduledLocked()
+		}
+	}
+	return fmt.Errorf("no scheduled message %q", id)
+}
+
+// TakeDueScheduled removes and returns every message whose SendAt is not
+// after now, oldest first.
+func (s *Store) TakeDueScheduled(now time.Time) ([]*protocol.ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*protocol.ScheduledMessage
+	kept := s.scheduled[:0]
+	for _, m := range s.scheduled {
+		if m.SendAt.After(now) {
+			kept = append(kept, m)
+		} else {
+			due = append(due, m)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	s.scheduled = kept
+	sort.Slice(due, func(i, j int) bool { return due[i].SendAt.Before(due[j].SendAt) })
+	return due, s.saveScheduledLocked()
+}
+
+func (s *Store) loadScheduled() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "scheduled.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.scheduled); err != nil {
+		return fmt.Errorf("store: parse scheduled.json: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) saveScheduledLocked() error {
+	return writeJSON(filepath.Join(s.dataDir, "scheduled.json"), s.scheduled)
+}
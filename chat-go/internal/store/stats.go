@@ -0,0 +1,103 @@
+package store
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// peak is the most users ever online at once, persisted in stats.json.
+type peak struct {
+	Online int       `json:"peak_online"`
+	At     time.Time `json:"peak_at"`
+}
+
+// RecordOnline notes that n users are online now, saving a new peak when n
+// beats the old one.
+func (s *Store) RecordOnline(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n <= s.peak.Online {
+		return nil
+	}
+	s.peak = peak{Online: n, At: time.Now().UTC()}
+	return writeJSON(filepath.Join(s.dataDir, "stats.json"), s.peak)
+}
+
+// Stats summarises activity over the last days UTC days (today included),
+// listing up to top of the most active users.  Online is left for the
+// caller, which knows who is connected.
+func (s *Store) Stats(days, top int) protocol.StatsReport {
+	defer s.timed(OpStats, time.Now(), func() string { return fmt.Sprintf("%d day(s)", days) })
+	now := time.Now().UTC()
+	first := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -(days - 1))
+
+	s.mu.RLock()
+	r := protocol.StatsReport{
+		Users:      len(s.users),
+		Messages:   len(s.messages),
+		PeakOnline: s.peak.Online,
+		PeakAt:     s.peak.At,
+		Daily:      make([]protocol.DayCount, days),
+	}
+	perUser := make(map[string]int)
+	for _, m := range s.messages {
+		if m.Timestamp.Before(first) {
+			continue
+		}
+		if d := int(m.Timestamp.Sub(first) / (24 * time.Hour)); d < days {
+			r.Daily[d].Messages++
+		}
+		perUser[m.Username]++
+	}
+	s.mu.RUnlock()
+
+	for i := range r.Daily {
+		r.Daily[i].Day = first.AddDate(0, 0, i).Format(time.DateOnly)
+	}
+	r.TopUsers = make([]protocol.UserCount, 0, len(perUser))
+	for name, n := range perUser {
+		r.TopUsers = append(r.TopUsers, protocol.UserCount{Username: name, Messages: n})
+	}
+	slices.SortFunc(r.TopUsers, func(a, b protocol.UserCount) int {
+		return cmp.Or(cmp.Compare(b.Messages, a.Messages), cmp.Compare(a.Username, b.Username))
+	})
+	if len(r.TopUsers) > top {
+		r.TopUsers = r.TopUsers[:top]
+	}
+	r.StorageBytes = dirSize(s.dataDir)
+	return r
+}
+
+// dirSize is the total size of the regular files under dir.
+func dirSize(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			n += info.Size()
+		}
+		return nil
+	})
+	return n
+}
+
+func (s *Store) loadStats() error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "stats.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.peak); err != nil {
+		return fmt.Errorf("store: parse stats.json: %w", err)
+	}
+	return nil
+}
@@ -3,37 +3,115 @@
 package store
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"cmp"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"chat/internal/protocol"
 )
 
+// logger returns the logger the Store logs to: slog's default, as the
+// "store" component.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "store")
+}
+
+// Account roles, lowest privilege first.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// RoleRank orders roles by privilege so callers can write
+// RoleRank(r) >= RoleRank(RoleModerator).  Unknown roles rank lowest.
+func RoleRank(role string) int {
+	switch role {
+	case RoleMember, "":
+		return 1
+	case RoleModerator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
 // User is a registered account.
 type User struct {
 	ID           string    `json:"id"`
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"password_hash"`
+	Role         string    `json:"role,omitempty"`   // empty means RoleMember
+	Source       string    `json:"source,omitempty"` // external auth provider; empty for local accounts
 	CreatedAt    time.Time `json:"created_at"`
+
+	// Activity and lifecycle, see accounts.go.
+	Email            string    `json:"email,omitempty"`             // where inactivity warnings and unlock codes go
+	Ntfy             string    `json:"ntfy,omitempty"`              // ntfy topic for unlock codes
+	LastSeenAt       time.Time `json:"last_seen_at,omitzero"`       // last login or logout
+	InactiveWarnedAt time.Time `json:"inactive_warned_at,omitzero"` // flagged as inactive
+	DeactivatedAt    time.Time `json:"deactivated_at,omitzero"`     // may not log in
+	FailedLogins     int       `json:"failed_logins,omitempty"`     // wrong passwords in a row
+	LockedAt         time.Time `json:"locked_at,omitzero"`          // locked after failed logins
+
+	// Relaying, see relay.go.  RelayPrefixes is replaced, never changed
+	// in place, since copies of the account share it.
+	RelayPrefixes []string `json:"relay_prefixes,omitempty"` // may post as relay identities with these prefixes
+	RelayOf       string   `json:"relay_of,omitempty"`       // the account posting as this relay identity
+
+	// Moderation, see moderation.go.
+	BannedAt   time.Time `json:"banned_at,omitzero"`   // may not log in
+	BanReason  string    `json:"ban_reason,omitempty"` // told to the user when they try
+	MutedAt    time.Time `json:"muted_at,omitzero"`    // may read but not post
+	MutedUntil time.Time `json:"muted_until,omitzero"` // when the mute ends; zero: when it is lifted
+	MuteReason string    `json:"mute_reason,omitempty"`
 }
 
 // Store holds users and messages in memory and persists them to disk.
 // A sync.RWMutex protects the in-memory state so multiple goroutines can read
-// concurrently while writes are serialised.
+// concurrently while writes are serialised.  Queries that go through the
+// whole message archive, like Search, work on a snapshot of it instead, so
+// a long one never holds up the writers.
 type Store struct {
-	mu       sync.RWMutex
-	users    map[string]*User          // keyed by lower-case username
-	byID     map[string]*User          // keyed by user ID
-	messages []*protocol.StoredMessage // ordered by insertion time
-	dataDir  string
+	mu        sync.RWMutex
+	users     map[string]*User                // keyed by lower-case username
+	byID      map[string]*User                // keyed by user ID
+	messages  []*protocol.StoredMessage       // ordered by insertion time
+	scheduled []*protocol.ScheduledMessage    // pending future sends
+	polls     map[string]*poll                // keyed by poll ID
+	pollSeq   int                             // last poll ID handed out
+	files     map[string]*File                // uploaded attachments, keyed by ID
+	feeds     map[string][]string             // feed URL → entry IDs already posted
+	channels  map[string]*channel             // public channels, keyed by name
+	prefs     map[string]protocol.Preferences // keyed by user ID
+	reads     map[string]readMarks            // keyed by user ID
+	revisions []*protocol.MessageRevision     // what removed messages said, oldest first
+	peak      peak                            // most users online at once
+	dataDir   string
+
+	durability string   // Durability* level of the message archive
+	unsaved    bool     // messages not yet written, with DurabilityNone
+	repl       *replLog // changes for standbys, see replication.go
+
+	days []partition // the archive by day, see partitions.go
+	disk []partition // the days as the files hold them
+
+	limits        map[string]RateCounter // rate limit buckets, see ratelimits.go
+	limitsUnsaved bool                   // changed since ratelimits.json was written
+
+	auditMu sync.Mutex // serialises appends to audit.jsonl
+
+	times opTimes // see opstats.go
 }
 
 // New creates (or reopens) a Store backed by files in dataDir.
@@ -42,9 +120,17 @@ func New(dataDir string) (*Store, error) {
 		return nil, fmt.Errorf("store: create data dir: %w", err)
 	}
 	s := &Store{
-		users:   make(map[string]*User),
-		byID:    make(map[string]*User),
-		dataDir: dataDir,
+		users:    make(map[string]*User),
+		byID:     make(map[string]*User),
+		polls:    make(map[string]*poll),
+		files:    make(map[string]*File),
+		feeds:    make(map[string][]string),
+		channels: make(map[string]*channel),
+		prefs:    make(map[string]protocol.Preferences),
+		reads:    make(map[string]readMarks),
+		limits:   make(map[string]RateCounter),
+		dataDir:  dataDir,
+		times:    opTimes{ops: newOpCounters()},
 	}
 	if err := s.load(); err != nil {
 		return nil, err
@@ -52,41 +138,173 @@ func New(dataDir string) (*Store, error) {
 	return s, nil
 }
 
+// reservedNames are kept for the server's own notices, so that nobody can
+// post as what looks like the server.
+var reservedNames = map[string]bool{
+	protocol.ServerName: true,
+	"system":            true,
+}
+
+// ReservedName reports whether username is one no account may have.  Case
+// and everything but letters and digits are ignored, so "System" and
+// "ser_ver" are reserved too.
+func ReservedName(username string) bool {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, username)
+	return reservedNames[key]
+}
+
 // RegisterUser creates a new user account.  Returns an error when the username
-// is already taken.
+// is already taken or reserved.
 func (s *Store) RegisterUser(username, password string) (*User, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	u, _, err := s.registerUserLocked(username, hash)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(u), s.saveUsersLocked()
+}
+
+// registerUserLocked adds the account, with the password hash, in memory
+// only and returns a function that takes it out again.
+func (s *Store) registerUserLocked(username, hash string) (*User, func(), error) {
+	if ReservedName(username) {
+		return nil, nil, fmt.Errorf("username %q is reserved", username)
+	}
 	key := strings.ToLower(username)
 	if _, exists := s.users[key]; exists {
-		return nil, fmt.Errorf("username %q is already taken", username)
+		return nil, nil, fmt.Errorf("username %q is already taken", username)
 	}
 
 	u := &User{
 		ID:           generateID(),
 		Username:     username,
-		PasswordHash: hashPassword(password),
+		PasswordHash: hash,
+		Role:         RoleMember,
 		CreatedAt:    time.Now().UTC(),
 	}
 	s.users[key] = u
 	s.byID[u.ID] = u
-	return u, s.saveUsersLocked()
+	return u, func() {
+		delete(s.users, key)
+		delete(s.byID, u.ID)
+	}, nil
 }
 
-// Authenticate verifies credentials and returns the matching User.
+// Authenticate verifies credentials and returns the matching User.  A
+// password hash from before bcrypt is upgraded on the way (see password.go).
 func (s *Store) Authenticate(username, password string) (*User, error) {
+	u, hash, deactivated, err := s.loginAccount(username)
+	if err != nil {
+		return nil, err
+	}
+	ok, stale := checkPassword(hash, password)
+	if !ok {
+		return nil, ErrIncorrectPassword
+	}
+	if deactivated {
+		return nil, fmt.Errorf("account %q was deactivated for inactivity; ask an admin to reactivate it", u.Username)
+	}
+	if stale {
+		s.rehash(u.ID, hash, password)
+	}
+	return u, nil
+}
+
+// loginAccount looks up the account username for Authenticate, returning
+// its password hash and whether it is deactivated, or why it cannot log in
+// with a password at all.
+func (s *Store) loginAccount(username string) (u *User, hash string, deactivated bool, err error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	u, ok := s.users[strings.ToLower(username)]
 	if !ok {
-		return nil, fmt.Errorf("user %q not found", username)
+		return nil, "", false, fmt.Errorf("user %q not found", username)
 	}
-	if u.PasswordHash != hashPassword(password) {
-		return nil, fmt.Errorf("incorrect password")
+	switch u.Source {
+	case "":
+	case SourceBot, SourceRelay:
+		return nil, "", false, fmt.Errorf("user %q is a %s account and cannot log in", u.Username, u.Source)
+	default:
+		return nil, "", false, fmt.Errorf("user %q signs in through %s", username, u.Source)
 	}
-	return u, nil
+	if u.Locked() {
+		return nil, "", false, fmt.Errorf("account %q %w", u.Username, ErrLocked)
+	}
+	return copyUser(u), u.PasswordHash, u.Deactivated(), nil
+}
+
+// UpsertExternalUser returns the local shadow account for a user verified by
+// an external auth provider, creating it on first login.  The provider is the
+// source of truth for the role, so it is refreshed on every call.  An
+// account of the same name that the provider does not own, a local one
+// above all, is never taken over: the directory user is refused instead.
+func (s *Store) UpsertExternalUser(username, role, source string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(username)
+	if ReservedName(username) {
+		return nil, fmt.Errorf("username %q is reserved", username)
+	}
+	if u, ok := s.users[key]; ok {
+		if u.Source != source {
+			return nil, fmt.Errorf("username %q belongs to an account that does not sign in through %s", username, source)
+		}
+		if u.Role == role {
+			return copyUser(u), nil
+		}
+		u.Role = role
+		return copyUser(u), s.saveUsersLocked()
+	}
+
+	u := &User{
+		ID:        generateID(),
+		Username:  username,
+		Role:      role,
+		Source:    source,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.users[key] = u
+	s.byID[u.ID] = u
+	return copyUser(u), s.saveUsersLocked()
+}
+
+// GetUser returns a copy of the account with the given username
+// (case-insensitive), or nil.
+func (s *Store) GetUser(username string) *User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyUser(s.users[strings.ToLower(username)])
+}
+
+// GetUserByID returns a copy of the account with the given ID, or nil.
+func (s *Store) GetUserByID(id string) *User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyUser(s.byID[id])
+}
+
+// copyUser returns a copy of u, or nil when u is.  The Store's own accounts
+// change in place with the lock held, so callers are only ever handed
+// copies, which they may read without it.
+func copyUser(u *User) *User {
+	if u == nil {
+		return nil
+	}
+	cp := *u
+	return &cp
 }
 
 // SaveMessage appends msg to the in-memory list and persists it to disk.
@@ -98,50 +316,218 @@ func (s *Store) SaveMessage(msg *protocol.StoredMessage) error {
 	return s.saveMessagesLocked()
 }
 
-// GetHistory returns the last n messages.  When n <= 0 all messages are
-// returned.
-func (s *Store) GetHistory(n int) []*protocol.StoredMessage {
+// snapshot returns the message archive as it is now, to be read without
+// the lock.  Archived messages are never changed, and the archive is only
+// appended to or replaced as a whole, so the returned slice stays as it
+// was whatever the writers do next.
+func (s *Store) snapshot() []*protocol.StoredMessage {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.messages
+}
 
-	total := len(s.messages)
-	if n <= 0 || n >= total {
-		out := make([]*protocol.StoredMessage, total)
-		copy(out, s.messages)
-		return out
+// HistoryRange returns up to n messages of channel with after < Seq <
+// before (before 0: no upper bound), lowest Seq first.  more reports that
+// the range holds further messages past the n returned.
+func (s *Store) HistoryRange(channel string, after, before uint64, n int) (msgs []*protocol.StoredMessage, more bool) {
+	defer s.timed(OpHistory, time.Now(), func() string { return fmt.Sprintf("channel %q, up to %d after #%d", channel, n, after) })
+	for _, m := range s.snapshot() {
+		if m.Channel == channel && m.Seq > after && (before == 0 || m.Seq < before) {
+			msgs = append(msgs, m)
+		}
 	}
-	out := make([]*protocol.StoredMessage, n)
-	copy(out, s.messages[total-n:])
-	return out
+
+	// The server saves messages in Seq order, but archives written by
+	// older versions, whose workers raced, may be only roughly in order.
+	slices.SortFunc(msgs, func(a, b *protocol.StoredMessage) int { return cmp.Compare(a.Seq, b.Seq) })
+	if n > 0 && len(msgs) > n {
+		return msgs[:n], true
+	}
+	return msgs, false
 }
 
-// Search returns messages matching all non-empty criteria (AND logic):
-//   - query    – case-insensitive substring match against content
-//   - username – case-insensitive exact match against the sender's username
-//   - from     – message timestamp must be >= from (inclusive)
-//   - to       – message timestamp must be <= to   (inclusive)
-func (s *Store) Search(query, username string, from, to *time.Time) []*protocol.StoredMessage {
+// LastSeqs returns the highest Seq stored in each channel, so numbering
+// can carry on after a restart.
+func (s *Store) LastSeqs() map[string]uint64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	last := make(map[string]uint64)
+	for _, m := range s.messages {
+		last[m.Channel] = max(last[m.Channel], m.Seq)
+	}
+	return last
+}
 
-	q := strings.ToLower(query)
-	u := strings.ToLower(username)
+// GetMessage returns the message with the given ID, or nil.
+func (s *Store) GetMessage(id string) *protocol.StoredMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	var out []*protocol.StoredMessage
-	for _, m := range s.messages {
-		if q != "" && !strings.Contains(strings.ToLower(m.Content), q) {
+	// Recent messages are the likeliest targets, so scan from the end.
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ID == id {
+			return s.messages[i]
+		}
+	}
+	return nil
+}
+
+// CountMessages returns how many messages match selects.
+func (s *Store) CountMessages(match func(*protocol.StoredMessage) bool) int {
+	defer s.timed(OpCount, time.Now(), nil)
+	n := 0
+	for _, m := range s.snapshot() {
+		if match(m) {
+			n++
+		}
+	}
+	return n
+}
+
+// GetHistory returns the last n messages of the main channel.  When n <= 0
+// all of them are returned.
+func (s *Store) GetHistory(n int) []*protocol.StoredMessage {
+	msgs, _, _ := s.HistoryBefore(protocol.MainChannel, "", n)
+	return msgs
+}
+
+// HistoryBefore returns up to n messages of channel immediately preceding
+// the message with ID before (the newest n when before is ""), and whether
+// even older messages exist.  ok is false when before names no stored
+// message in channel.
+func (s *Store) HistoryBefore(channel, before string, n int) (msgs []*protocol.StoredMessage, more, ok bool) {
+	defer s.timed(OpHistory, time.Now(), func() string { return fmt.Sprintf("channel %q, up to %d before %q", channel, n, before) })
+	archive := s.snapshot()
+	i := len(archive) - 1
+	if before != "" {
+		for ; i >= 0; i-- {
+			if archive[i].ID == before {
+				break
+			}
+		}
+		if i < 0 || archive[i].Channel != channel {
+			return nil, false, false
+		}
+		i--
+	}
+	// Walk backwards collecting n messages of channel, then look for one
+	// more to answer "are there older ones".
+	for ; i >= 0; i-- {
+		m := archive[i]
+		if m.Channel != channel {
 			continue
 		}
-		if u != "" && !strings.EqualFold(m.Username, u) {
+		if n > 0 && len(msgs) == n {
+			more = true
+			break
+		}
+		msgs = append(msgs, m)
+	}
+	slices.Reverse(msgs)
+	return msgs, more, true
+}
+
+// Conversations lists the direct-message channels userID belongs to and the
+// public channels they joined, most recently active first.  Joined channels
+// without any messages come last.
+func (s *Store) Conversations(userID string) []protocol.ConversationInfo {
+	defer s.timed(OpConversations, time.Now(), func() string { return "user " + userID })
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []protocol.ConversationInfo
+	seen := make(map[string]bool)
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		m := s.messages[i]
+		if seen[m.Channel] {
 			continue
 		}
-		if from != nil && m.Timestamp.Before(*from) {
+		if ch, ok := s.channels[m.Channel]; ok && slices.Contains(ch.Members, userID) {
+			seen[m.Channel] = true
+			out = append(out, protocol.ConversationInfo{Channel: m.Channel, LastAt: m.Timestamp, ReadOnly: !s.mayPostLocked(ch, userID), Archived: !ch.Archived.IsZero()})
 			continue
 		}
-		if to != nil && m.Timestamp.After(*to) {
+		a, b, ok := protocol.DirectMembers(m.Channel)
+		if !ok || (a != userID && b != userID) {
 			continue
 		}
-		out = append(out, m)
+		seen[m.Channel] = true
+		peer := a
+		if peer == userID {
+			peer = b
+		}
+		info := protocol.ConversationInfo{Channel: m.Channel, LastAt: m.Timestamp}
+		if u := s.byID[peer]; u != nil {
+			info.Peer = u.Username
+		}
+		out = append(out, info)
+	}
+	for name, ch := range s.channels {
+		if !seen[name] && slices.Contains(ch.Members, userID) {
+			out = append(out, protocol.ConversationInfo{Channel: name, ReadOnly: !s.mayPostLocked(ch, userID), Archived: !ch.Archived.IsZero()})
+		}
+	}
+	return out
+}
+
+// SearchFilter selects messages for Search.  Criteria are combined with AND
+// logic; zero-valued fields are ignored.
+type SearchFilter struct {
+	Query    *Query     // content expression, see ParseQuery
+	Username string     // case-insensitive exact match against the sender
+	From     *time.Time // message timestamp must be >= From (inclusive)
+	To       *time.Time // message timestamp must be <= To   (inclusive)
+	Channels []string   // conversations to search; nil means all of them
+	Sort     string     // protocol.Sort*; oldest first when empty
+}
+
+// Search returns the messages matching every criterion in f, ordered by
+// f.Sort.  With From or To, only the days of the archive in range are
+// looked at.
+func (s *Store) Search(f SearchFilter) []*protocol.StoredMessage {
+	var out []*protocol.StoredMessage
+	days, total := s.daysBetween(f.From, f.To)
+	defer func(start time.Time) {
+		s.timed(OpSearch, start, func() string {
+			return fmt.Sprintf("%s: %d of %d message(s), %d day(s) searched", f.describe(), len(out), total, len(days))
+		})
+	}(time.Now())
+	for _, day := range days {
+		for _, m := range day {
+			if !f.Query.Match(m.Content) {
+				continue
+			}
+			if f.Username != "" && !strings.EqualFold(m.Username, f.Username) {
+				continue
+			}
+			if f.From != nil && m.Timestamp.Before(*f.From) {
+				continue
+			}
+			if f.To != nil && m.Timestamp.After(*f.To) {
+				continue
+			}
+			if f.Channels != nil && !slices.Contains(f.Channels, m.Channel) {
+				continue
+			}
+			out = append(out, m)
+		}
+	}
+
+	switch f.Sort {
+	case protocol.SortNewest:
+		slices.Reverse(out)
+	case protocol.SortRelevance:
+		now := time.Now()
+		score := make(map[*protocol.StoredMessage]float64, len(out))
+		for _, m := range out {
+			score[m] = f.Query.Score(m.Content, now.Sub(m.Timestamp))
+		}
+		slices.SortStableFunc(out, func(a, b *protocol.StoredMessage) int {
+			if c := cmp.Compare(score[b], score[a]); c != 0 {
+				return c
+			}
+			return b.Timestamp.Compare(a.Timestamp)
+		})
 	}
 	return out
 }
@@ -151,6 +537,9 @@ func (s *Store) Search(query, username string, from, to *time.Time) []*protocol.
 // ---------------------------------------------------------------------------
 
 func (s *Store) load() error {
+	if err := s.recoverTx(); err != nil {
+		return err
+	}
 	usersPath := filepath.Join(s.dataDir, "users.json")
 	if data, err := os.ReadFile(usersPath); err == nil {
 		var users []*User
@@ -160,28 +549,82 @@ func (s *Store) load() error {
 		for _, u := range users {
 			s.users[strings.ToLower(u.Username)] = u
 			s.byID[u.ID] = u
+			if ReservedName(u.Username) {
+				logger().Warn("account predates the reserved names; consider chatctl delete-user", "user", u.Username, "user_id", u.ID)
+			}
 		}
 	}
 
-	msgsPath := filepath.Join(s.dataDir, "messages.json")
-	if data, err := os.ReadFile(msgsPath); err == nil {
-		if err := json.Unmarshal(data, &s.messages); err != nil {
-			return fmt.Errorf("store: parse messages.json: %w", err)
+	if err := s.loadMessages(); err != nil {
+		return err
+	}
+	if err := s.loadScheduled(); err != nil {
+		return err
+	}
+	if err := s.loadPolls(); err != nil {
+		return err
+	}
+	if err := s.loadFeeds(); err != nil {
+		return err
+	}
+	if err := s.loadStats(); err != nil {
+		return err
+	}
+	if err := s.loadChannels(); err != nil {
+		return err
+	}
+	if err := s.loadRevisions(); err != nil {
+		return err
+	}
+	if err := s.loadPrefs(); err != nil {
+		return err
+	}
+	if err := s.loadReads(); err != nil {
+		return err
+	}
+	if err := s.loadRateLimits(); err != nil {
+		return err
+	}
+	return s.loadFiles()
+}
+
+// dedupeMessages drops messages whose ID was already seen, keeping the first
+// copy.  Duplicates can be left behind by crash replays or imports; they are
+// removed from memory only, so the next save writes a clean archive.
+func (s *Store) dedupeMessages() {
+	seen := make(map[string]*protocol.StoredMessage, len(s.messages))
+	kept := s.messages[:0]
+	dups, conflicts := 0, 0
+	for _, m := range s.messages {
+		if first, ok := seen[m.ID]; ok {
+			dups++
+			if first.Content != m.Content || first.UserID != m.UserID {
+				conflicts++
+			}
+			continue
 		}
+		seen[m.ID] = m
+		kept = append(kept, m)
+	}
+	clear(s.messages[len(kept):])
+	s.messages = kept
+	if dups > 0 {
+		logger().Warn("dropped duplicate messages from the archive", "dropped", dups, "conflicting", conflicts, "kept", len(kept))
 	}
-	return nil
 }
 
 func (s *Store) saveUsersLocked() error {
+	defer s.timed(OpSaveUsers, time.Now(), func() string { return fmt.Sprintf("%d account(s)", len(s.users)) })
+	s.replicateLocked(true)
+	return writeJSON(filepath.Join(s.dataDir, "users.json"), s.userListLocked())
+}
+
+func (s *Store) userListLocked() []*User {
 	users := make([]*User, 0, len(s.users))
 	for _, u := range s.users {
 		users = append(users, u)
 	}
-	return writeJSON(filepath.Join(s.dataDir, "users.json"), users)
-}
-
-func (s *Store) saveMessagesLocked() error {
-	return writeJSON(filepath.Join(s.dataDir, "messages.json"), s.messages)
+	return users
 }
 
 func writeJSON(path string, v any) error {
@@ -192,11 +635,6 @@ func writeJSON(path string, v any) error {
 	return os.WriteFile(path, data, 0o644)
 }
 
-func hashPassword(pw string) string {
-	h := sha256.Sum256([]byte(pw))
-	return hex.EncodeToString(h[:])
-}
-
 func generateID() string {
 	// nano-timestamp + random hex nibbles — sufficient for a local demo.
 	return fmt.Sprintf("%d-%04x", time.Now().UnixNano(), rand.Intn(0xFFFF))
@@ -0,0 +1,54 @@
+package store
+
+import "testing"
+
+// TestUpsertExternalUser checks that a directory login gets a shadow
+// account of its own and never takes over an account it does not own.
+func TestUpsertExternalUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		role     string
+		wantErr  bool
+		wantRole string
+	}{
+		{"new directory user", "carol", RoleMember, false, RoleMember},
+		{"role refreshed", "dave", RoleAdmin, false, RoleAdmin},
+		{"local account", "alice", RoleMember, true, ""},
+		{"local account, other case", "ALICE", RoleAdmin, true, ""},
+		{"reserved name", "System", RoleMember, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			alice, err := s.RegisterUser("alice", "alice-password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dave, err := s.UpsertExternalUser("dave", RoleMember, "ldap")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err := s.UpsertExternalUser(tt.username, tt.role, "ldap")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := s.GetUserByID(alice.ID); got.Source != "" || got.PasswordHash != alice.PasswordHash || got.Role != RoleMember {
+				t.Errorf("local account changed to %+v", got)
+			}
+			if err != nil {
+				return
+			}
+			if u.Source != "ldap" || u.Role != tt.wantRole {
+				t.Errorf("got source %q role %q, want ldap %q", u.Source, u.Role, tt.wantRole)
+			}
+			if tt.username == "dave" && u.ID != dave.ID {
+				t.Errorf("second login got account %s, want %s", u.ID, dave.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"chat/internal/protocol"
+)
+
+// ---------------------------------------------------------------------------
+// Transactions
+// ---------------------------------------------------------------------------
+//
+// Update runs a compound operation — register an account and post its
+// welcome message, delete an account and purge what it wrote — as one unit
+// of work: either every change is applied and saved, or none is.
+//
+// The Store is locked for the whole transaction.  Changes are made in memory
+// and recorded with an undo function each; if the function fails, the undo
+// functions run newest first.  On success every file the transaction touched
+// is written next to its target as <name>.tx, then the commit record tx.json
+// names them, then they are renamed into place.  A crash before tx.json
+// exists leaves the old files untouched (the .tx leftovers are deleted on
+// the next start); a crash after it is rolled forward on the next start.
+//
+// The JSON files are the Store's only backend; the sqlite and postgres names
+// reserved by chatctl have none to implement.
+
+const txRecord = "tx.json"
+
+// Tx is a unit of work inside Store.Update.  Its methods change the Store
+// only for the rest of the transaction; nothing is saved until Update's
+// function returns nil.  A Tx must not be used after Update returns.
+type Tx struct {
+	s     *Store
+	undo  []func()
+	dirty map[string]bool // files to rewrite on commit
+}
+
+// Update runs fn as a transaction.  When fn returns an error, or saving
+// fails, every change fn made is rolled back and the error is returned.
+func (s *Store) Update(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &Tx{s: s, dirty: make(map[string]bool)}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// GetUser returns a copy of the account with the given username
+// (case-insensitive), or nil, as the transaction sees it.
+func (tx *Tx) GetUser(username string) *User {
+	return copyUser(tx.s.users[strings.ToLower(username)])
+}
+
+// RegisterUser creates a new user account, like Store.RegisterUser.  The
+// password is hashed with the store locked, so this is for tools rather
+// than the server's request path.
+func (tx *Tx) RegisterUser(username, password string) (*User, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	u, undo, err := tx.s.registerUserLocked(username, hash)
+	if err != nil {
+		return nil, err
+	}
+	tx.changed(undo, "users.json")
+	return copyUser(u), nil
+}
+
+// SaveMessage appends msg to the archive, like Store.SaveMessage.
+func (tx *Tx) SaveMessage(msg *protocol.StoredMessage) {
+	s := tx.s
+	n := len(s.messages)
+	s.messages = append(s.messages, msg)
+	// Clipped, so the next append cannot overwrite msg under a snapshot
+	// that still holds it.
+	tx.changed(func() { s.messages = slices.Clip(s.messages[:n]) }, archiveTx)
+}
+
+// DeleteUser removes an account and its scheduled messages, like
+// Store.DeleteUser.
+func (tx *Tx) DeleteUser(id string) error {
+	unscheduled, undo, err := tx.s.deleteUserLocked(id)
+	if err != nil {
+		return err
+	}
+	tx.changed(undo, "users.json")
+	if unscheduled {
+		tx.dirty["scheduled.json"] = true
+	}
+	return nil
+}
+
+// PurgeMessages removes every message the user with the given ID posted or
+// received as a direct message, with their revisions, and returns how many
+// were removed.
+func (tx *Tx) PurgeMessages(userID string) int {
+	match := func(m *protocol.StoredMessage) bool {
+		a, b, dm := protocol.DirectMembers(m.Channel)
+		return m.UserID == userID || dm && (a == userID || b == userID)
+	}
+	tx.dropRevisions(match)
+	return tx.DeleteMessages(match)
+}
+
+// DeleteMessages removes every message match selects and returns how many
+// were removed.
+func (tx *Tx) DeleteMessages(match func(*protocol.StoredMessage) bool) int {
+	s := tx.s
+	old := s.messages
+	kept := make([]*protocol.StoredMessage, 0, len(old))
+	for _, m := range old {
+		if !match(m) {
+			kept = append(kept, m)
+		}
+	}
+	n := len(old) - len(kept)
+	if n > 0 {
+		s.messages = kept
+		tx.changed(func() { s.messages = old }, archiveTx)
+	}
+	return n
+}
+
+func (tx *Tx) changed(undo func(), file string) {
+	tx.undo = append(tx.undo, undo)
+	tx.dirty[file] = true
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+// commit writes the dirty files as described at the top of this file.  A
+// changed message archive is written as the day files that changed, a
+// day that is gone as an empty file, and the index (see partitions.go).
+func (tx *Tx) commit() error {
+	s := tx.s
+	if len(tx.dirty) == 0 {
+		return nil
+	}
+	days := s.days
+	files := make(map[string][]byte, len(tx.dirty))
+	for name := range tx.dirty {
+		if name != archiveTx {
+			data, err := json.MarshalIndent(s.txStateLocked(name), "", "  ")
+			if err != nil {
+				return fmt.Errorf("store: commit: %w", err)
+			}
+			files[name] = data
+			continue
+		}
+		s.layoutLocked()
+		rewrite, _, err := s.dayChangesLocked(false)
+		if err == nil {
+			files[partitionIndex], err = s.indexLocked()
+		}
+		if err != nil {
+			s.days = days
+			return fmt.Errorf("store: commit: %w", err)
+		}
+		maps.Copy(files, rewrite)
+	}
+	names := slices.Sorted(maps.Keys(files))
+
+	written := names[:0:0]
+	abort := func(err error) error {
+		for _, name := range written {
+			os.Remove(filepath.Join(s.dataDir, name+".tx"))
+		}
+		s.days = days
+		return fmt.Errorf("store: commit: %w", err)
+	}
+	for _, name := range names {
+		if err := writeSynced(filepath.Join(s.dataDir, name+".tx"), files[name]); err != nil {
+			return abort(err)
+		}
+		written = append(written, name)
+	}
+	record, _ := json.Marshal(names)
+	tmp := filepath.Join(s.dataDir, txRecord+".tmp")
+	if err := writeSynced(tmp, record); err != nil {
+		return abort(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dataDir, txRecord)); err != nil {
+		os.Remove(tmp)
+		return abort(err)
+	}
+
+	// Committed: from here on a failure is finished by the next start,
+	// so the in-memory state stays as it is.
+	tx.undo = nil
+	s.replicateLocked(tx.dirty["users.json"])
+	if err := s.finishTx(names); err != nil {
+		logger().Error("transaction committed but not yet applied, it finishes on restart", "err", err)
+		return nil
+	}
+	if tx.dirty[archiveTx] {
+		s.disk = s.days
+		for _, name := range names {
+			if data, ok := files[name]; ok && len(data) == 0 {
+				os.Remove(filepath.Join(s.dataDir, name))
+			}
+		}
+	}
+	return nil
+}
+
+// txStateLocked is what the file name should hold now.
+func (s *Store) txStateLocked(name string) any {
+	switch name {
+	case "users.json":
+		return s.userListLocked()
+	case "scheduled.json":
+		return s.scheduled
+	case "revisions.json":
+		return s.revisions
+	}
+	panic("store: no transactional state for " + name)
+}
+
+// finishTx moves the committed files into place and removes the record.
+func (s *Store) finishTx(names []string) error {
+	for _, name := range names {
+		err := os.Rename(filepath.Join(s.dataDir, name+".tx"), filepath.Join(s.dataDir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) { // already moved
+			return err
+		}
+	}
+	return os.Remove(filepath.Join(s.dataDir, txRecord))
+}
+
+// recoverTx completes a transaction that was committed but not applied
+// when the process stopped, and discards the files of one that was not
+// committed.
+func (s *Store) recoverTx() error {
+	if data, err := os.ReadFile(filepath.Join(s.dataDir, txRecord)); err == nil {
+		var names []string
+		if err := json.Unmarshal(data, &names); err != nil {
+			return fmt.Errorf("store: parse %s: %w", txRecord, err)
+		}
+		if err := s.finishTx(names); err != nil {
+			return fmt.Errorf("store: finish interrupted transaction: %w", err)
+		}
+		logger().Warn("finished an interrupted transaction", "files", names)
+	}
+	os.Remove(filepath.Join(s.dataDir, txRecord+".tmp"))
+	leftovers, _ := filepath.Glob(filepath.Join(s.dataDir, "*.tx"))
+	for _, path := range leftovers {
+		os.Remove(path)
+	}
+	if len(leftovers) > 0 {
+		logger().Warn("discarded the files of an uncommitted transaction", "files", len(leftovers))
+	}
+	return nil
+}
+
+// writeSynced writes data to path and flushes it to disk before returning,
+// so a later rename cannot expose a half-written file.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"chat/internal/protocol"
+)
+
+// TestUpdateRollback runs transactions against a Store holding alice and
+// one message of hers, and checks what the Store holds afterwards, both
+// in memory and once reopened from disk.
+func TestUpdateRollback(t *testing.T) {
+	errAbort := errors.New("abort")
+	post := func(id, userID string) *protocol.StoredMessage {
+		return &protocol.StoredMessage{ID: id, UserID: userID, Content: id, Timestamp: time.Now().UTC()}
+	}
+	tests := []struct {
+		name      string
+		fn        func(tx *Tx, alice *User) error
+		wantErr   error
+		wantPanic bool
+		wantUsers []string
+		wantMsgs  []string
+	}{
+		{
+			name: "commit",
+			fn: func(tx *Tx, alice *User) error {
+				bob, err := tx.RegisterUser("bob", "bob-password")
+				if err != nil {
+					return err
+				}
+				tx.SaveMessage(post("m2", bob.ID))
+				return nil
+			},
+			wantUsers: []string{"alice", "bob"},
+			wantMsgs:  []string{"m1", "m2"},
+		},
+		{
+			name: "error after register and post",
+			fn: func(tx *Tx, alice *User) error {
+				bob, err := tx.RegisterUser("bob", "bob-password")
+				if err != nil {
+					return err
+				}
+				tx.SaveMessage(post("m2", bob.ID))
+				return errAbort
+			},
+			wantErr:   errAbort,
+			wantUsers: []string{"alice"},
+			wantMsgs:  []string{"m1"},
+		},
+		{
+			name: "error after delete and purge",
+			fn: func(tx *Tx, alice *User) error {
+				if err := tx.DeleteUser(alice.ID); err != nil {
+					return err
+				}
+				if n := tx.PurgeMessages(alice.ID); n != 1 {
+					t.Errorf("purged %d messages, want 1", n)
+				}
+				if tx.GetUser("alice") != nil {
+					t.Error("transaction still sees the deleted account")
+				}
+				return errAbort
+			},
+			wantErr:   errAbort,
+			wantUsers: []string{"alice"},
+			wantMsgs:  []string{"m1"},
+		},
+		{
+			name: "undo runs newest first",
+			fn: func(tx *Tx, alice *User) error {
+				tx.SaveMessage(post("m2", alice.ID))
+				tx.DeleteMessages(func(m *protocol.StoredMessage) bool { return m.ID == "m1" })
+				tx.SaveMessage(post("m3", alice.ID))
+				return errAbort
+			},
+			wantErr:   errAbort,
+			wantUsers: []string{"alice"},
+			wantMsgs:  []string{"m1"},
+		},
+		{
+			name: "panic",
+			fn: func(tx *Tx, alice *User) error {
+				tx.SaveMessage(post("m2", alice.ID))
+				if _, err := tx.RegisterUser("bob", "bob-password"); err != nil {
+					return err
+				}
+				panic("boom")
+			},
+			wantPanic: true,
+			wantUsers: []string{"alice"},
+			wantMsgs:  []string{"m1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := New(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			alice, err := s.RegisterUser("alice", "alice-password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Update(func(tx *Tx) error {
+				tx.SaveMessage(post("m1", alice.ID))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			err = func() (err error) {
+				defer func() {
+					if p := recover(); p != nil {
+						if !tt.wantPanic {
+							panic(p)
+						}
+						err = errors.New("panicked")
+					}
+				}()
+				err = s.Update(func(tx *Tx) error { return tt.fn(tx, alice) })
+				if tt.wantPanic {
+					t.Error("Update swallowed the panic")
+				}
+				return err
+			}()
+			if !tt.wantPanic && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update err = %v, want %v", err, tt.wantErr)
+			}
+
+			check := func(when string, s *Store) {
+				var users []string
+				for _, u := range s.Users() {
+					users = append(users, u.Username)
+				}
+				slices.Sort(users)
+				var msgs []string
+				for _, m := range s.Messages() {
+					msgs = append(msgs, m.ID)
+				}
+				if !slices.Equal(users, tt.wantUsers) {
+					t.Errorf("%s: users = %v, want %v", when, users, tt.wantUsers)
+				}
+				if !slices.Equal(msgs, tt.wantMsgs) {
+					t.Errorf("%s: messages = %v, want %v", when, msgs, tt.wantMsgs)
+				}
+			}
+			check("in memory", s)
+			if err := s.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := New(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check("reopened", reopened)
+		})
+	}
+}